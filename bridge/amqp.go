@@ -0,0 +1,76 @@
+package bridge
+
+import (
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/util"
+	"github.com/streadway/amqp"
+)
+
+type amqpConsumer struct {
+	name  string
+	url   string
+	queue string
+}
+
+// Run consumes from the queue, reconnecting as necessary.
+// Messages which can't be converted or pushed are rejected
+// so the broker can dead-letter them, if so configured.
+func (ac *amqpConsumer) Run(stopper chan bool, push func([]byte) error) {
+	for {
+		err := ac.consume(stopper, push)
+		if err == nil {
+			return
+		}
+		util.Warnf("Bridge %s: %v", ac.name, err)
+
+		select {
+		case <-stopper:
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func (ac *amqpConsumer) consume(stopper chan bool, push func([]byte) error) error {
+	conn, err := amqp.DialTLS(ac.url, client.RestrictTLS(nil))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	err = ch.Qos(100, 0, false)
+	if err != nil {
+		return err
+	}
+	deliveries, err := ch.Consume(ac.queue, "faktory-"+ac.name, false, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+	closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+
+	for {
+		select {
+		case <-stopper:
+			return nil
+		case err := <-closed:
+			return err
+		case d, ok := <-deliveries:
+			if !ok {
+				return amqp.ErrClosed
+			}
+			err := push(d.Body)
+			if err != nil {
+				util.Warnf("Bridge %s: rejecting message: %v", ac.name, err)
+				d.Nack(false, false)
+				continue
+			}
+			d.Ack(false)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package bridge
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/util"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+type mqttConsumer struct {
+	name    string
+	topic   string
+	options *mqtt.ClientOptions
+}
+
+func newMQTTConsumer(name string, uri *url.URL, topic string) *mqttConsumer {
+	broker := *uri
+	broker.User = nil
+	if broker.Scheme == "mqtt" {
+		broker.Scheme = "tcp"
+	}
+
+	hs, _ := os.Hostname()
+	opts := mqtt.NewClientOptions().
+		AddBroker(broker.String()).
+		SetClientID(fmt.Sprintf("faktory-%s-%s", name, hs)).
+		SetCleanSession(false).
+		SetAutoReconnect(true).
+		SetTLSConfig(client.RestrictTLS(nil))
+	if uri.User != nil {
+		pwd, _ := uri.User.Password()
+		opts.SetUsername(uri.User.Username())
+		opts.SetPassword(pwd)
+	}
+
+	return &mqttConsumer{name: name, topic: topic, options: opts}
+}
+
+// Run subscribes with QoS 1 so the broker will redeliver
+// messages received while the bridge was disconnected.
+func (mc *mqttConsumer) Run(stopper chan bool, push func([]byte) error) {
+	mc.options.SetOnConnectHandler(func(c mqtt.Client) {
+		token := c.Subscribe(mc.topic, 1, func(_ mqtt.Client, msg mqtt.Message) {
+			err := push(msg.Payload())
+			if err != nil {
+				util.Warnf("Bridge %s: dropping message from %s: %v", mc.name, msg.Topic(), err)
+			}
+		})
+		if token.Wait() && token.Error() != nil {
+			util.Warnf("Bridge %s: %v", mc.name, token.Error())
+		}
+	})
+
+	c := mqtt.NewClient(mc.options)
+	for {
+		// paho only reconnects automatically after
+		// the first connection succeeds
+		token := c.Connect()
+		if token.Wait() && token.Error() == nil {
+			break
+		}
+		util.Warnf("Bridge %s: %v", mc.name, token.Error())
+
+		select {
+		case <-stopper:
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+
+	<-stopper
+	c.Disconnect(250)
+}
@@ -0,0 +1,125 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/contribsys/faktory/server"
+	"github.com/contribsys/faktory/storage"
+	"github.com/go-redis/redis"
+)
+
+// Backup backs up the Redis of the server running with the same
+// storage directory, see server/backup.go.  It returns the exit
+// code for the process.
+func Backup(opts CliOptions) int {
+	if opts.StorageEngine != "redis" {
+		log.Printf("Unable to back up the %s storage engine, it does not persist jobs", opts.StorageEngine)
+		return 1
+	}
+	cfg, err := readConfig(opts.ConfigDirectory, opts.Environment)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	so := &server.ServerOptions{GlobalConfig: cfg}
+	keys, err := so.BackupKeys()
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	rclient := redis.NewClient(&redis.Options{
+		Network: "unix",
+		Addr:    fmt.Sprintf("%s/redis.sock", opts.StorageDirectory),
+	})
+	defer rclient.Close()
+
+	path, err := storage.Backup(rclient, storage.BackupDirectory(opts.StorageDirectory), so.Int("backup", "retention", 7), keys)
+	if err != nil {
+		log.Printf("Unable to back up %s, is Faktory running? %v", opts.StorageDirectory, err)
+		return 1
+	}
+	log.Printf("Backed up to %s", path)
+	return 0
+}
+
+// Restore replaces the Redis snapshot in the storage directory with
+// a backup, "latest" or a name or path as listed by the Debug page,
+// decrypted with [backup.keys], and boots Redis on it to check it
+// loads.  Faktory must be stopped.
+// It returns the exit code for the process.
+func Restore(opts CliOptions, name string) int {
+	if opts.StorageEngine != "redis" {
+		log.Printf("Unable to restore the %s storage engine, it does not persist jobs", opts.StorageEngine)
+		return 1
+	}
+	cfg, err := readConfig(opts.ConfigDirectory, opts.Environment)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	keys, err := (&server.ServerOptions{GlobalConfig: cfg}).BackupKeys()
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	sock := fmt.Sprintf("%s/redis.sock", opts.StorageDirectory)
+	rclient := redis.NewClient(&redis.Options{Network: "unix", Addr: sock})
+	running := rclient.Ping().Err() == nil
+	rclient.Close()
+	if running {
+		log.Printf("Faktory is running with %s, stop it before restoring", opts.StorageDirectory)
+		return 1
+	}
+	unlock, err := storage.LockDirectory(opts.StorageDirectory)
+	if err != nil {
+		log.Printf("%v, stop it before restoring", err)
+		return 1
+	}
+	defer unlock()
+
+	path, err := storage.FindBackup(opts.StorageDirectory, name)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	previous, err := storage.Restore(opts.StorageDirectory, path, keys)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	size, err := bootRestored(opts.StorageDirectory, sock)
+	if err != nil {
+		log.Printf("Unable to boot Redis on %s: %v", path, err)
+		if previous != "" {
+			_, err = storage.Restore(opts.StorageDirectory, previous, keys)
+			if err != nil {
+				log.Printf("Unable to put back the previous snapshot %s: %v", previous, err)
+				return 1
+			}
+			log.Printf("Put back the previous snapshot")
+		}
+		return 1
+	}
+	log.Printf("Restored %s, %d keys", path, size)
+	if previous != "" {
+		log.Printf("The previous snapshot was kept as %s", previous)
+	}
+	return 0
+}
+
+func bootRestored(dir string, sock string) (int64, error) {
+	_, err := storage.BootRedis(dir, sock)
+	// stop Redis even if it failed so it isn't restarted
+	defer storage.StopRedis(sock)
+	if err != nil {
+		return 0, err
+	}
+
+	rclient := redis.NewClient(&redis.Options{Network: "unix", Addr: sock})
+	defer rclient.Close()
+	return rclient.DbSize().Result()
+}
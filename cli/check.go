@@ -0,0 +1,211 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/contribsys/faktory/server"
+)
+
+// configSchema lists the keys of each config section and the
+// TOML type of their values.  "*" matches any key, sections
+// mapped to nil are validated by their subsystem when it boots.
+var configSchema = map[string]map[string]string{
+	"faktory": {"binding": "string", "admin_binding": "string", "password": "string",
+		"fips": "bool", "max_job_size": "integer", "tls_cert": "string", "tls_key": "string",
+		"socket_mode": "string", "shutdown_timeout": "integer"},
+	"web": {"binding": "string", "password": "string", "users": "table", "groups": "table", "approvals": "table"},
+	"auth": {"provider": "string", "url": "string", "dn": "string", "client_id": "string",
+		"client_secret": "string", "introspection_url": "string", "audience": "string", "tokens": "array"},
+	"tls":          {"cert": "string", "key": "string", "client_ca": "string"},
+	"spiffe":       {"trust_domain": "string", "ids": "table"},
+	"tcp":          {"keepalive": "integer", "nodelay": "bool", "read_buffer": "integer", "write_buffer": "integer", "handlers": "integer"},
+	"storage":      {"retries": "integer", "breaker_threshold": "integer", "breaker_cooldown": "integer"},
+	"fetch":        {"strategy": "string", "timeout": "integer", "max_timeout": "integer", "max_prefetch": "integer", "poll_interval": "integer"},
+	"deadlines":    {"*": "integer"},
+	"reservations": {"warn_at": "integer"},
+	"anomalies":    {"baseline": "integer", "minimum": "integer", "threshold": "float"},
+	"metrics":      {"retention": "integer", "labels": "array", "label_values": "integer", "usage_days": "integer"},
+	"sampling":     {"rate": "float", "hours": "integer"},
+	"backup":       {"retention": "integer", "key": "string", "keys": "table"},
+	"tracking":     {"ttl": "integer"},
+	"results":      {"ttl": "integer", "max_size": "integer"},
+	"lineage":      {"ttl": "integer"},
+	"debounce":     {"max_window": "integer"},
+	"mirror":       {"url": "string", "queues": "array", "buffer": "integer"},
+	"routing":      {"region": "string", "links": "table", "queues": "table", "shards": "array"},
+	"offload":      {"threshold": "integer", "url": "string", "token": "string", "resolve": "bool"},
+	"encryption":   {"key": "string", "keys": "table", "queues": "array"},
+	"shedding": {"memory_mb": "integer", "large_job": "integer", "deep_queue": "integer",
+		"tiers": "table", "queues": "table"},
+	"webhooks":   nil,
+	"alerts":     nil,
+	"transforms": nil,
+	"batch":      {"ttl": "integer"},
+	"changes":    {"size": "integer"},
+	"duplicates": {"window": "integer", "minimum": "integer"},
+	"pruning":    {"days": "integer", "keep": "array"},
+	"unique":     {"duplicates": "string"},
+	"bridge":     nil,
+}
+
+var bindings = [][2]string{
+	{"faktory", "binding"},
+	{"faktory", "admin_binding"},
+	{"web", "binding"},
+}
+
+// Check validates the configuration without booting the server and
+// logs a report, returning the exit code for the process.
+func Check(opts CliOptions) int {
+	problems := checkConfig(opts.ConfigDirectory, opts.Environment)
+	for _, problem := range problems {
+		log.Println(problem)
+	}
+	if len(problems) > 0 {
+		log.Printf("%d problem(s) found in %s/conf.d", len(problems), opts.ConfigDirectory)
+		return 1
+	}
+	log.Printf("Configuration in %s/conf.d is valid", opts.ConfigDirectory)
+	return 0
+}
+
+func checkConfig(cdir string, env string) []string {
+	problems := []string{}
+
+	// parse each file on its own so errors name the file
+	matches, err := filepath.Glob(fmt.Sprintf("%s/conf.d/*.toml", cdir))
+	if err != nil {
+		return append(problems, err.Error())
+	}
+	for _, file := range matches {
+		var hash map[string]interface{}
+		_, err := toml.DecodeFile(file, &hash)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", file, err))
+		}
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+
+	cfg, err := readConfig(cdir, env)
+	if err != nil {
+		return append(problems, err.Error())
+	}
+
+	sections := make([]string, 0, len(cfg))
+	for name := range cfg {
+		sections = append(sections, name)
+	}
+	sort.Strings(sections)
+	for _, name := range sections {
+		schema, ok := configSchema[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("Unknown section [%s]", name))
+			continue
+		}
+		section, ok := cfg[name].(map[string]interface{})
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s must be a section, not of type %s", name, tomlType(cfg[name])))
+			continue
+		}
+		if schema == nil {
+			continue
+		}
+
+		keys := make([]string, 0, len(section))
+		for key := range section {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			expected, ok := schema[key]
+			if !ok {
+				expected, ok = schema["*"]
+			}
+			if !ok {
+				problems = append(problems, fmt.Sprintf("Unknown key %s.%s", name, key))
+				continue
+			}
+			actual := tomlType(section[key])
+			if actual != expected && !(expected == "float" && actual == "integer") {
+				problems = append(problems, fmt.Sprintf("%s.%s must be of type %s, not %s", name, key, expected, actual))
+			}
+		}
+	}
+
+	for _, b := range bindings {
+		val := stringConfig(cfg, b[0], b[1], "")
+		if val == "" {
+			continue
+		}
+		vals := []string{val}
+		if b == bindings[0] {
+			// the command port may listen on several addresses
+			vals = (&server.ServerOptions{Binding: val}).Bindings()
+		}
+		for _, val := range vals {
+			if strings.HasPrefix(val, "unix:") && b == bindings[0] {
+				continue
+			}
+			err := checkBinding(val)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("Invalid %s.%s %q: %v", b[0], b[1], val, err))
+			}
+		}
+	}
+
+	if mode := stringConfig(cfg, "faktory", "socket_mode", ""); mode != "" {
+		if _, err := strconv.ParseUint(mode, 8, 32); err != nil {
+			problems = append(problems, fmt.Sprintf("Invalid faktory.socket_mode %q, expected octal permissions like \"0660\"", mode))
+		}
+	}
+
+	_, err = fetchPassword(cfg, env)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	return problems
+}
+
+func checkBinding(binding string) error {
+	_, port, err := net.SplitHostPort(binding)
+	if err != nil {
+		return err
+	}
+	num, err := strconv.Atoi(port)
+	if err != nil || num < 0 || num > 65535 {
+		return fmt.Errorf("invalid port %s", port)
+	}
+	return nil
+}
+
+// tomlType names the type of a value decoded from TOML
+func tomlType(val interface{}) string {
+	switch val.(type) {
+	case string:
+		return "string"
+	case int64:
+		return "integer"
+	case float64:
+		return "float"
+	case bool:
+		return "bool"
+	case time.Time:
+		return "datetime"
+	case map[string]interface{}:
+		return "table"
+	case []interface{}, []map[string]interface{}:
+		return "array"
+	default:
+		return fmt.Sprintf("%T", val)
+	}
+}
@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "faktory-check")
+	assert.NoError(t, err)
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "conf.d"), os.FileMode(0755)))
+	for name, content := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, "conf.d", name), []byte(content), os.FileMode(0644))
+		assert.NoError(t, err)
+	}
+	return dir
+}
+
+func TestCheckConfig(t *testing.T) {
+	dir := writeConfig(t, map[string]string{
+		"faktory.toml": "[faktory]\nbinding = \"127.0.0.1:7419, unix:/var/run/faktory.sock\"\npassword = \"secret\"\n",
+		"tuning.toml":  "[deadlines]\npush = 2\n\n[sampling]\nrate = 1\n\n[webhooks.stripe]\nprovider = \"stripe\"\n",
+	})
+	defer os.RemoveAll(dir)
+	assert.Empty(t, checkConfig(dir, "production"))
+
+	dir = writeConfig(t, map[string]string{
+		"faktory.toml": "[faktory]\nbinding = \"0.0.0.0:74190\"\nmax_job_size = \"1MB\"\nsocket_mode = \"rw\"\nbogus = 1\n\n[fetcher]\ntimeout = 2\n\n[web]\nbinding = \"7420\"\n",
+	})
+	defer os.RemoveAll(dir)
+	assert.Equal(t, []string{
+		"Unknown key faktory.bogus",
+		"faktory.max_job_size must be of type integer, not string",
+		"Unknown section [fetcher]",
+		`Invalid faktory.binding "0.0.0.0:74190": invalid port 74190`,
+		`Invalid web.binding "7420": address 7420: missing port in address`,
+		`Invalid faktory.socket_mode "rw", expected octal permissions like "0660"`,
+		"Faktory requires a password to be set in production mode, see the Security wiki page",
+	}, checkConfig(dir, "production"))
+
+	dir = writeConfig(t, map[string]string{
+		"broken.toml": "[faktory\n",
+	})
+	defer os.RemoveAll(dir)
+	problems := checkConfig(dir, "development")
+	assert.Equal(t, 1, len(problems))
+	assert.Contains(t, problems[0], "broken.toml")
+}
@@ -10,7 +10,6 @@ import (
 	"os/user"
 	"path/filepath"
 	"strings"
-	"syscall"
 
 	"github.com/BurntSushi/toml"
 	"github.com/contribsys/faktory/client"
@@ -25,22 +24,42 @@ type CliOptions struct {
 	Environment      string
 	ConfigDirectory  string
 	LogLevel         string
+	LogFormat        string
 	StorageDirectory string
+	StorageEngine    string
+	TLSCert          string
+	TLSKey           string
+	LogFile          string
+	Simulate         bool
+	PidFile          string
+	Maintenance      bool
 }
 
 func ParseArguments() CliOptions {
-	defaults := CliOptions{"localhost:7419", "localhost:7420", "development", "/etc/faktory", "info", "/var/lib/faktory/db"}
+	defaults := CliOptions{"localhost:7419", "localhost:7420", "development", "/etc/faktory", "info", "text", "/var/lib/faktory/db", "redis", "", "", "", false, "", false}
 
 	flag.Usage = help
 	flag.StringVar(&defaults.WebBinding, "w", "localhost:7420", "WebUI binding")
 	flag.StringVar(&defaults.CmdBinding, "b", "localhost:7419", "Network binding")
 	flag.StringVar(&defaults.LogLevel, "l", "info", "Logging level (error, warn, info, debug)")
+	flag.StringVar(&defaults.LogFormat, "log-format", "text", "Logging format (text, json)")
+	flag.StringVar(&defaults.LogFile, "logfile", "", "Log file, stdout if not set")
 	flag.StringVar(&defaults.Environment, "e", "development", "Environment (development, production)")
+	flag.StringVar(&defaults.StorageEngine, "storage", "redis", "Storage engine (redis, memory)")
+	flag.StringVar(&defaults.TLSCert, "tls-cert", "", "TLS certificate for the command port")
+	flag.StringVar(&defaults.TLSKey, "tls-key", "", "TLS private key for the command port")
+	flag.StringVar(&defaults.PidFile, "pidfile", "", "Write the PID to this file")
+	flag.BoolVar(&defaults.Maintenance, "maintenance", false, "Start in maintenance mode, FETCH is refused")
+	flag.BoolVar(&defaults.Simulate, "simulate", false, "Simulation mode, jobs are kept in memory and have no external effects")
+	flag.Var(&overrides, "o", "Override a config value, e.g. faktory.binding=0.0.0.0:7419")
 
 	// undocumented on purpose, we don't want people changing these if possible
 	flag.StringVar(&defaults.StorageDirectory, "d", "/var/lib/faktory/db", "Storage directory")
 	flag.StringVar(&defaults.ConfigDirectory, "c", "/etc/faktory", "Config directory")
 	versionPtr := flag.Bool("v", false, "Show version")
+	checkPtr := flag.Bool("check", false, "Validate the configuration and exit")
+	var printConfig printFormat
+	flag.Var(&printConfig, "print-config", "Print the merged configuration as TOML, or JSON with -print-config=json, and exit")
 	flag.Parse()
 
 	if *versionPtr {
@@ -66,30 +85,73 @@ func ParseArguments() CliOptions {
 			defaults.ConfigDirectory = filepath.Join(dir, ".faktory")
 		}
 	}
+
+	if *checkPtr {
+		os.Exit(Check(defaults))
+	}
+	if printConfig != "" {
+		os.Exit(Dump(defaults, os.Stdout, string(printConfig)))
+	}
+	if args := flag.Args(); len(args) > 0 {
+		command := strings.Join(args, " ")
+		switch {
+		case command == "config dump":
+			os.Exit(Dump(defaults, os.Stdout, "toml"))
+		case command == "backup":
+			os.Exit(Backup(defaults))
+		case args[0] == "restore" && len(args) == 2:
+			os.Exit(Restore(defaults, args[1]))
+		case args[0] == "cli":
+			os.Exit(Repl(args[1:], os.Stdin, os.Stdout))
+		case args[0] == "template":
+			os.Exit(Template(args[1:], os.Stdout))
+		case args[0] == "seed":
+			os.Exit(Seed(defaults, args[1:], os.Stdin))
+		default:
+			log.Printf("Unknown command: %s", command)
+			help()
+			os.Exit(1)
+		}
+	}
 	return defaults
 }
 
 func help() {
-	log.Println("-b [binding]\tNetwork binding (use :7419 to listen on all interfaces), default: localhost:7419")
+	log.Println("-b [binding]\tNetwork binding (use :7419 to listen on all interfaces, unix:/path for a Unix socket, separate several with commas), default: localhost:7419")
 	log.Println("-w [binding]\tWeb UI binding (use :7420 to listen on all interfaces), default: localhost:7420")
 	log.Println("-e [env]\tSet environment (development, production), default: development")
 	log.Println("-l [level]\tSet logging level (warn, info, debug, verbose), default: info")
+	log.Println("-log-format [format]\tSet logging format (text, json), default: text. json writes a JSON object per line")
+	log.Println("-logfile [file]\tAppend the log to the file rather than stdout, SIGUSR2 reopens it for rotation")
+	log.Println("-storage [engine]\tStorage engine (redis, memory), default: redis. memory does not persist jobs")
+	log.Println("-tls-cert [file]\tTLS certificate, clients connect with tcp+tls://")
+	log.Println("-tls-key [file]\tTLS private key for the certificate")
+	log.Println("-pidfile [file]\tWrite the PID to the file, removed on shutdown")
+	log.Println("-maintenance\tStart in maintenance mode: PUSH is accepted but FETCH fails until MAINTENANCE OFF")
+	log.Println("-simulate\tSimulation mode for staging and training: jobs are kept in memory, the mirror, routing links, offloading and the bridge are disabled")
+	log.Println("-o [key=value]\tOverride a config value, e.g. -o faktory.binding=0.0.0.0:7419, may be repeated")
+	log.Println("-check\t\tValidate the configuration in conf.d and exit, non-zero if invalid")
+	log.Println("-print-config\tPrint the merged configuration with secrets redacted and exit, -print-config=json for JSON")
+	log.Println("config dump\tPrint the merged configuration as TOML with secrets redacted")
+	log.Println("backup\t\tBack up the running server's Redis to backups/ in the storage directory")
+	log.Println("cli [-x command]\tInteractive client for the command protocol, connects to FAKTORY_URL")
+	log.Println("template list|push [name] [param=value...]\tList or push the job templates saved on the server at FAKTORY_URL")
+	log.Println("seed -f [file]\tPush the jobs in a file of JSON lines, - for stdin, to FAKTORY_URL or the stopped storage directory")
+	log.Println("restore [backup]\tRestore a backup, latest or a file in backups/, while Faktory is stopped")
 	log.Println("-v\t\tShow version and license information")
 	log.Println("-h\t\tThis help screen")
 }
 
-var (
-	Term os.Signal = syscall.SIGTERM
-	Hup  os.Signal = syscall.SIGHUP
-
-	SignalHandlers = map[os.Signal]func(*server.Server){
-		Term:         exit,
-		os.Interrupt: exit,
-		Hup:          reload,
+// HandleSignals dispatches the process' signals to SignalHandlers,
+// see signals_unix.go and signals_windows.go.  When Windows started
+// Faktory as a service, the service control requests are handled
+// instead.
+func HandleSignals(s *server.Server) {
+	if isService() {
+		runService(s)
+		return
 	}
-)
 
-func HandleSignals(s *server.Server) {
 	signals := make(chan os.Signal, 1)
 	for k := range SignalHandlers {
 		signal.Notify(signals, k)
@@ -112,29 +174,84 @@ func reload(s *server.Server) {
 		return
 	}
 
+	// the bindings are reopened if they changed, the flags
+	// take precedence as they do at boot
+	binding := "localhost:7419"
+	if f := flag.Lookup("b"); f != nil {
+		binding = f.Value.String()
+	}
+	s.Options.Binding = cmdBinding(binding, globalConfig)
+	s.Options.AdminBinding = stringConfig(globalConfig, "faktory", "admin_binding", "")
 	s.Options.GlobalConfig = globalConfig
 	s.Reload()
 }
 
+// toggleDebug switches debug logging on and off so a misbehaving
+// process can be inspected without restarting it:
+//   kill -USR1 <pid>
+func toggleDebug(s *server.Server) {
+	util.Warnf("Log level is now %s", util.ToggleDebug())
+}
+
+// reopenLog reopens the -logfile after logrotate has moved it
+func reopenLog(s *server.Server) {
+	err := util.ReopenLogFile()
+	if err != nil {
+		util.Warnf("Unable to reopen log file: %v", err)
+	}
+}
+
 func exit(s *server.Server) {
 	util.Infof("%s shutting down", client.Name)
 
-	close(s.Stopper())
+	s.Shutdown()
 }
 
 func BuildServer(opts CliOptions) (*server.Server, func(), error) {
-	globalConfig, err := readConfig(opts.ConfigDirectory, opts.Environment)
+	// each stage is logged and reported at the Web UI's /-/ready
+	startup := server.NewStartup(server.StartupConfig, server.StartupStorage, server.StartupListeners)
+
+	startup.Begin(server.StartupConfig)
+	globalConfig, pwd, err := loadConfig(opts)
+	startup.Finish(server.StartupConfig, err)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	pwd, err := fetchPassword(globalConfig, opts.Environment)
-	if err != nil {
-		return nil, nil, err
+	if opts.Simulate {
+		util.Warn("Simulation mode, jobs are kept in memory and discarded on exit")
+		opts.StorageEngine = "memory"
+	}
+
+	// the stopper undoes each step, newest first
+	var stoppers []func()
+	stopper := func() {
+		for idx := len(stoppers) - 1; idx >= 0; idx-- {
+			stoppers[idx]()
+		}
+	}
+
+	if opts.StorageEngine == "" || opts.StorageEngine == "redis" {
+		unlock, err := storage.LockDirectory(opts.StorageDirectory)
+		if err != nil {
+			return nil, nil, err
+		}
+		stoppers = append(stoppers, unlock)
+	}
+	if opts.PidFile != "" {
+		remove, err := writePidFile(opts.PidFile)
+		if err != nil {
+			return nil, stopper, err
+		}
+		stoppers = append(stoppers, remove)
 	}
 
-	sock := fmt.Sprintf("%s/redis.sock", opts.StorageDirectory)
-	stopper, err := storage.BootRedis(opts.StorageDirectory, sock)
+	startup.Begin(server.StartupStorage)
+	sock, stopStorage, err := bootStorage(opts)
+	startup.Finish(server.StartupStorage, err)
+	if stopStorage != nil {
+		stoppers = append(stoppers, stopStorage)
+	}
 	if err != nil {
 		return nil, stopper, err
 	}
@@ -142,18 +259,29 @@ func BuildServer(opts CliOptions) (*server.Server, func(), error) {
 	// allow binding config element if no CLI arg spec'd:
 	// [faktory]
 	//   binding = "0.0.0.0:7419"
-	if opts.CmdBinding == "localhost:7419" {
-		opts.CmdBinding = stringConfig(globalConfig, "faktory", "binding", "localhost:7419")
-	}
+	// or a list of addresses:
+	//   binding = "127.0.0.1:7419,10.0.0.5:7419"
+	opts.CmdBinding = cmdBinding(opts.CmdBinding, globalConfig)
+	// serve admin commands like FLUSH on a separate binding:
+	// [faktory]
+	//   admin_binding = "10.0.0.5:7421"
+	// both are reopened on SIGHUP if they change
+	adminBinding := stringConfig(globalConfig, "faktory", "admin_binding", "")
 
 	sopts := &server.ServerOptions{
 		Binding:          opts.CmdBinding,
+		AdminBinding:     adminBinding,
 		StorageDirectory: opts.StorageDirectory,
 		ConfigDirectory:  opts.ConfigDirectory,
 		Environment:      opts.Environment,
 		RedisSock:        sock,
 		GlobalConfig:     globalConfig,
 		Password:         pwd,
+		TLSCert:          opts.TLSCert,
+		TLSKey:           opts.TLSKey,
+		Simulated:        opts.Simulate,
+		Startup:          startup,
+		Maintenance:      opts.Maintenance,
 	}
 
 	// don't log config hash until fetchPassword has had a chance to scrub the password value
@@ -168,6 +296,45 @@ func BuildServer(opts CliOptions) (*server.Server, func(), error) {
 	return s, stopper, nil
 }
 
+// loadConfig reads the configuration and the password it names
+func loadConfig(opts CliOptions) (map[string]interface{}, string, error) {
+	globalConfig, err := readConfig(opts.ConfigDirectory, opts.Environment)
+	if err != nil {
+		return nil, "", err
+	}
+	pwd, err := fetchPassword(globalConfig, opts.Environment)
+	if err != nil {
+		return nil, "", err
+	}
+	return globalConfig, pwd, nil
+}
+
+// bootStorage starts the storage engine, returning the socket
+// it listens on and a func to stop it
+func bootStorage(opts CliOptions) (string, func(), error) {
+	switch opts.StorageEngine {
+	case "", "redis":
+		sock := fmt.Sprintf("%s/redis.sock", opts.StorageDirectory)
+		stop, err := storage.BootRedis(opts.StorageDirectory, sock)
+		return sock, stop, err
+	case "memory":
+		sock := filepath.Join(os.TempDir(), fmt.Sprintf("faktory-memory-%d.sock", os.Getpid()))
+		stop, err := storage.BootMemory(sock)
+		return sock, stop, err
+	default:
+		return "", nil, fmt.Errorf("Unknown storage engine: %s", opts.StorageEngine)
+	}
+}
+
+// cmdBinding returns the -b flag, or [faktory] binding
+// if the flag wasn't given
+func cmdBinding(flagValue string, cfg map[string]interface{}) string {
+	if flagValue == "localhost:7419" {
+		return stringConfig(cfg, "faktory", "binding", "localhost:7419")
+	}
+	return flagValue
+}
+
 func stringConfig(cfg map[string]interface{}, subsys string, elm string, defval string) string {
 	if mapp, ok := cfg[subsys]; ok {
 		if mappp, ok := mapp.(map[string]interface{}); ok {
@@ -187,7 +354,9 @@ func stringConfig(cfg map[string]interface{}, subsys string, elm string, defval
 //
 // They are read in alphabetical order.
 // File contents are shallow merged, a latter file
-// can override a value from an earlier file.
+// can override a value from an earlier file.  FAKTORY_*
+// environment variables override them all, see env.go, and
+// -o flags override those, see overrides.go.
 func readConfig(cdir string, env string) (map[string]interface{}, error) {
 	hash := map[string]interface{}{}
 
@@ -215,6 +384,8 @@ func readConfig(cdir string, env string) (map[string]interface{}, error) {
 		}
 	}
 
+	overlayEnv(hash, os.Environ())
+	overrides.apply(hash)
 	return hash, nil
 }
 
@@ -263,13 +434,20 @@ func fetchPassword(cfg map[string]interface{}, env string) (string, error) {
 		password = strings.TrimSpace(string(data))
 	}
 
-	if env == "production" && !skip() && password == "" {
+	if env == "production" && !skip() && password == "" && !externalAuth(cfg) {
 		return "", fmt.Errorf("Faktory requires a password to be set in production mode, see the Security wiki page")
 	}
 
 	return password, nil
 }
 
+// externalAuth is true if workers authenticate with an [auth]
+// provider other than the password
+func externalAuth(cfg map[string]interface{}) bool {
+	provider := stringConfig(cfg, "auth", "provider", "password")
+	return provider != "password"
+}
+
 func skip() bool {
 	val, ok := os.LookupEnv("FAKTORY_SKIP_PASSWORD")
 	return ok && (val == "1" || val == "true" || val == "yes")
@@ -0,0 +1,155 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+const redacted = "********"
+
+// secretKeys are redacted wherever they appear, e.g. [web.users.alice]
+// password or [webhooks.stripe] secret
+var secretKeys = map[string]bool{
+	"password":      true,
+	"secret":        true,
+	"client_secret": true,
+	"token":         true,
+	"tokens":        true,
+}
+
+// Dump writes the configuration the server would run with as TOML
+// or JSON, conf.d merged with FAKTORY_* environment variables and
+// the command line, with secrets redacted.  It returns the exit code
+// for the process.
+func Dump(opts CliOptions, w io.Writer, format string) int {
+	cfg, err := dumpConfig(opts)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(cfg)
+	default:
+		err = toml.NewEncoder(w).Encode(cfg)
+	}
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	return 0
+}
+
+// printFormat is the value of -print-config, which may be given
+// on its own for TOML or as -print-config=json
+type printFormat string
+
+func (p *printFormat) String() string {
+	return string(*p)
+}
+
+func (p *printFormat) Set(val string) error {
+	switch val {
+	case "true", "toml":
+		*p = "toml"
+	case "json":
+		*p = "json"
+	case "false":
+		*p = ""
+	default:
+		return fmt.Errorf("unknown format %q, expected toml or json", val)
+	}
+	return nil
+}
+
+func (p *printFormat) IsBoolFlag() bool {
+	return true
+}
+
+func dumpConfig(opts CliOptions) (map[string]interface{}, error) {
+	cfg, err := readConfig(opts.ConfigDirectory, opts.Environment)
+	if err != nil {
+		return nil, err
+	}
+
+	// the command line and defaults, as BuildServer and the Web UI apply them
+	binding := opts.CmdBinding
+	if binding == "localhost:7419" {
+		binding = stringConfig(cfg, "faktory", "binding", binding)
+	}
+	setPath(cfg, []string{"faktory", "binding"}, binding)
+	web := opts.WebBinding
+	if web == "localhost:7420" {
+		web = stringConfig(cfg, "web", "binding", web)
+	}
+	setPath(cfg, []string{"web", "binding"}, web)
+	if opts.TLSCert != "" {
+		setPath(cfg, []string{"tls", "cert"}, opts.TLSCert)
+	}
+	if opts.TLSKey != "" {
+		setPath(cfg, []string{"tls", "key"}, opts.TLSKey)
+	}
+	if _, ok := os.LookupEnv("FAKTORY_PASSWORD"); ok {
+		setPath(cfg, []string{"faktory", "password"}, redacted)
+	}
+
+	redact(cfg)
+	for _, name := range []string{"encryption", "backup"} {
+		if enc, ok := cfg[name].(map[string]interface{}); ok {
+			for _, key := range []string{"key", "keys"} {
+				if _, ok := enc[key]; ok {
+					enc[key] = redacted
+				}
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// redact replaces secrets in the section and any nested tables,
+// and passwords in URLs, e.g. [mirror] url
+func redact(section map[string]interface{}) {
+	for key, val := range section {
+		switch v := val.(type) {
+		case map[string]interface{}:
+			redact(v)
+		case []map[string]interface{}:
+			for _, table := range v {
+				redact(table)
+			}
+		case string:
+			if secretKeys[key] {
+				section[key] = redacted
+			} else if strings.Contains(v, "://") {
+				section[key] = redactURL(v)
+			}
+		default:
+			if secretKeys[key] {
+				section[key] = redacted
+			}
+		}
+	}
+}
+
+func redactURL(val string) string {
+	uri, err := url.Parse(val)
+	if err != nil || uri.User == nil {
+		return val
+	}
+	if _, ok := uri.User.Password(); !ok {
+		return val
+	}
+	// UserPassword would escape the asterisks, the user name
+	// is escaped so the first @ ends it
+	uri.User = url.User(uri.User.Username())
+	return strings.Replace(uri.String(), "@", ":"+redacted+"@", 1)
+}
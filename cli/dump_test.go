@@ -0,0 +1,54 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDump(t *testing.T) {
+	dir := writeConfig(t, map[string]string{
+		"faktory.toml": "[faktory]\npassword = \"hunter2\"\n\n[web.users.alice]\npassword = \"alice\"\ngroups = [\"payments\"]\n",
+		"extras.toml": "[mirror]\nurl = \"tcp://:secret@staging.example.com:7419\"\n\n[encryption]\nkey = \"abc\"\n\n" +
+			"[webhooks.stripe]\nprovider = \"stripe\"\nsecret = \"whsec_123\"\n\n[backup.keys]\n2019-06 = \"c2VjcmV0\"\n",
+	})
+	defer os.RemoveAll(dir)
+	os.Setenv("FAKTORY_WEB_BINDING", "0.0.0.0:7420")
+	defer os.Unsetenv("FAKTORY_WEB_BINDING")
+
+	opts := CliOptions{CmdBinding: "localhost:7419", WebBinding: "localhost:7420", ConfigDirectory: dir, Environment: "development"}
+	var buf bytes.Buffer
+	assert.Equal(t, 0, Dump(opts, &buf, "toml"))
+	output := buf.String()
+	for _, secret := range []string{"hunter2", "alice\"", "secret@", "abc", "whsec_123", "c2VjcmV0"} {
+		assert.NotContains(t, output, secret)
+	}
+
+	var cfg map[string]map[string]interface{}
+	_, err := toml.Decode(output, &cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "localhost:7419", cfg["faktory"]["binding"])
+	assert.Equal(t, redacted, cfg["faktory"]["password"])
+	assert.Equal(t, "0.0.0.0:7420", cfg["web"]["binding"])
+	assert.Equal(t, "tcp://:********@staging.example.com:7419", cfg["mirror"]["url"])
+	assert.Equal(t, "stripe", cfg["webhooks"]["stripe"].(map[string]interface{})["provider"])
+
+	buf.Reset()
+	assert.Equal(t, 0, Dump(opts, &buf, "json"))
+	assert.NotContains(t, buf.String(), "hunter2")
+	cfg = nil
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &cfg))
+	assert.Equal(t, redacted, cfg["faktory"]["password"])
+	assert.Equal(t, "0.0.0.0:7420", cfg["web"]["binding"])
+
+	var format printFormat
+	assert.NoError(t, format.Set("true"))
+	assert.Equal(t, "toml", format.String())
+	assert.NoError(t, format.Set("json"))
+	assert.Equal(t, "json", format.String())
+	assert.Error(t, format.Set("yaml"))
+}
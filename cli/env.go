@@ -0,0 +1,124 @@
+package cli
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/contribsys/faktory/util"
+)
+
+/*
+ * Any config value may be set with a FAKTORY_* environment variable,
+ * overriding the TOML in conf.d, so containers can be configured
+ * without mounting a volume.  The variable is named after the section
+ * and key, upper-cased:
+ *
+ *   FAKTORY_BINDING=0.0.0.0:7419         # [faktory] binding
+ *   FAKTORY_MAX_JOB_SIZE=1048576         # [faktory] max_job_size
+ *   FAKTORY_WEB_BINDING=0.0.0.0:7420     # [web] binding
+ *   FAKTORY_SAMPLING_RATE=0.1            # [sampling] rate
+ *
+ * Keys of the [faktory] section need no prefix.  Nested tables and
+ * sections Faktory doesn't know about separate each level with a
+ * double underscore:
+ *
+ *   FAKTORY_SHEDDING__QUEUES__BULK=low      # [shedding.queues] bulk
+ *   FAKTORY_QUEUE__LIMITS='{ bulk = 10 }'   # [queue] limits
+ *
+ * Values are parsed as TOML, so integers, floats, booleans, arrays
+ * and inline tables work, anything else is a string.  Keys documented
+ * as strings are never parsed.  FAKTORY_PASSWORD, FAKTORY_SKIP_PASSWORD,
+ * FAKTORY_PROVIDER and FAKTORY_URL keep their existing meaning.
+ */
+const envPrefix = "FAKTORY_"
+
+var reservedEnv = map[string]bool{
+	"FAKTORY_PASSWORD":      true,
+	"FAKTORY_SKIP_PASSWORD": true,
+	"FAKTORY_PROVIDER":      true,
+	"FAKTORY_URL":           true,
+}
+
+// overlayEnv sets the config values named by the environment
+func overlayEnv(hash map[string]interface{}, environ []string) {
+	sort.Strings(environ)
+	for _, pair := range environ {
+		idx := strings.IndexByte(pair, '=')
+		if idx == -1 || !strings.HasPrefix(pair, envPrefix) {
+			continue
+		}
+		name, value := pair[:idx], pair[idx+1:]
+		if reservedEnv[name] {
+			continue
+		}
+		path := envPath(name)
+		if path == nil {
+			util.Debugf("Ignoring %s, it doesn't name a config key", name)
+			continue
+		}
+		util.Debugf("Setting %s from %s", strings.Join(path, "."), name)
+		setPath(hash, path, envValue(path, value))
+	}
+}
+
+// envPath maps the variable's name to the path of the config
+// key, nil if it doesn't name one
+func envPath(name string) []string {
+	key := strings.ToLower(strings.TrimPrefix(name, envPrefix))
+	if key == "" {
+		return nil
+	}
+	if strings.Contains(key, "__") {
+		path := strings.Split(key, "__")
+		for _, elm := range path {
+			if elm == "" {
+				return nil
+			}
+		}
+		if len(path) == 1 {
+			return nil
+		}
+		return path
+	}
+
+	if _, ok := configSchema["faktory"][key]; ok {
+		return []string{"faktory", key}
+	}
+	// the longest matching section wins
+	best := ""
+	for section := range configSchema {
+		if strings.HasPrefix(key, section+"_") && len(section) > len(best) {
+			best = section
+		}
+	}
+	if best == "" {
+		return nil
+	}
+	return []string{best, strings.TrimPrefix(key, best+"_")}
+}
+
+// envValue parses the value as TOML unless the key is a string
+func envValue(path []string, value string) interface{} {
+	if len(path) == 2 && configSchema[path[0]][path[1]] == "string" {
+		return value
+	}
+	var parsed map[string]interface{}
+	_, err := toml.Decode("v = "+value, &parsed)
+	if err != nil {
+		return value
+	}
+	return parsed["v"]
+}
+
+func setPath(hash map[string]interface{}, path []string, value interface{}) {
+	for _, elm := range path[:len(path)-1] {
+		child, ok := hash[elm].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			hash[elm] = child
+		}
+		hash = child
+	}
+	hash[path[len(path)-1]] = value
+}
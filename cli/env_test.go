@@ -0,0 +1,37 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOverlayEnv(t *testing.T) {
+	hash := map[string]interface{}{
+		"faktory": map[string]interface{}{"binding": "localhost:7419", "password": "secret"},
+		"web":     "oops",
+	}
+	overlayEnv(hash, []string{
+		"HOME=/root",
+		"FAKTORY_BINDING=0.0.0.0:7419",
+		"FAKTORY_MAX_JOB_SIZE=1048576",
+		"FAKTORY_WEB_BINDING=0.0.0.0:7420",
+		"FAKTORY_SAMPLING_RATE=0.5",
+		"FAKTORY_TLS_CLIENT_CA=/etc/faktory/ca.pem",
+		"FAKTORY_SHEDDING__QUEUES__BULK=low",
+		"FAKTORY_QUEUE__LIMITS={ bulk = 10 }",
+		"FAKTORY_PASSWORD=hunter2",
+		"FAKTORY_URL=tcp://localhost:7419",
+		"FAKTORY_BOGUS=1",
+		"FAKTORY___X=1",
+	})
+
+	assert.Equal(t, map[string]interface{}{
+		"faktory":  map[string]interface{}{"binding": "0.0.0.0:7419", "password": "secret", "max_job_size": int64(1048576)},
+		"web":      map[string]interface{}{"binding": "0.0.0.0:7420"},
+		"sampling": map[string]interface{}{"rate": 0.5},
+		"tls":      map[string]interface{}{"client_ca": "/etc/faktory/ca.pem"},
+		"shedding": map[string]interface{}{"queues": map[string]interface{}{"bulk": "low"}},
+		"queue":    map[string]interface{}{"limits": map[string]interface{}{"bulk": int64(10)}},
+	}, hash)
+}
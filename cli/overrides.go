@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/contribsys/faktory/util"
+)
+
+/*
+ * -o sets a single config value on the command line, overriding
+ * conf.d and FAKTORY_* environment variables, so container entrypoints
+ * can tweak a value without generating TOML.  The key is the path of
+ * sections and key separated by dots and may be given repeatedly:
+ *
+ *   faktory -o faktory.binding=0.0.0.0:7419 -o shedding.queues.bulk=low
+ *
+ * Values are parsed like environment variables, see env.go.
+ */
+type override struct {
+	path  []string
+	value string
+}
+
+type overrideFlag []override
+
+// overrides are set by ParseArguments and applied by every
+// readConfig, so they survive a reload
+var overrides overrideFlag
+
+func (o *overrideFlag) String() string {
+	pairs := make([]string, 0, len(*o))
+	for _, ovr := range *o {
+		pairs = append(pairs, strings.Join(ovr.path, ".")+"="+ovr.value)
+	}
+	return strings.Join(pairs, " ")
+}
+
+func (o *overrideFlag) Set(pair string) error {
+	idx := strings.IndexByte(pair, '=')
+	if idx == -1 {
+		return fmt.Errorf("%q must be of the form section.key=value", pair)
+	}
+	path := strings.Split(strings.TrimSpace(pair[:idx]), ".")
+	for _, elm := range path {
+		if elm == "" {
+			return fmt.Errorf("%q must be of the form section.key=value", pair)
+		}
+	}
+	if len(path) < 2 {
+		return fmt.Errorf("%q must name a section and key, e.g. faktory.%s", pair, path[0])
+	}
+	*o = append(*o, override{path: path, value: pair[idx+1:]})
+	return nil
+}
+
+func (o overrideFlag) apply(hash map[string]interface{}) {
+	for _, ovr := range o {
+		util.Debugf("Setting %s from the command line", strings.Join(ovr.path, "."))
+		setPath(hash, ovr.path, envValue(ovr.path, ovr.value))
+	}
+}
@@ -0,0 +1,39 @@
+package cli
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOverrides(t *testing.T) {
+	var o overrideFlag
+	assert.NoError(t, o.Set("faktory.binding=0.0.0.0:7419"))
+	assert.NoError(t, o.Set("faktory.max_job_size=1048576"))
+	assert.NoError(t, o.Set("shedding.queues.bulk=low"))
+	assert.NoError(t, o.Set("web.password="))
+	assert.Equal(t, "faktory.binding=0.0.0.0:7419 faktory.max_job_size=1048576 shedding.queues.bulk=low web.password=", o.String())
+
+	assert.Error(t, o.Set("faktory.binding"))
+	assert.Error(t, o.Set("binding=0.0.0.0:7419"))
+	assert.Error(t, o.Set("faktory..binding=0.0.0.0:7419"))
+	assert.Equal(t, 4, len(o))
+
+	dir := writeConfig(t, map[string]string{
+		"faktory.toml": "[faktory]\nbinding = \"localhost:7419\"\nmax_job_size = 1024\n\n[web]\npassword = \"secret\"\n",
+	})
+	defer os.RemoveAll(dir)
+	os.Setenv("FAKTORY_MAX_JOB_SIZE", "2048")
+	defer os.Unsetenv("FAKTORY_MAX_JOB_SIZE")
+
+	overrides = o
+	defer func() { overrides = nil }()
+	cfg, err := readConfig(dir, "development")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"faktory":  map[string]interface{}{"binding": "0.0.0.0:7419", "max_job_size": int64(1048576)},
+		"web":      map[string]interface{}{"password": ""},
+		"shedding": map[string]interface{}{"queues": map[string]interface{}{"bulk": "low"}},
+	}, cfg)
+}
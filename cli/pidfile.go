@@ -0,0 +1,38 @@
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*
+ * -pidfile writes the process' PID for init scripts and monitoring.
+ * It's written to a temporary file and renamed so readers never see
+ * a partial PID, and removed on a clean shutdown.  Two servers can't
+ * share a storage directory whether or not -pidfile is given, see
+ * storage.LockDirectory.
+ */
+func writePidFile(path string) (func(), error) {
+	pid := strconv.Itoa(os.Getpid())
+	tmp := path + ".tmp"
+	err := ioutil.WriteFile(tmp, []byte(pid+"\n"), 0644)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Rename(tmp, path)
+	if err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("Unable to write pidfile: %v", err)
+	}
+
+	return func() {
+		// leave the file if another process has replaced it
+		data, err := ioutil.ReadFile(path)
+		if err == nil && strings.TrimSpace(string(data)) == pid {
+			os.Remove(path)
+		}
+	}, nil
+}
@@ -0,0 +1,41 @@
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPidFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "faktory-pidfile")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "faktory.pid")
+
+	remove, err := writePidFile(path)
+	assert.NoError(t, err)
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%d\n", os.Getpid()), string(data))
+	_, err = os.Stat(path + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+
+	remove()
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	// another process' pidfile is left alone
+	remove, err = writePidFile(path)
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(path, []byte("1\n"), 0644))
+	remove()
+	_, err = os.Stat(path)
+	assert.NoError(t, err)
+
+	_, err = writePidFile(filepath.Join(dir, "missing", "faktory.pid"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,259 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/contribsys/faktory/client"
+)
+
+/*
+ * `faktory cli` is an interactive client for the command protocol,
+ * like redis-cli, so operators needn't craft frames with netcat.  It
+ * connects and authenticates like any client, see client.Open, so set
+ * FAKTORY_URL to reach a remote server:
+ *
+ *   $ FAKTORY_URL=tcp://:secret@faktory.example.com:7419 faktory cli
+ *   faktory> PUSH {"jobtype":"Report","args":[1]}
+ *   OK
+ *   faktory> INFO
+ *
+ * PUSH fills in the jid, queue and other defaults the payload omits
+ * and INFO is pretty printed, any other line is sent as is.  Lines
+ * are saved in ~/.faktory_history, "history" lists them and "!n"
+ * runs one again, rlwrap adds line editing.  -x runs a single
+ * command and exits:
+ *
+ *   $ faktory cli -x INFO
+ */
+const replPrompt = "faktory> "
+
+// generic sends a command line and returns the response,
+// implemented by *client.Client
+type generic interface {
+	Generic(cmdline string) (string, error)
+}
+
+// Repl runs `faktory cli` with the arguments following "cli",
+// returning the exit code for the process.
+func Repl(args []string, in io.Reader, out io.Writer) int {
+	flags := flag.NewFlagSet("cli", flag.ContinueOnError)
+	command := flags.String("x", "", "Run the command and exit")
+	err := flags.Parse(args)
+	if err != nil {
+		return 1
+	}
+
+	cl, err := client.Open()
+	if err != nil {
+		log.Printf("Unable to connect: %v", err)
+		return 1
+	}
+	defer cl.Close()
+
+	if *command != "" {
+		err = runCommand(cl, *command, out)
+		if err != nil {
+			fmt.Fprintf(out, "(error) %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	hist := openHistory(historyPath())
+	defer hist.close()
+	repl(cl, hist, in, out)
+	return 0
+}
+
+func repl(cl generic, hist *history, in io.Reader, out io.Writer) {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for {
+		fmt.Fprint(out, replPrompt)
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "!") {
+			num, err := strconv.Atoi(line[1:])
+			prev, ok := hist.get(num)
+			if err != nil || !ok {
+				fmt.Fprintf(out, "(error) No such history entry %s\n", line[1:])
+				continue
+			}
+			line = prev
+			fmt.Fprintln(out, line)
+		}
+
+		switch strings.ToLower(line) {
+		case "history":
+			hist.list(out)
+			continue
+		case "quit", "exit":
+			return
+		}
+		hist.add(line)
+
+		err := runCommand(cl, line, out)
+		if err != nil {
+			fmt.Fprintf(out, "(error) %v\n", err)
+			if client.ErrorCode(err) == "" {
+				// the connection is broken
+				return
+			}
+		}
+		if strings.EqualFold(line, "END") {
+			return
+		}
+	}
+}
+
+func runCommand(cl generic, line string, out io.Writer) error {
+	verb, payload := line, ""
+	if idx := strings.IndexByte(line, ' '); idx != -1 {
+		verb, payload = line[:idx], strings.TrimSpace(line[idx+1:])
+	}
+	verb = strings.ToUpper(verb)
+
+	if verb == "PUSH" {
+		// the payload's fields override NewJob's defaults
+		job := client.NewJob("")
+		err := json.Unmarshal([]byte(payload), job)
+		if err != nil {
+			return fmt.Errorf("Invalid job: %v", err)
+		}
+		data, err := json.Marshal(job)
+		if err != nil {
+			return err
+		}
+		payload = string(data)
+	}
+	if verb == "PUSHB" {
+		var raw []json.RawMessage
+		err := json.Unmarshal([]byte(payload), &raw)
+		if err != nil {
+			return fmt.Errorf("Invalid jobs: %v", err)
+		}
+		jobs := make([]*client.Job, len(raw))
+		for idx, val := range raw {
+			jobs[idx] = client.NewJob("")
+			err = json.Unmarshal(val, jobs[idx])
+			if err != nil {
+				return fmt.Errorf("Invalid job: %v", err)
+			}
+		}
+		data, err := json.Marshal(jobs)
+		if err != nil {
+			return err
+		}
+		payload = string(data)
+	}
+	if payload != "" {
+		line = verb + " " + payload
+	} else {
+		line = verb
+	}
+
+	resp, err := cl.Generic(line)
+	if err != nil {
+		return err
+	}
+	if verb == "INFO" {
+		var pretty bytes.Buffer
+		if json.Indent(&pretty, []byte(resp), "", "  ") == nil {
+			resp = pretty.String()
+		}
+	}
+	if resp == "" {
+		resp = "(nil)"
+	}
+	fmt.Fprintln(out, resp)
+	return nil
+}
+
+func historyPath() string {
+	dir := os.Getenv("HOME")
+	if usr, err := user.Current(); err == nil {
+		dir = usr.HomeDir
+	}
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, ".faktory_history")
+}
+
+// history keeps the lines entered, appending them to a
+// file so they're available next time
+type history struct {
+	lines []string
+	file  *os.File
+}
+
+// maxHistory limits the lines loaded from the file
+const maxHistory = 1000
+
+func openHistory(path string) *history {
+	h := &history{}
+	if path == "" {
+		return h
+	}
+	if data, err := ioutil.ReadFile(path); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if line != "" {
+				h.lines = append(h.lines, line)
+			}
+		}
+		if len(h.lines) > maxHistory {
+			h.lines = h.lines[len(h.lines)-maxHistory:]
+		}
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err == nil {
+		h.file = file
+	}
+	return h
+}
+
+func (h *history) add(line string) {
+	h.lines = append(h.lines, line)
+	if h.file != nil {
+		fmt.Fprintln(h.file, line)
+	}
+}
+
+// get returns the entry numbered as listed, from 1
+func (h *history) get(num int) (string, bool) {
+	if num < 1 || num > len(h.lines) {
+		return "", false
+	}
+	return h.lines[num-1], true
+}
+
+func (h *history) list(out io.Writer) {
+	for idx, line := range h.lines {
+		fmt.Fprintf(out, "%5d  %s\n", idx+1, line)
+	}
+}
+
+func (h *history) close() {
+	if h.file != nil {
+		h.file.Close()
+	}
+}
@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeServer struct {
+	sent []string
+}
+
+func (f *fakeServer) Generic(cmdline string) (string, error) {
+	f.sent = append(f.sent, cmdline)
+	switch {
+	case cmdline == "INFO":
+		return `{"faktory":{"total_enqueued":1}}`, nil
+	case strings.HasPrefix(cmdline, "PUSH"), cmdline == "FLUSH":
+		return "OK", nil
+	case strings.HasPrefix(cmdline, "FETCH"):
+		return "", nil
+	default:
+		return "", &client.ProtocolError{Code: "ERR"}
+	}
+}
+
+type brokenServer struct {
+	calls int
+}
+
+func (b *brokenServer) Generic(cmdline string) (string, error) {
+	b.calls++
+	return "", io.EOF
+}
+
+func TestRepl(t *testing.T) {
+	dir, err := ioutil.TempDir("", "faktory-repl")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, ".faktory_history")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("INFO\n"), 0600))
+
+	srv := &fakeServer{}
+	hist := openHistory(path)
+	in := strings.NewReader("push {\"jobtype\":\"Report\",\"args\":[1]}\n\nFETCH default\nBOGUS\n!1\n!9\nhistory\nquit\nFLUSH\n")
+	var out bytes.Buffer
+	repl(srv, hist, in, &out)
+	hist.close()
+
+	assert.Equal(t, 4, len(srv.sent))
+	var job client.Job
+	assert.True(t, strings.HasPrefix(srv.sent[0], "PUSH "))
+	assert.NoError(t, json.Unmarshal([]byte(srv.sent[0][5:]), &job))
+	assert.Equal(t, "Report", job.Type)
+	assert.Equal(t, "default", job.Queue)
+	assert.NotEmpty(t, job.Jid)
+	assert.Equal(t, []string{"FETCH default", "BOGUS", "INFO"}, srv.sent[1:])
+
+	assert.Equal(t, `faktory> OK
+faktory> faktory> (nil)
+faktory> (error) 
+faktory> INFO
+{
+  "faktory": {
+    "total_enqueued": 1
+  }
+}
+faktory> (error) No such history entry 9
+faktory>     1  INFO
+    2  push {"jobtype":"Report","args":[1]}
+    3  FETCH default
+    4  BOGUS
+    5  INFO
+faktory> `, out.String())
+
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, len(strings.Split(strings.TrimSpace(string(data)), "\n")))
+
+	out.Reset()
+	assert.Error(t, runCommand(srv, "PUSH {", &out))
+	assert.Equal(t, 4, len(srv.sent))
+
+	assert.NoError(t, runCommand(srv, `PUSHB [{"jobtype":"Report","args":[1]}]`, &out))
+	var jobs []client.Job
+	assert.NoError(t, json.Unmarshal([]byte(srv.sent[4][6:]), &jobs))
+	assert.Equal(t, 1, len(jobs))
+	assert.NotEmpty(t, jobs[0].Jid)
+	assert.Error(t, runCommand(srv, `PUSHB [1]`, &out))
+	assert.Equal(t, 5, len(srv.sent))
+
+	// a broken connection ends the session
+	broken := &brokenServer{}
+	repl(broken, &history{}, strings.NewReader("INFO\nINFO\n"), &out)
+	assert.Equal(t, 1, broken.calls)
+}
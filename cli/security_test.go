@@ -78,4 +78,15 @@ func TestPasswords(t *testing.T) {
 	})
 
 	os.Unsetenv("FAKTORY_SKIP_PASSWORD")
+
+	t.Run("ProductionExternalAuth", func(t *testing.T) {
+		cfg := map[string]interface{}{
+			"auth": map[string]interface{}{
+				"provider": "token",
+			},
+		}
+		pwd, err := fetchPassword(cfg, "production")
+		assert.NoError(t, err)
+		assert.Equal(t, "", pwd)
+	})
 }
@@ -0,0 +1,167 @@
+package cli
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/manager"
+	"github.com/contribsys/faktory/storage"
+)
+
+/*
+ * `faktory seed` pushes fixture jobs for development and load testing
+ * from a file of newline-delimited JSON, one job per line:
+ *
+ *   {"jobtype":"Report","args":[1]}
+ *   {"jobtype":"Report","args":[2],"queue":"critical","at":"2030-01-01T00:00:00Z"}
+ *
+ * Like PUSH in `faktory cli`, the jid, queue and other defaults a job
+ * omits are filled in.  Every line is checked before any job is pushed.
+ * When FAKTORY_URL is set or Faktory is running with the storage
+ * directory, the jobs are pushed to the server, otherwise they're
+ * written straight to the storage directory so Faktory finds them
+ * when it boots:
+ *
+ *   $ faktory seed -f jobs.jsonl
+ *   $ FAKTORY_URL=tcp://:secret@faktory.example.com:7419 faktory seed -f - < jobs.jsonl
+ */
+
+// pusher enqueues a job, implemented by *client.Client
+// and manager.Manager
+type pusher interface {
+	Push(job *client.Job) error
+}
+
+// Seed runs `faktory seed` with the arguments following "seed",
+// returning the exit code for the process.
+func Seed(opts CliOptions, args []string, stdin io.Reader) int {
+	flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+	file := flags.String("f", "", "The file of jobs, - for stdin")
+	err := flags.Parse(args)
+	if err != nil {
+		return 1
+	}
+	if *file == "" || flags.NArg() > 0 {
+		log.Println("Usage: faktory seed -f [file]")
+		return 1
+	}
+
+	in := stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+		defer f.Close()
+		in = f
+	}
+	jobs, err := readJobs(in)
+	if err != nil {
+		log.Printf("%s: %v", *file, err)
+		return 1
+	}
+
+	start := time.Now()
+	target, count, err := seed(opts, jobs)
+	if err != nil {
+		log.Printf("Unable to seed %s, %d of %d jobs pushed: %v", target, count, len(jobs), err)
+		return 1
+	}
+	log.Printf("Pushed %d jobs to %s in %v", count, target, time.Since(start))
+	return 0
+}
+
+// readJobs parses the jobs, one per line, failing on the
+// first invalid line so nothing is half seeded
+func readJobs(in io.Reader) ([]*client.Job, error) {
+	jobs := []*client.Job{}
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	num := 0
+	for scanner.Scan() {
+		num++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		// the line's fields override NewJob's defaults
+		job := client.NewJob("")
+		err := json.Unmarshal([]byte(line), job)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: Invalid job: %v", num, err)
+		}
+		if job.Type == "" {
+			return nil, fmt.Errorf("line %d: Invalid job: missing jobtype", num)
+		}
+		if job.Args == nil {
+			job.Args = []interface{}{}
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, scanner.Err()
+}
+
+// seed pushes the jobs to the server or, when none is running,
+// the storage directory.  It returns where they were pushed and
+// how many were.
+func seed(opts CliOptions, jobs []*client.Job) (string, int, error) {
+	_, remote := os.LookupEnv("FAKTORY_URL")
+	if _, ok := os.LookupEnv("FAKTORY_PROVIDER"); ok {
+		remote = true
+	}
+	if !remote && opts.StorageEngine == "redis" {
+		unlock, err := storage.LockDirectory(opts.StorageDirectory)
+		if err == nil {
+			defer unlock()
+			count, err := seedStorage(opts.StorageDirectory, jobs)
+			return opts.StorageDirectory, count, err
+		}
+		// Faktory holds the lock, push to it
+	}
+
+	cl, err := client.Open()
+	if err != nil {
+		return "Faktory", 0, err
+	}
+	defer cl.Close()
+	count, err := pushAll(cl, jobs)
+	return cl.Location, count, err
+}
+
+// seedStorage boots Redis on the storage directory to push the
+// jobs, stopping it saves them for Faktory
+func seedStorage(dir string, jobs []*client.Job) (int, error) {
+	sock := fmt.Sprintf("%s/redis.sock", dir)
+	_, err := storage.BootRedis(dir, sock)
+	// stop Redis even if it failed so it isn't restarted
+	defer storage.StopRedis(sock)
+	if err != nil {
+		return 0, err
+	}
+
+	store, err := storage.Open("redis", sock)
+	if err != nil {
+		return 0, err
+	}
+	defer store.Close()
+	return pushAll(manager.NewManager(store), jobs)
+}
+
+func pushAll(p pusher, jobs []*client.Job) (int, error) {
+	for idx, job := range jobs {
+		err := p.Push(job)
+		if err != nil {
+			return idx, fmt.Errorf("%s %s: %v", job.Type, job.Jid, err)
+		}
+	}
+	return len(jobs), nil
+}
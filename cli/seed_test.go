@@ -0,0 +1,55 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakePusher struct {
+	jobs  []*client.Job
+	limit int
+}
+
+func (f *fakePusher) Push(job *client.Job) error {
+	if len(f.jobs) == f.limit {
+		return fmt.Errorf("full")
+	}
+	f.jobs = append(f.jobs, job)
+	return nil
+}
+
+func TestSeed(t *testing.T) {
+	jobs, err := readJobs(strings.NewReader("{\"jobtype\":\"Report\",\"args\":[1]}\n\n  {\"jobtype\":\"Mail\",\"queue\":\"critical\",\"jid\":\"abc\"}\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(jobs))
+	assert.Equal(t, "Report", jobs[0].Type)
+	assert.Equal(t, "default", jobs[0].Queue)
+	assert.NotEmpty(t, jobs[0].Jid)
+	assert.Equal(t, "critical", jobs[1].Queue)
+	assert.Equal(t, "abc", jobs[1].Jid)
+	assert.Equal(t, []interface{}{}, jobs[1].Args)
+
+	_, err = readJobs(strings.NewReader("{\"jobtype\":\"Report\"}\n{\"args\":[1]}\n"))
+	assert.EqualError(t, err, "line 2: Invalid job: missing jobtype")
+	_, err = readJobs(strings.NewReader("\n\n{\"jobtype\":\n"))
+	assert.Error(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "line 3: Invalid job"))
+
+	p := &fakePusher{limit: 10}
+	count, err := pushAll(p, jobs)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, jobs, p.jobs)
+
+	p = &fakePusher{limit: 1}
+	count, err = pushAll(p, jobs)
+	assert.EqualError(t, err, "Mail abc: full")
+	assert.Equal(t, 1, count)
+
+	assert.Equal(t, 1, Seed(CliOptions{}, []string{}, strings.NewReader("")))
+	assert.Equal(t, 1, Seed(CliOptions{}, []string{"-f", "-"}, strings.NewReader("nope\n")))
+}
@@ -0,0 +1,93 @@
+package cli
+
+import (
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/server"
+	"github.com/contribsys/faktory/util"
+	"golang.org/x/sys/windows/svc"
+)
+
+/*
+ * Faktory runs as a Windows service when the service control manager
+ * starts it.  Register it with sc.exe, logging to a file as a service
+ * has no console:
+ *
+ *   sc.exe create faktory binPath= "C:\Faktory\faktory.exe -e production -logfile C:\Faktory\faktory.log" start= auto
+ *   sc.exe start faktory
+ *
+ * Stopping the service shuts Faktory down as SIGTERM would, draining
+ * the jobs in progress for up to faktory.shutdown_timeout.  Pausing
+ * it stops FETCH returning jobs until it's continued, and
+ * `sc.exe control faktory paramchange` reloads the config as SIGHUP
+ * does elsewhere.
+ */
+const serviceName = "faktory"
+
+const serviceAccepts = svc.AcceptStop | svc.AcceptShutdown | svc.AcceptPauseAndContinue | svc.AcceptParamChange
+
+func isService() bool {
+	interactive, err := svc.IsAnInteractiveSession()
+	if err != nil {
+		util.Warnf("Unable to determine if running as a service: %v", err)
+		return false
+	}
+	return !interactive
+}
+
+func runService(s *server.Server) {
+	err := svc.Run(serviceName, &service{s})
+	if err != nil {
+		util.Warnf("Unable to run as a Windows service: %v", err)
+		exit(s)
+	}
+}
+
+// service handles the control requests of the
+// service control manager
+type service struct {
+	s *server.Server
+}
+
+func (svr *service) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	stopped := make(chan struct{})
+	svr.s.OnShutdown(func(*server.Server) {
+		close(stopped)
+	})
+
+	status <- svc.Status{State: svc.Running, Accepts: serviceAccepts}
+	for {
+		select {
+		case <-stopped:
+			// shut down by other means, e.g. Ctrl-C
+			status <- svc.Status{State: svc.Stopped}
+			return false, 0
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				timeout := time.Duration(svr.s.Options.Int("faktory", "shutdown_timeout", 25)) * time.Second
+				status <- svc.Status{State: svc.StopPending, WaitHint: uint32((timeout + 10*time.Second) / time.Millisecond)}
+				exit(svr.s)
+				<-stopped
+				status <- svc.Status{State: svc.Stopped}
+				return false, 0
+			case svc.Pause:
+				util.Infof("%s pausing, fetching is paused", client.Name)
+				svr.s.PauseFetching()
+				status <- svc.Status{State: svc.Paused, Accepts: serviceAccepts}
+			case svc.Continue:
+				util.Infof("%s continuing", client.Name)
+				svr.s.ResumeFetching()
+				status <- svc.Status{State: svc.Running, Accepts: serviceAccepts}
+			case svc.ParamChange:
+				reload(svr.s)
+				status <- req.CurrentStatus
+			default:
+				util.Warnf("Unexpected service control request %d", req.Cmd)
+			}
+		}
+	}
+}
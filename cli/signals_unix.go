@@ -0,0 +1,33 @@
+// +build !windows
+
+package cli
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/contribsys/faktory/server"
+)
+
+var (
+	Term os.Signal = syscall.SIGTERM
+	Hup  os.Signal = syscall.SIGHUP
+	Usr1 os.Signal = syscall.SIGUSR1
+	Usr2 os.Signal = syscall.SIGUSR2
+
+	SignalHandlers = map[os.Signal]func(*server.Server){
+		Term:         exit,
+		os.Interrupt: exit,
+		Hup:          reload,
+		Usr1:         toggleDebug,
+		Usr2:         reopenLog,
+	}
+)
+
+// services are managed with signals, see service_windows.go
+func isService() bool {
+	return false
+}
+
+func runService(s *server.Server) {
+}
@@ -0,0 +1,19 @@
+package cli
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/contribsys/faktory/server"
+)
+
+// Windows has no SIGHUP or SIGUSR signals, a service reloads on
+// the paramchange control instead, see service_windows.go
+var (
+	Term os.Signal = syscall.SIGTERM
+
+	SignalHandlers = map[os.Signal]func(*server.Server){
+		Term:         exit,
+		os.Interrupt: exit,
+	}
+)
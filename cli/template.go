@@ -0,0 +1,99 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/contribsys/faktory/client"
+)
+
+/*
+ * `faktory template` pushes the job templates saved on the server,
+ * see server/templates.go, so run-books can give a single command.
+ * It connects to FAKTORY_URL like `faktory cli`:
+ *
+ *   $ faktory template list
+ *   $ faktory template push reindex index=users batch=100
+ */
+
+// Template runs `faktory template` with the arguments following
+// "template", returning the exit code for the process.
+func Template(args []string, out io.Writer) int {
+	if len(args) == 0 || (args[0] != "list" && args[0] != "push") || (args[0] == "push" && len(args) < 2) {
+		log.Println("Usage: faktory template list | push [name] [param=value...]")
+		return 1
+	}
+
+	cl, err := client.Open()
+	if err != nil {
+		log.Printf("Unable to connect: %v", err)
+		return 1
+	}
+	defer cl.Close()
+
+	if args[0] == "list" {
+		err = listTemplates(cl, out)
+	} else {
+		err = pushTemplate(cl, args[1], args[2:], out)
+	}
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	return 0
+}
+
+func listTemplates(cl generic, out io.Writer) error {
+	resp, err := cl.Generic("TEMPLATE LIST")
+	if err != nil {
+		return err
+	}
+	var list []struct {
+		Name        string          `json:"name"`
+		Type        string          `json:"jobtype"`
+		Args        json.RawMessage `json:"args"`
+		Description string          `json:"description"`
+	}
+	err = json.Unmarshal([]byte(resp), &list)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tJOB\tARGS\tDESCRIPTION")
+	for _, t := range list {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", t.Name, t.Type, t.Args, t.Description)
+	}
+	return tw.Flush()
+}
+
+// pushTemplate fills the template's placeholders from
+// param=value arguments
+func pushTemplate(cl generic, name string, args []string, out io.Writer) error {
+	params := map[string]string{}
+	for _, arg := range args {
+		idx := strings.IndexByte(arg, '=')
+		if idx < 1 {
+			return fmt.Errorf("Invalid parameter %q, expected param=value", arg)
+		}
+		params[arg[:idx]] = arg[idx+1:]
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"name":   name,
+		"params": params,
+	})
+	if err != nil {
+		return err
+	}
+
+	jid, err := cl.Generic("TEMPLATE PUSH " + string(payload))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Pushed %s, JID %s\n", name, jid)
+	return nil
+}
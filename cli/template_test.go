@@ -0,0 +1,36 @@
+package cli
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type templateServer struct {
+	sent []string
+}
+
+func (ts *templateServer) Generic(cmdline string) (string, error) {
+	ts.sent = append(ts.sent, cmdline)
+	if cmdline == "TEMPLATE LIST" {
+		return `[{"name":"reindex","jobtype":"Reindex","args":["{{index}}"],"description":"Rebuild an index"}]`, nil
+	}
+	return "abc123", nil
+}
+
+func TestTemplate(t *testing.T) {
+	srv := &templateServer{}
+	var out bytes.Buffer
+	assert.NoError(t, listTemplates(srv, &out))
+	assert.Equal(t, "NAME     JOB      ARGS           DESCRIPTION\nreindex  Reindex  [\"{{index}}\"]  Rebuild an index\n", out.String())
+
+	out.Reset()
+	assert.NoError(t, pushTemplate(srv, "reindex", []string{"index=users", "note=a=b"}, &out))
+	assert.Equal(t, `TEMPLATE PUSH {"name":"reindex","params":{"index":"users","note":"a=b"}}`, srv.sent[1])
+	assert.Equal(t, "Pushed reindex, JID abc123\n", out.String())
+
+	assert.EqualError(t, pushTemplate(srv, "reindex", []string{"users"}, &out), `Invalid parameter "users", expected param=value`)
+	assert.Equal(t, 2, len(srv.sent))
+	assert.Equal(t, 1, Template([]string{"push"}, &out))
+}
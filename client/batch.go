@@ -0,0 +1,149 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// BatchAttribute is the custom attribute holding the BID of the
+// batch a job belongs to, see Batch.Push.
+const BatchAttribute = "bid"
+
+// BatchCallbackAttribute is the custom attribute holding the BID
+// on a batch's success and complete callbacks.
+const BatchCallbackAttribute = "_bid"
+
+/*
+ * Batch groups jobs so a callback job is pushed once they've
+ * all run.  Complete is pushed once every job has run, even if
+ * some failed, and Success once every job has succeeded:
+ *
+ *   b := client.NewBatch(cl)
+ *   b.Description = "Import users.csv"
+ *   b.Success = client.NewJob("ImportDone", "users.csv")
+ *   err := b.Jobs(func() error {
+ *     for _, row := range rows {
+ *       err := b.Push(client.NewJob("ImportRow", row))
+ *       if err != nil {
+ *         return err
+ *       }
+ *     }
+ *     return nil
+ *   })
+ */
+type Batch struct {
+	Bid         string `json:"-"`
+	Description string `json:"description,omitempty"`
+	Success     *Job   `json:"success,omitempty"`
+	Complete    *Job   `json:"complete,omitempty"`
+
+	client    *Client
+	committed bool
+}
+
+// BatchStatus is the progress of a batch.  Pending counts the
+// jobs which haven't succeeded, Failed those of them which have
+// failed at least once.
+type BatchStatus struct {
+	Bid         string `json:"bid"`
+	Description string `json:"description,omitempty"`
+	CreatedAt   string `json:"created_at"`
+	Committed   bool   `json:"committed"`
+	Total       int64  `json:"total"`
+	Pending     int64  `json:"pending"`
+	Failed      int64  `json:"failed"`
+	CompletedAt string `json:"completed_at,omitempty"`
+	SucceededAt string `json:"succeeded_at,omitempty"`
+}
+
+// NewBatch returns a batch which is created on the server by Jobs.
+func NewBatch(cl *Client) *Batch {
+	return &Batch{client: cl}
+}
+
+// Jobs creates the batch on the server if it's new, calls fn to push
+// its jobs and commits the batch if fn succeeds.
+func (b *Batch) Jobs(fn func() error) error {
+	if b.Bid == "" {
+		payload, err := json.Marshal(b)
+		if err != nil {
+			return err
+		}
+		err = b.client.writeLine("BATCH", append([]byte("NEW "), payload...))
+		if err != nil {
+			return err
+		}
+		b.Bid, err = b.client.readString()
+		if err != nil {
+			return err
+		}
+	}
+
+	err := fn()
+	if err != nil {
+		return err
+	}
+	return b.Commit()
+}
+
+// Push pushes a job in the batch, which must be open.
+func (b *Batch) Push(job *Job) error {
+	if b.Bid == "" || b.committed {
+		return fmt.Errorf("Batch is not open, push its jobs within Jobs")
+	}
+	job.SetCustom(BatchAttribute, b.Bid)
+	return b.client.Push(job)
+}
+
+// Commit tells the server every job has been pushed, Jobs
+// commits the batch itself.
+func (b *Batch) Commit() error {
+	if b.committed {
+		return nil
+	}
+	err := b.client.writeLine("BATCH", []byte("COMMIT "+b.Bid))
+	if err != nil {
+		return err
+	}
+	err = b.client.ok()
+	if err != nil {
+		return err
+	}
+	b.committed = true
+	return nil
+}
+
+// BatchOpen reopens a committed batch so more jobs can be added with
+// its Jobs, e.g. by a job in the batch.  A batch can't be reopened
+// once it has succeeded.
+func (c *Client) BatchOpen(bid string) (*Batch, error) {
+	err := c.writeLine("BATCH", []byte("OPEN "+bid))
+	if err != nil {
+		return nil, err
+	}
+	err = c.ok()
+	if err != nil {
+		return nil, err
+	}
+	return &Batch{Bid: bid, client: c}, nil
+}
+
+// BatchStatus returns the progress of the batch.
+func (c *Client) BatchStatus(bid string) (*BatchStatus, error) {
+	err := c.writeLine("BATCH", []byte("STATUS "+bid))
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := c.readResponse()
+	if err != nil {
+		return nil, err
+	}
+
+	var status BatchStatus
+	err = json.Unmarshal(data, &status)
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
@@ -0,0 +1,115 @@
+package client
+
+import "context"
+
+// ParentAttribute is the custom attribute holding the JID of the
+// job which pushed the job, see WithJob.
+const ParentAttribute = "parent_jid"
+
+// BagAttribute is the custom attribute holding the job's Bag.
+const BagAttribute = "bag"
+
+// Keys for the context commonly carried in a Bag, so teams
+// don't each invent their own.
+const (
+	BagRequestID = "request_id"
+	BagUserID    = "user_id"
+	BagLocale    = "locale"
+)
+
+// Bag is request-scoped context, e.g. the request id, user id and
+// locale, which is carried from the code pushing a job to the worker
+// executing it and on to any jobs that job pushes.
+type Bag map[string]string
+
+type jobKey struct{}
+type bagKey struct{}
+
+// WithJob returns a context for executing the job.  Worker libraries
+// call it so the job's Bag is restored and jobs pushed with PushContext
+// from within the job record it as their parent, shown as the job's
+// lineage in the Web UI.
+func WithJob(ctx context.Context, job *Job) context.Context {
+	return context.WithValue(WithBag(ctx, job.Bag()), jobKey{}, job)
+}
+
+// JobFromContext returns the job being executed, nil if none.
+func JobFromContext(ctx context.Context) *Job {
+	job, _ := ctx.Value(jobKey{}).(*Job)
+	return job
+}
+
+// WithBag returns a context carrying the bag's values merged
+// over any bag ctx already carries.
+func WithBag(ctx context.Context, bag Bag) context.Context {
+	if len(bag) == 0 {
+		return ctx
+	}
+	merged := Bag{}
+	for k, v := range BagFromContext(ctx) {
+		merged[k] = v
+	}
+	for k, v := range bag {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, bagKey{}, merged)
+}
+
+// BagFromContext returns the bag carried by ctx, nil if none.
+// It must not be modified, use WithBag.
+func BagFromContext(ctx context.Context) Bag {
+	bag, _ := ctx.Value(bagKey{}).(Bag)
+	return bag
+}
+
+// Bag returns the job's bag, nil if it has none.
+func (j *Job) Bag() Bag {
+	val, ok := j.GetCustom(BagAttribute)
+	if !ok {
+		return nil
+	}
+	switch v := val.(type) {
+	case Bag:
+		return v
+	case map[string]string:
+		return Bag(v)
+	case map[string]interface{}:
+		// decoded from JSON
+		bag := Bag{}
+		for k, val := range v {
+			if s, ok := val.(string); ok {
+				bag[k] = s
+			}
+		}
+		return bag
+	default:
+		return nil
+	}
+}
+
+// SetBag replaces the job's bag.
+func (j *Job) SetBag(bag Bag) {
+	j.SetCustom(BagAttribute, bag)
+}
+
+// PushContext pushes the job, recording the job being executed
+// in ctx, if any, as its parent and carrying ctx's bag.  Values
+// already in the job's bag take precedence.
+func (c *Client) PushContext(ctx context.Context, job *Job) error {
+	if parent := JobFromContext(ctx); parent != nil {
+		if _, ok := job.GetCustom(ParentAttribute); !ok {
+			job.SetCustom(ParentAttribute, parent.Jid)
+		}
+	}
+	if bag := BagFromContext(ctx); len(bag) > 0 {
+		merged := Bag{}
+		for k, v := range bag {
+			merged[k] = v
+		}
+		for k, v := range job.Bag() {
+			merged[k] = v
+		}
+		job.SetBag(merged)
+	}
+	return c.Push(job)
+}
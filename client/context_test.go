@@ -0,0 +1,32 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBag(t *testing.T) {
+	ctx := WithBag(context.Background(), Bag{BagRequestID: "abc", BagLocale: "en"})
+	ctx = WithBag(ctx, Bag{BagLocale: "de"})
+	assert.Equal(t, Bag{BagRequestID: "abc", BagLocale: "de"}, BagFromContext(ctx))
+	assert.Nil(t, BagFromContext(context.Background()))
+
+	// the bag survives the round trip through the server
+	job := NewJob("SendEmail", 1)
+	job.SetBag(Bag{BagUserID: "42", BagLocale: "fr"})
+	data, err := json.Marshal(job)
+	assert.NoError(t, err)
+
+	var fetched Job
+	assert.NoError(t, json.Unmarshal(data, &fetched))
+	ctx = WithJob(context.Background(), &fetched)
+	assert.Equal(t, &fetched, JobFromContext(ctx))
+	assert.Equal(t, Bag{BagUserID: "42", BagLocale: "fr"}, BagFromContext(ctx))
+
+	job = NewJob("Nothing")
+	assert.Nil(t, job.Bag())
+	assert.Equal(t, context.Background(), WithBag(context.Background(), job.Bag()))
+}
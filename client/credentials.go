@@ -0,0 +1,93 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ClientCredentials returns a TokenSource which fetches access tokens
+// from an OAuth2 token endpoint with the client-credentials grant, for
+// servers which authenticate workers with an OIDC identity server.
+// Tokens are reused until shortly before they expire.
+//
+//	srv := client.DefaultServer()
+//	srv.TokenSource = client.ClientCredentials("https://idp.example.com/oauth2/token", "worker", secret)
+func ClientCredentials(tokenURL, clientID, clientSecret string, scopes ...string) func() (string, error) {
+	cc := &clientCredentials{
+		url:    tokenURL,
+		id:     clientID,
+		secret: clientSecret,
+		scopes: scopes,
+		client: HTTPClient(10 * time.Second),
+	}
+	return cc.Token
+}
+
+type clientCredentials struct {
+	url    string
+	id     string
+	secret string
+	scopes []string
+	client *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+func (cc *clientCredentials) Token() (string, error) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.token != "" && time.Now().Before(cc.expires) {
+		return cc.token, nil
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(cc.scopes) > 0 {
+		form.Set("scope", strings.Join(cc.scopes, " "))
+	}
+	req, err := http.NewRequest("POST", cc.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(cc.id), url.QueryEscape(cc.secret))
+
+	resp, err := cc.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Unable to fetch access token: %s", resp.Status)
+	}
+
+	var tr tokenResponse
+	err = json.NewDecoder(resp.Body).Decode(&tr)
+	if err != nil {
+		return "", err
+	}
+	if tr.AccessToken == "" {
+		return "", fmt.Errorf("Token endpoint returned no access token")
+	}
+
+	cc.token = tr.AccessToken
+	cc.expires = time.Time{}
+	if tr.ExpiresIn > 0 {
+		// leave time for the server to introspect it
+		lifetime := time.Duration(tr.ExpiresIn) * time.Second
+		cc.expires = time.Now().Add(lifetime - lifetime/10)
+	}
+	return cc.token, nil
+}
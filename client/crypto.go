@@ -0,0 +1,107 @@
+package client
+
+import (
+	"crypto/tls"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Restricted crypto mode limits TLS to FIPS 140-2 approved protocol
+// versions, cipher suites and curves for deployments which require it.
+// Binaries built with "-tags fips" always run in restricted mode, others
+// can enable it with SetRestrictedCrypto or the server's
+// [faktory] fips = true config.
+//
+// Go's TLS 1.3 cipher suites can't be configured and include ChaCha20,
+// so restricted connections use TLS 1.2 only.
+var restricted int32
+
+// RestrictedCrypto returns true if only approved algorithms may be used.
+func RestrictedCrypto() bool {
+	return fipsBuild || atomic.LoadInt32(&restricted) == 1
+}
+
+// SetRestrictedCrypto enables or disables restricted crypto mode for the
+// process.  It can't be disabled in binaries built with the fips tag.
+func SetRestrictedCrypto(enabled bool) {
+	val := int32(0)
+	if enabled {
+		val = 1
+	}
+	atomic.StoreInt32(&restricted, val)
+}
+
+// ApprovedCipherSuites are the TLS 1.2 suites allowed in restricted mode,
+// see NIST SP 800-52.
+var ApprovedCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+// ApprovedCurves are the key exchange curves allowed in restricted mode.
+var ApprovedCurves = []tls.CurveID{tls.CurveP256, tls.CurveP384, tls.CurveP521}
+
+// RestrictTLS limits the config to the approved suites and curves if
+// restricted mode is enabled and returns it.  Suites already configured
+// are kept if they are approved.
+func RestrictTLS(cfg *tls.Config) *tls.Config {
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+	if !RestrictedCrypto() {
+		return cfg
+	}
+
+	cfg.MinVersion = tls.VersionTLS12
+	cfg.MaxVersion = tls.VersionTLS12
+	cfg.CipherSuites = approved(cfg.CipherSuites, ApprovedCipherSuites)
+	cfg.CurvePreferences = approvedCurves(cfg.CurvePreferences)
+	return cfg
+}
+
+func approved(suites, allowed []uint16) []uint16 {
+	result := []uint16{}
+	for _, suite := range suites {
+		for _, ok := range allowed {
+			if suite == ok {
+				result = append(result, suite)
+			}
+		}
+	}
+	if len(result) == 0 {
+		return append(result, allowed...)
+	}
+	return result
+}
+
+func approvedCurves(curves []tls.CurveID) []tls.CurveID {
+	result := []tls.CurveID{}
+	for _, curve := range curves {
+		for _, ok := range ApprovedCurves {
+			if curve == ok {
+				result = append(result, curve)
+			}
+		}
+	}
+	if len(result) == 0 {
+		return append(result, ApprovedCurves...)
+	}
+	return result
+}
+
+// HTTPClient returns a client with the given timeout which only
+// negotiates approved TLS in restricted mode.
+func HTTPClient(timeout time.Duration) *http.Client {
+	hc := &http.Client{Timeout: timeout}
+	if RestrictedCrypto() {
+		hc.Transport = &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			TLSClientConfig:     RestrictTLS(nil),
+			TLSHandshakeTimeout: 10 * time.Second,
+		}
+	}
+	return hc
+}
@@ -0,0 +1,5 @@
+// +build !fips
+
+package client
+
+const fipsBuild = false
@@ -0,0 +1,6 @@
+// +build fips
+
+package client
+
+// built with "-tags fips", restricted crypto can't be disabled
+const fipsBuild = true
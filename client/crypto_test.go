@@ -0,0 +1,40 @@
+package client
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestrictTLS(t *testing.T) {
+	if !fipsBuild {
+		cfg := RestrictTLS(nil)
+		assert.Nil(t, cfg.CipherSuites)
+		assert.Equal(t, uint16(0), cfg.MaxVersion)
+	}
+
+	SetRestrictedCrypto(true)
+	defer SetRestrictedCrypto(false)
+	assert.True(t, RestrictedCrypto())
+
+	cfg := RestrictTLS(nil)
+	assert.Equal(t, uint16(tls.VersionTLS12), cfg.MinVersion)
+	assert.Equal(t, uint16(tls.VersionTLS12), cfg.MaxVersion)
+	assert.Equal(t, ApprovedCipherSuites, cfg.CipherSuites)
+	assert.Equal(t, ApprovedCurves, cfg.CurvePreferences)
+
+	cfg = RestrictTLS(&tls.Config{
+		CipherSuites: []uint16{
+			tls.TLS_RSA_WITH_RC4_128_SHA,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+		},
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP384},
+	})
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}, cfg.CipherSuites)
+	assert.Equal(t, []tls.CurveID{tls.CurveP384}, cfg.CurvePreferences)
+
+	hc := HTTPClient(0)
+	assert.NotNil(t, hc.Transport)
+}
@@ -0,0 +1,28 @@
+package client
+
+import "time"
+
+// DebounceAttribute is the custom attribute which collapses repeated
+// pushes of the same key, see Job.Debounce and Job.Throttle.
+const DebounceAttribute = "debounce"
+
+// Debounce collapses pushes of jobs with the same key within the window
+// into one job, executed at the end of the window with the arguments of
+// the latest push, e.g. to reindex a document once after a burst of
+// edits.  The window starts with the first push.
+func (j *Job) Debounce(key string, window time.Duration) {
+	j.SetCustom(DebounceAttribute, map[string]interface{}{
+		"key":    key,
+		"window": int(window / time.Second),
+	})
+}
+
+// Throttle enqueues the first push of a job with the key immediately
+// and drops any further pushes with the same key within the window.
+func (j *Job) Throttle(key string, window time.Duration) {
+	j.SetCustom(DebounceAttribute, map[string]interface{}{
+		"key":     key,
+		"window":  int(window / time.Second),
+		"leading": true,
+	})
+}
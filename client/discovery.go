@@ -0,0 +1,172 @@
+package client
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultPort is used when the server's address has
+	// no port and no SRV record.
+	DefaultPort = 7419
+)
+
+var (
+	// Reconnect dials the server up to ReconnectAttempts times,
+	// doubling the delay between attempts from ReconnectBackoff
+	// up to MaxReconnectBackoff.
+	ReconnectAttempts   = 5
+	ReconnectBackoff    = 100 * time.Millisecond
+	MaxReconnectBackoff = 5 * time.Second
+
+	// overridden in tests
+	lookupSRV = net.LookupSRV
+)
+
+// uriAddress returns the address for a URL like
+// "tcp://:password@faktory.example.com:7419", without
+// a port the host is resolved with SRV records.
+func uriAddress(uri *url.URL) string {
+	if uri.Scheme == "unix" {
+		// unix:///var/run/faktory.sock
+		return uri.Path
+	}
+	if uri.Port() == "" {
+		return uri.Hostname()
+	}
+	return net.JoinHostPort(uri.Hostname(), uri.Port())
+}
+
+// resolve returns the addresses to try, in order.  An address
+// without a port, e.g. "_faktory._tcp.faktory.default.svc.cluster.local",
+// is looked up as an SRV record so the client follows the server
+// when it moves.  Host names are resolved by the dialer, the
+// result is never cached.
+func (s *Server) resolve() ([]string, error) {
+	if s.Network != "tcp" && s.Network != "tcp+tls" {
+		return []string{s.Address}, nil
+	}
+	if _, _, err := net.SplitHostPort(s.Address); err == nil {
+		return []string{s.Address}, nil
+	}
+
+	_, srvs, err := lookupSRV("", "", s.Address)
+	if err != nil {
+		if dnsErr, ok := err.(*net.DNSError); ok && !dnsErr.Temporary() {
+			// no SRV record, assume a plain host name
+			return []string{net.JoinHostPort(s.Address, strconv.Itoa(DefaultPort))}, nil
+		}
+		return nil, err
+	}
+	if len(srvs) == 0 {
+		return nil, fmt.Errorf("No SRV records for %s", s.Address)
+	}
+
+	// already sorted by priority and randomized by weight
+	addrs := make([]string, len(srvs))
+	for idx, rec := range srvs {
+		addrs[idx] = net.JoinHostPort(strings.TrimSuffix(rec.Target, "."), strconv.Itoa(int(rec.Port)))
+	}
+	return addrs, nil
+}
+
+// connect dials the first reachable address for the server.
+func (s *Server) connect() (net.Conn, error) {
+	addrs, err := s.resolve()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, addr := range addrs {
+		var conn net.Conn
+		start := time.Now()
+		conn, err = s.connectTo(addr)
+		s.Hooks.dialed(addr, start, err)
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+func (s *Server) connectTo(address string) (net.Conn, error) {
+	if isWebSocket(s.Network) {
+		address = webSocketAddress(s.Network, address)
+	}
+	proxy, err := s.proxy(address)
+	if err != nil {
+		return nil, err
+	}
+
+	network := s.Network
+	if network == "tcp+tls" || isWebSocket(network) {
+		network = "tcp"
+	}
+	target := address
+	if proxy != nil {
+		target = proxyAddress(proxy)
+	}
+
+	dial := &net.Dialer{Timeout: s.Timeout}
+	conn, err := dial.Dial(network, target)
+	if err != nil {
+		return nil, err
+	}
+	err = s.tune(conn)
+	if err == nil && proxy != nil {
+		conn, err = s.tunnel(conn, proxy, address)
+	}
+	if err == nil && s.encrypted() {
+		conn, err = s.startTLS(conn, address)
+	}
+	if err == nil && isWebSocket(s.Network) {
+		conn, err = s.upgradeWebSocket(conn, address)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Reconnect replaces a broken connection with a new one.  The server's
+// address is resolved again so a server which has moved, e.g. behind a
+// Kubernetes Service, is found.  Only clients created with Dial or Open
+// can reconnect.
+func (c *Client) Reconnect() error {
+	if c.srv == nil {
+		return fmt.Errorf("Unable to reconnect, client was not created with Dial")
+	}
+	c.conn.Close()
+
+	var err error
+	delay := ReconnectBackoff
+	for i := 0; i < ReconnectAttempts; i++ {
+		if i > 0 {
+			// add jitter so workers don't reconnect in lockstep
+			pause := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+			c.srv.Hooks.retrying(i, pause, err)
+			time.Sleep(pause)
+			delay *= 2
+			if delay > MaxReconnectBackoff {
+				delay = MaxReconnectBackoff
+			}
+		}
+
+		var cl *Client
+		cl, err = Dial(c.srv, c.password)
+		if err == nil {
+			c.Options = cl.Options
+			c.conn = cl.conn
+			c.rdr = cl.rdr
+			c.wtr = cl.wtr
+			return nil
+		}
+	}
+	return err
+}
@@ -0,0 +1,139 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func stubSRV(fn func(string) ([]*net.SRV, error)) func() {
+	old := lookupSRV
+	lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		srvs, err := fn(name)
+		return name, srvs, err
+	}
+	return func() { lookupSRV = old }
+}
+
+func TestResolve(t *testing.T) {
+	defer stubSRV(func(name string) ([]*net.SRV, error) {
+		if name == "_faktory._tcp.example.com" {
+			return []*net.SRV{
+				{Target: "faktory1.example.com.", Port: 7419, Priority: 1},
+				{Target: "faktory2.example.com.", Port: 7420, Priority: 2},
+			}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: name}
+	})()
+
+	srv := DefaultServer()
+	addrs, err := srv.resolve()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"localhost:7419"}, addrs)
+
+	srv.Address = "_faktory._tcp.example.com"
+	addrs, err = srv.resolve()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"faktory1.example.com:7419", "faktory2.example.com:7420"}, addrs)
+
+	srv.Address = "faktory.example.com"
+	addrs, err = srv.resolve()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"faktory.example.com:7419"}, addrs)
+
+	uri, _ := url.Parse("tcp://:secret@_faktory._tcp.example.com")
+	assert.Equal(t, "_faktory._tcp.example.com", uriAddress(uri))
+	uri, _ = url.Parse("tcp://:secret@faktory.example.com:7421")
+	assert.Equal(t, "faktory.example.com:7421", uriAddress(uri))
+	uri, _ = url.Parse("unix:///var/run/faktory.sock")
+	assert.Equal(t, "/var/run/faktory.sock", uriAddress(uri))
+}
+
+func TestReconnect(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	var accepted int32
+	conns := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&accepted, 1)
+			conns <- conn
+			go func() {
+				conn.SetDeadline(time.Now().Add(1 * time.Second))
+				conn.Write([]byte("+HI {\"v\":2}\r\n"))
+				buf := bufio.NewReader(conn)
+				for {
+					_, err := buf.ReadString('\n')
+					if err != nil {
+						conn.Close()
+						return
+					}
+					conn.Write([]byte("+OK\r\n"))
+				}
+			}()
+		}
+	}()
+
+	defer stubSRV(func(name string) ([]*net.SRV, error) {
+		return []*net.SRV{{Target: "localhost.", Port: uint16(port)}}, nil
+	})()
+
+	var dials, retries []error
+	commands := []string{}
+	srv := DefaultServer()
+	srv.Address = "_faktory._tcp.example.com"
+	srv.Hooks = &Hooks{
+		OnDial: func(address string, _ time.Duration, err error) {
+			assert.Equal(t, fmt.Sprintf("localhost:%d", port), address)
+			dials = append(dials, err)
+		},
+		OnRetry: func(attempt int, _ time.Duration, err error) {
+			retries = append(retries, err)
+		},
+		OnCommand: func(verb string, _ time.Duration, err error) {
+			commands = append(commands, fmt.Sprintf("%s %v", verb, err != nil))
+		},
+	}
+	cl, err := srv.Open()
+	assert.NoError(t, err)
+	assert.Equal(t, listener.Addr().String(), cl.conn.RemoteAddr().String())
+
+	// the server goes away
+	(<-conns).Close()
+	_, err = cl.Beat()
+	assert.Error(t, err)
+
+	assert.NoError(t, cl.Reconnect())
+	_, err = cl.Beat()
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&accepted))
+	assert.Equal(t, []error{nil, nil}, dials)
+	assert.Equal(t, []string{"BEAT true", "BEAT false"}, commands)
+
+	// the server is gone for good
+	attempts := ReconnectAttempts
+	ReconnectAttempts = 2
+	defer func() { ReconnectAttempts = attempts }()
+	listener.Close()
+	assert.Error(t, cl.Reconnect())
+	assert.Equal(t, 4, len(dials))
+	assert.Error(t, dials[3])
+	assert.Equal(t, 1, len(retries))
+	assert.Error(t, retries[0])
+
+	cl = &Client{}
+	assert.Error(t, cl.Reconnect())
+}
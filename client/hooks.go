@@ -0,0 +1,83 @@
+package client
+
+import (
+	"strings"
+	"time"
+)
+
+// Hooks feed the client's activity to the application's own metrics
+// system without wrapping every call site.  Each hook is optional and
+// called synchronously, so it should return quickly.
+type Hooks struct {
+	// OnDial is called after each attempt to connect to an address,
+	// err is nil if the connection was established.
+	OnDial func(address string, duration time.Duration, err error)
+	// OnRetry is called before Reconnect tries again, attempt
+	// counts from 1 and err is why the last attempt failed.
+	OnRetry func(attempt int, delay time.Duration, err error)
+	// OnCommand is called after each command, e.g. "PUSH", with the
+	// time until its response was read.  err is the network error or
+	// the server's error response, see ErrorCode.
+	OnCommand func(verb string, duration time.Duration, err error)
+}
+
+func (h *Hooks) dialed(address string, start time.Time, err error) {
+	if h != nil && h.OnDial != nil {
+		h.OnDial(address, time.Since(start), err)
+	}
+}
+
+func (h *Hooks) retrying(attempt int, delay time.Duration, err error) {
+	if h != nil && h.OnRetry != nil {
+		h.OnRetry(attempt, delay, err)
+	}
+}
+
+func (c *Client) hooks() *Hooks {
+	if c.srv == nil {
+		return nil
+	}
+	return c.srv.Hooks
+}
+
+// writeLine sends the command and starts timing it
+func (c *Client) writeLine(op string, payload []byte) error {
+	c.verb = op
+	if idx := strings.IndexByte(op, ' '); idx != -1 {
+		c.verb = op[:idx]
+	}
+	c.start = time.Now()
+
+	err := writeLine(c.wtr, op, payload)
+	if err != nil {
+		c.done(err)
+	}
+	return err
+}
+
+// done reports the command's latency to the hooks
+func (c *Client) done(err error) {
+	h := c.hooks()
+	if h != nil && h.OnCommand != nil && c.verb != "" {
+		h.OnCommand(c.verb, time.Since(c.start), err)
+	}
+	c.verb = ""
+}
+
+func (c *Client) ok() error {
+	err := ok(c.rdr)
+	c.done(err)
+	return err
+}
+
+func (c *Client) readResponse() ([]byte, error) {
+	data, err := readResponse(c.rdr)
+	c.done(err)
+	return data, err
+}
+
+func (c *Client) readString() (string, error) {
+	val, err := readString(c.rdr)
+	c.done(err)
+	return val, err
+}
@@ -14,6 +14,18 @@ type Failure struct {
 	ErrorMessage string   `json:"message,omitempty"`
 	ErrorType    string   `json:"errtype,omitempty"`
 	Backtrace    []string `json:"backtrace,omitempty"`
+	// the most recent failures, oldest first
+	Attempts []*Attempt `json:"attempts,omitempty"`
+}
+
+// Attempt is one failed execution of a job.
+type Attempt struct {
+	FailedAt string `json:"failed_at"`
+	// seconds the job ran before it failed
+	Runtime      float64  `json:"runtime,omitempty"`
+	ErrorMessage string   `json:"message,omitempty"`
+	ErrorType    string   `json:"errtype,omitempty"`
+	Backtrace    []string `json:"backtrace,omitempty"`
 }
 
 type Job struct {
@@ -32,6 +44,9 @@ type Job struct {
 	Backtrace  int                    `json:"backtrace,omitempty"`
 	Failure    *Failure               `json:"failure,omitempty"`
 	Custom     map[string]interface{} `json:"custom,omitempty"`
+
+	// Annotations are attached by workers during ACK or FAIL
+	Annotations map[string]string `json:"annotations,omitempty"`
 }
 
 func NewJob(jobtype string, args ...interface{}) *Job {
@@ -71,3 +86,28 @@ func (j *Job) SetCustom(name string, value interface{}) {
 
 	j.Custom[name] = value
 }
+
+// NextBootAttribute is the custom attribute which holds a job
+// until the server next starts, see Job.AtNextBoot.
+const NextBootAttribute = "next_boot"
+
+// AtNextBoot holds the job until the server next starts, when it's
+// dispatched ahead of every other job, e.g. to warm caches or run
+// migrations for the release being deployed.
+func (j *Job) AtNextBoot() {
+	j.SetCustom(NextBootAttribute, true)
+}
+
+// Annotate merges the given annotations into the job,
+// overwriting any existing values.
+func (j *Job) Annotate(annotations map[string]string) {
+	if len(annotations) == 0 {
+		return
+	}
+	if j.Annotations == nil {
+		j.Annotations = map[string]string{}
+	}
+	for k, v := range annotations {
+		j.Annotations[k] = v
+	}
+}
@@ -0,0 +1,56 @@
+package client
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// Commands for Mutate
+const (
+	// remove every job in the set
+	MutateClear = "clear"
+	// remove the matching jobs
+	MutateDiscard = "discard"
+	// move the matching jobs to the dead set
+	MutateKill = "kill"
+	// push the matching jobs to their queues now
+	MutateRequeue = "requeue"
+)
+
+// Mutation changes the jobs in the "retries", "scheduled" or
+// "dead" set which match the filter.
+type Mutation struct {
+	Cmd    string     `json:"cmd"`
+	Target string     `json:"target"`
+	Filter *JobFilter `json:"filter,omitempty"`
+}
+
+// JobFilter selects the jobs with one of the Jids, the Type and
+// a payload matching the Pattern, a glob such as `*"uid":1234*`.
+// Empty criteria match every job.
+type JobFilter struct {
+	Jids    []string `json:"jids,omitempty"`
+	Type    string   `json:"jobtype,omitempty"`
+	Pattern string   `json:"pattern,omitempty"`
+}
+
+// Mutate applies the mutation on the server, returning the
+// number of jobs changed, e.g. to kill the retries of a jobtype:
+//
+// cl.Mutate(client.Mutation{Cmd: client.MutateKill, Target: "retries", Filter: &client.JobFilter{Type: "SyncJob"}})
+func (c *Client) Mutate(op Mutation) (int, error) {
+	payload, err := json.Marshal(op)
+	if err != nil {
+		return 0, err
+	}
+	err = c.writeLine("MUTATE", payload)
+	if err != nil {
+		return 0, err
+	}
+
+	data, err := c.readResponse()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(string(data))
+}
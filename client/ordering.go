@@ -0,0 +1,12 @@
+package client
+
+// OrderKeyAttribute is the custom attribute which orders jobs,
+// see Job.SetOrderKey.
+const OrderKeyAttribute = "order_key"
+
+// SetOrderKey runs the job after every job pushed before it with the
+// same key, e.g. an account ID, and never alongside them.  Jobs with
+// different keys, or none, run as usual.
+func (j *Job) SetOrderKey(key string) {
+	j.SetCustom(OrderKeyAttribute, key)
+}
@@ -0,0 +1,202 @@
+package client
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyFromEnvironment(t *testing.T) {
+	for _, name := range []string{"HTTPS_PROXY", "https_proxy", "ALL_PROXY", "all_proxy", "NO_PROXY", "no_proxy"} {
+		defer os.Setenv(name, os.Getenv(name))
+		os.Unsetenv(name)
+	}
+
+	uri, err := ProxyFromEnvironment("faktory.example.com:7419")
+	assert.NoError(t, err)
+	assert.Nil(t, uri)
+
+	os.Setenv("ALL_PROXY", "socks5://proxy.example.com:1080")
+	uri, err = ProxyFromEnvironment("faktory.example.com:7419")
+	assert.NoError(t, err)
+	assert.Equal(t, "socks5://proxy.example.com:1080", uri.String())
+
+	os.Setenv("HTTPS_PROXY", "proxy.example.com:3128")
+	uri, err = ProxyFromEnvironment("faktory.example.com:7419")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://proxy.example.com:3128", uri.String())
+
+	for _, addr := range []string{"localhost:7419", "127.0.0.1:7419", "[::1]:7419"} {
+		uri, err = ProxyFromEnvironment(addr)
+		assert.NoError(t, err)
+		assert.Nil(t, uri, addr)
+	}
+
+	os.Setenv("NO_PROXY", "internal, .example.com")
+	for _, addr := range []string{"faktory.example.com:7419", "example.com:7419", "faktory.internal:7419"} {
+		uri, err = ProxyFromEnvironment(addr)
+		assert.NoError(t, err)
+		assert.Nil(t, uri, addr)
+	}
+	uri, err = ProxyFromEnvironment("faktory.example.org:7419")
+	assert.NoError(t, err)
+	assert.NotNil(t, uri)
+}
+
+func TestProxyDial(t *testing.T) {
+	t.Run("HTTP", func(t *testing.T) {
+		withFakeProxy(t, httpProxy, func(proxy string) {
+			withFakeServer(t, func(req, resp chan string, addr string) {
+				srv := DefaultServer()
+				srv.Address = addr
+				srv.Proxy, _ = url.Parse("http://user:secret@" + proxy)
+
+				resp <- "+OK\r\n"
+				cl, err := srv.Open()
+				assert.NoError(t, err)
+				assert.Contains(t, <-req, "HELLO")
+
+				resp <- "+OK\r\n"
+				assert.NoError(t, cl.Close())
+				assert.Contains(t, <-req, "END")
+			})
+		})
+	})
+
+	t.Run("SOCKS5", func(t *testing.T) {
+		withFakeProxy(t, socksProxy, func(proxy string) {
+			withFakeServer(t, func(req, resp chan string, addr string) {
+				srv := DefaultServer()
+				srv.Address = addr
+				srv.Proxy, _ = url.Parse("socks5://user:secret@" + proxy)
+
+				resp <- "+OK\r\n"
+				cl, err := srv.Open()
+				assert.NoError(t, err)
+				assert.Contains(t, <-req, "HELLO")
+
+				resp <- "+OK\r\n"
+				assert.NoError(t, cl.Close())
+				assert.Contains(t, <-req, "END")
+			})
+		})
+	})
+
+	t.Run("Refused", func(t *testing.T) {
+		withFakeProxy(t, httpProxy, func(proxy string) {
+			srv := DefaultServer()
+			srv.Address = "localhost:1"
+			srv.Proxy, _ = url.Parse("http://user:secret@" + proxy)
+
+			cl, err := srv.Open()
+			assert.Error(t, err)
+			assert.Nil(t, cl)
+		})
+	})
+}
+
+// withFakeProxy runs a proxy which tunnels to the address
+// negotiated by the given handshake
+func withFakeProxy(t *testing.T, handshake func(net.Conn) (net.Conn, error), fn func(string)) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				remote, err := handshake(conn)
+				if err != nil {
+					return
+				}
+				defer remote.Close()
+				go io.Copy(remote, conn)
+				io.Copy(conn, remote)
+			}()
+		}
+	}()
+
+	fn(listener.Addr().String())
+}
+
+func httpProxy(conn net.Conn) (net.Conn, error) {
+	req, err := http.ReadRequest(bufio.NewReader(conn))
+	if err != nil {
+		return nil, err
+	}
+	if req.Method != "CONNECT" || req.Header.Get("Proxy-Authorization") == "" {
+		conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+		return nil, fmt.Errorf("Unexpected %s", req.Method)
+	}
+	remote, err := net.Dial("tcp", req.Host)
+	if err != nil {
+		conn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+		return nil, err
+	}
+	conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	return remote, nil
+}
+
+func socksProxy(conn net.Conn) (net.Conn, error) {
+	buf := make([]byte, 256)
+	_, err := io.ReadFull(conn, buf[:3])
+	if err != nil {
+		return nil, err
+	}
+	if buf[2] != socksPasswordAuth {
+		conn.Write([]byte{socksVersion, socksNoAcceptable})
+		return nil, fmt.Errorf("Unexpected method %d", buf[2])
+	}
+	conn.Write([]byte{socksVersion, socksPasswordAuth})
+
+	// version, username and password
+	for _, field := range []bool{false, true, true} {
+		_, err = io.ReadFull(conn, buf[:1])
+		if err != nil {
+			return nil, err
+		}
+		if field {
+			_, err = io.ReadFull(conn, buf[:buf[0]])
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	conn.Write([]byte{1, 0})
+
+	_, err = io.ReadFull(conn, buf[:5])
+	if err != nil {
+		return nil, err
+	}
+	if buf[3] != socksDomain {
+		return nil, fmt.Errorf("Unexpected address type %d", buf[3])
+	}
+	n := int(buf[4])
+	_, err = io.ReadFull(conn, buf[:n+2])
+	if err != nil {
+		return nil, err
+	}
+	port := binary.BigEndian.Uint16(buf[n : n+2])
+
+	remote, err := net.Dial("tcp", net.JoinHostPort(string(buf[:n]), strconv.Itoa(int(port))))
+	if err != nil {
+		conn.Write([]byte{socksVersion, 5, 0, socksIPv4, 0, 0, 0, 0, 0, 0})
+		return nil, err
+	}
+	conn.Write([]byte{socksVersion, 0, 0, socksIPv4, 0, 0, 0, 0, 0, 0})
+	return remote, nil
+}
@@ -0,0 +1,40 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// AckWithResult acknowledges the job with a small result, any value
+// which marshals to JSON, for producers to read with Result.
+func (c *Client) AckWithResult(jid string, result interface{}) error {
+	payload, err := json.Marshal(map[string]interface{}{
+		"jid":    jid,
+		"result": result,
+	})
+	if err != nil {
+		return err
+	}
+	err = c.writeLine("ACK", payload)
+	if err != nil {
+		return err
+	}
+
+	return c.ok()
+}
+
+// Result returns the JSON result the job was acknowledged with, nil
+// if it has none or the result has expired.  Unmarshal it into the
+// type the worker sent.
+func (c *Client) Result(jid string) (json.RawMessage, error) {
+	err := c.writeLine("RESULT", []byte(fmt.Sprintf("GET %s", jid)))
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := c.readResponse()
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+	return json.RawMessage(data), nil
+}
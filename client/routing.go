@@ -0,0 +1,22 @@
+package client
+
+// RegionAttribute and ShardKeyAttribute are the custom attributes
+// which hint where a job should run, see Job.SetRegion and
+// Job.SetShardKey.
+const (
+	RegionAttribute   = "region"
+	ShardKeyAttribute = "shard_key"
+)
+
+// SetRegion runs the job in the region, one of the server's
+// [routing] links, whatever its queue is routed to.
+func (j *Job) SetRegion(region string) {
+	j.SetCustom(RegionAttribute, region)
+}
+
+// SetShardKey runs the job in the region the server assigns to the
+// key, e.g. a tenant ID, so every job with the key runs in the same
+// region without the producer knowing which one.
+func (j *Job) SetShardKey(key string) {
+	j.SetCustom(ShardKeyAttribute, key)
+}
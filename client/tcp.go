@@ -0,0 +1,75 @@
+package client
+
+import (
+	"net"
+	"time"
+)
+
+// TCPOptions tunes a TCP connection.  The OS defaults can leave a
+// dead connection undetected for a long time, e.g. when a load balancer
+// silently drops idle connections, a shorter keep-alive period finds
+// those quickly.
+type TCPOptions struct {
+	// KeepAlive is the period between keep-alive probes.  Zero
+	// enables keep-alive with the OS default period, negative
+	// disables keep-alive.
+	KeepAlive time.Duration
+	// NoDelay disables Nagle's algorithm so small writes are
+	// sent immediately.
+	NoDelay bool
+	// ReadBuffer and WriteBuffer are the socket buffer sizes
+	// in bytes, zero keeps the OS default.
+	ReadBuffer  int
+	WriteBuffer int
+}
+
+// DefaultTCPOptions probes idle connections every 30 seconds.
+func DefaultTCPOptions() *TCPOptions {
+	return &TCPOptions{KeepAlive: 30 * time.Second, NoDelay: true}
+}
+
+// Apply sets the options on the connection, connections
+// which aren't TCP are left alone.
+func (o *TCPOptions) Apply(conn net.Conn) error {
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+
+	err := tc.SetKeepAlive(o.KeepAlive >= 0)
+	if err != nil {
+		return err
+	}
+	if o.KeepAlive > 0 {
+		err = tc.SetKeepAlivePeriod(o.KeepAlive)
+		if err != nil {
+			return err
+		}
+	}
+	err = tc.SetNoDelay(o.NoDelay)
+	if err != nil {
+		return err
+	}
+	if o.ReadBuffer > 0 {
+		err = tc.SetReadBuffer(o.ReadBuffer)
+		if err != nil {
+			return err
+		}
+	}
+	if o.WriteBuffer > 0 {
+		err = tc.SetWriteBuffer(o.WriteBuffer)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tune applies the server's TCP options to a new connection,
+// without options keep-alive is enabled with the OS defaults.
+func (s *Server) tune(conn net.Conn) error {
+	if s.TCP == nil {
+		return (&TCPOptions{NoDelay: true}).Apply(conn)
+	}
+	return s.TCP.Apply(conn)
+}
@@ -0,0 +1,45 @@
+package client
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTCPOptions(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	opts := &TCPOptions{KeepAlive: 10 * time.Second, NoDelay: true, ReadBuffer: 64 * 1024, WriteBuffer: 64 * 1024}
+	assert.NoError(t, opts.Apply(conn))
+	opts = &TCPOptions{KeepAlive: -1}
+	assert.NoError(t, opts.Apply(conn))
+
+	// not a TCP connection
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	assert.NoError(t, DefaultTCPOptions().Apply(a))
+
+	withFakeServer(t, func(req, resp chan string, addr string) {
+		srv := DefaultServer()
+		srv.Address = addr
+		srv.TCP = DefaultTCPOptions()
+
+		resp <- "+OK\r\n"
+		cl, err := srv.Open()
+		assert.NoError(t, err)
+		assert.Contains(t, <-req, "HELLO")
+
+		resp <- "+OK\r\n"
+		assert.NoError(t, cl.Close())
+		assert.Contains(t, <-req, "END")
+	})
+}
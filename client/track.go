@@ -0,0 +1,124 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// TrackAttribute is the custom attribute which opts a job into
+// status tracking, see Job.Track.
+const TrackAttribute = "track"
+
+// The states reported for a tracked job.  StateUnknown is
+// returned for jobs which aren't tracked or whose status has
+// expired.
+const (
+	StateUnknown  = "unknown"
+	StateEnqueued = "enqueued"
+	StateWorking  = "working"
+	StateRetrying = "retrying"
+	StateSuccess  = "success"
+	StateDead     = "dead"
+)
+
+// TrackInterval is how often TrackSubscribe polls the server.
+var TrackInterval = 1 * time.Second
+
+type JobStatus struct {
+	Jid     string `json:"jid"`
+	State   string `json:"state"`
+	Percent int    `json:"percent"`
+	// the worker's message for the user, see TrackSet
+	Desc      string `json:"desc,omitempty"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// Done is true once the job has succeeded or died, its
+// status won't change again.
+func (js *JobStatus) Done() bool {
+	return js.State == StateSuccess || js.State == StateDead
+}
+
+// Track asks the server to record the job's status as it is
+// processed so it can be queried with TrackGet.
+func (j *Job) Track() {
+	j.SetCustom(TrackAttribute, true)
+}
+
+// TrackGet returns the current status of the given job.
+func (c *Client) TrackGet(jid string) (*JobStatus, error) {
+	err := c.writeLine("TRACK", []byte(fmt.Sprintf(`GET {"jid":%q}`, jid)))
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := c.readResponse()
+	if err != nil {
+		return nil, err
+	}
+
+	var status JobStatus
+	err = json.Unmarshal(data, &status)
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// TrackSet reports the progress of a job the caller is executing,
+// percent complete and a message for the user, e.g. "Exported 400
+// of 1000 rows".  Producers see it with TrackGet.
+func (c *Client) TrackSet(jid string, percent int, desc string) error {
+	payload, err := json.Marshal(map[string]interface{}{"jid": jid, "percent": percent, "desc": desc})
+	if err != nil {
+		return err
+	}
+	err = c.writeLine("TRACK", append([]byte("SET "), payload...))
+	if err != nil {
+		return err
+	}
+	return c.ok()
+}
+
+// TrackSubscribe opens a new connection, configured like Open,
+// and returns a channel which receives the job's status every time
+// its state or progress changes.  The channel is closed once the job
+// has succeeded or died, or if the connection fails.
+//
+//   updates, err := client.TrackSubscribe(job.Jid)
+//   for status := range updates {
+//     fmt.Println(status.State)
+//   }
+func TrackSubscribe(jid string) (<-chan *JobStatus, error) {
+	cl, err := Open()
+	if err != nil {
+		return nil, err
+	}
+	updates := make(chan *JobStatus)
+	go func() {
+		defer cl.Close()
+		subscribe(cl, jid, TrackInterval, updates)
+	}()
+	return updates, nil
+}
+
+func subscribe(c *Client, jid string, interval time.Duration, updates chan<- *JobStatus) {
+	defer close(updates)
+
+	var last JobStatus
+	for {
+		status, err := c.TrackGet(jid)
+		if err != nil {
+			return
+		}
+		if status.State != last.State || status.Percent != last.Percent || status.Desc != last.Desc {
+			last = *status
+			updates <- status
+		}
+		if status.Done() {
+			return
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,26 @@
+package client
+
+import "time"
+
+// UniqueForAttribute and UniqueUntilAttribute are the custom attributes
+// which stop duplicate pushes of a job, see Job.SetUniqueFor.
+const (
+	UniqueForAttribute   = "unique_for"
+	UniqueUntilAttribute = "unique_until"
+)
+
+// The points at which a unique job's lock is released
+const (
+	// the job is fetched by a worker
+	UniqueUntilStart = "start"
+	// the job succeeds, the default
+	UniqueUntilSuccess = "success"
+)
+
+// SetUniqueFor rejects pushes of jobs with the same jobtype and args
+// as this one for the duration, or until this job is fetched or
+// succeeds, see UniqueUntilStart and UniqueUntilSuccess.
+func (j *Job) SetUniqueFor(window time.Duration, until string) {
+	j.SetCustom(UniqueForAttribute, int(window/time.Second))
+	j.SetCustom(UniqueUntilAttribute, until)
+}
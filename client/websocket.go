@@ -0,0 +1,344 @@
+package client
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * Workers which can only make HTTP requests, e.g. behind a corporate
+ * proxy, may tunnel the protocol through a WebSocket to the Web UI's
+ * binding.  The ws and wss schemes dial the Web UI rather than the
+ * command port and honor HTTPS_PROXY like tcp:
+ *
+ *   FAKTORY_URL=wss://:mypassword@faktory.example.com:443
+ *
+ * The commands and responses are sent as they would be over TCP,
+ * split across binary messages as they're written.  See RFC 6455.
+ */
+const (
+	// WebSocketPath is where the Web UI accepts WebSockets
+	WebSocketPath = "/ws"
+	// WebSocketProtocol is the subprotocol the client asks for
+	WebSocketProtocol = "faktory"
+)
+
+// RFC 6455 constants
+const (
+	wsGUID         = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	wsContinuation = 0
+	wsText         = 1
+	wsBinary       = 2
+	wsClose        = 8
+	wsPing         = 9
+	wsPong         = 10
+	wsFinal        = 0x80
+	wsMasked       = 0x80
+	// control frames can't be fragmented or carry more
+	maxControlPayload = 125
+)
+
+func isWebSocket(network string) bool {
+	return network == "ws" || network == "wss"
+}
+
+// webSocketAddress adds the default port for the scheme
+func webSocketAddress(network, address string) string {
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return address
+	}
+	if network == "wss" {
+		return net.JoinHostPort(address, "443")
+	}
+	return net.JoinHostPort(address, "80")
+}
+
+func acceptKey(key string) string {
+	digest := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(digest[:])
+}
+
+// upgradeWebSocket performs the client's side of the
+// handshake over the connection to the Web UI
+func (s *Server) upgradeWebSocket(conn net.Conn, address string) (net.Conn, error) {
+	if s.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(s.Timeout))
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	nonce := make([]byte, 16)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return conn, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+	req := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{Path: WebSocketPath},
+		Host:   address,
+		Header: http.Header{},
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Protocol", WebSocketProtocol)
+	err = req.Write(conn)
+	if err != nil {
+		return conn, err
+	}
+
+	rdr := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(rdr, req)
+	if err != nil {
+		return conn, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return conn, fmt.Errorf("Unable to open a WebSocket to %s: %s", address, resp.Status)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		return conn, fmt.Errorf("Unable to open a WebSocket to %s: invalid Sec-WebSocket-Accept", address)
+	}
+	return &wsConn{Conn: conn, rdr: rdr, client: true}, nil
+}
+
+// AcceptWebSocket performs the server's side of the handshake,
+// returning the connection to speak the protocol over.  Requests
+// from browsers on other sites are refused.
+func AcceptWebSocket(w http.ResponseWriter, req *http.Request) (net.Conn, error) {
+	if !strings.EqualFold(req.Header.Get("Upgrade"), "websocket") || !headerHas(req.Header, "Connection", "upgrade") {
+		w.Header().Set("Upgrade", "websocket")
+		http.Error(w, "WebSocket upgrade required", http.StatusUpgradeRequired)
+		return nil, fmt.Errorf("not a WebSocket upgrade")
+	}
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusBadRequest)
+		return nil, fmt.Errorf("unsupported WebSocket version %q", req.Header.Get("Sec-WebSocket-Version"))
+	}
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "Missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, fmt.Errorf("missing Sec-WebSocket-Key")
+	}
+	// workers don't send an Origin, browsers do
+	if origin := req.Header.Get("Origin"); origin != "" {
+		uri, err := url.Parse(origin)
+		if err != nil || !strings.EqualFold(uri.Host, req.Host) {
+			http.Error(w, "Cross-origin WebSocket refused", http.StatusForbidden)
+			return nil, fmt.Errorf("cross-origin WebSocket from %s", origin)
+		}
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSockets are unsupported", http.StatusInternalServerError)
+		return nil, fmt.Errorf("the ResponseWriter can't be hijacked")
+	}
+
+	conn, brw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	// clear the HTTP server's timeouts
+	conn.SetDeadline(time.Time{})
+	fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n", acceptKey(key))
+	if headerHas(req.Header, "Sec-WebSocket-Protocol", WebSocketProtocol) {
+		fmt.Fprintf(brw, "Sec-WebSocket-Protocol: %s\r\n", WebSocketProtocol)
+	}
+	brw.WriteString("\r\n")
+	err = brw.Flush()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{Conn: conn, rdr: brw.Reader}, nil
+}
+
+// headerHas checks a comma separated header for the token
+func headerHas(header http.Header, name string, token string) bool {
+	for _, val := range header[http.CanonicalHeaderKey(name)] {
+		for _, elm := range strings.Split(val, ",") {
+			if strings.EqualFold(strings.TrimSpace(elm), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsConn carries a byte stream over WebSocket messages so the
+// protocol code needn't know it isn't speaking TCP
+type wsConn struct {
+	net.Conn
+	rdr *bufio.Reader
+	// clients mask the frames they send
+	client bool
+
+	// the data frame being read
+	remaining int64
+	masked    bool
+	mask      [4]byte
+	maskPos   int
+
+	wmu    sync.Mutex
+	closed bool
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		err := c.nextFrame()
+		if err != nil {
+			return 0, err
+		}
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.rdr.Read(p)
+	if c.masked {
+		for idx := 0; idx < n; idx++ {
+			p[idx] ^= c.mask[c.maskPos%4]
+			c.maskPos++
+		}
+	}
+	c.remaining -= int64(n)
+	return n, err
+}
+
+// nextFrame reads frame headers until a data frame with a
+// payload, answering pings and closes along the way
+func (c *wsConn) nextFrame() error {
+	header := make([]byte, 2)
+	_, err := io.ReadFull(c.rdr, header)
+	if err != nil {
+		return err
+	}
+	opcode := header[0] & 0x0f
+	masked := header[1]&wsMasked != 0
+	length := int64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		_, err = io.ReadFull(c.rdr, ext)
+		length = int64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		_, err = io.ReadFull(c.rdr, ext)
+		length = int64(binary.BigEndian.Uint64(ext))
+	}
+	if err != nil {
+		return err
+	}
+	if length < 0 {
+		return fmt.Errorf("Invalid WebSocket frame length")
+	}
+	if masked == c.client {
+		// each side masks only the frames it sends
+		return fmt.Errorf("Invalid WebSocket frame masking")
+	}
+	var mask [4]byte
+	if masked {
+		_, err = io.ReadFull(c.rdr, mask[:])
+		if err != nil {
+			return err
+		}
+	}
+
+	switch opcode {
+	case wsContinuation, wsText, wsBinary:
+		c.remaining, c.masked, c.mask, c.maskPos = length, masked, mask, 0
+		return nil
+	case wsClose, wsPing, wsPong:
+		if length > maxControlPayload {
+			return fmt.Errorf("Invalid WebSocket control frame")
+		}
+		payload := make([]byte, length)
+		_, err = io.ReadFull(c.rdr, payload)
+		if err != nil {
+			return err
+		}
+		for idx := range payload {
+			payload[idx] ^= mask[idx%4]
+		}
+		switch opcode {
+		case wsClose:
+			c.writeFrame(wsClose, payload)
+			return io.EOF
+		case wsPing:
+			return c.writeFrame(wsPong, payload)
+		}
+		return nil
+	default:
+		return fmt.Errorf("Unexpected WebSocket opcode %d", opcode)
+	}
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	err := c.writeFrame(wsBinary, p)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return io.ErrClosedPipe
+	}
+	if opcode == wsClose {
+		c.closed = true
+	}
+
+	frame := make([]byte, 2, 14+len(payload))
+	frame[0] = wsFinal | opcode
+	length := len(payload)
+	switch {
+	case length < 126:
+		frame[1] = byte(length)
+	case length <= 0xffff:
+		frame[1] = 126
+		frame = append(frame, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(length))
+	default:
+		frame[1] = 127
+		frame = append(frame, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(length))
+	}
+	if !c.client {
+		frame = append(frame, payload...)
+	} else {
+		var mask [4]byte
+		_, err := rand.Read(mask[:])
+		if err != nil {
+			return err
+		}
+		frame[1] |= wsMasked
+		frame = append(frame, mask[:]...)
+		for idx, b := range payload {
+			frame = append(frame, b^mask[idx%4])
+		}
+	}
+	_, err := c.Conn.Write(frame)
+	return err
+}
+
+// Close sends a close frame, status 1000, before
+// closing the connection
+func (c *wsConn) Close() error {
+	c.writeFrame(wsClose, []byte{0x03, 0xe8})
+	return c.Conn.Close()
+}
@@ -0,0 +1,76 @@
+package client
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebSocket(t *testing.T) {
+	lines := make(chan string, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, WebSocketPath, r.URL.Path)
+		conn, err := AcceptWebSocket(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		conn.Write([]byte("+HI {\"v\":2}\r\n"))
+		rdr := bufio.NewReader(conn)
+		for {
+			line, err := rdr.ReadString('\n')
+			if err != nil {
+				close(lines)
+				return
+			}
+			lines <- strings.TrimSpace(line)
+			// pings are answered while reading
+			assert.NoError(t, conn.(*wsConn).writeFrame(wsPing, []byte("ping")))
+			conn.Write([]byte("+OK\r\n"))
+		}
+	}))
+	defer ts.Close()
+
+	srv := DefaultServer()
+	srv.Network = "ws"
+	srv.Address = strings.TrimPrefix(ts.URL, "http://")
+	cl, err := srv.Open()
+	assert.NoError(t, err)
+	assert.Contains(t, <-lines, "HELLO {")
+
+	// large enough for a 64-bit frame length
+	payload := strings.Repeat("x", 70000)
+	resp, err := cl.Generic("ECHO " + payload)
+	assert.NoError(t, err)
+	assert.Equal(t, "OK", resp)
+	assert.Equal(t, "ECHO "+payload, <-lines)
+
+	assert.NoError(t, cl.Close())
+	assert.Equal(t, "END", <-lines)
+	_, ok := <-lines
+	assert.False(t, ok)
+
+	// browsers on other sites are refused
+	req, err := http.NewRequest("GET", ts.URL+WebSocketPath, nil)
+	assert.NoError(t, err)
+	for key, val := range map[string]string{"Upgrade": "websocket", "Connection": "keep-alive, Upgrade", "Sec-WebSocket-Version": "13",
+		"Sec-WebSocket-Key": "dGhlIHNhbXBsZSBub25jZQ==", "Origin": "http://evil.example.com"} {
+		req.Header.Set(key, val)
+	}
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	res, err = http.Get(ts.URL + WebSocketPath)
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusUpgradeRequired, res.StatusCode)
+
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", acceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+}
@@ -5,8 +5,10 @@ import (
 	"log"
 	"time"
 
+	"github.com/contribsys/faktory/bridge"
 	"github.com/contribsys/faktory/cli"
 	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/server"
 	"github.com/contribsys/faktory/util"
 	"github.com/contribsys/faktory/webui"
 )
@@ -23,6 +25,18 @@ func main() {
 
 	opts := cli.ParseArguments()
 	util.InitLogger(opts.LogLevel)
+	err := util.SetLogFormat(opts.LogFormat)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if opts.LogFile != "" {
+		err = util.SetLogFile(opts.LogFile)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+	}
 	util.Debugf("Options: %v", opts)
 
 	s, stopper, err := cli.BuildServer(opts)
@@ -42,10 +56,40 @@ func main() {
 	}
 
 	s.Register(webui.Subsystem(opts.WebBinding))
+	// park ordered jobs before other middleware sees the fetch
+	s.Register(server.OrderingSubsystem())
+	// transform args before they're encrypted
+	s.Register(server.TransformsSubsystem())
+	// lock on the args as pushed, not encrypted
+	s.Register(server.UniqueSubsystem())
+	// encrypt before other middleware can copy the payload
+	s.Register(server.EncryptionSubsystem())
+	s.Register(server.SheddingSubsystem())
+	s.Register(server.OffloadSubsystem())
+	s.Register(bridge.Subsystem())
+	s.Register(server.MirrorSubsystem())
+	s.Register(server.RoutingSubsystem())
+	s.Register(server.TrackingSubsystem())
+	s.Register(server.ResultsSubsystem())
+	s.Register(server.LineageSubsystem())
+	s.Register(server.BatchSubsystem())
+	s.Register(server.DebounceSubsystem())
+	s.Register(server.NextBootSubsystem())
+	s.Register(server.MetricsSubsystem())
+	s.Register(server.DuplicatesSubsystem())
+	s.Register(server.PruningSubsystem())
+	s.Register(server.AlertsSubsystem())
+	s.Register(server.SamplingSubsystem())
 
 	go cli.HandleSignals(s)
-	go s.Run()
+	go func() {
+		err := s.Run()
+		if err != nil {
+			util.Error("Unable to start the server", err)
+			s.Shutdown()
+		}
+	}()
 
-	<-s.Stopper()
-	s.Stop(nil)
+	<-s.Context().Done()
+	s.Stop(s.Drain)
 }
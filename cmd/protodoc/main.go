@@ -0,0 +1,29 @@
+// protodoc writes the protocol reference generated from
+// server/protocol.go, run by `go generate` in server:
+//
+//	go run cmd/protodoc/main.go docs/protocol-reference.md
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/contribsys/faktory/server"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatalf("Usage: %s [output file]", os.Args[0])
+	}
+	file, err := os.Create(os.Args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = server.Protocol().WriteMarkdown(file)
+	if err == nil {
+		err = file.Close()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,300 @@
+// Package graphql executes read-only GraphQL queries against a schema of
+// resolver functions.  It supports the subset of the language dashboards
+// need: fields, aliases, arguments, variables and nested selections.
+// Fragments, directives, mutations and introspection beyond __typename
+// are not supported.
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// MaxDepth limits how deeply selections may nest so a
+// single query can't walk the whole server.
+const MaxDepth = 10
+
+type Schema struct {
+	Query *Object
+}
+
+// Object is a GraphQL object type.  Types may refer to each
+// other, fill in Fields after declaring them.
+type Object struct {
+	Name   string
+	Fields map[string]*Field
+}
+
+type Field struct {
+	// Type is the object type the field resolves to, a field
+	// without a Type is a scalar and is returned as JSON.
+	// Slices resolve to lists of the type.
+	Type    *Object
+	Resolve func(ctx context.Context, source interface{}, args Args) (interface{}, error)
+}
+
+// Args are the field's arguments with variables substituted.
+type Args map[string]interface{}
+
+func (a Args) String(name string, def string) string {
+	if val, ok := a[name].(string); ok {
+		return val
+	}
+	return def
+}
+
+func (a Args) Int(name string, def int) int {
+	switch val := a[name].(type) {
+	case int:
+		return val
+	case float64:
+		// JSON variables are decoded as floats
+		return int(val)
+	}
+	return def
+}
+
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+type Error struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+type Response struct {
+	Data   interface{} `json:"data"`
+	Errors []*Error    `json:"errors,omitempty"`
+}
+
+// result keeps the fields in the order they were selected.
+type result struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (r *result) set(key string, val interface{}) {
+	if _, ok := r.values[key]; !ok {
+		r.keys = append(r.keys, key)
+	}
+	r.values[key] = val
+}
+
+func (r *result) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for idx, key := range r.keys {
+		if idx > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(key)
+		buf.Write(name)
+		buf.WriteByte(':')
+		val, err := json.Marshal(r.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(val)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+type execution struct {
+	ctx    context.Context
+	vars   map[string]interface{}
+	errors []*Error
+}
+
+// Execute runs the request's operation.  Errors in the query fail the
+// whole request, errors from resolvers null the field and are listed
+// alongside the data.
+func (s *Schema) Execute(ctx context.Context, req *Request) *Response {
+	op, vars, err := s.prepare(req)
+	if err != nil {
+		return &Response{Errors: []*Error{{Message: err.Error()}}}
+	}
+
+	e := &execution{ctx: ctx, vars: vars}
+	data := e.object(s.Query, nil, op.Selections, nil)
+	return &Response{Data: data, Errors: e.errors}
+}
+
+func (s *Schema) prepare(req *Request) (*operation, map[string]interface{}, error) {
+	ops, err := parse(req.Query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var op *operation
+	if req.OperationName == "" {
+		if len(ops) > 1 {
+			return nil, nil, fmt.Errorf("operationName is required when the query has several operations")
+		}
+		op = ops[0]
+	} else {
+		for _, o := range ops {
+			if o.Name == req.OperationName {
+				op = o
+			}
+		}
+		if op == nil {
+			return nil, nil, fmt.Errorf("Unknown operation %q", req.OperationName)
+		}
+	}
+
+	vars := map[string]interface{}{}
+	for _, def := range op.Variables {
+		val, ok := req.Variables[def.Name]
+		if !ok || val == nil {
+			if def.Required && def.Default == nil {
+				return nil, nil, fmt.Errorf("Variable $%s is required", def.Name)
+			}
+			val = def.Default
+		}
+		vars[def.Name] = val
+	}
+
+	err = validate(s.Query, op.Selections, vars, 1)
+	if err != nil {
+		return nil, nil, err
+	}
+	return op, vars, nil
+}
+
+func validate(obj *Object, sels []*selection, vars map[string]interface{}, depth int) error {
+	if depth > MaxDepth {
+		return fmt.Errorf("Query is nested more than %d levels deep", MaxDepth)
+	}
+	for _, sel := range sels {
+		if sel.Name == "__typename" {
+			if sel.Selections != nil {
+				return fmt.Errorf("Field __typename is a scalar and can't have a selection")
+			}
+			continue
+		}
+		field, ok := obj.Fields[sel.Name]
+		if !ok {
+			return fmt.Errorf("Cannot query field %q on type %q", sel.Name, obj.Name)
+		}
+		for _, arg := range sel.Args {
+			err := checkVariables(arg, vars)
+			if err != nil {
+				return err
+			}
+		}
+		if field.Type == nil {
+			if sel.Selections != nil {
+				return fmt.Errorf("Field %q of type %q is a scalar and can't have a selection", sel.Name, obj.Name)
+			}
+			continue
+		}
+		if sel.Selections == nil {
+			return fmt.Errorf("Field %q of type %q must have a selection of subfields", sel.Name, obj.Name)
+		}
+		err := validate(field.Type, sel.Selections, vars, depth+1)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkVariables(val interface{}, vars map[string]interface{}) error {
+	switch v := val.(type) {
+	case variable:
+		if _, ok := vars[string(v)]; !ok {
+			return fmt.Errorf("Variable $%s is not defined", string(v))
+		}
+	case []interface{}:
+		for _, item := range v {
+			err := checkVariables(item, vars)
+			if err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		for _, item := range v {
+			err := checkVariables(item, vars)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (e *execution) substitute(val interface{}) interface{} {
+	switch v := val.(type) {
+	case variable:
+		return e.vars[string(v)]
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for idx, item := range v {
+			list[idx] = e.substitute(item)
+		}
+		return list
+	case map[string]interface{}:
+		obj := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			obj[key] = e.substitute(item)
+		}
+		return obj
+	}
+	return val
+}
+
+func (e *execution) object(obj *Object, source interface{}, sels []*selection, path []interface{}) *result {
+	res := &result{values: map[string]interface{}{}}
+	for _, sel := range sels {
+		key := sel.key()
+		if sel.Name == "__typename" {
+			res.set(key, obj.Name)
+			continue
+		}
+
+		field := obj.Fields[sel.Name]
+		args := Args{}
+		for name, val := range sel.Args {
+			args[name] = e.substitute(val)
+		}
+		fieldPath := append(append([]interface{}{}, path...), key)
+		val, err := field.Resolve(e.ctx, source, args)
+		if err != nil {
+			e.errors = append(e.errors, &Error{Message: err.Error(), Path: fieldPath})
+			res.set(key, nil)
+			continue
+		}
+		res.set(key, e.complete(field.Type, val, sel.Selections, fieldPath))
+	}
+	return res
+}
+
+func (e *execution) complete(typ *Object, val interface{}, sels []*selection, path []interface{}) interface{} {
+	if val == nil {
+		return nil
+	}
+	rv := reflect.ValueOf(val)
+	if (rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Map) && rv.IsNil() {
+		return nil
+	}
+	if typ == nil {
+		return val
+	}
+
+	if rv.Kind() == reflect.Slice {
+		list := make([]interface{}, rv.Len())
+		for idx := range list {
+			itemPath := append(append([]interface{}{}, path...), idx)
+			list[idx] = e.complete(typ, rv.Index(idx).Interface(), sels, itemPath)
+		}
+		return list
+	}
+	return e.object(typ, val, sels, path)
+}
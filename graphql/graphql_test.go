@@ -0,0 +1,129 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type book struct {
+	Title  string
+	Pages  int
+	Author *author
+}
+
+type author struct {
+	Name string
+}
+
+func testSchema() *Schema {
+	authorType := &Object{Name: "Author", Fields: map[string]*Field{
+		"name": {Resolve: func(_ context.Context, src interface{}, _ Args) (interface{}, error) {
+			return src.(*author).Name, nil
+		}},
+	}}
+	bookType := &Object{Name: "Book", Fields: map[string]*Field{
+		"title": {Resolve: func(_ context.Context, src interface{}, _ Args) (interface{}, error) {
+			return src.(*book).Title, nil
+		}},
+		"pages": {Resolve: func(_ context.Context, src interface{}, _ Args) (interface{}, error) {
+			if src.(*book).Pages == 0 {
+				return nil, fmt.Errorf("Unknown length")
+			}
+			return src.(*book).Pages, nil
+		}},
+		"author": {Type: authorType, Resolve: func(_ context.Context, src interface{}, _ Args) (interface{}, error) {
+			return src.(*book).Author, nil
+		}},
+	}}
+
+	books := []*book{
+		{"Dune", 412, &author{"Frank Herbert"}},
+		{"Anonymous", 0, nil},
+		{"Emma", 474, &author{"Jane Austen"}},
+	}
+	return &Schema{Query: &Object{Name: "Query", Fields: map[string]*Field{
+		"books": {Type: bookType, Resolve: func(_ context.Context, _ interface{}, args Args) (interface{}, error) {
+			first := args.Int("first", len(books))
+			if first > len(books) {
+				first = len(books)
+			}
+			return books[:first], nil
+		}},
+		"book": {Type: bookType, Resolve: func(_ context.Context, _ interface{}, args Args) (interface{}, error) {
+			for _, b := range books {
+				if b.Title == args.String("title", "") {
+					return b, nil
+				}
+			}
+			return nil, nil
+		}},
+	}}}
+}
+
+func run(t *testing.T, req *Request) string {
+	data, err := json.Marshal(testSchema().Execute(context.Background(), req))
+	assert.NoError(t, err)
+	return string(data)
+}
+
+func TestExecute(t *testing.T) {
+	t.Run("Fields", func(t *testing.T) {
+		out := run(t, &Request{Query: `{ books(first: 1) { title, author { name } } }`})
+		assert.Equal(t, `{"data":{"books":[{"title":"Dune","author":{"name":"Frank Herbert"}}]}}`, out)
+
+		out = run(t, &Request{Query: `
+			# aliases keep the selection order
+			query Titles {
+				b: book(title: "Emma") { __typename pages title }
+				missing: book(title: "Ulysses") { title }
+			}`})
+		assert.Equal(t, `{"data":{"b":{"__typename":"Book","pages":474,"title":"Emma"},"missing":null}}`, out)
+	})
+
+	t.Run("Variables", func(t *testing.T) {
+		query := `query Book($title: String!, $n: Int = 2) { book(title: $title) { title } books(first: $n) { title } }`
+		out := run(t, &Request{Query: query, Variables: map[string]interface{}{"title": "Dune"}})
+		assert.Equal(t, `{"data":{"book":{"title":"Dune"},"books":[{"title":"Dune"},{"title":"Anonymous"}]}}`, out)
+
+		var vars map[string]interface{}
+		err := json.Unmarshal([]byte(`{"title":"Emma","n":1}`), &vars)
+		assert.NoError(t, err)
+		out = run(t, &Request{Query: query, Variables: vars})
+		assert.Equal(t, `{"data":{"book":{"title":"Emma"},"books":[{"title":"Dune"}]}}`, out)
+
+		out = run(t, &Request{Query: query})
+		assert.Equal(t, `{"data":null,"errors":[{"message":"Variable $title is required"}]}`, out)
+	})
+
+	t.Run("ResolverErrors", func(t *testing.T) {
+		out := run(t, &Request{Query: `{ books { pages } }`})
+		assert.Equal(t, `{"data":{"books":[{"pages":412},{"pages":null},{"pages":474}]},"errors":[{"message":"Unknown length","path":["books",1,"pages"]}]}`, out)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for query, msg := range map[string]string{
+			`{ books { isbn } }`:                  `Cannot query field \"isbn\" on type \"Book\"`,
+			`{ books }`:                           `Field \"books\" of type \"Query\" must have a selection of subfields`,
+			`{ books { title { x } } }`:           `Field \"title\" of type \"Book\" is a scalar and can't have a selection`,
+			`{ book(title: $t) { title } }`:       `Variable $t is not defined`,
+			`mutation { books { title } }`:        `Only queries are supported, not mutation`,
+			`{ books { ...Parts } }`:              `Fragments are not supported`,
+			`{ books @skip(if: true) { title } }`: `Directives are not supported`,
+			"{ books {\n  title ":                 `Syntax error at 2:9: unexpected end of query`,
+			`{ book(title: "Dune) { title } }`:    `Syntax error at 1:15: unterminated string`,
+			``:                                    `No operation in query`,
+		} {
+			out := run(t, &Request{Query: query})
+			assert.Equal(t, `{"data":null,"errors":[{"message":"`+msg+`"}]}`, out, query)
+		}
+
+		out := run(t, &Request{Query: `query A { books { title } } query B { book { title } }`})
+		assert.Contains(t, out, "operationName is required")
+		out = run(t, &Request{Query: `query A { books(first: 1) { title } } query B { book { title } }`, OperationName: "A"})
+		assert.Equal(t, `{"data":{"books":[{"title":"Dune"}]}}`, out)
+	})
+}
@@ -0,0 +1,478 @@
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokPunct
+	tokName
+	tokInt
+	tokFloat
+	tokString
+)
+
+type token struct {
+	kind tokenKind
+	val  string
+	pos  int
+}
+
+type lexer struct {
+	src string
+	pos int
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func (l *lexer) errorf(pos int, format string, args ...interface{}) error {
+	line, col := 1, 1
+	for _, c := range l.src[:pos] {
+		if c == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return fmt.Errorf("Syntax error at %d:%d: %s", line, col, fmt.Sprintf(format, args...))
+}
+
+func (l *lexer) next() (token, error) {
+	// commas are insignificant, like whitespace
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',' {
+			l.pos++
+		} else if c == '#' {
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		} else {
+			break
+		}
+	}
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, pos: l.pos}, nil
+	}
+
+	start := l.pos
+	c := l.src[l.pos]
+	switch {
+	case strings.IndexByte("{}()[]:$!=@", c) >= 0:
+		l.pos++
+		return token{tokPunct, string(c), start}, nil
+	case strings.HasPrefix(l.src[l.pos:], "..."):
+		l.pos += 3
+		return token{tokPunct, "...", start}, nil
+	case c == '"':
+		l.pos++
+		for l.pos < len(l.src) {
+			switch l.src[l.pos] {
+			case '\\':
+				l.pos += 2
+				continue
+			case '\n':
+				return token{}, l.errorf(start, "unterminated string")
+			case '"':
+				l.pos++
+				// GraphQL escapes are the same as JSON's
+				var str string
+				err := json.Unmarshal([]byte(l.src[start:l.pos]), &str)
+				if err != nil {
+					return token{}, l.errorf(start, "invalid string")
+				}
+				return token{tokString, str, start}, nil
+			}
+			l.pos++
+		}
+		return token{}, l.errorf(start, "unterminated string")
+	case c == '-' || isDigit(c):
+		l.pos++
+		kind := tokInt
+		l.digits()
+		if l.pos < len(l.src) && l.src[l.pos] == '.' {
+			kind = tokFloat
+			l.pos++
+			l.digits()
+		}
+		if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+			kind = tokFloat
+			l.pos++
+			if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+				l.pos++
+			}
+			l.digits()
+		}
+		return token{kind, l.src[start:l.pos], start}, nil
+	case isNameStart(c):
+		for l.pos < len(l.src) && (isNameStart(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.pos++
+		}
+		return token{tokName, l.src[start:l.pos], start}, nil
+	}
+	return token{}, l.errorf(start, "unexpected character %q", c)
+}
+
+func (l *lexer) digits() {
+	for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+		l.pos++
+	}
+}
+
+type variable string
+
+type selection struct {
+	Alias      string
+	Name       string
+	Args       map[string]interface{}
+	Selections []*selection
+}
+
+func (s *selection) key() string {
+	if s.Alias != "" {
+		return s.Alias
+	}
+	return s.Name
+}
+
+type variableDef struct {
+	Name     string
+	Required bool
+	Default  interface{}
+}
+
+type operation struct {
+	Name       string
+	Variables  []*variableDef
+	Selections []*selection
+}
+
+type parser struct {
+	lex *lexer
+	tok token
+}
+
+func parse(query string) ([]*operation, error) {
+	p := &parser{lex: &lexer{src: query}}
+	err := p.advance()
+	if err != nil {
+		return nil, err
+	}
+
+	ops := []*operation{}
+	for p.tok.kind != tokEOF {
+		op, err := p.operation()
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+	if len(ops) == 0 {
+		return nil, fmt.Errorf("No operation in query")
+	}
+	return ops, nil
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) peek(punct string) bool {
+	return p.tok.kind == tokPunct && p.tok.val == punct
+}
+
+func (p *parser) unexpected() error {
+	if p.tok.kind == tokEOF {
+		return p.lex.errorf(p.tok.pos, "unexpected end of query")
+	}
+	return p.lex.errorf(p.tok.pos, "unexpected %q", p.tok.val)
+}
+
+func (p *parser) expect(punct string) error {
+	if !p.peek(punct) {
+		return p.unexpected()
+	}
+	return p.advance()
+}
+
+func (p *parser) name() (string, error) {
+	if p.tok.kind != tokName {
+		return "", p.unexpected()
+	}
+	name := p.tok.val
+	return name, p.advance()
+}
+
+func (p *parser) operation() (*operation, error) {
+	op := &operation{}
+	if !p.peek("{") {
+		kind, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		switch kind {
+		case "query":
+		case "mutation", "subscription":
+			return nil, fmt.Errorf("Only queries are supported, not %s", kind)
+		case "fragment":
+			return nil, fmt.Errorf("Fragments are not supported")
+		default:
+			return nil, fmt.Errorf("Unknown operation %q", kind)
+		}
+		if p.tok.kind == tokName {
+			op.Name, _ = p.name()
+		}
+		if p.peek("(") {
+			op.Variables, err = p.variableDefs()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	sels, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	op.Selections = sels
+	return op, nil
+}
+
+func (p *parser) variableDefs() ([]*variableDef, error) {
+	err := p.expect("(")
+	if err != nil {
+		return nil, err
+	}
+	defs := []*variableDef{}
+	for !p.peek(")") {
+		err = p.expect("$")
+		if err != nil {
+			return nil, err
+		}
+		def := &variableDef{}
+		def.Name, err = p.name()
+		if err != nil {
+			return nil, err
+		}
+		err = p.expect(":")
+		if err != nil {
+			return nil, err
+		}
+		def.Required, err = p.typeRef()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek("=") {
+			err = p.advance()
+			if err != nil {
+				return nil, err
+			}
+			def.Default, err = p.value(true)
+			if err != nil {
+				return nil, err
+			}
+		}
+		defs = append(defs, def)
+	}
+	return defs, p.advance()
+}
+
+// typeRef skips a type, variables are not type checked beyond
+// whether they are required.
+func (p *parser) typeRef() (bool, error) {
+	var err error
+	if p.peek("[") {
+		err = p.advance()
+		if err == nil {
+			_, err = p.typeRef()
+		}
+		if err == nil {
+			err = p.expect("]")
+		}
+	} else {
+		_, err = p.name()
+	}
+	if err != nil {
+		return false, err
+	}
+	if p.peek("!") {
+		return true, p.advance()
+	}
+	return false, nil
+}
+
+func (p *parser) selectionSet() ([]*selection, error) {
+	err := p.expect("{")
+	if err != nil {
+		return nil, err
+	}
+	sels := []*selection{}
+	for !p.peek("}") {
+		if p.peek("...") {
+			return nil, fmt.Errorf("Fragments are not supported")
+		}
+		sel, err := p.field()
+		if err != nil {
+			return nil, err
+		}
+		sels = append(sels, sel)
+	}
+	if len(sels) == 0 {
+		return nil, p.unexpected()
+	}
+	return sels, p.advance()
+}
+
+func (p *parser) field() (*selection, error) {
+	sel := &selection{}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek(":") {
+		err = p.advance()
+		if err != nil {
+			return nil, err
+		}
+		sel.Alias = name
+		name, err = p.name()
+		if err != nil {
+			return nil, err
+		}
+	}
+	sel.Name = name
+
+	if p.peek("(") {
+		err = p.advance()
+		if err != nil {
+			return nil, err
+		}
+		sel.Args = map[string]interface{}{}
+		for !p.peek(")") {
+			arg, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			err = p.expect(":")
+			if err != nil {
+				return nil, err
+			}
+			sel.Args[arg], err = p.value(false)
+			if err != nil {
+				return nil, err
+			}
+		}
+		err = p.advance()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if p.peek("@") {
+		return nil, fmt.Errorf("Directives are not supported")
+	}
+	if p.peek("{") {
+		sel.Selections, err = p.selectionSet()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sel, nil
+}
+
+// value parses a literal, constant values such as defaults
+// may not refer to variables.
+func (p *parser) value(constant bool) (interface{}, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokInt:
+		val, err := strconv.ParseInt(tok.val, 10, 64)
+		if err != nil {
+			return nil, p.lex.errorf(tok.pos, "invalid integer %s", tok.val)
+		}
+		return int(val), p.advance()
+	case tokFloat:
+		val, err := strconv.ParseFloat(tok.val, 64)
+		if err != nil {
+			return nil, p.lex.errorf(tok.pos, "invalid number %s", tok.val)
+		}
+		return val, p.advance()
+	case tokString:
+		return tok.val, p.advance()
+	case tokName:
+		err := p.advance()
+		switch tok.val {
+		case "true":
+			return true, err
+		case "false":
+			return false, err
+		case "null":
+			return nil, err
+		}
+		// enum values are passed as strings
+		return tok.val, err
+	}
+
+	switch {
+	case p.peek("$") && !constant:
+		err := p.advance()
+		if err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		return variable(name), err
+	case p.peek("["):
+		err := p.advance()
+		if err != nil {
+			return nil, err
+		}
+		list := []interface{}{}
+		for !p.peek("]") {
+			val, err := p.value(constant)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, val)
+		}
+		return list, p.advance()
+	case p.peek("{"):
+		err := p.advance()
+		if err != nil {
+			return nil, err
+		}
+		obj := map[string]interface{}{}
+		for !p.peek("}") {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			err = p.expect(":")
+			if err != nil {
+				return nil, err
+			}
+			obj[name], err = p.value(constant)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return obj, p.advance()
+	}
+	return nil, p.unexpected()
+}
@@ -0,0 +1,95 @@
+package manager
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/storage"
+)
+
+/*
+ * The admin API allows Go code embedding Faktory to inspect and
+ * control the server without going through the Web UI.
+ */
+
+type QueueInfo struct {
+	Name   string
+	Size   uint64
+	Paused bool
+}
+
+// ListQueues returns the known queues, sorted by name.
+func (m *manager) ListQueues() ([]QueueInfo, error) {
+	queues := []QueueInfo{}
+	m.store.EachQueue(func(q storage.Queue) {
+		queues = append(queues, QueueInfo{Name: q.Name(), Size: q.Size(), Paused: q.IsPaused()})
+	})
+	sort.Slice(queues, func(i, j int) bool {
+		return queues[i].Name < queues[j].Name
+	})
+	return queues, nil
+}
+
+func (m *manager) PauseQueue(name string) error {
+	q, err := m.store.GetQueue(name)
+	if err != nil {
+		return err
+	}
+	return q.Pause()
+}
+
+func (m *manager) ResumeQueue(name string) error {
+	q, err := m.store.GetQueue(name)
+	if err != nil {
+		return err
+	}
+	return q.Resume()
+}
+
+// RetryDead enqueues the given dead jobs, or the entire
+// Dead set if no keys are given.
+func (m *manager) RetryDead(keys ...[]byte) error {
+	if len(keys) == 0 {
+		return m.store.EnqueueAll(m.store.Dead())
+	}
+	for _, key := range keys {
+		err := m.store.EnqueueFrom(m.store.Dead(), key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *manager) EnumerateScheduled(cursor string, count int) ([]*client.Job, string, error) {
+	return enumerate(m.store.Scheduled(), cursor, count)
+}
+
+func (m *manager) EnumerateRetries(cursor string, count int) ([]*client.Job, string, error) {
+	return enumerate(m.store.Retries(), cursor, count)
+}
+
+func (m *manager) EnumerateDead(cursor string, count int) ([]*client.Job, string, error) {
+	return enumerate(m.store.Dead(), cursor, count)
+}
+
+func enumerate(set storage.SortedSet, cursor string, count int) ([]*client.Job, string, error) {
+	if count < 1 {
+		return nil, "", fmt.Errorf("Invalid count: %d", count)
+	}
+
+	jobs := make([]*client.Job, 0, count)
+	next, err := set.Cursor(cursor, count, func(_ int, e storage.SortedEntry) error {
+		job, err := e.Job()
+		if err != nil {
+			return err
+		}
+		jobs = append(jobs, job)
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return jobs, next, nil
+}
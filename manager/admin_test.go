@@ -0,0 +1,129 @@
+package manager
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/storage"
+	"github.com/contribsys/faktory/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdmin(t *testing.T) {
+	withRedis(t, "admin", func(t *testing.T, store storage.Store) {
+		t.Run("ListQueues", func(t *testing.T) {
+			store.Flush()
+			m := NewManager(store)
+
+			assert.NoError(t, m.Push(client.NewJob("AdminJob", 1)))
+			job := client.NewJob("AdminJob", 2)
+			job.Queue = "critical"
+			assert.NoError(t, m.Push(job))
+
+			queues, err := m.ListQueues()
+			assert.NoError(t, err)
+			assert.True(t, len(queues) >= 2)
+			for i := 1; i < len(queues); i++ {
+				assert.True(t, queues[i-1].Name < queues[i].Name)
+			}
+			for _, q := range queues {
+				if q.Name == "critical" {
+					assert.EqualValues(t, 1, q.Size)
+					assert.False(t, q.Paused)
+				}
+			}
+		})
+
+		t.Run("PauseQueue", func(t *testing.T) {
+			store.Flush()
+			m := NewManager(store)
+
+			job := client.NewJob("AdminJob", 1)
+			job.Queue = "pausable"
+			assert.NoError(t, m.Push(job))
+			assert.NoError(t, m.PauseQueue("pausable"))
+			defer m.ResumeQueue("pausable")
+
+			q, err := store.GetQueue("pausable")
+			assert.NoError(t, err)
+			assert.True(t, q.IsPaused())
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			fetched, err := m.Fetch(ctx, "workerId", "pausable")
+			assert.NoError(t, err)
+			assert.Nil(t, fetched)
+			assert.EqualValues(t, 1, q.Size())
+
+			assert.NoError(t, m.ResumeQueue("pausable"))
+			assert.False(t, q.IsPaused())
+			fetched, err = m.Fetch(context.Background(), "workerId", "pausable")
+			assert.NoError(t, err)
+			assert.NotNil(t, fetched)
+			assert.Equal(t, job.Jid, fetched.Jid)
+		})
+
+		t.Run("RetryDead", func(t *testing.T) {
+			store.Flush()
+			m := NewManager(store)
+
+			for i := 0; i < 3; i++ {
+				job := client.NewJob("DeadJob", i)
+				job.Queue = "graveyard"
+				job.At = util.Nows()
+				assert.NoError(t, store.Dead().Add(job))
+			}
+			assert.EqualValues(t, 3, store.Dead().Size())
+
+			var key []byte
+			store.Dead().Each(func(_ int, e storage.SortedEntry) error {
+				k, err := e.Key()
+				key = k
+				return err
+			})
+			assert.NoError(t, m.RetryDead(key))
+			assert.EqualValues(t, 2, store.Dead().Size())
+
+			assert.NoError(t, m.RetryDead())
+			assert.EqualValues(t, 0, store.Dead().Size())
+
+			q, err := store.GetQueue("graveyard")
+			assert.NoError(t, err)
+			assert.EqualValues(t, 3, q.Size())
+		})
+
+		t.Run("Enumerate", func(t *testing.T) {
+			store.Flush()
+			m := NewManager(store)
+
+			for i := 0; i < 10; i++ {
+				job := client.NewJob("LaterJob", i)
+				job.At = util.Thens(time.Now().Add(time.Hour))
+				assert.NoError(t, m.Push(job))
+			}
+
+			count := 0
+			cursor := ""
+			for {
+				jobs, next, err := m.EnumerateScheduled(cursor, 4)
+				assert.NoError(t, err)
+				count += len(jobs)
+				if next == "" {
+					break
+				}
+				cursor = next
+			}
+			assert.Equal(t, 10, count)
+
+			jobs, next, err := m.EnumerateDead("", 4)
+			assert.NoError(t, err)
+			assert.Equal(t, 0, len(jobs))
+			assert.Equal(t, "", next)
+
+			_, _, err = m.EnumerateRetries("", 0)
+			assert.Error(t, err)
+		})
+	})
+}
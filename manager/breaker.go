@@ -0,0 +1,179 @@
+package manager
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/contribsys/faktory/storage"
+	"github.com/contribsys/faktory/util"
+	"github.com/go-redis/redis"
+)
+
+var (
+	// ErrBusy is returned while the circuit breaker is open, the
+	// server rejects commands with BUSY so clients back off rather
+	// than piling more work onto an unhealthy Redis.
+	ErrBusy = errors.New("Storage is unhealthy, try again later")
+)
+
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+/*
+ * Breaker wraps the manager's Redis calls.  Transient storage
+ * errors are retried a few times.  After Threshold consecutive
+ * failures the breaker opens and calls fail immediately with ErrBusy.
+ * Once Cooldown has passed a single call is let through to test
+ * Redis: if it succeeds the breaker closes, otherwise it opens again.
+ *
+ * [storage]
+ * retries = 2              # retries per call, 0 disables
+ * breaker_threshold = 5    # consecutive failures, 0 disables the breaker
+ * breaker_cooldown = 10    # seconds
+ */
+type Breaker struct {
+	mu        sync.Mutex
+	state     string
+	failures  int
+	openedAt  time.Time
+	retries   int
+	backoff   time.Duration
+	threshold int
+	cooldown  time.Duration
+
+	trips    int64
+	rejected int64
+}
+
+func NewBreaker() *Breaker {
+	return &Breaker{
+		state:     BreakerClosed,
+		retries:   2,
+		backoff:   50 * time.Millisecond,
+		threshold: 5,
+		cooldown:  10 * time.Second,
+	}
+}
+
+func (b *Breaker) Configure(retries int, threshold int, cooldown time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.retries = retries
+	b.threshold = threshold
+	b.cooldown = cooldown
+}
+
+func (b *Breaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *Breaker) Stats() map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return map[string]interface{}{
+		"state":    b.state,
+		"failures": b.failures,
+		"trips":    atomic.LoadInt64(&b.trips),
+		"rejected": atomic.LoadInt64(&b.rejected),
+	}
+}
+
+// Call runs fn, retrying transient storage errors.  Other
+// errors are returned as is and don't count as failures.
+func (b *Breaker) Call(fn func() error) error {
+	retries, err := b.admit()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; ; i++ {
+		err = fn()
+		if err == nil || !isStorageError(err) {
+			b.success()
+			return err
+		}
+		if i >= retries {
+			break
+		}
+		time.Sleep(b.backoff * time.Duration(i+1))
+	}
+
+	b.failure(err)
+	return err
+}
+
+// admit returns the number of retries allowed or ErrBusy if
+// the breaker is open.
+func (b *Breaker) admit() (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			atomic.AddInt64(&b.rejected, 1)
+			return 0, ErrBusy
+		}
+		// let this call through as a trial, everyone
+		// else is rejected until it finishes
+		b.state = BreakerHalfOpen
+		return 0, nil
+	case BreakerHalfOpen:
+		atomic.AddInt64(&b.rejected, 1)
+		return 0, ErrBusy
+	default:
+		return b.retries, nil
+	}
+}
+
+func (b *Breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != BreakerClosed {
+		util.Info("Storage has recovered, closing circuit breaker")
+	}
+	b.state = BreakerClosed
+	b.failures = 0
+}
+
+func (b *Breaker) failure(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.state == BreakerHalfOpen || (b.threshold > 0 && b.failures >= b.threshold) {
+		if b.state == BreakerClosed {
+			util.Warnf("Storage is unhealthy, opening circuit breaker: %v", err)
+			atomic.AddInt64(&b.trips, 1)
+		}
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// isStorageError is true for errors which indicate Redis is
+// unreachable or restarting, as opposed to a bad command.
+func isStorageError(err error) bool {
+	if err == redis.Nil {
+		return false
+	}
+	if err == io.EOF || err == storage.ErrUnavailable {
+		return true
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	msg := err.Error()
+	return strings.HasPrefix(msg, "LOADING ") || strings.HasPrefix(msg, "redis: ")
+}
@@ -0,0 +1,60 @@
+package manager
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreaker(t *testing.T) {
+	b := NewBreaker()
+	b.backoff = time.Millisecond
+	b.Configure(2, 2, 50*time.Millisecond)
+
+	calls := 0
+	down := func() error {
+		calls++
+		return io.EOF
+	}
+	up := func() error {
+		calls++
+		return nil
+	}
+
+	// bad commands aren't retried and don't trip the breaker
+	bad := errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	assert.Equal(t, bad, b.Call(func() error { calls++; return bad }))
+	assert.Equal(t, redis.Nil, b.Call(func() error { calls++; return redis.Nil }))
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, BreakerClosed, b.State())
+
+	calls = 0
+	assert.Equal(t, io.EOF, b.Call(down))
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, BreakerClosed, b.State())
+	assert.Equal(t, io.EOF, b.Call(down))
+	assert.Equal(t, BreakerOpen, b.State())
+
+	calls = 0
+	assert.Equal(t, ErrBusy, b.Call(up))
+	assert.Equal(t, 0, calls)
+
+	// the trial call after the cooldown fails, open again
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, io.EOF, b.Call(down))
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, BreakerOpen, b.State())
+
+	time.Sleep(60 * time.Millisecond)
+	assert.NoError(t, b.Call(up))
+	assert.Equal(t, BreakerClosed, b.State())
+
+	stats := b.Stats()
+	assert.EqualValues(t, 1, stats["trips"])
+	assert.EqualValues(t, 1, stats["rejected"])
+	assert.Equal(t, 0, stats["failures"])
+}
@@ -0,0 +1,178 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// How FETCH waits for a job when the worker's queues are empty:
+//
+//   - FetchBlocking pops from the first non-empty queue with a single
+//     BRPOP across all of them, blocking if they're empty.  A job is
+//     dispatched as soon as it's pushed, but each waiting worker holds
+//     a Redis connection.
+//   - FetchScripted pops the first non-empty queue with a Lua script,
+//     one round trip however many queues the worker has, and polls
+//     every interval.  No connection is held while waiting, which
+//     suits many workers or long queue lists.  A push wakes one of the
+//     workers polling its queue so it needn't wait for the interval.
+const (
+	FetchBlocking = "brpop"
+	FetchScripted = "script"
+)
+
+type fetchStrategy struct {
+	mu       sync.RWMutex
+	name     string
+	interval time.Duration
+}
+
+func (m *manager) SetFetchStrategy(name string, interval time.Duration) error {
+	switch name {
+	case FetchBlocking, FetchScripted:
+	default:
+		return fmt.Errorf("Unknown fetch strategy %q, expected %s or %s", name, FetchBlocking, FetchScripted)
+	}
+	if name == FetchScripted && interval <= 0 {
+		return fmt.Errorf("The %s fetch strategy requires a poll interval", name)
+	}
+
+	m.fetch.mu.Lock()
+	defer m.fetch.mu.Unlock()
+	m.fetch.name = name
+	m.fetch.interval = interval
+	return nil
+}
+
+func (m *manager) fetchStrategy() (string, time.Duration) {
+	m.fetch.mu.RLock()
+	defer m.fetch.mu.RUnlock()
+	if m.fetch.name == "" {
+		return FetchBlocking, 0
+	}
+	return m.fetch.name, m.fetch.interval
+}
+
+// unpaused returns the queues which aren't paused
+func (m *manager) unpaused(names []string) ([]string, error) {
+	queues := make([]string, 0, len(names))
+	for _, name := range names {
+		q, err := m.store.GetQueue(name)
+		if err != nil {
+			return nil, err
+		}
+		if !q.IsPaused() {
+			queues = append(queues, q.Name())
+		}
+	}
+	return queues, nil
+}
+
+// pop returns the next payload from the queues, waiting until the
+// context is done if they are empty.  Paused queues are skipped.
+func (m *manager) pop(ctx context.Context, names []string) ([]byte, error) {
+	queues, err := m.unpaused(names)
+	if err != nil {
+		return nil, err
+	}
+	if len(queues) == 0 {
+		// every queue is paused, wait out the timeout
+		<-ctx.Done()
+		return nil, nil
+	}
+
+	strategy, interval := m.fetchStrategy()
+	if strategy == FetchScripted {
+		return m.popScripted(ctx, queues, interval)
+	}
+	return m.popBlocking(ctx, queues)
+}
+
+// popBlocking checks the queues in order and blocks on all of them
+// with one command.  This allows us to pick up new jobs in µs rather
+// than seconds, without a round trip per queue.
+func (m *manager) popBlocking(ctx context.Context, names []string) ([]byte, error) {
+	var data []byte
+	err := m.breaker.Call(func() error {
+		var err error
+		_, data, err = m.store.BPopFirst(ctx, names)
+		return err
+	})
+	return data, err
+}
+
+func (m *manager) popScripted(ctx context.Context, names []string, interval time.Duration) ([]byte, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	// registered before the first pop so a push isn't missed
+	wake := m.waiters.add(names)
+	defer m.waiters.remove(names, wake)
+	for {
+		var data []byte
+		err := m.breaker.Call(func() error {
+			var err error
+			_, data, err = m.store.PopFirst(names)
+			return err
+		})
+		if err != nil || data != nil {
+			return data, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, nil
+		case <-ticker.C:
+		case <-wake:
+		}
+	}
+}
+
+// pushWaiters are the fetches polling each queue
+type pushWaiters struct {
+	mu      sync.Mutex
+	byQueue map[string]map[chan struct{}]bool
+}
+
+func (pw *pushWaiters) add(names []string) chan struct{} {
+	wake := make(chan struct{}, 1)
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	if pw.byQueue == nil {
+		pw.byQueue = map[string]map[chan struct{}]bool{}
+	}
+	for _, name := range names {
+		if pw.byQueue[name] == nil {
+			pw.byQueue[name] = map[chan struct{}]bool{}
+		}
+		pw.byQueue[name][wake] = true
+	}
+	return wake
+}
+
+func (pw *pushWaiters) remove(names []string, wake chan struct{}) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	for _, name := range names {
+		delete(pw.byQueue[name], wake)
+		if len(pw.byQueue[name]) == 0 {
+			delete(pw.byQueue, name)
+		}
+	}
+}
+
+// notify wakes one of the fetches polling the queue, a job
+// only needs one worker
+func (pw *pushWaiters) notify(name string) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	for wake := range pw.byQueue[name] {
+		select {
+		case wake <- struct{}{}:
+			return
+		default:
+			// already woken, try another
+		}
+	}
+}
@@ -25,6 +25,13 @@ const (
 
 type Manager interface {
 	Push(job *client.Job) error
+	// PushBulk pushes the jobs with a single round trip to Redis,
+	// returning the errors of the jobs which weren't pushed by JID.
+	PushBulk(jobs []*client.Job) map[string]error
+	// PushFirst enqueues a job the server accepted earlier ahead of
+	// every job in its queue so it's the next dispatched, ignoring
+	// its "at" time.  The push middleware sees it as Reenqueued.
+	PushFirst(job *client.Job) error
 
 	// Dispatch operations:
 	//
@@ -44,21 +51,44 @@ type Manager interface {
 	// How are jobs passed to waiting workers?
 	//
 	// Socket sends "FETCH q1, q2, q3"
-	// Connection pops each queue:
-	//   store.GetQueue("q1").Pop()
-	// and returns if it gets any non-nil data.
-	//
-	// If all nil, the connection registers itself, blocking for a job.
+	// Connection pops the first non-empty queue:
+	//   BRPOP q1 q2 q3 timeout
+	// blocking for a job if they are all empty, see fetch.go.
 	Fetch(ctx context.Context, wid string, queues ...string) (*client.Job, error)
 
+	// FetchWithin is Fetch for a worker which expects to live for the
+	// given duration, only jobs whose reservation fits within it are
+	// fetched.  A queue whose next job doesn't fit is skipped.
+	FetchWithin(ctx context.Context, wid string, lifetime time.Duration, queues ...string) (*client.Job, error)
+
+	// Prefetch reserves up to count jobs for the worker without
+	// waiting, fewer if the queues run out.
+	Prefetch(wid string, count int, queues ...string) ([]*client.Job, error)
+
 	Acknowledge(jid string) (*client.Job, error)
 
+	// Annotate attaches the given key/value pairs to a job
+	// which is currently reserved by a worker.
+	Annotate(jid string, annotations map[string]string) error
+
 	Fail(fail *FailPayload) error
 
 	WorkingCount() int
 
+	// OverdueReservations returns the reservations which have used
+	// at least the given fraction of their timeout, e.g. 0.8.
+	OverdueReservations(fraction float64, now time.Time) []*Reservation
+
 	ReapExpiredJobs(timestamp string) (int, error)
 
+	// RequeueWorking releases every reservation and returns the
+	// jobs to the front of their queues, see working.go.
+	RequeueWorking() (int, error)
+
+	// Release returns the given reserved jobs to the front of
+	// their queues.
+	Release(jids ...string) (int, error)
+
 	// Purge deletes all dead jobs
 	Purge() (int64, error)
 
@@ -72,8 +102,27 @@ type Manager interface {
 
 	AddMiddleware(fntype string, fn MiddlewareFunc)
 
+	// Administrative operations, see admin.go
+	ListQueues() ([]QueueInfo, error)
+	PauseQueue(name string) error
+	ResumeQueue(name string) error
+	RetryDead(keys ...[]byte) error
+
+	// Enumerate the given set in count-sized batches.
+	// Pass "" to start and the returned cursor to continue,
+	// a returned cursor of "" means the set is exhausted.
+	EnumerateScheduled(cursor string, count int) ([]*client.Job, string, error)
+	EnumerateRetries(cursor string, count int) ([]*client.Job, string, error)
+	EnumerateDead(cursor string, count int) ([]*client.Job, string, error)
+
 	KV() storage.KV
 	Redis() *redis.Client
+
+	// Breaker guards the manager's Redis calls, see breaker.go.
+	Breaker() *Breaker
+
+	// SetFetchStrategy selects how Fetch waits for jobs, see fetch.go.
+	SetFetchStrategy(name string, interval time.Duration) error
 }
 
 func NewManager(s storage.Store) Manager {
@@ -84,8 +133,10 @@ func NewManager(s storage.Store) Manager {
 		failChain:  make(MiddlewareChain, 0),
 		ackChain:   make(MiddlewareChain, 0),
 		fetchChain: make(MiddlewareChain, 0),
+		breaker:    NewBreaker(),
 	}
 	m.loadWorkingSet()
+	s.OnRecovery(m.restoreWorkingSet)
 	return m
 }
 
@@ -93,6 +144,10 @@ func (m *manager) KV() storage.KV {
 	return m.store.Raw()
 }
 
+func (m *manager) Breaker() *Breaker {
+	return m.breaker
+}
+
 func (m *manager) Redis() *redis.Client {
 	return m.store.Redis()
 }
@@ -101,6 +156,8 @@ func (m *manager) AddMiddleware(fntype string, fn MiddlewareFunc) {
 	switch fntype {
 	case "push":
 		m.pushChain = append(m.pushChain, fn)
+	case "schedule":
+		m.scheduleChain = append(m.scheduleChain, fn)
 	case "ack":
 		m.ackChain = append(m.ackChain, fn)
 	case "fail":
@@ -125,20 +182,28 @@ type manager struct {
 	fetchChain   MiddlewareChain
 	failChain    MiddlewareChain
 	ackChain     MiddlewareChain
+	breaker      *Breaker
+	fetch        fetchStrategy
+	waiters      pushWaiters
+	// called for jobs pushed with a future "at", they run through
+	// the push chain when they're enqueued
+	scheduleChain MiddlewareChain
 }
 
-func (m *manager) Push(job *client.Job) error {
+// prepare validates a job being pushed and fills in its defaults,
+// returning true if it's scheduled for later
+func prepare(job *client.Job) (bool, error) {
 	if job.Jid == "" || len(job.Jid) < 8 {
-		return fmt.Errorf("All jobs must have a reasonable jid parameter")
+		return false, fmt.Errorf("All jobs must have a reasonable jid parameter")
 	}
 	if job.Type == "" {
-		return fmt.Errorf("All jobs must have a jobtype parameter")
+		return false, fmt.Errorf("All jobs must have a jobtype parameter")
 	}
 	if job.Args == nil {
-		return fmt.Errorf("All jobs must have an args parameter")
+		return false, fmt.Errorf("All jobs must have an args parameter")
 	}
 	if job.ReserveFor > 86400 {
-		return fmt.Errorf("Jobs cannot be reserved for more than one day")
+		return false, fmt.Errorf("Jobs cannot be reserved for more than one day")
 	}
 
 	if job.CreatedAt == "" {
@@ -152,110 +217,228 @@ func (m *manager) Push(job *client.Job) error {
 	if job.At != "" {
 		t, err := util.ParseTime(job.At)
 		if err != nil {
-			return fmt.Errorf("Invalid timestamp for 'at': '%s'", job.At)
+			return false, fmt.Errorf("Invalid timestamp for 'at': '%s'", job.At)
 		}
+		return t.After(time.Now()), nil
+	}
+	return false, nil
+}
 
-		if t.After(time.Now()) {
-			data, err := json.Marshal(job)
-			if err != nil {
-				return err
-			}
+func (m *manager) Push(job *client.Job) error {
+	later, err := prepare(job)
+	if err != nil {
+		return err
+	}
 
+	if later {
+		return callMiddleware(m.scheduleChain, Ctx{context.Background(), job, m}, func() error {
 			// scheduler for later
-			return m.store.Scheduled().AddElement(job.At, job.Jid, data)
-		}
+			return marshal(job, func(data []byte) error {
+				return m.breaker.Call(func() error {
+					return m.store.Scheduled().AddElement(job.At, job.Jid, data)
+				})
+			})
+		})
 	}
 
 	// enqueue immediately
 	return m.enqueue(job)
 }
 
+// PushBulk runs each job through the middleware like Push but the
+// jobs are only written once they've all been through it.  If the
+// write fails every job which made it through is reported as failed.
+func (m *manager) PushBulk(jobs []*client.Job) map[string]error {
+	failed := map[string]error{}
+	batch := m.store.NewPushBatch()
+	pending := []*client.Job{}
+	for _, job := range jobs {
+		err := m.pushTo(batch, job)
+		if err != nil {
+			failed[job.Jid] = err
+			continue
+		}
+		pending = append(pending, job)
+	}
+
+	err := m.breaker.Call(batch.Exec)
+	for _, job := range pending {
+		if err != nil {
+			failed[job.Jid] = err
+		} else {
+			// waking a fetch for a scheduled job is harmless
+			m.waiters.notify(job.Queue)
+		}
+	}
+	return failed
+}
+
+func (m *manager) pushTo(batch storage.PushBatch, job *client.Job) error {
+	later, err := prepare(job)
+	if err != nil {
+		return err
+	}
+
+	if later {
+		return callMiddleware(m.scheduleChain, Ctx{context.Background(), job, m}, func() error {
+			return marshal(job, func(data []byte) error {
+				return batch.Schedule(job.At, data)
+			})
+		})
+	}
+
+	q, err := m.store.GetQueue(job.Queue)
+	if err != nil {
+		return err
+	}
+	return callMiddleware(m.pushChain, Ctx{context.Background(), job, m}, func() error {
+		job.EnqueuedAt = util.Nows()
+		return marshal(job, func(data []byte) error {
+			batch.Push(q, data)
+			return nil
+		})
+	})
+}
+
+func (m *manager) PushFirst(job *client.Job) error {
+	if job.Queue == "" {
+		job.Queue = "default"
+	}
+	if job.CreatedAt == "" {
+		job.CreatedAt = util.Nows()
+	}
+	return m.enqueueAt(reenqueued, job, true)
+}
+
 func (m *manager) enqueue(job *client.Job) error {
+	return m.enqueueAt(context.Background(), job, false)
+}
+
+// requeue enqueues a job the server accepted earlier
+func (m *manager) requeue(job *client.Job) error {
+	return m.enqueueAt(reenqueued, job, false)
+}
+
+func (m *manager) enqueueAt(ctx context.Context, job *client.Job, front bool) error {
 	q, err := m.store.GetQueue(job.Queue)
 	if err != nil {
 		return err
 	}
 
-	return callMiddleware(m.pushChain, Ctx{context.Background(), job, m}, func() error {
+	return callMiddleware(m.pushChain, Ctx{ctx, job, m}, func() error {
 		job.EnqueuedAt = util.Nows()
-		data, err := json.Marshal(job)
-		if err != nil {
-			return err
-		}
 		//util.Debugf("pushed: %+v", job)
-		return q.Push(data)
+		return marshal(job, func(data []byte) error {
+			err := m.breaker.Call(func() error {
+				if front {
+					return q.PushFront(data)
+				}
+				return q.Push(data)
+			})
+			if err == nil {
+				m.waiters.notify(job.Queue)
+			}
+			return err
+		})
 	})
 }
 
 func (m *manager) Fetch(ctx context.Context, wid string, queues ...string) (*client.Job, error) {
+	return m.FetchWithin(ctx, wid, 0, queues...)
+}
+
+func (m *manager) FetchWithin(ctx context.Context, wid string, lifetime time.Duration, queues ...string) (*client.Job, error) {
+	if len(queues) == 0 {
+		return nil, fmt.Errorf("Fetch must be called with one or more queue names")
+	}
+
 restart:
-	var first storage.Queue
+	data, err := m.pop(ctx, queues)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, nil
+	}
 
-	for idx, qname := range queues {
-		q, err := m.store.GetQueue(qname)
+	var job client.Job
+	err = json.Unmarshal(data, &job)
+	if err != nil {
+		return nil, err
+	}
+	if lifetime > 0 && time.Duration(ReserveTimeout(&job))*time.Second > lifetime {
+		// the worker would be gone before the reservation expires,
+		// put the job back where it was and try the other queues
+		q, err := m.store.GetQueue(job.Queue)
 		if err != nil {
 			return nil, err
 		}
-
-		data, err := q.Pop()
+		err = m.breaker.Call(func() error { return q.PushFront(data) })
 		if err != nil {
 			return nil, err
 		}
-		if data != nil {
-			var job client.Job
-			err = json.Unmarshal(data, &job)
-			if err != nil {
-				return nil, err
+		remaining := make([]string, 0, len(queues))
+		for _, name := range queues {
+			if name != job.Queue {
+				remaining = append(remaining, name)
 			}
-			err = callMiddleware(m.fetchChain, Ctx{ctx, &job, m}, func() error {
-				return m.reserve(wid, &job)
-			})
-			if h, ok := err.(halt); ok {
-				// middleware halted the fetch, for whatever reason
-				util.Infof("JID %s: %s", job.Jid, h.Error())
-				goto restart
-			}
-			if err != nil {
-				return nil, err
-			}
-			return &job, nil
 		}
-		if idx == 0 {
-			first = q
+		if len(remaining) == 0 || len(remaining) == len(queues) {
+			// nothing fits, wait as if the queues were empty
+			<-ctx.Done()
+			return nil, nil
 		}
+		queues = remaining
+		goto restart
 	}
-
-	if first == nil {
-		return nil, fmt.Errorf("Fetch must be called with one or more queue names")
+	err = callMiddleware(m.fetchChain, Ctx{ctx, &job, m}, func() error {
+		return m.reserve(wid, &job)
+	})
+	if h, ok := err.(halt); ok {
+		// middleware halted the fetch, for whatever reason
+		util.ForJob(job.Jid, job.Queue).Infof("JID %s: %s", job.Jid, h.Error())
+		goto restart
 	}
-
-	// scanned through our queues, no jobs were available
-	// we should block for a moment, awaiting a job to be
-	// pushed.  this allows us to pick up new jobs in µs
-	// rather than seconds.
-	data, err := first.BPop(ctx)
 	if err != nil {
 		return nil, err
 	}
-	if data != nil {
+	return &job, nil
+}
+
+func (m *manager) Prefetch(wid string, count int, queues ...string) ([]*client.Job, error) {
+	queues, err := m.unpaused(queues)
+	if err != nil || len(queues) == 0 {
+		return nil, err
+	}
+
+	jobs := make([]*client.Job, 0, count)
+	for len(jobs) < count {
+		var data []byte
+		err := m.breaker.Call(func() error {
+			var err error
+			_, data, err = m.store.PopFirst(queues)
+			return err
+		})
+		if err != nil || data == nil {
+			return jobs, err
+		}
+
 		var job client.Job
 		err = json.Unmarshal(data, &job)
 		if err != nil {
-			return nil, err
+			return jobs, err
 		}
-		err = callMiddleware(m.fetchChain, Ctx{ctx, &job, m}, func() error {
+		err = callMiddleware(m.fetchChain, Ctx{context.Background(), &job, m}, func() error {
 			return m.reserve(wid, &job)
 		})
 		if h, ok := err.(halt); ok {
-			// middleware halted the fetch, for whatever reason
-			util.Debugf("JID %s: %s", job.Jid, h.Error())
-			goto restart
+			util.ForJob(job.Jid, job.Queue).Infof("JID %s: %s", job.Jid, h.Error())
+			continue
 		}
 		if err != nil {
-			return nil, err
+			return jobs, err
 		}
-		return &job, nil
+		jobs = append(jobs, &job)
 	}
-
-	return nil, nil
+	return jobs, nil
 }
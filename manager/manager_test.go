@@ -3,6 +3,7 @@ package manager
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"testing"
 	"time"
@@ -10,6 +11,7 @@ import (
 	"github.com/contribsys/faktory/client"
 	"github.com/contribsys/faktory/storage"
 	"github.com/contribsys/faktory/util"
+	"github.com/go-redis/redis"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -151,6 +153,64 @@ func TestManager(t *testing.T) {
 			assert.Empty(t, job.EnqueuedAt)
 		})
 
+		t.Run("PushBulk", func(t *testing.T) {
+			store.Flush()
+			m := NewManager(store)
+			m.AddMiddleware("push", func(next func() error, ctx Context) error {
+				if ctx.Job().Type == "Rejected" {
+					return Halt("no thanks")
+				}
+				return next()
+			})
+
+			now := client.NewJob("BulkJob", 1)
+			later := client.NewJob("BulkJob", 2)
+			later.At = util.Thens(time.Now().Add(5 * time.Minute))
+			invalid := client.NewJob("", 3)
+			rejected := client.NewJob("Rejected", 4)
+
+			failed := m.PushBulk([]*client.Job{now, later, invalid, rejected})
+			assert.Equal(t, 2, len(failed))
+			assert.EqualError(t, failed[invalid.Jid], "All jobs must have a jobtype parameter")
+			assert.Contains(t, failed[rejected.Jid].Error(), "no thanks")
+
+			q, err := store.GetQueue(now.Queue)
+			assert.NoError(t, err)
+			assert.EqualValues(t, 1, q.Size())
+			assert.NotEmpty(t, now.EnqueuedAt)
+			assert.EqualValues(t, 1, store.Scheduled().Size())
+
+			assert.Empty(t, m.PushBulk(nil))
+		})
+
+		t.Run("PushBulkRetry", func(t *testing.T) {
+			store.Flush()
+			m := NewManager(store)
+
+			// the first attempt at the pipeline is lost
+			failures := 1
+			store.Redis().WrapProcessPipeline(func(old func([]redis.Cmder) error) func([]redis.Cmder) error {
+				return func(cmds []redis.Cmder) error {
+					if failures > 0 {
+						failures--
+						return io.EOF
+					}
+					return old(cmds)
+				}
+			})
+
+			now := client.NewJob("BulkJob", 1)
+			later := client.NewJob("BulkJob", 2)
+			later.At = util.Thens(time.Now().Add(5 * time.Minute))
+			assert.Empty(t, m.PushBulk([]*client.Job{now, later}))
+			assert.Equal(t, 0, failures)
+
+			q, err := store.GetQueue(now.Queue)
+			assert.NoError(t, err)
+			assert.EqualValues(t, 1, q.Size())
+			assert.EqualValues(t, 1, store.Scheduled().Size())
+		})
+
 		t.Run("Fetch", func(t *testing.T) {
 			store.Flush()
 			m := NewManager(store)
@@ -235,6 +295,135 @@ func TestManager(t *testing.T) {
 			assert.EqualValues(t, 0, q2.Size())
 		})
 
+		t.Run("FetchWithin", func(t *testing.T) {
+			store.Flush()
+			m := NewManager(store)
+
+			long := client.NewJob("Export", 1)
+			long.ReserveFor = 3600
+			assert.NoError(t, m.Push(long))
+			after := client.NewJob("Export", 2)
+			assert.NoError(t, m.Push(after))
+			short := client.NewJob("SendEmail", 1)
+			short.Queue = "email"
+			short.ReserveFor = 120
+			assert.NoError(t, m.Push(short))
+
+			queues := []string{"default", "email"}
+			fetchedJob, err := m.FetchWithin(context.Background(), "workerId", 10*time.Minute, queues...)
+			assert.NoError(t, err)
+			assert.EqualValues(t, short.Jid, fetchedJob.Jid)
+
+			// the long job stays at the front of its queue
+			q, err := store.GetQueue("default")
+			assert.NoError(t, err)
+			assert.EqualValues(t, 2, q.Size())
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			fetchedJob, err = m.FetchWithin(ctx, "workerId", 10*time.Minute, queues...)
+			assert.NoError(t, err)
+			assert.Nil(t, fetchedJob)
+			assert.EqualValues(t, 2, q.Size())
+
+			fetchedJob, err = m.FetchWithin(context.Background(), "workerId", 2*time.Hour, queues...)
+			assert.NoError(t, err)
+			assert.EqualValues(t, long.Jid, fetchedJob.Jid)
+			fetchedJob, err = m.Fetch(context.Background(), "workerId", queues...)
+			assert.NoError(t, err)
+			assert.EqualValues(t, after.Jid, fetchedJob.Jid)
+		})
+
+		t.Run("PrefetchAndRelease", func(t *testing.T) {
+			store.Flush()
+			m := NewManager(store)
+
+			first := client.NewJob("Export", 1)
+			assert.NoError(t, m.Push(first))
+			second := client.NewJob("Export", 2)
+			assert.NoError(t, m.Push(second))
+
+			jobs, err := m.Prefetch("workerId", 5, "default")
+			assert.NoError(t, err)
+			assert.Len(t, jobs, 2)
+			assert.EqualValues(t, first.Jid, jobs[0].Jid)
+			assert.EqualValues(t, 2, m.BusyCount("workerId"))
+
+			assert.NoError(t, m.Push(client.NewJob("Export", 3)))
+			count, err := m.Release(jobs[0].Jid, jobs[1].Jid, "unknown")
+			assert.NoError(t, err)
+			assert.EqualValues(t, 2, count)
+			assert.EqualValues(t, 0, m.WorkingCount())
+
+			// each released job goes to the front of its queue
+			fetchedJob, err := m.Fetch(context.Background(), "workerId", "default")
+			assert.NoError(t, err)
+			assert.EqualValues(t, second.Jid, fetchedJob.Jid)
+		})
+
+		t.Run("ScriptedFetch", func(t *testing.T) {
+			store.Flush()
+			m := NewManager(store)
+			assert.Error(t, m.SetFetchStrategy("lpop", 0))
+			assert.Error(t, m.SetFetchStrategy(FetchScripted, 0))
+			assert.NoError(t, m.SetFetchStrategy(FetchScripted, 10*time.Millisecond))
+
+			email := client.NewJob("SendEmail", 1)
+			email.Queue = "email"
+			assert.NoError(t, m.Push(email))
+			job := client.NewJob("ManagerPush", 1)
+			assert.NoError(t, m.Push(job))
+
+			queues := []string{"default", "email"}
+			fetchedJob, err := m.Fetch(context.Background(), "workerId", queues...)
+			assert.NoError(t, err)
+			assert.EqualValues(t, job.Jid, fetchedJob.Jid)
+			fetchedJob, err = m.Fetch(context.Background(), "workerId", queues...)
+			assert.NoError(t, err)
+			assert.EqualValues(t, email.Jid, fetchedJob.Jid)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			fetchedJob, err = m.Fetch(ctx, "workerId", queues...)
+			assert.NoError(t, err)
+			assert.Nil(t, fetchedJob)
+
+			go func() {
+				time.Sleep(50 * time.Millisecond)
+				m.Push(client.NewJob("ManagerPush", 2))
+			}()
+			ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			fetchedJob, err = m.Fetch(ctx, "workerId", queues...)
+			assert.NoError(t, err)
+			assert.NotNil(t, fetchedJob)
+		})
+
+		t.Run("ScriptedFetchWakesOnPush", func(t *testing.T) {
+			store.Flush()
+			m := NewManager(store)
+			assert.NoError(t, m.SetFetchStrategy(FetchScripted, time.Minute))
+
+			go func() {
+				time.Sleep(50 * time.Millisecond)
+				m.Push(client.NewJob("ManagerPush", 1))
+			}()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			start := time.Now()
+			fetchedJob, err := m.Fetch(ctx, "workerId", "email", "default")
+			assert.NoError(t, err)
+			assert.NotNil(t, fetchedJob)
+			assert.True(t, time.Since(start) < time.Second, "took %v", time.Since(start))
+
+			go func() {
+				time.Sleep(50 * time.Millisecond)
+				m.PushBulk([]*client.Job{client.NewJob("ManagerPush", 2)})
+			}()
+			fetchedJob, err = m.Fetch(ctx, "workerId", "default")
+			assert.NoError(t, err)
+			assert.NotNil(t, fetchedJob)
+		})
+
 		t.Run("FetchAwaitsForNewJob", func(t *testing.T) {
 			store.Flush()
 			m := NewManager(store)
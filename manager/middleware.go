@@ -32,6 +32,22 @@ func (c Ctx) Manager() Manager {
 	return c.mgr
 }
 
+// reenqueuedKey marks the context of a job the server accepted
+// earlier being enqueued again
+type reenqueuedKey struct{}
+
+var reenqueued = context.WithValue(context.Background(), reenqueuedKey{}, true)
+
+// Reenqueued is true if the job was accepted earlier and is being
+// enqueued again, e.g. a retry, a scheduled job which is due or a job
+// whose reservation expired, rather than pushed by a producer.
+// Middleware which must only run once per job, or mustn't trust
+// what producers send, checks it.
+func Reenqueued(ctx Context) bool {
+	val, _ := ctx.Value(reenqueuedKey{}).(bool)
+	return val
+}
+
 func Halt(msg string) error {
 	return halt{msg: msg}
 }
@@ -2,12 +2,15 @@ package manager
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"testing"
 	"time"
 
 	"github.com/contribsys/faktory/client"
 	"github.com/contribsys/faktory/storage"
+	"github.com/contribsys/faktory/util"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -72,6 +75,63 @@ func TestLiveMiddleware(t *testing.T) {
 			assert.EqualValues(t, 1, q.Size())
 		})
 
+		t.Run("Schedule", func(t *testing.T) {
+			store.Flush()
+			m := NewManager(store)
+			pushed := 0
+			m.AddMiddleware("push", func(next func() error, ctx Context) error {
+				pushed += 1
+				return next()
+			})
+			m.AddMiddleware("schedule", func(next func() error, ctx Context) error {
+				ctx.Job().SetCustom("scheduled", true)
+				return next()
+			})
+
+			job := client.NewJob("Later", 1, 2, 3)
+			job.At = util.Thens(time.Now().Add(time.Minute))
+			err := m.Push(job)
+			assert.NoError(t, err)
+			assert.EqualValues(t, 0, pushed)
+			assert.EqualValues(t, 1, store.Scheduled().Size())
+
+			stored, err := store.Scheduled().Get([]byte(fmt.Sprintf("%s|%s", job.At, job.Jid)))
+			assert.NoError(t, err)
+			sjob, err := stored.Job()
+			assert.NoError(t, err)
+			val, ok := sjob.GetCustom("scheduled")
+			assert.True(t, ok)
+			assert.Equal(t, true, val)
+
+			err = m.Push(client.NewJob("Now", 1))
+			assert.NoError(t, err)
+			assert.EqualValues(t, 1, pushed)
+			assert.EqualValues(t, 1, store.Scheduled().Size())
+		})
+
+		t.Run("Reenqueued", func(t *testing.T) {
+			store.Flush()
+			m := NewManager(store)
+			again := map[string]bool{}
+			m.AddMiddleware("push", func(next func() error, ctx Context) error {
+				again[ctx.Job().Type] = Reenqueued(ctx)
+				return next()
+			})
+
+			assert.NoError(t, m.Push(client.NewJob("Pushed", 1)))
+			assert.NoError(t, m.PushFirst(client.NewJob("Released", 1)))
+			due := client.NewJob("Due", 1)
+			due.At = util.Thens(time.Now().Add(-time.Second))
+			data, err := json.Marshal(due)
+			assert.NoError(t, err)
+			assert.NoError(t, store.Scheduled().AddElement(due.At, due.Jid, data))
+			count, err := m.EnqueueScheduledJobs()
+			assert.NoError(t, err)
+			assert.EqualValues(t, 1, count)
+
+			assert.Equal(t, map[string]bool{"Pushed": false, "Released": true, "Due": true}, again)
+		})
+
 		t.Run("Fetch", func(t *testing.T) {
 			denied := errors.New("fetch denied")
 
@@ -0,0 +1,38 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+
+	"github.com/contribsys/faktory/client"
+)
+
+// buffers larger than this aren't pooled so one huge job
+// doesn't pin its buffer forever
+const maxPooledBuffer = 64 * 1024
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return &bytes.Buffer{}
+	},
+}
+
+// marshal encodes the job into a pooled buffer and calls fn with the
+// payload.  The payload is only valid until fn returns.
+func marshal(job *client.Job, fn func(data []byte) error) error {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer func() {
+		if buf.Cap() <= maxPooledBuffer {
+			bufferPool.Put(buf)
+		}
+	}()
+
+	err := json.NewEncoder(buf).Encode(job)
+	if err != nil {
+		return err
+	}
+	// drop the encoder's trailing newline
+	return fn(buf.Bytes()[:buf.Len()-1])
+}
@@ -2,7 +2,6 @@ package manager
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"math/rand"
 	"strings"
@@ -18,6 +17,8 @@ type FailPayload struct {
 	ErrorMessage string   `json:"message"`
 	ErrorType    string   `json:"errtype"`
 	Backtrace    []string `json:"backtrace"`
+
+	Annotations map[string]string `json:"annotations,omitempty"`
 }
 
 func (m *manager) Fail(failure *FailPayload) error {
@@ -61,6 +62,43 @@ func cleanse(failure *FailPayload) {
 	if len(failure.Backtrace) > 50 {
 		failure.Backtrace = failure.Backtrace[0:50]
 	}
+
+	failure.Annotations = cleanseAnnotations(failure.Annotations)
+}
+
+const (
+	// the failures kept for each job so the Web UI
+	// can compare attempts
+	MaxFailureAttempts     = 5
+	MaxAnnotations         = 20
+	MaxAnnotationKeySize   = 64
+	MaxAnnotationValueSize = 256
+)
+
+// Annotations are indexed by the Web UI so we keep them small.
+func cleanseAnnotations(annotations map[string]string) map[string]string {
+	if len(annotations) == 0 {
+		return nil
+	}
+
+	result := map[string]string{}
+	for k, v := range annotations {
+		k = strings.TrimSpace(k)
+		if k == "" {
+			continue
+		}
+		if len(result) >= MaxAnnotations {
+			break
+		}
+		if len(k) > MaxAnnotationKeySize {
+			k = k[0:MaxAnnotationKeySize]
+		}
+		if len(v) > MaxAnnotationValueSize {
+			v = v[0:MaxAnnotationValueSize]
+		}
+		result[k] = v
+	}
+	return result
 }
 
 func (m *manager) clearReservation(jid string) *Reservation {
@@ -79,7 +117,7 @@ func (m *manager) clearReservation(jid string) *Reservation {
 func (m *manager) processFailure(jid string, failure *FailPayload) error {
 	res := m.clearReservation(jid)
 	if res == nil {
-		return fmt.Errorf("Job not found %s", jid)
+		return &JobNotFoundError{Jid: jid}
 	}
 
 	// when expiring overdue jobs in the working set, we remove in
@@ -97,11 +135,7 @@ func (m *manager) processFailure(jid string, failure *FailPayload) error {
 	m.store.Failure()
 
 	job := res.Job
-	if job.Retry == 0 {
-		// no retry, no death, completely ephemeral, goodbye
-		return nil
-	}
-
+	job.Annotate(failure.Annotations)
 	if job.Failure != nil {
 		job.Failure.RetryCount++
 		job.Failure.ErrorMessage = failure.ErrorMessage
@@ -116,34 +150,53 @@ func (m *manager) processFailure(jid string, failure *FailPayload) error {
 			Backtrace:    failure.Backtrace,
 		}
 	}
+	recordAttempt(job.Failure, res, failure)
 
+	// the middleware sees every failure, e.g. so a batch
+	// counts its jobs which won't be retried
 	return callMiddleware(m.failChain, Ctx{context.Background(), job, m}, func() error {
-		if job.Failure.RetryCount < job.Retry {
-			return retryLater(m.store, job)
+		if job.Retry == 0 {
+			// no retry, no death, completely ephemeral, goodbye
+			return nil
 		}
-		return sendToMorgue(m.store, job)
+		return m.breaker.Call(func() error {
+			if job.Failure.RetryCount < job.Retry {
+				return retryLater(m.store, job)
+			}
+			return sendToMorgue(m.store, job)
+		})
 	})
 }
 
+func recordAttempt(f *client.Failure, res *Reservation, failure *FailPayload) {
+	attempt := &client.Attempt{
+		FailedAt:     util.Nows(),
+		ErrorMessage: failure.ErrorMessage,
+		ErrorType:    failure.ErrorType,
+		Backtrace:    failure.Backtrace,
+	}
+	if !res.tsince.IsZero() {
+		attempt.Runtime = time.Since(res.tsince).Seconds()
+	}
+	f.Attempts = append(f.Attempts, attempt)
+	if len(f.Attempts) > MaxFailureAttempts {
+		f.Attempts = f.Attempts[len(f.Attempts)-MaxFailureAttempts:]
+	}
+}
+
 func retryLater(store storage.Store, job *client.Job) error {
 	when := util.Thens(nextRetry(job))
 	job.Failure.NextAt = when
-	bytes, err := json.Marshal(job)
-	if err != nil {
-		return err
-	}
-
-	return store.Retries().AddElement(when, job.Jid, bytes)
+	return marshal(job, func(data []byte) error {
+		return store.Retries().AddElement(when, job.Jid, data)
+	})
 }
 
 func sendToMorgue(store storage.Store, job *client.Job) error {
-	bytes, err := json.Marshal(job)
-	if err != nil {
-		return err
-	}
-
 	expiry := util.Thens(time.Now().Add(DeadTTL))
-	return store.Dead().AddElement(expiry, job.Jid, bytes)
+	return marshal(job, func(data []byte) error {
+		return store.Dead().AddElement(expiry, job.Jid, data)
+	})
 }
 
 func nextRetry(job *client.Job) time.Time {
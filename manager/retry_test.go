@@ -1,6 +1,8 @@
 package manager
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/contribsys/faktory/client"
@@ -58,6 +60,18 @@ func TestRetry(t *testing.T) {
 			assert.EqualValues(t, 1, store.Dead().Size())
 			assert.EqualValues(t, 2, store.TotalProcessed())
 			assert.EqualValues(t, 2, store.TotalFailures())
+
+			attempts := job.Failure.Attempts
+			assert.Equal(t, 2, len(attempts))
+			assert.Equal(t, "SomeError", attempts[0].ErrorType)
+			assert.Equal(t, "uh no again", attempts[1].ErrorMessage)
+			assert.True(t, attempts[1].Runtime >= 0)
+
+			for i := 0; i < MaxFailureAttempts; i++ {
+				recordAttempt(job.Failure, &Reservation{}, failure(job.Jid, "again", "LastError", nil))
+			}
+			assert.Equal(t, MaxFailureAttempts, len(job.Failure.Attempts))
+			assert.Equal(t, "LastError", job.Failure.Attempts[0].ErrorType)
 		})
 
 		t.Run("FailOneShotJob", func(t *testing.T) {
@@ -105,7 +119,58 @@ func TestRetry(t *testing.T) {
 			assert.Error(t, err)
 			assert.Contains(t, err.Error(), "not found")
 		})
+
+		t.Run("FailWithAnnotations", func(t *testing.T) {
+			store.Flush()
+			m := NewManager(store).(*manager)
+
+			job := client.NewJob("ManagerPush", 1, 2, 3)
+			job.Retry = 5
+			err := m.reserve("workerId", job)
+			assert.NoError(t, err)
+
+			err = m.Annotate(job.Jid, map[string]string{"attempt": "1"})
+			assert.NoError(t, err)
+
+			fail := failure(job.Jid, "uh no", "SomeError", nil)
+			fail.Annotations = map[string]string{"external_ref": "INV-1234"}
+			err = m.Fail(fail)
+			assert.NoError(t, err)
+			assert.EqualValues(t, 1, store.Retries().Size())
+
+			var retried *client.Job
+			err = store.Retries().Each(func(idx int, entry storage.SortedEntry) error {
+				retried, err = entry.Job()
+				return err
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, "INV-1234", retried.Annotations["external_ref"])
+			assert.Equal(t, "1", retried.Annotations["attempt"])
+
+			err = m.Annotate(job.Jid, map[string]string{"attempt": "2"})
+			assert.Error(t, err)
+		})
+	})
+}
+
+func TestCleanseAnnotations(t *testing.T) {
+	assert.Nil(t, cleanseAnnotations(nil))
+	assert.Nil(t, cleanseAnnotations(map[string]string{}))
+
+	result := cleanseAnnotations(map[string]string{
+		" ":     "blank keys are dropped",
+		" ref ": "INV-1234",
+		"long":  strings.Repeat("x", 1000),
 	})
+	assert.Equal(t, 2, len(result))
+	assert.Equal(t, "INV-1234", result["ref"])
+	assert.Equal(t, MaxAnnotationValueSize, len(result["long"]))
+
+	many := map[string]string{}
+	for i := 0; i < 100; i++ {
+		many[fmt.Sprintf("key%d", i)] = "value"
+	}
+	assert.Equal(t, MaxAnnotations, len(cleanseAnnotations(many)))
 }
 
 func failure(jid, msg, errtype string, bt []string) *FailPayload {
@@ -43,7 +43,7 @@ func (m *manager) schedule(set storage.SortedSet) (int64, error) {
 			continue
 		}
 
-		err = m.enqueue(&job)
+		err = m.requeue(&job)
 		if err != nil {
 			util.Warnf("Error pushing job to '%s': %s", job.Queue, err.Error())
 			continue
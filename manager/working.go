@@ -3,6 +3,7 @@ package manager
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"time"
 
 	"github.com/contribsys/faktory/client"
@@ -17,6 +18,16 @@ var (
 	}
 )
 
+// JobNotFoundError is returned for a jid which isn't reserved,
+// e.g. because its reservation expired.
+type JobNotFoundError struct {
+	Jid string
+}
+
+func (e *JobNotFoundError) Error() string {
+	return fmt.Sprintf("Job not found %s", e.Jid)
+}
+
 type Reservation struct {
 	Job     *client.Job `json:"job"`
 	Since   string      `json:"reserved_at"`
@@ -26,6 +37,32 @@ type Reservation struct {
 	texpiry time.Time
 }
 
+// Progress returns the fraction of the reservation's timeout
+// which has elapsed at the given time, 1.0 or more means the
+// reservation has expired and the job will be reaped.
+func (res *Reservation) Progress(now time.Time) float64 {
+	total := res.texpiry.Sub(res.tsince)
+	if total <= 0 {
+		return 1
+	}
+	return float64(now.Sub(res.tsince)) / float64(total)
+}
+
+// OverdueReservations returns the reservations which have used
+// at least the given fraction of their timeout.
+func (m *manager) OverdueReservations(fraction float64, now time.Time) []*Reservation {
+	m.workingMutex.RLock()
+	defer m.workingMutex.RUnlock()
+
+	overdue := []*Reservation{}
+	for _, res := range m.workingMap {
+		if res.Progress(now) >= fraction {
+			overdue = append(overdue, res)
+		}
+	}
+	return overdue
+}
+
 func (m *manager) WorkingCount() int {
 	m.workingMutex.RLock()
 	defer m.workingMutex.RUnlock()
@@ -64,6 +101,8 @@ func (m *manager) loadWorkingSet() error {
 		if err != nil {
 			return err
 		}
+		res.tsince, _ = util.ParseTime(res.Since)
+		res.texpiry, _ = util.ParseTime(res.Expiry)
 		m.workingMap[res.Job.Jid] = &res
 		addedCount++
 		return nil
@@ -77,21 +116,83 @@ func (m *manager) loadWorkingSet() error {
 	return err
 }
 
-func (m *manager) reserve(wid string, job *client.Job) error {
-	now := time.Now()
-	timeout := job.ReserveFor
-	if timeout == 0 {
-		timeout = DefaultTimeout
+/*
+ * The in-memory reservations are authoritative while we're
+ * running.  If Redis restarts and loses recent writes, its working
+ * set no longer matches: reservations made since the last save are
+ * missing and acknowledged jobs would be reaped and run again.
+ */
+func (m *manager) restoreWorkingSet() error {
+	m.workingMutex.RLock()
+	defer m.workingMutex.RUnlock()
+
+	stale := [][]byte{}
+	present := map[string]bool{}
+	err := m.store.Working().Each(func(idx int, entry storage.SortedEntry) error {
+		var res Reservation
+		err := json.Unmarshal(entry.Value(), &res)
+		if err != nil {
+			return err
+		}
+		present[res.Job.Jid] = true
+		if _, ok := m.workingMap[res.Job.Jid]; !ok {
+			key, err := entry.Key()
+			if err != nil {
+				return err
+			}
+			stale = append(stale, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range stale {
+		_, err := m.store.Working().Remove(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	restored := 0
+	for jid, res := range m.workingMap {
+		if present[jid] {
+			continue
+		}
+		data, err := json.Marshal(res)
+		if err != nil {
+			return err
+		}
+		err = m.store.Working().AddElement(res.Expiry, jid, data)
+		if err != nil {
+			return err
+		}
+		restored++
+	}
+	if len(stale) > 0 || restored > 0 {
+		util.Infof("Restored working set: %d missing reservations added, %d stale removed", restored, len(stale))
 	}
+	return nil
+}
 
-	if timeout < 60 {
-		util.Warnf("Timeout too short %d, 60 seconds minimum", timeout)
-		timeout = DefaultTimeout
+// ReserveTimeout is how many seconds the job will be reserved
+// for, the default if its reserve_for is out of range
+func ReserveTimeout(job *client.Job) int {
+	timeout := job.ReserveFor
+	if timeout < 60 || timeout > 86400 {
+		return DefaultTimeout
 	}
+	return timeout
+}
 
-	if timeout > 86400 {
-		util.Warnf("Timeout too long %d, one day maximum", timeout)
-		timeout = DefaultTimeout
+func (m *manager) reserve(wid string, job *client.Job) error {
+	now := time.Now()
+	timeout := ReserveTimeout(job)
+	if job.ReserveFor != 0 && job.ReserveFor < 60 {
+		util.Warnf("Timeout too short %d, 60 seconds minimum", job.ReserveFor)
+	}
+	if job.ReserveFor > 86400 {
+		util.Warnf("Timeout too long %d, one day maximum", job.ReserveFor)
 	}
 
 	exp := now.Add(time.Duration(timeout) * time.Second)
@@ -129,7 +230,10 @@ func (m *manager) ack(jid string) (*client.Job, error) {
 	}
 
 	// doesn't matter, might not have acknowledged in time
-	_, err := m.store.Working().RemoveElement(res.Expiry, jid)
+	err := m.breaker.Call(func() error {
+		_, err := m.store.Working().RemoveElement(res.Expiry, jid)
+		return err
+	})
 	return res.Job, err
 }
 
@@ -149,6 +253,62 @@ func (m *manager) Acknowledge(jid string) (*client.Job, error) {
 	return job, err
 }
 
+func (m *manager) Annotate(jid string, annotations map[string]string) error {
+	annotations = cleanseAnnotations(annotations)
+	if annotations == nil {
+		return nil
+	}
+
+	m.workingMutex.Lock()
+	defer m.workingMutex.Unlock()
+
+	res, ok := m.workingMap[jid]
+	if !ok {
+		return &JobNotFoundError{Jid: jid}
+	}
+	res.Job.Annotate(annotations)
+	return nil
+}
+
+// RequeueWorking returns the reserved jobs to the front of their
+// queues when the server shuts down before workers finish them,
+// rather than leaving them until their reservations expire.  A
+// worker which later acknowledges one will find it isn't reserved
+// and the job will run again.
+func (m *manager) RequeueWorking() (int, error) {
+	m.workingMutex.RLock()
+	jids := make([]string, 0, len(m.workingMap))
+	for jid := range m.workingMap {
+		jids = append(jids, jid)
+	}
+	m.workingMutex.RUnlock()
+
+	return m.Release(jids...)
+}
+
+// Release returns the reserved jobs to the front of their queues,
+// skipping any which are no longer reserved.
+func (m *manager) Release(jids ...string) (int, error) {
+	count := 0
+	for _, jid := range jids {
+		res := m.clearReservation(jid)
+		if res == nil {
+			// acknowledged meanwhile
+			continue
+		}
+		_, err := m.store.Working().RemoveElement(res.Expiry, jid)
+		if err != nil {
+			return count, err
+		}
+		err = m.PushFirst(res.Job)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
 func (m *manager) ReapExpiredJobs(timestamp string) (int, error) {
 	elms, err := m.store.Working().RemoveBefore(timestamp)
 	if err != nil {
@@ -30,6 +30,22 @@ func TestLoadWorkingSet(t *testing.T) {
 			m2 := NewManager(store).(*manager)
 			assert.EqualValues(t, 1, store.Working().Size())
 			assert.EqualValues(t, 1, m2.WorkingCount())
+			assert.InDelta(t, 0.5, m2.workingMap[job.Jid].Progress(time.Now().Add(300*time.Second)), 0.01)
+		})
+
+		t.Run("OverdueReservations", func(t *testing.T) {
+			store.Flush()
+			m := NewManager(store).(*manager)
+
+			job := client.NewJob("WorkingJob", 1, 2, 3)
+			job.ReserveFor = 600
+			err := m.reserve("workerId", job)
+			assert.NoError(t, err)
+
+			assert.Equal(t, 0, len(m.OverdueReservations(0.8, time.Now())))
+			overdue := m.OverdueReservations(0.8, time.Now().Add(500*time.Second))
+			assert.Equal(t, 1, len(overdue))
+			assert.Equal(t, job.Jid, overdue[0].Job.Jid)
 		})
 
 		t.Run("ManagerReserve", func(t *testing.T) {
@@ -0,0 +1,356 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/storage"
+	"github.com/contribsys/faktory/util"
+	"github.com/go-redis/redis"
+)
+
+/*
+ * Queue alerts notify the team owning a queue directly, rather than
+ * through the anomaly events, by POSTing to its webhook when the
+ * queue's depth or latency crosses a threshold:
+ *
+ * [alerts.billing]
+ * url = "https://hooks.example.com/billing-team"
+ * depth = 10000        # jobs, 0 disables
+ * latency = 300        # seconds the oldest job has waited, 0 disables
+ * recover = 0.8        # fraction of the threshold to fall below before
+ *                      # the alert clears, default 0.8
+ * secret = "..."       # optional, signs the body like inbound webhooks
+ *
+ * The section name may be a pattern, e.g. [alerts."billing_*"], an
+ * exact name is preferred over a pattern.  Queues are checked every
+ * 10 seconds.  A "raised" event is sent when a metric crosses its
+ * threshold and a "recovered" event once it falls below the threshold
+ * times recover, so a queue hovering around the threshold doesn't send
+ * an event each check.  The body is JSON:
+ *
+ * {"event":"raised","queue":"billing","metric":"depth","value":10250,"threshold":10000,"at":"..."}
+ *
+ * With a secret, X-Faktory-Signature is "sha256=" and the hex HMAC-SHA256
+ * of the body.  Delivery is best effort, failures are logged.
+ */
+const (
+	alertDepth   = "depth"
+	alertLatency = "latency"
+
+	alertTimeout = 5 * time.Second
+)
+
+type queueAlert struct {
+	pattern string
+	url     string
+	secret  []byte
+	depth   uint64
+	latency time.Duration
+	recover float64
+}
+
+// QueueAlert is the event POSTed to a queue's alert webhook
+type QueueAlert struct {
+	Event     string    `json:"event"`
+	Queue     string    `json:"queue"`
+	Metric    string    `json:"metric"`
+	Value     int64     `json:"value"`
+	Threshold int64     `json:"threshold"`
+	At        time.Time `json:"at"`
+}
+
+type alerter struct {
+	mu    sync.Mutex
+	rules []*queueAlert
+	// queue => metric => raised
+	raised map[string]map[string]bool
+	s      *Server
+	hc     *http.Client
+
+	sent   int64
+	failed int64
+}
+
+func AlertsSubsystem() Subsystem {
+	return &alerter{raised: map[string]map[string]bool{}, hc: &http.Client{Timeout: alertTimeout}}
+}
+
+// External sends alerts outside the server
+func (a *alerter) External() {}
+
+func (a *alerter) Start(s *Server) error {
+	a.s = s
+	err := a.configure(s)
+	if err != nil {
+		return err
+	}
+	s.AddTask(10, a)
+	return nil
+}
+
+func (a *alerter) Reload(s *Server) error {
+	return a.configure(s)
+}
+
+func (a *alerter) configure(s *Server) error {
+	rules, err := parseAlerts(s.Options.GlobalConfig["alerts"])
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rules = rules
+	return nil
+}
+
+func parseAlerts(config interface{}) ([]*queueAlert, error) {
+	rules := []*queueAlert{}
+	if config == nil {
+		return rules, nil
+	}
+	mapp, ok := config.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Invalid alerts configuration")
+	}
+
+	for pattern, val := range mapp {
+		cfg, ok := val.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Invalid configuration for queue alert %s", pattern)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Invalid queue pattern %q for alerts", pattern)
+		}
+		rule := &queueAlert{pattern: pattern, recover: 0.8}
+		rule.url, _ = cfg["url"].(string)
+		if rule.url == "" {
+			return nil, fmt.Errorf("Queue alert %s requires a url", pattern)
+		}
+		if secret, ok := cfg["secret"].(string); ok {
+			rule.secret = []byte(secret)
+		}
+
+		nums := map[string]float64{}
+		for _, key := range []string{"depth", "latency", "recover"} {
+			if cfg[key] == nil {
+				continue
+			}
+			num, err := strconv.ParseFloat(fmt.Sprintf("%v", cfg[key]), 64)
+			if err != nil || num < 0 {
+				return nil, fmt.Errorf("Queue alert %s: %s must be a positive number, not %v", pattern, key, cfg[key])
+			}
+			nums[key] = num
+		}
+		rule.depth = uint64(nums["depth"])
+		rule.latency = time.Duration(nums["latency"] * float64(time.Second))
+		if ratio, ok := nums["recover"]; ok {
+			if ratio <= 0 || ratio > 1 {
+				return nil, fmt.Errorf("Queue alert %s: recover must be between 0 and 1, not %v", pattern, ratio)
+			}
+			rule.recover = ratio
+		}
+		if rule.depth == 0 && rule.latency == 0 {
+			return nil, fmt.Errorf("Queue alert %s requires a depth or latency threshold", pattern)
+		}
+		rules = append(rules, rule)
+	}
+	// exact names first, then patterns in a stable order
+	sort.Slice(rules, func(i, j int) bool {
+		iexact, jexact := isLiteral(rules[i].pattern), isLiteral(rules[j].pattern)
+		if iexact != jexact {
+			return iexact
+		}
+		return rules[i].pattern < rules[j].pattern
+	})
+	return rules, nil
+}
+
+func isLiteral(pattern string) bool {
+	return !strings.ContainsAny(pattern, `*?[\`)
+}
+
+func (a *alerter) rule(queue string) *queueAlert {
+	for _, rule := range a.rules {
+		if ok, _ := path.Match(rule.pattern, queue); ok {
+			return rule
+		}
+	}
+	return nil
+}
+
+func (a *alerter) Name() string {
+	return "Alerts"
+}
+
+func (a *alerter) Stats() map[string]interface{} {
+	return map[string]interface{}{
+		"sent":   atomic.LoadInt64(&a.sent),
+		"failed": atomic.LoadInt64(&a.failed),
+	}
+}
+
+// Execute checks each queue with an alert against its thresholds
+func (a *alerter) Execute() error {
+	a.mu.Lock()
+	rules := len(a.rules)
+	a.mu.Unlock()
+	if rules == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	queues := map[string]bool{}
+	var err error
+	a.s.Store().EachQueue(func(q storage.Queue) {
+		queues[q.Name()] = true
+		if qerr := a.check(now, q); qerr != nil {
+			err = qerr
+		}
+	})
+	a.forget(queues)
+	return err
+}
+
+func (a *alerter) check(now time.Time, q storage.Queue) error {
+	a.mu.Lock()
+	rule := a.rule(q.Name())
+	a.mu.Unlock()
+	if rule == nil {
+		return nil
+	}
+
+	if rule.depth > 0 {
+		a.observe(rule, now, q.Name(), alertDepth, int64(q.Size()), int64(rule.depth))
+	}
+	if rule.latency > 0 {
+		latency, err := a.latency(now, q.Name())
+		if err != nil {
+			return err
+		}
+		a.observe(rule, now, q.Name(), alertLatency, int64(latency/time.Second), int64(rule.latency/time.Second))
+	}
+	return nil
+}
+
+// latency is how long the oldest job in the queue has waited,
+// jobs are pushed to the head so it's at the tail
+func (a *alerter) latency(now time.Time, queue string) (time.Duration, error) {
+	data, err := a.s.Store().Redis().LIndex(queue, -1).Bytes()
+	if err == redis.Nil {
+		// an empty queue has no latency
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var job client.Job
+	err = json.Unmarshal(data, &job)
+	if err != nil {
+		return 0, err
+	}
+	enqueued, err := util.ParseTime(job.EnqueuedAt)
+	if err != nil {
+		return 0, nil
+	}
+	return now.Sub(enqueued), nil
+}
+
+// observe raises the alert when the value reaches the threshold and
+// clears it once the value falls below threshold * recover
+func (a *alerter) observe(rule *queueAlert, now time.Time, queue string, metric string, value int64, threshold int64) {
+	a.mu.Lock()
+	metrics, ok := a.raised[queue]
+	if !ok {
+		metrics = map[string]bool{}
+		a.raised[queue] = metrics
+	}
+	raised := metrics[metric]
+	event := ""
+	if !raised && value >= threshold {
+		event = "raised"
+	} else if raised && float64(value) < float64(threshold)*rule.recover {
+		event = "recovered"
+	}
+	if event != "" {
+		metrics[metric] = event == "raised"
+	}
+	a.mu.Unlock()
+	if event == "" {
+		return
+	}
+
+	alert := &QueueAlert{Event: event, Queue: queue, Metric: metric, Value: value, Threshold: threshold, At: now}
+	if event == "raised" {
+		util.Warnf("Queue %s %s %d reached the alert threshold of %d", queue, metric, value, threshold)
+	} else {
+		util.Infof("Queue %s %s %d recovered below the alert threshold of %d", queue, metric, value, threshold)
+	}
+	a.s.Go(func(ctx context.Context) {
+		a.deliver(ctx, rule, alert)
+	})
+}
+
+// forget drops the state of queues which no longer exist
+func (a *alerter) forget(queues map[string]bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for queue := range a.raised {
+		if !queues[queue] {
+			delete(a.raised, queue)
+		}
+	}
+}
+
+func (a *alerter) deliver(ctx context.Context, rule *queueAlert, alert *QueueAlert) {
+	err := a.post(ctx, rule, alert)
+	if err != nil {
+		atomic.AddInt64(&a.failed, 1)
+		util.Warnf("Unable to send %s alert for queue %s to %s: %v", alert.Metric, alert.Queue, rule.url, err)
+		return
+	}
+	atomic.AddInt64(&a.sent, 1)
+}
+
+func (a *alerter) post(ctx context.Context, rule *queueAlert, alert *QueueAlert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", rule.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Faktory-Event", "queue_alert")
+	if len(rule.secret) > 0 {
+		mac := hmac.New(sha256.New, rule.secret)
+		mac.Write(body)
+		req.Header.Set("X-Faktory-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := a.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
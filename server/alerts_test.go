@@ -0,0 +1,138 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAlerts(t *testing.T) {
+	rules, err := parseAlerts(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(rules))
+
+	for _, cfg := range []map[string]interface{}{
+		{"billing": "nope"},
+		{"billing": map[string]interface{}{"depth": int64(10)}},
+		{"billing": map[string]interface{}{"url": "http://example.com"}},
+		{"billing": map[string]interface{}{"url": "http://example.com", "depth": "lots"}},
+		{"billing": map[string]interface{}{"url": "http://example.com", "depth": int64(10), "recover": 1.5}},
+		{"billing[": map[string]interface{}{"url": "http://example.com", "depth": int64(10)}},
+	} {
+		_, err := parseAlerts(cfg)
+		assert.Error(t, err, "%v", cfg)
+	}
+
+	rules, err = parseAlerts(map[string]interface{}{
+		"billing_*":      map[string]interface{}{"url": "http://example.com/team", "depth": int64(100)},
+		"billing_urgent": map[string]interface{}{"url": "http://example.com/oncall", "latency": int64(60), "recover": 0.5},
+	})
+	assert.NoError(t, err)
+	a := &alerter{rules: rules}
+	assert.Equal(t, "http://example.com/oncall", a.rule("billing_urgent").url)
+	assert.Equal(t, time.Minute, a.rule("billing_urgent").latency)
+	assert.Equal(t, 0.5, a.rule("billing_urgent").recover)
+	assert.Equal(t, "http://example.com/team", a.rule("billing_reports").url)
+	assert.Equal(t, 0.8, a.rule("billing_reports").recover)
+	assert.Nil(t, a.rule("default"))
+}
+
+func TestAlerts(t *testing.T) {
+	alerts := make(chan *QueueAlert, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		mac := hmac.New(sha256.New, []byte("s3cr3t"))
+		mac.Write(body)
+		assert.Equal(t, "sha256="+hex.EncodeToString(mac.Sum(nil)), r.Header.Get("X-Faktory-Signature"))
+
+		var alert QueueAlert
+		assert.NoError(t, json.Unmarshal(body, &alert))
+		alerts <- &alert
+	}))
+	defer ts.Close()
+
+	s, _, stop := newTestServer(t, &ServerOptions{GlobalConfig: map[string]interface{}{
+		"alerts": map[string]interface{}{
+			"billing": map[string]interface{}{"url": ts.URL, "secret": "s3cr3t", "depth": int64(3), "latency": int64(60), "recover": 0.5},
+		},
+	}})
+	defer stop()
+	defer func() {
+		s.Shutdown()
+		s.Wait()
+	}()
+
+	a := AlertsSubsystem().(*alerter)
+	assert.NoError(t, a.Start(s))
+
+	q, err := s.store.GetQueue("billing")
+	assert.NoError(t, err)
+	push := func(enqueued time.Time) {
+		job := client.NewJob("Invoice", 1)
+		job.Queue = "billing"
+		job.EnqueuedAt = util.Thens(enqueued)
+		data, err := json.Marshal(job)
+		assert.NoError(t, err)
+		assert.NoError(t, q.Push(data))
+	}
+	quiet := func() {
+		assert.NoError(t, a.Execute())
+		select {
+		case alert := <-alerts:
+			t.Fatalf("unexpected alert %v", alert)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	push(time.Now())
+	push(time.Now())
+	quiet()
+
+	push(time.Now())
+	assert.NoError(t, a.Execute())
+	alert := <-alerts
+	assert.Equal(t, "raised", alert.Event)
+	assert.Equal(t, "billing", alert.Queue)
+	assert.Equal(t, "depth", alert.Metric)
+	assert.Equal(t, int64(3), alert.Value)
+	assert.Equal(t, int64(3), alert.Threshold)
+
+	// still above half the threshold
+	_, err = q.Pop()
+	assert.NoError(t, err)
+	quiet()
+
+	_, err = q.Clear()
+	assert.NoError(t, err)
+	push(time.Now().Add(-2 * time.Minute))
+	assert.NoError(t, a.Execute())
+	events := map[string]*QueueAlert{}
+	for idx := 0; idx < 2; idx++ {
+		alert := <-alerts
+		events[alert.Metric] = alert
+	}
+	assert.Equal(t, "recovered", events["depth"].Event)
+	assert.Equal(t, int64(1), events["depth"].Value)
+	assert.Equal(t, "raised", events["latency"].Event)
+	assert.True(t, events["latency"].Value >= 120, "%d", events["latency"].Value)
+
+	_, err = q.Clear()
+	assert.NoError(t, err)
+	assert.NoError(t, a.Execute())
+	alert = <-alerts
+	assert.Equal(t, "recovered", alert.Event)
+	assert.Equal(t, "latency", alert.Metric)
+	s.Wait()
+	assert.Equal(t, int64(4), a.Stats()["sent"])
+}
@@ -0,0 +1,247 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/contribsys/faktory/storage"
+	"github.com/contribsys/faktory/util"
+	"github.com/go-redis/redis"
+)
+
+/*
+ * Anomaly detection keeps an exponentially weighted moving average
+ * and variance of the jobs pushed and failed per minute in each queue
+ * and raises an event when a minute deviates sharply from that
+ * trailing baseline, e.g. a burst of failures or enqueues stopping:
+ *
+ * [anomalies]
+ * threshold = 4      # z-score, 0 disables detection
+ * baseline = 60      # minutes, the span of the moving average
+ * minimum = 10       # jobs per minute, quieter minutes are ignored
+ *
+ * Detection is part of the metrics subsystem.  Events are logged,
+ * recorded as "anomaly" markers on the charts and passed to the
+ * handlers registered with Server.OnAnomaly.
+ */
+const (
+	// minutes of history needed before a queue's baseline is trusted
+	anomalyWarmup = 10
+	// a queue's metric raises at most one event per cooldown
+	anomalyCooldown = 10 * time.Minute
+)
+
+type Anomaly struct {
+	At     time.Time
+	Queue  string
+	Metric string
+	Value  int64
+	// the trailing baseline and how many standard deviations
+	// the value is from it
+	Baseline float64
+	Score    float64
+}
+
+func (a *Anomaly) String() string {
+	direction := "above"
+	if a.Score < 0 {
+		direction = "below"
+	}
+	return fmt.Sprintf("%s %s %d/min, %.1f deviations %s the baseline of %.1f",
+		a.Queue, a.Metric, a.Value, math.Abs(a.Score), direction, a.Baseline)
+}
+
+// ewma is a moving average and variance
+type ewma struct {
+	mean     float64
+	variance float64
+	samples  int
+	raised   time.Time
+}
+
+// score returns how many standard deviations the value is from the
+// average before adding it.  The deviation is at least 1 so a steady
+// queue doesn't flag every small change.
+func (e *ewma) score(val, alpha, limit float64) float64 {
+	std := math.Max(math.Sqrt(e.variance), 1)
+	z := (val - e.mean) / std
+	if e.samples >= anomalyWarmup {
+		// outliers only move the baseline as far as the limit so
+		// a spike doesn't hide the anomalies after it
+		val = math.Max(e.mean-limit*std, math.Min(val, e.mean+limit*std))
+	}
+	if e.samples == 0 {
+		e.mean = val
+	} else {
+		diff := val - e.mean
+		e.mean += alpha * diff
+		e.variance = (1 - alpha) * (e.variance + alpha*diff*diff)
+	}
+	e.samples++
+	return z
+}
+
+type anomalyDetector struct {
+	mu        sync.Mutex
+	threshold float64
+	alpha     float64
+	minimum   int64
+	// queue => metric => baseline
+	series map[string]map[string]*ewma
+	// the last minute which was checked
+	checked  int64
+	handlers []func(*Anomaly)
+}
+
+func newAnomalyDetector() *anomalyDetector {
+	return &anomalyDetector{series: map[string]map[string]*ewma{}}
+}
+
+func (d *anomalyDetector) configure(s *Server) {
+	threshold := s.Options.Float("anomalies", "threshold", 4)
+	baseline := s.Options.Int("anomalies", "baseline", 60)
+	if baseline < anomalyWarmup {
+		util.Warnf("Anomaly baseline must be at least %d minutes", anomalyWarmup)
+		baseline = anomalyWarmup
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.threshold = threshold
+	d.alpha = 2 / (float64(baseline) + 1)
+	d.minimum = int64(s.Options.Int("anomalies", "minimum", 10))
+}
+
+// observe adds a minute of the queue's metrics to its baselines and
+// returns any anomalies.  Failures only raise events when they increase,
+// enqueues raise events in both directions.
+func (d *anomalyDetector) observe(at time.Time, queue string, pushed, failed int64) []*Anomaly {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.threshold <= 0 {
+		return nil
+	}
+
+	series, ok := d.series[queue]
+	if !ok {
+		series = map[string]*ewma{}
+		d.series[queue] = series
+	}
+
+	var anomalies []*Anomaly
+	for _, m := range []struct {
+		metric string
+		value  int64
+		drops  bool
+	}{
+		{metricPushed, pushed, true},
+		{metricFailed, failed, false},
+	} {
+		e, ok := series[m.metric]
+		if !ok {
+			e = &ewma{}
+			series[m.metric] = e
+		}
+		warm := e.samples >= anomalyWarmup
+		baseline := e.mean
+		z := e.score(float64(m.value), d.alpha, d.threshold)
+
+		if !warm || at.Sub(e.raised) < anomalyCooldown {
+			continue
+		}
+		if m.value < d.minimum && baseline < float64(d.minimum) {
+			continue
+		}
+		if z >= d.threshold || (m.drops && -z >= d.threshold) {
+			e.raised = at
+			anomalies = append(anomalies, &Anomaly{
+				At:       at,
+				Queue:    queue,
+				Metric:   m.metric,
+				Value:    m.value,
+				Baseline: baseline,
+				Score:    z,
+			})
+		}
+	}
+	return anomalies
+}
+
+// forget drops the baselines of queues which no longer exist
+func (d *anomalyDetector) forget(queues map[string]bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for queue := range d.series {
+		if !queues[queue] {
+			delete(d.series, queue)
+		}
+	}
+}
+
+func (d *anomalyDetector) raise(s *Server, a *Anomaly) {
+	util.Warnf("Anomaly detected: %s", a)
+	err := s.AddMarker(&Marker{At: a.At, Kind: "anomaly", Label: a.String()})
+	if err != nil {
+		util.Warnf("Unable to record anomaly marker: %v", err)
+	}
+
+	d.mu.Lock()
+	handlers := d.handlers
+	d.mu.Unlock()
+	for _, fn := range handlers {
+		fn(a)
+	}
+}
+
+// checkAnomalies observes every queue's last complete minute once
+func (m *queueMetrics) checkAnomalies() error {
+	d := m.anomalies
+	minute := m.minute() - 1
+	d.mu.Lock()
+	if minute <= d.checked {
+		d.mu.Unlock()
+		return nil
+	}
+	d.checked = minute
+	d.mu.Unlock()
+
+	queues := map[string]*redis.StringStringMapCmd{}
+	_, err := m.rclient.Pipelined(func(pipe redis.Pipeliner) error {
+		m.store.EachQueue(func(q storage.Queue) {
+			queues[q.Name()] = pipe.HGetAll(metricsKey(q.Name(), minute))
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	at := time.Unix((minute+1)*60, 0)
+	names := map[string]bool{}
+	for name, cmd := range queues {
+		names[name] = true
+		vals := cmd.Val()
+		pushed, _ := strconv.ParseInt(vals[metricPushed], 10, 64)
+		failed, _ := strconv.ParseInt(vals[metricFailed], 10, 64)
+		for _, a := range d.observe(at, name, pushed, failed) {
+			d.raise(m.server, a)
+		}
+	}
+	d.forget(names)
+	return nil
+}
+
+// OnAnomaly registers a handler called with each anomaly the
+// metrics subsystem detects, e.g. to send an alert.
+func (s *Server) OnAnomaly(fn func(*Anomaly)) {
+	m := s.metrics()
+	if m == nil {
+		return
+	}
+	m.anomalies.mu.Lock()
+	m.anomalies.handlers = append(m.anomalies.handlers, fn)
+	m.anomalies.mu.Unlock()
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnomalyDetection(t *testing.T) {
+	d := newAnomalyDetector()
+	d.threshold = 4
+	d.alpha = 2.0 / 31
+	d.minimum = 10
+
+	at := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	minute := func(pushed, failed int64) []*Anomaly {
+		at = at.Add(time.Minute)
+		return d.observe(at, "default", pushed, failed)
+	}
+
+	// no events while the baseline warms up
+	assert.Equal(t, 0, len(minute(100, 0)))
+	assert.Equal(t, 0, len(minute(300, 40)))
+	for i := 0; i < 30; i++ {
+		assert.Equal(t, 0, len(minute(100+int64(i%5)*5, int64(i%2))), i)
+	}
+
+	found := minute(400, 1)
+	assert.Equal(t, 1, len(found))
+	assert.Equal(t, "pushed", found[0].Metric)
+	assert.EqualValues(t, 400, found[0].Value)
+	assert.True(t, found[0].Score > 4)
+	assert.Contains(t, found[0].String(), "default pushed 400/min")
+
+	// failures are below the minimum
+	assert.Equal(t, 0, len(minute(110, 8)))
+	found = minute(110, 60)
+	assert.Equal(t, 1, len(found))
+	assert.Equal(t, "failed", found[0].Metric)
+	// at most one event per cooldown
+	assert.Equal(t, 0, len(minute(110, 90)))
+
+	for i := 0; i < 10; i++ {
+		minute(100, 0)
+	}
+	found = minute(0, 0)
+	assert.Equal(t, 1, len(found))
+	assert.Equal(t, "pushed", found[0].Metric)
+	assert.True(t, found[0].Score < -4)
+	assert.Contains(t, found[0].String(), "below")
+
+	d.forget(map[string]bool{"other": true})
+	assert.Equal(t, 0, len(d.series))
+
+	d.threshold = 0
+	assert.Nil(t, d.observe(at, "default", 1000, 1000))
+}
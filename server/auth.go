@@ -0,0 +1,177 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+ * Workers authenticate in their HELLO with the credentials the
+ * configured provider asks for.  Without an [auth] section the
+ * server password is used, as before:
+ *
+ * [auth]
+ * provider = "token"
+ * tokens = ["s3cr3t", "0ld-s3cr3t"]
+ *
+ * [auth]
+ * provider = "ldap"
+ * url = "ldaps://ldap.example.com"
+ * dn = "uid=%s,ou=workers,dc=example,dc=com"
+ *
+ * [auth]
+ * provider = "oidc"
+ * introspection_url = "https://idp.example.com/oauth2/introspect"
+ * client_id = "faktory"           # the server's own credentials
+ * client_secret = "..."
+ * audience = "faktory"            # optional
+ *
+ * The HI tells the client which credentials to send: the salted
+ * password hash, a bearer token in the URL's password for "token"
+ * and "oidc" (client-credentials access tokens), or the URL's user
+ * name and password for "ldap".  Tokens and passwords are sent as
+ * they are so providers other than "password" require [tls].
+ *
+ * Other providers can be added with RegisterAuthProvider before
+ * the server boots.
+ */
+
+// Credential mechanisms announced in the HI
+const (
+	MechanismPassword = "password"
+	MechanismToken    = "token"
+	MechanismPlain    = "plain"
+)
+
+// AuthTimeout is how long a provider may take to check the credentials,
+// e.g. with a call to an identity server.
+var AuthTimeout = 5 * time.Second
+
+// Credentials are sent by the client in its HELLO.  PasswordHash is
+// checked against the Salt and Iterations sent in the HI.
+type Credentials struct {
+	Username     string
+	Password     string
+	Token        string
+	PasswordHash string
+	Salt         string
+	Iterations   int
+}
+
+// AuthProvider verifies the credentials of each connection.
+type AuthProvider interface {
+	// Mechanism returns the credentials clients must send.
+	Mechanism() string
+	// Authenticate returns an error if the credentials are invalid.
+	Authenticate(creds *Credentials) error
+}
+
+type AuthProviderFunc func(s *Server) (AuthProvider, error)
+
+var (
+	authMu        sync.Mutex
+	authProviders = map[string]AuthProviderFunc{
+		"password": newPasswordAuth,
+		"token":    newTokenAuth,
+		"ldap":     newLDAPAuth,
+		"oidc":     newOIDCAuth,
+	}
+)
+
+// RegisterAuthProvider makes a provider available as
+// [auth] provider = "name".
+func RegisterAuthProvider(name string, fn AuthProviderFunc) {
+	authMu.Lock()
+	defer authMu.Unlock()
+	authProviders[name] = fn
+}
+
+// newAuthProvider returns the configured provider or nil if
+// connections aren't authenticated.
+func newAuthProvider(s *Server) (AuthProvider, error) {
+	name := s.Options.String("auth", "provider", "password")
+
+	authMu.Lock()
+	fn, ok := authProviders[name]
+	names := []string{}
+	for known := range authProviders {
+		names = append(names, known)
+	}
+	authMu.Unlock()
+
+	if !ok {
+		sort.Strings(names)
+		return nil, fmt.Errorf("Unknown auth provider %q, expected one of %v", name, names)
+	}
+	return fn(s)
+}
+
+// requireTLS refuses providers whose credentials are sent as they
+// are unless connections are encrypted
+func (s *Server) requireTLS(auth AuthProvider, encrypted bool) error {
+	if auth == nil || auth.Mechanism() == MechanismPassword || encrypted {
+		return nil
+	}
+	return fmt.Errorf("The %s auth provider sends credentials in clear text and requires [tls]", s.Options.String("auth", "provider", "password"))
+}
+
+type passwordAuth struct {
+	password string
+}
+
+func newPasswordAuth(s *Server) (AuthProvider, error) {
+	if s.Options.Password == "" {
+		return nil, nil
+	}
+	return &passwordAuth{s.Options.Password}, nil
+}
+
+func (p *passwordAuth) Mechanism() string {
+	return MechanismPassword
+}
+
+func (p *passwordAuth) Authenticate(creds *Credentials) error {
+	if subtle.ConstantTimeCompare([]byte(creds.PasswordHash), []byte(hash(p.password, creds.Salt, creds.Iterations))) != 1 {
+		return fmt.Errorf("Invalid password")
+	}
+	return nil
+}
+
+type tokenAuth struct {
+	tokens []string
+}
+
+func newTokenAuth(s *Server) (AuthProvider, error) {
+	tokens, ok := s.Options.Config("auth", "tokens", nil).([]interface{})
+	if !ok || len(tokens) == 0 {
+		return nil, fmt.Errorf("The token auth provider requires a list of tokens")
+	}
+	ta := &tokenAuth{}
+	for _, token := range tokens {
+		str, ok := token.(string)
+		if !ok || str == "" {
+			return nil, fmt.Errorf("Invalid auth token %v", token)
+		}
+		ta.tokens = append(ta.tokens, str)
+	}
+	return ta, nil
+}
+
+func (t *tokenAuth) Mechanism() string {
+	return MechanismToken
+}
+
+func (t *tokenAuth) Authenticate(creds *Credentials) error {
+	valid := 0
+	for _, token := range t.tokens {
+		// check every token so the time taken doesn't leak which matched
+		valid |= subtle.ConstantTimeCompare([]byte(creds.Token), []byte(token))
+	}
+	if valid != 1 {
+		return fmt.Errorf("Invalid token")
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/contribsys/faktory/client"
+)
+
+// ldapAuth checks the worker's user name and password with
+// a simple bind to an LDAP server.
+type ldapAuth struct {
+	url *url.URL
+	dn  string
+	tls *tls.Config
+}
+
+func newLDAPAuth(s *Server) (AuthProvider, error) {
+	location := s.Options.String("auth", "url", "")
+	uri, err := url.Parse(location)
+	if err != nil || (uri.Scheme != "ldap" && uri.Scheme != "ldaps") || uri.Host == "" {
+		return nil, fmt.Errorf("The ldap auth provider requires a ldap:// or ldaps:// url, not %q", location)
+	}
+	dn := s.Options.String("auth", "dn", "")
+	if strings.Count(dn, "%s") != 1 {
+		return nil, fmt.Errorf("The ldap auth provider requires a dn with one %%s for the user name")
+	}
+	if uri.Scheme == "ldap" && !isLoopback(uri.Hostname()) {
+		return nil, fmt.Errorf("The ldap auth provider sends passwords in clear text, use ldaps:// for %s", uri.Host)
+	}
+	cfg := client.RestrictTLS(&tls.Config{ServerName: uri.Hostname()})
+	return &ldapAuth{url: uri, dn: dn, tls: cfg}, nil
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (l *ldapAuth) Mechanism() string {
+	return MechanismPlain
+}
+
+func (l *ldapAuth) Authenticate(creds *Credentials) error {
+	// an LDAP bind without a password is anonymous and succeeds
+	if creds.Username == "" || creds.Password == "" {
+		return fmt.Errorf("Invalid user name or password")
+	}
+
+	address := l.url.Host
+	if l.url.Port() == "" {
+		if l.url.Scheme == "ldaps" {
+			address = net.JoinHostPort(address, "636")
+		} else {
+			address = net.JoinHostPort(address, "389")
+		}
+	}
+	dialer := &net.Dialer{Timeout: AuthTimeout}
+	var conn net.Conn
+	var err error
+	if l.url.Scheme == "ldaps" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, l.tls)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	dn := fmt.Sprintf(l.dn, ldapEscape(creds.Username))
+	return ldapBind(conn, dn, creds.Password)
+}
+
+// ldapEscape escapes a DN attribute value, see RFC 4514
+func ldapEscape(val string) string {
+	var b strings.Builder
+	for idx, c := range val {
+		switch {
+		case strings.ContainsRune(`,+"\<>;=`, c),
+			idx == 0 && (c == ' ' || c == '#'),
+			idx == len(val)-1 && c == ' ':
+			b.WriteByte('\\')
+			b.WriteRune(c)
+		case c == 0:
+			b.WriteString(`\00`)
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// ber encodes a BER element with the given tag
+func ber(tag byte, content []byte) []byte {
+	n := len(content)
+	out := []byte{tag}
+	switch {
+	case n < 0x80:
+		out = append(out, byte(n))
+	case n < 0x100:
+		out = append(out, 0x81, byte(n))
+	default:
+		out = append(out, 0x82, byte(n>>8), byte(n))
+	}
+	return append(out, content...)
+}
+
+func readBER(r *bufio.Reader) (byte, []byte, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length := int(n)
+	if n&0x80 != 0 {
+		if n&0x7f > 3 {
+			return 0, nil, fmt.Errorf("LDAP response too large")
+		}
+		length = 0
+		for i := 0; i < int(n&0x7f); i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return 0, nil, err
+			}
+			length = length<<8 | int(b)
+		}
+	}
+	content := make([]byte, length)
+	_, err = io.ReadFull(r, content)
+	return tag, content, err
+}
+
+const ldapInvalidCredentials = 49
+
+// ldapBind sends a simple BindRequest and reads its BindResponse
+func ldapBind(conn net.Conn, dn, password string) error {
+	bind := ber(0x60, concat( // [APPLICATION 0] BindRequest
+		ber(0x02, []byte{3}), // version
+		ber(0x04, []byte(dn)),
+		ber(0x80, []byte(password)), // [0] simple authentication
+	))
+	msg := ber(0x30, concat(ber(0x02, []byte{1}), bind))
+	_, err := conn.Write(msg)
+	if err != nil {
+		return err
+	}
+
+	tag, content, err := readBER(bufio.NewReader(conn))
+	if err != nil {
+		return err
+	}
+	if tag != 0x30 {
+		return fmt.Errorf("Invalid LDAP response")
+	}
+	r := bufio.NewReader(strings.NewReader(string(content)))
+	if _, _, err = readBER(r); err != nil { // message id
+		return err
+	}
+	tag, content, err = readBER(r)
+	if err != nil {
+		return err
+	}
+	if tag != 0x61 { // [APPLICATION 1] BindResponse
+		return fmt.Errorf("Invalid LDAP bind response")
+	}
+	r = bufio.NewReader(strings.NewReader(string(content)))
+	tag, code, err := readBER(r)
+	if err != nil {
+		return err
+	}
+	if tag != 0x0a || len(code) != 1 {
+		return fmt.Errorf("Invalid LDAP result code")
+	}
+	switch code[0] {
+	case 0:
+		return nil
+	case ldapInvalidCredentials:
+		return fmt.Errorf("Invalid user name or password")
+	default:
+		return fmt.Errorf("LDAP bind failed with result code %d", code[0])
+	}
+}
+
+func concat(parts ...[]byte) []byte {
+	out := []byte{}
+	for _, part := range parts {
+		out = append(out, part...)
+	}
+	return out
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/contribsys/faktory/client"
+)
+
+// oidcAuth checks the access tokens workers obtain from an identity
+// server with the OAuth2 client-credentials grant by introspecting
+// them, see RFC 7662.
+type oidcAuth struct {
+	endpoint     string
+	clientID     string
+	clientSecret string
+	audience     string
+	client       *http.Client
+}
+
+func newOIDCAuth(s *Server) (AuthProvider, error) {
+	endpoint := s.Options.String("auth", "introspection_url", "")
+	uri, err := url.Parse(endpoint)
+	if err != nil || uri.Host == "" || (uri.Scheme != "https" && !isLoopback(uri.Hostname())) {
+		return nil, fmt.Errorf("The oidc auth provider requires an https introspection_url, not %q", endpoint)
+	}
+	return &oidcAuth{
+		endpoint:     endpoint,
+		clientID:     s.Options.String("auth", "client_id", ""),
+		clientSecret: s.Options.String("auth", "client_secret", ""),
+		audience:     s.Options.String("auth", "audience", ""),
+		client:       client.HTTPClient(AuthTimeout),
+	}, nil
+}
+
+func (o *oidcAuth) Mechanism() string {
+	return MechanismToken
+}
+
+type introspection struct {
+	Active   bool        `json:"active"`
+	Audience interface{} `json:"aud"`
+}
+
+func (i *introspection) hasAudience(aud string) bool {
+	switch v := i.Audience.(type) {
+	case string:
+		return v == aud
+	case []interface{}:
+		for _, item := range v {
+			if item == aud {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (o *oidcAuth) Authenticate(creds *Credentials) error {
+	if creds.Token == "" {
+		return fmt.Errorf("Invalid token")
+	}
+	form := url.Values{"token": {creds.Token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequest("POST", o.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if o.clientID != "" {
+		req.SetBasicAuth(url.QueryEscape(o.clientID), url.QueryEscape(o.clientSecret))
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Token introspection failed: %s", resp.Status)
+	}
+
+	var result introspection
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return err
+	}
+	if !result.Active {
+		return fmt.Errorf("Invalid token")
+	}
+	if o.audience != "" && !result.hasAudience(o.audience) {
+		return fmt.Errorf("Token is not valid for %s", o.audience)
+	}
+	return nil
+}
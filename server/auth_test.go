@@ -0,0 +1,217 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func authServer(auth map[string]interface{}) *Server {
+	return &Server{Options: &ServerOptions{
+		GlobalConfig: map[string]interface{}{"auth": auth},
+	}}
+}
+
+func TestAuthProviderConfig(t *testing.T) {
+	auth, err := newAuthProvider(&Server{Options: &ServerOptions{}})
+	assert.NoError(t, err)
+	assert.Nil(t, auth)
+
+	auth, err = newAuthProvider(&Server{Options: &ServerOptions{Password: "foobar"}})
+	assert.NoError(t, err)
+	assert.Equal(t, MechanismPassword, auth.Mechanism())
+
+	for _, bad := range []map[string]interface{}{
+		{"provider": "kerberos"},
+		{"provider": "token"},
+		{"provider": "token", "tokens": []interface{}{""}},
+		{"provider": "ldap", "url": "ldap://ldap.example.com", "dn": "uid=%s,dc=example,dc=com"},
+		{"provider": "ldap", "url": "ldaps://ldap.example.com", "dn": "dc=example,dc=com"},
+		{"provider": "oidc", "introspection_url": "http://idp.example.com/introspect"},
+	} {
+		_, err := newAuthProvider(authServer(bad))
+		assert.Error(t, err, "%v", bad)
+	}
+
+	// tokens and passwords are only accepted over TLS
+	s := authServer(map[string]interface{}{"provider": "token", "tokens": []interface{}{"s3cr3t"}})
+	auth, err = newAuthProvider(s)
+	assert.NoError(t, err)
+	assert.EqualError(t, s.requireTLS(auth, false), "The token auth provider sends credentials in clear text and requires [tls]")
+	assert.NoError(t, s.requireTLS(auth, true))
+	assert.NoError(t, s.requireTLS(&passwordAuth{"foobar"}, false))
+	assert.NoError(t, s.requireTLS(nil, false))
+}
+
+func TestTokenAuth(t *testing.T) {
+	auth, err := newAuthProvider(authServer(map[string]interface{}{
+		"provider": "token",
+		"tokens":   []interface{}{"s3cr3t", "0ld-s3cr3t"},
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, MechanismToken, auth.Mechanism())
+
+	assert.NoError(t, auth.Authenticate(&Credentials{Token: "s3cr3t"}))
+	assert.NoError(t, auth.Authenticate(&Credentials{Token: "0ld-s3cr3t"}))
+	assert.Error(t, auth.Authenticate(&Credentials{Token: "s3cr3"}))
+	assert.Error(t, auth.Authenticate(&Credentials{}))
+}
+
+// fakeLDAP answers simple binds, accepting the given password for
+// the given DN
+func fakeLDAP(t *testing.T, dn, password string) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			r := bufio.NewReader(conn)
+			_, msg, err := readBER(r)
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			r = bufio.NewReader(bytes.NewReader(msg))
+			readBER(r) // message id
+			_, bind, _ := readBER(r)
+			r = bufio.NewReader(bytes.NewReader(bind))
+			readBER(r) // version
+			_, name, _ := readBER(r)
+			_, pwd, _ := readBER(r)
+
+			code := byte(ldapInvalidCredentials)
+			if string(name) == dn && string(pwd) == password {
+				code = 0
+			}
+			result := ber(0x61, concat(ber(0x0a, []byte{code}), ber(0x04, nil), ber(0x04, nil)))
+			conn.Write(ber(0x30, concat(ber(0x02, []byte{1}), result)))
+			conn.Close()
+		}
+	}()
+	return listener
+}
+
+func TestLDAPAuth(t *testing.T) {
+	listener := fakeLDAP(t, `uid=mike\,admin,ou=workers,dc=example,dc=com`, "pa55")
+	defer listener.Close()
+	auth, err := newAuthProvider(authServer(map[string]interface{}{
+		"provider": "ldap",
+		"url":      "ldap://" + listener.Addr().String(),
+		"dn":       "uid=%s,ou=workers,dc=example,dc=com",
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, MechanismPlain, auth.Mechanism())
+
+	assert.NoError(t, auth.Authenticate(&Credentials{Username: "mike,admin", Password: "pa55"}))
+	assert.EqualError(t, auth.Authenticate(&Credentials{Username: "mike,admin", Password: "wrong"}), "Invalid user name or password")
+	// an unauthenticated bind must not be attempted
+	assert.Error(t, auth.Authenticate(&Credentials{Username: "mike,admin"}))
+
+	assert.Equal(t, `\#mike\=x\+y\ `, ldapEscape("#mike=x+y "))
+}
+
+func TestOIDCAuth(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "faktory" || secret != "shh" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.FormValue("token") {
+		case "good":
+			fmt.Fprint(w, `{"active":true,"aud":["faktory","other"]}`)
+		case "elsewhere":
+			fmt.Fprint(w, `{"active":true,"aud":"other"}`)
+		default:
+			fmt.Fprint(w, `{"active":false}`)
+		}
+	}))
+	defer ts.Close()
+
+	cfg := map[string]interface{}{
+		"provider":          "oidc",
+		"introspection_url": ts.URL,
+		"client_id":         "faktory",
+		"client_secret":     "shh",
+		"audience":          "faktory",
+	}
+	auth, err := newAuthProvider(authServer(cfg))
+	assert.NoError(t, err)
+	assert.Equal(t, MechanismToken, auth.Mechanism())
+
+	assert.NoError(t, auth.Authenticate(&Credentials{Token: "good"}))
+	assert.Error(t, auth.Authenticate(&Credentials{Token: "elsewhere"}))
+	assert.Error(t, auth.Authenticate(&Credentials{Token: "expired"}))
+	assert.Error(t, auth.Authenticate(&Credentials{}))
+
+	cfg["client_secret"] = "wrong"
+	auth, err = newAuthProvider(authServer(cfg))
+	assert.NoError(t, err)
+	assert.Error(t, auth.Authenticate(&Credentials{Token: "good"}))
+}
+
+type userAuth struct{}
+
+func (u *userAuth) Mechanism() string {
+	return MechanismPlain
+}
+
+func (u *userAuth) Authenticate(creds *Credentials) error {
+	if creds.Username != "mike" || creds.Password != "pa55" {
+		return fmt.Errorf("Unknown user %s", creds.Username)
+	}
+	return nil
+}
+
+func TestAuthHandshake(t *testing.T) {
+	RegisterAuthProvider("test", func(s *Server) (AuthProvider, error) {
+		return &userAuth{}, nil
+	})
+
+	dir, err := ioutil.TempDir("", "auth")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t)
+	certFile, keyFile := writePEM(t, dir, "server", ca.issue(t, 2, "localhost", ""))
+
+	runServer("localhost:7424", func() {
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(ca.pem)
+		srv := client.DefaultServer()
+		srv.Network = "tcp+tls"
+		srv.Address = "localhost:7424"
+		srv.Timeout = 1 * time.Second
+		srv.TLS = &tls.Config{RootCAs: pool}
+
+		srv.Username = "mike"
+		cl, err := client.Dial(srv, "pa55")
+		assert.NoError(t, err)
+		_, err = cl.Info()
+		assert.NoError(t, err)
+		cl.Close()
+
+		_, err = client.Dial(srv, "wrong")
+		assert.EqualError(t, err, "AUTH Authentication failed")
+	}, func(opts *ServerOptions) {
+		opts.GlobalConfig = map[string]interface{}{
+			"auth": map[string]interface{}{"provider": "test"},
+			"tls":  map[string]interface{}{"cert": certFile, "key": keyFile},
+		}
+	})
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/contribsys/faktory/storage"
+	"github.com/contribsys/faktory/util"
+)
+
+/*
+ * Backups copy Redis' snapshot to backups/ in the storage directory,
+ * named for the time they were taken.  They're taken with the
+ * `faktory backup` command, BACKUP or the Web UI's Debug page, e.g.
+ * from cron.  Older backups are removed:
+ *
+ * [backup]
+ * retention = 7     # backups kept, 0 keeps them all
+ * key = "2019-06"   # encrypts new backups with AES-256-GCM
+ *
+ * [backup.keys]     # base64 encoded 256-bit keys
+ * 2019-06 = "..."
+ * 2018-11 = "..."   # retired keys still restore older backups
+ *
+ * Encrypted backups end in .rdb.enc and record the id of their key so
+ * keys are rotated by adding a new key, making it the current key and
+ * removing the old one once its backups have been pruned.  Keep the
+ * keys somewhere other than the backups, they can't be restored
+ * without them.
+ *
+ * Restore a backup with `faktory restore latest` or the backup's name
+ * while Faktory is stopped.
+ */
+func (s *Server) Backup() (string, error) {
+	keys, err := s.Options.BackupKeys()
+	if err != nil {
+		return "", err
+	}
+	path, err := storage.Backup(s.store.Redis(), s.backupDirectory(), s.Options.Int("backup", "retention", 7), keys)
+	if err != nil {
+		return "", err
+	}
+	util.Infof("Backed up Redis to %s", path)
+	return path, nil
+}
+
+// Backups lists the backups, newest first
+func (s *Server) Backups() ([]string, error) {
+	return storage.Backups(s.backupDirectory())
+}
+
+func (s *Server) backupDirectory() string {
+	return storage.BackupDirectory(s.Options.StorageDirectory)
+}
+
+// BackupKeys returns the keys in [backup.keys], nil if there are none
+func (so *ServerOptions) BackupKeys() (*storage.BackupKeys, error) {
+	current := so.String("backup", "key", "")
+	mapp, _ := so.Config("backup", "keys", nil).(map[string]interface{})
+	if current == "" && len(mapp) == 0 {
+		return nil, nil
+	}
+
+	keys := &storage.BackupKeys{Current: current, Keys: map[string][]byte{}}
+	for id, val := range mapp {
+		if id == "" || strings.ContainsAny(id, "\r\n") {
+			return nil, fmt.Errorf("Invalid backup key id %q", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(fmt.Sprintf("%v", val))
+		if err == nil && len(key) != 32 {
+			err = fmt.Errorf("expected 32 bytes, not %d", len(key))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid backup key %s: %v", id, err)
+		}
+		keys.Keys[id] = key
+	}
+	if current != "" && keys.Keys[current] == nil {
+		return nil, fmt.Errorf("Backups require an encryption key, %q isn't in [backup.keys]", current)
+	}
+	return keys, nil
+}
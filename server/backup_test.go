@@ -0,0 +1,36 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackupKeys(t *testing.T) {
+	keys, err := (&ServerOptions{GlobalConfig: map[string]interface{}{}}).BackupKeys()
+	assert.NoError(t, err)
+	assert.Nil(t, keys)
+
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	backup := func(cfg map[string]interface{}) error {
+		_, err := (&ServerOptions{GlobalConfig: map[string]interface{}{"backup": cfg}}).BackupKeys()
+		return err
+	}
+	assert.NoError(t, backup(map[string]interface{}{"retention": 7}))
+	assert.NoError(t, backup(map[string]interface{}{"key": "2019-06", "keys": map[string]interface{}{"2019-06": key}}))
+	// retired keys alone still restore
+	assert.NoError(t, backup(map[string]interface{}{"keys": map[string]interface{}{"2018-11": key}}))
+	assert.Error(t, backup(map[string]interface{}{"key": "2019-06"}))
+	assert.Error(t, backup(map[string]interface{}{"key": "2019-06", "keys": map[string]interface{}{"2019-06": "c2hvcnQ="}}))
+	assert.Error(t, backup(map[string]interface{}{"keys": map[string]interface{}{"2019\n06": key}}))
+
+	keys, err = (&ServerOptions{GlobalConfig: map[string]interface{}{"backup": map[string]interface{}{
+		"key":  "2019-06",
+		"keys": map[string]interface{}{"2019-06": key},
+	}}}).BackupKeys()
+	assert.NoError(t, err)
+	assert.Equal(t, "2019-06", keys.Current)
+	assert.Equal(t, bytes.Repeat([]byte{1}, 32), keys.Keys["2019-06"])
+}
@@ -0,0 +1,404 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/manager"
+	"github.com/contribsys/faktory/util"
+	"github.com/go-redis/redis"
+)
+
+/*
+ * Batches group jobs so a parent is notified once they've all run.
+ * The producer creates a batch with its callback jobs, pushes the jobs
+ * with the batch's BID in the "bid" custom attribute and commits it:
+ *
+ *   BATCH NEW {"description":"Import","success":{"jobtype":"ImportDone","args":[]}}
+ *   PUSH {"jid":"...","jobtype":"ImportRow","args":[1],"custom":{"bid":"b-..."}}
+ *   BATCH COMMIT b-...
+ *
+ * Once the batch is committed and every job has run, the "complete"
+ * callback is pushed, even if some failed and are awaiting a retry.
+ * The "success" callback is pushed once every job has succeeded, which
+ * may be after retries.  Callbacks carry the BID in the "_bid" custom
+ * attribute.  Jobs may only be pushed to an open batch, BATCH OPEN
+ * reopens a committed one until it has succeeded, e.g. for a job in the
+ * batch to add more.  Batches expire after the TTL:
+ *
+ * [batch]
+ * ttl = 2592000     # seconds, 30 days
+ */
+type batches struct {
+	rclient *redis.Client
+	mgr     manager.Manager
+	ttl     time.Duration
+}
+
+const (
+	batchSuccess  = "success"
+	batchComplete = "complete"
+)
+
+func BatchSubsystem() Subsystem {
+	return &batches{}
+}
+
+func (b *batches) Start(s *Server) error {
+	b.rclient = s.Manager().Redis()
+	b.mgr = s.Manager()
+	b.configure(s)
+
+	s.Manager().AddMiddleware("push", b.push)
+	s.Manager().AddMiddleware("schedule", b.push)
+	s.Manager().AddMiddleware("ack", b.ack)
+	s.Manager().AddMiddleware("fail", b.fail)
+	return nil
+}
+
+func (b *batches) Reload(s *Server) error {
+	b.configure(s)
+	return nil
+}
+
+func (b *batches) configure(s *Server) {
+	b.ttl = time.Duration(s.Options.Int("batch", "ttl", 30*24*60*60)) * time.Second
+}
+
+func batchKey(bid string) string {
+	return "batch-" + bid
+}
+
+// every job pushed to the batch
+func batchJobsKey(bid string) string {
+	return "batch-" + bid + "-jobs"
+}
+
+// the jobs which haven't succeeded
+func batchPendingKey(bid string) string {
+	return "batch-" + bid + "-pending"
+}
+
+// the pending jobs which have failed at least once
+func batchFailedKey(bid string) string {
+	return "batch-" + bid + "-failed"
+}
+
+func batchID(job *client.Job) string {
+	val, _ := job.GetCustom(client.BatchAttribute)
+	bid, _ := val.(string)
+	return bid
+}
+
+func errNoBatch(bid string) error {
+	return newTaggedError("NOTFOUND", fmt.Errorf("No such batch %s", bid))
+}
+
+// create saves a new batch, open for jobs, returning its BID
+func (b *batches) create(def *client.Batch) (string, error) {
+	fields := map[string]string{
+		"description": def.Description,
+		"created_at":  util.Nows(),
+		"committed":   "0",
+	}
+	for name, job := range map[string]*client.Job{batchSuccess: def.Success, batchComplete: def.Complete} {
+		if job == nil {
+			continue
+		}
+		if job.Type == "" {
+			return "", fmt.Errorf("The %s callback must have a jobtype", name)
+		}
+		if job.Jid == "" {
+			job.Jid = util.RandomJid()
+		}
+		if job.Args == nil {
+			job.Args = []interface{}{}
+		}
+		data, err := json.Marshal(job)
+		if err != nil {
+			return "", err
+		}
+		fields[name] = string(data)
+	}
+
+	bid := "b-" + util.RandomJid()
+	_, err := b.rclient.Pipelined(func(pipe redis.Pipeliner) error {
+		for field, val := range fields {
+			pipe.HSet(batchKey(bid), field, val)
+		}
+		pipe.Expire(batchKey(bid), b.ttl)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return bid, nil
+}
+
+// push adds a new job to its batch, retries and scheduled jobs
+// being enqueued are already in it
+func (b *batches) push(next func() error, ctx manager.Context) error {
+	job := ctx.Job()
+	bid := batchID(job)
+	if bid == "" {
+		return next()
+	}
+	known, err := b.rclient.SIsMember(batchJobsKey(bid), job.Jid).Result()
+	if err != nil {
+		return err
+	}
+	if known {
+		return next()
+	}
+
+	committed, err := b.rclient.HGet(batchKey(bid), "committed").Result()
+	if err == redis.Nil {
+		return errNoBatch(bid)
+	}
+	if err != nil {
+		return err
+	}
+	if committed == "1" {
+		return fmt.Errorf("Batch %s is committed, BATCH OPEN it to add jobs", bid)
+	}
+
+	// added before it's enqueued so a worker can't finish it first
+	_, err = b.rclient.Pipelined(func(pipe redis.Pipeliner) error {
+		for _, key := range []string{batchJobsKey(bid), batchPendingKey(bid)} {
+			pipe.SAdd(key, job.Jid)
+			pipe.Expire(key, b.ttl)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = next()
+	if err != nil {
+		b.rclient.Pipelined(func(pipe redis.Pipeliner) error {
+			pipe.SRem(batchJobsKey(bid), job.Jid)
+			pipe.SRem(batchPendingKey(bid), job.Jid)
+			return nil
+		})
+	}
+	return err
+}
+
+func (b *batches) ack(next func() error, ctx manager.Context) error {
+	err := next()
+	job := ctx.Job()
+	if bid := batchID(job); bid != "" && err == nil {
+		_, err := b.rclient.Pipelined(func(pipe redis.Pipeliner) error {
+			pipe.SRem(batchPendingKey(bid), job.Jid)
+			pipe.SRem(batchFailedKey(bid), job.Jid)
+			return nil
+		})
+		if err == nil {
+			err = b.check(bid)
+		}
+		if err != nil {
+			util.ForJob(job.Jid, job.Queue).Warnf("Unable to update batch %s: %v", bid, err)
+		}
+	}
+	return err
+}
+
+func (b *batches) fail(next func() error, ctx manager.Context) error {
+	err := next()
+	job := ctx.Job()
+	if bid := batchID(job); bid != "" && err == nil {
+		pending, err := b.rclient.SIsMember(batchPendingKey(bid), job.Jid).Result()
+		if err == nil && pending {
+			_, err = b.rclient.Pipelined(func(pipe redis.Pipeliner) error {
+				pipe.SAdd(batchFailedKey(bid), job.Jid)
+				pipe.Expire(batchFailedKey(bid), b.ttl)
+				return nil
+			})
+		}
+		if err == nil {
+			err = b.check(bid)
+		}
+		if err != nil {
+			util.ForJob(job.Jid, job.Queue).Warnf("Unable to update batch %s: %v", bid, err)
+		}
+	}
+	return err
+}
+
+// check pushes the callbacks once a committed batch's jobs have all
+// run, complete first
+func (b *batches) check(bid string) error {
+	status, err := b.status(bid)
+	if err != nil || !status.Committed {
+		return err
+	}
+	if status.Pending == status.Failed {
+		err = b.fire(bid, batchComplete)
+		if err != nil {
+			return err
+		}
+	}
+	if status.Pending == 0 {
+		return b.fire(bid, batchSuccess)
+	}
+	return nil
+}
+
+// fire pushes the callback, at most once.  The callback is marked
+// fired first so concurrent checks can't both push it, and unmarked
+// if the push fails so the next check tries again.
+func (b *batches) fire(bid string, callback string) error {
+	first, err := b.rclient.HSetNX(batchKey(bid), callback+"_at", util.Nows()).Result()
+	if err != nil || !first {
+		return err
+	}
+	err = b.pushCallback(bid, callback)
+	if err != nil {
+		if derr := b.rclient.HDel(batchKey(bid), callback+"_at").Err(); derr != nil {
+			util.Warnf("Unable to unmark batch %s %s callback: %v", bid, callback, derr)
+		}
+	}
+	return err
+}
+
+func (b *batches) pushCallback(bid string, callback string) error {
+	data, err := b.rclient.HGet(batchKey(bid), callback).Bytes()
+	if err == redis.Nil {
+		// no callback was given
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var job client.Job
+	err = json.Unmarshal(data, &job)
+	if err != nil {
+		return err
+	}
+	job.SetCustom(client.BatchCallbackAttribute, bid)
+	util.Debugf("Batch %s pushing %s callback %s", bid, callback, job.Jid)
+	return b.mgr.Push(&job)
+}
+
+func (b *batches) commit(bid string) error {
+	exists, err := b.rclient.Exists(batchKey(bid)).Result()
+	if err != nil {
+		return err
+	}
+	if exists == 0 {
+		return errNoBatch(bid)
+	}
+	err = b.rclient.HSet(batchKey(bid), "committed", "1").Err()
+	if err != nil {
+		return err
+	}
+	return b.check(bid)
+}
+
+func (b *batches) open(bid string) error {
+	status, err := b.status(bid)
+	if err != nil {
+		return err
+	}
+	if status.SucceededAt != "" {
+		return fmt.Errorf("Batch %s has succeeded and can't be reopened", bid)
+	}
+	return b.rclient.HSet(batchKey(bid), "committed", "0").Err()
+}
+
+func (b *batches) status(bid string) (*client.BatchStatus, error) {
+	var fields *redis.StringStringMapCmd
+	var total, pending, failed *redis.IntCmd
+	_, err := b.rclient.Pipelined(func(pipe redis.Pipeliner) error {
+		fields = pipe.HGetAll(batchKey(bid))
+		total = pipe.SCard(batchJobsKey(bid))
+		pending = pipe.SCard(batchPendingKey(bid))
+		failed = pipe.SCard(batchFailedKey(bid))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	vals := fields.Val()
+	if len(vals) == 0 {
+		return nil, errNoBatch(bid)
+	}
+	return &client.BatchStatus{
+		Bid:         bid,
+		Description: vals["description"],
+		CreatedAt:   vals["created_at"],
+		Committed:   vals["committed"] == "1",
+		Total:       total.Val(),
+		Pending:     pending.Val(),
+		Failed:      failed.Val(),
+		CompletedAt: vals[batchComplete+"_at"],
+		SucceededAt: vals[batchSuccess+"_at"],
+	}, nil
+}
+
+func (s *Server) batches() *batches {
+	for _, x := range s.Subsystems {
+		if b, ok := x.(*batches); ok {
+			return b
+		}
+	}
+	return nil
+}
+
+func batch(c *Connection, s *Server, cmd string) {
+	b := s.batches()
+	if b == nil {
+		c.Error(cmd, fmt.Errorf("Batches are not enabled"))
+		return
+	}
+	parts := strings.SplitN(cmd, " ", 3)
+	if len(parts) != 3 {
+		c.Error(cmd, fmt.Errorf("Invalid BATCH %s", cmd))
+		return
+	}
+
+	switch parts[1] {
+	case "NEW":
+		var def client.Batch
+		err := json.Unmarshal([]byte(parts[2]), &def)
+		if err != nil {
+			c.Error(cmd, newTaggedError("MALFORMED", err))
+			return
+		}
+		bid, err := b.create(&def)
+		if err != nil {
+			c.Error(cmd, err)
+			return
+		}
+		c.Result([]byte(bid))
+	case "OPEN", "COMMIT":
+		var err error
+		if parts[1] == "OPEN" {
+			err = b.open(parts[2])
+		} else {
+			err = b.commit(parts[2])
+		}
+		if err != nil {
+			c.Error(cmd, err)
+			return
+		}
+		c.Ok()
+	case "STATUS":
+		status, err := b.status(parts[2])
+		if err != nil {
+			c.Error(cmd, err)
+			return
+		}
+		res, err := json.Marshal(status)
+		if err != nil {
+			c.Error(cmd, err)
+			return
+		}
+		c.Result(res)
+	default:
+		c.Error(cmd, fmt.Errorf("Invalid BATCH %s", cmd))
+	}
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/manager"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatches(t *testing.T) {
+	s, _, stop := newTestServer(t, nil)
+	defer stop()
+
+	assert.Nil(t, s.batches())
+	s.Register(BatchSubsystem())
+	b := s.batches()
+	assert.NoError(t, b.Start(s))
+
+	_, err := b.create(&client.Batch{Success: &client.Job{}})
+	assert.EqualError(t, err, "The success callback must have a jobtype")
+
+	bid, err := b.create(&client.Batch{
+		Description: "Import",
+		Success:     &client.Job{Type: "ImportDone", Queue: "default"},
+		Complete:    &client.Job{Type: "ImportRan", Queue: "default"},
+	})
+	assert.NoError(t, err)
+
+	orphan := client.NewJob("ImportRow", 0)
+	orphan.SetCustom(client.BatchAttribute, "b-missing")
+	assert.EqualError(t, s.manager.Push(orphan), "NOTFOUND No such batch b-missing")
+
+	jobs := []*client.Job{}
+	for idx := 0; idx < 2; idx++ {
+		job := client.NewJob("ImportRow", idx)
+		job.SetCustom(client.BatchAttribute, bid)
+		assert.NoError(t, s.manager.Push(job))
+		jobs = append(jobs, job)
+	}
+	assert.NoError(t, b.commit(bid))
+
+	late := client.NewJob("ImportRow", 2)
+	late.SetCustom(client.BatchAttribute, bid)
+	assert.Error(t, s.manager.Push(late))
+
+	status, err := b.status(bid)
+	assert.NoError(t, err)
+	assert.Equal(t, "Import", status.Description)
+	assert.True(t, status.Committed)
+	assert.EqualValues(t, 2, status.Total)
+	assert.EqualValues(t, 2, status.Pending)
+
+	fetch := func(jobtype string) *client.Job {
+		job, err := s.manager.Fetch(context.Background(), "", "default")
+		assert.NoError(t, err)
+		if assert.NotNil(t, job) {
+			assert.Equal(t, jobtype, job.Type)
+		}
+		return job
+	}
+
+	// the first job fails and will be retried, the batch has
+	// then run so complete fires but not success
+	job := fetch("ImportRow")
+	assert.NoError(t, s.manager.Fail(&manager.FailPayload{Jid: job.Jid, ErrorMessage: "oops", ErrorType: "RuntimeError"}))
+	job = fetch("ImportRow")
+	_, err = s.manager.Acknowledge(job.Jid)
+	assert.NoError(t, err)
+
+	status, err = b.status(bid)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, status.Pending)
+	assert.EqualValues(t, 1, status.Failed)
+	assert.NotEmpty(t, status.CompletedAt)
+	assert.Empty(t, status.SucceededAt)
+
+	callback := fetch("ImportRan")
+	val, _ := callback.GetCustom(client.BatchCallbackAttribute)
+	assert.Equal(t, bid, val)
+	_, err = s.manager.Acknowledge(callback.Jid)
+	assert.NoError(t, err)
+
+	// the retry is already in the batch, even though it's committed
+	assert.NoError(t, s.manager.Push(jobs[0]))
+	job = fetch("ImportRow")
+	assert.Equal(t, jobs[0].Jid, job.Jid)
+	_, err = s.manager.Acknowledge(job.Jid)
+	assert.NoError(t, err)
+
+	status, err = b.status(bid)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, status.Pending)
+	assert.EqualValues(t, 0, status.Failed)
+	assert.NotEmpty(t, status.SucceededAt)
+	fetch("ImportDone")
+	job, err = s.manager.Fetch(context.Background(), "", "default")
+	assert.NoError(t, err)
+	assert.Nil(t, job)
+
+	assert.EqualError(t, b.open(bid), fmt.Sprintf("Batch %s has succeeded and can't be reopened", bid))
+	assert.EqualError(t, b.commit("b-missing"), "NOTFOUND No such batch b-missing")
+
+	// a callback which couldn't be pushed is pushed by the next check
+	refuse := true
+	s.manager.AddMiddleware("push", func(next func() error, ctx manager.Context) error {
+		if refuse && ctx.Job().Type == "ExportDone" {
+			return fmt.Errorf("refused")
+		}
+		return next()
+	})
+	bid, err = b.create(&client.Batch{Success: &client.Job{Type: "ExportDone", Queue: "default"}})
+	assert.NoError(t, err)
+	assert.EqualError(t, b.commit(bid), "refused")
+	status, err = b.status(bid)
+	assert.NoError(t, err)
+	assert.Empty(t, status.SucceededAt)
+	refuse = false
+	assert.NoError(t, b.check(bid))
+	status, err = b.status(bid)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, status.SucceededAt)
+	fetch("ExportDone")
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/contribsys/faktory/storage"
+	"github.com/contribsys/faktory/util"
+)
+
+// BootSummary describes the state recovered from storage when the
+// server booted so operators can verify a restart was clean.  It's
+// logged at boot and included in INFO.
+type BootSummary struct {
+	Queues    map[string]uint64 `json:"queues"`
+	Scheduled uint64            `json:"scheduled"`
+	Retries   uint64            `json:"retries"`
+	Dead      uint64            `json:"dead"`
+	// reservations restored from the working set
+	Working int `json:"working"`
+	// reservations which expired while the server was down,
+	// their jobs have been sent to the retry set
+	Reclaimed int `json:"reclaimed"`
+}
+
+func (s *Server) summarizeBoot() (*BootSummary, error) {
+	// don't wait for the reaper, the summary
+	// should reflect the reclaimed jobs
+	reclaimed, err := s.manager.ReapExpiredJobs(util.Nows())
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &BootSummary{
+		Queues:    map[string]uint64{},
+		Scheduled: s.store.Scheduled().Size(),
+		Retries:   s.store.Retries().Size(),
+		Dead:      s.store.Dead().Size(),
+		Working:   s.manager.WorkingCount(),
+		Reclaimed: reclaimed,
+	}
+	s.store.EachQueue(func(q storage.Queue) {
+		summary.Queues[q.Name()] = q.Size()
+	})
+	return summary, nil
+}
+
+func (bs *BootSummary) log() {
+	total := uint64(0)
+	names := make([]string, 0, len(bs.Queues))
+	for name, size := range bs.Queues {
+		total += size
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	util.Infof("Recovered %d jobs in %d queues, %d scheduled, %d retries, %d dead, %d in progress",
+		total, len(bs.Queues), bs.Scheduled, bs.Retries, bs.Dead, bs.Working)
+	if len(names) > 0 {
+		sizes := make([]string, len(names))
+		for idx, name := range names {
+			sizes[idx] = fmt.Sprintf("%s=%d", name, bs.Queues[name])
+		}
+		util.Infof("Queues: %s", strings.Join(sizes, ", "))
+	}
+	if bs.Reclaimed > 0 {
+		util.Warnf("Reclaimed %d reservations which expired while the server was down", bs.Reclaimed)
+	}
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/contribsys/faktory/util"
+	"github.com/go-redis/redis"
+)
+
+/*
+ * The changes feed records administrative and lifecycle events, e.g.
+ * a queue cleared from the Web UI or maintenance mode turned on, in
+ * the order they happened so external tools can mirror Faktory's
+ * state.  Each change has a sequence number and readers page through
+ * the feed with the last sequence they saw as the cursor, see the
+ * API's changes field.  The feed keeps the latest changes:
+ *
+ * [changes]
+ * size = 10000
+ *
+ * A reader whose cursor has fallen off the end of the feed, or is
+ * ahead of it after a FLUSH, is told the feed was truncated and
+ * should resync from scratch.
+ */
+const (
+	changesKey    = "server:changes"
+	changesSeqKey = "server:changes:seq"
+)
+
+// Kinds of change
+const (
+	ChangeStarted         = "server.started"
+	ChangeStopped         = "server.stopped"
+	ChangeMaintenance     = "server.maintenance"
+	ChangeFlushed         = "server.flushed"
+	ChangeMarker          = "marker.added"
+	ChangeTemplateSaved   = "template.saved"
+	ChangeTemplateDeleted = "template.deleted"
+	ChangeQueueCleared    = "queue.cleared"
+	ChangeQueuePruned     = "queue.pruned"
+	ChangeJobsDeleted     = "jobs.deleted"
+	ChangeJobsRetried     = "jobs.retried"
+	ChangeJobsKilled      = "jobs.killed"
+)
+
+type Change struct {
+	Seq     int64                  `json:"seq"`
+	At      time.Time              `json:"at"`
+	Kind    string                 `json:"kind"`
+	Subject string                 `json:"subject,omitempty"`
+	Detail  map[string]interface{} `json:"detail,omitempty"`
+}
+
+// ChangeFeed is a page of the changes feed.  Next is the cursor for
+// the following page, the given cursor if there were no changes.
+type ChangeFeed struct {
+	Changes   []*Change
+	Next      int64
+	Truncated bool
+}
+
+// RecordChange appends a change to the feed.  Recording is best
+// effort, failures are logged.
+func (s *Server) RecordChange(kind string, subject string, detail map[string]interface{}) {
+	if s.store == nil {
+		// before Boot
+		return
+	}
+	err := s.recordChange(&Change{At: time.Now(), Kind: kind, Subject: subject, Detail: detail})
+	if err != nil {
+		util.Warnf("Unable to record %s change: %v", kind, err)
+	}
+}
+
+func (s *Server) recordChange(c *Change) error {
+	rclient := s.store.Redis()
+	seq, err := rclient.Incr(changesSeqKey).Result()
+	if err != nil {
+		return err
+	}
+	c.Seq = seq
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	size := int64(s.Options.Int("changes", "size", 10000))
+	_, err = rclient.Pipelined(func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(changesKey, redis.Z{Score: float64(seq), Member: data})
+		pipe.ZRemRangeByScore(changesKey, "-inf", strconv.FormatInt(seq-size, 10))
+		return nil
+	})
+	return err
+}
+
+// Changes returns up to count changes after the cursor, oldest
+// first.  A cursor of 0 starts at the oldest change kept.
+func (s *Server) Changes(after int64, count int) (*ChangeFeed, error) {
+	var last *redis.StringCmd
+	var vals *redis.StringSliceCmd
+	_, err := s.store.Redis().Pipelined(func(pipe redis.Pipeliner) error {
+		last = pipe.Get(changesSeqKey)
+		vals = pipe.ZRangeByScore(changesKey, redis.ZRangeBy{
+			Min:   "(" + strconv.FormatInt(after, 10),
+			Max:   "+inf",
+			Count: int64(count),
+		})
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	seq, _ := last.Int64()
+
+	feed := &ChangeFeed{Changes: []*Change{}, Next: after}
+	for _, val := range vals.Val() {
+		var c Change
+		err := json.Unmarshal([]byte(val), &c)
+		if err != nil {
+			return nil, err
+		}
+		feed.Changes = append(feed.Changes, &c)
+		feed.Next = c.Seq
+	}
+	if after > seq {
+		// the feed was flushed since the reader's last page
+		feed.Truncated = true
+	} else if after > 0 && len(feed.Changes) > 0 && feed.Changes[0].Seq > after+1 {
+		// changes after the cursor were trimmed
+		feed.Truncated = true
+	}
+	return feed, nil
+}
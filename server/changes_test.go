@@ -0,0 +1,73 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChanges(t *testing.T) {
+	s, _, stop := newTestServer(t, &ServerOptions{GlobalConfig: map[string]interface{}{
+		"changes": map[string]interface{}{"size": 3},
+	}})
+	defer stop()
+
+	// not booted, nothing is recorded
+	store := s.store
+	s.store = nil
+	s.RecordChange(ChangeFlushed, "", nil)
+
+	s.store = store
+	feed, err := s.Changes(0, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, feed.Changes)
+	assert.EqualValues(t, 0, feed.Next)
+	assert.False(t, feed.Truncated)
+
+	s.SetMaintenance(true)
+	assert.NoError(t, s.SaveTemplate(&JobTemplate{Name: "reindex", Type: "Reindex"}))
+	assert.NoError(t, s.DeleteTemplate("reindex"))
+
+	feed, err = s.Changes(0, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(feed.Changes))
+	assert.EqualValues(t, 1, feed.Changes[0].Seq)
+	assert.Equal(t, ChangeMaintenance, feed.Changes[0].Kind)
+	assert.Equal(t, true, feed.Changes[0].Detail["on"])
+	assert.Equal(t, ChangeTemplateSaved, feed.Changes[1].Kind)
+	assert.Equal(t, "reindex", feed.Changes[1].Subject)
+	assert.EqualValues(t, 2, feed.Next)
+
+	feed, err = s.Changes(feed.Next, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(feed.Changes))
+	assert.Equal(t, ChangeTemplateDeleted, feed.Changes[0].Kind)
+	assert.False(t, feed.Truncated)
+
+	// nothing new, the cursor stays put
+	feed, err = s.Changes(feed.Next, 2)
+	assert.NoError(t, err)
+	assert.Empty(t, feed.Changes)
+	assert.EqualValues(t, 3, feed.Next)
+
+	// only the last 3 changes are kept, a reader at 1 missed 2
+	s.SetMaintenance(false)
+	s.SetMaintenance(true)
+	feed, err = s.Changes(1, 10)
+	assert.NoError(t, err)
+	assert.True(t, feed.Truncated)
+	assert.Equal(t, 3, len(feed.Changes))
+	assert.EqualValues(t, 3, feed.Changes[0].Seq)
+	assert.EqualValues(t, 5, feed.Next)
+	feed, err = s.Changes(2, 10)
+	assert.NoError(t, err)
+	assert.False(t, feed.Truncated)
+
+	// after a flush the reader is ahead of the feed
+	s.store.Flush()
+	s.RecordChange(ChangeFlushed, "", nil)
+	feed, err = s.Changes(5, 10)
+	assert.NoError(t, err)
+	assert.True(t, feed.Truncated)
+	assert.Empty(t, feed.Changes)
+}
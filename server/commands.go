@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
@@ -18,14 +19,34 @@ import (
 type command func(c *Connection, s *Server, cmd string)
 
 var cmdSet = map[string]command{
-	"END":   end,
-	"PUSH":  push,
-	"FETCH": fetch,
-	"ACK":   ack,
-	"FAIL":  fail,
-	"BEAT":  heartbeat,
-	"INFO":  info,
-	"FLUSH": flush,
+	"END":         end,
+	"PUSH":        push,
+	"PUSHB":       pushBulk,
+	"FETCH":       fetch,
+	"ACK":         ack,
+	"FAIL":        fail,
+	"BEAT":        heartbeat,
+	"INFO":        info,
+	"FLUSH":       flush,
+	"JOBS":        jobs,
+	"TRACK":       track,
+	"RESULT":      result,
+	"MARK":        mark,
+	"BACKUP":      backup,
+	"TEMPLATE":    templates,
+	"MAINTENANCE": maintenance,
+	"BATCH":       batch,
+	"MUTATE":      mutate,
+}
+
+// When an admin binding is configured, these commands are
+// only accepted on connections to it.
+var adminCommands = map[string]bool{
+	"FLUSH":       true,
+	"BACKUP":      true,
+	"TEMPLATE":    true,
+	"MAINTENANCE": true,
+	"MUTATE":      true,
 }
 
 func flush(c *Connection, s *Server, cmd string) {
@@ -39,81 +60,329 @@ func flush(c *Connection, s *Server, cmd string) {
 		c.Error(cmd, err)
 		return
 	}
+	s.RecordChange(ChangeFlushed, "", nil)
 
 	c.Ok()
 }
 
+func backup(c *Connection, s *Server, cmd string) {
+	path, err := s.Backup()
+	if err != nil {
+		c.Error(cmd, err)
+		return
+	}
+
+	c.Result([]byte(path))
+}
+
 func end(c *Connection, s *Server, cmd string) {
 	c.Close()
 }
 
+// StorageTimeout is how long PUSH waits for Redis to
+// come back during a restart before giving up.
+var StorageTimeout = 5 * time.Second
+
+// PUSH rejects jobs larger than [faktory] max_job_size bytes
+// with TOOBIG, 0 means unlimited.  The limit applies to the job
+// as sent, before its args are offloaded.
 func push(c *Connection, s *Server, cmd string) {
 	data := cmd[5:]
+	// refused before it's parsed too
+	err := s.checkSize(len(data))
+	if err != nil {
+		c.Error(cmd, err)
+		return
+	}
 
-	var job client.Job
-	err := json.Unmarshal([]byte(data), &job)
+	job := acquireJob()
+	defer releaseJob(job)
+	err = json.Unmarshal([]byte(data), job)
 	if err != nil {
 		c.Error(cmd, newTaggedError("MALFORMED", err))
 		return
 	}
 
-	err = s.manager.Push(&job)
+	err = s.Push(job, len(data))
 	if err != nil {
 		c.Error(cmd, err)
 		return
 	}
-
 	c.Ok()
 }
 
+// Push pushes a job as PUSH does, for jobs created within the server,
+// e.g. by webhooks: it's refused if its size, encoded as JSON, is over
+// the limit or its queue is shedding load and the subsystems which
+// take over pushes, e.g. debounce, see it first.
+func (s *Server) Push(job *client.Job, size int) error {
+	err := s.waitForStorage()
+	if err != nil {
+		return err
+	}
+	handled, err := s.admit(job, size)
+	if err != nil || handled {
+		return err
+	}
+	return s.manager.Push(job)
+}
+
+// admit checks a job before it's pushed, returning true if it
+// was collapsed into another rather than needing a push
+func (s *Server) admit(job *client.Job, size int) (bool, error) {
+	err := s.checkSize(size)
+	if err != nil {
+		return false, err
+	}
+	if sh := s.shedder(); sh != nil {
+		err = sh.admit(job)
+		if err != nil {
+			return false, err
+		}
+	}
+	return s.intercept(job)
+}
+
+func (s *Server) checkSize(size int) error {
+	if max := s.Options.Int("faktory", "max_job_size", 0); max > 0 && size > max {
+		return newTaggedError("TOOBIG", fmt.Errorf("Job is %d bytes, the limit is %d", size, max))
+	}
+	return nil
+}
+
+// waitForStorage holds a push while Redis is restarting
+func (s *Server) waitForStorage() error {
+	if s.store.Available() {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), StorageTimeout)
+	defer cancel()
+	err := s.store.WaitAvailable(ctx)
+	if err != nil {
+		return newTaggedError("UNAVAILABLE", err)
+	}
+	return nil
+}
+
+// intercept gives the subsystems which take over jobs being
+// pushed a chance to, returning true if the job was collapsed
+// rather than needing a push
+func (s *Server) intercept(job *client.Job) (bool, error) {
+	if d := s.debouncer(); d != nil {
+		return d.debounce(job)
+	}
+	return false, nil
+}
+
+// pushBulk pushes a JSON array of jobs, responding with the errors
+// of the jobs which weren't pushed by JID, {} if they all were.
+func pushBulk(c *Connection, s *Server, cmd string) {
+	if len(cmd) <= 6 {
+		c.Error(cmd, newTaggedError("MALFORMED", fmt.Errorf("PUSHB requires a JSON array of jobs")))
+		return
+	}
+	data := cmd[6:]
+
+	var payloads []json.RawMessage
+	err := json.Unmarshal([]byte(data), &payloads)
+	if err != nil {
+		c.Error(cmd, newTaggedError("MALFORMED", err))
+		return
+	}
+	if len(payloads) > MaxBulkPush {
+		c.Error(cmd, fmt.Errorf("At most %d jobs may be pushed at once", MaxBulkPush))
+		return
+	}
+	all := make([]client.Job, len(payloads))
+	for idx, payload := range payloads {
+		err := json.Unmarshal(payload, &all[idx])
+		if err != nil {
+			c.Error(cmd, newTaggedError("MALFORMED", err))
+			return
+		}
+	}
+	err = s.waitForStorage()
+	if err != nil {
+		c.Error(cmd, err)
+		return
+	}
+
+	failed := map[string]string{}
+	jobs := make([]*client.Job, 0, len(all))
+	for idx := range all {
+		job := &all[idx]
+		handled, err := s.admit(job, len(payloads[idx]))
+		if err != nil {
+			failed[job.Jid] = err.Error()
+			continue
+		}
+		if !handled {
+			jobs = append(jobs, job)
+		}
+	}
+	unpushed := s.manager.PushBulk(jobs)
+	s.releaseUnpushed(jobs, unpushed)
+	for jid, err := range unpushed {
+		failed[jid] = err.Error()
+	}
+
+	res, err := json.Marshal(failed)
+	if err != nil {
+		c.Error(cmd, err)
+		return
+	}
+	c.Result(res)
+}
+
 func fetch(c *Connection, s *Server, cmd string) {
-	if c.client.state != Running {
-		// quiet or terminated workers should not get new jobs
+	if s.InMaintenance() {
+		time.Sleep(MaintenanceDelay)
+		c.Error(cmd, errMaintenance)
+		return
+	}
+	if c.client.state != Running || s.isDraining() || s.isPaused() {
+		// quiet or terminated workers should not get new jobs,
+		// nor should any worker while the server shuts down or
+		// fetching is paused
 		time.Sleep(2 * time.Second)
 		c.Result(nil)
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-	defer cancel()
-
-	qs := strings.Split(cmd, " ")[1:]
-	job, err := s.manager.Fetch(ctx, c.client.Wid, qs...)
+	req, err := parseFetch(cmd)
 	if err != nil {
 		c.Error(cmd, err)
 		return
 	}
+
+	// a worker which expects to be stopped shouldn't hold jobs
+	prefetch := req.prefetch > 0 && req.lifetime == 0 && c.client.Wid != ""
+
+	var job *client.Job
+	if prefetch {
+		job = s.prefetched.take(c.client.Wid)
+	}
+	if job == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), s.fetchTimeout(req.timeout))
+		defer cancel()
+
+		// while Redis is restarting, hold the fetch like an empty queue
+		if s.store.WaitAvailable(ctx) != nil {
+			c.Result(nil)
+			return
+		}
+
+		job, err = s.manager.FetchWithin(ctx, c.client.Wid, req.lifetime, req.queues...)
+		if err != nil {
+			c.Error(cmd, err)
+			return
+		}
+	}
 	if job != nil {
-		res, err := json.Marshal(job)
+		jid, queue := job.Jid, job.Queue
+		job, err = s.decrypted(job)
+		if err != nil {
+			util.ForJob(jid, queue).Warnf("Unable to decrypt %s: %v", jid, err)
+			s.manager.Fail(&manager.FailPayload{Jid: jid, ErrorMessage: err.Error(), ErrorType: "DecryptionError"})
+			c.Error(cmd, err)
+			return
+		}
+		buf := acquireBuffer()
+		defer releaseBuffer(buf)
+		err = encodeJob(buf, job)
 		if err != nil {
 			c.Error(cmd, err)
 			return
 		}
-		c.Result(res)
+		c.Result(buf.Bytes())
+		if prefetch {
+			s.prefetch(c.client.Wid, req)
+		}
 	} else {
 		c.Result(nil)
 	}
 }
 
+// fetchRequest is FETCH's arguments, the queues followed by the
+// options, each "<name>=<seconds>" as '=' isn't valid in queue names
+type fetchRequest struct {
+	queues []string
+	// the worker's remaining lifetime, 0 if it wasn't given
+	lifetime time.Duration
+	// how long to wait for a job, 0 for the server's default
+	timeout time.Duration
+	// how many jobs to hold for the worker, see prefetch.go
+	prefetch int
+}
+
+func parseFetch(cmd string) (*fetchRequest, error) {
+	req := &fetchRequest{queues: []string{}}
+	for _, arg := range strings.Split(cmd, " ")[1:] {
+		idx := strings.IndexByte(arg, '=')
+		if idx == -1 {
+			req.queues = append(req.queues, arg)
+			continue
+		}
+		name := arg[:idx]
+		value, err := strconv.Atoi(arg[idx+1:])
+		if err != nil || value < 1 {
+			return nil, newTaggedError("MALFORMED", fmt.Errorf("Invalid %s %s", name, arg[idx+1:]))
+		}
+		switch name {
+		case "lifetime":
+			req.lifetime = time.Duration(value) * time.Second
+		case "timeout":
+			req.timeout = time.Duration(value) * time.Second
+		case "prefetch":
+			req.prefetch = value
+		default:
+			return nil, newTaggedError("MALFORMED", fmt.Errorf("Unknown FETCH option %s", name))
+		}
+	}
+	return req, nil
+}
+
 func ack(c *Connection, s *Server, cmd string) {
 	data := cmd[4:]
 
-	var hash map[string]string
-	err := json.Unmarshal([]byte(data), &hash)
+	var payload struct {
+		Jid         string            `json:"jid"`
+		Annotations map[string]string `json:"annotations"`
+		Result      json.RawMessage   `json:"result"`
+	}
+	err := json.Unmarshal([]byte(data), &payload)
 	if err != nil {
 		c.Error(cmd, fmt.Errorf("Invalid ACK %s", data))
 		return
 	}
-	jid, ok := hash["jid"]
-	if !ok {
+	jid := payload.Jid
+	if jid == "" {
 		c.Error(cmd, fmt.Errorf("Invalid ACK %s", data))
 		return
 	}
+	r := s.results()
+	if r != nil && len(payload.Result) > 0 {
+		err = r.check(payload.Result)
+		if err != nil {
+			c.Error(cmd, err)
+			return
+		}
+	}
+	if len(payload.Annotations) > 0 {
+		// the reservation may have expired, that's ok
+		s.manager.Annotate(jid, payload.Annotations)
+	}
 	_, err = s.manager.Acknowledge(jid)
 	if err != nil {
 		c.Error(cmd, err)
 		return
 	}
+	if r != nil && len(payload.Result) > 0 {
+		err = r.save(jid, payload.Result)
+		if err != nil {
+			util.Warnf("Unable to save the result of %s: %v", jid, err)
+		}
+	}
 
 	c.Ok()
 }
@@ -121,14 +390,15 @@ func ack(c *Connection, s *Server, cmd string) {
 func fail(c *Connection, s *Server, cmd string) {
 	data := cmd[5:]
 
-	var failure manager.FailPayload
-	err := json.Unmarshal([]byte(data), &failure)
+	failure := acquireFailure()
+	defer releaseFailure(failure)
+	err := json.Unmarshal([]byte(data), failure)
 	if err != nil {
 		c.Error(cmd, fmt.Errorf("Invalid FAIL %s", data))
 		return
 	}
 
-	err = s.manager.Fail(&failure)
+	err = s.manager.Fail(failure)
 	if err != nil {
 		c.Error(cmd, err)
 		return
@@ -151,6 +421,58 @@ func info(c *Connection, s *Server, cmd string) {
 	c.Result(bytes)
 }
 
+const (
+	MaxEnumerateCount = 1000
+	MaxBulkPush       = client.MaxBulkPush
+)
+
+func jobs(c *Connection, s *Server, cmd string) {
+	data := cmd[4:]
+
+	payload := struct {
+		Set    string `json:"set"`
+		Cursor string `json:"cursor"`
+		Count  int    `json:"count"`
+	}{Count: 25}
+	err := json.Unmarshal([]byte(data), &payload)
+	if err != nil {
+		c.Error(cmd, fmt.Errorf("Invalid JOBS %s", data))
+		return
+	}
+	if payload.Count < 1 || payload.Count > MaxEnumerateCount {
+		c.Error(cmd, fmt.Errorf("Count must be between 1 and %d", MaxEnumerateCount))
+		return
+	}
+
+	var enumerate func(string, int) ([]*client.Job, string, error)
+	switch payload.Set {
+	case "scheduled":
+		enumerate = s.manager.EnumerateScheduled
+	case "retries":
+		enumerate = s.manager.EnumerateRetries
+	case "dead":
+		enumerate = s.manager.EnumerateDead
+	default:
+		c.Error(cmd, fmt.Errorf("Unknown set %s", payload.Set))
+		return
+	}
+
+	jobs, next, err := enumerate(payload.Cursor, payload.Count)
+	if err != nil {
+		c.Error(cmd, err)
+		return
+	}
+	res, err := json.Marshal(map[string]interface{}{
+		"jobs":   jobs,
+		"cursor": next,
+	})
+	if err != nil {
+		c.Error(cmd, err)
+		return
+	}
+	c.Result(res)
+}
+
 func heartbeat(c *Connection, s *Server, cmd string) {
 	data := cmd[5:]
 
@@ -163,9 +485,10 @@ func heartbeat(c *Connection, s *Server, cmd string) {
 
 	worker, ok := s.workers.heartbeat(&client, nil)
 	if !ok {
-		c.Error(cmd, fmt.Errorf("Unknown worker %s", client.Wid))
+		c.Error(cmd, newTaggedError("NOTFOUND", fmt.Errorf("Unknown worker %s", client.Wid)))
 		return
 	}
+	s.releasePrefetched(worker.Wid)
 
 	if worker.state == Running {
 		c.Ok()
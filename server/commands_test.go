@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPushBulk(t *testing.T) {
+	opts := &ServerOptions{GlobalConfig: map[string]interface{}{
+		"faktory": map[string]interface{}{"max_job_size": 200},
+	}}
+	s, run, stop := newTestServer(t, opts)
+	defer stop()
+
+	pushb := func(payload string) string {
+		return run("PUSHB " + payload)
+	}
+
+	assert.Contains(t, pushb(`{"jid":"12345678"}`), "-MALFORMED")
+	assert.Contains(t, run("PUSHB"), "-MALFORMED")
+	assert.Contains(t, pushb(`[{"jid":"12345678","args":"x"}]`), "-MALFORMED")
+
+	jobs := []*client.Job{client.NewJob("Bulk", 1), client.NewJob("", 2), client.NewJob("Bulk", strings.Repeat("x", 200))}
+	data, err := json.Marshal(jobs)
+	assert.NoError(t, err)
+	res := pushb(string(data))
+	lines := strings.Split(res, "\r\n")
+	assert.Equal(t, 2, len(lines), res)
+	var failed map[string]string
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &failed))
+	assert.Equal(t, map[string]string{
+		jobs[1].Jid: "All jobs must have a jobtype parameter",
+		jobs[2].Jid: failed[jobs[2].Jid],
+	}, failed)
+	assert.Contains(t, failed[jobs[2].Jid], "TOOBIG")
+
+	q, err := s.store.GetQueue("default")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, q.Size())
+
+	data, err = json.Marshal([]*client.Job{client.NewJob("Bulk", 3)})
+	assert.NoError(t, err)
+	assert.Equal(t, "$2\r\n{}", pushb(string(data)))
+	assert.EqualValues(t, 2, q.Size())
+}
+
+func TestParseFetch(t *testing.T) {
+	req, err := parseFetch("FETCH critical default")
+	assert.NoError(t, err)
+	assert.Equal(t, &fetchRequest{queues: []string{"critical", "default"}}, req)
+
+	req, err = parseFetch("FETCH critical default lifetime=90 timeout=30")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"critical", "default"}, req.queues)
+	assert.Equal(t, 90*time.Second, req.lifetime)
+	assert.Equal(t, 30*time.Second, req.timeout)
+
+	req, err = parseFetch("FETCH default prefetch=4")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, req.prefetch)
+
+	for _, cmd := range []string{"FETCH default lifetime=", "FETCH default lifetime=0", "FETCH lifetime=soon default", "FETCH default timeout=-1", "FETCH default prefetch=0", "FETCH default limit=5"} {
+		_, err = parseFetch(cmd)
+		assert.Error(t, err, cmd)
+		assert.Contains(t, err.Error(), "MALFORMED")
+	}
+
+	s := &Server{Options: &ServerOptions{GlobalConfig: map[string]interface{}{}}}
+	assert.Equal(t, 2*time.Second, s.fetchTimeout(0))
+	assert.Equal(t, 10*time.Second, s.fetchTimeout(10*time.Second))
+	assert.Equal(t, 30*time.Second, s.fetchTimeout(time.Minute))
+}
@@ -1,15 +1,46 @@
 package server
 
-import "github.com/contribsys/faktory/util"
+import (
+	"strings"
+
+	"github.com/contribsys/faktory/util"
+)
 
 type ServerOptions struct {
 	Binding          string
+	AdminBinding     string
 	StorageDirectory string
 	RedisSock        string
 	ConfigDirectory  string
 	Environment      string
 	Password         string
+	TLSCert          string
+	TLSKey           string
 	GlobalConfig     map[string]interface{}
+	// Simulated servers have no effect outside themselves, see simulate.go
+	Simulated bool
+	// Startup reports the stages of booting, see startup.go
+	Startup *Startup
+	// Maintenance servers refuse FETCH, see maintenance.go
+	Maintenance bool
+}
+
+// Bindings returns the addresses of the command port, Binding
+// may list several separated by commas, e.g. to listen on
+// localhost and a VPN interface without binding 0.0.0.0.
+// "unix:/path" listens on a Unix socket.
+func (so *ServerOptions) Bindings() []string {
+	bindings := []string{}
+	for _, binding := range strings.Split(so.Binding, ",") {
+		binding = strings.TrimSpace(binding)
+		if binding != "" {
+			bindings = append(bindings, binding)
+		}
+	}
+	if len(bindings) == 0 {
+		return []string{"localhost:7419"}
+	}
+	return bindings
 }
 
 func (so *ServerOptions) String(subsys string, key string, defval string) string {
@@ -22,6 +53,46 @@ func (so *ServerOptions) String(subsys string, key string, defval string) string
 	return str
 }
 
+// TOML integers are decoded as int64
+func (so *ServerOptions) Int(subsys string, key string, defval int) int {
+	val := so.Config(subsys, key, defval)
+	switch v := val.(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	default:
+		util.Warnf("Config error: %s/%s is not an Integer", subsys, key)
+		return defval
+	}
+}
+
+// Float accepts integers too, "rate = 1" is a valid float
+func (so *ServerOptions) Float(subsys string, key string, defval float64) float64 {
+	val := so.Config(subsys, key, defval)
+	switch v := val.(type) {
+	case float64:
+		return v
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	default:
+		util.Warnf("Config error: %s/%s is not a Float", subsys, key)
+		return defval
+	}
+}
+
+func (so *ServerOptions) Bool(subsys string, key string, defval bool) bool {
+	val := so.Config(subsys, key, defval)
+	b, ok := val.(bool)
+	if !ok {
+		util.Warnf("Config error: %s/%s is not a Boolean", subsys, key)
+		return defval
+	}
+	return b
+}
+
 func (so *ServerOptions) Config(subsys string, key string, defval interface{}) interface{} {
 	mapp, ok := so.GlobalConfig[subsys]
 	if !ok {
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io"
 	"strconv"
+
+	"github.com/contribsys/faktory/manager"
 )
 
 // Represents a connection to a faktory client.
@@ -17,6 +19,14 @@ type Connection struct {
 	client *ClientData
 	conn   io.WriteCloser
 	buf    *bufio.Reader
+	// accepted on the admin binding
+	admin bool
+	// the client certificate's SPIFFE ID and the scopes it was
+	// granted, nil if unrestricted
+	identity string
+	scopes   map[string]bool
+	// closed when a command which missed its deadline finishes
+	running chan struct{}
 }
 
 func (c *Connection) Close() error {
@@ -24,6 +34,12 @@ func (c *Connection) Close() error {
 }
 
 func (c *Connection) Error(cmd string, err error) error {
+	if err == manager.ErrBusy {
+		err = newTaggedError("BUSY", err)
+	}
+	if _, ok := err.(*manager.JobNotFoundError); ok {
+		err = newTaggedError("NOTFOUND", err)
+	}
 	re, ok := err.(*taggedError)
 	if ok {
 		_, err = c.conn.Write([]byte(fmt.Sprintf("-%s\r\n", re.Error())))
@@ -49,16 +65,14 @@ func (c *Connection) Result(msg []byte) error {
 		return err
 	}
 
-	_, err := c.conn.Write([]byte("$" + strconv.Itoa(len(msg)) + "\r\n"))
-	if err != nil {
-		return err
-	}
-	if msg != nil {
-		_, err = c.conn.Write(msg)
-		if err != nil {
-			return err
-		}
-	}
-	_, err = c.conn.Write([]byte("\r\n"))
+	// a single write for the whole response
+	buf := acquireBuffer()
+	defer releaseBuffer(buf)
+	buf.WriteByte('$')
+	buf.WriteString(strconv.Itoa(len(msg)))
+	buf.WriteString("\r\n")
+	buf.Write(msg)
+	buf.WriteString("\r\n")
+	_, err := c.conn.Write(buf.Bytes())
 	return err
 }
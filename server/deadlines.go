@@ -0,0 +1,170 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/contribsys/faktory/util"
+)
+
+/*
+ * Each command must respond within its deadline, otherwise the client
+ * gets a TIMEOUT error rather than waiting on slow storage indefinitely:
+ *
+ * [deadlines]
+ * default = 10     # seconds, 0 disables
+ * push = 2         # per command
+ * flush = 0
+ *
+ * FLUSH has no deadline unless configured.  A command which misses its
+ * deadline keeps running in the background and may still complete, e.g.
+ * a timed out PUSH may have enqueued the job.  The connection's next
+ * command waits for it, within that command's own deadline, so commands
+ * on a connection never run concurrently.
+ */
+
+var defaultDeadlines = map[string]int{
+	"END":   0,
+	"FLUSH": 0,
+}
+
+// deadline returns how long the command may take, 0 if unlimited
+func (s *Server) deadline(verb string) time.Duration {
+	secs, ok := defaultDeadlines[verb]
+	if !ok {
+		secs = s.Options.Int("deadlines", "default", 10)
+	}
+	secs = s.Options.Int("deadlines", strings.ToLower(verb), secs)
+	return time.Duration(secs) * time.Second
+}
+
+// commandDeadline is the command's deadline, a FETCH's
+// covers the timeout it asked for
+func (s *Server) commandDeadline(verb string, cmd string) time.Duration {
+	limit := s.deadline(verb)
+	if verb != "FETCH" || limit <= 0 || !strings.Contains(cmd, "timeout=") {
+		return limit
+	}
+	if req, err := parseFetch(cmd); err == nil {
+		if wait := s.fetchTimeout(req.timeout) + 5*time.Second; wait > limit {
+			return wait
+		}
+	}
+	return limit
+}
+
+// deadlineStats counts the commands which missed their deadline
+type deadlineStats struct {
+	mu     sync.Mutex
+	missed map[string]uint64
+}
+
+func (ds *deadlineStats) add(verb string) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if ds.missed == nil {
+		ds.missed = map[string]uint64{}
+	}
+	ds.missed[verb]++
+}
+
+func (ds *deadlineStats) Stats() map[string]uint64 {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	stats := make(map[string]uint64, len(ds.missed))
+	for verb, count := range ds.missed {
+		stats[verb] = count
+	}
+	return stats
+}
+
+// responseBuffer holds a command's response until it completes
+// so a command which missed its deadline can't write to the client
+type responseBuffer struct {
+	bytes.Buffer
+	conn *Connection
+}
+
+func (rb *responseBuffer) Close() error {
+	return rb.conn.Close()
+}
+
+var responsePool = sync.Pool{
+	New: func() interface{} {
+		return &responseBuffer{}
+	},
+}
+
+// run calls the command, a panic is answered with an error
+// rather than taking down the server
+func (s *Server) run(c *Connection, verb string, proc command, cmd string) {
+	defer func() {
+		if r := recover(); r != nil {
+			util.Warnf("%s panicked: %v\n%s", verb, r, debug.Stack())
+			c.Error(cmd, fmt.Errorf("Internal error running %s", verb))
+		}
+	}()
+	proc(c, s, cmd)
+}
+
+// execute runs the command within its deadline
+func (s *Server) execute(c *Connection, verb string, proc command, cmd string) {
+	limit := s.commandDeadline(verb, cmd)
+	if limit <= 0 {
+		if c.running != nil {
+			<-c.running
+			c.running = nil
+		}
+		s.run(c, verb, proc, cmd)
+		return
+	}
+
+	timer := time.NewTimer(limit)
+	defer timer.Stop()
+
+	// wait for a previous command which missed its deadline
+	if c.running != nil {
+		select {
+		case <-c.running:
+			c.running = nil
+		case <-timer.C:
+			s.missed(c, verb, cmd, limit)
+			return
+		}
+	}
+
+	buf := responsePool.Get().(*responseBuffer)
+	buf.Reset()
+	buf.conn = c
+	bc := *c
+	bc.conn = buf
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.run(&bc, verb, proc, cmd)
+	}()
+
+	select {
+	case <-done:
+		c.conn.Write(buf.Bytes())
+		// a buffer which missed its deadline is left to the GC,
+		// the command may still write to it
+		if buf.Cap() <= maxPooledBuffer {
+			buf.conn = nil
+			responsePool.Put(buf)
+		}
+	case <-timer.C:
+		c.running = done
+		s.missed(c, verb, cmd, limit)
+	}
+}
+
+func (s *Server) missed(c *Connection, verb string, cmd string, limit time.Duration) {
+	s.deadlines.add(verb)
+	util.Warnf("%s exceeded its deadline of %v", verb, limit)
+	c.Error(cmd, newTaggedError("TIMEOUT", fmt.Errorf("%s exceeded its deadline of %v", verb, limit)))
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadlineConfig(t *testing.T) {
+	s := &Server{Options: &ServerOptions{}}
+	assert.Equal(t, 10*time.Second, s.deadline("PUSH"))
+	assert.Equal(t, time.Duration(0), s.deadline("FLUSH"))
+
+	s.Options.GlobalConfig = map[string]interface{}{
+		"deadlines": map[string]interface{}{"default": 3, "fetch": 5, "flush": 60},
+	}
+	assert.Equal(t, 3*time.Second, s.deadline("PUSH"))
+	assert.Equal(t, 5*time.Second, s.deadline("FETCH"))
+	assert.Equal(t, 60*time.Second, s.deadline("FLUSH"))
+	assert.Equal(t, time.Duration(0), s.deadline("END"))
+
+	assert.Equal(t, 5*time.Second, s.commandDeadline("FETCH", "FETCH default"))
+	assert.Equal(t, 25*time.Second, s.commandDeadline("FETCH", "FETCH default timeout=20"))
+	assert.Equal(t, 3*time.Second, s.commandDeadline("PUSH", "PUSH {}"))
+}
+
+func TestDeadlines(t *testing.T) {
+	s := &Server{Options: &ServerOptions{GlobalConfig: map[string]interface{}{
+		"deadlines": map[string]interface{}{"default": 1},
+	}}}
+	out := &bufferConn{}
+	c := &Connection{conn: out}
+
+	s.execute(c, "INFO", func(c *Connection, s *Server, cmd string) {
+		c.Ok()
+	}, "INFO")
+	assert.Equal(t, "+OK\r\n", out.String())
+	assert.Nil(t, c.running)
+
+	release := make(chan bool)
+	out.Reset()
+	s.execute(c, "PUSH", func(c *Connection, s *Server, cmd string) {
+		<-release
+		c.Ok()
+	}, "PUSH {}")
+	assert.Equal(t, "-TIMEOUT PUSH exceeded its deadline of 1s\r\n", out.String())
+	assert.NotNil(t, c.running)
+	assert.Equal(t, map[string]uint64{"PUSH": 1}, s.deadlines.Stats())
+
+	// the next command waits for the slow one, which can't respond
+	out.Reset()
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		release <- true
+	}()
+	s.execute(c, "ACK", func(c *Connection, s *Server, cmd string) {
+		c.Ok()
+	}, "ACK {}")
+	assert.Equal(t, "+OK\r\n", out.String())
+	assert.Nil(t, c.running)
+	assert.Equal(t, map[string]uint64{"PUSH": 1}, s.deadlines.Stats())
+}
+
+func TestPanickingCommand(t *testing.T) {
+	s := &Server{Options: &ServerOptions{}}
+	out := &bufferConn{}
+	c := &Connection{conn: out}
+	boom := func(c *Connection, s *Server, cmd string) {
+		var job *client.Job
+		c.Result([]byte(job.Jid))
+	}
+
+	// with and without a deadline
+	for _, verb := range []string{"PUSH", "FLUSH"} {
+		out.Reset()
+		s.execute(c, verb, boom, verb+" {}")
+		assert.Equal(t, "-ERR Internal error running "+verb+"\r\n", out.String())
+	}
+
+	out.Reset()
+	s.execute(c, "INFO", func(c *Connection, s *Server, cmd string) {
+		c.Ok()
+	}, "INFO")
+	assert.Equal(t, "+OK\r\n", out.String())
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/util"
+	"github.com/go-redis/redis"
+)
+
+/*
+ * Debouncing collapses repeated pushes of jobs with the same key within
+ * a window into one job.  Jobs opt in with the "debounce" custom attribute:
+ *
+ *   "debounce": {"key": "reindex-42", "window": 30}
+ *
+ * The first push starts the window and is scheduled at its end, later
+ * pushes within the window replace the pending job so it executes once
+ * with the latest arguments.  With "leading": true the first push is
+ * enqueued immediately and later pushes within the window are dropped.
+ * Keys are global, include the jobtype in the key if it may collide.
+ * Windows longer than the configured maximum are shortened:
+ *
+ * [debounce]
+ * max_window = 86400     # seconds
+ */
+type debouncer struct {
+	rclient   *redis.Client
+	maxWindow time.Duration
+	s         *Server
+}
+
+func DebounceSubsystem() Subsystem {
+	return &debouncer{}
+}
+
+func (d *debouncer) Start(s *Server) error {
+	d.s = s
+	d.rclient = s.Manager().Redis()
+	d.configure(s)
+	return nil
+}
+
+func (d *debouncer) Reload(s *Server) error {
+	d.configure(s)
+	return nil
+}
+
+func (d *debouncer) configure(s *Server) {
+	d.maxWindow = time.Duration(s.Options.Int("debounce", "max_window", 24*60*60)) * time.Second
+}
+
+func debounceKey(key string) string {
+	return "debounce-" + key
+}
+
+// debounceOptions returns the job's key, window and whether it's
+// leading, an empty key if the job doesn't debounce
+func debounceOptions(job *client.Job) (string, time.Duration, bool) {
+	val, ok := job.GetCustom(client.DebounceAttribute)
+	if !ok {
+		return "", 0, false
+	}
+	opts, ok := val.(map[string]interface{})
+	if !ok {
+		return "", 0, false
+	}
+	key, _ := opts["key"].(string)
+	var window time.Duration
+	switch v := opts["window"].(type) {
+	case float64:
+		window = time.Duration(v * float64(time.Second))
+	case int:
+		window = time.Duration(v) * time.Second
+	}
+	leading, _ := opts["leading"].(bool)
+	if window <= 0 {
+		return "", 0, false
+	}
+	return key, window, leading
+}
+
+// debounce returns true if the push was collapsed into an earlier one
+// and the job must not be pushed, otherwise the job may have been
+// scheduled for the end of its window.
+func (d *debouncer) debounce(job *client.Job) (bool, error) {
+	key, window, leading := debounceOptions(job)
+	if key == "" {
+		return false, nil
+	}
+	if window > d.maxWindow {
+		window = d.maxWindow
+	}
+	rkey := debounceKey(key)
+
+	if leading {
+		first, err := d.rclient.SetNX(rkey, job.Jid, window).Result()
+		if err != nil {
+			return false, err
+		}
+		return !first, nil
+	}
+
+	// the pending job is recorded as "<at> <jid>" so it can be replaced
+	at := util.Thens(time.Now().Add(window))
+	first, err := d.rclient.SetNX(rkey, at+" "+job.Jid, window).Result()
+	if err != nil {
+		return false, err
+	}
+	if first {
+		job.At = at
+		return false, nil
+	}
+
+	pending, err := d.rclient.Get(rkey).Result()
+	if err != nil && err != redis.Nil {
+		return false, err
+	}
+	fields := strings.SplitN(pending, " ", 2)
+	if len(fields) == 2 {
+		removed, err := d.s.Store().Scheduled().RemoveElement(fields[0], fields[1])
+		if err != nil {
+			return false, err
+		}
+		if removed {
+			tim, err := util.ParseTime(fields[0])
+			if err != nil {
+				return false, fmt.Errorf("Invalid debounce record for %s: %v", key, err)
+			}
+			job.At = fields[0]
+			ttl := time.Until(tim)
+			if ttl < time.Second {
+				ttl = time.Second
+			}
+			return false, d.rclient.Set(rkey, fields[0]+" "+job.Jid, ttl).Err()
+		}
+	}
+
+	// the pending job has already been enqueued, start a new window
+	job.At = at
+	return false, d.rclient.Set(rkey, at+" "+job.Jid, window).Err()
+}
+
+func (s *Server) debouncer() *debouncer {
+	for _, x := range s.Subsystems {
+		if d, ok := x.(*debouncer); ok {
+			return d
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/storage"
+	"github.com/contribsys/faktory/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDebounce(t *testing.T) {
+	s, _, stop := newTestServer(t, nil)
+	defer stop()
+	s.Register(DebounceSubsystem())
+	d := s.debouncer()
+	assert.NoError(t, d.Start(s))
+
+	// push as the PUSH command does, after a round trip through JSON
+	push := func(job *client.Job) bool {
+		data, err := json.Marshal(job)
+		assert.NoError(t, err)
+		var decoded client.Job
+		assert.NoError(t, json.Unmarshal(data, &decoded))
+		collapsed, err := d.debounce(&decoded)
+		assert.NoError(t, err)
+		if !collapsed {
+			assert.NoError(t, s.manager.Push(&decoded))
+		}
+		return collapsed
+	}
+
+	first := client.NewJob("Reindex", 42, "v1")
+	first.Debounce("reindex-42", time.Minute)
+	assert.False(t, push(first))
+	assert.EqualValues(t, 1, s.store.Scheduled().Size())
+
+	latest := client.NewJob("Reindex", 42, "v2")
+	latest.Debounce("reindex-42", time.Minute)
+	assert.False(t, push(latest))
+	assert.EqualValues(t, 1, s.store.Scheduled().Size())
+
+	var pending []*client.Job
+	assert.NoError(t, s.store.Scheduled().Each(func(idx int, e storage.SortedEntry) error {
+		job, err := e.Job()
+		pending = append(pending, job)
+		return err
+	}))
+	assert.Equal(t, latest.Jid, pending[0].Jid)
+	assert.Equal(t, "v2", pending[0].Args[1])
+	at, err := util.ParseTime(pending[0].At)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), at, 5*time.Second)
+
+	// the pending job was enqueued, the next push starts a new window
+	s.store.Scheduled().Clear()
+	again := client.NewJob("Reindex", 42, "v3")
+	again.Debounce("reindex-42", time.Minute)
+	assert.False(t, push(again))
+	assert.EqualValues(t, 1, s.store.Scheduled().Size())
+
+	q, err := s.store.GetQueue("default")
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		job := client.NewJob("Notify", i)
+		job.Throttle("notify", time.Minute)
+		assert.Equal(t, i > 0, push(job))
+	}
+	assert.EqualValues(t, 1, q.Size())
+
+	plain := client.NewJob("Notify", 1)
+	assert.False(t, push(plain))
+	assert.EqualValues(t, 2, q.Size())
+}
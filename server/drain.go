@@ -0,0 +1,95 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// DrainWindow is how much recent throughput drain estimates are based on.
+const DrainWindow = 15 * time.Minute
+
+// DrainEstimate predicts when a queue's backlog will clear, assuming
+// jobs keep arriving and being fetched at the rate they did over the
+// last DrainWindow.
+type DrainEstimate struct {
+	Queue string
+	Size  uint64
+	// jobs per minute pushed to and fetched from the queue
+	Inflow  float64
+	Outflow float64
+}
+
+// Rate is the net number of jobs per minute leaving the queue.
+func (d *DrainEstimate) Rate() float64 {
+	return d.Outflow - d.Inflow
+}
+
+// ETA returns how long until the queue is empty, false
+// if it isn't shrinking.
+func (d *DrainEstimate) ETA() (time.Duration, bool) {
+	if d.Size == 0 {
+		return 0, true
+	}
+	rate := d.Rate()
+	if rate <= 0 {
+		return 0, false
+	}
+	return time.Duration(float64(d.Size) / rate * float64(time.Minute)), true
+}
+
+// drain sums the complete minutes of the window for each queue
+func (m *queueMetrics) drain(sizes map[string]uint64) (map[string]*DrainEstimate, error) {
+	minutes := int64(DrainWindow / time.Minute)
+	last := m.minute() - 1
+	cmds := map[string][]*redis.StringStringMapCmd{}
+	_, err := m.rclient.Pipelined(func(pipe redis.Pipeliner) error {
+		for queue := range sizes {
+			for minute := last - minutes + 1; minute <= last; minute++ {
+				cmds[queue] = append(cmds[queue], pipe.HGetAll(metricsKey(queue, minute)))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	estimates := map[string]*DrainEstimate{}
+	for queue, size := range sizes {
+		var pushed, fetched int64
+		for _, cmd := range cmds[queue] {
+			vals := cmd.Val()
+			p, _ := strconv.ParseInt(vals[metricPushed], 10, 64)
+			f, _ := strconv.ParseInt(vals[metricFetched], 10, 64)
+			pushed += p
+			fetched += f
+		}
+		estimates[queue] = &DrainEstimate{
+			Queue:   queue,
+			Size:    size,
+			Inflow:  float64(pushed) / float64(minutes),
+			Outflow: float64(fetched) / float64(minutes),
+		}
+	}
+	return estimates, nil
+}
+
+// DrainEstimates predicts when each of the given queues will be empty
+// or returns nil if the metrics subsystem isn't running.
+func (s *Server) DrainEstimates(queues ...string) (map[string]*DrainEstimate, error) {
+	m := s.metrics()
+	if m == nil {
+		return nil, nil
+	}
+	sizes := map[string]uint64{}
+	for _, name := range queues {
+		q, err := s.store.GetQueue(name)
+		if err != nil {
+			return nil, err
+		}
+		sizes[name] = q.Size()
+	}
+	return m.drain(sizes)
+}
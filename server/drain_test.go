@@ -0,0 +1,54 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDrainEstimates(t *testing.T) {
+	booted, _, stop := newTestServer(t, nil)
+	defer stop()
+
+	now := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	m := &queueMetrics{
+		rclient:   booted.store.Redis(),
+		store:     booted.store,
+		retention: 1 * time.Hour,
+		now:       func() time.Time { return now },
+	}
+
+	// 30 jobs pushed and 60 fetched per minute in "busy"
+	for i := 0; i < 15; i++ {
+		job := client.NewJob("Report")
+		job.Queue = "busy"
+		for j := 0; j < 30; j++ {
+			m.pushed(job)
+			m.fetched(job)
+			m.fetched(job)
+		}
+		m.pushed(&client.Job{Queue: "growing"})
+		now = now.Add(time.Minute)
+	}
+
+	est, err := m.drain(map[string]uint64{"busy": 900, "growing": 15, "empty": 0})
+	assert.NoError(t, err)
+	assert.EqualValues(t, 30, est["busy"].Inflow)
+	assert.EqualValues(t, 60, est["busy"].Outflow)
+	eta, ok := est["busy"].ETA()
+	assert.True(t, ok)
+	assert.Equal(t, 30*time.Minute, eta)
+
+	_, ok = est["growing"].ETA()
+	assert.False(t, ok)
+	eta, ok = est["empty"].ETA()
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(0), eta)
+
+	s := &Server{}
+	est, err = s.DrainEstimates("busy")
+	assert.NoError(t, err)
+	assert.Nil(t, est)
+}
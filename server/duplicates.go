@@ -0,0 +1,291 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/manager"
+	"github.com/contribsys/faktory/util"
+	"github.com/go-redis/redis"
+)
+
+/*
+ * The duplicates report finds producers which push the same job over
+ * and over, wasting workers even though the jobs aren't required to
+ * be unique.  Each push's jobtype and arguments are hashed and a push
+ * whose hash was already seen in the current window counts as a
+ * duplicate, nothing is rejected:
+ *
+ * [duplicates]
+ * window = 3600     # seconds, 0 disables tracking
+ * minimum = 10      # pushes over both windows before a jobtype is reported
+ *
+ * Every minute the report of the jobtypes with the highest duplicate
+ * rates over the current and previous windows is refreshed, see the
+ * Web UI's Duplicates page and the API's duplicates field.  Retries
+ * and scheduled jobs coming due aren't counted.  Jobs in encrypted
+ * queues aren't counted either: the subsystem is registered after
+ * Encryption so their plaintext arguments are never hashed, where a
+ * hash could be used to guess them, and their ciphertext never repeats.
+ */
+const (
+	// jobtypes in the report
+	maxDuplicateTypes = 25
+
+	duplicatePushed = "pushed:"
+	duplicateSeen   = "dupes:"
+)
+
+// DuplicateStat counts a jobtype's pushes and duplicates
+type DuplicateStat struct {
+	Type       string `json:"jobtype"`
+	Pushed     int64  `json:"pushed"`
+	Duplicates int64  `json:"duplicates"`
+}
+
+// Rate is the fraction of pushes which were duplicates
+func (ds *DuplicateStat) Rate() float64 {
+	if ds.Pushed == 0 {
+		return 0
+	}
+	return float64(ds.Duplicates) / float64(ds.Pushed)
+}
+
+type DuplicatesReport struct {
+	At     time.Time
+	Window time.Duration
+	// highest rate first
+	JobTypes []*DuplicateStat
+}
+
+type duplicates struct {
+	rclient *redis.Client
+	now     func() time.Time
+
+	mu      sync.Mutex
+	window  time.Duration
+	minimum int64
+	report  *DuplicatesReport
+}
+
+func DuplicatesSubsystem() Subsystem {
+	return &duplicates{now: time.Now}
+}
+
+func (d *duplicates) Start(s *Server) error {
+	d.rclient = s.Manager().Redis()
+	d.configure(s)
+
+	s.Manager().AddMiddleware("push", d.push)
+	s.Manager().AddMiddleware("schedule", d.push)
+	s.AddTask(60, d)
+	return nil
+}
+
+func (d *duplicates) Reload(s *Server) error {
+	d.configure(s)
+	return nil
+}
+
+func (d *duplicates) configure(s *Server) {
+	window := time.Duration(s.Options.Int("duplicates", "window", 0)) * time.Second
+	minimum := int64(s.Options.Int("duplicates", "minimum", 10))
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if window > 0 && d.window != window {
+		util.Infof("Reporting duplicate pushes within %v", window)
+	}
+	d.window = window
+	d.minimum = minimum
+	if window == 0 {
+		d.report = nil
+	}
+}
+
+func (d *duplicates) settings() (time.Duration, int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.window, d.minimum
+}
+
+func duplicatesKey(bucket int64) string {
+	return fmt.Sprintf("duplicates:%d", bucket)
+}
+
+func duplicateHashesKey(bucket int64, jobtype string) string {
+	return fmt.Sprintf("duplicates:%d:%s", bucket, jobtype)
+}
+
+// argsHash identifies pushes of the same job, the
+// jobtype is part of the hashes' key
+func argsHash(job *client.Job) (string, error) {
+	data, err := json.Marshal(job.Args)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+func (d *duplicates) push(next func() error, ctx manager.Context) error {
+	err := next()
+	job := ctx.Job()
+	window, _ := d.settings()
+	if err != nil || window == 0 || job.Failure != nil || manager.Reenqueued(ctx) {
+		return err
+	}
+	if _, ok := encryptedArgs(job); ok {
+		return nil
+	}
+	rerr := d.record(window, job)
+	if rerr != nil {
+		util.ForJob(job.Jid, job.Queue).Warnf("Unable to check %s for duplicates: %v", job.Jid, rerr)
+	}
+	return nil
+}
+
+func (d *duplicates) record(window time.Duration, job *client.Job) error {
+	hash, err := argsHash(job)
+	if err != nil {
+		return err
+	}
+	bucket := d.now().Unix() / int64(window/time.Second)
+	hashes := duplicateHashesKey(bucket, job.Type)
+	var added *redis.IntCmd
+	_, err = d.rclient.Pipelined(func(pipe redis.Pipeliner) error {
+		added = pipe.SAdd(hashes, hash)
+		// the previous window is reported along with the current one
+		pipe.Expire(hashes, 2*window)
+		pipe.HIncrBy(duplicatesKey(bucket), duplicatePushed+job.Type, 1)
+		pipe.Expire(duplicatesKey(bucket), 2*window)
+		return nil
+	})
+	if err != nil || added.Val() == 1 {
+		return err
+	}
+	return d.rclient.HIncrBy(duplicatesKey(bucket), duplicateSeen+job.Type, 1).Err()
+}
+
+func (d *duplicates) Name() string {
+	return "Duplicates"
+}
+
+func (d *duplicates) Stats() map[string]interface{} {
+	window, _ := d.settings()
+	return map[string]interface{}{
+		"window": int64(window / time.Second),
+	}
+}
+
+// Execute refreshes the report
+func (d *duplicates) Execute() error {
+	window, minimum := d.settings()
+	if window == 0 {
+		return nil
+	}
+	report, err := d.compute(window, minimum)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.report = report
+	d.mu.Unlock()
+	return nil
+}
+
+func (d *duplicates) compute(window time.Duration, minimum int64) (*DuplicatesReport, error) {
+	now := d.now()
+	bucket := now.Unix() / int64(window/time.Second)
+	var counts []*redis.StringStringMapCmd
+	_, err := d.rclient.Pipelined(func(pipe redis.Pipeliner) error {
+		for _, b := range []int64{bucket - 1, bucket} {
+			counts = append(counts, pipe.HGetAll(duplicatesKey(b)))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stats := map[string]*DuplicateStat{}
+	for _, cmd := range counts {
+		for field, val := range cmd.Val() {
+			count, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				continue
+			}
+			jobtype := field[strings.Index(field, ":")+1:]
+			stat, ok := stats[jobtype]
+			if !ok {
+				stat = &DuplicateStat{Type: jobtype}
+				stats[jobtype] = stat
+			}
+			if strings.HasPrefix(field, duplicateSeen) {
+				stat.Duplicates += count
+			} else {
+				stat.Pushed += count
+			}
+		}
+	}
+
+	report := &DuplicatesReport{At: now, Window: window, JobTypes: []*DuplicateStat{}}
+	for _, stat := range stats {
+		if stat.Duplicates > 0 && stat.Pushed >= minimum {
+			report.JobTypes = append(report.JobTypes, stat)
+		}
+	}
+	sort.Slice(report.JobTypes, func(i, j int) bool {
+		a, b := report.JobTypes[i], report.JobTypes[j]
+		if a.Rate() != b.Rate() {
+			return a.Rate() > b.Rate()
+		}
+		if a.Duplicates != b.Duplicates {
+			return a.Duplicates > b.Duplicates
+		}
+		return a.Type < b.Type
+	})
+	if len(report.JobTypes) > maxDuplicateTypes {
+		report.JobTypes = report.JobTypes[:maxDuplicateTypes]
+	}
+	return report, nil
+}
+
+func (s *Server) duplicates() *duplicates {
+	for _, x := range s.Subsystems {
+		if d, ok := x.(*duplicates); ok {
+			return d
+		}
+	}
+	return nil
+}
+
+// DuplicatesEnabled is true if pushes are checked for duplicates.
+func (s *Server) DuplicatesEnabled() bool {
+	d := s.duplicates()
+	if d == nil {
+		return false
+	}
+	window, _ := d.settings()
+	return window > 0
+}
+
+// DuplicatesReport returns the latest report, nil if duplicates
+// aren't tracked or the first report hasn't been computed.
+func (s *Server) DuplicatesReport() *DuplicatesReport {
+	d := s.duplicates()
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.report
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDuplicates(t *testing.T) {
+	s, _, stop := newTestServer(t, &ServerOptions{GlobalConfig: map[string]interface{}{
+		"duplicates": map[string]interface{}{"window": 3600, "minimum": 4},
+		"encryption": map[string]interface{}{
+			"queues": []interface{}{"payments"},
+			"key":    "k1",
+			"keys":   map[string]interface{}{"k1": base64.StdEncoding.EncodeToString(make([]byte, 32))},
+		},
+	}})
+	defer stop()
+	assert.False(t, s.DuplicatesEnabled())
+	// registered in this order by the daemon
+	enc := EncryptionSubsystem()
+	s.Register(enc)
+	s.Register(DuplicatesSubsystem())
+	d := s.duplicates()
+	now := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	d.now = func() time.Time { return now }
+	assert.NoError(t, enc.Start(s))
+	assert.NoError(t, d.Start(s))
+	assert.True(t, s.DuplicatesEnabled())
+	assert.Nil(t, s.DuplicatesReport())
+
+	push := func(jobtype string, args ...interface{}) {
+		assert.NoError(t, s.manager.Push(client.NewJob(jobtype, args...)))
+	}
+	// half of Reindex's pushes are duplicates
+	for idx := 0; idx < 4; idx++ {
+		push("Reindex", "users", idx%2)
+	}
+	// a quarter of Notify's, counting the previous window
+	push("Notify", 1)
+	now = now.Add(time.Hour)
+	push("Notify", 1)
+	push("Notify", 2)
+	push("Notify", 1)
+	// too few pushes to report
+	push("Cleanup", 1)
+	push("Cleanup", 1)
+	// retries aren't pushes
+	retry := client.NewJob("Unique", 1)
+	retry.Failure = &client.Failure{RetryCount: 1}
+	push("Unique", 1)
+	assert.NoError(t, s.manager.Push(retry))
+	// nor are scheduled jobs coming due
+	scheduled := client.NewJob("Report", 1)
+	scheduled.At = util.Thens(time.Now().Add(time.Hour))
+	assert.NoError(t, s.manager.Push(scheduled))
+	for idx := 0; idx < 4; idx++ {
+		assert.NoError(t, s.manager.PushFirst(scheduled))
+	}
+	// nor jobs in encrypted queues, their args are never hashed
+	for idx := 0; idx < 4; idx++ {
+		charge := client.NewJob("Charge", "4242 4242 4242 4242")
+		charge.Queue = "payments"
+		assert.NoError(t, s.manager.Push(charge))
+	}
+	bucket := now.Unix() / 3600
+	hashes, err := d.rclient.Exists(duplicateHashesKey(bucket, "Charge")).Result()
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, hashes)
+
+	assert.NoError(t, d.Execute())
+	report := s.DuplicatesReport()
+	assert.Equal(t, time.Hour, report.Window)
+	assert.Equal(t, 2, len(report.JobTypes))
+	assert.Equal(t, &DuplicateStat{Type: "Reindex", Pushed: 4, Duplicates: 2}, report.JobTypes[0])
+	assert.Equal(t, &DuplicateStat{Type: "Notify", Pushed: 4, Duplicates: 1}, report.JobTypes[1])
+	assert.Equal(t, 0.25, report.JobTypes[1].Rate())
+
+	// disabled by reloading without a window
+	s.Options.GlobalConfig = map[string]interface{}{}
+	assert.NoError(t, d.Reload(s))
+	assert.False(t, s.DuplicatesEnabled())
+	assert.Nil(t, s.DuplicatesReport())
+}
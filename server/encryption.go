@@ -0,0 +1,253 @@
+package server
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/manager"
+	"github.com/contribsys/faktory/util"
+)
+
+/*
+ * Jobs pushed to sensitive queues have their args encrypted with
+ * AES-GCM before they're stored, whatever the producer sent.  The
+ * args are replaced with an empty array and the key id and ciphertext
+ * are stored in the "encrypted" custom attribute.  Workers receive the
+ * decrypted args on FETCH, everywhere else (Redis, the Web UI, samples,
+ * mirrors) only sees the ciphertext.
+ *
+ * [encryption]
+ * queues = ["payments", "users"]
+ * key = "2019-06"          # encrypts new jobs
+ *
+ * [encryption.keys]        # base64 encoded 256-bit keys
+ * 2019-06 = "..."
+ * 2018-11 = "..."          # retired keys still decrypt older jobs
+ *
+ * Keys are rotated by adding a new key, making it the current key and
+ * removing the old one once its jobs are gone.  Mirrors and linked
+ * regions receive encrypted jobs and need the same keys.
+ */
+const EncryptedAttribute = "encrypted"
+
+type encryptor struct {
+	mu      sync.RWMutex
+	queues  map[string]bool
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+func EncryptionSubsystem() Subsystem {
+	return &encryptor{}
+}
+
+func (e *encryptor) Start(s *Server) error {
+	err := e.configure(s)
+	if err != nil {
+		return err
+	}
+
+	s.Manager().AddMiddleware("push", e.push)
+	s.Manager().AddMiddleware("schedule", e.push)
+	return nil
+}
+
+func (e *encryptor) Reload(s *Server) error {
+	return e.configure(s)
+}
+
+func (e *encryptor) configure(s *Server) error {
+	queues := map[string]bool{}
+	if list, ok := s.Options.Config("encryption", "queues", nil).([]interface{}); ok {
+		for _, q := range list {
+			queues[fmt.Sprintf("%v", q)] = true
+		}
+	}
+
+	keys := map[string]cipher.AEAD{}
+	if mapp, ok := s.Options.Config("encryption", "keys", nil).(map[string]interface{}); ok {
+		for id, val := range mapp {
+			if strings.Contains(id, ":") {
+				return fmt.Errorf("Encryption key id %q can't contain a colon", id)
+			}
+			aead, err := newAEAD(fmt.Sprintf("%v", val))
+			if err != nil {
+				return fmt.Errorf("Invalid encryption key %s: %v", id, err)
+			}
+			keys[id] = aead
+		}
+	}
+
+	current := s.Options.String("encryption", "key", "")
+	if len(queues) > 0 && keys[current] == nil {
+		return fmt.Errorf("Sensitive queues require an encryption key, %q isn't in [encryption.keys]", current)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(queues) > 0 && len(e.queues) == 0 {
+		util.Infof("Encrypting jobs in %d sensitive queues", len(queues))
+	}
+	e.queues = queues
+	e.current = current
+	e.keys = keys
+	return nil
+}
+
+func newAEAD(encoded string) (cipher.AEAD, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("expected 32 bytes, not %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sensitive is true if jobs in the queue are encrypted
+func (e *encryptor) sensitive(queue string) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.queues[queue]
+}
+
+func encryptedArgs(job *client.Job) (string, bool) {
+	val, ok := job.GetCustom(EncryptedAttribute)
+	if !ok {
+		return "", false
+	}
+	sealed, ok := val.(string)
+	return sealed, ok && sealed != ""
+}
+
+func (e *encryptor) push(next func() error, ctx manager.Context) error {
+	job := ctx.Job()
+	if e.sensitive(job.Queue) {
+		err := e.seal(job)
+		if err != nil {
+			return err
+		}
+	}
+	return next()
+}
+
+// seal encrypts the job's args unless they already are, e.g.
+// a retry or a scheduled job being enqueued
+func (e *encryptor) seal(job *client.Job) error {
+	if _, ok := encryptedArgs(job); ok && len(job.Args) == 0 {
+		return nil
+	}
+
+	e.mu.RLock()
+	id := e.current
+	aead := e.keys[id]
+	e.mu.RUnlock()
+
+	data, err := json.Marshal(job.Args)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return err
+	}
+	// the JID is authenticated so ciphertext can't be moved to another job
+	sealed := aead.Seal(nonce, nonce, data, []byte(job.Jid))
+
+	job.Args = []interface{}{}
+	job.SetCustom(EncryptedAttribute, id+":"+base64.StdEncoding.EncodeToString(sealed))
+	return nil
+}
+
+// open returns the job's decrypted args
+func (e *encryptor) open(job *client.Job) ([]interface{}, error) {
+	val, _ := encryptedArgs(job)
+	idx := strings.Index(val, ":")
+	if idx < 0 {
+		return nil, fmt.Errorf("Invalid encrypted args")
+	}
+	id := val[0:idx]
+
+	e.mu.RLock()
+	aead := e.keys[id]
+	e.mu.RUnlock()
+	if aead == nil {
+		return nil, fmt.Errorf("Unknown encryption key %q", id)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(val[idx+1:])
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("Invalid encrypted args")
+	}
+	data, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(job.Jid))
+	if err != nil {
+		return nil, err
+	}
+
+	var args []interface{}
+	err = json.Unmarshal(data, &args)
+	return args, err
+}
+
+func (s *Server) encryptor() *encryptor {
+	for _, x := range s.Subsystems {
+		if e, ok := x.(*encryptor); ok {
+			return e
+		}
+	}
+	return nil
+}
+
+// Sensitive is true if the queue's jobs are encrypted and
+// should be redacted.
+func (s *Server) Sensitive(queue string) bool {
+	e := s.encryptor()
+	return e != nil && e.sensitive(queue)
+}
+
+// decrypted returns the job as sent to the worker, a copy with its
+// args decrypted.  The stored job and the reservation keep the
+// ciphertext.
+func (s *Server) decrypted(job *client.Job) (*client.Job, error) {
+	if _, ok := encryptedArgs(job); !ok {
+		return job, nil
+	}
+	e := s.encryptor()
+	if e == nil {
+		return nil, fmt.Errorf("Job %s is encrypted but encryption isn't configured", job.Jid)
+	}
+	args, err := e.open(job)
+	if err != nil {
+		return nil, err
+	}
+
+	plain := *job
+	plain.Args = args
+	plain.Custom = map[string]interface{}{}
+	for k, v := range job.Custom {
+		if k != EncryptedAttribute {
+			plain.Custom[k] = v
+		}
+	}
+	if len(plain.Custom) == 0 {
+		plain.Custom = nil
+	}
+	return &plain, nil
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/storage"
+	"github.com/contribsys/faktory/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(b), 32)))
+}
+
+func encryptionOptions(current string, keys map[string]interface{}) *ServerOptions {
+	return &ServerOptions{GlobalConfig: map[string]interface{}{
+		"encryption": map[string]interface{}{
+			"queues": []interface{}{"payments"},
+			"key":    current,
+			"keys":   keys,
+		},
+	}}
+}
+
+func TestEncryptionConfig(t *testing.T) {
+	e := &encryptor{}
+	assert.NoError(t, e.configure(&Server{Options: &ServerOptions{}}))
+	assert.False(t, e.sensitive("payments"))
+
+	for _, opts := range []*ServerOptions{
+		encryptionOptions("", nil),
+		encryptionOptions("k2", map[string]interface{}{"k1": testKey('a')}),
+		encryptionOptions("k1", map[string]interface{}{"k1": "short"}),
+		encryptionOptions("k:1", map[string]interface{}{"k:1": testKey('a')}),
+	} {
+		assert.Error(t, e.configure(&Server{Options: opts}))
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	s, _, stop := newTestServer(t, encryptionOptions("k1", map[string]interface{}{"k1": testKey('a')}))
+	defer stop()
+	s.Register(EncryptionSubsystem())
+	e := s.encryptor()
+	assert.NoError(t, e.Start(s))
+	assert.True(t, s.Sensitive("payments"))
+	assert.False(t, s.Sensitive("default"))
+
+	job := client.NewJob("Charge", "4111 1111 1111 1111", 42)
+	job.Queue = "payments"
+	assert.NoError(t, s.manager.Push(job))
+	plain := client.NewJob("Email", "bob@example.com")
+	assert.NoError(t, s.manager.Push(plain))
+	later := client.NewJob("Charge", "4111 1111 1111 1111", 7)
+	later.Queue = "payments"
+	later.At = util.Thens(time.Now().Add(time.Hour))
+	assert.NoError(t, s.manager.Push(later))
+
+	// nothing readable is stored
+	q, err := s.store.GetQueue("payments")
+	assert.NoError(t, err)
+	q.Each(func(_ int, data []byte) error {
+		assert.NotContains(t, string(data), "4111")
+		assert.Contains(t, string(data), `"encrypted":"k1:`)
+		return nil
+	})
+	s.store.Scheduled().Each(func(_ int, entry storage.SortedEntry) error {
+		data := entry.Value()
+		assert.NotContains(t, string(data), "4111")
+		return nil
+	})
+
+	// rotate, the old key still decrypts
+	e.configure(&Server{Options: encryptionOptions("k2", map[string]interface{}{"k1": testKey('a'), "k2": testKey('b')})})
+
+	fetched, err := s.manager.Fetch(context.Background(), "", "payments")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(fetched.Args))
+	worker, err := s.decrypted(fetched)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"4111 1111 1111 1111", float64(42)}, worker.Args)
+	assert.Nil(t, worker.Custom)
+	// the reservation keeps the ciphertext
+	assert.Equal(t, 0, len(fetched.Args))
+
+	unencrypted, err := s.manager.Fetch(context.Background(), "", "default")
+	assert.NoError(t, err)
+	same, err := s.decrypted(unencrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, unencrypted, same)
+
+	// new jobs use the new key
+	job = client.NewJob("Charge", "5500 0000 0000 0004")
+	job.Queue = "payments"
+	assert.NoError(t, e.seal(job))
+	sealed, _ := encryptedArgs(job)
+	assert.True(t, strings.HasPrefix(sealed, "k2:"))
+
+	// ciphertext is bound to its job
+	other := client.NewJob("Charge")
+	other.SetCustom(EncryptedAttribute, sealed)
+	_, err = s.decrypted(other)
+	assert.Error(t, err)
+
+	e.configure(&Server{Options: encryptionOptions("k1", map[string]interface{}{"k1": testKey('a')})})
+	_, err = s.decrypted(job)
+	assert.EqualError(t, err, `Unknown encryption key "k2"`)
+}
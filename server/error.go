@@ -1,6 +1,32 @@
 package server
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/contribsys/faktory/manager"
+)
+
+/*
+ * Error responses start with a code so clients can branch on the
+ * cause rather than parsing the message.  These codes are stable,
+ * anything else is ERR.  The list is part of the protocol reference,
+ * see protocol.go.
+ */
+var errorCodes = []FieldDoc{
+	{"ERR", "a generic error"},
+	{"AUTH", "authentication failed"},
+	{"TOOBIG", "the job is larger than [faktory] max_job_size"},
+	{"PAUSED", "the queue is paused, reserved as paused queues currently accept jobs"},
+	{"BUSY", "the server is overloaded or storage is unhealthy, back off and retry"},
+	{"NOTFOUND", "the job, worker, template or batch doesn't exist"},
+	{"MALFORMED", "the command's payload couldn't be parsed"},
+	{"NOTUNIQUE", "a job with the same jobtype and args is pending and the job is unique"},
+	{"NOPERM", "the connection may not use the command, e.g. an admin command off the admin binding"},
+	{"TIMEOUT", "the command exceeded its deadline, it may still complete"},
+	{"UNAVAILABLE", "storage didn't recover in time, retry later"},
+	{"SHUTDOWN", "the server is shutting down"},
+	{"MAINTENANCE", "the server is in maintenance mode, FETCH is refused until it ends"},
+}
 
 type taggedError struct {
 	Code string
@@ -14,3 +40,15 @@ func (re *taggedError) Error() string {
 func newTaggedError(code string, err error) *taggedError {
 	return &taggedError{Code: code, Err: err}
 }
+
+// ErrorCode returns the code the error is reported to clients with,
+// e.g. TOOBIG, ERR if it has none.
+func ErrorCode(err error) string {
+	if re, ok := err.(*taggedError); ok {
+		return re.Code
+	}
+	if err == manager.ErrBusy {
+		return "BUSY"
+	}
+	return "ERR"
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/manager"
+	"github.com/contribsys/faktory/util"
+	"github.com/go-redis/redis"
+)
+
+/*
+ * Lineage records which job pushed which so cascades can be traced
+ * in the Web UI.  Jobs pushed from within another job carry its JID in
+ * the "parent_jid" custom attribute, the Go client's PushContext sets it
+ * for jobs executed with client.WithJob.  Records expire after the
+ * configured TTL:
+ *
+ * [lineage]
+ * ttl = 604800     # seconds
+ */
+type lineage struct {
+	rclient *redis.Client
+	ttl     time.Duration
+}
+
+// LineageNode is a job in a lineage tree
+type LineageNode struct {
+	Jid      string         `json:"jid"`
+	Type     string         `json:"jobtype"`
+	Queue    string         `json:"queue"`
+	Parent   string         `json:"parent_jid"`
+	Children []*LineageNode `json:"-"`
+}
+
+// limits on the tree shown for a job
+const (
+	lineageAncestors   = 10
+	lineageDepth       = 3
+	lineageMaxChildren = 50
+)
+
+func LineageSubsystem() Subsystem {
+	return &lineage{}
+}
+
+func (l *lineage) Start(s *Server) error {
+	l.rclient = s.Manager().Redis()
+	l.configure(s)
+
+	s.Manager().AddMiddleware("push", l.push)
+	return nil
+}
+
+func (l *lineage) Reload(s *Server) error {
+	l.configure(s)
+	return nil
+}
+
+func (l *lineage) configure(s *Server) {
+	l.ttl = time.Duration(s.Options.Int("lineage", "ttl", 7*24*60*60)) * time.Second
+}
+
+func lineageKey(jid string) string {
+	return "lineage-" + jid
+}
+
+func childrenKey(jid string) string {
+	return "children-" + jid
+}
+
+func parentJid(job *client.Job) string {
+	val, _ := job.GetCustom(client.ParentAttribute)
+	parent, _ := val.(string)
+	return parent
+}
+
+// push records the job once it has been enqueued, retries are
+// enqueued again so the records are idempotent
+func (l *lineage) push(next func() error, ctx manager.Context) error {
+	err := next()
+	if err == nil {
+		l.record(ctx.Job())
+	}
+	return err
+}
+
+func (l *lineage) record(job *client.Job) {
+	parent := parentJid(job)
+	if parent == "" {
+		return
+	}
+	data, err := json.Marshal(&LineageNode{Jid: job.Jid, Type: job.Type, Queue: job.Queue, Parent: parent})
+	if err != nil {
+		return
+	}
+	_, err = l.rclient.Pipelined(func(pipe redis.Pipeliner) error {
+		pipe.Set(lineageKey(job.Jid), data, l.ttl)
+		pipe.SAdd(childrenKey(parent), job.Jid)
+		pipe.Expire(childrenKey(parent), l.ttl)
+		return nil
+	})
+	if err != nil {
+		util.ForJob(job.Jid, job.Queue).Warnf("Unable to record lineage of %s: %v", job.Jid, err)
+	}
+}
+
+// node returns the job's record, a bare node if it has no parent
+// or the record has expired
+func (l *lineage) node(jid string) (*LineageNode, error) {
+	data, err := l.rclient.Get(lineageKey(jid)).Bytes()
+	if err == redis.Nil {
+		return &LineageNode{Jid: jid}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var node LineageNode
+	err = json.Unmarshal(data, &node)
+	if err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+func (l *lineage) children(node *LineageNode, depth int) error {
+	if depth == 0 {
+		return nil
+	}
+	jids, err := l.rclient.SMembers(childrenKey(node.Jid)).Result()
+	if err != nil {
+		return err
+	}
+	sort.Strings(jids)
+	if len(jids) > lineageMaxChildren {
+		jids = jids[:lineageMaxChildren]
+	}
+	for _, jid := range jids {
+		child, err := l.node(jid)
+		if err != nil {
+			return err
+		}
+		err = l.children(child, depth-1)
+		if err != nil {
+			return err
+		}
+		node.Children = append(node.Children, child)
+	}
+	return nil
+}
+
+// tree returns the job's ancestors, oldest first, and the job
+// with its descendants
+func (l *lineage) tree(job *client.Job) ([]*LineageNode, *LineageNode, error) {
+	root := &LineageNode{Jid: job.Jid, Type: job.Type, Queue: job.Queue, Parent: parentJid(job)}
+	err := l.children(root, lineageDepth)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ancestors := []*LineageNode{}
+	parent := root.Parent
+	for parent != "" && len(ancestors) < lineageAncestors {
+		node, err := l.node(parent)
+		if err != nil {
+			return nil, nil, err
+		}
+		ancestors = append([]*LineageNode{node}, ancestors...)
+		parent = node.Parent
+	}
+	return ancestors, root, nil
+}
+
+// Lineage returns the job's ancestors, oldest first, and the job with
+// its descendants.  Ancestors which weren't pushed from a job are only
+// known by their JID.  Without the lineage subsystem the job has no
+// relatives.
+func (s *Server) Lineage(job *client.Job) ([]*LineageNode, *LineageNode, error) {
+	l := s.lineage()
+	if l == nil {
+		return nil, &LineageNode{Jid: job.Jid, Type: job.Type, Queue: job.Queue}, nil
+	}
+	return l.tree(job)
+}
+
+func (s *Server) lineage() *lineage {
+	for _, x := range s.Subsystems {
+		if l, ok := x.(*lineage); ok {
+			return l
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLineage(t *testing.T) {
+	s, _, stop := newTestServer(t, nil)
+	defer stop()
+
+	parent := client.NewJob("Import", "orders.csv")
+	ancestors, tree, err := s.Lineage(parent)
+	assert.NoError(t, err)
+	assert.Empty(t, ancestors)
+	assert.Empty(t, tree.Children)
+
+	assert.Nil(t, s.lineage())
+	s.Register(LineageSubsystem())
+	assert.NoError(t, s.lineage().Start(s))
+
+	push := func(jobtype string, parent *client.Job) *client.Job {
+		job := client.NewJob(jobtype, 1)
+		if parent != nil {
+			job.SetCustom(client.ParentAttribute, parent.Jid)
+		}
+		assert.NoError(t, s.manager.Push(job))
+		return job
+	}
+	assert.NoError(t, s.manager.Push(parent))
+	row := push("ImportRow", parent)
+	push("ImportRow", parent)
+	notify := push("Notify", row)
+	push("Unrelated", nil)
+
+	ancestors, tree, err = s.Lineage(notify)
+	assert.NoError(t, err)
+	assert.Empty(t, tree.Children)
+	assert.Equal(t, 2, len(ancestors))
+	// the root wasn't pushed from a job, only its JID is known
+	assert.Equal(t, &LineageNode{Jid: parent.Jid}, ancestors[0])
+	assert.Equal(t, "ImportRow", ancestors[1].Type)
+	assert.Equal(t, row.Jid, ancestors[1].Jid)
+
+	ancestors, tree, err = s.Lineage(parent)
+	assert.NoError(t, err)
+	assert.Empty(t, ancestors)
+	assert.Equal(t, 2, len(tree.Children))
+	for _, child := range tree.Children {
+		assert.Equal(t, "ImportRow", child.Type)
+		if child.Jid == row.Jid {
+			assert.Equal(t, 1, len(child.Children))
+			assert.Equal(t, notify.Jid, child.Children[0].Jid)
+		} else {
+			assert.Empty(t, child.Children)
+		}
+	}
+}
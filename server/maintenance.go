@@ -0,0 +1,63 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/contribsys/faktory/util"
+)
+
+/*
+ * In maintenance mode the server accepts connections and PUSH as
+ * usual but FETCH fails with MAINTENANCE, so operators can drain the
+ * workers while producers' jobs keep queueing.  Start the server with
+ * `faktory -maintenance`, or toggle it at runtime:
+ *
+ *   MAINTENANCE ON
+ *   MAINTENANCE OFF
+ *
+ * The mode isn't saved, a restart without -maintenance ends it.
+ */
+
+// SetMaintenance turns maintenance mode on or off.
+func (s *Server) SetMaintenance(on bool) {
+	val := int32(0)
+	if on {
+		val = 1
+	}
+	if atomic.SwapInt32(&s.maint, val) == val {
+		return
+	}
+	if on {
+		util.Warn("Maintenance mode, FETCH is refused until MAINTENANCE OFF")
+	} else {
+		util.Info("Maintenance mode is over, FETCH is resumed")
+	}
+	s.RecordChange(ChangeMaintenance, "", map[string]interface{}{"on": on})
+}
+
+// InMaintenance reports whether FETCH is refused.
+func (s *Server) InMaintenance() bool {
+	return atomic.LoadInt32(&s.maint) == 1
+}
+
+// MaintenanceDelay slows workers retrying FETCH in a loop
+// during maintenance.
+var MaintenanceDelay = 2 * time.Second
+
+var errMaintenance = newTaggedError("MAINTENANCE", fmt.Errorf("Server is in maintenance mode, jobs are not fetched"))
+
+func maintenance(c *Connection, s *Server, cmd string) {
+	switch strings.TrimSpace(strings.TrimPrefix(cmd, "MAINTENANCE")) {
+	case "ON":
+		s.SetMaintenance(true)
+	case "OFF":
+		s.SetMaintenance(false)
+	default:
+		c.Error(cmd, fmt.Errorf("Invalid MAINTENANCE %s, expected ON or OFF", cmd))
+		return
+	}
+	c.Ok()
+}
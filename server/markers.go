@@ -0,0 +1,101 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+/*
+ * Markers record deploys and incidents so the Web UI can draw them
+ * on its charts:
+ *
+ * MARK {"kind":"deploy","label":"v1.4.2"}
+ *
+ * Markers are kept as long as the dashboard's longest history.
+ */
+const (
+	markersKey      = "server:markers"
+	MarkerRetention = 180 * 24 * time.Hour
+	MaxMarkerLabel  = 200
+)
+
+var markerKinds = map[string]bool{
+	"deploy":   true,
+	"incident": true,
+	"anomaly":  true,
+}
+
+type Marker struct {
+	At    time.Time `json:"at"`
+	Kind  string    `json:"kind"`
+	Label string    `json:"label"`
+}
+
+func (s *Server) AddMarker(m *Marker) error {
+	if !markerKinds[m.Kind] {
+		return fmt.Errorf("Unknown marker kind %q, expected deploy, incident or anomaly", m.Kind)
+	}
+	if m.Label == "" || len(m.Label) > MaxMarkerLabel {
+		return fmt.Errorf("Marker label must be 1 to %d characters", MaxMarkerLabel)
+	}
+	if m.At.IsZero() {
+		m.At = time.Now()
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-MarkerRetention).Unix()
+	_, err = s.store.Redis().TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(markersKey, redis.Z{Score: float64(m.At.Unix()), Member: data})
+		pipe.ZRemRangeByScore(markersKey, "-inf", "("+strconv.FormatInt(cutoff, 10))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.RecordChange(ChangeMarker, m.Kind, map[string]interface{}{"label": m.Label})
+	return nil
+}
+
+// Markers returns the markers between the given times, oldest first.
+func (s *Server) Markers(since, until time.Time) ([]*Marker, error) {
+	vals, err := s.store.Redis().ZRangeByScore(markersKey, redis.ZRangeBy{
+		Min: strconv.FormatInt(since.Unix(), 10),
+		Max: strconv.FormatInt(until.Unix(), 10),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	markers := make([]*Marker, 0, len(vals))
+	for _, val := range vals {
+		var m Marker
+		err := json.Unmarshal([]byte(val), &m)
+		if err != nil {
+			continue
+		}
+		markers = append(markers, &m)
+	}
+	return markers, nil
+}
+
+func mark(c *Connection, s *Server, cmd string) {
+	var m Marker
+	err := json.Unmarshal([]byte(cmd[4:]), &m)
+	if err != nil {
+		c.Error(cmd, fmt.Errorf("Invalid MARK %s", cmd[4:]))
+		return
+	}
+	err = s.AddMarker(&m)
+	if err != nil {
+		c.Error(cmd, err)
+		return
+	}
+	c.Ok()
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarkers(t *testing.T) {
+	s, _, stop := newTestServer(t, nil)
+	defer stop()
+	now := time.Now()
+
+	assert.Error(t, s.AddMarker(&Marker{Kind: "release", Label: "v1.2"}))
+	assert.Error(t, s.AddMarker(&Marker{Kind: "deploy"}))
+
+	assert.NoError(t, s.AddMarker(&Marker{Kind: "deploy", Label: "v1.2", At: now.Add(-2 * time.Hour)}))
+	assert.NoError(t, s.AddMarker(&Marker{Kind: "incident", Label: "DB failover"}))
+	// expired immediately
+	assert.NoError(t, s.AddMarker(&Marker{Kind: "deploy", Label: "v0.1", At: now.Add(-MarkerRetention - time.Hour)}))
+
+	markers, err := s.Markers(now.Add(-24*time.Hour), now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(markers))
+	assert.Equal(t, "v1.2", markers[0].Label)
+	assert.Equal(t, "incident", markers[1].Kind)
+
+	markers, err = s.Markers(now.Add(-1*time.Hour), now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(markers))
+
+	markers, err = s.Markers(now.Add(-365*24*time.Hour), now)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(markers))
+}
@@ -0,0 +1,368 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/manager"
+	"github.com/contribsys/faktory/storage"
+	"github.com/contribsys/faktory/util"
+	"github.com/go-redis/redis"
+)
+
+/*
+ * Queue metrics count the jobs pushed, fetched, processed and failed
+ * in each queue per minute, along with the time jobs waited in the
+ * queue and its depth, for the queue dashboards in the Web UI.  Jobs
+ * may also be counted per label for cost attribution, see usage.go.
+ * Buckets expire after the retention period:
+ *
+ * [metrics]
+ * retention = 24     # hours
+ */
+type queueMetrics struct {
+	rclient   *redis.Client
+	store     storage.Store
+	server    *Server
+	retention time.Duration
+	now       func() time.Time
+	anomalies *anomalyDetector
+
+	mu     sync.Mutex
+	labels usageLabels
+}
+
+const (
+	metricPushed    = "pushed"
+	metricFetched   = "fetched"
+	metricProcessed = "processed"
+	metricFailed    = "failed"
+	metricLatency   = "latency"
+	metricDepth     = "depth"
+	// prefix of the per-jobtype push counters
+	metricType = "type:"
+)
+
+func MetricsSubsystem() Subsystem {
+	return &queueMetrics{now: time.Now, anomalies: newAnomalyDetector()}
+}
+
+func (m *queueMetrics) Start(s *Server) error {
+	m.rclient = s.Manager().Redis()
+	m.store = s.Store()
+	m.server = s
+	m.configure(s)
+
+	s.Manager().AddMiddleware("push", m.middleware(m.pushed))
+	s.Manager().AddMiddleware("fetch", m.middleware(m.fetched))
+	s.Manager().AddMiddleware("ack", m.middleware(m.counter(metricProcessed)))
+	s.Manager().AddMiddleware("fail", m.middleware(m.counter(metricFailed)))
+	// sample twice a minute so no bucket is missed
+	s.taskRunner.AddTask(30, m)
+	return nil
+}
+
+func (m *queueMetrics) Reload(s *Server) error {
+	m.configure(s)
+	return nil
+}
+
+func (m *queueMetrics) configure(s *Server) {
+	m.retention = time.Duration(s.Options.Int("metrics", "retention", 24)) * time.Hour
+	m.anomalies.configure(s)
+	m.configureLabels(s)
+}
+
+func metricsKey(queue string, minute int64) string {
+	return fmt.Sprintf("metrics:%s:%d", queue, minute)
+}
+
+func (m *queueMetrics) minute() int64 {
+	return m.now().Unix() / 60
+}
+
+// incr adds the given values to the queue's current bucket
+func (m *queueMetrics) incr(queue string, values map[string]int64) {
+	key := metricsKey(queue, m.minute())
+	_, err := m.rclient.Pipelined(func(pipe redis.Pipeliner) error {
+		for field, val := range values {
+			pipe.HIncrBy(key, field, val)
+		}
+		pipe.Expire(key, m.retention)
+		return nil
+	})
+	if err != nil {
+		util.Warnf("Unable to record metrics for %s: %v", queue, err)
+	}
+}
+
+func (m *queueMetrics) pushed(job *client.Job) {
+	// retries are counted as failures, not as new jobs
+	if job.Failure != nil {
+		return
+	}
+	values := map[string]int64{
+		metricPushed:          1,
+		metricType + job.Type: 1,
+	}
+	labels := m.jobLabels(job)
+	for label, value := range labels {
+		values[metricLabel+label+"="+value] = 1
+	}
+	m.incr(job.Queue, values)
+	m.rollup(job, metricPushed, labels)
+}
+
+func (m *queueMetrics) fetched(job *client.Job) {
+	values := map[string]int64{metricFetched: 1}
+	if enqueued, err := util.ParseTime(job.EnqueuedAt); err == nil {
+		wait := m.now().Sub(enqueued)
+		if wait > 0 {
+			values[metricLatency] = int64(wait / time.Millisecond)
+		}
+	}
+	m.incr(job.Queue, values)
+}
+
+func (m *queueMetrics) counter(field string) func(*client.Job) {
+	return func(job *client.Job) {
+		m.incr(job.Queue, map[string]int64{field: 1})
+		m.rollup(job, field, m.jobLabels(job))
+	}
+}
+
+// middleware records the metric once the operation has succeeded
+func (m *queueMetrics) middleware(record func(*client.Job)) manager.MiddlewareFunc {
+	return func(next func() error, ctx manager.Context) error {
+		err := next()
+		if err == nil {
+			record(ctx.Job())
+		}
+		return err
+	}
+}
+
+func (m *queueMetrics) Name() string {
+	return "Metrics"
+}
+
+// Execute samples the depth of every queue and checks
+// the last minute for anomalies
+func (m *queueMetrics) Execute() error {
+	minute := m.minute()
+	_, err := m.rclient.Pipelined(func(pipe redis.Pipeliner) error {
+		m.store.EachQueue(func(q storage.Queue) {
+			key := metricsKey(q.Name(), minute)
+			pipe.HSet(key, metricDepth, q.Size())
+			pipe.Expire(key, m.retention)
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return m.checkAnomalies()
+}
+
+func (m *queueMetrics) Stats() map[string]interface{} {
+	return map[string]interface{}{
+		"retention": int64(m.retention / time.Second),
+	}
+}
+
+// MetricsPoint aggregates a queue's metrics over one step.
+type MetricsPoint struct {
+	At        time.Time
+	Depth     int64
+	Pushed    int64
+	Processed int64
+	Failed    int64
+	// average time jobs waited in the queue
+	Latency time.Duration
+}
+
+// ErrorRate is the percentage of executions which failed.
+func (p *MetricsPoint) ErrorRate() float64 {
+	total := p.Processed + p.Failed
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(p.Failed) / float64(total)
+}
+
+type JobTypeCount struct {
+	Type  string
+	Count int64
+}
+
+type QueueDashboard struct {
+	Queue  string
+	Step   time.Duration
+	Points []*MetricsPoint
+	// the job types pushed most often, busiest first
+	JobTypes []*JobTypeCount
+	// the label values pushed most often, see usage.go
+	Labels []*LabelCount
+	Totals *MetricsPoint
+	// deploys and incidents during the period
+	Markers []*Marker
+}
+
+// dashboard reads the buckets of the last period, aggregated
+// into at most 60 points.
+func (m *queueMetrics) dashboard(queue string, period time.Duration) (*QueueDashboard, error) {
+	minutes := int64(period / time.Minute)
+	if minutes < 1 {
+		minutes = 1
+	}
+	step := minutes / 60
+	if step < 1 {
+		step = 1
+	}
+
+	last := m.minute()
+	first := last - minutes + 1
+	cmds := make([]*redis.StringStringMapCmd, minutes)
+	_, err := m.rclient.Pipelined(func(pipe redis.Pipeliner) error {
+		for idx := range cmds {
+			cmds[idx] = pipe.HGetAll(metricsKey(queue, first+int64(idx)))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	dash := &QueueDashboard{
+		Queue:  queue,
+		Step:   time.Duration(step) * time.Minute,
+		Totals: &MetricsPoint{At: time.Unix(first*60, 0)},
+	}
+	types := map[string]int64{}
+	labels := map[string]int64{}
+	var point *MetricsPoint
+	var latency, fetched, totalLatency, totalFetched int64
+	for idx, cmd := range cmds {
+		if int64(idx)%step == 0 {
+			if point != nil {
+				point.Latency = average(latency, fetched)
+			}
+			point = &MetricsPoint{At: time.Unix((first+int64(idx))*60, 0)}
+			dash.Points = append(dash.Points, point)
+			latency, fetched = 0, 0
+		}
+
+		for field, str := range cmd.Val() {
+			val, err := strconv.ParseInt(str, 10, 64)
+			if err != nil {
+				continue
+			}
+			switch field {
+			case metricDepth:
+				if val > point.Depth {
+					point.Depth = val
+				}
+				dash.Totals.Depth = val
+			case metricPushed:
+				point.Pushed += val
+				dash.Totals.Pushed += val
+			case metricProcessed:
+				point.Processed += val
+				dash.Totals.Processed += val
+			case metricFailed:
+				point.Failed += val
+				dash.Totals.Failed += val
+			case metricFetched:
+				fetched += val
+				totalFetched += val
+			case metricLatency:
+				latency += val
+				totalLatency += val
+			default:
+				if strings.HasPrefix(field, metricType) {
+					types[field[len(metricType):]] += val
+				} else if strings.HasPrefix(field, metricLabel) {
+					labels[field[len(metricLabel):]] += val
+				}
+			}
+		}
+	}
+	if point != nil {
+		point.Latency = average(latency, fetched)
+	}
+	dash.Totals.Latency = average(totalLatency, totalFetched)
+
+	for jobtype, count := range types {
+		dash.JobTypes = append(dash.JobTypes, &JobTypeCount{jobtype, count})
+	}
+	sort.Slice(dash.JobTypes, func(i, j int) bool {
+		a, b := dash.JobTypes[i], dash.JobTypes[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Type < b.Type
+	})
+	if len(dash.JobTypes) > 10 {
+		dash.JobTypes = dash.JobTypes[:10]
+	}
+
+	for pair, count := range labels {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) == 2 {
+			dash.Labels = append(dash.Labels, &LabelCount{parts[0], parts[1], count})
+		}
+	}
+	sort.Slice(dash.Labels, func(i, j int) bool {
+		a, b := dash.Labels[i], dash.Labels[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		if a.Label != b.Label {
+			return a.Label < b.Label
+		}
+		return a.Value < b.Value
+	})
+	if len(dash.Labels) > 10 {
+		dash.Labels = dash.Labels[:10]
+	}
+	return dash, nil
+}
+
+func average(totalMillis, count int64) time.Duration {
+	if count == 0 {
+		return 0
+	}
+	return time.Duration(totalMillis/count) * time.Millisecond
+}
+
+func (s *Server) metrics() *queueMetrics {
+	for _, x := range s.Subsystems {
+		if m, ok := x.(*queueMetrics); ok {
+			return m
+		}
+	}
+	return nil
+}
+
+// QueueDashboard returns the metrics for the queue over the last
+// period or nil if the metrics subsystem isn't running.
+func (s *Server) QueueDashboard(queue string, period time.Duration) (*QueueDashboard, error) {
+	m := s.metrics()
+	if m == nil {
+		return nil, nil
+	}
+	dash, err := m.dashboard(queue, period)
+	if err != nil {
+		return nil, err
+	}
+	dash.Markers, err = s.Markers(dash.Points[0].At, m.now())
+	if err != nil {
+		return nil, err
+	}
+	return dash, nil
+}
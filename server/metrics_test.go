@@ -0,0 +1,90 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueueMetrics(t *testing.T) {
+	booted, _, stop := newTestServer(t, nil)
+	defer stop()
+
+	now := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	m := &queueMetrics{
+		rclient:   booted.store.Redis(),
+		store:     booted.store,
+		retention: 1 * time.Hour,
+		now:       func() time.Time { return now },
+		anomalies: newAnomalyDetector(),
+	}
+
+	q, err := booted.store.GetQueue("metrics")
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		job := client.NewJob("Report", i)
+		job.Queue = "metrics"
+		job.EnqueuedAt = util.Thens(now.Add(-2 * time.Second))
+		m.pushed(job)
+		assert.NoError(t, q.Push([]byte(job.Jid)))
+	}
+	retried := client.NewJob("Report", 9)
+	retried.Queue = "metrics"
+	retried.Failure = &client.Failure{RetryCount: 1}
+	m.pushed(retried)
+
+	email := client.NewJob("Email")
+	email.Queue = "metrics"
+	m.pushed(email)
+	assert.NoError(t, m.Execute())
+
+	// a minute later
+	now = now.Add(1 * time.Minute)
+	job := client.NewJob("Report", 1)
+	job.Queue = "metrics"
+	job.EnqueuedAt = util.Thens(now.Add(-4 * time.Second))
+	m.fetched(job)
+	job.EnqueuedAt = util.Thens(now.Add(-2 * time.Second))
+	m.fetched(job)
+	m.counter(metricProcessed)(job)
+	m.counter(metricFailed)(job)
+	q.Pop()
+	assert.NoError(t, m.Execute())
+
+	dash, err := m.dashboard("metrics", 1*time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, 60, len(dash.Points))
+	assert.Equal(t, 1*time.Minute, dash.Step)
+
+	prev := dash.Points[58]
+	assert.EqualValues(t, 4, prev.Pushed)
+	assert.EqualValues(t, 3, prev.Depth)
+	last := dash.Points[59]
+	assert.Equal(t, now.Truncate(time.Minute).Unix(), last.At.Unix())
+	assert.EqualValues(t, 2, last.Depth)
+	assert.EqualValues(t, 1, last.Processed)
+	assert.EqualValues(t, 1, last.Failed)
+	assert.EqualValues(t, 50, last.ErrorRate())
+	assert.Equal(t, 3*time.Second, last.Latency)
+
+	assert.EqualValues(t, 4, dash.Totals.Pushed)
+	assert.EqualValues(t, 2, dash.Totals.Depth)
+	assert.Equal(t, 2, len(dash.JobTypes))
+	assert.Equal(t, "Report", dash.JobTypes[0].Type)
+	assert.EqualValues(t, 3, dash.JobTypes[0].Count)
+
+	// 24 hours are aggregated into 60 steps
+	dash, err = m.dashboard("metrics", 24*time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, 60, len(dash.Points))
+	assert.Equal(t, 24*time.Minute, dash.Step)
+	assert.EqualValues(t, 4, dash.Totals.Pushed)
+
+	s := &Server{}
+	assert.Nil(t, s.metrics())
+	s.Register(MetricsSubsystem())
+	assert.NotNil(t, s.metrics())
+}
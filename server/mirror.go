@@ -0,0 +1,221 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/manager"
+	"github.com/contribsys/faktory/util"
+)
+
+/*
+ * Mirroring forwards a copy of every job pushed to this server
+ * to a secondary Faktory server, e.g. to feed a staging environment
+ * with production traffic.
+ *
+ * [mirror]
+ * url = "tcp://:password@staging.example.com:7419"
+ * queues = ["default", "critical"]    # optional, all queues otherwise
+ * buffer = 10000                      # jobs held while the secondary is slow
+ *
+ * Forwarding is asynchronous and best effort: if the secondary is
+ * down or can't keep up, jobs are dropped once the buffer is full
+ * rather than slowing down this server.  Retries are not mirrored,
+ * the secondary retries its own failures.
+ */
+type mirror struct {
+	mu       sync.RWMutex
+	location string
+	srv      *client.Server
+	queues   map[string]bool
+
+	jobs    chan []byte
+	sent    int64
+	dropped int64
+}
+
+func MirrorSubsystem() Subsystem {
+	return &mirror{}
+}
+
+// External sends jobs outside the server
+func (m *mirror) External() {}
+
+func (m *mirror) Start(s *Server) error {
+	err := m.configure(s)
+	if err != nil {
+		return err
+	}
+
+	m.jobs = make(chan []byte, s.Options.Int("mirror", "buffer", 10000))
+	s.Manager().AddMiddleware("push", m.push)
+	s.taskRunner.AddTask(60, m)
+	s.Go(m.run)
+	return nil
+}
+
+func (m *mirror) Name() string {
+	return "Mirror"
+}
+
+// Execute is a no-op, the mirror is registered as a
+// task so its stats are included in INFO.
+func (m *mirror) Execute() error {
+	return nil
+}
+
+func (m *mirror) Stats() map[string]interface{} {
+	return map[string]interface{}{
+		"size":    len(m.jobs),
+		"sent":    atomic.LoadInt64(&m.sent),
+		"dropped": atomic.LoadInt64(&m.dropped),
+	}
+}
+
+func (m *mirror) Reload(s *Server) error {
+	return m.configure(s)
+}
+
+func (m *mirror) configure(s *Server) error {
+	location := s.Options.String("mirror", "url", "")
+
+	var queues map[string]bool
+	if names, ok := s.Options.Config("mirror", "queues", nil).([]interface{}); ok {
+		queues = map[string]bool{}
+		for _, name := range names {
+			queues[fmt.Sprintf("%v", name)] = true
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queues = queues
+	if location == m.location {
+		return nil
+	}
+
+	var srv *client.Server
+	if location != "" {
+		var err error
+		srv, err = remoteServer(location)
+		if err != nil {
+			return err
+		}
+	}
+
+	if srv != nil {
+		util.Infof("Mirroring jobs to %s", srv.Address)
+	}
+	m.location = location
+	m.srv = srv
+	return nil
+}
+
+// remoteServer parses a URL like "tcp://:password@host:7419"
+func remoteServer(location string) (*client.Server, error) {
+	uri, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	srv := client.DefaultServer()
+	srv.Network = uri.Scheme
+	srv.Address = uri.Host
+	if uri.User != nil {
+		srv.Password, _ = uri.User.Password()
+	}
+	return srv, nil
+}
+
+func (m *mirror) settings() (*client.Server, map[string]bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.srv, m.queues
+}
+
+func (m *mirror) push(next func() error, ctx manager.Context) error {
+	err := next()
+	if err != nil {
+		return err
+	}
+
+	srv, queues := m.settings()
+	job := ctx.Job()
+	if srv == nil || job.Failure != nil {
+		return nil
+	}
+	if queues != nil && !queues[job.Queue] {
+		return nil
+	}
+
+	data, err := json.Marshal(job)
+	if err != nil {
+		return nil
+	}
+	select {
+	case m.jobs <- data:
+	default:
+		if atomic.AddInt64(&m.dropped, 1)%1000 == 1 {
+			util.Warnf("Mirror buffer full, %d jobs dropped", atomic.LoadInt64(&m.dropped))
+		}
+	}
+	return nil
+}
+
+func (m *mirror) run(ctx context.Context) {
+	var cl *client.Client
+	var connected *client.Server
+
+	for {
+		var data []byte
+		select {
+		case <-ctx.Done():
+			if cl != nil {
+				cl.Close()
+			}
+			return
+		case data = <-m.jobs:
+		}
+
+		srv, _ := m.settings()
+		if srv == nil {
+			continue
+		}
+		if cl != nil && connected != srv {
+			// reloaded with a different secondary
+			cl.Close()
+			cl = nil
+		}
+		if cl == nil {
+			c, err := srv.Open()
+			if err != nil {
+				util.Warnf("Unable to connect to mirror %s: %v", srv.Address, err)
+				atomic.AddInt64(&m.dropped, 1)
+				time.Sleep(1 * time.Second)
+				continue
+			}
+			cl = c
+			connected = srv
+		}
+
+		var job client.Job
+		err := json.Unmarshal(data, &job)
+		if err != nil {
+			continue
+		}
+		err = cl.Push(&job)
+		if err != nil {
+			util.ForJob(job.Jid, job.Queue).Warnf("Unable to mirror %s: %v", job.Jid, err)
+			atomic.AddInt64(&m.dropped, 1)
+			cl.Close()
+			cl = nil
+			continue
+		}
+		atomic.AddInt64(&m.sent, 1)
+	}
+}
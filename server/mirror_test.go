@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/manager"
+	"github.com/stretchr/testify/assert"
+)
+
+type testContext struct {
+	context.Context
+	job *client.Job
+}
+
+func (tc testContext) Job() *client.Job {
+	return tc.job
+}
+
+func (tc testContext) Manager() manager.Manager {
+	return nil
+}
+
+func TestMirror(t *testing.T) {
+	s := &Server{Options: &ServerOptions{GlobalConfig: map[string]interface{}{
+		"mirror": map[string]interface{}{
+			"url":    "tcp://:secret@staging.example.com:7419",
+			"queues": []interface{}{"default"},
+		},
+	}}}
+
+	m := &mirror{jobs: make(chan []byte, 2)}
+	err := m.configure(s)
+	assert.NoError(t, err)
+	srv, queues := m.settings()
+	assert.Equal(t, "staging.example.com:7419", srv.Address)
+	assert.Equal(t, "secret", srv.Password)
+	assert.True(t, queues["default"])
+
+	next := func() error { return nil }
+	push := func(job *client.Job) error {
+		return m.push(next, testContext{context.Background(), job})
+	}
+
+	assert.NoError(t, push(client.NewJob("Mirrored", 1)))
+	assert.Equal(t, 1, len(m.jobs))
+
+	other := client.NewJob("Filtered", 1)
+	other.Queue = "other"
+	assert.NoError(t, push(other))
+	assert.Equal(t, 1, len(m.jobs))
+
+	retry := client.NewJob("Retry", 1)
+	retry.Failure = &client.Failure{RetryCount: 1}
+	assert.NoError(t, push(retry))
+	assert.Equal(t, 1, len(m.jobs))
+
+	// buffer full, jobs are dropped rather than blocking
+	assert.NoError(t, push(client.NewJob("Mirrored", 2)))
+	assert.NoError(t, push(client.NewJob("Mirrored", 3)))
+	assert.Equal(t, 2, len(m.jobs))
+	assert.EqualValues(t, 1, m.dropped)
+
+	s.Options.GlobalConfig = map[string]interface{}{}
+	err = m.configure(s)
+	assert.NoError(t, err)
+	srv, queues = m.settings()
+	assert.Nil(t, srv)
+	assert.Nil(t, queues)
+	assert.NoError(t, push(client.NewJob("Unmirrored", 1)))
+	assert.EqualValues(t, 1, m.dropped)
+}
@@ -0,0 +1,247 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/manager"
+	"github.com/contribsys/faktory/storage"
+)
+
+/*
+ * MUTATE changes the jobs in the retries, scheduled or dead set in
+ * bulk, so operators can script what they'd otherwise click through
+ * in the Web UI:
+ *
+ *   MUTATE {"cmd":"kill","target":"retries","filter":{"jobtype":"SyncJob","pattern":"*\"uid\":1234*"}}
+ *
+ * The commands are:
+ *
+ *   clear    remove every job in the set, a filter isn't allowed
+ *   discard  remove the matching jobs
+ *   kill     move the matching jobs to the dead set
+ *   requeue  push the matching jobs to their queues now
+ *
+ * A job matches the filter if it has one of the jids, the jobtype and
+ * its JSON payload matches the pattern, a glob where * matches any
+ * text, ? any character and [abc] any of the characters.  Omitted
+ * criteria match every job.  The response is the number of jobs
+ * changed.
+ */
+
+// jobs read from the set at a time
+const mutateScanSize = 500
+
+// jobMatcher tests the jobs of a set against a filter
+type jobMatcher struct {
+	jids    map[string]bool
+	jobtype string
+	pattern *regexp.Regexp
+}
+
+func newJobMatcher(filter *client.JobFilter) (*jobMatcher, error) {
+	jm := &jobMatcher{}
+	if filter == nil {
+		return jm, nil
+	}
+	if len(filter.Jids) > 0 {
+		jm.jids = map[string]bool{}
+		for _, jid := range filter.Jids {
+			jm.jids[jid] = true
+		}
+	}
+	jm.jobtype = filter.Type
+	if filter.Pattern != "" {
+		rx, err := globRegexp(filter.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid pattern %q: %v", filter.Pattern, err)
+		}
+		jm.pattern = rx
+	}
+	return jm, nil
+}
+
+func (jm *jobMatcher) match(entry storage.SortedEntry) (bool, error) {
+	if jm.pattern != nil && !jm.pattern.Match(entry.Value()) {
+		return false, nil
+	}
+	if jm.jids == nil && jm.jobtype == "" {
+		return true, nil
+	}
+	job, err := entry.Job()
+	if err != nil {
+		return false, err
+	}
+	if jm.jids != nil && !jm.jids[job.Jid] {
+		return false, nil
+	}
+	return jm.jobtype == "" || jm.jobtype == job.Type, nil
+}
+
+// globRegexp compiles a glob matching the entire text
+func globRegexp(glob string) (*regexp.Regexp, error) {
+	var buf bytes.Buffer
+	buf.WriteString(`(?s)^`)
+	for i := 0; i < len(glob); i++ {
+		switch chr := glob[i]; chr {
+		case '*':
+			buf.WriteString(".*")
+		case '?':
+			buf.WriteString(".")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 1 {
+				return nil, fmt.Errorf("unterminated [")
+			}
+			class := glob[i+1 : i+1+end]
+			if class[0] == '!' {
+				class = "^" + class[1:]
+			}
+			buf.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+			}
+			buf.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(chr)))
+		}
+	}
+	buf.WriteString(`$`)
+	return regexp.Compile(buf.String())
+}
+
+func (s *Server) mutateTarget(name string) (storage.SortedSet, error) {
+	switch name {
+	case "retries":
+		return s.store.Retries(), nil
+	case "scheduled":
+		return s.store.Scheduled(), nil
+	case "dead":
+		return s.store.Dead(), nil
+	default:
+		return nil, fmt.Errorf("Unknown target %s", name)
+	}
+}
+
+// matching returns the keys of the set's jobs which match, every
+// match is found before any are changed so the cursor stays valid
+func matching(set storage.SortedSet, jm *jobMatcher) ([][]byte, error) {
+	keys := [][]byte{}
+	cursor := ""
+	for {
+		next, err := set.Cursor(cursor, mutateScanSize, func(_ int, entry storage.SortedEntry) error {
+			ok, err := jm.match(entry)
+			if err != nil || !ok {
+				return err
+			}
+			key, err := entry.Key()
+			if err != nil {
+				return err
+			}
+			keys = append(keys, key)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if next == "" {
+			return keys, nil
+		}
+		cursor = next
+	}
+}
+
+// Mutate applies the mutation to its target set, returning
+// the number of jobs changed.
+func (s *Server) Mutate(op *client.Mutation) (int, error) {
+	set, err := s.mutateTarget(op.Target)
+	if err != nil {
+		return 0, err
+	}
+
+	if op.Cmd == client.MutateClear {
+		if op.Filter != nil {
+			return 0, fmt.Errorf("clear removes every job, use discard with a filter")
+		}
+		count := int(set.Size())
+		err := set.Clear()
+		if err != nil {
+			return 0, err
+		}
+		s.RecordChange(ChangeJobsDeleted, set.Name(), map[string]interface{}{"all": true, "count": count})
+		return count, nil
+	}
+
+	var apply func(key []byte) (bool, error)
+	var kind string
+	switch op.Cmd {
+	case client.MutateDiscard:
+		kind = ChangeJobsDeleted
+		apply = set.Remove
+	case client.MutateKill:
+		if op.Target == "dead" {
+			return 0, fmt.Errorf("Dead jobs can't be killed, use discard")
+		}
+		kind = ChangeJobsKilled
+		expiry := time.Now().Add(manager.DeadTTL)
+		apply = func(key []byte) (bool, error) {
+			entry, err := set.Get(key)
+			if err != nil || entry == nil {
+				return false, err
+			}
+			return true, set.MoveTo(s.store.Dead(), entry, expiry)
+		}
+	case client.MutateRequeue:
+		kind = ChangeJobsRetried
+		apply = func(key []byte) (bool, error) {
+			return true, s.store.EnqueueFrom(set, key)
+		}
+	default:
+		return 0, fmt.Errorf("Unknown command %s", op.Cmd)
+	}
+
+	jm, err := newJobMatcher(op.Filter)
+	if err != nil {
+		return 0, err
+	}
+	keys, err := matching(set, jm)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, key := range keys {
+		ok, err := apply(key)
+		if err != nil {
+			return count, err
+		}
+		if ok {
+			count++
+		}
+	}
+	s.RecordChange(kind, set.Name(), map[string]interface{}{"count": count})
+	return count, nil
+}
+
+func mutate(c *Connection, s *Server, cmd string) {
+	data := cmd[6:]
+
+	var op client.Mutation
+	err := json.Unmarshal([]byte(data), &op)
+	if err != nil {
+		c.Error(cmd, newTaggedError("MALFORMED", fmt.Errorf("Invalid MUTATE %s", data)))
+		return
+	}
+	count, err := s.Mutate(&op)
+	if err != nil {
+		c.Error(cmd, err)
+		return
+	}
+	c.Number(count)
+}
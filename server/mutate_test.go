@@ -0,0 +1,78 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGlobRegexp(t *testing.T) {
+	for glob, cases := range map[string]map[string]bool{
+		`*"uid":12*`: {`{"args":[{"uid":12}]}`: true, `{"args":[{"uid":123}]}`: true, `{"args":[{"uid":2}]}`: false},
+		`Sync?`:      {"SyncA": true, "Sync": false, "SyncAB": false},
+		`[!a-c]x`:    {"dx": true, "ax": false},
+		`a\*`:        {"a*": true, "ab": false},
+		`(.)`:        {"(.)": true, "(a)": false},
+	} {
+		rx, err := globRegexp(glob)
+		assert.NoError(t, err)
+		for text, match := range cases {
+			assert.Equal(t, match, rx.MatchString(text), "%s %s", glob, text)
+		}
+	}
+	_, err := globRegexp("[abc")
+	assert.Error(t, err)
+}
+
+func TestMutate(t *testing.T) {
+	s, _, stop := newTestServer(t, nil)
+	defer stop()
+
+	jobs := []*client.Job{}
+	for idx, jobtype := range []string{"SyncJob", "SyncJob", "SyncJob", "Email"} {
+		job := client.NewJob(jobtype, map[string]interface{}{"uid": idx})
+		job.Queue = "mutate"
+		job.At = util.Nows()
+		assert.NoError(t, s.store.Retries().Add(job))
+		jobs = append(jobs, job)
+	}
+
+	_, err := s.Mutate(&client.Mutation{Cmd: "kill", Target: "working"})
+	assert.EqualError(t, err, "Unknown target working")
+	_, err = s.Mutate(&client.Mutation{Cmd: "explode", Target: "retries"})
+	assert.EqualError(t, err, "Unknown command explode")
+	_, err = s.Mutate(&client.Mutation{Cmd: "kill", Target: "dead"})
+	assert.Error(t, err)
+	_, err = s.Mutate(&client.Mutation{Cmd: "clear", Target: "retries", Filter: &client.JobFilter{Type: "SyncJob"}})
+	assert.Error(t, err)
+	assert.EqualValues(t, 4, s.store.Retries().Size())
+
+	count, err := s.Mutate(&client.Mutation{Cmd: "kill", Target: "retries",
+		Filter: &client.JobFilter{Type: "SyncJob", Pattern: `*"uid":[01]*`}})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.EqualValues(t, 2, s.store.Retries().Size())
+	assert.EqualValues(t, 2, s.store.Dead().Size())
+
+	count, err = s.Mutate(&client.Mutation{Cmd: "requeue", Target: "dead",
+		Filter: &client.JobFilter{Jids: []string{jobs[1].Jid, jobs[3].Jid}}})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	q, err := s.store.GetQueue("mutate")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, q.Size())
+	assert.EqualValues(t, 1, s.store.Dead().Size())
+
+	count, err = s.Mutate(&client.Mutation{Cmd: "discard", Target: "retries",
+		Filter: &client.JobFilter{Type: "Email"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.EqualValues(t, 1, s.store.Retries().Size())
+
+	count, err = s.Mutate(&client.Mutation{Cmd: "clear", Target: "dead"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.EqualValues(t, 0, s.store.Dead().Size())
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/json"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/manager"
+	"github.com/contribsys/faktory/util"
+	"github.com/go-redis/redis"
+)
+
+/*
+ * Jobs pushed with the "next_boot" custom attribute are held until the
+ * server next starts, e.g. cache warmers or migrations for the release
+ * being deployed.  When it starts they are enqueued in the order they
+ * were pushed, ahead of every other job in their queues, before any
+ * worker can fetch.  NextBootSubsystem must be registered after the
+ * subsystems whose push middleware should apply to held jobs.
+ */
+type nextBoot struct {
+	rclient *redis.Client
+}
+
+const nextBootKey = "next-boot"
+
+func NextBootSubsystem() Subsystem {
+	return &nextBoot{}
+}
+
+func (nb *nextBoot) Start(s *Server) error {
+	nb.rclient = s.Manager().Redis()
+	nb.enqueue(s)
+
+	s.Manager().AddMiddleware("push", nb.push)
+	s.Manager().AddMiddleware("schedule", nb.push)
+	return nil
+}
+
+func (nb *nextBoot) Reload(s *Server) error {
+	return nil
+}
+
+func heldForBoot(job *client.Job) bool {
+	val, ok := job.GetCustom(client.NextBootAttribute)
+	if !ok {
+		return false
+	}
+	held, _ := val.(bool)
+	return held
+}
+
+// push holds the job rather than enqueueing it, the middleware
+// registered before this one has run so the job is stored as
+// it would be in its queue, e.g. encrypted
+func (nb *nextBoot) push(next func() error, ctx manager.Context) error {
+	job := ctx.Job()
+	if !heldForBoot(job) {
+		return next()
+	}
+	return nb.hold(job)
+}
+
+// hold stores the job until the next boot
+func (nb *nextBoot) hold(job *client.Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return nb.rclient.RPush(nextBootKey, data).Err()
+}
+
+// enqueue pushes the held jobs to the front of their queues, the
+// latest first so the earliest is dispatched first.  Jobs which can't
+// be enqueued stay held for the next boot.
+func (nb *nextBoot) enqueue(s *Server) {
+	count := 0
+	for {
+		data, err := nb.rclient.RPop(nextBootKey).Bytes()
+		if err == redis.Nil {
+			break
+		}
+		if err != nil {
+			util.Warnf("Unable to enqueue jobs held for boot: %v", err)
+			return
+		}
+
+		var job client.Job
+		err = json.Unmarshal(data, &job)
+		if err != nil {
+			util.Warnf("Dropping unreadable job held for boot: %v", err)
+			continue
+		}
+		delete(job.Custom, client.NextBootAttribute)
+		err = s.Manager().PushFirst(&job)
+		if err != nil {
+			util.ForJob(job.Jid, job.Queue).Warnf("Unable to enqueue %s held for boot: %v", job.Jid, err)
+			nb.rclient.RPush(nextBootKey, data)
+			return
+		}
+		count++
+	}
+	if count > 0 {
+		util.Infof("Enqueued %d job(s) held for boot", count)
+	}
+}
+
+func (s *Server) nextBoot() *nextBoot {
+	for _, x := range s.Subsystems {
+		if nb, ok := x.(*nextBoot); ok {
+			return nb
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextBoot(t *testing.T) {
+	s, _, stop := newTestServer(t, nil)
+	defer stop()
+	s.Register(NextBootSubsystem())
+	nb := s.nextBoot()
+	assert.NoError(t, nb.Start(s))
+
+	waiting := client.NewJob("Report", 1)
+	assert.NoError(t, s.manager.Push(waiting))
+
+	warm := client.NewJob("WarmCache", 1)
+	warm.AtNextBoot()
+	migrate := client.NewJob("Migrate", 2)
+	migrate.AtNextBoot()
+	assert.True(t, heldForBoot(warm))
+	assert.False(t, heldForBoot(waiting))
+	assert.NoError(t, s.manager.Push(warm))
+	assert.NoError(t, s.manager.Push(migrate))
+	assert.Error(t, s.manager.Push(client.NewJob("")))
+
+	q, err := s.store.GetQueue("default")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, q.Size())
+	assert.EqualValues(t, 2, s.store.Redis().LLen(nextBootKey).Val())
+
+	// the next boot
+	nb.enqueue(s)
+	assert.EqualValues(t, 3, q.Size())
+	assert.EqualValues(t, 0, s.store.Redis().LLen(nextBootKey).Val())
+
+	for _, expected := range []*client.Job{warm, migrate, waiting} {
+		data, err := q.Pop()
+		assert.NoError(t, err)
+		var job client.Job
+		assert.NoError(t, json.Unmarshal(data, &job))
+		assert.Equal(t, expected.Jid, job.Jid)
+		assert.False(t, heldForBoot(&job))
+	}
+}
+
+func TestNextBootEncrypted(t *testing.T) {
+	s, _, stop := newTestServer(t, encryptionOptions("k1", map[string]interface{}{"k1": testKey('a')}))
+	defer stop()
+	s.Register(EncryptionSubsystem())
+	s.Register(NextBootSubsystem())
+	e := s.encryptor()
+	assert.NoError(t, e.Start(s))
+	nb := s.nextBoot()
+	assert.NoError(t, nb.Start(s))
+
+	job := client.NewJob("Charge", "4111 1111 1111 1111", 42)
+	job.Queue = "payments"
+	job.AtNextBoot()
+	assert.NoError(t, s.manager.Push(job))
+
+	held, err := s.store.Redis().LRange(nextBootKey, 0, -1).Result()
+	assert.NoError(t, err)
+	assert.Len(t, held, 1)
+	assert.NotContains(t, held[0], "4111")
+	assert.Contains(t, held[0], EncryptedAttribute)
+
+	nb.enqueue(s)
+	q, err := s.store.GetQueue("payments")
+	assert.NoError(t, err)
+	data, err := q.Pop()
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "4111")
+	var enqueued client.Job
+	assert.NoError(t, json.Unmarshal(data, &enqueued))
+	args, err := e.open(&enqueued)
+	assert.NoError(t, err)
+	assert.Equal(t, "4111 1111 1111 1111", args[0])
+}
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/manager"
+	"github.com/contribsys/faktory/storage"
+	"github.com/contribsys/faktory/util"
+	"github.com/go-redis/redis"
+)
+
+/*
+ * Large job payloads are moved out of Redis into a blob store.
+ * The job's args are replaced with an empty array and the blob
+ * key is stored in the "offload" custom attribute.
+ *
+ * [offload]
+ * threshold = 65536                         # bytes, 0 disables
+ * url = "file:///var/lib/faktory/offload"   # or https://...
+ * token = "..."                             # bearer token for https
+ * resolve = true                            # restore args on FETCH
+ *
+ * If resolve is false, workers receive the pointer and are
+ * responsible for fetching the args from the blob store.  Blob keys
+ * are chosen by the server and recorded by JID, an "offload" attribute
+ * sent by a producer is dropped.
+ */
+const OffloadAttribute = "offload"
+
+// JID => key of the job's blob
+const offloadedKey = "offloaded"
+
+type offloader struct {
+	rclient   *redis.Client
+	mu        sync.RWMutex
+	blobs     storage.Blobs
+	threshold int
+	resolve   bool
+	location  string
+}
+
+func OffloadSubsystem() Subsystem {
+	return &offloader{}
+}
+
+// External sends jobs outside the server
+func (o *offloader) External() {}
+
+func (o *offloader) Start(s *Server) error {
+	o.rclient = s.Manager().Redis()
+	err := o.configure(s)
+	if err != nil {
+		return err
+	}
+
+	s.Manager().AddMiddleware("push", o.push)
+	s.Manager().AddMiddleware("schedule", o.push)
+	s.Manager().AddMiddleware("fetch", o.fetch)
+	s.Manager().AddMiddleware("ack", o.ack)
+	s.Manager().AddMiddleware("fail", o.fail)
+	return nil
+}
+
+func (o *offloader) Reload(s *Server) error {
+	return o.configure(s)
+}
+
+func (o *offloader) configure(s *Server) error {
+	threshold := s.Options.Int("offload", "threshold", 0)
+	location := s.Options.String("offload", "url", "")
+	token := s.Options.String("offload", "token", "")
+	resolve := s.Options.Bool("offload", "resolve", true)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if threshold <= 0 || location == "" {
+		o.blobs = nil
+		o.threshold = 0
+		return nil
+	}
+
+	if location != o.location {
+		blobs, err := storage.OpenBlobs(location, token)
+		if err != nil {
+			return err
+		}
+		o.blobs = blobs
+		o.location = location
+		util.Infof("Offloading job payloads over %d bytes to %s", threshold, location)
+	}
+	o.threshold = threshold
+	o.resolve = resolve
+	return nil
+}
+
+func (o *offloader) settings() (storage.Blobs, int, bool) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.blobs, o.threshold, o.resolve
+}
+
+func (o *offloader) push(next func() error, ctx manager.Context) error {
+	job := ctx.Job()
+	if manager.Reenqueued(ctx) {
+		// offloaded when it was pushed, drop the args resolved
+		// when it was fetched
+		if _, ok := offloadKey(job); ok {
+			job.Args = []interface{}{}
+		}
+		return next()
+	}
+
+	delete(job.Custom, OffloadAttribute)
+	blobs, threshold, _ := o.settings()
+	if blobs != nil {
+		err := o.offloadArgs(blobs, threshold, job)
+		if err != nil {
+			return err
+		}
+	}
+	return next()
+}
+
+// fetch resolves the args once the job is reserved, so the
+// working set doesn't hold them
+func (o *offloader) fetch(next func() error, ctx manager.Context) error {
+	err := next()
+	blobs, _, resolve := o.settings()
+	if err == nil && blobs != nil && resolve {
+		rerr := o.resolveArgs(blobs, ctx.Job())
+		if rerr != nil {
+			// the worker still gets the pointer
+			util.ForJob(ctx.Job().Jid, ctx.Job().Queue).Warnf("Unable to resolve offloaded args for %s: %v", ctx.Job().Jid, rerr)
+		}
+	}
+	return err
+}
+
+func (o *offloader) ack(next func() error, ctx manager.Context) error {
+	blobs, _, _ := o.settings()
+	if blobs != nil {
+		job := ctx.Job()
+		key, err := o.blobKey(job)
+		if err == nil && key != "" {
+			err = blobs.Delete(key)
+			if err == nil {
+				err = o.rclient.HDel(offloadedKey, job.Jid).Err()
+			}
+		}
+		if err != nil {
+			util.ForJob(job.Jid, job.Queue).Warnf("Unable to delete offloaded args for %s: %v", job.Jid, err)
+		}
+	}
+	return next()
+}
+
+func (o *offloader) fail(next func() error, ctx manager.Context) error {
+	// the job is going back into Redis, strip the
+	// args we resolved during FETCH
+	if _, ok := offloadKey(ctx.Job()); ok {
+		ctx.Job().Args = []interface{}{}
+	}
+	return next()
+}
+
+func offloadKey(job *client.Job) (string, bool) {
+	val, ok := job.GetCustom(OffloadAttribute)
+	if !ok {
+		return "", false
+	}
+	key, ok := val.(string)
+	return key, ok && key != ""
+}
+
+// blobKey returns the key of the job's blob if the server
+// offloaded it, "" otherwise
+func (o *offloader) blobKey(job *client.Job) (string, error) {
+	key, ok := offloadKey(job)
+	if !ok {
+		return "", nil
+	}
+	recorded, err := o.rclient.HGet(offloadedKey, job.Jid).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if recorded != key {
+		return "", nil
+	}
+	return key, nil
+}
+
+func (o *offloader) offloadArgs(blobs storage.Blobs, threshold int, job *client.Job) error {
+	data, err := json.Marshal(job.Args)
+	if err != nil {
+		return err
+	}
+	if len(data) <= threshold {
+		return nil
+	}
+
+	suffix := make([]byte, 8)
+	_, err = rand.Read(suffix)
+	if err != nil {
+		return err
+	}
+	key := job.Jid + "-" + hex.EncodeToString(suffix)
+	err = blobs.Put(key, data)
+	if err != nil {
+		return err
+	}
+	err = o.rclient.HSet(offloadedKey, job.Jid, key).Err()
+	if err != nil {
+		blobs.Delete(key)
+		return err
+	}
+	job.Args = []interface{}{}
+	job.SetCustom(OffloadAttribute, key)
+	return nil
+}
+
+func (o *offloader) resolveArgs(blobs storage.Blobs, job *client.Job) error {
+	if len(job.Args) > 0 {
+		return nil
+	}
+	key, err := o.blobKey(job)
+	if err != nil || key == "" {
+		return err
+	}
+
+	data, err := blobs.Get(key)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		return fmt.Errorf("No blob found for %s", key)
+	}
+
+	var args []interface{}
+	err = json.Unmarshal(data, &args)
+	if err != nil {
+		return err
+	}
+	job.Args = args
+	return nil
+}
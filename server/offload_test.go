@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOffload(t *testing.T) {
+	dir := "/tmp/faktory-test-offload"
+	defer os.RemoveAll(dir)
+
+	opts := &ServerOptions{GlobalConfig: map[string]interface{}{
+		"offload": map[string]interface{}{"threshold": 100, "url": "file://" + dir},
+	}}
+	s, _, stop := newTestServer(t, opts)
+	defer stop()
+	s.Register(OffloadSubsystem())
+	o := s.Subsystems[0].(*offloader)
+	assert.NoError(t, o.Start(s))
+	blobs, _, _ := o.settings()
+
+	small := client.NewJob("Small", 1, 2, 3)
+	assert.NoError(t, s.manager.Push(small))
+	assert.Equal(t, 3, len(small.Args))
+	_, ok := offloadKey(small)
+	assert.False(t, ok)
+
+	big := strings.Repeat("x", 200)
+	job := client.NewJob("Big", big)
+	assert.NoError(t, s.manager.Push(job))
+	assert.Equal(t, 0, len(job.Args))
+	key, ok := offloadKey(job)
+	assert.True(t, ok)
+	assert.True(t, strings.HasPrefix(key, job.Jid+"-"))
+
+	// producers can't point a job at another job's blob
+	forged := client.NewJob("Forged", 1)
+	forged.SetCustom(OffloadAttribute, key)
+	assert.NoError(t, s.manager.Push(forged))
+	_, ok = offloadKey(forged)
+	assert.False(t, ok)
+
+	fetch := func(jobtype string) *client.Job {
+		for {
+			fetched, err := s.manager.Fetch(context.Background(), "", "default")
+			assert.NoError(t, err)
+			if fetched.Type == jobtype {
+				return fetched
+			}
+			_, err = s.manager.Acknowledge(fetched.Jid)
+			assert.NoError(t, err)
+		}
+	}
+
+	// the args are resolved for the worker but not reserved
+	fetched := fetch("Big")
+	assert.Equal(t, []interface{}{big}, fetched.Args)
+	s.store.Working().Each(func(_ int, e storage.SortedEntry) error {
+		assert.NotContains(t, string(e.Value()), big)
+		return nil
+	})
+
+	// nor written back to the queue when it's released
+	count, err := s.manager.Release(fetched.Jid)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	q, err := s.store.GetQueue("default")
+	assert.NoError(t, err)
+	q.Each(func(_ int, data []byte) error {
+		assert.NotContains(t, string(data), big)
+		return nil
+	})
+
+	fetched = fetch("Big")
+	assert.Equal(t, []interface{}{big}, fetched.Args)
+	_, err = s.manager.Acknowledge(fetched.Jid)
+	assert.NoError(t, err)
+	data, err := blobs.Get(key)
+	assert.NoError(t, err)
+	assert.Nil(t, data)
+	assert.EqualValues(t, 0, s.store.Redis().HLen(offloadedKey).Val())
+}
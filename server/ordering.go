@@ -0,0 +1,310 @@
+package server
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/manager"
+	"github.com/contribsys/faktory/util"
+	"github.com/go-redis/redis"
+)
+
+/*
+ * Jobs with the same "order_key" custom attribute run one at a time in
+ * the order they're fetched, like a Kafka partition or an SQS FIFO
+ * group, e.g. to apply an account's events in order:
+ *
+ *   "custom": {"order_key": "account-123"}
+ *
+ * The first job fetched takes the key's lock and holds it until it
+ * succeeds or won't be retried, through any retries.  A job fetched
+ * while another holds its key's lock is parked behind it rather than
+ * delivered, and the worker gets the next job from its queues instead.
+ * When the lock is released the oldest parked job takes it and is
+ * pushed to the front of its queue, so it runs next.  Jobs sharing a
+ * key should be pushed to the same queue, their order is the order
+ * they're fetched in.
+ *
+ * The lock expires with the job's reservation, or its retry, in case
+ * the job is lost, e.g. deleted in the Web UI.  Keys with parked jobs
+ * whose lock has expired are checked every minute and their oldest
+ * parked job released.
+ */
+const (
+	// the keys which have parked jobs
+	orderKeysKey = "order-keys"
+)
+
+type ordering struct {
+	rclient *redis.Client
+	mgr     manager.Manager
+	// serializes taking and handing over the locks
+	mu     sync.Mutex
+	parked int64
+}
+
+func OrderingSubsystem() Subsystem {
+	return &ordering{}
+}
+
+func (o *ordering) Start(s *Server) error {
+	o.rclient = s.Manager().Redis()
+	o.mgr = s.Manager()
+
+	s.Manager().AddMiddleware("fetch", o.fetch)
+	s.Manager().AddMiddleware("ack", o.ack)
+	s.Manager().AddMiddleware("fail", o.fail)
+	s.AddTask(60, o)
+	return nil
+}
+
+func (o *ordering) Reload(s *Server) error {
+	return nil
+}
+
+func orderKey(job *client.Job) string {
+	val, _ := job.GetCustom(client.OrderKeyAttribute)
+	key, _ := val.(string)
+	return key
+}
+
+func orderLockKey(key string) string {
+	return "order:" + key
+}
+
+func orderParkedKey(key string) string {
+	return "order-parked:" + key
+}
+
+// orderLockTTL is how long the job holds its key's lock if it's lost
+// while reserved
+func orderLockTTL(job *client.Job) time.Duration {
+	return time.Duration(manager.ReserveTimeout(job))*time.Second + time.Minute
+}
+
+func (o *ordering) fetch(next func() error, ctx manager.Context) error {
+	job := ctx.Job()
+	key := orderKey(job)
+	if key == "" {
+		return next()
+	}
+
+	o.mu.Lock()
+	holder, err := o.rclient.Get(orderLockKey(key)).Result()
+	if err != nil && err != redis.Nil {
+		o.mu.Unlock()
+		return err
+	}
+	if holder != "" && holder != job.Jid {
+		err = o.park(key, job)
+		o.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		return manager.Halt("parked behind " + holder)
+	}
+	if holder == "" {
+		waiting, err := o.rclient.LLen(orderParkedKey(key)).Result()
+		if err != nil {
+			o.mu.Unlock()
+			return err
+		}
+		if waiting > 0 {
+			// the lock expired, the oldest parked job goes first
+			err = o.park(key, job)
+			var promoted *client.Job
+			if err == nil {
+				promoted, err = o.handover(key)
+			}
+			o.mu.Unlock()
+			if err != nil {
+				return err
+			}
+			o.promote(key, promoted)
+			return manager.Halt("parked behind an expired lock")
+		}
+	}
+	err = o.rclient.Set(orderLockKey(key), job.Jid, orderLockTTL(job)).Err()
+	o.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = next()
+	if err != nil {
+		o.release(job)
+	}
+	return err
+}
+
+// park queues the job behind its key's lock, the caller holds o.mu
+func (o *ordering) park(key string, job *client.Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	_, err = o.rclient.Pipelined(func(pipe redis.Pipeliner) error {
+		pipe.LPush(orderParkedKey(key), data)
+		pipe.SAdd(orderKeysKey, key)
+		return nil
+	})
+	if err == nil {
+		atomic.AddInt64(&o.parked, 1)
+		util.ForJob(job.Jid, job.Queue).Debugf("Parked %s behind order key %s", job.Jid, key)
+	}
+	return err
+}
+
+// handover gives the key's lock to its oldest parked job, or frees it
+// if none are parked, the caller holds o.mu and must promote the job
+func (o *ordering) handover(key string) (*client.Job, error) {
+	data, err := o.rclient.RPop(orderParkedKey(key)).Bytes()
+	if err == redis.Nil {
+		_, err = o.rclient.Pipelined(func(pipe redis.Pipeliner) error {
+			pipe.Del(orderLockKey(key))
+			pipe.SRem(orderKeysKey, key)
+			return nil
+		})
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var job client.Job
+	err = json.Unmarshal(data, &job)
+	if err != nil {
+		return nil, err
+	}
+	err = o.rclient.Set(orderLockKey(key), job.Jid, orderLockTTL(&job)).Err()
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// promote pushes the job given the lock to the front of its queue
+func (o *ordering) promote(key string, job *client.Job) {
+	if job == nil {
+		return
+	}
+	err := o.mgr.PushFirst(job)
+	if err == nil {
+		return
+	}
+
+	util.ForJob(job.Jid, job.Queue).Warnf("Unable to release parked job %s: %v", job.Jid, err)
+	// put it back in front of the other parked jobs and free the
+	// lock, the next check will try again
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	data, err := json.Marshal(job)
+	if err == nil {
+		_, err = o.rclient.Pipelined(func(pipe redis.Pipeliner) error {
+			pipe.RPush(orderParkedKey(key), data)
+			pipe.Del(orderLockKey(key))
+			return nil
+		})
+	}
+	if err != nil {
+		util.ForJob(job.Jid, job.Queue).Warnf("Unable to park job %s again, it is lost: %v", job.Jid, err)
+	}
+}
+
+// release hands the key's lock over, if the job holds it
+func (o *ordering) release(job *client.Job) {
+	key := orderKey(job)
+	if key == "" {
+		return
+	}
+
+	o.mu.Lock()
+	holder, err := o.rclient.Get(orderLockKey(key)).Result()
+	var promoted *client.Job
+	if err == nil && holder == job.Jid {
+		promoted, err = o.handover(key)
+	}
+	o.mu.Unlock()
+	if err != nil && err != redis.Nil {
+		util.ForJob(job.Jid, job.Queue).Warnf("Unable to release order key %s: %v", key, err)
+		return
+	}
+	o.promote(key, promoted)
+}
+
+func (o *ordering) ack(next func() error, ctx manager.Context) error {
+	err := next()
+	if err == nil {
+		o.release(ctx.Job())
+	}
+	return err
+}
+
+func (o *ordering) fail(next func() error, ctx manager.Context) error {
+	err := next()
+	job := ctx.Job()
+	key := orderKey(job)
+	if err != nil || key == "" {
+		return err
+	}
+	if job.Retry == 0 || job.Failure.RetryCount >= job.Retry {
+		o.release(job)
+		return nil
+	}
+
+	// hold the lock until the retry has had time to run
+	ttl := orderLockTTL(job)
+	if at, perr := util.ParseTime(job.Failure.NextAt); perr == nil {
+		ttl += time.Until(at)
+	}
+	o.mu.Lock()
+	holder, gerr := o.rclient.Get(orderLockKey(key)).Result()
+	if gerr == nil && holder == job.Jid {
+		gerr = o.rclient.Expire(orderLockKey(key), ttl).Err()
+	}
+	o.mu.Unlock()
+	if gerr != nil && gerr != redis.Nil {
+		util.ForJob(job.Jid, job.Queue).Warnf("Unable to extend order key %s: %v", key, gerr)
+	}
+	return nil
+}
+
+func (o *ordering) Name() string {
+	return "Ordering"
+}
+
+func (o *ordering) Stats() map[string]interface{} {
+	return map[string]interface{}{
+		"parked": atomic.LoadInt64(&o.parked),
+	}
+}
+
+// Execute releases the oldest parked job of each key whose lock has
+// expired
+func (o *ordering) Execute() error {
+	keys, err := o.rclient.SMembers(orderKeysKey).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		o.mu.Lock()
+		var promoted *client.Job
+		exists, err := o.rclient.Exists(orderLockKey(key)).Result()
+		if err == nil && exists == 0 {
+			promoted, err = o.handover(key)
+		}
+		o.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		if promoted != nil {
+			util.ForJob(promoted.Jid, promoted.Queue).Infof("Order key %s expired, releasing %s", key, promoted.Jid)
+			o.promote(key, promoted)
+		}
+	}
+	return nil
+}
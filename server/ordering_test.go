@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/manager"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrdering(t *testing.T) {
+	s, _, stop := newTestServer(t, &ServerOptions{GlobalConfig: map[string]interface{}{}})
+	defer stop()
+	s.Register(OrderingSubsystem())
+	o := s.Subsystems[0].(*ordering)
+	assert.NoError(t, o.Start(s))
+
+	ordered := func(key string) *client.Job {
+		job := client.NewJob("ApplyEvent", key)
+		job.Queue = "events"
+		job.SetOrderKey(key)
+		assert.NoError(t, s.manager.Push(job))
+		return job
+	}
+	fetch := func() *client.Job {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		job, err := s.manager.Fetch(ctx, "", "events")
+		assert.NoError(t, err)
+		return job
+	}
+
+	a1 := ordered("account-1")
+	a2 := ordered("account-1")
+	b1 := ordered("account-2")
+	a3 := ordered("account-1")
+
+	// the rest of account-1 is parked behind a1
+	assert.Equal(t, a1.Jid, fetch().Jid)
+	assert.Equal(t, b1.Jid, fetch().Jid)
+	assert.Nil(t, fetch())
+
+	_, err := s.manager.Acknowledge(a1.Jid)
+	assert.NoError(t, err)
+	assert.Equal(t, a2.Jid, fetch().Jid)
+
+	// a retry keeps the lock
+	assert.NoError(t, s.manager.Fail(&manager.FailPayload{Jid: a2.Jid, ErrorType: "Timeout"}))
+	assert.Nil(t, fetch())
+
+	// until it expires
+	assert.NoError(t, o.Execute())
+	assert.Nil(t, fetch())
+	assert.NoError(t, o.rclient.Del(orderLockKey("account-1")).Err())
+	assert.NoError(t, o.Execute())
+	assert.Equal(t, a3.Jid, fetch().Jid)
+
+	_, err = s.manager.Acknowledge(a3.Jid)
+	assert.NoError(t, err)
+	keys, err := o.rclient.SMembers(orderKeysKey).Result()
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+	assert.EqualValues(t, 2, o.Stats()["parked"])
+
+	// jobs without a key are never held back
+	_, err = s.manager.Acknowledge(b1.Jid)
+	assert.NoError(t, err)
+	ordered("account-2")
+	plain := client.NewJob("ApplyEvent", "account-2")
+	plain.Queue = "events"
+	assert.NoError(t, s.manager.Push(plain))
+	assert.NotNil(t, fetch())
+	assert.Equal(t, plain.Jid, fetch().Jid)
+}
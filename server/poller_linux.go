@@ -0,0 +1,73 @@
+// +build linux
+
+package server
+
+import (
+	"sync/atomic"
+	"syscall"
+)
+
+// poller reports when idle connections become readable with epoll.
+// Each fd is armed for a single event and must be re-armed once its
+// connection has been handled.
+type poller struct {
+	fd     int
+	closed int32
+}
+
+func newPoller() (*poller, error) {
+	fd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	return &poller{fd: fd}, nil
+}
+
+func (p *poller) arm(fd int) error {
+	if atomic.LoadInt32(&p.closed) == 1 {
+		return syscall.EBADF
+	}
+	event := &syscall.EpollEvent{
+		Events: syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT,
+		Fd:     int32(fd),
+	}
+	err := syscall.EpollCtl(p.fd, syscall.EPOLL_CTL_MOD, fd, event)
+	if err == syscall.ENOENT {
+		err = syscall.EpollCtl(p.fd, syscall.EPOLL_CTL_ADD, fd, event)
+	}
+	return err
+}
+
+func (p *poller) remove(fd int) error {
+	if atomic.LoadInt32(&p.closed) == 1 {
+		return nil
+	}
+	return syscall.EpollCtl(p.fd, syscall.EPOLL_CTL_DEL, fd, nil)
+}
+
+// wait calls fn with each readable fd until the poller is closed
+func (p *poller) wait(fn func(fd int)) error {
+	defer syscall.Close(p.fd)
+
+	events := make([]syscall.EpollEvent, 128)
+	for atomic.LoadInt32(&p.closed) == 0 {
+		// closing the fd doesn't interrupt epoll_wait so wake
+		// up regularly to check
+		n, err := syscall.EpollWait(p.fd, events, 1000)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			fn(int(events[i].Fd))
+		}
+	}
+	return nil
+}
+
+func (p *poller) close() error {
+	atomic.StoreInt32(&p.closed, 1)
+	return nil
+}
@@ -0,0 +1,29 @@
+// +build !linux
+
+package server
+
+import (
+	"fmt"
+)
+
+type poller struct{}
+
+func newPoller() (*poller, error) {
+	return nil, fmt.Errorf("[tcp] handlers requires Linux")
+}
+
+func (p *poller) arm(fd int) error {
+	return nil
+}
+
+func (p *poller) remove(fd int) error {
+	return nil
+}
+
+func (p *poller) wait(fn func(fd int)) error {
+	return nil
+}
+
+func (p *poller) close() error {
+	return nil
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/contribsys/faktory/util"
+)
+
+/*
+ * By default each connection has its own goroutine which blocks reading
+ * the next command.  With tens of thousands of mostly idle workers their
+ * stacks dominate the server's memory, so connections can instead wait
+ * in a readiness poller (epoll, Linux only) and be handled by a bounded
+ * pool of goroutines when a command arrives:
+ *
+ * [tcp]
+ * handlers = 0     # size of the handler pool, 0 uses a goroutine per connection
+ *
+ * A command which blocks, e.g. FETCH on an empty queue, occupies a handler
+ * so the pool should be larger than the number of workers expected to
+ * fetch at once.  TLS connections always use their own goroutine.
+ */
+
+// how long a handler waits for the rest of a partially sent command
+var pooledReadTimeout = 5 * time.Second
+
+type pooledConn struct {
+	fd      int
+	conn    net.Conn
+	c       *Connection
+	removed int32
+}
+
+// pooledCloser stops polling a connection and forgets it before it's
+// closed, its fd may be reused by the next accepted connection
+type pooledCloser struct {
+	net.Conn
+	pool *connPool
+	pc   *pooledConn
+}
+
+func (pc *pooledCloser) Close() error {
+	pc.pool.poller.remove(pc.pc.fd)
+	pc.pool.forget(pc.pc)
+	return pc.Conn.Close()
+}
+
+type connPool struct {
+	s      *Server
+	poller *poller
+	ready  chan *pooledConn
+
+	mu    sync.Mutex
+	conns map[int]*pooledConn
+}
+
+// newConnPool returns nil if pooling isn't configured
+func newConnPool(s *Server) (*connPool, error) {
+	size := s.Options.Int("tcp", "handlers", 0)
+	if size <= 0 {
+		return nil, nil
+	}
+	p, err := newPoller()
+	if err != nil {
+		return nil, err
+	}
+
+	pool := &connPool{
+		s:      s,
+		poller: p,
+		ready:  make(chan *pooledConn, size),
+		conns:  map[int]*pooledConn{},
+	}
+	for i := 0; i < size; i++ {
+		go pool.handler()
+	}
+	go func() {
+		err := p.wait(pool.dispatch)
+		if err != nil {
+			util.Warnf("Connection poller stopped: %v", err)
+		}
+	}()
+	util.Infof("Handling idle connections with a pool of %d goroutines", size)
+	return pool, nil
+}
+
+// add hands the connection to the pool, returning false if
+// it needs its own goroutine
+func (pool *connPool) add(conn net.Conn, c *Connection) bool {
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return false
+	}
+	raw, err := tcp.SyscallConn()
+	if err != nil {
+		return false
+	}
+	fd := -1
+	raw.Control(func(ptr uintptr) {
+		fd = int(ptr)
+	})
+	if fd < 0 {
+		return false
+	}
+
+	pc := &pooledConn{fd: fd, conn: conn, c: c}
+	c.conn = &pooledCloser{conn, pool, pc}
+	pool.mu.Lock()
+	pool.conns[fd] = pc
+	pool.mu.Unlock()
+	atomic.AddUint64(&pool.s.Stats.Connections, 1)
+
+	// commands may have been sent along with HELLO
+	if c.buf.Buffered() > 0 {
+		pool.ready <- pc
+		return true
+	}
+	err = pool.poller.arm(fd)
+	if err != nil {
+		util.Warnf("Unable to poll connection from %s: %v", conn.RemoteAddr(), err)
+		pool.remove(pc)
+		c.Close()
+	}
+	return true
+}
+
+func (pool *connPool) dispatch(fd int) {
+	pool.mu.Lock()
+	pc, ok := pool.conns[fd]
+	pool.mu.Unlock()
+	if ok {
+		pool.ready <- pc
+	}
+}
+
+func (pool *connPool) handler() {
+	for pc := range pool.ready {
+		pool.handle(pc)
+	}
+}
+
+// handle processes the commands which have arrived and
+// returns the connection to the poller
+func (pool *connPool) handle(pc *pooledConn) {
+	for {
+		pc.conn.SetReadDeadline(time.Now().Add(pooledReadTimeout))
+		if !pool.s.processLine(pc.c) {
+			pool.remove(pc)
+			return
+		}
+		if pc.c.buf.Buffered() == 0 {
+			break
+		}
+	}
+	pc.conn.SetReadDeadline(time.Time{})
+
+	err := pool.poller.arm(pc.fd)
+	if err != nil {
+		pool.remove(pc)
+		pc.c.Close()
+	}
+}
+
+func (pool *connPool) remove(pc *pooledConn) {
+	pool.forget(pc)
+	if !atomic.CompareAndSwapInt32(&pc.removed, 0, 1) {
+		return
+	}
+	atomic.AddUint64(&pool.s.Stats.Connections, ^uint64(0))
+	cleanupConnection(pool.s, pc.c)
+}
+
+// forget stops dispatching to the connection, unless its fd
+// already belongs to a newer one
+func (pool *connPool) forget(pc *pooledConn) {
+	pool.mu.Lock()
+	if pool.conns[pc.fd] == pc {
+		delete(pool.conns, pc.fd)
+	}
+	pool.mu.Unlock()
+}
+
+// close stops polling and closes the pooled connections
+func (pool *connPool) close() {
+	pool.poller.close()
+	pool.mu.Lock()
+	conns := make([]*pooledConn, 0, len(pool.conns))
+	for _, pc := range pool.conns {
+		conns = append(conns, pc)
+	}
+	pool.mu.Unlock()
+	for _, pc := range conns {
+		pool.remove(pc)
+		pc.c.Close()
+	}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnectionPool(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("readiness polling requires Linux")
+	}
+
+	runServer("localhost:7427", func() {
+		dial := func() *client.Client {
+			srv := client.DefaultServer()
+			srv.Address = "localhost:7427"
+			cl, err := client.Dial(srv, "")
+			assert.NoError(t, err)
+			return cl
+		}
+
+		idle := make([]*client.Client, 50)
+		for i := range idle {
+			idle[i] = dial()
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(cl *client.Client) {
+				defer wg.Done()
+				for j := 0; j < 20; j++ {
+					assert.NoError(t, cl.Push(client.NewJob("Thing", j)))
+					job, err := cl.Fetch("default")
+					assert.NoError(t, err)
+					assert.NotNil(t, job)
+					assert.NoError(t, cl.Ack(job.Jid))
+				}
+			}(idle[i])
+		}
+		wg.Wait()
+
+		info, err := idle[49].Info()
+		assert.NoError(t, err)
+		server := info["server"].(map[string]interface{})
+		assert.EqualValues(t, 50, server["connections"])
+
+		for _, cl := range idle[10:] {
+			cl.Close()
+		}
+		time.Sleep(100 * time.Millisecond)
+		info, err = idle[0].Info()
+		assert.NoError(t, err)
+		server = info["server"].(map[string]interface{})
+		assert.EqualValues(t, 10, server["connections"], fmt.Sprintf("%v", server))
+	}, func(opts *ServerOptions) {
+		opts.GlobalConfig = map[string]interface{}{
+			"tcp": map[string]interface{}{"handlers": 4},
+		}
+	})
+}
+
+func TestConnectionPoolReuse(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("readiness polling requires Linux")
+	}
+
+	runServer("localhost:7427", func() {
+		dial := func() *client.Client {
+			srv := client.DefaultServer()
+			srv.Address = "localhost:7427"
+			cl, err := client.Dial(srv, "")
+			assert.NoError(t, err)
+			return cl
+		}
+		observer := dial()
+		defer observer.Close()
+
+		// closed fds are reused straight away by the next accept
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			var wg sync.WaitGroup
+			for i := 0; i < 4; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 100; j++ {
+						cl := dial()
+						_, err := cl.Info()
+						assert.NoError(t, err)
+						cl.Close()
+					}
+				}()
+			}
+			wg.Wait()
+		}()
+		select {
+		case <-done:
+		case <-time.After(20 * time.Second):
+			t.Fatal("a reopened connection was never handled")
+		}
+
+		time.Sleep(100 * time.Millisecond)
+		info, err := observer.Info()
+		assert.NoError(t, err)
+		server := info["server"].(map[string]interface{})
+		assert.EqualValues(t, 1, server["connections"], fmt.Sprintf("%v", server))
+	}, func(opts *ServerOptions) {
+		opts.GlobalConfig = map[string]interface{}{
+			"tcp": map[string]interface{}{"handlers": 4},
+		}
+	})
+}
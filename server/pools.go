@@ -0,0 +1,78 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/manager"
+)
+
+// Every PUSH, FETCH and FAIL allocates a job or failure and a response,
+// these pools reuse them to reduce GC pressure at high throughput.
+// Nothing taken from a pool may be referenced once it's been released,
+// so middleware must not keep the job it's given after returning.
+
+// buffers larger than this aren't pooled so one huge job
+// doesn't pin its buffer forever
+const maxPooledBuffer = 64 * 1024
+
+var (
+	jobPool = sync.Pool{
+		New: func() interface{} {
+			return &client.Job{}
+		},
+	}
+	failurePool = sync.Pool{
+		New: func() interface{} {
+			return &manager.FailPayload{}
+		},
+	}
+	bufferPool = sync.Pool{
+		New: func() interface{} {
+			return &bytes.Buffer{}
+		},
+	}
+)
+
+func acquireJob() *client.Job {
+	return jobPool.Get().(*client.Job)
+}
+
+func releaseJob(job *client.Job) {
+	*job = client.Job{}
+	jobPool.Put(job)
+}
+
+func acquireFailure() *manager.FailPayload {
+	return failurePool.Get().(*manager.FailPayload)
+}
+
+func releaseFailure(failure *manager.FailPayload) {
+	*failure = manager.FailPayload{}
+	failurePool.Put(failure)
+}
+
+func acquireBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func releaseBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBuffer {
+		bufferPool.Put(buf)
+	}
+}
+
+// encodeJob writes the job's JSON to the buffer
+func encodeJob(buf *bytes.Buffer, job *client.Job) error {
+	err := json.NewEncoder(buf).Encode(job)
+	if err != nil {
+		return err
+	}
+	// drop the encoder's trailing newline
+	buf.Truncate(buf.Len() - 1)
+	return nil
+}
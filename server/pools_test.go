@@ -0,0 +1,77 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/contribsys/faktory/storage"
+)
+
+// go test ./server -run NONE -bench Commands -benchmem
+func BenchmarkCommands(b *testing.B) {
+	dir := "/tmp/faktory-bench-commands"
+	defer os.RemoveAll(dir)
+	sock := fmt.Sprintf("%s/test.sock", dir)
+	stopper, err := storage.BootRedis(dir, sock)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer stopper()
+
+	s, err := NewServer(&ServerOptions{Binding: "localhost:7428", StorageDirectory: dir, RedisSock: sock})
+	if err != nil {
+		b.Fatal(err)
+	}
+	err = s.Boot()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer s.Stop(nil)
+
+	out := &bufferConn{}
+	c := &Connection{client: &ClientData{Wid: "bench", state: Running}, conn: out}
+	payload := `PUSH {"jid":"%s","jobtype":"Thing","args":[1,"two",{"three":3}],"queue":"default","retry":1}`
+
+	run := func(b *testing.B, cmd string) {
+		out.Reset()
+		cmdSet[strings.SplitN(cmd, " ", 2)[0]](c, s, cmd)
+		if out.Len() == 0 || out.Bytes()[0] == '-' {
+			b.Fatalf("%s: %s", cmd, out.String())
+		}
+	}
+
+	b.Run("Push", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			run(b, fmt.Sprintf(payload, fmt.Sprintf("push-%020d", i)))
+		}
+		s.store.Flush()
+	})
+
+	b.Run("FetchAck", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			run(b, fmt.Sprintf(payload, fmt.Sprintf("ack-%020d", i)))
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			run(b, "FETCH default")
+			run(b, fmt.Sprintf(`ACK {"jid":"ack-%020d"}`, i))
+		}
+	})
+
+	b.Run("FetchFail", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			run(b, fmt.Sprintf(payload, fmt.Sprintf("fail-%020d", i)))
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			run(b, "FETCH default")
+			run(b, fmt.Sprintf(`FAIL {"jid":"fail-%020d","errtype":"RuntimeError","message":"oops","backtrace":["a.rb:1","b.rb:2"]}`, i))
+		}
+		s.store.Flush()
+	})
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/util"
+)
+
+/*
+ * A worker may ask FETCH to hold a few jobs for it with "prefetch=N",
+ * up to [fetch] max_prefetch, smoothing over latency spikes between
+ * the worker and Redis.  After
+ * each job it delivers, FETCH reserves up to N more for the worker
+ * without waiting and the worker's next FETCH gets one of them
+ * immediately:
+ *
+ *   FETCH critical default prefetch=4
+ *
+ * The held jobs are reserved like any fetched job, so they survive a
+ * server crash, but they're only held until the worker's next BEAT,
+ * which returns them to the front of their queues.  A worker which
+ * stops fetching doesn't sit on jobs other workers could run and a
+ * worker which dies has its jobs returned when its heartbeat is
+ * reaped.
+ */
+type prefetchBuffers struct {
+	mu    sync.Mutex
+	byWid map[string]*prefetchBuffer
+}
+
+type prefetchBuffer struct {
+	jobs    []*client.Job
+	filling bool
+}
+
+// take returns the worker's next held job, nil if it has none
+func (pb *prefetchBuffers) take(wid string) *client.Job {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	buf := pb.byWid[wid]
+	if buf == nil || len(buf.jobs) == 0 {
+		return nil
+	}
+	job := buf.jobs[0]
+	buf.jobs = buf.jobs[1:]
+	return job
+}
+
+// claim returns how many jobs to fetch to hold size for the worker,
+// 0 if it has enough or another fetch is already filling its buffer.
+// A claim must be followed by fill.
+func (pb *prefetchBuffers) claim(wid string, size int) int {
+	if size <= 0 {
+		return 0
+	}
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	if pb.byWid == nil {
+		pb.byWid = map[string]*prefetchBuffer{}
+	}
+	buf := pb.byWid[wid]
+	if buf == nil {
+		buf = &prefetchBuffer{}
+		pb.byWid[wid] = buf
+	}
+	if buf.filling || len(buf.jobs) >= size {
+		return 0
+	}
+	buf.filling = true
+	return size - len(buf.jobs)
+}
+
+func (pb *prefetchBuffers) fill(wid string, jobs []*client.Job) {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	buf := pb.byWid[wid]
+	if buf == nil {
+		buf = &prefetchBuffer{}
+		pb.byWid[wid] = buf
+	}
+	buf.jobs = append(buf.jobs, jobs...)
+	buf.filling = false
+}
+
+// drain removes and returns the worker's held jobs
+func (pb *prefetchBuffers) drain(wid string) []*client.Job {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	buf := pb.byWid[wid]
+	if buf == nil {
+		return nil
+	}
+	jobs := buf.jobs
+	buf.jobs = nil
+	if !buf.filling {
+		delete(pb.byWid, wid)
+	}
+	return jobs
+}
+
+// prefetch tops up the jobs held for the worker after a FETCH
+func (s *Server) prefetch(wid string, req *fetchRequest) {
+	size := req.prefetch
+	if max := s.Options.Int("fetch", "max_prefetch", 10); size > max {
+		size = max
+	}
+	want := s.prefetched.claim(wid, size)
+	if want == 0 {
+		return
+	}
+	jobs, err := s.manager.Prefetch(wid, want, req.queues...)
+	s.prefetched.fill(wid, jobs)
+	if err != nil {
+		util.Warnf("Unable to prefetch jobs for %s: %v", wid, err)
+	}
+}
+
+// releasePrefetched returns the jobs held for the worker to the front
+// of their queues
+func (s *Server) releasePrefetched(wid string) {
+	jobs := s.prefetched.drain(wid)
+	if len(jobs) == 0 {
+		return
+	}
+	// each is pushed to the front, so last first to keep their order
+	jids := make([]string, len(jobs))
+	for idx, job := range jobs {
+		jids[len(jobs)-1-idx] = job.Jid
+	}
+	count, err := s.manager.Release(jids...)
+	if err != nil {
+		util.Warnf("Unable to release prefetched jobs for %s: %v", wid, err)
+		return
+	}
+	util.Debugf("Released %d prefetched jobs for %s", count, wid)
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrefetch(t *testing.T) {
+	s, _, stop := newTestServer(t, &ServerOptions{GlobalConfig: map[string]interface{}{}})
+	defer stop()
+	s.workers.reaped = s.releasePrefetched
+	worker, ok := s.workers.heartbeat(&ClientData{Wid: "prefetcher"}, cls{})
+	assert.True(t, ok)
+
+	run := commandRunner(s, worker)
+
+	jids := []string{}
+	for i := 0; i < 4; i++ {
+		job := client.NewJob("Export", i)
+		assert.NoError(t, s.manager.Push(job))
+		jids = append(jids, job.Jid)
+	}
+	q, err := s.store.GetQueue("default")
+	assert.NoError(t, err)
+
+	// the first is delivered, the next two held
+	assert.Contains(t, run("FETCH default prefetch=2"), jids[0])
+	assert.Equal(t, 3, s.manager.BusyCount("prefetcher"))
+	assert.EqualValues(t, 1, q.Size())
+
+	// delivered from the buffer, which is topped up
+	assert.Contains(t, run("FETCH default prefetch=2"), jids[1])
+	assert.Equal(t, 4, s.manager.BusyCount("prefetcher"))
+	assert.EqualValues(t, 0, q.Size())
+
+	// a heartbeat returns the held jobs in order
+	assert.Equal(t, "+OK", run(`BEAT {"wid":"prefetcher"}`))
+	assert.Equal(t, 2, s.manager.BusyCount("prefetcher"))
+	assert.EqualValues(t, 2, q.Size())
+
+	// as are a dead worker's when its heartbeat is reaped
+	assert.Contains(t, run("FETCH default prefetch=1"), jids[2])
+	assert.EqualValues(t, 0, q.Size())
+	assert.Equal(t, 1, s.workers.reapHeartbeats(time.Now()))
+	assert.Equal(t, 3, s.manager.BusyCount("prefetcher"))
+	assert.EqualValues(t, 1, q.Size())
+	assert.Contains(t, run("FETCH default"), jids[3])
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/contribsys/faktory/client"
+)
+
+//go:generate go run ../cmd/protodoc/main.go ../docs/protocol-reference.md
+
+/*
+ * The protocol reference is generated from the tables below so it
+ * can't drift from the implementation: the tests fail if a command in
+ * cmdSet or an error code the server returns isn't documented here, or
+ * if docs/protocol-reference.md is stale, run `make generate`.  The Web
+ * UI serves the reference at /protocol.  docs/protocol-specification.md
+ * remains the narrative specification.
+ */
+
+// CommandDoc documents a command of the protocol
+type CommandDoc struct {
+	Name        string   `json:"name"`
+	Arguments   string   `json:"arguments"`
+	Responses   []string `json:"responses"`
+	Description string   `json:"description"`
+	// Admin commands are only accepted on the admin binding,
+	// when one is configured
+	Admin bool `json:"admin,omitempty"`
+}
+
+// FieldDoc documents an error code or a field of the HI
+type FieldDoc struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// ProtocolReference describes the protocol spoken by this server
+type ProtocolReference struct {
+	Version        int          `json:"version"`
+	FaktoryVersion string       `json:"faktory_version"`
+	Capabilities   []FieldDoc   `json:"capabilities"`
+	Commands       []CommandDoc `json:"commands"`
+	ErrorCodes     []FieldDoc   `json:"error_codes"`
+}
+
+// the fields the server may send in its HI
+var capabilities = []FieldDoc{
+	{"v", "the protocol version, clients warn if it isn't the version they expect"},
+	{"i", "the number of SHA256 iterations for the HELLO's pwdhash, sent when the server checks a password"},
+	{"s", "the salt for the HELLO's pwdhash, sent with i"},
+	{"a", fmt.Sprintf("the credentials the HELLO must include instead of a pwdhash: %q for a token, %q for a username and password", MechanismToken, MechanismPlain)},
+}
+
+// commandDocs lists the commands in the order they're documented,
+// HELLO is part of the handshake rather than cmdSet
+var commandDocs = []CommandDoc{
+	{
+		Name:      "HELLO",
+		Arguments: "{v: Integer, pwdhash: String, token: String, username: String, password: String, wid: String, hostname: String, pid: Integer, labels: Array[String]}",
+		Responses: []string{`"OK" - the connection is accepted`, "Error - the connection is declined"},
+		Description: "The first command of every connection, sent in response to the server's HI. " +
+			"It must include v and the credentials the HI asks for, consumers also send wid, hostname, pid and labels.",
+	},
+	{
+		Name:        "PUSH",
+		Arguments:   "{jid: String, jobtype: String, args: Array, queue: String, ...}",
+		Responses:   []string{`"OK" - the job was enqueued`, "Error - the job was not enqueued"},
+		Description: "Enqueues a job, or schedules it if it has an `at` time.",
+	},
+	{
+		Name:        "PUSHB",
+		Arguments:   "[{jid: String, jobtype: String, args: Array, queue: String, ...}, ...]",
+		Responses:   []string{"Bulk String - {jid: String} the error of each job which wasn't enqueued", "Error - no job was enqueued"},
+		Description: "Pushes up to 10,000 jobs as PUSH would with a single write to storage.",
+	},
+	{
+		Name:        "FETCH",
+		Arguments:   "[queue...] [lifetime=Integer] [timeout=Integer] [prefetch=Integer]",
+		Responses:   []string{"Bulk String - a job to execute", "Null - no job is available", "Error"},
+		Description: "Reserves a job from the first of the queues which has one, waiting for up to 2 seconds, or the timeout in seconds up to [fetch] max_timeout, for a job to be pushed. Fetched jobs must be acknowledged with ACK or FAIL. A worker which expects to be stopped, e.g. a spot instance given notice, may send its remaining lifetime in seconds so queues whose next job reserves for longer are skipped. A worker may ask the server to hold up to prefetch jobs for its next fetches, returned to their queues at its next BEAT. Fails with MAINTENANCE while the server is in maintenance mode.",
+	},
+	{
+		Name:        "ACK",
+		Arguments:   "{jid: String, annotations: Hash[String, String], result: Any}",
+		Responses:   []string{`"OK" - the job is complete`, "Error"},
+		Description: "Reports a fetched job was executed successfully. The result, any JSON up to [results] max_size, is kept for RESULT GET.",
+	},
+	{
+		Name:        "FAIL",
+		Arguments:   "{jid: String, errtype: String, message: String, backtrace: Array[String], annotations: Hash[String, String]}",
+		Responses:   []string{`"OK" - the failure was recorded`, "Error"},
+		Description: "Reports a fetched job failed, it's retried or moved to the dead set as its retry count allows.",
+	},
+	{
+		Name:        "BEAT",
+		Arguments:   "{wid: String}",
+		Responses:   []string{`"OK"`, `{state: String} - the worker must quiet or terminate`, "Error"},
+		Description: "Reports a consumer is alive, at least every 15 seconds.",
+	},
+	{
+		Name:        "INFO",
+		Arguments:   "none",
+		Responses:   []string{"Bulk String - the server's state as JSON", "Error"},
+		Description: "Returns the queue sizes and server statistics.",
+	},
+	{
+		Name:        "FLUSH",
+		Arguments:   "none",
+		Responses:   []string{`"OK" - the dataset was deleted`, "Error"},
+		Description: "Deletes every job and statistic, for tests.",
+	},
+	{
+		Name:        "JOBS",
+		Arguments:   "{set: String, cursor: String, count: Integer}",
+		Responses:   []string{"Bulk String - {jobs: Array[job], cursor: String}", "Error"},
+		Description: `Pages through the "scheduled", "retries" or "dead" set. Pass the returned cursor to continue, an empty cursor means the set is exhausted.`,
+	},
+	{
+		Name:        "TRACK",
+		Arguments:   "GET {jid: String} | SET {jid: String, percent: Integer, desc: String}",
+		Responses:   []string{"Bulk String - GET's {jid: String, state: String, percent: Integer, desc: String, updated_at: String}", `"OK" - SET's progress was recorded`, "Error"},
+		Description: `GET returns the state and progress of a job pushed with "track": true. SET reports the progress of a job being executed, percent complete and a message for the user, which is reset when the job changes state.`,
+	},
+	{
+		Name:        "RESULT",
+		Arguments:   "GET jid",
+		Responses:   []string{"Bulk String - the result the job was acknowledged with", "Null - the job has no result or it has expired", "Error"},
+		Description: "Returns the result a worker sent with the job's ACK, results expire after [results] ttl.",
+	},
+	{
+		Name:        "MARK",
+		Arguments:   "{kind: String, label: String, at: String}",
+		Responses:   []string{`"OK" - the marker was recorded`, "Error"},
+		Description: `Records a "deploy" or "incident" to draw on the Web UI's charts.`,
+	},
+	{
+		Name:        "BACKUP",
+		Arguments:   "none",
+		Responses:   []string{"Bulk String - the path of the backup on the server", "Error"},
+		Description: "Backs up Redis to the storage directory, responding once the backup is complete.",
+	},
+	{
+		Name:        "TEMPLATE",
+		Arguments:   "LIST | SAVE {name: String, jobtype: String, queue: String, args: Array, description: String} | DEL {name: String} | PUSH {name: String, params: Hash[String, String]}",
+		Responses:   []string{"Bulk String - LIST's templates as JSON, PUSH's JID", `"OK" - the template was saved or deleted`, "Error"},
+		Description: "Manages the job templates admins push by hand, strings in a template's args may contain `{{param}}` or `{{param|default}}` placeholders which PUSH fills in from its params.",
+	},
+	{
+		Name:        "MAINTENANCE",
+		Arguments:   "ON | OFF",
+		Responses:   []string{`"OK"`, "Error"},
+		Description: "Turns maintenance mode on or off. In maintenance mode PUSH is accepted but FETCH fails with MAINTENANCE, so workers can be drained while jobs keep queueing.",
+	},
+	{
+		Name:        "BATCH",
+		Arguments:   "NEW {description: String, success: Job, complete: Job} | OPEN bid | COMMIT bid | STATUS bid",
+		Responses:   []string{"Bulk String - NEW's BID, STATUS's progress as JSON", `"OK" - the batch was opened or committed`, "Error"},
+		Description: "Groups jobs, pushed with the BID in the `bid` custom attribute, so the `complete` callback job is pushed once every job has run and the `success` callback once every job has succeeded. Jobs can only be pushed to an open batch, COMMIT marks it as fully pushed.",
+	},
+	{
+		Name:        "MUTATE",
+		Arguments:   "{cmd: String, target: String, filter: {jids: Array[String], jobtype: String, pattern: String}}",
+		Responses:   []string{"Integer - the number of jobs changed", "Error"},
+		Description: "Changes the jobs in the `retries`, `scheduled` or `dead` target set in bulk. `clear` removes every job, `discard` removes the matching jobs, `kill` moves them to the dead set and `requeue` pushes them to their queues. The filter's `pattern` is a glob matched against each job's JSON.",
+	},
+	{
+		Name:        "END",
+		Arguments:   "none",
+		Responses:   []string{"the connection is closed"},
+		Description: "Ends the connection, consumers should first ACK or FAIL their jobs.",
+	},
+}
+
+// Protocol returns the reference for the protocol this server speaks
+func Protocol() *ProtocolReference {
+	commands := make([]CommandDoc, len(commandDocs))
+	for idx, doc := range commandDocs {
+		doc.Admin = adminCommands[doc.Name]
+		commands[idx] = doc
+	}
+	return &ProtocolReference{
+		Version:        client.ExpectedProtocolVersion,
+		FaktoryVersion: client.Version,
+		Capabilities:   capabilities,
+		Commands:       commands,
+		ErrorCodes:     errorCodes,
+	}
+}
+
+// WriteMarkdown writes the reference as Markdown
+func (p *ProtocolReference) WriteMarkdown(w io.Writer) error {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# Faktory Protocol Reference\n\n")
+	fmt.Fprintf(&b, "Protocol version %d, Faktory %s.  This file is generated from server/protocol.go, see\n", p.Version, p.FaktoryVersion)
+	fmt.Fprintf(&b, "protocol-specification.md for the complete specification.\n\n")
+
+	fmt.Fprintf(&b, "## Capabilities\n\nThe fields the server may send in its `HI`:\n\n| Field | Description |\n| ----- | ----------- |\n")
+	for _, field := range p.Capabilities {
+		fmt.Fprintf(&b, "| `%s` | %s |\n", field.Name, field.Description)
+	}
+
+	fmt.Fprintf(&b, "\n## Commands\n")
+	for _, cmd := range p.Commands {
+		fmt.Fprintf(&b, "\n### `%s`\n\n", cmd.Name)
+		fmt.Fprintf(&b, "Arguments: `%s`\n\n", cmd.Arguments)
+		fmt.Fprintf(&b, "Responses:\n\n")
+		for _, resp := range cmd.Responses {
+			fmt.Fprintf(&b, " - %s\n", resp)
+		}
+		fmt.Fprintf(&b, "\n%s\n", cmd.Description)
+		if cmd.Admin {
+			fmt.Fprintf(&b, "\nOnly accepted on the admin binding when one is configured.\n")
+		}
+	}
+
+	fmt.Fprintf(&b, "\n## Error Codes\n\nError responses start with a code so clients can branch on the cause:\n\n| Code | Description |\n| ---- | ----------- |\n")
+	for _, code := range p.ErrorCodes {
+		fmt.Fprintf(&b, "| `%s` | %s |\n", code.Name, code.Description)
+	}
+
+	_, err := b.WriteTo(w)
+	return err
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProtocolReference(t *testing.T) {
+	ref := Protocol()
+	documented := map[string]bool{}
+	for _, cmd := range ref.Commands {
+		documented[cmd.Name] = true
+		assert.NotEmpty(t, cmd.Description, cmd.Name)
+		assert.NotEmpty(t, cmd.Responses, cmd.Name)
+		if cmd.Name != "HELLO" {
+			assert.NotNil(t, cmdSet[cmd.Name], "%s is documented but not implemented", cmd.Name)
+		}
+		assert.Equal(t, adminCommands[cmd.Name], cmd.Admin, cmd.Name)
+	}
+	for name := range cmdSet {
+		assert.True(t, documented[name], "%s is missing from commandDocs", name)
+	}
+
+	codes := map[string]bool{}
+	for _, code := range ref.ErrorCodes {
+		codes[code.Name] = true
+	}
+	used := regexp.MustCompile(`newTaggedError\("([A-Z]+)"|"-([A-Z]+) `)
+	files, err := filepath.Glob("*.go")
+	assert.NoError(t, err)
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		data, err := ioutil.ReadFile(file)
+		assert.NoError(t, err)
+		for _, match := range used.FindAllStringSubmatch(string(data), -1) {
+			code := match[1] + match[2]
+			assert.True(t, codes[code], "%s returns %s which is missing from errorCodes", file, code)
+		}
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, ref.WriteMarkdown(&buf))
+	assert.Contains(t, buf.String(), "### `BACKUP`\n")
+	data, err := ioutil.ReadFile("../docs/protocol-reference.md")
+	assert.NoError(t, err)
+	assert.Equal(t, buf.String(), string(data), "docs/protocol-reference.md is stale, run `make generate`")
+}
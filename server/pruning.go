@@ -0,0 +1,194 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/contribsys/faktory/manager"
+	"github.com/contribsys/faktory/storage"
+	"github.com/contribsys/faktory/util"
+	"github.com/go-redis/redis"
+)
+
+/*
+ * Queues are created by the first job pushed to them and never go
+ * away, so producers with a queue per tenant leave thousands of empty
+ * queues behind.  Pruning removes the queues which have been empty
+ * and had no jobs pushed for a number of days:
+ *
+ * [pruning]
+ * days = 30                          # 0 disables pruning
+ * keep = ["critical", "reports-*"]   # never pruned, * matches a prefix
+ *
+ * The default queue and paused queues are never pruned.  A pruned
+ * queue is recorded in the changes feed and comes back as soon as
+ * another job is pushed to it.
+ */
+const (
+	// queue => the unix time of its last push
+	queuePushedKey = "queues:pushed"
+)
+
+type queuePruner struct {
+	store   storage.Store
+	rclient *redis.Client
+	server  *Server
+	now     func() time.Time
+
+	mu   sync.Mutex
+	days int
+	keep []string
+	// pushes since the last run, written to Redis by Execute
+	pushed map[string]int64
+	pruned int64
+}
+
+func PruningSubsystem() Subsystem {
+	return &queuePruner{now: time.Now, pushed: map[string]int64{}}
+}
+
+func (p *queuePruner) Start(s *Server) error {
+	p.server = s
+	p.store = s.Store()
+	p.rclient = s.Manager().Redis()
+	p.configure(s)
+
+	s.Manager().AddMiddleware("push", p.push)
+	s.AddTask(3600, p)
+	return nil
+}
+
+func (p *queuePruner) Reload(s *Server) error {
+	p.configure(s)
+	return nil
+}
+
+func (p *queuePruner) configure(s *Server) {
+	keep := []string{"default"}
+	if list, ok := s.Options.Config("pruning", "keep", nil).([]interface{}); ok {
+		for _, val := range list {
+			keep = append(keep, fmt.Sprintf("%v", val))
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.days = s.Options.Int("pruning", "days", 0)
+	p.keep = keep
+}
+
+func (p *queuePruner) push(next func() error, ctx manager.Context) error {
+	err := next()
+	if err == nil {
+		p.mu.Lock()
+		p.pushed[ctx.Job().Queue] = p.now().Unix()
+		p.mu.Unlock()
+	}
+	return err
+}
+
+// kept is true if the queue is never pruned
+func kept(keep []string, name string) bool {
+	for _, pattern := range keep {
+		if strings.HasSuffix(pattern, "*") {
+			if strings.HasPrefix(name, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *queuePruner) Name() string {
+	return "Pruning"
+}
+
+func (p *queuePruner) Stats() map[string]interface{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return map[string]interface{}{
+		"days":   p.days,
+		"pruned": p.pruned,
+	}
+}
+
+// Execute records the latest pushes and removes the queues
+// which have been idle too long
+func (p *queuePruner) Execute() error {
+	p.mu.Lock()
+	pushed := p.pushed
+	p.pushed = map[string]int64{}
+	days := p.days
+	keep := p.keep
+	p.mu.Unlock()
+
+	if len(pushed) > 0 {
+		_, err := p.rclient.Pipelined(func(pipe redis.Pipeliner) error {
+			for name, at := range pushed {
+				pipe.HSet(queuePushedKey, name, at)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if days <= 0 {
+		return nil
+	}
+
+	last, err := p.rclient.HGetAll(queuePushedKey).Result()
+	if err != nil {
+		return err
+	}
+	now := p.now().Unix()
+	idle := []string{}
+	unseen := []string{}
+	p.store.EachQueue(func(q storage.Queue) {
+		if kept(keep, q.Name()) || q.IsPaused() || q.Size() > 0 {
+			return
+		}
+		at, err := strconv.ParseInt(last[q.Name()], 10, 64)
+		if err != nil {
+			unseen = append(unseen, q.Name())
+			return
+		}
+		if now-at >= int64(days)*24*60*60 {
+			idle = append(idle, q.Name())
+		}
+	})
+
+	for _, name := range idle {
+		removed, err := p.store.RemoveQueue(name)
+		if err != nil {
+			return err
+		}
+		if !removed {
+			continue
+		}
+		err = p.rclient.HDel(queuePushedKey, name).Err()
+		if err != nil {
+			return err
+		}
+		util.Infof("Pruned queue %s, idle for %d days", name, days)
+		p.server.RecordChange(ChangeQueuePruned, name, map[string]interface{}{"days": days})
+		p.mu.Lock()
+		p.pruned++
+		p.mu.Unlock()
+	}
+
+	// no push has been seen since pruning was enabled,
+	// their idle time is counted from now
+	for _, name := range unseen {
+		err := p.rclient.HSetNX(queuePushedKey, name, now).Err()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPruning(t *testing.T) {
+	opts := &ServerOptions{GlobalConfig: map[string]interface{}{
+		"pruning": map[string]interface{}{
+			"days": 2,
+			"keep": []interface{}{"reports-*"},
+		},
+	}}
+	s, _, stop := newTestServer(t, opts)
+	defer stop()
+	s.Register(PruningSubsystem())
+	p := s.Subsystems[0].(*queuePruner)
+	now := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	p.now = func() time.Time { return now }
+	assert.NoError(t, p.Start(s))
+
+	for _, name := range []string{"default", "reports-daily", "tenant-1", "tenant-2", "tenant-3"} {
+		job := client.NewJob("Report", 1)
+		job.Queue = name
+		assert.NoError(t, s.manager.Push(job))
+		q, err := s.store.GetQueue(name)
+		assert.NoError(t, err)
+		_, err = q.Pop()
+		assert.NoError(t, err)
+	}
+	// a queue from before pruning was enabled
+	_, err := s.store.GetQueue("tenant-4")
+	assert.NoError(t, err)
+	paused, err := s.store.GetQueue("tenant-3")
+	assert.NoError(t, err)
+	assert.NoError(t, paused.Pause())
+	assert.NoError(t, p.Execute())
+
+	now = now.Add(36 * time.Hour)
+	job := client.NewJob("Report", 1)
+	job.Queue = "tenant-2"
+	assert.NoError(t, s.manager.Push(job))
+	assert.NoError(t, p.Execute())
+
+	now = now.Add(36 * time.Hour)
+	assert.NoError(t, p.Execute())
+
+	names := []string{}
+	s.store.EachQueue(func(q storage.Queue) {
+		names = append(names, q.Name())
+	})
+	assert.ElementsMatch(t, []string{"default", "reports-daily", "tenant-2", "tenant-3"}, names)
+	assert.EqualValues(t, 2, p.Stats()["pruned"])
+
+	feed, err := s.Changes(0, 10)
+	assert.NoError(t, err)
+	pruned := []string{}
+	for _, c := range feed.Changes {
+		if c.Kind == ChangeQueuePruned {
+			pruned = append(pruned, c.Subject)
+		}
+	}
+	assert.ElementsMatch(t, []string{"tenant-1", "tenant-4"}, pruned)
+
+	// it comes back when used
+	job = client.NewJob("Report", 1)
+	job.Queue = "tenant-1"
+	assert.NoError(t, s.manager.Push(job))
+	q, err := s.store.GetQueue("tenant-1")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, q.Size())
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+/*
+ * Results let a worker return a small JSON payload with its ACK so a
+ * producer waiting on the job can read it with RESULT GET, without a
+ * store of its own for request/response workflows:
+ *
+ *   ACK {"jid":"123456","result":{"url":"https://example.com/export.csv"}}
+ *   RESULT GET 123456
+ *
+ * Results expire after the TTL and larger results are rejected with
+ * TOOBIG, leaving the job reserved so it can be acknowledged without:
+ *
+ * [results]
+ * ttl = 3600          # seconds
+ * max_size = 65536    # bytes
+ */
+type results struct {
+	rclient *redis.Client
+	ttl     time.Duration
+	maxSize int
+}
+
+func ResultsSubsystem() Subsystem {
+	return &results{}
+}
+
+func (r *results) Start(s *Server) error {
+	r.rclient = s.Manager().Redis()
+	r.configure(s)
+	return nil
+}
+
+func (r *results) Reload(s *Server) error {
+	r.configure(s)
+	return nil
+}
+
+func (r *results) configure(s *Server) {
+	r.ttl = time.Duration(s.Options.Int("results", "ttl", 60*60)) * time.Second
+	r.maxSize = s.Options.Int("results", "max_size", 64*1024)
+}
+
+func resultKey(jid string) string {
+	return "result-" + jid
+}
+
+// check returns an error if the result can't be stored
+func (r *results) check(result json.RawMessage) error {
+	if len(result) > r.maxSize {
+		return newTaggedError("TOOBIG", fmt.Errorf("Result is %d bytes, the limit is %d", len(result), r.maxSize))
+	}
+	return nil
+}
+
+func (r *results) save(jid string, result json.RawMessage) error {
+	return r.rclient.Set(resultKey(jid), []byte(result), r.ttl).Err()
+}
+
+// get returns the job's result, nil if it has none or it has expired
+func (r *results) get(jid string) ([]byte, error) {
+	data, err := r.rclient.Get(resultKey(jid)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (s *Server) results() *results {
+	for _, x := range s.Subsystems {
+		if r, ok := x.(*results); ok {
+			return r
+		}
+	}
+	return nil
+}
+
+func result(c *Connection, s *Server, cmd string) {
+	r := s.results()
+	if r == nil {
+		c.Error(cmd, fmt.Errorf("Results are not enabled"))
+		return
+	}
+
+	if !strings.HasPrefix(cmd, "RESULT GET ") {
+		c.Error(cmd, fmt.Errorf("Invalid RESULT %s", cmd))
+		return
+	}
+	jid := strings.TrimSpace(cmd[11:])
+	if jid == "" {
+		c.Error(cmd, fmt.Errorf("Invalid RESULT %s", cmd))
+		return
+	}
+
+	data, err := r.get(jid)
+	if err != nil {
+		c.Error(cmd, err)
+		return
+	}
+	c.Result(data)
+}
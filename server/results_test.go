@@ -0,0 +1,40 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResults(t *testing.T) {
+	opts := &ServerOptions{GlobalConfig: map[string]interface{}{
+		"results": map[string]interface{}{"max_size": 32},
+	}}
+	s, run, stop := newTestServer(t, opts)
+	defer stop()
+
+	assert.Contains(t, run("RESULT GET 123456"), "not enabled")
+
+	s.Register(ResultsSubsystem())
+	assert.NoError(t, s.results().Start(s))
+
+	job := client.NewJob("Export", 1)
+	assert.NoError(t, s.manager.Push(job))
+	_, err := s.manager.Fetch(context.Background(), "", "default")
+	assert.NoError(t, err)
+
+	assert.Equal(t, "$-1", run("RESULT GET "+job.Jid))
+	assert.Contains(t, run("RESULT GET "), "Invalid RESULT")
+
+	// too big, the job stays reserved
+	res := run(fmt.Sprintf(`ACK {"jid":%q,"result":{"url":"https://example.com/exports/1.csv"}}`, job.Jid))
+	assert.Contains(t, res, "TOOBIG")
+	assert.Equal(t, 1, s.manager.WorkingCount())
+
+	assert.Equal(t, "+OK", run(fmt.Sprintf(`ACK {"jid":%q,"result":{"url":"/exports/1.csv"}}`, job.Jid)))
+	assert.Equal(t, 0, s.manager.WorkingCount())
+	assert.Equal(t, "$24\r\n"+`{"url":"/exports/1.csv"}`, run("RESULT GET "+job.Jid))
+}
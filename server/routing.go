@@ -0,0 +1,340 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/manager"
+	"github.com/contribsys/faktory/util"
+	"github.com/go-redis/redis"
+)
+
+/*
+ * Routing sends the jobs for certain queues to a linked Faktory
+ * server in another region so the data can be processed locally.
+ * The same config can be deployed to every region, queues routed
+ * to this server's own region are enqueued as usual.
+ *
+ * [routing]
+ * region = "us"
+ *
+ * [routing.links]
+ * us = "tcp://:password@faktory.us.example.com:7419"
+ * eu = "tcp://:password@faktory.eu.example.com:7419"
+ *
+ * [routing.queues]
+ * reports-eu = "eu"
+ * reports-us = "us"
+ *
+ * Producers may pick the region of a job with the "region" custom
+ * attribute, see client.Job.SetRegion, which overrides its queue's
+ * route.  Jobs with a "shard_key" are spread over the shards' regions
+ * by their key, the same key always going to the same region while
+ * the shards don't change:
+ *
+ * [routing]
+ * shards = ["us", "eu"]
+ *
+ * Routed jobs are stored in Redis and forwarded in order.  If a link
+ * is down or BUSY, jobs accumulate until it recovers.  Jobs rejected by
+ * the linked server are logged and dropped.
+ */
+type router struct {
+	mu      sync.RWMutex
+	region  string
+	links   map[string]*client.Server
+	queues  map[string]string
+	shards  []string
+	running map[string]bool
+
+	rclient *redis.Client
+}
+
+func RoutingSubsystem() Subsystem {
+	return &router{running: map[string]bool{}}
+}
+
+func forwardKey(link string) string {
+	return "forward-" + link
+}
+
+// External sends jobs outside the server
+func (r *router) External() {}
+
+func (r *router) Start(s *Server) error {
+	r.rclient = s.Manager().Redis()
+
+	err := r.configure(s)
+	if err != nil {
+		return err
+	}
+
+	s.Manager().AddMiddleware("push", r.push)
+	s.taskRunner.AddTask(60, r)
+	return nil
+}
+
+func (r *router) Reload(s *Server) error {
+	return r.configure(s)
+}
+
+func (r *router) configure(s *Server) error {
+	region := s.Options.String("routing", "region", "")
+
+	links := map[string]*client.Server{}
+	if mapp, ok := s.Options.Config("routing", "links", nil).(map[string]interface{}); ok {
+		for name, val := range mapp {
+			srv, err := remoteServer(fmt.Sprintf("%v", val))
+			if err != nil {
+				return fmt.Errorf("Invalid link %s: %v", name, err)
+			}
+			links[name] = srv
+		}
+	}
+
+	queues := map[string]string{}
+	if mapp, ok := s.Options.Config("routing", "queues", nil).(map[string]interface{}); ok {
+		for name, val := range mapp {
+			link := fmt.Sprintf("%v", val)
+			if link != region && links[link] == nil {
+				return fmt.Errorf("Queue %s is routed to unknown link %s", name, link)
+			}
+			queues[name] = link
+		}
+	}
+
+	shards := []string{}
+	if list, ok := s.Options.Config("routing", "shards", nil).([]interface{}); ok {
+		for _, val := range list {
+			shard := fmt.Sprintf("%v", val)
+			if shard != region && links[shard] == nil {
+				return fmt.Errorf("Shard %s is an unknown link", shard)
+			}
+			shards = append(shards, shard)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.region = region
+	r.links = links
+	r.queues = queues
+	r.shards = shards
+	for name := range links {
+		if name == region || r.running[name] {
+			continue
+		}
+		r.running[name] = true
+		name := name
+		s.Go(func(ctx context.Context) { r.forward(ctx, name) })
+	}
+	return nil
+}
+
+// route returns the link for the given queue or "" if the
+// queue should be processed locally
+func (r *router) route(queue string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	link, ok := r.queues[queue]
+	if !ok || link == r.region {
+		return ""
+	}
+	return link
+}
+
+// routeJob returns the link for the job, honoring its region and
+// shard key hints over its queue's route
+func (r *router) routeJob(job *client.Job) (string, error) {
+	region, _ := job.GetCustom(client.RegionAttribute)
+	name, _ := region.(string)
+	if name == "" {
+		key, _ := job.GetCustom(client.ShardKeyAttribute)
+		if shardKey, ok := key.(string); ok && shardKey != "" {
+			name = r.shard(shardKey)
+			if name != "" {
+				// pinned so the linked server keeps it
+				job.SetCustom(client.RegionAttribute, name)
+			}
+		}
+	}
+	if name == "" {
+		return r.route(job.Queue), nil
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	// hints are ignored by servers which don't route
+	if name == r.region || len(r.links) == 0 {
+		return "", nil
+	}
+	if r.links[name] == nil {
+		return "", fmt.Errorf("Unknown region %s", name)
+	}
+	return name, nil
+}
+
+// shard picks the key's region by rendezvous hashing so adding or
+// removing a shard only moves the keys of that shard
+func (r *router) shard(key string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var best string
+	var high uint64
+	for _, shard := range r.shards {
+		digest := sha256.Sum256([]byte(shard + "\x00" + key))
+		if sum := binary.BigEndian.Uint64(digest[:8]); best == "" || sum > high {
+			best, high = shard, sum
+		}
+	}
+	return best
+}
+
+func (r *router) link(name string) *client.Server {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.links[name]
+}
+
+func (r *router) push(next func() error, ctx manager.Context) error {
+	job := ctx.Job()
+	link, err := r.routeJob(job)
+	if err != nil {
+		return err
+	}
+	if link == "" {
+		return next()
+	}
+
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return r.rclient.LPush(forwardKey(link), data).Err()
+}
+
+// forward drains the link's backlog in order, the job is only
+// removed from Redis once the remote server has accepted it.
+func (r *router) forward(ctx context.Context, name string) {
+	var cl *client.Client
+	var connected *client.Server
+	key := forwardKey(name)
+
+	pause := func(d time.Duration) bool {
+		select {
+		case <-ctx.Done():
+			if cl != nil {
+				cl.Close()
+			}
+			return false
+		case <-time.After(d):
+			return true
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			if cl != nil {
+				cl.Close()
+			}
+			return
+		default:
+		}
+
+		srv := r.link(name)
+		if srv == nil {
+			// link removed from the config, keep its backlog
+			// in case it is added back
+			if !pause(5 * time.Second) {
+				return
+			}
+			continue
+		}
+
+		data, err := r.rclient.LIndex(key, -1).Result()
+		if err == redis.Nil {
+			if !pause(1 * time.Second) {
+				return
+			}
+			continue
+		}
+		if err != nil {
+			util.Warnf("Unable to read %s: %v", key, err)
+			if !pause(5 * time.Second) {
+				return
+			}
+			continue
+		}
+
+		if cl != nil && connected != srv {
+			cl.Close()
+			cl = nil
+		}
+		if cl == nil {
+			cl, err = srv.Open()
+			if err != nil {
+				util.Warnf("Unable to connect to link %s: %v", name, err)
+				cl = nil
+				if !pause(5 * time.Second) {
+					return
+				}
+				continue
+			}
+			connected = srv
+		}
+
+		var job client.Job
+		err = json.Unmarshal([]byte(data), &job)
+		if err == nil {
+			err = cl.Push(&job)
+			if err != nil {
+				code := client.ErrorCode(err)
+				if code == "" || code == client.CodeBusy {
+					// network error or busy link, retry the same job
+					util.ForJob(job.Jid, job.Queue).Warnf("Unable to forward %s to %s: %v", job.Jid, name, err)
+					cl.Close()
+					cl = nil
+					if !pause(5 * time.Second) {
+						return
+					}
+					continue
+				}
+			}
+		}
+		if err != nil {
+			// the job will never be accepted, don't block the link
+			util.Warnf("Dropping job for %s: %v", name, err)
+		}
+		r.rclient.RPop(key)
+	}
+}
+
+func (r *router) Name() string {
+	return "Routing"
+}
+
+// Execute is a no-op, the router is registered as a
+// task so the size of each link's backlog is included in INFO.
+func (r *router) Execute() error {
+	return nil
+}
+
+func (r *router) Stats() map[string]interface{} {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	sizes := map[string]interface{}{}
+	for name := range r.links {
+		if name != r.region {
+			sizes[name] = r.rclient.LLen(forwardKey(name)).Val()
+		}
+	}
+	return sizes
+}
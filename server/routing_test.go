@@ -0,0 +1,125 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouting(t *testing.T) {
+	s := &Server{Options: &ServerOptions{GlobalConfig: map[string]interface{}{
+		"routing": map[string]interface{}{
+			"region": "us",
+			"links": map[string]interface{}{
+				"us": "tcp://:secret@faktory.us.example.com:7419",
+			},
+			"queues": map[string]interface{}{
+				"reports-us": "us",
+			},
+		},
+	}}}
+
+	r := &router{running: map[string]bool{}}
+	err := r.configure(s)
+	assert.NoError(t, err)
+	assert.Equal(t, "", r.route("reports-us"))
+	assert.Equal(t, "", r.route("default"))
+	assert.Equal(t, "secret", r.link("us").Password)
+	// no forwarder for our own region
+	assert.Equal(t, 0, len(r.running))
+
+	s.Options.GlobalConfig["routing"].(map[string]interface{})["queues"] = map[string]interface{}{
+		"reports-eu": "eu",
+	}
+	err = r.configure(s)
+	assert.Error(t, err)
+}
+
+func TestRoutingHints(t *testing.T) {
+	s := &Server{Options: &ServerOptions{GlobalConfig: map[string]interface{}{
+		"routing": map[string]interface{}{
+			"region": "us",
+			"links": map[string]interface{}{
+				"us": "tcp://faktory.us.example.com:7419",
+				"eu": "tcp://faktory.eu.example.com:7419",
+				"ap": "tcp://faktory.ap.example.com:7419",
+			},
+			"queues": map[string]interface{}{
+				"reports-eu": "eu",
+			},
+			"shards": []interface{}{"us", "eu", "ap"},
+		},
+	}}}
+
+	// no forwarders in tests
+	r := &router{running: map[string]bool{"eu": true, "ap": true}}
+	assert.NoError(t, r.configure(s))
+
+	route := func(job *client.Job) string {
+		link, err := r.routeJob(job)
+		assert.NoError(t, err)
+		return link
+	}
+
+	job := client.NewJob("Report", 1)
+	job.Queue = "reports-eu"
+	assert.Equal(t, "eu", route(job))
+	job.SetRegion("us")
+	assert.Equal(t, "", route(job))
+	job.SetRegion("ap")
+	assert.Equal(t, "ap", route(job))
+	job.SetRegion("mars")
+	_, err := r.routeJob(job)
+	assert.EqualError(t, err, "Unknown region mars")
+
+	// every key goes to the same shard each time and
+	// the keys are spread over the shards
+	counts := map[string]int{}
+	for i := 0; i < 300; i++ {
+		key := fmt.Sprintf("tenant-%d", i)
+		job := client.NewJob("Report", 1)
+		job.SetShardKey(key)
+		link := route(job)
+		region, _ := job.GetCustom(client.RegionAttribute)
+		assert.Equal(t, r.shard(key), region)
+		if region == "us" {
+			assert.Equal(t, "", link)
+		} else {
+			assert.Equal(t, region, link)
+		}
+		// pinned, the region is kept when it's pushed again
+		assert.Equal(t, link, route(job))
+		counts[r.shard(key)]++
+	}
+	assert.Equal(t, 3, len(counts))
+	for shard, count := range counts {
+		assert.True(t, count > 50, "%s has %d keys", shard, count)
+	}
+
+	// dropping a shard only moves its keys
+	before := map[string]string{}
+	for i := 0; i < 300; i++ {
+		key := fmt.Sprintf("tenant-%d", i)
+		before[key] = r.shard(key)
+	}
+	s.Options.GlobalConfig["routing"].(map[string]interface{})["shards"] = []interface{}{"us", "eu"}
+	assert.NoError(t, r.configure(s))
+	for key, shard := range before {
+		if shard != "ap" {
+			assert.Equal(t, shard, r.shard(key))
+		}
+	}
+
+	s.Options.GlobalConfig["routing"].(map[string]interface{})["shards"] = []interface{}{"us", "mars"}
+	assert.Error(t, r.configure(s))
+
+	// hints are ignored without routing
+	r = &router{running: map[string]bool{}}
+	s.Options.GlobalConfig = map[string]interface{}{}
+	assert.NoError(t, r.configure(s))
+	job = client.NewJob("Report", 1)
+	job.SetRegion("eu")
+	assert.Equal(t, "", route(job))
+}
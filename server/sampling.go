@@ -0,0 +1,284 @@
+package server
+
+import (
+	"encoding/json"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/manager"
+	"github.com/contribsys/faktory/util"
+	"github.com/go-redis/redis"
+)
+
+/*
+ * Sampling keeps the full payloads of a random sample of jobs as they
+ * are fetched, acknowledged and failed so production data issues can
+ * be debugged from the Web UI's Samples page without logging every job:
+ *
+ * [sampling]
+ * rate = 0.001    # fraction of fetched jobs, 0 disables sampling
+ * hours = 24      # how long samples are kept
+ *
+ * Payloads are stored as-is, don't sample jobs whose arguments
+ * must not be persisted.
+ */
+const (
+	samplesKey = "server:samples"
+	// samples waiting for an ACK or FAIL are forgotten after a day
+	maxSamplePending = 24 * time.Hour
+)
+
+type SampleEvent struct {
+	At      time.Time       `json:"at"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+type Sample struct {
+	Jid     string       `json:"jid"`
+	Fetched *SampleEvent `json:"fetched"`
+	Acked   *SampleEvent `json:"acked,omitempty"`
+	Failed  *SampleEvent `json:"failed,omitempty"`
+
+	// Job is the payload as fetched
+	Job *client.Job `json:"-"`
+}
+
+type sampler struct {
+	rclient *redis.Client
+
+	mu        sync.Mutex
+	rate      float64
+	retention time.Duration
+	// jids sampled at fetch, waiting for their ACK or FAIL
+	pending map[string]time.Time
+}
+
+func SamplingSubsystem() Subsystem {
+	return &sampler{pending: map[string]time.Time{}}
+}
+
+func (sm *sampler) Start(s *Server) error {
+	sm.rclient = s.Manager().Redis()
+	sm.configure(s)
+
+	s.Manager().AddMiddleware("fetch", sm.fetched)
+	s.Manager().AddMiddleware("ack", sm.finished("acked"))
+	s.Manager().AddMiddleware("fail", sm.finished("failed"))
+	return nil
+}
+
+func (sm *sampler) Reload(s *Server) error {
+	sm.configure(s)
+	return nil
+}
+
+func (sm *sampler) configure(s *Server) {
+	rate := s.Options.Float("sampling", "rate", 0)
+	if rate < 0 || rate > 1 {
+		util.Warnf("Invalid sampling rate %v, must be between 0 and 1", rate)
+		rate = 0
+	}
+	hours := s.Options.Int("sampling", "hours", 24)
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if rate > 0 && sm.rate != rate {
+		util.Infof("Sampling %v%% of fetched jobs for %d hours", rate*100, hours)
+	}
+	sm.rate = rate
+	sm.retention = time.Duration(hours) * time.Hour
+}
+
+func (sm *sampler) settings() (float64, time.Duration) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return sm.rate, sm.retention
+}
+
+func sampleKey(jid string) string {
+	return "sample-" + jid
+}
+
+func (sm *sampler) fetched(next func() error, ctx manager.Context) error {
+	err := next()
+	rate, retention := sm.settings()
+	if err != nil || rate == 0 || rand.Float64() >= rate {
+		return err
+	}
+
+	job := ctx.Job()
+	now := time.Now()
+	err = sm.record(job, "fetched", now, retention, func(pipe redis.Pipeliner) {
+		pipe.ZAdd(samplesKey, redis.Z{Score: float64(now.Unix()), Member: job.Jid})
+		pipe.ZRemRangeByScore(samplesKey, "-inf", "("+strconv.FormatInt(now.Add(-retention).Unix(), 10))
+	})
+	if err != nil {
+		util.ForJob(job.Jid, job.Queue).Warnf("Unable to sample %s: %v", job.Jid, err)
+		return nil
+	}
+
+	sm.mu.Lock()
+	for jid, at := range sm.pending {
+		if now.Sub(at) > maxSamplePending {
+			delete(sm.pending, jid)
+		}
+	}
+	sm.pending[job.Jid] = now
+	sm.mu.Unlock()
+	return nil
+}
+
+// finished records the payload of a sampled job once it has
+// been acknowledged or failed.
+func (sm *sampler) finished(field string) manager.MiddlewareFunc {
+	return func(next func() error, ctx manager.Context) error {
+		err := next()
+		if err != nil {
+			return err
+		}
+
+		job := ctx.Job()
+		sm.mu.Lock()
+		_, ok := sm.pending[job.Jid]
+		delete(sm.pending, job.Jid)
+		sm.mu.Unlock()
+		if !ok {
+			return nil
+		}
+
+		_, retention := sm.settings()
+		err = sm.record(job, field, time.Now(), retention, nil)
+		if err != nil {
+			util.ForJob(job.Jid, job.Queue).Warnf("Unable to sample %s: %v", job.Jid, err)
+		}
+		return nil
+	}
+}
+
+func (sm *sampler) record(job *client.Job, field string, at time.Time, retention time.Duration, extra func(redis.Pipeliner)) error {
+	payload, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(&SampleEvent{At: at, Payload: payload})
+	if err != nil {
+		return err
+	}
+
+	_, err = sm.rclient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.HSet(sampleKey(job.Jid), field, data)
+		pipe.Expire(sampleKey(job.Jid), retention)
+		if extra != nil {
+			extra(pipe)
+		}
+		return nil
+	})
+	return err
+}
+
+func (sm *sampler) sample(jid string) (*Sample, error) {
+	fields, err := sm.rclient.HGetAll(sampleKey(jid)).Result()
+	if err != nil || fields["fetched"] == "" {
+		return nil, err
+	}
+
+	smp := &Sample{Jid: jid}
+	for name, dest := range map[string]**SampleEvent{
+		"fetched": &smp.Fetched,
+		"acked":   &smp.Acked,
+		"failed":  &smp.Failed,
+	} {
+		if fields[name] == "" {
+			continue
+		}
+		var evt SampleEvent
+		err = json.Unmarshal([]byte(fields[name]), &evt)
+		if err != nil {
+			return nil, err
+		}
+		*dest = &evt
+	}
+
+	var job client.Job
+	err = json.Unmarshal(smp.Fetched.Payload, &job)
+	if err != nil {
+		return nil, err
+	}
+	smp.Job = &job
+	return smp, nil
+}
+
+func (s *Server) sampler() *sampler {
+	for _, x := range s.Subsystems {
+		if sm, ok := x.(*sampler); ok {
+			return sm
+		}
+	}
+	return nil
+}
+
+// SamplingEnabled is true if sampled payloads may be available.
+func (s *Server) SamplingEnabled() bool {
+	sm := s.sampler()
+	if sm == nil {
+		return false
+	}
+	rate, _ := sm.settings()
+	return rate > 0 || sm.rclient.ZCard(samplesKey).Val() > 0
+}
+
+// Samples returns up to count sampled jobs, newest first, whose JID,
+// queue, jobtype or payloads contain the query.  The accept func
+// may skip samples, e.g. in queues the caller may not see.
+func (s *Server) Samples(query string, count int, accept func(*Sample) bool) ([]*Sample, error) {
+	sm := s.sampler()
+	if sm == nil {
+		return nil, nil
+	}
+	_, retention := sm.settings()
+	jids, err := sm.rclient.ZRangeByScore(samplesKey, redis.ZRangeBy{
+		Min: strconv.FormatInt(time.Now().Add(-retention).Unix(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	samples := []*Sample{}
+	for idx := len(jids) - 1; idx >= 0 && len(samples) < count; idx-- {
+		smp, err := sm.sample(jids[idx])
+		if err != nil {
+			return nil, err
+		}
+		if smp == nil || !smp.Matches(query) || (accept != nil && !accept(smp)) {
+			continue
+		}
+		samples = append(samples, smp)
+	}
+	return samples, nil
+}
+
+// Sample returns the sampled job with the given JID or nil.
+func (s *Server) Sample(jid string) (*Sample, error) {
+	sm := s.sampler()
+	if sm == nil {
+		return nil, nil
+	}
+	return sm.sample(jid)
+}
+
+func (smp *Sample) Matches(query string) bool {
+	if query == "" || smp.Jid == query || smp.Job.Queue == query || smp.Job.Type == query {
+		return true
+	}
+	for _, evt := range []*SampleEvent{smp.Fetched, smp.Acked, smp.Failed} {
+		if evt != nil && strings.Contains(string(evt.Payload), query) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/manager"
+	"github.com/stretchr/testify/assert"
+)
+
+type jobCtx struct {
+	context.Context
+	job *client.Job
+}
+
+func (c jobCtx) Job() *client.Job {
+	return c.job
+}
+
+func (c jobCtx) Manager() manager.Manager {
+	return nil
+}
+
+func TestSampling(t *testing.T) {
+	s, _, stop := newTestServer(t, nil)
+	defer stop()
+
+	assert.Nil(t, s.sampler())
+	s.Register(SamplingSubsystem())
+	sm := s.sampler()
+	sm.rclient = s.store.Redis()
+	sm.retention = time.Hour
+	assert.False(t, s.SamplingEnabled())
+
+	ok := func() error { return nil }
+	skipped := client.NewJob("Invoice", 1)
+	skipped.Queue = "billing"
+	assert.NoError(t, sm.fetched(ok, jobCtx{context.Background(), skipped}))
+
+	sm.rate = 1
+	assert.True(t, s.SamplingEnabled())
+	acked := client.NewJob("Invoice", 2, "eur")
+	acked.Queue = "billing"
+	assert.NoError(t, sm.fetched(ok, jobCtx{context.Background(), acked}))
+	acked.Annotations = map[string]string{"total": "42"}
+	assert.NoError(t, sm.finished("acked")(ok, jobCtx{context.Background(), acked}))
+
+	failed := client.NewJob("Email", "bob@example.com")
+	assert.NoError(t, sm.fetched(ok, jobCtx{context.Background(), failed}))
+	failed.Failure = &client.Failure{ErrorMessage: "bounced"}
+	assert.NoError(t, sm.finished("failed")(ok, jobCtx{context.Background(), failed}))
+	// an ACK for a job which wasn't sampled is ignored
+	assert.NoError(t, sm.finished("acked")(ok, jobCtx{context.Background(), skipped}))
+	assert.Equal(t, 0, len(sm.pending))
+
+	samples, err := s.Samples("", 10, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(samples))
+
+	smp, err := s.Sample(acked.Jid)
+	assert.NoError(t, err)
+	assert.Equal(t, "billing", smp.Job.Queue)
+	assert.NotNil(t, smp.Acked)
+	assert.Nil(t, smp.Failed)
+	assert.Contains(t, string(smp.Acked.Payload), `"total":"42"`)
+	assert.NotContains(t, string(smp.Fetched.Payload), `"total"`)
+
+	samples, err = s.Samples("bounced", 10, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(samples))
+	assert.Equal(t, failed.Jid, samples[0].Jid)
+	assert.NotNil(t, samples[0].Failed)
+
+	samples, err = s.Samples("billing", 10, func(smp *Sample) bool { return smp.Job.Type != "Invoice" })
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(samples))
+
+	smp, err = s.Sample(skipped.Jid)
+	assert.NoError(t, err)
+	assert.Nil(t, smp)
+}
@@ -2,8 +2,11 @@ package server
 
 import (
 	"bufio"
+	"context"
+	cryptorand "crypto/rand"
 	"crypto/sha256"
-	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"math/rand"
@@ -33,13 +36,31 @@ type Server struct {
 	Stats      *RuntimeStats
 	Subsystems []Subsystem
 
-	listener   net.Listener
+	listeners  []net.Listener
+	bound      []string
+	admin      net.Listener
+	adminBound string
+	tcp        *client.TCPOptions
+	auth       AuthProvider
+	tls        *tls.Config
+	certs      *tlsFiles
+	spiffe     *spiffeMapper
+	pool       *connPool
 	store      storage.Store
 	manager    manager.Manager
 	workers    *workers
 	taskRunner *taskRunner
+	boot       *BootSummary
+	deadlines  deadlineStats
+	prefetched prefetchBuffers
+	hooks      lifecycleHooks
 	mu         sync.Mutex
-	stopper    chan bool
+	ctx        context.Context
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+	draining   int32
+	paused     int32
+	maint      int32
 	closed     bool
 }
 
@@ -50,14 +71,20 @@ func NewServer(opts *ServerOptions) (*Server, error) {
 	if opts.StorageDirectory == "" {
 		return nil, fmt.Errorf("empty storage directory")
 	}
+	if opts.Startup == nil {
+		opts.Startup = NewStartup(StartupListeners)
+	}
 
 	s := &Server{
 		Options:    opts,
 		Stats:      &RuntimeStats{StartedAt: time.Now()},
 		Subsystems: []Subsystem{},
 
-		stopper: make(chan bool),
-		closed:  false,
+		closed: false,
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	if opts.Maintenance {
+		s.SetMaintenance(true)
 	}
 
 	return s, nil
@@ -76,12 +103,49 @@ func (s *Server) Manager() manager.Manager {
 }
 
 func (s *Server) Reload() {
+	err := s.rebind()
+	if err != nil {
+		util.Warnf("Unable to reopen the command port, keeping the previous bindings: %v", err)
+	}
+	s.configureBreaker()
+	err = s.configureFetch()
+	if err != nil {
+		util.Warnf("Unable to reload fetch strategy, keeping the previous one: %v", err)
+	}
+	err = s.reloadTLS()
+	if err != nil {
+		util.Warnf("Unable to reload TLS certificates, keeping the previous ones: %v", err)
+	}
+	auth, err := newAuthProvider(s)
+	if err == nil {
+		err = s.requireTLS(auth, s.tlsConfig() != nil)
+	}
+	if err != nil {
+		util.Warnf("Unable to reload auth provider, keeping the previous one: %v", err)
+	} else {
+		s.mu.Lock()
+		s.auth = auth
+		s.mu.Unlock()
+	}
+	if s.spiffe != nil {
+		spiffe, err := newSpiffeMapper(s)
+		if err != nil || spiffe == nil {
+			util.Warnf("Unable to reload SPIFFE IDs, keeping the previous ones: %v", err)
+		} else {
+			s.mu.Lock()
+			s.spiffe = spiffe
+			s.mu.Unlock()
+		}
+	}
 	for _, x := range s.Subsystems {
 		err := x.Reload(s)
 		if err != nil {
 			util.Warnf("Subsystem %v returned reload error: %v", x, err)
 		}
 	}
+	for _, fn := range s.lifecycle().reload {
+		fn(s)
+	}
 }
 
 func (s *Server) AddTask(everySec int64, task Taskable) {
@@ -89,29 +153,333 @@ func (s *Server) AddTask(everySec int64, task Taskable) {
 }
 
 func (s *Server) Boot() error {
+	if s.Options.Bool("faktory", "fips", false) {
+		client.SetRestrictedCrypto(true)
+	}
+	if client.RestrictedCrypto() {
+		util.Info("Restricted crypto mode, only FIPS 140-2 approved algorithms will be used")
+	}
+
+	auth, err := newAuthProvider(s)
+	if err != nil {
+		return err
+	}
+	tf, err := newTLSFiles(s)
+	if err != nil {
+		return err
+	}
+	err = s.requireTLS(auth, tf != nil)
+	if err != nil {
+		return err
+	}
+	spiffe, err := newSpiffeMapper(s)
+	if err != nil {
+		return err
+	}
+	if spiffe != nil && (tf == nil || tf.ca == "") {
+		return fmt.Errorf("[spiffe] requires [tls] with a client_ca trust bundle")
+	}
+
 	store, err := storage.Open("redis", s.Options.RedisSock)
 	if err != nil {
 		return err
 	}
 
-	listener, err := net.Listen("tcp", s.Options.Binding)
+	s.Options.Startup.Begin(StartupListeners)
+	listeners, err := listen(s.Options.Bindings(), s.socketMode())
 	if err != nil {
+		s.Options.Startup.Finish(StartupListeners, err)
 		store.Close()
 		return err
 	}
 
+	var admin net.Listener
+	if s.Options.AdminBinding != "" {
+		admin, err = net.Listen("tcp", s.Options.AdminBinding)
+		if err != nil {
+			s.Options.Startup.Finish(StartupListeners, err)
+			closeAll(listeners)
+			store.Close()
+			return err
+		}
+	}
+	s.Options.Startup.Finish(StartupListeners, nil)
+
 	s.mu.Lock()
 	s.store = store
 	s.workers = newWorkers()
+	s.workers.reaped = s.releasePrefetched
 	s.manager = manager.NewManager(store)
-	s.listener = listener
-	s.stopper = make(chan bool)
+	s.listeners = listeners
+	s.bound = s.Options.Bindings()
+	s.admin = admin
+	s.adminBound = s.Options.AdminBinding
+	s.tcp = s.tcpOptions()
+	s.auth = auth
+	s.spiffe = spiffe
+	if tf != nil {
+		s.tls = tf.serverConfig()
+		s.certs = tf
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.configureBreaker()
+	err = s.configureFetch()
+	if err == nil {
+		s.boot, err = s.summarizeBoot()
+	}
+	if err != nil {
+		s.mu.Unlock()
+		closeAll(listeners)
+		if admin != nil {
+			admin.Close()
+		}
+		store.Close()
+		return err
+	}
+	s.boot.log()
+	err = s.restoreSnapshot()
+	if err != nil {
+		util.Warnf("Unable to restore shutdown snapshot: %v", err)
+	}
 	s.startTasks()
 	s.mu.Unlock()
 
+	for _, fn := range s.lifecycle().boot {
+		err := fn(s)
+		if err != nil {
+			s.cancel()
+			s.Wait()
+			closeAll(listeners)
+			if admin != nil {
+				admin.Close()
+			}
+			store.Close()
+			return err
+		}
+	}
+	s.RecordChange(ChangeStarted, "", map[string]interface{}{
+		"version":     client.Version,
+		"maintenance": s.InMaintenance(),
+	})
 	return nil
 }
 
+// listen opens a listener for each binding, closing them
+// all if any binding fails
+func listen(bindings []string, mode os.FileMode) ([]net.Listener, error) {
+	listeners := make([]net.Listener, 0, len(bindings))
+	for _, binding := range bindings {
+		var listener net.Listener
+		var err error
+		if path := strings.TrimPrefix(binding, "unix:"); path != binding {
+			listener, err = listenUnix(path, mode)
+		} else {
+			listener, err = net.Listen("tcp", binding)
+		}
+		if err != nil {
+			closeAll(listeners)
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+/*
+ * Co-located workers may connect to a Unix domain socket, e.g.
+ * -b unix:/var/run/faktory.sock.  Connections to the socket skip
+ * TLS and authentication, access is controlled by the socket's
+ * permissions:
+ *
+ * [faktory]
+ * socket_mode = "0660"
+ */
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	// remove the socket left behind by a crash, never a regular file
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(path, mode)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+/*
+ * Reload reopens the command and admin ports when their bindings
+ * change.  Listeners for new bindings are opened before those for
+ * removed bindings are closed, so a binding which can't be opened
+ * leaves the ports as they were.  Connections already accepted are
+ * unaffected and continue until the client disconnects.
+ */
+func (s *Server) rebind() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || s.bound == nil {
+		return nil
+	}
+
+	// the options reflect the ports as they are
+	restore := func() {
+		s.Options.Binding = strings.Join(s.bound, ",")
+		s.Options.AdminBinding = s.adminBound
+	}
+	previous := map[string]net.Listener{}
+	for idx, binding := range s.bound {
+		previous[binding] = s.listeners[idx]
+	}
+	bindings := s.Options.Bindings()
+	listeners := make([]net.Listener, len(bindings))
+	opened := []net.Listener{}
+	for idx, binding := range bindings {
+		if listener, ok := previous[binding]; ok {
+			listeners[idx] = listener
+			delete(previous, binding)
+			continue
+		}
+		ls, err := listen([]string{binding}, s.socketMode())
+		if err != nil {
+			closeAll(opened)
+			restore()
+			return err
+		}
+		listeners[idx] = ls[0]
+		opened = append(opened, ls[0])
+	}
+
+	admin := s.admin
+	adminChanged := s.Options.AdminBinding != s.adminBound
+	if adminChanged {
+		admin = nil
+	}
+	if adminChanged && s.Options.AdminBinding != "" {
+		var err error
+		admin, err = net.Listen("tcp", s.Options.AdminBinding)
+		if err != nil {
+			closeAll(opened)
+			restore()
+			return err
+		}
+	}
+
+	for _, listener := range opened {
+		go s.serve(listener, false)
+	}
+	for binding, listener := range previous {
+		util.Infof("No longer listening at %s", binding)
+		listener.Close()
+	}
+	if len(opened) > 0 {
+		util.Infof("Now listening at %s", strings.Join(bindings, ", "))
+	}
+	if adminChanged {
+		if s.admin != nil {
+			s.admin.Close()
+		}
+		if admin != nil {
+			util.Infof("Admin commands are only available at %s", s.Options.AdminBinding)
+			go s.serve(admin, true)
+		} else {
+			util.Info("Admin commands are now available on the command port")
+		}
+	}
+	s.listeners = listeners
+	s.bound = bindings
+	s.admin = admin
+	s.adminBound = s.Options.AdminBinding
+	return nil
+}
+
+// adminOnly reports whether admin commands are restricted
+// to the admin binding
+func (s *Server) adminOnly() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.admin != nil
+}
+
+func (s *Server) socketMode() os.FileMode {
+	val := s.Options.String("faktory", "socket_mode", "0660")
+	mode, err := strconv.ParseUint(val, 8, 32)
+	if err != nil {
+		util.Warnf("Invalid socket_mode %q, using 0660", val)
+		return os.FileMode(0660)
+	}
+	return os.FileMode(mode)
+}
+
+func closeAll(listeners []net.Listener) {
+	for _, listener := range listeners {
+		listener.Close()
+	}
+}
+
+// see manager/breaker.go for the [storage] options
+func (s *Server) configureBreaker() {
+	s.manager.Breaker().Configure(
+		s.Options.Int("storage", "retries", 2),
+		s.Options.Int("storage", "breaker_threshold", 5),
+		time.Duration(s.Options.Int("storage", "breaker_cooldown", 10))*time.Second)
+}
+
+/*
+ * How FETCH waits for a job when the worker's queues are empty:
+ *
+ * [fetch]
+ * strategy = "brpop"      # or "script", see manager/fetch.go
+ * poll_interval = 100     # milliseconds between polls with "script"
+ * timeout = 2             # seconds before an empty FETCH returns
+ * max_timeout = 30        # the longest timeout FETCH may ask for
+ * max_prefetch = 10       # the most jobs held per worker, 0 disables
+ *
+ * "brpop" dispatches jobs with the lowest latency, "script" suits
+ * thousands of workers or workers fetching from many queues, a push
+ * wakes a worker polling its queue.  The timeout should stay below the
+ * FETCH deadline, which is extended for a FETCH which asks for a longer
+ * timeout with "timeout=<seconds>".
+ */
+func (s *Server) configureFetch() error {
+	return s.manager.SetFetchStrategy(
+		s.Options.String("fetch", "strategy", manager.FetchBlocking),
+		time.Duration(s.Options.Int("fetch", "poll_interval", 100))*time.Millisecond)
+}
+
+// fetchTimeout is how long a FETCH waits for a job, the requested
+// timeout up to the maximum or the default if 0
+func (s *Server) fetchTimeout(requested time.Duration) time.Duration {
+	if requested <= 0 {
+		return time.Duration(s.Options.Int("fetch", "timeout", 2)) * time.Second
+	}
+	if max := time.Duration(s.Options.Int("fetch", "max_timeout", 30)) * time.Second; requested > max {
+		return max
+	}
+	return requested
+}
+
+/*
+ * Accepted connections are tuned with the [tcp] options:
+ *
+ * [tcp]
+ * keepalive = 30       # seconds between keep-alive probes, -1 disables
+ * nodelay = true
+ * read_buffer = 0      # socket buffer sizes in bytes, 0 for the OS default
+ * write_buffer = 0
+ */
+func (s *Server) tcpOptions() *client.TCPOptions {
+	opts := client.DefaultTCPOptions()
+	opts.KeepAlive = time.Duration(s.Options.Int("tcp", "keepalive", 30)) * time.Second
+	opts.NoDelay = s.Options.Bool("tcp", "nodelay", opts.NoDelay)
+	opts.ReadBuffer = s.Options.Int("tcp", "read_buffer", 0)
+	opts.WriteBuffer = s.Options.Int("tcp", "write_buffer", 0)
+	return opts
+}
+
 func (s *Server) Run() error {
 	if s.store == nil {
 		panic("Server hasn't been booted")
@@ -124,47 +492,209 @@ func (s *Server) Run() error {
 		}
 	}
 
-	util.Infof("PID %d listening at %s, press Ctrl-C to stop", os.Getpid(), s.Options.Binding)
+	pool, err := newConnPool(s)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.pool = pool
+	listeners, admin := s.listeners, s.admin
+	bound, adminBound := s.bound, s.adminBound
+	s.mu.Unlock()
 
-	// this is the runtime loop for the command server
+	util.Infof("PID %d listening at %s, press Ctrl-C to stop", os.Getpid(), strings.Join(bound, ", "))
+	if admin != nil {
+		util.Infof("Admin commands are only available at %s", adminBound)
+		go s.serve(admin, true)
+	}
+	for _, listener := range listeners {
+		go s.serve(listener, false)
+	}
+
+	// the listeners may be replaced on reload, see rebind
+	<-s.Context().Done()
+	return nil
+}
+
+func (s *Server) serve(listener net.Listener, admin bool) {
 	for {
-		conn, err := s.listener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
-			return nil
+			return
 		}
-		go func(conn net.Conn) {
-			c := startConnection(conn, s)
-			if c == nil {
-				return
-			}
-			defer cleanupConnection(s, c)
-			s.processLines(c)
-		}(conn)
+		local := listener.Addr().Network() == "unix"
+		err = s.tcp.Apply(conn)
+		if err != nil {
+			util.Warnf("Unable to tune connection from %s: %v", conn.RemoteAddr(), err)
+		}
+		if cfg := s.tlsConfig(); cfg != nil && !local {
+			conn = tls.Server(conn, cfg)
+		}
+		go s.handle(conn, admin, local)
+	}
+}
+
+/*
+ * ServeConn speaks the protocol over a connection accepted elsewhere,
+ * e.g. a WebSocket to the Web UI, see client/websocket.go, returning
+ * once the client disconnects.  The client must authenticate as over
+ * TCP, TLS is the business of whoever accepted the connection.
+ */
+func (s *Server) ServeConn(conn net.Conn) {
+	if s.stopping() {
+		conn.Close()
+		return
+	}
+	s.handle(conn, false, false)
+}
+
+func (s *Server) handle(conn net.Conn, admin bool, local bool) {
+	c := startConnection(conn, s, local)
+	if c == nil {
+		return
+	}
+	c.admin = admin
+	if s.pool != nil && s.pool.add(conn, c) {
+		return
+	}
+	defer cleanupConnection(s, c)
+	s.processLines(c)
+}
+
+// stopping is true once Stop has been called
+func (s *Server) stopping() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// Bindings returns the addresses the command port is listening at,
+// which change when it's reopened on reload.
+func (s *Server) Bindings() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.bound == nil {
+		return s.Options.Bindings()
 	}
+	return append([]string(nil), s.bound...)
+}
+
+// Context is done once the server begins shutting down.  Background
+// work started with Go should return when it is.
+func (s *Server) Context() context.Context {
+	return s.ctx
 }
 
-func (s *Server) Stopper() chan bool {
-	return s.stopper
+// Shutdown begins shutting the server down, the process
+// waiting on Context then calls Stop.
+func (s *Server) Shutdown() {
+	s.cancel()
+}
+
+// Go runs fn in a goroutine which Wait, and so Stop, waits
+// for.  fn should return once ctx is done.
+func (s *Server) Go(fn func(ctx context.Context)) {
+	s.wg.Add(1)
+	ctx := s.ctx
+	go func() {
+		defer s.wg.Done()
+		fn(ctx)
+	}()
+}
+
+// Wait returns once every goroutine started with Go has returned.
+func (s *Server) Wait() {
+	s.wg.Wait()
 }
 
 func (s *Server) Stop(f func()) {
 	// Don't allow new network connections
 	s.mu.Lock()
 	s.closed = true
-	if s.listener != nil {
-		s.listener.Close()
+	closeAll(s.listeners)
+	if s.admin != nil {
+		s.admin.Close()
 	}
 	s.mu.Unlock()
+	s.cancel()
 
 	time.Sleep(100 * time.Millisecond)
 
 	if f != nil {
 		f()
 	}
+	for _, fn := range s.lifecycle().shutdown {
+		fn(s)
+	}
+	s.Wait()
 
+	s.RecordChange(ChangeStopped, "", nil)
+	err := s.saveSnapshot()
+	if err != nil {
+		util.Warnf("Unable to save shutdown snapshot: %v", err)
+	}
+	if s.pool != nil {
+		s.pool.close()
+	}
 	s.store.Close()
 }
 
+/*
+ * On shutdown the daemon gives workers time to finish the jobs they
+ * have fetched.  Workers are told to quiet and FETCH returns no jobs
+ * meanwhile.  Jobs still reserved after the timeout are returned to
+ * the front of their queues rather than left until their reservations
+ * expire:
+ *
+ * [faktory]
+ * shutdown_timeout = 25     # seconds, 0 requeues immediately
+ */
+func (s *Server) Drain() {
+	atomic.StoreInt32(&s.draining, 1)
+	s.workers.mu.RLock()
+	for _, worker := range s.workers.heartbeats {
+		worker.Signal(Quiet)
+	}
+	s.workers.mu.RUnlock()
+
+	timeout := time.Duration(s.Options.Int("faktory", "shutdown_timeout", 25)) * time.Second
+	deadline := time.Now().Add(timeout)
+	if count := s.manager.WorkingCount(); count > 0 && timeout > 0 {
+		util.Infof("Waiting up to %v for %d job(s) to finish", timeout, count)
+	}
+	for s.manager.WorkingCount() > 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	count, err := s.manager.RequeueWorking()
+	if err != nil {
+		util.Warnf("Unable to requeue reserved jobs: %v", err)
+	}
+	if count > 0 {
+		util.Infof("Requeued %d unfinished job(s)", count)
+	}
+}
+
+func (s *Server) isDraining() bool {
+	return atomic.LoadInt32(&s.draining) == 1
+}
+
+// PauseFetching stops FETCH returning jobs until ResumeFetching is
+// called, connections and pushes are unaffected.  Pausing the Windows
+// service pauses fetching.
+func (s *Server) PauseFetching() {
+	atomic.StoreInt32(&s.paused, 1)
+}
+
+// ResumeFetching undoes PauseFetching.
+func (s *Server) ResumeFetching() {
+	atomic.StoreInt32(&s.paused, 0)
+}
+
+func (s *Server) isPaused() bool {
+	return atomic.LoadInt32(&s.paused) == 1
+}
+
 func cleanupConnection(s *Server, c *Connection) {
 	//util.Debugf("Removing client connection %v", c)
 	s.workers.RemoveConnection(c)
@@ -181,27 +711,89 @@ func hash(pwd, salt string, iterations int) string {
 	return fmt.Sprintf("%x", hash)
 }
 
-func startConnection(conn net.Conn, s *Server) *Connection {
+// newSalt returns the salt for the password hash, from the
+// approved DRBG in restricted crypto mode
+func newSalt(restricted bool) (string, error) {
+	if !restricted {
+		return strconv.FormatInt(rand.Int63(), 16), nil
+	}
+	bytes := make([]byte, 16)
+	_, err := cryptorand.Read(bytes)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+// startConnection performs the handshake, local connections
+// arrive on a Unix socket and don't authenticate
+func startConnection(conn net.Conn, s *Server, local bool) *Connection {
 	// handshake must complete within 1 second
 	conn.SetDeadline(time.Now().Add(1 * time.Second))
 
 	// 4000 iterations is about 1ms on my 2016 MBP w/ 2.9Ghz Core i5
 	iter := rand.Intn(4096) + 4000
 
-	var salt string
+	s.mu.Lock()
+	auth := s.auth
+	spiffe := s.spiffe
+	s.mu.Unlock()
+	if local {
+		auth = nil
+	}
+	restricted := client.RestrictedCrypto()
+
+	var identity string
+	var scopes map[string]bool
+	if tconn, ok := conn.(*tls.Conn); ok {
+		err := tconn.Handshake()
+		if err != nil {
+			util.Infof("TLS handshake with %s failed: %v", conn.RemoteAddr(), err)
+			conn.Close()
+			return nil
+		}
+		if spiffe != nil {
+			identity, scopes, err = spiffe.scopes(tconn.ConnectionState())
+			if err != nil {
+				util.Infof("Refusing connection from %s: %v", conn.RemoteAddr(), err)
+				conn.Write([]byte("-ERR Unauthorized client certificate\r\n"))
+				conn.Close()
+				return nil
+			}
+		}
+	}
+	if spiffe != nil && scopes == nil && !local {
+		// e.g. a WebSocket, there's no SVID to scope it by
+		util.Infof("Refusing connection from %s without a client certificate", conn.RemoteAddr())
+		conn.Write([]byte("-ERR SPIFFE requires a client certificate\r\n"))
+		conn.Close()
+		return nil
+	}
+
+	salt, err := newSalt(restricted)
+	if err != nil {
+		util.Error("Unable to generate salt", err)
+		conn.Close()
+		return nil
+	}
+
 	conn.Write([]byte(`+HI {"v":2`))
-	if s.Options.Password != "" {
-		conn.Write([]byte(`,"i":`))
-		iters := strconv.FormatInt(int64(iter), 10)
-		conn.Write([]byte(iters))
-		salt = strconv.FormatInt(rand.Int63(), 16)
-		conn.Write([]byte(`,"s":"`))
-		conn.Write([]byte(salt))
-		conn.Write([]byte(`"}`))
-	} else {
-		conn.Write([]byte("}"))
+	if auth != nil {
+		switch mech := auth.Mechanism(); mech {
+		case MechanismPassword:
+			conn.Write([]byte(`,"i":`))
+			iters := strconv.FormatInt(int64(iter), 10)
+			conn.Write([]byte(iters))
+			conn.Write([]byte(`,"s":"`))
+			conn.Write([]byte(salt))
+			conn.Write([]byte(`"`))
+		default:
+			conn.Write([]byte(`,"a":"`))
+			conn.Write([]byte(mech))
+			conn.Write([]byte(`"`))
+		}
 	}
-	conn.Write([]byte("\r\n"))
+	conn.Write([]byte("}\r\n"))
 
 	buf := bufio.NewReader(conn)
 
@@ -227,22 +819,53 @@ func startConnection(conn net.Conn, s *Server) *Connection {
 		return nil
 	}
 
-	if s.Options.Password != "" {
+	if auth != nil {
 		if client.Version < 2 {
+			if restricted {
+				// v1 clients hash the password once
+				conn.Write([]byte("-ERR Protocol version 2 is required\r\n"))
+				conn.Close()
+				return nil
+			}
 			iter = 1
 		}
 
-		if subtle.ConstantTimeCompare([]byte(client.PasswordHash), []byte(hash(s.Options.Password, salt, iter))) != 1 {
-			conn.Write([]byte("-ERR Invalid password\r\n"))
+		creds := &Credentials{
+			Username:     client.Username,
+			Password:     client.Password,
+			Token:        client.Token,
+			PasswordHash: client.PasswordHash,
+			Salt:         salt,
+			Iterations:   iter,
+		}
+		// the client's secrets aren't kept with its heartbeat
+		client.Password = ""
+		client.Token = ""
+
+		if auth.Mechanism() != MechanismPassword {
+			conn.SetDeadline(time.Now().Add(AuthTimeout))
+		}
+		err = auth.Authenticate(creds)
+		if err != nil {
+			// provider errors may describe the identity server, log them
+			// rather than telling the client
+			util.Infof("Authentication failed for %s: %v", conn.RemoteAddr(), err)
+			if auth.Mechanism() == MechanismPassword {
+				conn.Write([]byte("-AUTH Invalid password\r\n"))
+			} else {
+				conn.Write([]byte("-AUTH Authentication failed\r\n"))
+			}
 			conn.Close()
 			return nil
 		}
 	}
 
 	cn := &Connection{
-		client: client,
-		conn:   conn,
-		buf:    buf,
+		client:   client,
+		conn:     conn,
+		buf:      buf,
+		identity: identity,
+		scopes:   scopes,
 	}
 
 	if client.Wid == "" {
@@ -268,40 +891,47 @@ func (s *Server) processLines(conn *Connection) {
 	atomic.AddUint64(&s.Stats.Connections, 1)
 	defer atomic.AddUint64(&s.Stats.Connections, ^uint64(0))
 
-	for {
-		cmd, e := conn.buf.ReadString('\n')
-		if e != nil {
-			if e != io.EOF {
-				util.Error("Unexpected socket error", e)
-			}
-			conn.Close()
-			return
-		}
-		if s.closed {
-			conn.Error("Closing connection", newTaggedError("SHUTDOWN", fmt.Errorf("Shutdown in progress")))
-			conn.Close()
-			return
-		}
-		cmd = strings.TrimSuffix(cmd, "\r\n")
-		cmd = strings.TrimSuffix(cmd, "\n")
-		//util.Debug(cmd)
-
-		idx := strings.Index(cmd, " ")
-		verb := cmd
-		if idx >= 0 {
-			verb = cmd[0:idx]
-		}
-		proc, ok := cmdSet[verb]
-		if !ok {
-			conn.Error(cmd, fmt.Errorf("Unknown command %s", verb))
-		} else {
-			atomic.AddUint64(&s.Stats.Commands, 1)
-			proc(conn, s, cmd)
-		}
-		if verb == "END" {
-			break
+	for s.processLine(conn) {
+	}
+}
+
+// processLine reads and executes the next command, returning false
+// once the connection has been closed
+func (s *Server) processLine(conn *Connection) bool {
+	cmd, e := conn.buf.ReadString('\n')
+	if e != nil {
+		if e != io.EOF {
+			util.Error("Unexpected socket error", e)
 		}
+		conn.Close()
+		return false
+	}
+	if s.stopping() {
+		conn.Error("Closing connection", newTaggedError("SHUTDOWN", fmt.Errorf("Shutdown in progress")))
+		conn.Close()
+		return false
+	}
+	cmd = strings.TrimSuffix(cmd, "\r\n")
+	cmd = strings.TrimSuffix(cmd, "\n")
+	//util.Debug(cmd)
+
+	idx := strings.Index(cmd, " ")
+	verb := cmd
+	if idx >= 0 {
+		verb = cmd[0:idx]
+	}
+	proc, ok := cmdSet[verb]
+	if !ok {
+		conn.Error(cmd, fmt.Errorf("Unknown command %s", verb))
+	} else if adminCommands[verb] && !conn.admin && s.adminOnly() {
+		conn.Error(cmd, newTaggedError("NOPERM", fmt.Errorf("%s is only available on the admin port", verb)))
+	} else if !conn.permitted(verb) {
+		conn.Error(cmd, newTaggedError("NOPERM", fmt.Errorf("%s may not use %s", conn.identity, verb)))
+	} else {
+		atomic.AddUint64(&s.Stats.Commands, 1)
+		s.execute(conn, verb, proc, cmd)
 	}
+	return verb != "END"
 }
 
 func (s *Server) uptimeInSeconds() int {
@@ -338,13 +968,18 @@ func (s *Server) CurrentState() (map[string]interface{}, error) {
 			"total_queues":    totalQueues,
 			"queues":          queues,
 			"tasks":           s.taskRunner.Stats(),
+			"breaker":         s.manager.Breaker().Stats(),
 		},
 		"server": map[string]interface{}{
-			"faktory_version": client.Version,
-			"uptime":          s.uptimeInSeconds(),
-			"connections":     atomic.LoadUint64(&s.Stats.Connections),
-			"command_count":   atomic.LoadUint64(&s.Stats.Commands),
-			"used_memory_mb":  util.MemoryUsage(),
+			"faktory_version":  client.Version,
+			"uptime":           s.uptimeInSeconds(),
+			"connections":      atomic.LoadUint64(&s.Stats.Connections),
+			"command_count":    atomic.LoadUint64(&s.Stats.Commands),
+			"missed_deadlines": s.deadlines.Stats(),
+			"used_memory_mb":   util.MemoryUsage(),
+			"boot":             s.boot,
+			"simulated":        s.Simulated(),
+			"maintenance":      s.InMaintenance(),
 		},
 	}, nil
 }
@@ -2,22 +2,28 @@ package server
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"math/rand"
 	"net"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/manager"
 	"github.com/contribsys/faktory/storage"
 	"github.com/stretchr/testify/assert"
 )
 
-func runServer(binding string, runner func()) {
-	dir := fmt.Sprintf("/tmp/%s", strings.Replace(binding, ":", "_", 1))
+func runServer(binding string, runner func(), configure ...func(*ServerOptions)) {
+	dir := fmt.Sprintf("/tmp/%s", strings.NewReplacer(":", "_", ",", "_", "/", "_").Replace(binding))
 	defer os.RemoveAll(dir)
 
 	sock := fmt.Sprintf("%s/test.sock", dir)
@@ -33,6 +39,9 @@ func runServer(binding string, runner func()) {
 		RedisSock:        sock,
 		ConfigDirectory:  os.ExpandEnv("$HOME/.faktory"),
 	}
+	for _, fn := range configure {
+		fn(opts)
+	}
 	s, err := NewServer(opts)
 	if err != nil {
 		panic(err)
@@ -52,6 +61,64 @@ func runServer(binding string, runner func()) {
 	s.Stop(nil)
 }
 
+type bufferConn struct {
+	bytes.Buffer
+}
+
+func (bc *bufferConn) Close() error {
+	return nil
+}
+
+// newTestServer returns a server backed by a flushed in-memory Redis,
+// without booting it, a function running commands as an anonymous
+// client and a function stopping Redis when the test is done.
+func newTestServer(t *testing.T, opts *ServerOptions) (*Server, func(string) string, func()) {
+	if opts == nil {
+		opts = &ServerOptions{}
+	}
+	if opts.StorageDirectory == "" {
+		opts.StorageDirectory = os.TempDir()
+	}
+	s, err := NewServer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sock := fmt.Sprintf("%s/faktory-%s.sock", os.TempDir(), strings.ToLower(t.Name()))
+	stopper, err := storage.BootMemory(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := storage.Open("redis", sock)
+	if err != nil {
+		stopper()
+		t.Fatal(err)
+	}
+	store.Flush()
+
+	s.store = store
+	s.manager = manager.NewManager(store)
+	s.workers = newWorkers()
+	s.taskRunner = newTaskRunner()
+	return s, commandRunner(s, nil), func() {
+		store.Close()
+		stopper()
+	}
+}
+
+// commandRunner returns a function running a command for client, as
+// if it was read from the client's connection, and returning the
+// trimmed response
+func commandRunner(s *Server, client *ClientData) func(string) string {
+	out := &bufferConn{}
+	c := &Connection{client: client, conn: out}
+	return func(cmd string) string {
+		out.Reset()
+		cmdSet[strings.SplitN(cmd, " ", 2)[0]](c, s, cmd)
+		return strings.TrimSpace(out.String())
+	}
+}
+
 func TestServerStart(t *testing.T) {
 	runServer("localhost:7420", func() {
 		conn, err := net.DialTimeout("tcp", "localhost:7420", 1*time.Second)
@@ -114,6 +181,16 @@ func TestServerStart(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, "+OK\r\n", result)
 
+		conn.Write([]byte(fmt.Sprintf("FAIL {\"jid\":\"%s\",\"message\":\"Invalid something\",\"errtype\":\"RuntimeError\"}\n", hash["jid"])))
+		result, err = buf.ReadString('\n')
+		assert.NoError(t, err)
+		assert.Equal(t, "-NOTFOUND Job not found 12345678901234567890abcd\r\n", result)
+
+		conn.Write([]byte("BEAT {\"wid\":\"bogus\"}\n"))
+		result, err = buf.ReadString('\n')
+		assert.NoError(t, err)
+		assert.Equal(t, "-NOTFOUND Unknown worker bogus\r\n", result)
+
 		conn.Write([]byte(fmt.Sprintf("INFO\n")))
 		_, err = buf.ReadString('\n')
 		assert.NoError(t, err)
@@ -124,6 +201,25 @@ func TestServerStart(t *testing.T) {
 		err = json.Unmarshal([]byte(result), &stats)
 		assert.NoError(t, err)
 		assert.Equal(t, 3, len(stats))
+		boot := stats["server"].(map[string]interface{})["boot"].(map[string]interface{})
+		assert.EqualValues(t, 0, boot["reclaimed"])
+
+		conn.Write([]byte("PUSH {\"jid\":\"scheduled5678901234567890\",\"jobtype\":\"Thing\",\"args\":[123],\"at\":\"2099-01-01T00:00:00Z\"}\n"))
+		result, err = buf.ReadString('\n')
+		assert.NoError(t, err)
+		assert.Equal(t, "+OK\r\n", result)
+
+		conn.Write([]byte("JOBS {\"set\":\"scheduled\",\"count\":10}\n"))
+		_, err = buf.ReadString('\n')
+		assert.NoError(t, err)
+		result, err = buf.ReadString('\n')
+		assert.NoError(t, err)
+		assert.Regexp(t, "scheduled5678901234567890", result)
+
+		conn.Write([]byte("JOBS {\"set\":\"bogus\"}\n"))
+		result, err = buf.ReadString('\n')
+		assert.NoError(t, err)
+		assert.Equal(t, "-ERR Unknown set bogus\r\n", result)
 
 		conn.Write([]byte("END\n"))
 		//result, err = buf.ReadString('\n')
@@ -135,6 +231,136 @@ func TestServerStart(t *testing.T) {
 
 }
 
+func TestAdminBinding(t *testing.T) {
+	command := func(addr string, cmd string) string {
+		conn, err := net.DialTimeout("tcp", addr, 1*time.Second)
+		assert.NoError(t, err)
+		defer conn.Close()
+		buf := bufio.NewReader(conn)
+
+		_, err = buf.ReadString('\n')
+		assert.NoError(t, err)
+		conn.Write([]byte("HELLO {\"v\":2}\r\n"))
+		result, err := buf.ReadString('\n')
+		assert.NoError(t, err)
+		assert.Equal(t, "+OK\r\n", result)
+
+		conn.Write([]byte(cmd + "\r\n"))
+		result, err = buf.ReadString('\n')
+		assert.NoError(t, err)
+		return result
+	}
+
+	runServer("localhost:7423", func() {
+		assert.Equal(t, "-NOPERM FLUSH is only available on the admin port\r\n", command("localhost:7423", "FLUSH"))
+		assert.Equal(t, "+OK\r\n", command("localhost:7424", "FLUSH"))
+	}, func(opts *ServerOptions) {
+		opts.AdminBinding = "localhost:7424"
+	})
+}
+
+func TestMultipleBindings(t *testing.T) {
+	runServer("localhost:7431,127.0.0.1:7432", func() {
+		for _, addr := range []string{"localhost:7431", "127.0.0.1:7432"} {
+			conn, err := net.DialTimeout("tcp", addr, 1*time.Second)
+			assert.NoError(t, err)
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			assert.NoError(t, err)
+			assert.Contains(t, line, "HI")
+			conn.Close()
+		}
+	})
+
+	opts := &ServerOptions{Binding: " 127.0.0.1:7419 ,,10.0.0.5:7419"}
+	assert.Equal(t, []string{"127.0.0.1:7419", "10.0.0.5:7419"}, opts.Bindings())
+	opts.Binding = ""
+	assert.Equal(t, []string{"localhost:7419"}, opts.Bindings())
+}
+
+func TestUnixSocket(t *testing.T) {
+	path := fmt.Sprintf("%s/faktory-unix-test.sock", os.TempDir())
+	runServer("localhost:7433,unix:"+path, func() {
+		info, err := os.Stat(path)
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+		// no password is needed on the socket
+		srv := client.DefaultServer()
+		srv.Network = "unix"
+		srv.Address = path
+		cl, err := srv.Open()
+		assert.NoError(t, err)
+		_, err = cl.Info()
+		assert.NoError(t, err)
+		cl.Close()
+
+		_, err = client.Dial(&client.Server{Network: "tcp", Address: "localhost:7433", Timeout: time.Second}, "")
+		assert.Error(t, err)
+	}, func(opts *ServerOptions) {
+		opts.Password = "secret"
+		opts.GlobalConfig = map[string]interface{}{
+			"faktory": map[string]interface{}{"socket_mode": "0600"},
+		}
+	})
+	_, err := os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestLifecycleHooks(t *testing.T) {
+	dir := "/tmp/faktory-test-lifecycle"
+	defer os.RemoveAll(dir)
+	sock := fmt.Sprintf("%s/test.sock", dir)
+	stopper, err := storage.BootRedis(dir, sock)
+	assert.NoError(t, err)
+	defer stopper()
+
+	var calls []string
+	s, err := NewServer(&ServerOptions{Binding: "localhost:7434", StorageDirectory: dir, RedisSock: sock})
+	assert.NoError(t, err)
+	s.OnBoot(func(s *Server) error {
+		assert.NotNil(t, s.Store())
+		calls = append(calls, "boot")
+		return nil
+	})
+	s.OnReload(func(s *Server) { calls = append(calls, "reload") })
+	s.OnShutdown(func(s *Server) {
+		assert.NoError(t, s.Store().Redis().Ping().Err())
+		calls = append(calls, "shutdown")
+	})
+	assert.NoError(t, s.Boot())
+	s.Reload()
+
+	drained := false
+	s.Go(func(ctx context.Context) {
+		<-ctx.Done()
+		time.Sleep(10 * time.Millisecond)
+		drained = true
+	})
+	s.Shutdown()
+	<-s.Context().Done()
+	s.Stop(nil)
+	assert.True(t, drained)
+	assert.Equal(t, []string{"boot", "reload", "shutdown"}, calls)
+
+	// a failed boot hook releases the port
+	s, err = NewServer(&ServerOptions{Binding: "localhost:7434", StorageDirectory: dir, RedisSock: sock})
+	assert.NoError(t, err)
+	s.OnBoot(func(s *Server) error { return fmt.Errorf("not ready") })
+	assert.EqualError(t, s.Boot(), "not ready")
+	listener, err := net.Listen("tcp", "localhost:7434")
+	assert.NoError(t, err)
+	listener.Close()
+}
+
+func TestMaxJobSize(t *testing.T) {
+	s := &Server{Options: &ServerOptions{GlobalConfig: map[string]interface{}{
+		"faktory": map[string]interface{}{"max_job_size": 64},
+	}}}
+	out := &bufferConn{}
+	push(&Connection{conn: out}, s, `PUSH {"jid":"12345678901234567890abcd","jobtype":"Thing","args":["`+strings.Repeat("x", 100)+`"]}`)
+	assert.Equal(t, "-TOOBIG Job is 164 bytes, the limit is 64\r\n", out.String())
+}
+
 func TestPasswordHashing(t *testing.T) {
 	iterations := 1545
 	pwd := "foobar"
@@ -144,6 +370,39 @@ func TestPasswordHashing(t *testing.T) {
 	assert.Equal(t, "6d877f8e5544b1f2598768f817413ab8a357afffa924dedae99eb91472d4ec30", result)
 }
 
+func TestRestrictedHandshake(t *testing.T) {
+	defer client.SetRestrictedCrypto(false)
+
+	runServer("localhost:7425", func() {
+		assert.True(t, client.RestrictedCrypto())
+
+		conn, err := net.DialTimeout("tcp", "localhost:7425", 1*time.Second)
+		assert.NoError(t, err)
+		defer conn.Close()
+		buf := bufio.NewReader(conn)
+		hi, err := buf.ReadString('\n')
+		assert.NoError(t, err)
+		// a 128-bit salt
+		assert.Regexp(t, `"s":"[0-9a-f]{32}"`, hi)
+
+		conn.Write([]byte(`HELLO {"pwdhash":"abc"}` + "\r\n"))
+		result, err := buf.ReadString('\n')
+		assert.NoError(t, err)
+		assert.Equal(t, "-ERR Protocol version 2 is required\r\n", result)
+
+		srv := client.DefaultServer()
+		srv.Address = "localhost:7425"
+		cl, err := client.Dial(srv, "foobar")
+		assert.NoError(t, err)
+		cl.Close()
+	}, func(opts *ServerOptions) {
+		opts.Password = "foobar"
+		opts.GlobalConfig = map[string]interface{}{
+			"faktory": map[string]interface{}{"fips": true},
+		}
+	})
+}
+
 func BenchmarkHash(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		// 1550 µs per call with 5545 iterations
@@ -154,3 +413,173 @@ func BenchmarkHash(b *testing.B) {
 		hash(pwd, salt, iterations)
 	}
 }
+
+func TestTCPOptions(t *testing.T) {
+	s := &Server{Options: &ServerOptions{GlobalConfig: map[string]interface{}{}}}
+	opts := s.tcpOptions()
+	assert.Equal(t, 30*time.Second, opts.KeepAlive)
+	assert.True(t, opts.NoDelay)
+	assert.Equal(t, 0, opts.ReadBuffer)
+
+	s.Options.GlobalConfig["tcp"] = map[string]interface{}{
+		"keepalive":    int64(-1),
+		"nodelay":      false,
+		"read_buffer":  int64(65536),
+		"write_buffer": int64(32768),
+	}
+	opts = s.tcpOptions()
+	assert.True(t, opts.KeepAlive < 0)
+	assert.False(t, opts.NoDelay)
+	assert.Equal(t, 65536, opts.ReadBuffer)
+	assert.Equal(t, 32768, opts.WriteBuffer)
+}
+
+func TestDrain(t *testing.T) {
+	s, _, stop := newTestServer(t, &ServerOptions{GlobalConfig: map[string]interface{}{
+		"faktory": map[string]interface{}{"shutdown_timeout": 1},
+	}})
+	defer stop()
+	worker := &ClientData{Wid: "worker", state: Running}
+	s.workers.heartbeats[worker.Wid] = worker
+
+	finished := client.NewJob("Finished", 1)
+	unfinished := client.NewJob("Unfinished", 2)
+	waiting := client.NewJob("Waiting", 3)
+	for _, job := range []*client.Job{finished, unfinished} {
+		assert.NoError(t, s.manager.Push(job))
+		fetched, err := s.manager.Fetch(context.Background(), worker.Wid, "default")
+		assert.NoError(t, err)
+		assert.Equal(t, job.Jid, fetched.Jid)
+	}
+	assert.NoError(t, s.manager.Push(waiting))
+	assert.EqualValues(t, 2, s.manager.WorkingCount())
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_, err := s.manager.Acknowledge(finished.Jid)
+		assert.NoError(t, err)
+	}()
+
+	start := time.Now()
+	s.Drain()
+	assert.True(t, time.Since(start) >= time.Second)
+	assert.True(t, worker.IsQuiet())
+	assert.EqualValues(t, 0, s.manager.WorkingCount())
+	assert.EqualValues(t, 0, s.store.Working().Size())
+
+	q, err := s.store.GetQueue("default")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, q.Size())
+	for _, expected := range []*client.Job{unfinished, waiting} {
+		data, err := q.Pop()
+		assert.NoError(t, err)
+		var job client.Job
+		assert.NoError(t, json.Unmarshal(data, &job))
+		assert.Equal(t, expected.Jid, job.Jid)
+	}
+
+	// FETCH returns no job, even for workers which haven't heartbeated
+	run := commandRunner(s, &ClientData{Wid: "late", state: Running})
+	assert.Equal(t, "$-1", run("FETCH default"))
+}
+
+func TestPauseFetching(t *testing.T) {
+	s, _, stop := newTestServer(t, nil)
+	defer stop()
+	job := client.NewJob("Paused", 1)
+	assert.NoError(t, s.manager.Push(job))
+	run := commandRunner(s, &ClientData{Wid: "worker", state: Running})
+
+	s.PauseFetching()
+	assert.Equal(t, "$-1", run("FETCH default"))
+
+	s.ResumeFetching()
+	assert.Contains(t, run("FETCH default"), job.Jid)
+}
+
+func TestMaintenance(t *testing.T) {
+	delay := MaintenanceDelay
+	MaintenanceDelay = 0
+	defer func() { MaintenanceDelay = delay }()
+
+	s, _, stop := newTestServer(t, &ServerOptions{Maintenance: true})
+	defer stop()
+	assert.True(t, s.InMaintenance())
+
+	// producers are unaffected
+	job := client.NewJob("Maintained", 1)
+	assert.NoError(t, s.manager.Push(job))
+	run := commandRunner(s, &ClientData{Wid: "worker", state: Running})
+	res := run("FETCH default")
+	assert.True(t, strings.HasPrefix(res, "-MAINTENANCE "), res)
+
+	res = run("MAINTENANCE SOON")
+	assert.True(t, strings.HasPrefix(res, "-ERR "), res)
+	assert.True(t, s.InMaintenance())
+
+	assert.Equal(t, "+OK", run("MAINTENANCE OFF"))
+	assert.False(t, s.InMaintenance())
+
+	assert.Contains(t, run("FETCH default"), job.Jid)
+}
+
+func TestRebind(t *testing.T) {
+	dir, err := ioutil.TempDir("", "faktory-rebind")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "test.sock")
+	stopper, err := storage.BootMemory(sock)
+	assert.NoError(t, err)
+	defer stopper()
+
+	s, err := NewServer(&ServerOptions{Binding: "localhost:7441", StorageDirectory: dir, RedisSock: sock})
+	assert.NoError(t, err)
+	assert.NoError(t, s.Boot())
+	go s.Run()
+	defer s.Stop(nil)
+
+	dial := func(network, addr string) error {
+		conn, err := net.DialTimeout(network, addr, time.Second)
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+	held, err := net.Dial("tcp", "localhost:7441")
+	assert.NoError(t, err)
+	defer held.Close()
+
+	unix := filepath.Join(dir, "faktory.sock")
+	s.Options.Binding = "localhost:7442,unix:" + unix
+	s.Options.AdminBinding = "localhost:7443"
+	s.Reload()
+	assert.Error(t, dial("tcp", "localhost:7441"))
+	assert.NoError(t, dial("tcp", "localhost:7442"))
+	assert.NoError(t, dial("unix", unix))
+	assert.NoError(t, dial("tcp", "localhost:7443"))
+	assert.True(t, s.adminOnly())
+
+	// connections accepted before are unaffected
+	hi, err := bufio.NewReader(held).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Contains(t, hi, "+HI")
+
+	// a binding which can't be opened keeps the previous ones
+	taken, err := net.Listen("tcp", "localhost:7444")
+	assert.NoError(t, err)
+	defer taken.Close()
+	s.Options.Binding = "localhost:7442,localhost:7444"
+	s.Options.AdminBinding = ""
+	s.Reload()
+	assert.Equal(t, "localhost:7442,unix:"+unix, s.Options.Binding)
+	assert.Equal(t, "localhost:7443", s.Options.AdminBinding)
+	assert.NoError(t, dial("unix", unix))
+	assert.True(t, s.adminOnly())
+
+	s.Options.Binding = "localhost:7442"
+	s.Options.AdminBinding = ""
+	s.Reload()
+	assert.Error(t, dial("unix", unix))
+	assert.Error(t, dial("tcp", "localhost:7443"))
+	assert.False(t, s.adminOnly())
+}
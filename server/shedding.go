@@ -0,0 +1,225 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/manager"
+)
+
+/*
+ * Load shedding rejects pushes to less important queues while storage
+ * is under pressure so critical queues keep accepting jobs, rather than
+ * every producer failing equally once Redis is full or unhealthy.
+ *
+ * [shedding]
+ * memory_mb = 0          # Redis memory budget, 0 uses Redis' maxmemory
+ * large_job = 65536      # bytes, larger jobs are shed earlier
+ * deep_queue = 100000    # jobs, pushes to deeper queues are shed earlier
+ *
+ * [shedding.tiers]       # the pressure at which each tier is shed
+ * low = 0.7
+ * normal = 0.85
+ *
+ * [shedding.queues]
+ * bulk = "low"
+ * reports = "low"
+ * default = "normal"
+ *
+ * Queues without a tier are never shed.  Pressure ranges from 0 to 1,
+ * it's Redis' memory use relative to the budget and 1 while the storage
+ * breaker isn't closed.  Large jobs and pushes to deep queues are each
+ * shed at 90% of their tier's threshold.  Shed pushes are rejected with
+ * BUSY so producers back off and retry.  Only PUSH is shed, scheduled
+ * jobs and retries are already stored and are always enqueued.
+ */
+type shedder struct {
+	mu        sync.RWMutex
+	queues    map[string]float64
+	budget    int64
+	largeJob  int
+	deepQueue uint64
+
+	// pressure * 1e6, updated by Execute
+	pressure int64
+	shed     int64
+	s        *Server
+}
+
+// shedFactor lowers the threshold for large jobs and deep queues
+const shedFactor = 0.9
+
+func SheddingSubsystem() Subsystem {
+	return &shedder{}
+}
+
+func (sh *shedder) Start(s *Server) error {
+	sh.s = s
+	err := sh.configure(s)
+	if err != nil {
+		return err
+	}
+
+	s.AddTask(5, sh)
+	return nil
+}
+
+func (sh *shedder) Reload(s *Server) error {
+	return sh.configure(s)
+}
+
+func (sh *shedder) configure(s *Server) error {
+	tiers := map[string]float64{}
+	if mapp, ok := s.Options.Config("shedding", "tiers", nil).(map[string]interface{}); ok {
+		for name, val := range mapp {
+			threshold, err := strconv.ParseFloat(fmt.Sprintf("%v", val), 64)
+			if err != nil || threshold <= 0 || threshold > 1 {
+				return fmt.Errorf("Shedding tier %s must be a pressure between 0 and 1, not %v", name, val)
+			}
+			tiers[name] = threshold
+		}
+	}
+
+	queues := map[string]float64{}
+	if mapp, ok := s.Options.Config("shedding", "queues", nil).(map[string]interface{}); ok {
+		for queue, val := range mapp {
+			tier := fmt.Sprintf("%v", val)
+			threshold, ok := tiers[tier]
+			if !ok {
+				return fmt.Errorf("Queue %s has an unknown shedding tier %q", queue, tier)
+			}
+			queues[queue] = threshold
+		}
+	}
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sh.queues = queues
+	sh.budget = int64(s.Options.Int("shedding", "memory_mb", 0)) * 1024 * 1024
+	sh.largeJob = s.Options.Int("shedding", "large_job", 64*1024)
+	sh.deepQueue = uint64(s.Options.Int("shedding", "deep_queue", 100000))
+	return nil
+}
+
+func (sh *shedder) Name() string {
+	return "Shedding"
+}
+
+// Execute samples the storage pressure
+func (sh *shedder) Execute() error {
+	pressure, err := sh.measure()
+	sh.setPressure(pressure)
+	return err
+}
+
+func (sh *shedder) Stats() map[string]interface{} {
+	return map[string]interface{}{
+		"pressure": sh.currentPressure(),
+		"shed":     atomic.LoadInt64(&sh.shed),
+	}
+}
+
+func (sh *shedder) setPressure(pressure float64) {
+	atomic.StoreInt64(&sh.pressure, int64(math.Min(pressure, 1)*1e6))
+}
+
+func (sh *shedder) currentPressure() float64 {
+	return float64(atomic.LoadInt64(&sh.pressure)) / 1e6
+}
+
+func (sh *shedder) measure() (float64, error) {
+	if sh.s.Manager().Breaker().State() != manager.BreakerClosed || !sh.s.Store().Available() {
+		return 1, nil
+	}
+
+	info, err := sh.s.Store().Redis().Info("memory").Result()
+	if err != nil {
+		return 1, err
+	}
+	used, budget := memoryUsage(info)
+	sh.mu.RLock()
+	if sh.budget > 0 {
+		budget = sh.budget
+	}
+	sh.mu.RUnlock()
+	if budget <= 0 {
+		return 0, nil
+	}
+	return float64(used) / float64(budget), nil
+}
+
+// memoryUsage returns used_memory and maxmemory from Redis' INFO
+func memoryUsage(info string) (int64, int64) {
+	var used, max int64
+	for _, line := range strings.Split(info, "\n") {
+		line = strings.TrimSpace(line)
+		if val := strings.TrimPrefix(line, "used_memory:"); val != line {
+			used, _ = strconv.ParseInt(val, 10, 64)
+		}
+		if val := strings.TrimPrefix(line, "maxmemory:"); val != line {
+			max, _ = strconv.ParseInt(val, 10, 64)
+		}
+	}
+	return used, max
+}
+
+// threshold returns the pressure at which pushes of the job are shed,
+// 0 if they never are.  The job's size and its queue's depth are only
+// checked if the pressure is high enough to matter.
+func (sh *shedder) threshold(job *client.Job, queue string, pressure float64) float64 {
+	sh.mu.RLock()
+	threshold, ok := sh.queues[queue]
+	largeJob, deepQueue := sh.largeJob, sh.deepQueue
+	sh.mu.RUnlock()
+	if !ok || pressure < threshold*shedFactor*shedFactor {
+		return threshold
+	}
+
+	if largeJob > 0 {
+		data, err := json.Marshal(job.Args)
+		if err == nil && len(data) > largeJob {
+			threshold *= shedFactor
+		}
+	}
+	if deepQueue > 0 {
+		q, err := sh.s.Store().GetQueue(queue)
+		if err == nil && q.Size() > deepQueue {
+			threshold *= shedFactor
+		}
+	}
+	return threshold
+}
+
+// admit returns an error if the job should be shed
+func (sh *shedder) admit(job *client.Job) error {
+	pressure := sh.currentPressure()
+	if pressure == 0 {
+		return nil
+	}
+
+	queue := job.Queue
+	if queue == "" {
+		queue = "default"
+	}
+	threshold := sh.threshold(job, queue, pressure)
+	if threshold > 0 && pressure >= threshold {
+		atomic.AddInt64(&sh.shed, 1)
+		return newTaggedError("BUSY", fmt.Errorf("Queue %s is shedding load, try again later", queue))
+	}
+	return nil
+}
+
+func (s *Server) shedder() *shedder {
+	for _, x := range s.Subsystems {
+		if sh, ok := x.(*shedder); ok {
+			return sh
+		}
+	}
+	return nil
+}
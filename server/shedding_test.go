@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSheddingConfig(t *testing.T) {
+	for _, bad := range []map[string]interface{}{
+		{"tiers": map[string]interface{}{"low": 1.5}},
+		{"tiers": map[string]interface{}{"low": 0.7}, "queues": map[string]interface{}{"bulk": "lowest"}},
+	} {
+		sh := &shedder{}
+		assert.Error(t, sh.configure(&Server{Options: &ServerOptions{GlobalConfig: map[string]interface{}{"shedding": bad}}}))
+	}
+
+	used, max := memoryUsage("# Memory\r\nused_memory:1024\r\nused_memory_human:1K\r\nmaxmemory:4096\r\n")
+	assert.EqualValues(t, 1024, used)
+	assert.EqualValues(t, 4096, max)
+}
+
+func TestShedding(t *testing.T) {
+	s, run, stop := newTestServer(t, &ServerOptions{GlobalConfig: map[string]interface{}{
+		"shedding": map[string]interface{}{
+			"large_job":  100,
+			"deep_queue": 3,
+			"tiers":      map[string]interface{}{"low": 0.7, "normal": 0.85},
+			"queues":     map[string]interface{}{"bulk": "low", "default": "normal"},
+		},
+	}})
+	defer stop()
+	assert.Nil(t, s.shedder())
+	s.Register(SheddingSubsystem())
+	sh := s.shedder()
+	sh.s = s
+	assert.NoError(t, sh.configure(s))
+
+	push := func(queue string, args ...interface{}) string {
+		job := client.NewJob("Thing", args...)
+		job.Queue = queue
+		data, err := json.Marshal(job)
+		assert.NoError(t, err)
+		return run("PUSH " + string(data))
+	}
+	assert.Equal(t, "+OK", push("bulk", 1))
+	assert.Equal(t, "+OK", push("default", 1))
+
+	sh.setPressure(0.75)
+	assert.Equal(t, "-BUSY Queue bulk is shedding load, try again later", push("bulk", 1))
+	assert.Equal(t, "+OK", push("default", 1))
+	assert.Equal(t, "+OK", push("critical", 1))
+
+	// large jobs and deep queues are shed at 90% of the threshold
+	sh.setPressure(0.8)
+	assert.Equal(t, "+OK", push("", 1))
+	assert.Equal(t, "-BUSY Queue default is shedding load, try again later", push("", strings.Repeat("x", 200)))
+	assert.Equal(t, "+OK", push("default", 1))
+	assert.Equal(t, "-BUSY Queue default is shedding load, try again later", push("default", 1))
+
+	sh.setPressure(1)
+	assert.Equal(t, "+OK", push("critical", strings.Repeat("x", 200)))
+	assert.EqualValues(t, 3, sh.Stats()["shed"])
+
+	// the breaker is closed and the memory storage has no maxmemory
+	assert.NoError(t, sh.Execute())
+	assert.Equal(t, float64(0), sh.currentPressure())
+}
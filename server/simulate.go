@@ -0,0 +1,33 @@
+package server
+
+import "github.com/contribsys/faktory/util"
+
+/*
+ * A simulated server accepts every command like any other but has no
+ * effect outside itself, for staging protocol changes and training
+ * without risk.  `faktory -simulate` keeps jobs in memory, they're
+ * discarded on exit, and subsystems which reach outside the server,
+ * e.g. the mirror, routing links, the offload blob store and the
+ * bridge, are never started.  INFO and the Web UI mark the instance
+ * as simulated.
+ */
+
+// External is implemented by subsystems which send jobs or payloads
+// outside the server or consume them from elsewhere, Register skips
+// them on a simulated server.
+type External interface {
+	External()
+}
+
+func (s *Server) Simulated() bool {
+	return s.Options != nil && s.Options.Simulated
+}
+
+// skipExternal returns true if the subsystem shouldn't run
+func (s *Server) skipExternal(x Subsystem) bool {
+	if _, ok := x.(External); !ok || !s.Simulated() {
+		return false
+	}
+	util.Infof("Simulation: not starting %T, it reaches outside the server", x)
+	return true
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimulated(t *testing.T) {
+	s := &Server{Options: &ServerOptions{}}
+	assert.False(t, s.Simulated())
+	s.Register(MirrorSubsystem())
+	s.Register(LineageSubsystem())
+	assert.Equal(t, 2, len(s.Subsystems))
+
+	s = &Server{Options: &ServerOptions{Simulated: true}}
+	assert.True(t, s.Simulated())
+	for _, x := range []Subsystem{MirrorSubsystem(), RoutingSubsystem(), OffloadSubsystem(), LineageSubsystem(), TrackingSubsystem()} {
+		s.Register(x)
+	}
+	assert.Equal(t, 2, len(s.Subsystems))
+	assert.NotNil(t, s.lineage())
+	for _, x := range s.Subsystems {
+		_, ok := x.(External)
+		assert.False(t, ok)
+	}
+
+	assert.False(t, (&Server{}).Simulated())
+}
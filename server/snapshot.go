@@ -0,0 +1,109 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/contribsys/faktory/util"
+)
+
+/*
+ * On graceful shutdown the server saves a snapshot of its in-memory
+ * state to Redis and restores it at boot.  Workers which were
+ * heartbeating are expected back: they're listed on the Busy page,
+ * keep their quiet/terminate state and are given the usual heartbeat
+ * window to reconnect before they're reaped.  The command counter
+ * continues rather than starting from zero.
+ */
+const (
+	snapshotKey = "server:snapshot"
+)
+
+type snapshot struct {
+	SavedAt  time.Time         `json:"saved_at"`
+	Commands uint64            `json:"commands"`
+	Workers  []*workerSnapshot `json:"workers"`
+}
+
+type workerSnapshot struct {
+	ClientData
+	LastHeartbeat time.Time   `json:"last_heartbeat"`
+	State         WorkerState `json:"state"`
+}
+
+func (s *Server) saveSnapshot() error {
+	snap := snapshot{
+		SavedAt:  time.Now(),
+		Commands: atomic.LoadUint64(&s.Stats.Commands),
+		Workers:  []*workerSnapshot{},
+	}
+
+	s.workers.mu.RLock()
+	for _, worker := range s.workers.heartbeats {
+		ws := &workerSnapshot{
+			ClientData:    *worker,
+			LastHeartbeat: worker.lastHeartbeat,
+			State:         worker.state,
+		}
+		ws.PasswordHash = ""
+		snap.Workers = append(snap.Workers, ws)
+	}
+	s.workers.mu.RUnlock()
+
+	data, err := json.Marshal(&snap)
+	if err != nil {
+		return err
+	}
+	return s.store.Raw().Set(snapshotKey, data)
+}
+
+// restoreSnapshot loads the snapshot saved at shutdown, if any.
+// The snapshot is deleted so it can't be restored twice, e.g. if
+// the server later crashes.
+func (s *Server) restoreSnapshot() error {
+	data, err := s.store.Raw().Get(snapshotKey)
+	if err != nil || data == nil {
+		return err
+	}
+	err = s.store.Redis().Del(snapshotKey).Err()
+	if err != nil {
+		return err
+	}
+
+	var snap snapshot
+	err = json.Unmarshal(data, &snap)
+	if err != nil {
+		return err
+	}
+
+	atomic.AddUint64(&s.Stats.Commands, snap.Commands)
+	restored := s.workers.restore(snap.Workers, snap.SavedAt, time.Now())
+	util.Infof("Restored shutdown snapshot from %s, expecting %d workers", snap.SavedAt.Format(time.RFC3339), restored)
+	return nil
+}
+
+// restore adds the workers which were alive when the snapshot
+// was saved, treating the restart as their last heartbeat.
+func (w *workers) restore(snaps []*workerSnapshot, savedAt time.Time, now time.Time) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	count := 0
+	for _, ws := range snaps {
+		if ws.Wid == "" || ws.LastHeartbeat.Before(savedAt.Add(-1*time.Minute)) {
+			continue
+		}
+		if _, ok := w.heartbeats[ws.Wid]; ok {
+			continue
+		}
+		worker := ws.ClientData
+		worker.lastHeartbeat = now
+		worker.state = ws.State
+		worker.connections = map[io.Closer]bool{}
+		w.heartbeats[worker.Wid] = &worker
+		count++
+	}
+	return count
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot(t *testing.T) {
+	now := time.Now()
+	snap := snapshot{
+		SavedAt:  now,
+		Commands: 123,
+		Workers: []*workerSnapshot{
+			{ClientData: ClientData{Wid: "alive", Hostname: "box"}, LastHeartbeat: now.Add(-10 * time.Second), State: Quiet},
+			{ClientData: ClientData{Wid: "stale"}, LastHeartbeat: now.Add(-5 * time.Minute)},
+			{ClientData: ClientData{}, LastHeartbeat: now},
+		},
+	}
+
+	data, err := json.Marshal(&snap)
+	assert.NoError(t, err)
+	var loaded snapshot
+	assert.NoError(t, json.Unmarshal(data, &loaded))
+	assert.EqualValues(t, 123, loaded.Commands)
+	assert.Equal(t, 3, len(loaded.Workers))
+	assert.Equal(t, "box", loaded.Workers[0].Hostname)
+
+	w := newWorkers()
+	later := now.Add(2 * time.Minute)
+	assert.Equal(t, 1, w.restore(loaded.Workers, loaded.SavedAt, later))
+	worker := w.heartbeats["alive"]
+	assert.NotNil(t, worker)
+	assert.True(t, worker.IsQuiet())
+	assert.Equal(t, later, worker.lastHeartbeat)
+
+	// the worker reconnects before it's reaped
+	assert.Equal(t, 0, w.reapHeartbeats(later.Add(-1*time.Minute)))
+	entry, ok := w.heartbeat(&ClientData{Wid: "alive"}, cls{})
+	assert.True(t, ok)
+	assert.Equal(t, 1, len(entry.connections))
+
+	// already known workers aren't replaced
+	assert.Equal(t, 0, w.restore(loaded.Workers, loaded.SavedAt, later))
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+ * Workers can identify themselves with a SPIFFE X.509 SVID as their
+ * TLS client certificate, verified against the trust bundle in
+ * [tls] client_ca.  The SPIFFE ID selects the scopes the connection
+ * may use:
+ *
+ * [spiffe]
+ * trust_domain = "example.org"
+ *
+ * [spiffe.ids]
+ * "spiffe://example.org/ns/prod/sa/worker" = ["fetch", "track"]
+ * "spiffe://example.org/ns/prod/sa/web" = ["push", "info"]
+ * "spiffe://example.org/ns/ops/*" = ["*"]
+ *
+ * A trailing /* matches every ID below that path, the longest match
+ * wins.  The scopes are:
+ *
+ *   push   PUSH, PUSHB and BATCH
+ *   fetch  FETCH, ACK, FAIL and BEAT
+ *   info   INFO and JOBS
+ *   track  TRACK and RESULT
+ *   admin  FLUSH, BACKUP, MARK, TEMPLATE, MAINTENANCE and MUTATE
+ *   *      all commands
+ *
+ * Any connection may send END.  Connections with an SVID from another
+ * trust domain or an unmapped ID are refused, as are WebSockets to the
+ * Web UI, which can't present an SVID.  Connections to the Unix socket
+ * aren't restricted, like authentication they're left to the socket's
+ * permissions.
+ */
+
+var commandScopes = map[string]string{
+	"PUSH":        "push",
+	"PUSHB":       "push",
+	"BATCH":       "push",
+	"FETCH":       "fetch",
+	"ACK":         "fetch",
+	"FAIL":        "fetch",
+	"BEAT":        "fetch",
+	"INFO":        "info",
+	"JOBS":        "info",
+	"TRACK":       "track",
+	"RESULT":      "track",
+	"FLUSH":       "admin",
+	"BACKUP":      "admin",
+	"MARK":        "admin",
+	"TEMPLATE":    "admin",
+	"MAINTENANCE": "admin",
+	"MUTATE":      "admin",
+}
+
+type spiffeMapper struct {
+	trustDomain string
+	// SPIFFE ID or path prefix => scopes
+	ids      map[string]map[string]bool
+	prefixes []string
+}
+
+// newSpiffeMapper returns nil if SPIFFE IDs aren't mapped
+func newSpiffeMapper(s *Server) (*spiffeMapper, error) {
+	if _, ok := s.Options.GlobalConfig["spiffe"]; !ok {
+		return nil, nil
+	}
+	domain := strings.ToLower(s.Options.String("spiffe", "trust_domain", ""))
+	if domain == "" {
+		return nil, fmt.Errorf("[spiffe] requires a trust_domain")
+	}
+
+	sm := &spiffeMapper{trustDomain: domain, ids: map[string]map[string]bool{}}
+	ids, _ := s.Options.Config("spiffe", "ids", nil).(map[string]interface{})
+	for id, val := range ids {
+		if !strings.HasPrefix(id, "spiffe://"+domain+"/") {
+			return nil, fmt.Errorf("SPIFFE ID %s is not in the trust domain %s", id, domain)
+		}
+		list, ok := val.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Scopes for %s must be a list", id)
+		}
+		scopes := map[string]bool{}
+		for _, item := range list {
+			scope := fmt.Sprintf("%v", item)
+			if scope != "*" && !knownScope(scope) {
+				return nil, fmt.Errorf("Unknown scope %q for %s", scope, id)
+			}
+			scopes[scope] = true
+		}
+
+		if strings.HasSuffix(id, "/*") {
+			id = strings.TrimSuffix(id, "*")
+			sm.prefixes = append(sm.prefixes, id)
+		}
+		sm.ids[id] = scopes
+	}
+	// longest first
+	sort.Slice(sm.prefixes, func(i, j int) bool {
+		return len(sm.prefixes[i]) > len(sm.prefixes[j])
+	})
+	return sm, nil
+}
+
+func knownScope(scope string) bool {
+	for _, known := range commandScopes {
+		if known == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// spiffeID returns the SPIFFE ID of the verified client certificate
+func spiffeID(state tls.ConnectionState) (string, error) {
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return "", fmt.Errorf("No verified client certificate")
+	}
+	leaf := state.VerifiedChains[0][0]
+	// an X.509-SVID has exactly one URI SAN
+	if len(leaf.URIs) != 1 || leaf.URIs[0].Scheme != "spiffe" {
+		return "", fmt.Errorf("Client certificate is not an SVID")
+	}
+	uri := leaf.URIs[0]
+	if uri.User != nil || uri.Port() != "" || uri.RawQuery != "" || uri.Fragment != "" || uri.Path == "" {
+		return "", fmt.Errorf("Invalid SPIFFE ID %s", uri)
+	}
+	return uri.String(), nil
+}
+
+// scopes returns the scopes granted to the connection's SPIFFE ID
+func (sm *spiffeMapper) scopes(state tls.ConnectionState) (string, map[string]bool, error) {
+	id, err := spiffeID(state)
+	if err != nil {
+		return "", nil, err
+	}
+	if !strings.HasPrefix(strings.ToLower(id), "spiffe://"+sm.trustDomain+"/") {
+		return id, nil, fmt.Errorf("SPIFFE ID %s is not in the trust domain %s", id, sm.trustDomain)
+	}
+	if scopes, ok := sm.ids[id]; ok {
+		return id, scopes, nil
+	}
+	for _, prefix := range sm.prefixes {
+		if strings.HasPrefix(id, prefix) {
+			return id, sm.ids[prefix], nil
+		}
+	}
+	return id, nil, fmt.Errorf("Unknown SPIFFE ID %s", id)
+}
+
+// permitted is true if the connection's scopes allow the command,
+// a command without a scope needs "*"
+func (c *Connection) permitted(verb string) bool {
+	if c.scopes == nil || c.scopes["*"] || verb == "END" {
+		return true
+	}
+	scope, ok := commandScopes[verb]
+	return ok && c.scopes[scope]
+}
@@ -0,0 +1,282 @@
+package server
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/stretchr/testify/assert"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return &testCA{cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a certificate for the host or SPIFFE ID
+func (ca *testCA) issue(t *testing.T, serial int64, host string, id string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if host != "" {
+		tmpl.DNSNames = []string{host}
+	}
+	if id != "" {
+		uri, err := url.Parse(id)
+		assert.NoError(t, err)
+		tmpl.URIs = []*url.URL{uri}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	cert, err := tls.X509KeyPair(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}))
+	assert.NoError(t, err)
+	return cert
+}
+
+func writePEM(t *testing.T, dir, name string, cert tls.Certificate) (string, string) {
+	certFile := dir + "/" + name + ".pem"
+	keyFile := dir + "/" + name + "_key.pem"
+	keyDer, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600))
+	assert.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certFile, keyFile
+}
+
+func TestSpiffeConfig(t *testing.T) {
+	for _, bad := range []map[string]interface{}{
+		{},
+		{"trust_domain": "example.org", "ids": map[string]interface{}{"spiffe://other.org/worker": []interface{}{"push"}}},
+		{"trust_domain": "example.org", "ids": map[string]interface{}{"spiffe://example.org/worker": []interface{}{"launch"}}},
+		{"trust_domain": "example.org", "ids": map[string]interface{}{"spiffe://example.org/worker": "push"}},
+	} {
+		_, err := newSpiffeMapper(&Server{Options: &ServerOptions{GlobalConfig: map[string]interface{}{"spiffe": bad}}})
+		assert.Error(t, err, "%v", bad)
+	}
+
+	sm, err := newSpiffeMapper(&Server{Options: &ServerOptions{}})
+	assert.NoError(t, err)
+	assert.Nil(t, sm)
+}
+
+func TestSpiffeCommandScopes(t *testing.T) {
+	for verb := range cmdSet {
+		if verb != "END" {
+			assert.NotEmpty(t, commandScopes[verb], "%s has no SPIFFE scope", verb)
+		}
+	}
+
+	c := &Connection{scopes: map[string]bool{"push": true}}
+	assert.True(t, c.permitted("PUSH"))
+	assert.True(t, c.permitted("END"))
+	assert.False(t, c.permitted("FETCH"))
+	assert.False(t, c.permitted("LAUNCH"))
+	c.scopes["*"] = true
+	assert.True(t, c.permitted("LAUNCH"))
+}
+
+func TestSpiffeUnscoped(t *testing.T) {
+	sm, err := newSpiffeMapper(&Server{Options: &ServerOptions{GlobalConfig: map[string]interface{}{
+		"spiffe": map[string]interface{}{"trust_domain": "example.org"},
+	}}})
+	assert.NoError(t, err)
+	s := &Server{Options: &ServerOptions{}, spiffe: sm}
+
+	// a WebSocket has no SVID
+	conn, peer := net.Pipe()
+	done := make(chan *Connection, 1)
+	go func() { done <- startConnection(conn, s, false) }()
+	line, _ := bufio.NewReader(peer).ReadString('\n')
+	assert.Equal(t, "-ERR SPIFFE requires a client certificate\r\n", line)
+	assert.Nil(t, <-done)
+
+	// the Unix socket is left to its permissions
+	conn, peer = net.Pipe()
+	go func() { done <- startConnection(conn, s, true) }()
+	r := bufio.NewReader(peer)
+	line, _ = r.ReadString('\n')
+	assert.Contains(t, line, "+HI")
+	_, err = peer.Write([]byte("HELLO {\"v\":2}\r\n"))
+	assert.NoError(t, err)
+	line, _ = r.ReadString('\n')
+	assert.Equal(t, "+OK\r\n", line)
+	c := <-done
+	assert.NotNil(t, c)
+	assert.True(t, c.permitted("FLUSH"))
+	peer.Close()
+}
+
+func TestSpiffeScopes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spiffe")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t)
+	caFile := dir + "/bundle.pem"
+	assert.NoError(t, ioutil.WriteFile(caFile, ca.pem, 0600))
+	certFile, keyFile := writePEM(t, dir, "server", ca.issue(t, 2, "localhost", "spiffe://example.org/faktory"))
+
+	runServer("localhost:7426", func() {
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(ca.pem)
+		dial := func(cert tls.Certificate) (*client.Client, error) {
+			srv := client.DefaultServer()
+			srv.Network = "tcp+tls"
+			srv.Address = "localhost:7426"
+			srv.TLS = &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{cert}}
+			return client.Dial(srv, "")
+		}
+
+		worker, err := dial(ca.issue(t, 3, "", "spiffe://example.org/ns/prod/worker"))
+		assert.NoError(t, err)
+		_, err = worker.Fetch("default")
+		assert.NoError(t, err)
+		err = worker.Push(client.NewJob("Thing", 1))
+		assert.EqualError(t, err, "NOPERM spiffe://example.org/ns/prod/worker may not use PUSH")
+		_, err = worker.Backup()
+		assert.EqualError(t, err, "NOPERM spiffe://example.org/ns/prod/worker may not use BACKUP")
+		worker.Close()
+
+		ops, err := dial(ca.issue(t, 4, "", "spiffe://example.org/ns/ops/deploy"))
+		assert.NoError(t, err)
+		assert.NoError(t, ops.Push(client.NewJob("Thing", 1)))
+		ops.Close()
+
+		_, err = dial(ca.issue(t, 5, "", "spiffe://example.org/ns/dev/worker"))
+		assert.Error(t, err)
+		_, err = dial(ca.issue(t, 6, "worker.example.org", ""))
+		assert.Error(t, err)
+		_, err = dial(newTestCA(t).issue(t, 7, "", "spiffe://example.org/ns/prod/worker"))
+		assert.Error(t, err)
+
+		// without a client certificate the TLS handshake fails
+		conn, err := tls.Dial("tcp", "localhost:7426", &tls.Config{RootCAs: pool})
+		if err == nil {
+			_, err = conn.Read(make([]byte, 1))
+			conn.Close()
+		}
+		assert.Error(t, err)
+	}, func(opts *ServerOptions) {
+		opts.GlobalConfig = map[string]interface{}{
+			"tls": map[string]interface{}{"cert": certFile, "key": keyFile, "client_ca": caFile},
+			"spiffe": map[string]interface{}{
+				"trust_domain": "example.org",
+				"ids": map[string]interface{}{
+					"spiffe://example.org/ns/prod/worker": []interface{}{"fetch"},
+					"spiffe://example.org/ns/ops/*":       []interface{}{"*"},
+				},
+			},
+		}
+	})
+}
+
+func TestTLSReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t)
+	certFile, keyFile := writePEM(t, dir, "server", ca.issue(t, 2, "localhost", ""))
+	tf, err := newTLSFiles(&Server{Options: &ServerOptions{GlobalConfig: map[string]interface{}{
+		"tls": map[string]interface{}{"cert": certFile, "key": keyFile},
+	}}})
+	assert.NoError(t, err)
+
+	cfg, err := tf.current(nil)
+	assert.NoError(t, err)
+	first := cfg.Certificates[0].Certificate[0]
+
+	writePEM(t, dir, "server", ca.issue(t, 3, "localhost", ""))
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(certFile, later, later))
+	tf.checked = time.Time{}
+
+	cfg, err = tf.current(nil)
+	assert.NoError(t, err)
+	assert.NotEqual(t, first, cfg.Certificates[0].Certificate[0])
+
+	// a half-written rotation keeps the previous certificate
+	assert.NoError(t, ioutil.WriteFile(keyFile, []byte("garbage"), 0600))
+	later = later.Add(time.Minute)
+	assert.NoError(t, os.Chtimes(keyFile, later, later))
+	tf.checked = time.Time{}
+	again, err := tf.current(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, cfg, again)
+
+	// SIGHUP reloads immediately, the files may be set with
+	// [faktory] tls_cert and tls_key or the flags too
+	rotatedCert, rotatedKey := writePEM(t, dir, "rotated", ca.issue(t, 4, "localhost", ""))
+	s := &Server{Options: &ServerOptions{GlobalConfig: map[string]interface{}{
+		"faktory": map[string]interface{}{"tls_cert": rotatedCert, "tls_key": rotatedKey},
+	}}}
+	assert.NoError(t, tf.reload(s))
+	cfg, err = tf.current(nil)
+	assert.NoError(t, err)
+	assert.NotEqual(t, again, cfg)
+
+	s.Options.TLSCert = filepath.Join(dir, "missing.pem")
+	assert.Error(t, tf.reload(s))
+	s.Options.TLSCert = ""
+	s.Options.GlobalConfig = map[string]interface{}{}
+	assert.Error(t, tf.reload(s))
+	again, err = tf.current(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, cfg, again)
+
+	_, err = newTLSFiles(&Server{Options: &ServerOptions{GlobalConfig: map[string]interface{}{
+		"tls": map[string]interface{}{"client_ca": certFile},
+	}}})
+	assert.Error(t, err)
+
+	// TLS isn't disabled while the auth provider depends on it
+	s = &Server{Options: &ServerOptions{GlobalConfig: map[string]interface{}{
+		"auth": map[string]interface{}{"provider": "token"},
+	}}, auth: &tokenAuth{}, certs: tf, tls: cfg}
+	assert.Error(t, s.reloadTLS())
+	assert.Equal(t, tf, s.certs)
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/contribsys/faktory/util"
+)
+
+/*
+ * Startup tracks the stages of booting the daemon: loading the
+ * configuration, booting storage and opening the listeners.  Each
+ * is logged with how long it took, and the Web UI reports them at
+ * /-/ready so orchestration only routes traffic to a server which has
+ * finished starting.  /-/ready also fails while Redis is recovering
+ * or the server is shutting down, /-/healthy only once the server is
+ * shutting down or a stage has failed, so a slow Redis restart
+ * doesn't get the process killed.
+ */
+const (
+	StartupConfig    = "config"
+	StartupStorage   = "storage"
+	StartupListeners = "listeners"
+)
+
+const (
+	StagePending = "pending"
+	StageRunning = "running"
+	StageDone    = "ok"
+	StageFailed  = "failed"
+)
+
+// Stage is a step in booting the server
+type Stage struct {
+	Name    string
+	State   string
+	Elapsed time.Duration
+	Err     error
+
+	started time.Time
+}
+
+func (st Stage) String() string {
+	switch st.State {
+	case StageDone:
+		return fmt.Sprintf("%s: %s %v", st.Name, st.State, st.Elapsed)
+	case StageFailed:
+		return fmt.Sprintf("%s: %s %v", st.Name, st.State, st.Err)
+	default:
+		return fmt.Sprintf("%s: %s", st.Name, st.State)
+	}
+}
+
+type Startup struct {
+	mu     sync.Mutex
+	stages []*Stage
+}
+
+// NewStartup tracks the named stages, in the order they run.
+func NewStartup(names ...string) *Startup {
+	st := &Startup{}
+	for _, name := range names {
+		st.stages = append(st.stages, &Stage{Name: name, State: StagePending})
+	}
+	return st
+}
+
+// stage returns the named stage, adding it if it wasn't named
+// when the Startup was created
+func (st *Startup) stage(name string) *Stage {
+	for _, stage := range st.stages {
+		if stage.Name == name {
+			return stage
+		}
+	}
+	stage := &Stage{Name: name, State: StagePending}
+	st.stages = append(st.stages, stage)
+	return stage
+}
+
+func (st *Startup) Begin(name string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	stage := st.stage(name)
+	stage.State = StageRunning
+	stage.Err = nil
+	stage.started = time.Now()
+}
+
+// Finish records the stage's outcome, err is nil if it succeeded.
+func (st *Startup) Finish(name string, err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	stage := st.stage(name)
+	stage.Elapsed = time.Since(stage.started)
+	if err != nil {
+		stage.State, stage.Err = StageFailed, err
+		util.Warnf("Startup %s failed after %v: %v", name, stage.Elapsed, err)
+		return
+	}
+	stage.State = StageDone
+	util.Infof("Startup %s took %v", name, stage.Elapsed)
+}
+
+// Stages returns a copy of the stages in the order they run.
+func (st *Startup) Stages() []Stage {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	stages := make([]Stage, len(st.stages))
+	for idx, stage := range st.stages {
+		stages[idx] = *stage
+	}
+	return stages
+}
+
+// Complete reports whether every stage has succeeded.
+func (st *Startup) Complete() bool {
+	for _, stage := range st.Stages() {
+		if stage.State != StageDone {
+			return false
+		}
+	}
+	return true
+}
+
+// Failed reports whether any stage has failed.
+func (st *Startup) Failed() bool {
+	for _, stage := range st.Stages() {
+		if stage.State == StageFailed {
+			return true
+		}
+	}
+	return false
+}
+
+// Readiness reports whether the server should be routed traffic,
+// with a line for each startup stage and for storage.
+func (s *Server) Readiness() (bool, []string) {
+	ready := s.Options.Startup.Complete()
+	report := []string{}
+	for _, stage := range s.Options.Startup.Stages() {
+		report = append(report, stage.String())
+	}
+
+	s.mu.Lock()
+	store, closed := s.store, s.closed
+	s.mu.Unlock()
+	if store != nil {
+		if store.Available() {
+			report = append(report, "redis: ok")
+		} else {
+			ready = false
+			report = append(report, "redis: unavailable")
+		}
+	}
+	if closed || s.isDraining() || s.ctx.Err() != nil {
+		ready = false
+		report = append(report, "server: shutting down")
+	}
+	return ready, report
+}
+
+// Healthy reports whether the process is alive and should be left
+// running, it is while Redis recovers.
+func (s *Server) Healthy() bool {
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	return !closed && s.ctx.Err() == nil && !s.Options.Startup.Failed()
+}
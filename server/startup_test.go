@@ -0,0 +1,51 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStartup(t *testing.T) {
+	s, err := NewServer(&ServerOptions{StorageDirectory: os.TempDir(), Startup: NewStartup(StartupConfig, StartupListeners)})
+	assert.NoError(t, err)
+
+	ready, report := s.Readiness()
+	assert.False(t, ready)
+	assert.Equal(t, []string{"config: pending", "listeners: pending"}, report)
+
+	s.Options.Startup.Begin(StartupConfig)
+	s.Options.Startup.Finish(StartupConfig, nil)
+	s.Options.Startup.Begin(StartupListeners)
+	ready, report = s.Readiness()
+	assert.False(t, ready)
+	assert.True(t, strings.HasPrefix(report[0], "config: ok "), report[0])
+	assert.Equal(t, "listeners: running", report[1])
+	assert.True(t, s.Healthy())
+
+	s.Options.Startup.Finish(StartupListeners, nil)
+	booted, _, stop := newTestServer(t, nil)
+	defer stop()
+	s.store = booted.store
+
+	ready, report = s.Readiness()
+	assert.True(t, ready)
+	assert.Equal(t, "redis: ok", report[2])
+	assert.True(t, s.Options.Startup.Complete())
+
+	s.Shutdown()
+	ready, report = s.Readiness()
+	assert.False(t, ready)
+	assert.Equal(t, "server: shutting down", report[len(report)-1])
+	assert.False(t, s.Healthy())
+
+	st := NewStartup(StartupStorage)
+	st.Begin(StartupStorage)
+	st.Finish(StartupStorage, errors.New("no redis-server"))
+	assert.True(t, st.Failed())
+	assert.False(t, st.Complete())
+	assert.Equal(t, "storage: failed no redis-server", st.Stages()[0].String())
+}
@@ -9,12 +9,57 @@ type Subsystem interface {
 	// necessary changes.
 	Reload(*Server) error
 
-	// Shutdown is signaled by the Server.Stopper() channel.  Subsystems should
-	// select on it to be notified when the server is shutting down.
+	// Shutdown is signaled by the Server.Context() being done.  Subsystems
+	// should run background work with Server.Go so Stop waits for it.
 }
 
 // register a global handler to be called when the Server instance
-// has finished booting but before it starts listening.
+// has finished booting but before it starts listening.  External
+// subsystems aren't registered on a simulated server.
 func (s *Server) Register(x Subsystem) {
+	if s.skipExternal(x) {
+		return
+	}
 	s.Subsystems = append(s.Subsystems, x)
 }
+
+// Applications embedding Faktory register lifecycle hooks to manage
+// their own resources alongside the server rather than wrapping the
+// signal handling in cli.  Hooks are called in the order they were
+// registered.
+type lifecycleHooks struct {
+	boot     []func(*Server) error
+	reload   []func(*Server)
+	shutdown []func(*Server)
+}
+
+// OnBoot registers a hook called at the end of Boot, once storage is
+// open but before subsystems start and clients can connect.  An error
+// aborts the boot.
+func (s *Server) OnBoot(fn func(*Server) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks.boot = append(s.hooks.boot, fn)
+}
+
+// OnReload registers a hook called after the config is reloaded,
+// e.g. on SIGHUP, and the subsystems have been reloaded.
+func (s *Server) OnReload(fn func(*Server)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks.reload = append(s.hooks.reload, fn)
+}
+
+// OnShutdown registers a hook called by Stop once the server no longer
+// accepts connections, while storage is still open.
+func (s *Server) OnShutdown(fn func(*Server)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks.shutdown = append(s.hooks.shutdown, fn)
+}
+
+func (s *Server) lifecycle() lifecycleHooks {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hooks
+}
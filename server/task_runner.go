@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"math/rand"
 	"sync"
 	"sync/atomic"
@@ -54,23 +55,22 @@ func (ts *taskRunner) AddTask(sec int64, thing Taskable) {
 	ts.mutex.Unlock()
 }
 
-func (ts *taskRunner) Run(stopper chan bool) {
-	go func() {
-		// add random jitter so the runner goroutine doesn't fire at 000ms
-		time.Sleep(time.Duration(rand.Float64()) * time.Second)
-		timer := time.NewTicker(1 * time.Second)
-		defer timer.Stop()
-
-		for {
-			ts.cycle()
-			select {
-			case <-timer.C:
-			case <-stopper:
-				util.Debug("Stopping scheduled tasks")
-				return
-			}
+// Run executes the tasks until ctx is done
+func (ts *taskRunner) Run(ctx context.Context) {
+	// add random jitter so the runner goroutine doesn't fire at 000ms
+	time.Sleep(time.Duration(rand.Float64()) * time.Second)
+	timer := time.NewTicker(1 * time.Second)
+	defer timer.Stop()
+
+	for {
+		ts.cycle()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			util.Debug("Stopping scheduled tasks")
+			return
 		}
-	}()
+	}
 }
 
 func (ts *taskRunner) Stats() map[string]map[string]interface{} {
@@ -120,9 +120,13 @@ func (s *Server) startTasks() {
 
 	// reaps job reservations which have expired
 	ts.AddTask(15, &reservationReaper{s.manager, 0})
+	// warns about reservations which are about to expire
+	ts.AddTask(15, &reservationMonitor{m: s.manager, opts: s.Options})
 	// reaps workers who have not heartbeated
 	ts.AddTask(15, &beatReaper{s.workers, 0})
+	// deletes the contents of cleared queues
+	ts.AddTask(1, &queueReaper{s.store, 0})
 
-	ts.Run(s.Stopper())
+	s.Go(ts.Run)
 	s.taskRunner = ts
 }
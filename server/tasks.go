@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/contribsys/faktory/manager"
+	"github.com/contribsys/faktory/storage"
 	"github.com/contribsys/faktory/util"
 )
 
@@ -34,6 +35,31 @@ func (r *reservationReaper) Stats() map[string]interface{} {
 	}
 }
 
+/*
+ * Deletes the contents of cleared queues in small batches.
+ */
+type queueReaper struct {
+	store storage.Store
+	count int64
+}
+
+func (r *queueReaper) Name() string {
+	return "Cleared"
+}
+
+func (r *queueReaper) Execute() error {
+	count, err := r.store.ReapClearedQueues(100000)
+	atomic.AddInt64(&r.count, count)
+	return err
+}
+
+func (r *queueReaper) Stats() map[string]interface{} {
+	return map[string]interface{}{
+		"size":   r.store.ClearedSize(),
+		"reaped": atomic.LoadInt64(&r.count),
+	}
+}
+
 /*
  * Removes any heartbeat records over 1 minute old.
  */
@@ -58,3 +84,57 @@ func (r *beatReaper) Stats() map[string]interface{} {
 		"reaped": atomic.LoadInt64(&r.count),
 	}
 }
+
+/*
+ * Warns about jobs which have been reserved for most of their
+ * reserve_for timeout.  These jobs are about to be reaped and
+ * retried, possibly while the original worker is still running them.
+ *
+ * [reservations]
+ * warn_at = 80    # percent of reserve_for, 0 disables
+ */
+type reservationMonitor struct {
+	m      manager.Manager
+	opts   *ServerOptions
+	warned map[string]bool
+	size   int64
+	count  int64
+}
+
+func (r *reservationMonitor) Name() string {
+	return "Overdue"
+}
+
+func (r *reservationMonitor) Execute() error {
+	percent := r.opts.Int("reservations", "warn_at", 80)
+	if percent <= 0 {
+		atomic.StoreInt64(&r.size, 0)
+		return nil
+	}
+
+	now := time.Now()
+	overdue := r.m.OverdueReservations(float64(percent)/100, now)
+	current := make(map[string]bool, len(overdue))
+	for _, res := range overdue {
+		jid := res.Job.Jid
+		current[jid] = true
+		if r.warned[jid] {
+			continue
+		}
+		util.Warnf("Job %s (%s) reserved by %s has used %d%% of its reservation, expires at %s",
+			jid, res.Job.Type, res.Wid, int(res.Progress(now)*100), res.Expiry)
+		atomic.AddInt64(&r.count, 1)
+	}
+	// only remember jobs which are still overdue so
+	// the map doesn't grow forever
+	r.warned = current
+	atomic.StoreInt64(&r.size, int64(len(overdue)))
+	return nil
+}
+
+func (r *reservationMonitor) Stats() map[string]interface{} {
+	return map[string]interface{}{
+		"size":   atomic.LoadInt64(&r.size),
+		"warned": atomic.LoadInt64(&r.count),
+	}
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/manager"
+	"github.com/stretchr/testify/assert"
+)
+
+type overdueManager struct {
+	manager.Manager
+	fraction float64
+	overdue  []*manager.Reservation
+}
+
+func (om *overdueManager) OverdueReservations(fraction float64, now time.Time) []*manager.Reservation {
+	om.fraction = fraction
+	return om.overdue
+}
+
+func TestReservationMonitor(t *testing.T) {
+	om := &overdueManager{}
+	opts := &ServerOptions{GlobalConfig: map[string]interface{}{}}
+	r := &reservationMonitor{m: om, opts: opts}
+
+	assert.NoError(t, r.Execute())
+	assert.Equal(t, 0.8, om.fraction)
+	assert.EqualValues(t, 0, r.Stats()["size"])
+
+	res := &manager.Reservation{Job: client.NewJob("Slow", 1), Wid: "1234"}
+	om.overdue = []*manager.Reservation{res}
+	assert.NoError(t, r.Execute())
+	assert.NoError(t, r.Execute())
+	assert.EqualValues(t, 1, r.Stats()["size"])
+	assert.EqualValues(t, 1, r.Stats()["warned"])
+
+	om.overdue = nil
+	assert.NoError(t, r.Execute())
+	assert.EqualValues(t, 0, r.Stats()["size"])
+	assert.Equal(t, 0, len(r.warned))
+
+	opts.GlobalConfig["reservations"] = map[string]interface{}{"warn_at": int64(50)}
+	assert.NoError(t, r.Execute())
+	assert.Equal(t, 0.5, om.fraction)
+}
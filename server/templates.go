@@ -0,0 +1,347 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/go-redis/redis"
+)
+
+/*
+ * Job templates standardize the jobs admins push by hand during
+ * run-book operations.  A template names a jobtype and default args,
+ * strings in the args may contain {{param}} placeholders which are
+ * filled in when the template is pushed, {{param|default}} gives a
+ * value to use when the parameter is omitted:
+ *
+ * TEMPLATE SAVE {"name":"reindex","jobtype":"Reindex","queue":"critical","args":["{{index}}",{"batch":"{{batch|500}}"}]}
+ * TEMPLATE PUSH {"name":"reindex","params":{"index":"users"}}
+ *
+ * A string which is a single placeholder is replaced by the value as
+ * JSON when it's valid JSON, so "{{batch|500}}" becomes the number 500,
+ * otherwise by the value as a string.  Templates are pushed from the
+ * Web UI's Templates page or `faktory template push`.
+ */
+const (
+	templatesKey = "server:templates"
+)
+
+var (
+	templateName = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,100}$`)
+	placeholder  = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*(?:\|([^}]*))?\}\}`)
+)
+
+// JobTemplate is a parameterized job saved for admins to push
+type JobTemplate struct {
+	Name        string        `json:"name"`
+	Type        string        `json:"jobtype"`
+	Queue       string        `json:"queue,omitempty"`
+	Args        []interface{} `json:"args"`
+	Description string        `json:"description,omitempty"`
+}
+
+// TemplateParam is a placeholder in a template's args
+type TemplateParam struct {
+	Name       string `json:"name"`
+	Default    string `json:"default,omitempty"`
+	HasDefault bool   `json:"has_default"`
+}
+
+// Params lists the template's placeholders in the order they
+// first appear in its args, hashes are walked by key
+func (t *JobTemplate) Params() []*TemplateParam {
+	params := []*TemplateParam{}
+	seen := map[string]bool{}
+	walkStrings(t.Args, func(val string) {
+		for _, match := range placeholder.FindAllStringSubmatchIndex(val, -1) {
+			name := val[match[2]:match[3]]
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			param := &TemplateParam{Name: name}
+			if match[4] != -1 {
+				param.Default, param.HasDefault = val[match[4]:match[5]], true
+			}
+			params = append(params, param)
+		}
+	})
+	return params
+}
+
+func walkStrings(val interface{}, fn func(string)) {
+	switch v := val.(type) {
+	case string:
+		fn(v)
+	case []interface{}:
+		for _, elm := range v {
+			walkStrings(elm, fn)
+		}
+	case map[string]interface{}:
+		// in a stable order for the forms
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			walkStrings(v[key], fn)
+		}
+	}
+}
+
+// Job returns a new job from the template with its placeholders
+// filled in from params.
+func (t *JobTemplate) Job(params map[string]string) (*client.Job, error) {
+	known := map[string]bool{}
+	for _, param := range t.Params() {
+		known[param.Name] = true
+	}
+	for name := range params {
+		if !known[name] {
+			return nil, fmt.Errorf("Template %s has no parameter %q", t.Name, name)
+		}
+	}
+
+	args := make([]interface{}, len(t.Args))
+	for idx, arg := range t.Args {
+		val, err := fill(arg, params)
+		if err != nil {
+			return nil, fmt.Errorf("Template %s: %v", t.Name, err)
+		}
+		args[idx] = val
+	}
+	job := client.NewJob(t.Type, args...)
+	if t.Queue != "" {
+		job.Queue = t.Queue
+	}
+	return job, nil
+}
+
+func fill(val interface{}, params map[string]string) (interface{}, error) {
+	switch v := val.(type) {
+	case string:
+		var err error
+		lookup := func(match []string) string {
+			if val, ok := params[match[1]]; ok {
+				return val
+			}
+			if !strings.Contains(match[0], "|") {
+				err = fmt.Errorf("Missing parameter %q", match[1])
+			}
+			return match[2]
+		}
+		if m := placeholder.FindStringSubmatch(v); m != nil && m[0] == v {
+			// a lone placeholder may be any JSON value
+			str := lookup(m)
+			var decoded interface{}
+			dec := json.NewDecoder(strings.NewReader(str))
+			dec.UseNumber()
+			if dec.Decode(&decoded) == nil && !dec.More() {
+				return decoded, err
+			}
+			return str, err
+		}
+		filled := placeholder.ReplaceAllStringFunc(v, func(s string) string {
+			return lookup(placeholder.FindStringSubmatch(s))
+		})
+		return filled, err
+	case []interface{}:
+		filled := make([]interface{}, len(v))
+		for idx, elm := range v {
+			val, err := fill(elm, params)
+			if err != nil {
+				return nil, err
+			}
+			filled[idx] = val
+		}
+		return filled, nil
+	case map[string]interface{}:
+		filled := make(map[string]interface{}, len(v))
+		for key, elm := range v {
+			val, err := fill(elm, params)
+			if err != nil {
+				return nil, err
+			}
+			filled[key] = val
+		}
+		return filled, nil
+	default:
+		return val, nil
+	}
+}
+
+// SaveTemplate adds the template or replaces the one
+// with the same name.
+func (s *Server) SaveTemplate(t *JobTemplate) error {
+	if !templateName.MatchString(t.Name) {
+		return fmt.Errorf("Template name must be 1 to 100 letters, digits, '_', '.' or '-'")
+	}
+	if t.Type == "" {
+		return fmt.Errorf("Template %s has no jobtype", t.Name)
+	}
+	if t.Args == nil {
+		t.Args = []interface{}{}
+	}
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	err = s.store.Redis().HSet(templatesKey, t.Name, data).Err()
+	if err != nil {
+		return err
+	}
+	s.RecordChange(ChangeTemplateSaved, t.Name, map[string]interface{}{"jobtype": t.Type, "queue": t.Queue})
+	return nil
+}
+
+// DeleteTemplate removes the template, if it exists.
+func (s *Server) DeleteTemplate(name string) error {
+	err := s.store.Redis().HDel(templatesKey, name).Err()
+	if err != nil {
+		return err
+	}
+	s.RecordChange(ChangeTemplateDeleted, name, nil)
+	return nil
+}
+
+// Template returns the named template, nil if there's none.
+func (s *Server) Template(name string) (*JobTemplate, error) {
+	data, err := s.store.Redis().HGet(templatesKey, name).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeTemplate(data)
+}
+
+// Templates returns the templates ordered by name.
+func (s *Server) Templates() ([]*JobTemplate, error) {
+	vals, err := s.store.Redis().HGetAll(templatesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*JobTemplate, 0, len(vals))
+	for _, val := range vals {
+		t, err := decodeTemplate(val)
+		if err != nil {
+			continue
+		}
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list, nil
+}
+
+func decodeTemplate(data string) (*JobTemplate, error) {
+	var t JobTemplate
+	dec := json.NewDecoder(strings.NewReader(data))
+	// large integers, e.g. IDs, would lose precision as floats
+	dec.UseNumber()
+	err := dec.Decode(&t)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// PushTemplate pushes a job from the named template with
+// its placeholders filled in from params.
+func (s *Server) PushTemplate(name string, params map[string]string) (*client.Job, error) {
+	t, err := s.Template(name)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, newTaggedError("NOTFOUND", fmt.Errorf("No such template %q", name))
+	}
+	job, err := t.Job(params)
+	if err != nil {
+		return nil, err
+	}
+	return job, s.manager.Push(job)
+}
+
+func templates(c *Connection, s *Server, cmd string) {
+	parts := strings.SplitN(cmd, " ", 3)
+	if len(parts) < 2 {
+		c.Error(cmd, fmt.Errorf("Invalid TEMPLATE %s", cmd))
+		return
+	}
+	payload := ""
+	if len(parts) == 3 {
+		payload = parts[2]
+	}
+
+	switch parts[1] {
+	case "LIST":
+		list, err := s.Templates()
+		if err != nil {
+			c.Error(cmd, err)
+			return
+		}
+		res, err := json.Marshal(list)
+		if err != nil {
+			c.Error(cmd, err)
+			return
+		}
+		c.Result(res)
+	case "SAVE":
+		var t JobTemplate
+		dec := json.NewDecoder(strings.NewReader(payload))
+		dec.UseNumber()
+		err := dec.Decode(&t)
+		if err != nil {
+			c.Error(cmd, newTaggedError("MALFORMED", err))
+			return
+		}
+		err = s.SaveTemplate(&t)
+		if err != nil {
+			c.Error(cmd, err)
+			return
+		}
+		c.Ok()
+	case "DEL":
+		var req struct {
+			Name string `json:"name"`
+		}
+		err := json.Unmarshal([]byte(payload), &req)
+		if err != nil {
+			c.Error(cmd, newTaggedError("MALFORMED", err))
+			return
+		}
+		err = s.DeleteTemplate(req.Name)
+		if err != nil {
+			c.Error(cmd, err)
+			return
+		}
+		c.Ok()
+	case "PUSH":
+		var req struct {
+			Name   string            `json:"name"`
+			Params map[string]string `json:"params"`
+		}
+		err := json.Unmarshal([]byte(payload), &req)
+		if err != nil {
+			c.Error(cmd, newTaggedError("MALFORMED", err))
+			return
+		}
+		job, err := s.PushTemplate(req.Name, req.Params)
+		if err != nil {
+			c.Error(cmd, err)
+			return
+		}
+		c.Result([]byte(job.Jid))
+	default:
+		c.Error(cmd, fmt.Errorf("Invalid TEMPLATE %s", cmd))
+	}
+}
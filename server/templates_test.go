@@ -0,0 +1,62 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplates(t *testing.T) {
+	s, _, stop := newTestServer(t, nil)
+	defer stop()
+
+	assert.Error(t, s.SaveTemplate(&JobTemplate{Name: "no spaces", Type: "Reindex"}))
+	assert.Error(t, s.SaveTemplate(&JobTemplate{Name: "reindex"}))
+
+	var args []interface{}
+	dec := json.NewDecoder(strings.NewReader(`["{{index}}", {"batch": "{{batch|500}}", "note": "by {{who|ops}} for {{index}}"}, 12345678901234567890]`))
+	dec.UseNumber()
+	assert.NoError(t, dec.Decode(&args))
+	assert.NoError(t, s.SaveTemplate(&JobTemplate{Name: "reindex", Type: "Reindex", Queue: "critical", Args: args}))
+	assert.NoError(t, s.SaveTemplate(&JobTemplate{Name: "cleanup", Type: "Cleanup"}))
+
+	list, err := s.Templates()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(list))
+	assert.Equal(t, "cleanup", list[0].Name)
+	assert.Equal(t, []interface{}{}, list[0].Args)
+
+	tmpl, err := s.Template("reindex")
+	assert.NoError(t, err)
+	params := tmpl.Params()
+	assert.Equal(t, 3, len(params))
+	assert.Equal(t, "index", params[0].Name)
+	assert.False(t, params[0].HasDefault)
+	assert.Equal(t, "500", params[1].Default)
+	assert.Equal(t, "who", params[2].Name)
+
+	_, err = tmpl.Job(nil)
+	assert.EqualError(t, err, `Template reindex: Missing parameter "index"`)
+	_, err = tmpl.Job(map[string]string{"index": "users", "bogus": "1"})
+	assert.EqualError(t, err, `Template reindex has no parameter "bogus"`)
+
+	job, err := s.PushTemplate("reindex", map[string]string{"index": "users"})
+	assert.NoError(t, err)
+	assert.Equal(t, "critical", job.Queue)
+	data, err := json.Marshal(job.Args)
+	assert.NoError(t, err)
+	assert.Equal(t, `["users",{"batch":500,"note":"by ops for users"},12345678901234567890]`, string(data))
+	q, err := s.store.GetQueue("critical")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, q.Size())
+
+	_, err = s.PushTemplate("missing", nil)
+	assert.EqualError(t, err, `NOTFOUND No such template "missing"`)
+
+	assert.NoError(t, s.DeleteTemplate("reindex"))
+	tmpl, err = s.Template("reindex")
+	assert.NoError(t, err)
+	assert.Nil(t, tmpl)
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/util"
+)
+
+/*
+ * The server can terminate TLS itself rather than relying on a proxy,
+ * clients connect with the tcp+tls scheme:
+ *
+ * [tls]
+ * cert = "/etc/faktory/tls/cert.pem"
+ * key = "/etc/faktory/tls/key.pem"
+ * client_ca = "/etc/faktory/tls/bundle.pem"   # optional, requires client
+ *                                              # certificates signed by these CAs
+ *
+ * The cert and key may also be set with `[faktory] tls_cert` and
+ * `tls_key` or the -tls-cert and -tls-key flags, which take precedence.
+ *
+ * The files are reloaded when they change so short-lived certificates,
+ * e.g. SPIFFE SVIDs written by the SPIRE agent, rotate without a restart,
+ * and immediately on SIGHUP, which can also enable or disable TLS.
+ */
+
+// how often the files are checked for changes
+var tlsReloadInterval = 10 * time.Second
+
+type tlsFiles struct {
+	cert string
+	key  string
+	ca   string
+
+	mu       sync.Mutex
+	checked  time.Time
+	modified time.Time
+	config   *tls.Config
+}
+
+// newTLSFiles returns nil if TLS isn't configured
+func newTLSFiles(s *Server) (*tlsFiles, error) {
+	tf := &tlsFiles{
+		cert: s.Options.TLSCert,
+		key:  s.Options.TLSKey,
+		ca:   s.Options.String("tls", "client_ca", ""),
+	}
+	if tf.cert == "" {
+		tf.cert = s.Options.String("tls", "cert", s.Options.String("faktory", "tls_cert", ""))
+	}
+	if tf.key == "" {
+		tf.key = s.Options.String("tls", "key", s.Options.String("faktory", "tls_key", ""))
+	}
+	if tf.cert == "" && tf.key == "" {
+		if tf.ca != "" {
+			return nil, fmt.Errorf("[tls] client_ca requires a cert and key")
+		}
+		return nil, nil
+	}
+	if tf.cert == "" || tf.key == "" {
+		return nil, fmt.Errorf("[tls] requires both a cert and key")
+	}
+	err := tf.load()
+	if err != nil {
+		return nil, err
+	}
+	return tf, nil
+}
+
+func (tf *tlsFiles) load() error {
+	cert, err := tls.LoadX509KeyPair(tf.cert, tf.key)
+	if err != nil {
+		return err
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	if tf.ca != "" {
+		data, err := ioutil.ReadFile(tf.ca)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("No certificates found in %s", tf.ca)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	tf.config = client.RestrictTLS(cfg)
+	tf.modified = tf.lastModified()
+	tf.checked = time.Now()
+	return nil
+}
+
+func (tf *tlsFiles) lastModified() time.Time {
+	var latest time.Time
+	for _, path := range []string{tf.cert, tf.key, tf.ca} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// current returns the config, reloading the files if they have
+// changed.  The previous config is kept if they can't be loaded,
+// e.g. when they are caught mid-rotation.
+func (tf *tlsFiles) current(*tls.ClientHelloInfo) (*tls.Config, error) {
+	tf.mu.Lock()
+	defer tf.mu.Unlock()
+
+	if time.Since(tf.checked) >= tlsReloadInterval {
+		tf.checked = time.Now()
+		if tf.lastModified().After(tf.modified) {
+			err := tf.load()
+			if err != nil {
+				util.Warnf("Unable to reload TLS certificates, keeping the previous ones: %v", err)
+			} else {
+				util.Info("Reloaded TLS certificates")
+			}
+		}
+	}
+	return tf.config, nil
+}
+
+// reload rereads the config and the files now rather than
+// waiting for them to change, e.g. on SIGHUP
+func (tf *tlsFiles) reload(s *Server) error {
+	next, err := newTLSFiles(s)
+	if err != nil {
+		return err
+	}
+	if next == nil {
+		return fmt.Errorf("TLS can't be disabled without a restart")
+	}
+
+	tf.mu.Lock()
+	defer tf.mu.Unlock()
+	tf.cert, tf.key, tf.ca = next.cert, next.key, next.ca
+	tf.config = next.config
+	tf.modified = next.modified
+	tf.checked = next.checked
+	return nil
+}
+
+func (tf *tlsFiles) serverConfig() *tls.Config {
+	return &tls.Config{GetConfigForClient: tf.current}
+}
+
+// reloadTLS rereads the TLS config on reload.  TLS may be
+// enabled or disabled too, affecting new connections only.
+func (s *Server) reloadTLS() error {
+	if s.certs != nil {
+		err := s.certs.reload(s)
+		if err == nil {
+			util.Info("Reloaded TLS certificates")
+			return nil
+		}
+	}
+
+	tf, err := newTLSFiles(s)
+	if err != nil {
+		return err
+	}
+	if tf == nil && s.certs == nil {
+		return nil
+	}
+	if _, ok := s.Options.GlobalConfig["spiffe"]; ok && tf == nil {
+		return fmt.Errorf("[spiffe] requires [tls] with a client_ca trust bundle")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if tf == nil {
+		err = s.requireTLS(s.auth, false)
+		if err != nil {
+			return err
+		}
+		util.Warn("TLS disabled, clients must now connect with tcp://")
+		s.tls, s.certs = nil, nil
+		return nil
+	}
+	util.Info("TLS enabled, clients must now connect with tcp+tls://")
+	s.tls, s.certs = tf.serverConfig(), tf
+	return nil
+}
+
+func (s *Server) tlsConfig() *tls.Config {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tls
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/manager"
+	"github.com/contribsys/faktory/util"
+	"github.com/go-redis/redis"
+)
+
+/*
+ * Tracking records the state of jobs which set the "track" custom
+ * attribute so clients can poll for completion with TRACK GET.
+ * While a job runs its worker may report its progress, a percent
+ * complete and a message for the user, with TRACK SET:
+ *
+ *   TRACK SET {"jid":"123456","percent":40,"desc":"Exported 400 of 1000 rows"}
+ *
+ * Progress is reset whenever the job changes state, a job which
+ * succeeds is 100% complete.  Status records expire after the
+ * configured TTL, which TRACK SET renews:
+ *
+ * [tracking]
+ * ttl = 1800     # seconds
+ */
+type tracker struct {
+	rclient *redis.Client
+	ttl     time.Duration
+}
+
+func TrackingSubsystem() Subsystem {
+	return &tracker{}
+}
+
+func (t *tracker) Start(s *Server) error {
+	t.rclient = s.Manager().Redis()
+	t.configure(s)
+
+	s.Manager().AddMiddleware("push", t.middleware(constantState(client.StateEnqueued)))
+	s.Manager().AddMiddleware("fetch", t.middleware(constantState(client.StateWorking)))
+	s.Manager().AddMiddleware("ack", t.middleware(constantState(client.StateSuccess)))
+	s.Manager().AddMiddleware("fail", t.middleware(failedState))
+	return nil
+}
+
+func (t *tracker) Reload(s *Server) error {
+	t.configure(s)
+	return nil
+}
+
+func (t *tracker) configure(s *Server) {
+	t.ttl = time.Duration(s.Options.Int("tracking", "ttl", 30*60)) * time.Second
+}
+
+func trackKey(jid string) string {
+	return "track-" + jid
+}
+
+func tracked(job *client.Job) bool {
+	val, ok := job.GetCustom(client.TrackAttribute)
+	if !ok {
+		return false
+	}
+	switch v := val.(type) {
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case int:
+		return v != 0
+	default:
+		return false
+	}
+}
+
+func (t *tracker) record(job *client.Job, state string) {
+	if !tracked(job) {
+		return
+	}
+	status := &client.JobStatus{Jid: job.Jid, State: state}
+	if state == client.StateSuccess {
+		status.Percent = 100
+	}
+	err := t.save(status)
+	if err != nil {
+		util.ForJob(job.Jid, job.Queue).Warnf("Unable to track %s: %v", job.Jid, err)
+	}
+}
+
+func (t *tracker) save(status *client.JobStatus) error {
+	status.UpdatedAt = util.Nows()
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	return t.rclient.Set(trackKey(status.Jid), data, t.ttl).Err()
+}
+
+// progress records the progress reported by the job's worker,
+// a job which isn't tracked is assumed to be working
+func (t *tracker) progress(jid string, percent int, desc string) error {
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("Percent must be between 0 and 100, not %d", percent)
+	}
+	status, err := t.status(jid)
+	if err != nil {
+		return err
+	}
+	if status.State == client.StateUnknown {
+		status.State = client.StateWorking
+	}
+	status.Percent = percent
+	status.Desc = desc
+	return t.save(status)
+}
+
+// middleware records the job's new state once the operation
+// has succeeded
+func (t *tracker) middleware(state func(*client.Job) string) manager.MiddlewareFunc {
+	return func(next func() error, ctx manager.Context) error {
+		err := next()
+		if err == nil {
+			t.record(ctx.Job(), state(ctx.Job()))
+		}
+		return err
+	}
+}
+
+func constantState(state string) func(*client.Job) string {
+	return func(*client.Job) string { return state }
+}
+
+// failedState is called after the retry count has been incremented,
+// a job which has exhausted its retries is dead.
+func failedState(job *client.Job) string {
+	if job.Failure != nil && job.Failure.RetryCount < job.Retry {
+		return client.StateRetrying
+	}
+	return client.StateDead
+}
+
+func (t *tracker) status(jid string) (*client.JobStatus, error) {
+	data, err := t.rclient.Get(trackKey(jid)).Bytes()
+	if err == redis.Nil {
+		return &client.JobStatus{Jid: jid, State: client.StateUnknown}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var status client.JobStatus
+	err = json.Unmarshal(data, &status)
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (s *Server) tracker() *tracker {
+	for _, x := range s.Subsystems {
+		if t, ok := x.(*tracker); ok {
+			return t
+		}
+	}
+	return nil
+}
+
+func track(c *Connection, s *Server, cmd string) {
+	t := s.tracker()
+	if t == nil {
+		c.Error(cmd, fmt.Errorf("Tracking is not enabled"))
+		return
+	}
+
+	var payload struct {
+		Jid     string `json:"jid"`
+		Percent int    `json:"percent"`
+		Desc    string `json:"desc"`
+	}
+	if len(cmd) < 10 || (cmd[0:10] != "TRACK GET " && cmd[0:10] != "TRACK SET ") {
+		c.Error(cmd, fmt.Errorf("Invalid TRACK %s", cmd))
+		return
+	}
+	err := json.Unmarshal([]byte(cmd[10:]), &payload)
+	if err != nil || payload.Jid == "" {
+		c.Error(cmd, fmt.Errorf("Invalid TRACK %s", cmd))
+		return
+	}
+
+	if cmd[6:9] == "SET" {
+		err = t.progress(payload.Jid, payload.Percent, payload.Desc)
+		if err != nil {
+			c.Error(cmd, err)
+			return
+		}
+		c.Ok()
+		return
+	}
+
+	status, err := t.status(payload.Jid)
+	if err != nil {
+		c.Error(cmd, err)
+		return
+	}
+	res, err := json.Marshal(status)
+	if err != nil {
+		c.Error(cmd, err)
+		return
+	}
+	c.Result(res)
+}
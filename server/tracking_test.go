@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracking(t *testing.T) {
+	job := client.NewJob("Tracked", 1)
+	assert.False(t, tracked(job))
+	job.Track()
+	assert.True(t, tracked(job))
+	job.SetCustom(client.TrackAttribute, float64(0))
+	assert.False(t, tracked(job))
+	job.SetCustom(client.TrackAttribute, "yes")
+	assert.False(t, tracked(job))
+
+	job.Retry = 2
+	job.Failure = &client.Failure{RetryCount: 1}
+	assert.Equal(t, client.StateRetrying, failedState(job))
+	job.Failure.RetryCount = 2
+	assert.Equal(t, client.StateDead, failedState(job))
+
+	s := &Server{}
+	assert.Nil(t, s.tracker())
+	s.Register(TrackingSubsystem())
+	assert.NotNil(t, s.tracker())
+}
+
+func TestTrackProgress(t *testing.T) {
+	s, _, stop := newTestServer(t, nil)
+	defer stop()
+	s.Register(TrackingSubsystem())
+	tr := s.tracker()
+	assert.NoError(t, tr.Start(s))
+
+	job := client.NewJob("Export", 1)
+	job.Track()
+	assert.NoError(t, s.manager.Push(job))
+	_, err := s.manager.Fetch(context.Background(), "", "default")
+	assert.NoError(t, err)
+
+	assert.Error(t, tr.progress(job.Jid, 101, ""))
+	assert.NoError(t, tr.progress(job.Jid, 40, "Exported 400 of 1000 rows"))
+	status, err := tr.status(job.Jid)
+	assert.NoError(t, err)
+	assert.Equal(t, client.StateWorking, status.State)
+	assert.Equal(t, 40, status.Percent)
+	assert.Equal(t, "Exported 400 of 1000 rows", status.Desc)
+
+	// progress is reset by the next state
+	_, err = s.manager.Acknowledge(job.Jid)
+	assert.NoError(t, err)
+	status, err = tr.status(job.Jid)
+	assert.NoError(t, err)
+	assert.Equal(t, client.StateSuccess, status.State)
+	assert.Equal(t, 100, status.Percent)
+	assert.Empty(t, status.Desc)
+
+	// a job which isn't tracked is assumed to be working
+	assert.NoError(t, tr.progress("untracked", 5, ""))
+	status, err = tr.status("untracked")
+	assert.NoError(t, err)
+	assert.Equal(t, client.StateWorking, status.State)
+}
@@ -0,0 +1,294 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/contribsys/faktory/manager"
+)
+
+/*
+ * Transforms rewrite the args of jobs as they're pushed so policies,
+ * e.g. no plaintext emails in Redis, are enforced whatever the
+ * producer sends:
+ *
+ * [transforms.SendInvoice]
+ * strip = ["password", "card_number"]   # removed from hashes in the args
+ * hash = ["email"]                      # replaced by a SHA-256 hash
+ * truncate = 1000                       # longest string, in characters
+ *
+ * [transforms."Import*"]
+ * hash = { fields = ["ssn"], key = "..." }   # HMAC-SHA256 with the key
+ *
+ * Fields are matched by name at any depth within the args.  The
+ * section name may be a pattern, an exact jobtype is preferred over a
+ * pattern.  Hashes are hex prefixed with "sha256:" and aren't truncated.
+ * Jobs the server enqueues again, retries and scheduled jobs, aren't
+ * transformed a second time.  The transforms run in order of name and
+ * before args are encrypted.
+ * Other transforms can be added with RegisterArgsTransform.
+ */
+
+// ArgsTransform rewrites a value within a job's args, returning
+// the new value and false if the field should be removed.
+type ArgsTransform func(field string, value interface{}) (interface{}, bool)
+
+// ArgsTransformFunc returns the transform for its setting in
+// a [transforms.<jobtype>] section.
+type ArgsTransformFunc func(setting interface{}) (ArgsTransform, error)
+
+const hashPrefix = "sha256:"
+
+// hashed marks a value the hash transform produced so later
+// transforms in the same push leave it alone.
+type hashed string
+
+var (
+	transformsMu    sync.Mutex
+	transformsKnown = map[string]ArgsTransformFunc{
+		"strip":    newStripTransform,
+		"hash":     newHashTransform,
+		"truncate": newTruncateTransform,
+	}
+)
+
+// RegisterArgsTransform makes a transform available as
+// name = <setting> in [transforms.<jobtype>] sections.
+func RegisterArgsTransform(name string, fn ArgsTransformFunc) {
+	transformsMu.Lock()
+	defer transformsMu.Unlock()
+	transformsKnown[name] = fn
+}
+
+func fieldSet(name string, setting interface{}) (map[string]bool, error) {
+	list, ok := setting.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s must be a list of field names", name)
+	}
+	fields := map[string]bool{}
+	for _, val := range list {
+		field, ok := val.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s must be a list of field names, not %v", name, val)
+		}
+		fields[field] = true
+	}
+	return fields, nil
+}
+
+func newStripTransform(setting interface{}) (ArgsTransform, error) {
+	fields, err := fieldSet("strip", setting)
+	if err != nil {
+		return nil, err
+	}
+	return func(field string, value interface{}) (interface{}, bool) {
+		return value, !fields[field]
+	}, nil
+}
+
+func newHashTransform(setting interface{}) (ArgsTransform, error) {
+	var key []byte
+	if mapp, ok := setting.(map[string]interface{}); ok {
+		if val, ok := mapp["key"].(string); ok {
+			key = []byte(val)
+		}
+		setting = mapp["fields"]
+	}
+	fields, err := fieldSet("hash", setting)
+	if err != nil {
+		return nil, err
+	}
+	return func(field string, value interface{}) (interface{}, bool) {
+		if !fields[field] || value == nil {
+			return value, true
+		}
+		str, ok := value.(string)
+		if !ok {
+			str = fmt.Sprintf("%v", value)
+		}
+		var sum []byte
+		if key != nil {
+			mac := hmac.New(sha256.New, key)
+			mac.Write([]byte(str))
+			sum = mac.Sum(nil)
+		} else {
+			digest := sha256.Sum256([]byte(str))
+			sum = digest[:]
+		}
+		return hashed(hashPrefix + hex.EncodeToString(sum)), true
+	}, nil
+}
+
+func newTruncateTransform(setting interface{}) (ArgsTransform, error) {
+	max, err := strconv.Atoi(fmt.Sprintf("%v", setting))
+	if err != nil || max < 1 {
+		return nil, fmt.Errorf("truncate must be a positive number of characters, not %v", setting)
+	}
+	return func(field string, value interface{}) (interface{}, bool) {
+		str, ok := value.(string)
+		if !ok || utf8.RuneCountInString(str) <= max {
+			return value, true
+		}
+		return string([]rune(str)[:max]), true
+	}, nil
+}
+
+type transformRule struct {
+	pattern    string
+	transforms []ArgsTransform
+}
+
+type transformer struct {
+	mu    sync.RWMutex
+	rules []*transformRule
+}
+
+func TransformsSubsystem() Subsystem {
+	return &transformer{}
+}
+
+func (t *transformer) Start(s *Server) error {
+	err := t.configure(s)
+	if err != nil {
+		return err
+	}
+
+	s.Manager().AddMiddleware("push", t.push)
+	s.Manager().AddMiddleware("schedule", t.push)
+	return nil
+}
+
+func (t *transformer) Reload(s *Server) error {
+	return t.configure(s)
+}
+
+func (t *transformer) configure(s *Server) error {
+	rules, err := parseTransforms(s.Options.GlobalConfig["transforms"])
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rules = rules
+	return nil
+}
+
+func parseTransforms(config interface{}) ([]*transformRule, error) {
+	rules := []*transformRule{}
+	if config == nil {
+		return rules, nil
+	}
+	mapp, ok := config.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Invalid transforms configuration")
+	}
+
+	transformsMu.Lock()
+	defer transformsMu.Unlock()
+	for pattern, val := range mapp {
+		cfg, ok := val.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Invalid configuration for %s transforms", pattern)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Invalid jobtype pattern %q for transforms", pattern)
+		}
+
+		names := make([]string, 0, len(cfg))
+		for name := range cfg {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		rule := &transformRule{pattern: pattern}
+		for _, name := range names {
+			fn, ok := transformsKnown[name]
+			if !ok {
+				return nil, fmt.Errorf("Unknown transform %q for %s", name, pattern)
+			}
+			tr, err := fn(cfg[name])
+			if err != nil {
+				return nil, fmt.Errorf("Invalid %s transforms: %v", pattern, err)
+			}
+			rule.transforms = append(rule.transforms, tr)
+		}
+		rules = append(rules, rule)
+	}
+
+	// exact jobtypes first, then patterns in a stable order
+	sort.Slice(rules, func(i, j int) bool {
+		iexact, jexact := isLiteral(rules[i].pattern), isLiteral(rules[j].pattern)
+		if iexact != jexact {
+			return iexact
+		}
+		return rules[i].pattern < rules[j].pattern
+	})
+	return rules, nil
+}
+
+func (t *transformer) rule(jobtype string) *transformRule {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, rule := range t.rules {
+		if ok, _ := path.Match(rule.pattern, jobtype); ok {
+			return rule
+		}
+	}
+	return nil
+}
+
+func (t *transformer) push(next func() error, ctx manager.Context) error {
+	job := ctx.Job()
+	if manager.Reenqueued(ctx) {
+		return next()
+	}
+	if rule := t.rule(job.Type); rule != nil {
+		for _, tr := range rule.transforms {
+			for idx, arg := range job.Args {
+				job.Args[idx], _ = transformValue(tr, "", arg)
+			}
+		}
+		for idx, arg := range job.Args {
+			job.Args[idx], _ = transformValue(unmarkHashes, "", arg)
+		}
+	}
+	return next()
+}
+
+func unmarkHashes(field string, value interface{}) (interface{}, bool) {
+	if val, ok := value.(hashed); ok {
+		return string(val), true
+	}
+	return value, true
+}
+
+// transformValue applies the transform to the value and, if it's
+// a hash or an array, everything within it
+func transformValue(tr ArgsTransform, field string, value interface{}) (interface{}, bool) {
+	value, keep := tr(field, value)
+	if !keep {
+		return nil, false
+	}
+	switch val := value.(type) {
+	case map[string]interface{}:
+		for key, elm := range val {
+			newval, keep := transformValue(tr, key, elm)
+			if !keep {
+				delete(val, key)
+				continue
+			}
+			val[key] = newval
+		}
+	case []interface{}:
+		for idx, elm := range val {
+			val[idx], _ = transformValue(tr, field, elm)
+		}
+	}
+	return value, true
+}
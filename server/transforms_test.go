@@ -0,0 +1,106 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTransforms(t *testing.T) {
+	_, err := parseTransforms(map[string]interface{}{"Email": map[string]interface{}{"explode": true}})
+	assert.EqualError(t, err, `Unknown transform "explode" for Email`)
+	_, err = parseTransforms(map[string]interface{}{"Email": map[string]interface{}{"truncate": 0}})
+	assert.Error(t, err)
+	_, err = parseTransforms(map[string]interface{}{"Email": map[string]interface{}{"strip": "password"}})
+	assert.Error(t, err)
+	_, err = parseTransforms(map[string]interface{}{"[Email": map[string]interface{}{}})
+	assert.Error(t, err)
+
+	rules, err := parseTransforms(map[string]interface{}{
+		"*":         map[string]interface{}{},
+		"Email*":    map[string]interface{}{},
+		"EmailUser": map[string]interface{}{},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "EmailUser", rules[0].pattern)
+	assert.Equal(t, "*", rules[1].pattern)
+	assert.Equal(t, "Email*", rules[2].pattern)
+}
+
+func TestTransforms(t *testing.T) {
+	opts := &ServerOptions{GlobalConfig: map[string]interface{}{
+		"transforms": map[string]interface{}{
+			"Signup": map[string]interface{}{
+				"strip":    []interface{}{"password"},
+				"hash":     []interface{}{"email"},
+				"truncate": 5,
+			},
+			"Import*": map[string]interface{}{
+				"hash": map[string]interface{}{"fields": []interface{}{"ssn"}, "key": "secret"},
+			},
+		},
+	}}
+	s, _, stop := newTestServer(t, opts)
+	defer stop()
+	tr := TransformsSubsystem()
+	assert.NoError(t, tr.Start(s))
+
+	sum := sha256.Sum256([]byte("mike@example.com"))
+	hashed := "sha256:" + hex.EncodeToString(sum[:])
+
+	job := client.NewJob("Signup", map[string]interface{}{
+		"email":    "mike@example.com",
+		"password": "hunter2",
+		"users":    []interface{}{map[string]interface{}{"email": "mike@example.com", "password": "x"}},
+	}, "résumé text", 12)
+	assert.NoError(t, s.manager.Push(job))
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{
+			"email": hashed,
+			"users": []interface{}{map[string]interface{}{"email": hashed}},
+		},
+		"résum", 12,
+	}, job.Args)
+
+	// jobs the server enqueues again aren't transformed again
+	assert.NoError(t, s.manager.PushFirst(job))
+	assert.Equal(t, hashed, job.Args[0].(map[string]interface{})["email"])
+	assert.Equal(t, "résum", job.Args[1])
+
+	// but a producer can't skip them by sending a hash
+	rehashed := sha256.Sum256([]byte(hashed))
+	job = client.NewJob("Signup", map[string]interface{}{"email": hashed}, hashed)
+	assert.NoError(t, s.manager.Push(job))
+	assert.Equal(t, "sha256:"+hex.EncodeToString(rehashed[:]), job.Args[0].(map[string]interface{})["email"])
+	assert.Equal(t, "sha25", job.Args[1])
+
+	job = client.NewJob("ImportUsers", map[string]interface{}{"ssn": 123456789, "email": "mike@example.com"})
+	assert.NoError(t, s.manager.Push(job))
+	args := job.Args[0].(map[string]interface{})
+	assert.Equal(t, "mike@example.com", args["email"])
+	assert.Contains(t, args["ssn"], "sha256:")
+	assert.NotEqual(t, hashed, args["ssn"])
+
+	job = client.NewJob("Other", map[string]interface{}{"password": "hunter2"})
+	assert.NoError(t, s.manager.Push(job))
+	assert.Equal(t, "hunter2", job.Args[0].(map[string]interface{})["password"])
+
+	RegisterArgsTransform("redact", func(setting interface{}) (ArgsTransform, error) {
+		return func(field string, value interface{}) (interface{}, bool) {
+			if field == setting {
+				return "[redacted]", true
+			}
+			return value, true
+		}, nil
+	})
+	opts.GlobalConfig["transforms"] = map[string]interface{}{
+		"Other": map[string]interface{}{"redact": "password"},
+	}
+	assert.NoError(t, tr.Reload(s))
+	job = client.NewJob("Other", map[string]interface{}{"password": "hunter2"})
+	assert.NoError(t, s.manager.Push(job))
+	assert.Equal(t, "[redacted]", job.Args[0].(map[string]interface{})["password"])
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/manager"
+	"github.com/contribsys/faktory/util"
+	"github.com/go-redis/redis"
+)
+
+/*
+ * Unique jobs stop producers from pushing the same job twice.  Jobs opt
+ * in with the "unique_for" custom attribute, in seconds:
+ *
+ *   "unique_for": 600, "unique_until": "start"
+ *
+ * Pushing a job takes a lock on its jobtype and args, a push of a job
+ * with the same jobtype and args while the lock is held is rejected
+ * with NOTUNIQUE.  The lock is released once the job succeeds, or is
+ * fetched with "unique_until": "start", once it won't be retried or
+ * after unique_for, counted from the time a scheduled job is due.
+ * Rather than rejecting duplicates the server can drop them, responding
+ * as if they'd been pushed:
+ *
+ * [unique]
+ * duplicates = "reject"    # or "drop"
+ *
+ * Retries and scheduled jobs aren't checked again when the server
+ * enqueues them, every push from a producer is checked, even one
+ * reusing the JID of a job holding the lock.  The lock a job took is
+ * recorded in Redis by JID so it can be released later.
+ */
+const (
+	uniqueReject = "reject"
+	uniqueDrop   = "drop"
+
+	// JID => key of the lock the job took when it was pushed
+	uniqueJobPrefix = "unique-job:"
+)
+
+// how long a job's lock is remembered after its window, long
+// enough for the default 25 retries
+var uniqueRetention = 30 * 24 * time.Hour
+
+type uniqueness struct {
+	rclient *redis.Client
+	drop    bool
+}
+
+func UniqueSubsystem() Subsystem {
+	return &uniqueness{}
+}
+
+func (u *uniqueness) Start(s *Server) error {
+	u.rclient = s.Manager().Redis()
+	err := u.configure(s)
+	if err != nil {
+		return err
+	}
+
+	s.Manager().AddMiddleware("push", u.push)
+	s.Manager().AddMiddleware("schedule", u.push)
+	s.Manager().AddMiddleware("fetch", u.fetch)
+	s.Manager().AddMiddleware("ack", u.ack)
+	s.Manager().AddMiddleware("fail", u.fail)
+	return nil
+}
+
+func (u *uniqueness) Reload(s *Server) error {
+	return u.configure(s)
+}
+
+func (u *uniqueness) configure(s *Server) error {
+	mode := s.Options.String("unique", "duplicates", uniqueReject)
+	if mode != uniqueReject && mode != uniqueDrop {
+		return fmt.Errorf("Invalid [unique] duplicates %q, must be %q or %q", mode, uniqueReject, uniqueDrop)
+	}
+	u.drop = mode == uniqueDrop
+	return nil
+}
+
+// uniqueOptions returns how long the job is unique for and when its
+// lock is released, 0 if the job isn't unique
+func uniqueOptions(job *client.Job) (time.Duration, string) {
+	var window time.Duration
+	val, _ := job.GetCustom(client.UniqueForAttribute)
+	switch v := val.(type) {
+	case float64:
+		window = time.Duration(v * float64(time.Second))
+	case int:
+		window = time.Duration(v) * time.Second
+	}
+	until, _ := job.GetCustom(client.UniqueUntilAttribute)
+	if until == client.UniqueUntilStart {
+		return window, client.UniqueUntilStart
+	}
+	return window, client.UniqueUntilSuccess
+}
+
+// uniqueLock returns the key of the lock the job took when it
+// was pushed, "" if it didn't take one
+func (u *uniqueness) uniqueLock(jid string) (string, error) {
+	key, err := u.rclient.Get(uniqueJobPrefix + jid).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return key, err
+}
+
+func (u *uniqueness) push(next func() error, ctx manager.Context) error {
+	job := ctx.Job()
+	window, _ := uniqueOptions(job)
+	if window <= 0 {
+		return next()
+	}
+	if manager.Reenqueued(ctx) {
+		return next()
+	}
+
+	hash, err := argsHash(job)
+	if err != nil {
+		return err
+	}
+	if job.At != "" {
+		at, err := util.ParseTime(job.At)
+		if err == nil && at.After(time.Now()) {
+			window += time.Until(at)
+		}
+	}
+	key := "unique:" + job.Type + ":" + hash
+	locked, err := u.rclient.SetNX(key, job.Jid, window).Result()
+	if err != nil {
+		return err
+	}
+	if !locked {
+		if u.drop {
+			util.ForJob(job.Jid, job.Queue).Debugf("Dropped duplicate of %s", job.Type)
+			return nil
+		}
+		return newTaggedError("NOTUNIQUE", fmt.Errorf("Job %s with the same args is already pending", job.Type))
+	}
+
+	err = u.rclient.Set(uniqueJobPrefix+job.Jid, key, window+uniqueRetention).Err()
+	if err != nil {
+		u.rclient.Del(key)
+		return err
+	}
+	err = next()
+	if err != nil {
+		u.finish(job)
+	}
+	return err
+}
+
+// release unlocks the job's jobtype and args, if the job holds the lock
+func (u *uniqueness) release(job *client.Job) {
+	key, err := u.uniqueLock(job.Jid)
+	if err == nil && key != "" {
+		var holder string
+		holder, err = u.rclient.Get(key).Result()
+		if err == nil && holder == job.Jid {
+			err = u.rclient.Del(key).Err()
+		}
+	}
+	if err != nil && err != redis.Nil {
+		util.ForJob(job.Jid, job.Queue).Warnf("Unable to release unique lock %s: %v", key, err)
+	}
+}
+
+// finish releases the job's lock and forgets it, the job
+// won't be enqueued again
+func (u *uniqueness) finish(job *client.Job) {
+	u.release(job)
+	err := u.rclient.Del(uniqueJobPrefix + job.Jid).Err()
+	if err != nil {
+		util.ForJob(job.Jid, job.Queue).Warnf("Unable to forget unique lock: %v", err)
+	}
+}
+
+func (u *uniqueness) fetch(next func() error, ctx manager.Context) error {
+	err := next()
+	job := ctx.Job()
+	if _, until := uniqueOptions(job); err == nil && until == client.UniqueUntilStart {
+		u.release(job)
+	}
+	return err
+}
+
+func (u *uniqueness) ack(next func() error, ctx manager.Context) error {
+	err := next()
+	if err == nil {
+		u.finish(ctx.Job())
+	}
+	return err
+}
+
+func (u *uniqueness) fail(next func() error, ctx manager.Context) error {
+	err := next()
+	job := ctx.Job()
+	if err == nil && (job.Retry == 0 || job.Failure.RetryCount >= job.Retry) {
+		u.finish(job)
+	}
+	return err
+}
+
+// releaseUnpushed unlocks the jobs of a bulk push which weren't
+// written after passing through the middleware
+func (s *Server) releaseUnpushed(jobs []*client.Job, failed map[string]error) {
+	u := s.uniqueness()
+	if u == nil || len(failed) == 0 {
+		return
+	}
+	for _, job := range jobs {
+		if failed[job.Jid] != nil {
+			u.finish(job)
+		}
+	}
+}
+
+func (s *Server) uniqueness() *uniqueness {
+	for _, x := range s.Subsystems {
+		if u, ok := x.(*uniqueness); ok {
+			return u
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/manager"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnique(t *testing.T) {
+	opts := &ServerOptions{GlobalConfig: map[string]interface{}{}}
+	s, _, stop := newTestServer(t, opts)
+	defer stop()
+	s.Register(UniqueSubsystem())
+	u := s.uniqueness()
+	assert.NoError(t, u.Start(s))
+
+	unique := func(until string, args ...interface{}) *client.Job {
+		job := client.NewJob("Sync", args...)
+		job.Queue = "unique"
+		job.SetUniqueFor(time.Minute, until)
+		return job
+	}
+	fetch := func() *client.Job {
+		job, err := s.manager.Fetch(context.Background(), "", "unique")
+		assert.NoError(t, err)
+		return job
+	}
+
+	first := unique(client.UniqueUntilSuccess, 1)
+	assert.NoError(t, s.manager.Push(first))
+	err := s.manager.Push(unique(client.UniqueUntilSuccess, 1))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "NOTUNIQUE")
+	assert.NoError(t, s.manager.Push(unique(client.UniqueUntilSuccess, 2)))
+	assert.NoError(t, s.manager.Push(client.NewJob("Sync", 1)))
+
+	// producers can't claim to hold the lock
+	forged := unique(client.UniqueUntilSuccess, 1)
+	forged.SetCustom("_unique", "unique:Sync:forged")
+	assert.Error(t, s.manager.Push(forged))
+
+	// locked until it succeeds
+	assert.Equal(t, first.Jid, fetch().Jid)
+	assert.Error(t, s.manager.Push(unique(client.UniqueUntilSuccess, 1)))
+	_, err = s.manager.Acknowledge(first.Jid)
+	assert.NoError(t, err)
+	assert.NoError(t, s.manager.Push(unique(client.UniqueUntilSuccess, 1)))
+	assert.EqualValues(t, 0, s.store.Redis().Exists(uniqueJobPrefix+first.Jid).Val())
+
+	// retries keep the lock, it's released once the job dies
+	s.store.Flush()
+	job := unique(client.UniqueUntilSuccess, 3)
+	job.Retry = 1
+	assert.NoError(t, s.manager.Push(job))
+	fetch()
+	assert.NoError(t, s.manager.Fail(&manager.FailPayload{Jid: job.Jid, ErrorMessage: "boom"}))
+	assert.EqualValues(t, 1, s.store.Retries().Size())
+	assert.Error(t, s.manager.Push(unique(client.UniqueUntilSuccess, 3)))
+
+	job = unique(client.UniqueUntilSuccess, 5)
+	job.Retry = 0
+	assert.NoError(t, s.manager.Push(job))
+	fetch()
+	assert.NoError(t, s.manager.Fail(&manager.FailPayload{Jid: job.Jid, ErrorMessage: "boom"}))
+	assert.NoError(t, s.manager.Push(unique(client.UniqueUntilSuccess, 5)))
+
+	// locked until it starts
+	s.store.Flush()
+	job = unique(client.UniqueUntilStart, 4)
+	assert.NoError(t, s.manager.Push(job))
+	assert.Error(t, s.manager.Push(unique(client.UniqueUntilStart, 4)))
+	fetch()
+	assert.NoError(t, s.manager.Push(unique(client.UniqueUntilStart, 4)))
+
+	// dropped rather than rejected
+	opts.GlobalConfig["unique"] = map[string]interface{}{"duplicates": "drop"}
+	assert.NoError(t, u.Reload(s))
+	assert.NoError(t, s.manager.Push(unique(client.UniqueUntilStart, 4)))
+	q, err := s.store.GetQueue("unique")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, q.Size())
+
+	// a retry isn't checked again though another job holds the lock
+	assert.NoError(t, s.manager.PushFirst(job))
+	assert.EqualValues(t, 2, q.Size())
+
+	// a push reusing its JID is
+	assert.NoError(t, s.manager.Push(job))
+	assert.EqualValues(t, 2, q.Size())
+	opts.GlobalConfig["unique"] = map[string]interface{}{"duplicates": "reject"}
+	assert.NoError(t, u.Reload(s))
+	err = s.manager.Push(job)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "NOTUNIQUE")
+	assert.EqualValues(t, 2, q.Size())
+
+	opts.GlobalConfig["unique"] = map[string]interface{}{"duplicates": "ignore"}
+	assert.Error(t, u.Reload(s))
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/util"
+	"github.com/go-redis/redis"
+)
+
+/*
+ * Cost attribution counts the jobs pushed, processed and failed per
+ * value of configured custom attributes, e.g. the team or cost center
+ * which pushed them, so each queue's usage can be charged back:
+ *
+ * [metrics]
+ * labels = ["team", "cost_center"]
+ * label_values = 100     # distinct values counted per label each hour
+ * usage_days = 35        # how long the hourly rollups are kept
+ *
+ * A job pushed with {"custom":{"team":"billing"}} is counted under
+ * team=billing in its queue's hourly usage rollup, see the API's usage
+ * field, and the queue dashboard shows the labels pushed most often.
+ * Jobs without the attribute are counted as "none".  Once a label
+ * has label_values distinct values in an hour further values are
+ * counted as "other", so a producer putting IDs in the attribute
+ * can't blow up the metrics.
+ */
+const (
+	// prefix of the per-label push counters
+	metricLabel = "label:"
+
+	labelNone  = "none"
+	labelOther = "other"
+)
+
+// UsageRow is a queue's usage by the jobs with a label's value.
+type UsageRow struct {
+	Label     string
+	Value     string
+	Queue     string
+	Pushed    int64
+	Processed int64
+	Failed    int64
+}
+
+// LabelCount is the number of jobs pushed with a label's value.
+type LabelCount struct {
+	Label string
+	Value string
+	Count int64
+}
+
+type usageLabels struct {
+	names     []string
+	maxValues int
+	retention time.Duration
+
+	// the values counted for each label this hour
+	hour int64
+	seen map[string]map[string]bool
+}
+
+func (m *queueMetrics) configureLabels(s *Server) {
+	names := []string{}
+	if list, ok := s.Options.Config("metrics", "labels", nil).([]interface{}); ok {
+		for _, val := range list {
+			if name, ok := val.(string); ok && name != "" {
+				names = append(names, name)
+			} else {
+				util.Warnf("Config error: metrics/labels must be a list of attribute names, not %v", val)
+			}
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.labels.names = names
+	m.labels.maxValues = s.Options.Int("metrics", "label_values", 100)
+	m.labels.retention = time.Duration(s.Options.Int("metrics", "usage_days", 35)) * 24 * time.Hour
+}
+
+func usageKey(hour int64) string {
+	return fmt.Sprintf("usage:%d", hour)
+}
+
+// the value is last as it may contain anything
+func usageField(metric, queue, label, value string) string {
+	return metric + "|" + queue + "|" + label + "|" + value
+}
+
+// jobLabels returns the job's value for each label, "other" once
+// the label has its limit of values this hour
+func (m *queueMetrics) jobLabels(job *client.Job) map[string]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.labels.names) == 0 {
+		return nil
+	}
+	if hour := m.now().Unix() / 3600; hour != m.labels.hour {
+		m.labels.hour = hour
+		m.labels.seen = map[string]map[string]bool{}
+	}
+
+	values := map[string]string{}
+	for _, name := range m.labels.names {
+		value := labelNone
+		if val, ok := job.GetCustom(name); ok && val != nil {
+			value = fmt.Sprintf("%v", val)
+		}
+		seen := m.labels.seen[name]
+		if seen == nil {
+			seen = map[string]bool{}
+			m.labels.seen[name] = seen
+		}
+		if !seen[value] {
+			if len(seen) >= m.labels.maxValues {
+				value = labelOther
+			} else {
+				seen[value] = true
+			}
+		}
+		values[name] = value
+	}
+	return values
+}
+
+// rollup adds the job to its labels' hourly usage
+func (m *queueMetrics) rollup(job *client.Job, metric string, labels map[string]string) {
+	if len(labels) == 0 {
+		return
+	}
+	m.mu.Lock()
+	retention := m.labels.retention
+	m.mu.Unlock()
+
+	key := usageKey(m.now().Unix() / 3600)
+	_, err := m.rclient.Pipelined(func(pipe redis.Pipeliner) error {
+		for label, value := range labels {
+			pipe.HIncrBy(key, usageField(metric, job.Queue, label, value), 1)
+		}
+		pipe.Expire(key, retention)
+		return nil
+	})
+	if err != nil {
+		util.Warnf("Unable to record usage for %s: %v", job.Queue, err)
+	}
+}
+
+// usage sums the hourly rollups since the given time
+func (m *queueMetrics) usage(since time.Time) ([]*UsageRow, error) {
+	first := since.Unix() / 3600
+	last := m.now().Unix() / 3600
+	var cmds []*redis.StringStringMapCmd
+	_, err := m.rclient.Pipelined(func(pipe redis.Pipeliner) error {
+		for hour := first; hour <= last; hour++ {
+			cmds = append(cmds, pipe.HGetAll(usageKey(hour)))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rows := map[string]*UsageRow{}
+	for _, cmd := range cmds {
+		for field, str := range cmd.Val() {
+			parts := strings.SplitN(field, "|", 4)
+			val, err := strconv.ParseInt(str, 10, 64)
+			if len(parts) != 4 || err != nil {
+				continue
+			}
+			id := strings.Join(parts[1:], "|")
+			row, ok := rows[id]
+			if !ok {
+				row = &UsageRow{Queue: parts[1], Label: parts[2], Value: parts[3]}
+				rows[id] = row
+			}
+			switch parts[0] {
+			case metricPushed:
+				row.Pushed += val
+			case metricProcessed:
+				row.Processed += val
+			case metricFailed:
+				row.Failed += val
+			}
+		}
+	}
+
+	result := make([]*UsageRow, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, row)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Label != b.Label {
+			return a.Label < b.Label
+		}
+		if a.Processed != b.Processed {
+			return a.Processed > b.Processed
+		}
+		if a.Value != b.Value {
+			return a.Value < b.Value
+		}
+		return a.Queue < b.Queue
+	})
+	return result, nil
+}
+
+// Usage returns each queue's usage per label value since the given
+// time, by label and busiest first.  It's nil if the metrics
+// subsystem isn't running.
+func (s *Server) Usage(since time.Time) ([]*UsageRow, error) {
+	m := s.metrics()
+	if m == nil {
+		return nil, nil
+	}
+	m.mu.Lock()
+	oldest := m.now().Add(-m.labels.retention)
+	m.mu.Unlock()
+	if since.Before(oldest) {
+		since = oldest
+	}
+	return m.usage(since)
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsageLabels(t *testing.T) {
+	booted, _, stop := newTestServer(t, nil)
+	defer stop()
+
+	now := time.Date(2018, 6, 1, 12, 30, 0, 0, time.UTC)
+	m := &queueMetrics{
+		rclient:   booted.store.Redis(),
+		store:     booted.store,
+		retention: 1 * time.Hour,
+		now:       func() time.Time { return now },
+		anomalies: newAnomalyDetector(),
+		labels: usageLabels{
+			names:     []string{"team"},
+			maxValues: 2,
+			retention: 24 * time.Hour,
+		},
+	}
+
+	push := func(team interface{}) *client.Job {
+		job := client.NewJob("Report", 1)
+		job.Queue = "usage"
+		if team != nil {
+			job.SetCustom("team", team)
+		}
+		m.pushed(job)
+		return job
+	}
+	job := push("billing")
+	m.counter(metricProcessed)(job)
+	push("billing")
+	push(nil)
+	// the third value this hour
+	push("search")
+	job = push("billing")
+	m.counter(metricFailed)(job)
+
+	dash, err := m.dashboard("usage", 1*time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, []*LabelCount{
+		{"team", "billing", 3},
+		{"team", "none", 1},
+		{"team", "other", 1},
+	}, dash.Labels)
+
+	// the next hour's values are counted afresh
+	now = now.Add(1 * time.Hour)
+	push("search")
+
+	rows, err := m.usage(now.Add(-2 * time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, []*UsageRow{
+		{Label: "team", Value: "billing", Queue: "usage", Pushed: 3, Processed: 1, Failed: 1},
+		{Label: "team", Value: "none", Queue: "usage", Pushed: 1},
+		{Label: "team", Value: "other", Queue: "usage", Pushed: 1},
+		{Label: "team", Value: "search", Queue: "usage", Pushed: 1},
+	}, rows)
+
+	rows, err = m.usage(now)
+	assert.NoError(t, err)
+	assert.Equal(t, []*UsageRow{
+		{Label: "team", Value: "search", Queue: "usage", Pushed: 1},
+	}, rows)
+
+	// no labels configured
+	m.labels.names = nil
+	push("billing")
+	rows, err = m.usage(now)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(rows))
+}
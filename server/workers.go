@@ -54,6 +54,9 @@ type ClientData struct {
 	Pid          int      `json:"pid"`
 	Labels       []string `json:"labels"`
 	PasswordHash string   `json:"pwdhash"`
+	Username     string   `json:"username,omitempty"`
+	Password     string   `json:"password,omitempty"`
+	Token        string   `json:"token,omitempty"`
 	Version      uint8    `json:"v"`
 	StartedAt    time.Time
 
@@ -126,6 +129,8 @@ func (worker *ClientData) IsConsumer() bool {
 type workers struct {
 	heartbeats map[string]*ClientData
 	mu         sync.RWMutex
+	// called with the WID of each reaped worker
+	reaped func(wid string)
 }
 
 func newWorkers() *workers {
@@ -148,6 +153,10 @@ func (w *workers) heartbeat(client *ClientData, cls io.Closer) (*ClientData, boo
 	if ok {
 		w.mu.Lock()
 		entry.lastHeartbeat = time.Now()
+		if cls != nil {
+			// reconnecting, e.g. after a server restart
+			entry.connections[cls] = true
+		}
 		w.mu.Unlock()
 	} else if cls != nil {
 		client.StartedAt = time.Now()
@@ -182,7 +191,14 @@ func (w *workers) reapHeartbeats(t time.Time) int {
 	toDelete := []string{}
 
 	w.mu.Lock()
-	defer w.mu.Unlock()
+	defer func() {
+		w.mu.Unlock()
+		if w.reaped != nil {
+			for _, wid := range toDelete {
+				w.reaped(wid)
+			}
+		}
+	}()
 
 	for k, worker := range w.heartbeats {
 		if worker.lastHeartbeat.Before(t) {
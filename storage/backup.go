@@ -0,0 +1,459 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+var (
+	// BackupTimeout is how long Backup waits for Redis to
+	// finish writing its snapshot.
+	BackupTimeout = 5 * time.Minute
+
+	// rdbChecker verifies the checksums of a backup, if installed
+	rdbChecker = "redis-check-rdb"
+)
+
+const (
+	backupLayout = "20060102-150405"
+	// the embedded Redis' snapshot in the storage directory, see redisconf
+	rdbFilename = "faktory.rdb"
+
+	// encrypted backups are named faktory-<time>.rdb.enc and begin with
+	// the magic and the id of the key, the snapshot follows in chunks
+	// sealed separately so it needn't fit in memory
+	encryptedSuffix = ".enc"
+	backupMagic     = "FAKTORY-BACKUP-1\n"
+	backupChunk     = 64 * 1024
+)
+
+// BackupKeys encrypt backups with AES-256-GCM.  New backups are
+// encrypted with the Current key, any of the Keys, by id, decrypt
+// them so keys can be rotated.  Backups aren't encrypted if
+// Current is empty.
+type BackupKeys struct {
+	Current string
+	Keys    map[string][]byte
+}
+
+func (k *BackupKeys) encrypts() bool {
+	return k != nil && k.Current != ""
+}
+
+func (k *BackupKeys) aead(id string) (cipher.AEAD, error) {
+	var key []byte
+	if k != nil {
+		key = k.Keys[id]
+	}
+	if key == nil {
+		return nil, fmt.Errorf("it was encrypted with the key %q which isn't configured", id)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// BackupDirectory is where backups of the Redis in the given
+// storage directory are kept.
+func BackupDirectory(storageDir string) string {
+	return filepath.Join(storageDir, "backups")
+}
+
+/*
+ * Backup asks Redis for a snapshot with BGSAVE, waits for it to be
+ * written and copies the RDB file into dir, named for the time it was
+ * taken, encrypting it if keys has a current key.  Only the newest keep
+ * backups are retained, 0 keeps them all.  It returns the path of the
+ * new backup.
+ *
+ * Redis must share the filesystem, as the embedded Redis does.
+ */
+func Backup(rclient *redis.Client, dir string, keep int, keys *BackupKeys) (string, error) {
+	// a save already in progress will do
+	err := rclient.BgSave().Err()
+	if err != nil && !strings.Contains(err.Error(), "in progress") {
+		return "", err
+	}
+	err = waitForSave(rclient)
+	if err != nil {
+		return "", err
+	}
+
+	src, err := rdbPath(rclient)
+	if err != nil {
+		return "", err
+	}
+	err = os.MkdirAll(dir, os.ModeDir|0755)
+	if err != nil {
+		return "", err
+	}
+	dest := backupPath(dir, keys)
+	err = writeBackup(src, dest, keys)
+	if err != nil {
+		return "", err
+	}
+	return dest, PruneBackups(dir, keep)
+}
+
+// backupPath names a backup taken now
+func backupPath(dir string, keys *BackupKeys) string {
+	path := filepath.Join(dir, fmt.Sprintf("faktory-%s.rdb", time.Now().Format(backupLayout)))
+	if keys.encrypts() {
+		path += encryptedSuffix
+	}
+	return path
+}
+
+// waitForSave polls until Redis has finished the background save,
+// BGSAVE marks it in progress before returning.
+func waitForSave(rclient *redis.Client) error {
+	deadline := time.Now().Add(BackupTimeout)
+	for time.Now().Before(deadline) {
+		info, err := rclient.Info("persistence").Result()
+		if err != nil {
+			return err
+		}
+		if infoValue(info, "rdb_bgsave_in_progress") != "1" {
+			if infoValue(info, "rdb_last_bgsave_status") != "ok" {
+				return fmt.Errorf("Redis was unable to save a snapshot, see its log")
+			}
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("Redis did not finish its snapshot within %v", BackupTimeout)
+}
+
+func infoValue(info string, key string) string {
+	for _, line := range strings.Split(info, "\n") {
+		line = strings.TrimSpace(line)
+		if val := strings.TrimPrefix(line, key+":"); val != line {
+			return val
+		}
+	}
+	return ""
+}
+
+func rdbPath(rclient *redis.Client) (string, error) {
+	parts := []string{}
+	for _, param := range []string{"dir", "dbfilename"} {
+		vals, err := rclient.ConfigGet(param).Result()
+		if err != nil {
+			return "", err
+		}
+		if len(vals) != 2 {
+			return "", fmt.Errorf("Redis has no %s configured", param)
+		}
+		parts = append(parts, fmt.Sprintf("%v", vals[1]))
+	}
+	return filepath.Join(parts...), nil
+}
+
+func copyFile(src, dest string) error {
+	return transformFile(src, dest, func(out io.Writer, in io.Reader) error {
+		_, err := io.Copy(out, in)
+		return err
+	})
+}
+
+// writeBackup copies the snapshot to dest, encrypting it
+// if dest is an encrypted backup
+func writeBackup(src, dest string, keys *BackupKeys) error {
+	if !isEncrypted(dest) {
+		return copyFile(src, dest)
+	}
+	return transformFile(src, dest, func(out io.Writer, in io.Reader) error {
+		return encryptBackup(out, in, keys)
+	})
+}
+
+// transformFile writes to a temporary file first so a backup
+// which exists is always complete
+func transformFile(src, dest string, fn func(io.Writer, io.Reader) error) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := dest + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	err = fn(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
+
+func isEncrypted(path string) bool {
+	return strings.HasSuffix(path, encryptedSuffix)
+}
+
+// chunkData authenticates the position of each chunk so chunks
+// can't be reordered, dropped or the backup truncated
+func chunkData(header []byte, idx uint64, final byte) []byte {
+	data := make([]byte, len(header)+9)
+	copy(data, header)
+	binary.BigEndian.PutUint64(data[len(header):], idx)
+	data[len(data)-1] = final
+	return data
+}
+
+// encryptBackup writes the header and then each chunk as its
+// final flag, nonce, length and ciphertext
+func encryptBackup(out io.Writer, in io.Reader, keys *BackupKeys) error {
+	aead, err := keys.aead(keys.Current)
+	if err != nil {
+		return err
+	}
+	header := []byte(backupMagic + keys.Current + "\n")
+	_, err = out.Write(header)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, backupChunk)
+	nonce := make([]byte, aead.NonceSize())
+	size := make([]byte, 4)
+	for idx := uint64(0); ; idx++ {
+		count, err := io.ReadFull(in, buf)
+		var final byte
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			final = 1
+		} else if err != nil {
+			return err
+		}
+		_, err = rand.Read(nonce)
+		if err != nil {
+			return err
+		}
+		sealed := aead.Seal(nil, nonce, buf[:count], chunkData(header, idx, final))
+		binary.BigEndian.PutUint32(size, uint32(len(sealed)))
+		for _, part := range [][]byte{{final}, nonce, size, sealed} {
+			_, err = out.Write(part)
+			if err != nil {
+				return err
+			}
+		}
+		if final == 1 {
+			return nil
+		}
+	}
+}
+
+// decryptBackup reverses encryptBackup with the key named
+// in the header
+func decryptBackup(out io.Writer, in io.Reader, keys *BackupKeys) error {
+	r := bufio.NewReader(in)
+	magic := make([]byte, len(backupMagic))
+	_, err := io.ReadFull(r, magic)
+	if err != nil || string(magic) != backupMagic {
+		return fmt.Errorf("it isn't an encrypted backup")
+	}
+	id, err := r.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("it isn't an encrypted backup")
+	}
+	aead, err := keys.aead(strings.TrimSuffix(id, "\n"))
+	if err != nil {
+		return err
+	}
+	header := append(magic, id...)
+
+	nonce := make([]byte, aead.NonceSize())
+	size := make([]byte, 4)
+	for idx := uint64(0); ; idx++ {
+		final, err := r.ReadByte()
+		if err == nil {
+			_, err = io.ReadFull(r, nonce)
+		}
+		if err == nil {
+			_, err = io.ReadFull(r, size)
+		}
+		if err != nil {
+			return fmt.Errorf("it's truncated or corrupt")
+		}
+		length := binary.BigEndian.Uint32(size)
+		if length > backupChunk+uint32(aead.Overhead()) {
+			return fmt.Errorf("it's corrupt")
+		}
+		sealed := make([]byte, length)
+		_, err = io.ReadFull(r, sealed)
+		if err != nil {
+			return fmt.Errorf("it's truncated or corrupt")
+		}
+		plain, err := aead.Open(sealed[:0], nonce, sealed, chunkData(header, idx, final))
+		if err != nil {
+			return fmt.Errorf("it's corrupt or the key %q has changed", strings.TrimSuffix(id, "\n"))
+		}
+		_, err = out.Write(plain)
+		if err != nil {
+			return err
+		}
+		if final == 1 {
+			if _, err := r.ReadByte(); err != io.EOF {
+				return fmt.Errorf("it has data after the end of the snapshot")
+			}
+			return nil
+		}
+	}
+}
+
+// Backups lists the backups in dir, encrypted or not, newest first.
+func Backups(dir string) ([]string, error) {
+	paths := []string{}
+	for _, pattern := range []string{"faktory-*.rdb", "faktory-*.rdb" + encryptedSuffix} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, matches...)
+	}
+	// the timestamps sort chronologically
+	sort.Sort(sort.Reverse(sort.StringSlice(paths)))
+	return paths, nil
+}
+
+// FindBackup resolves the name of a backup to its path: "latest",
+// the name of a file in the storage directory's backups or any
+// other path.
+func FindBackup(storageDir string, name string) (string, error) {
+	dir := BackupDirectory(storageDir)
+	if name == "latest" {
+		paths, err := Backups(dir)
+		if err != nil {
+			return "", err
+		}
+		if len(paths) == 0 {
+			return "", fmt.Errorf("No backups found in %s", dir)
+		}
+		return paths[0], nil
+	}
+	if !strings.ContainsRune(name, filepath.Separator) {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	if _, err := os.Stat(name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// VerifyBackup checks the file is an RDB snapshot, and its
+// checksums with redis-check-rdb if it's installed.
+func VerifyBackup(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	magic := make([]byte, 5)
+	_, err = io.ReadFull(f, magic)
+	f.Close()
+	if err != nil || string(magic) != "REDIS" {
+		return fmt.Errorf("%s is not a Redis snapshot", path)
+	}
+
+	checker, err := exec.LookPath(rdbChecker)
+	if err != nil {
+		return nil
+	}
+	out, err := exec.Command(checker, path).CombinedOutput()
+	if err != nil {
+		lines := bytes.Split(bytes.TrimSpace(out), []byte("\n"))
+		return fmt.Errorf("%s failed the integrity check: %s", path, lines[len(lines)-1])
+	}
+	return nil
+}
+
+/*
+ * Restore replaces the snapshot of the Redis in storageDir with the
+ * backup once it has been decrypted, with any of the keys, and
+ * verified.  The current snapshot is kept as a backup first, encrypted
+ * with the current key, so the restore can be undone, its path is
+ * returned, empty if there was none.  Redis must not be running or it
+ * would overwrite the snapshot when it next saves.
+ */
+func Restore(storageDir string, backup string, keys *BackupKeys) (string, error) {
+	snapshot := backup
+	if isEncrypted(backup) {
+		snapshot = filepath.Join(storageDir, rdbFilename+".restore")
+		err := transformFile(backup, snapshot, func(out io.Writer, in io.Reader) error {
+			return decryptBackup(out, in, keys)
+		})
+		if err != nil {
+			return "", fmt.Errorf("Unable to decrypt %s, %v", backup, err)
+		}
+		defer os.Remove(snapshot)
+	}
+	err := VerifyBackup(snapshot)
+	if err != nil {
+		return "", err
+	}
+
+	current := filepath.Join(storageDir, rdbFilename)
+	previous := ""
+	if _, err := os.Stat(current); err == nil {
+		dir := BackupDirectory(storageDir)
+		err = os.MkdirAll(dir, os.ModeDir|0755)
+		if err != nil {
+			return "", err
+		}
+		previous = backupPath(dir, keys)
+		if previous == backup {
+			// restoring a backup taken this second
+			return "", fmt.Errorf("%s is too recent to restore, try again in a second", backup)
+		}
+		err = writeBackup(current, previous, keys)
+		if err != nil {
+			return "", err
+		}
+	}
+	return previous, copyFile(snapshot, current)
+}
+
+// PruneBackups removes all but the newest keep backups in
+// dir, 0 keeps them all.
+func PruneBackups(dir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	paths, err := Backups(dir)
+	if err != nil || len(paths) <= keep {
+		return err
+	}
+	for _, path := range paths[keep:] {
+		err = os.Remove(path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
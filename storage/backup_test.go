@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "faktory-backups")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "faktory.rdb")
+	assert.NoError(t, ioutil.WriteFile(src, []byte("REDIS0008"), 0644))
+	backups := BackupDirectory(dir)
+	assert.NoError(t, os.MkdirAll(backups, os.ModeDir|0755))
+	for _, stamp := range []string{"20180601-120000", "20180603-120000", "20180602-120000"} {
+		assert.NoError(t, copyFile(src, filepath.Join(backups, "faktory-"+stamp+".rdb")))
+	}
+	// not a backup
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(backups, "notes.txt"), []byte("hi"), 0644))
+
+	paths, err := Backups(backups)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(backups, "faktory-20180603-120000.rdb"),
+		filepath.Join(backups, "faktory-20180602-120000.rdb"),
+		filepath.Join(backups, "faktory-20180601-120000.rdb"),
+	}, paths)
+	data, err := ioutil.ReadFile(paths[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "REDIS0008", string(data))
+
+	assert.NoError(t, PruneBackups(backups, 0))
+	paths, _ = Backups(backups)
+	assert.Equal(t, 3, len(paths))
+
+	assert.NoError(t, PruneBackups(backups, 2))
+	paths, _ = Backups(backups)
+	assert.Equal(t, []string{
+		filepath.Join(backups, "faktory-20180603-120000.rdb"),
+		filepath.Join(backups, "faktory-20180602-120000.rdb"),
+	}, paths)
+	_, err = os.Stat(filepath.Join(backups, "notes.txt"))
+	assert.NoError(t, err)
+
+	// restore the oldest over the current snapshot
+	rdbChecker = "faktory-test-no-such-checker"
+	defer func() { rdbChecker = "redis-check-rdb" }()
+	current := filepath.Join(dir, rdbFilename)
+	assert.NoError(t, ioutil.WriteFile(current, []byte("REDIS0008current"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(backups, "faktory-20180601-120000.rdb"), []byte("REDIS0008oldest"), 0644))
+
+	path, err := FindBackup(dir, "latest")
+	assert.NoError(t, err)
+	assert.Equal(t, paths[0], path)
+	path, err = FindBackup(dir, "faktory-20180601-120000.rdb")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(backups, "faktory-20180601-120000.rdb"), path)
+	_, err = FindBackup(dir, "faktory-20180604-120000.rdb")
+	assert.Error(t, err)
+	_, err = FindBackup(t.Name(), "latest")
+	assert.Error(t, err)
+
+	assert.Error(t, VerifyBackup(filepath.Join(backups, "notes.txt")))
+	_, err = Restore(dir, filepath.Join(backups, "notes.txt"), nil)
+	assert.Error(t, err)
+
+	previous, err := Restore(dir, path, nil)
+	assert.NoError(t, err)
+	data, err = ioutil.ReadFile(current)
+	assert.NoError(t, err)
+	assert.Equal(t, "REDIS0008oldest", string(data))
+	data, err = ioutil.ReadFile(previous)
+	assert.NoError(t, err)
+	assert.Equal(t, "REDIS0008current", string(data))
+
+	info := "# Persistence\r\nrdb_bgsave_in_progress:0\r\nrdb_last_bgsave_status:ok\r\n"
+	assert.Equal(t, "0", infoValue(info, "rdb_bgsave_in_progress"))
+	assert.Equal(t, "ok", infoValue(info, "rdb_last_bgsave_status"))
+	assert.Equal(t, "", infoValue(info, "aof_enabled"))
+}
+
+func TestEncryptedBackups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "faktory-encrypted")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	rdbChecker = "faktory-test-no-such-checker"
+	defer func() { rdbChecker = "redis-check-rdb" }()
+
+	// more than one chunk, ending part way through one
+	snapshot := append([]byte("REDIS0008"), bytes.Repeat([]byte("mike@example.com "), 10000)...)
+	src := filepath.Join(dir, "snapshot.rdb")
+	assert.NoError(t, ioutil.WriteFile(src, snapshot, 0644))
+	backups := BackupDirectory(dir)
+	assert.NoError(t, os.MkdirAll(backups, os.ModeDir|0755))
+
+	old := &BackupKeys{Current: "2018-11", Keys: map[string][]byte{"2018-11": bytes.Repeat([]byte{1}, 32)}}
+	path := filepath.Join(backups, "faktory-20180601-120000.rdb.enc")
+	assert.NoError(t, writeBackup(src, path, old))
+	sealed, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.False(t, bytes.Contains(sealed, []byte("mike@example.com")))
+	paths, err := Backups(backups)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{path}, paths)
+
+	// restored after the key is rotated, the current
+	// snapshot is kept encrypted with the new key
+	rotated := &BackupKeys{Current: "2019-06", Keys: map[string][]byte{
+		"2019-06": bytes.Repeat([]byte{2}, 32),
+		"2018-11": bytes.Repeat([]byte{1}, 32),
+	}}
+	current := filepath.Join(dir, rdbFilename)
+	assert.NoError(t, ioutil.WriteFile(current, []byte("REDIS0008current"), 0644))
+	previous, err := Restore(dir, path, rotated)
+	assert.NoError(t, err)
+	assert.True(t, isEncrypted(previous))
+	data, err := ioutil.ReadFile(current)
+	assert.NoError(t, err)
+	assert.Equal(t, snapshot, data)
+	_, err = os.Stat(filepath.Join(dir, rdbFilename+".restore"))
+	assert.True(t, os.IsNotExist(err))
+
+	var buf bytes.Buffer
+	f, err := os.Open(previous)
+	assert.NoError(t, err)
+	assert.NoError(t, decryptBackup(&buf, f, &BackupKeys{Keys: map[string][]byte{"2019-06": rotated.Keys["2019-06"]}}))
+	f.Close()
+	assert.Equal(t, "REDIS0008current", buf.String())
+
+	// the key must be configured and the backup intact
+	_, err = Restore(dir, path, nil)
+	assert.EqualError(t, err, `Unable to decrypt `+path+`, it was encrypted with the key "2018-11" which isn't configured`)
+	decrypt := func(data []byte) error {
+		return decryptBackup(ioutil.Discard, bytes.NewReader(data), old)
+	}
+	assert.NoError(t, decrypt(sealed))
+	tampered := append([]byte(nil), sealed...)
+	tampered[len(tampered)-1] ^= 1
+	assert.Error(t, decrypt(tampered))
+	assert.Error(t, decrypt(sealed[:len(sealed)-1]))
+	// dropping the final chunk
+	header := len(backupMagic + "2018-11\n")
+	assert.Error(t, decrypt(sealed[:header+1+12+4+backupChunk+16]))
+	assert.Error(t, decrypt(append(sealed, 0)))
+	assert.Error(t, decrypt(snapshot))
+}
@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+)
+
+// Blobs is a simple object store used to hold large job
+// payloads outside of Redis.  Keys are opaque strings
+// generated by Faktory.
+type Blobs interface {
+	Put(key string, data []byte) error
+	// Get returns nil, nil if the key does not exist
+	Get(key string) ([]byte, error)
+	Delete(key string) error
+}
+
+// OpenBlobs returns a Blobs implementation for the given URL:
+//
+//   file:///var/lib/faktory/blobs
+//   https://bucket.example.com/faktory
+//
+// The HTTP implementation issues plain PUT/GET/DELETE requests
+// so it works with any S3 or GCS compatible gateway which
+// accepts bearer token authentication.
+func OpenBlobs(location string, token string) (Blobs, error) {
+	uri, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+
+	switch uri.Scheme {
+	case "file":
+		err := os.MkdirAll(uri.Path, os.ModeDir|0755)
+		if err != nil {
+			return nil, err
+		}
+		return &fileBlobs{dir: uri.Path}, nil
+	case "http", "https":
+		return &httpBlobs{
+			base:   strings.TrimSuffix(location, "/"),
+			token:  token,
+			client: client.HTTPClient(5 * time.Second),
+		}, nil
+	default:
+		return nil, fmt.Errorf("Unsupported blob storage: %s", location)
+	}
+}
+
+type fileBlobs struct {
+	dir string
+}
+
+func (fb *fileBlobs) path(key string) string {
+	return filepath.Join(fb.dir, filepath.Base(key))
+}
+
+func (fb *fileBlobs) Put(key string, data []byte) error {
+	return ioutil.WriteFile(fb.path(key), data, 0644)
+}
+
+func (fb *fileBlobs) Get(key string) ([]byte, error) {
+	data, err := ioutil.ReadFile(fb.path(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (fb *fileBlobs) Delete(key string) error {
+	err := os.Remove(fb.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+type httpBlobs struct {
+	base   string
+	token  string
+	client *http.Client
+}
+
+func (hb *httpBlobs) do(method string, key string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, hb.base+"/"+url.PathEscape(key), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if hb.token != "" {
+		req.Header.Set("Authorization", "Bearer "+hb.token)
+	}
+	return hb.client.Do(req)
+}
+
+func (hb *httpBlobs) Put(key string, data []byte) error {
+	resp, err := hb.do("PUT", key, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Unable to store blob %s: %s", key, resp.Status)
+	}
+	return nil
+}
+
+func (hb *httpBlobs) Get(key string) ([]byte, error) {
+	resp, err := hb.do("GET", key, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("Unable to fetch blob %s: %s", key, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (hb *httpBlobs) Delete(key string) error {
+	resp, err := hb.do("DELETE", key, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("Unable to delete blob %s: %s", key, resp.Status)
+	}
+	return nil
+}
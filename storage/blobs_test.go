@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlobs(t *testing.T) {
+	t.Parallel()
+
+	t.Run("File", func(t *testing.T) {
+		dir := "/tmp/faktory-test-blobs"
+		defer os.RemoveAll(dir)
+
+		blobs, err := OpenBlobs("file://"+dir, "")
+		assert.NoError(t, err)
+		exerciseBlobs(t, blobs)
+	})
+
+	t.Run("HTTP", func(t *testing.T) {
+		var mu sync.Mutex
+		data := map[string][]byte{}
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "Bearer sekrit", r.Header.Get("Authorization"))
+			mu.Lock()
+			defer mu.Unlock()
+			switch r.Method {
+			case "PUT":
+				body, _ := ioutil.ReadAll(r.Body)
+				data[r.URL.Path] = body
+			case "GET":
+				val, ok := data[r.URL.Path]
+				if !ok {
+					http.NotFound(w, r)
+					return
+				}
+				w.Write(val)
+			case "DELETE":
+				delete(data, r.URL.Path)
+			}
+		}))
+		defer ts.Close()
+
+		blobs, err := OpenBlobs(ts.URL+"/bucket/", "sekrit")
+		assert.NoError(t, err)
+		exerciseBlobs(t, blobs)
+	})
+
+	_, err := OpenBlobs("ftp://example.com", "")
+	assert.Error(t, err)
+}
+
+func exerciseBlobs(t *testing.T, blobs Blobs) {
+	val, err := blobs.Get("missing")
+	assert.NoError(t, err)
+	assert.Nil(t, val)
+
+	err = blobs.Put("abc123", []byte(`["hello"]`))
+	assert.NoError(t, err)
+
+	val, err = blobs.Get("abc123")
+	assert.NoError(t, err)
+	assert.Equal(t, `["hello"]`, string(val))
+
+	err = blobs.Delete("abc123")
+	assert.NoError(t, err)
+	err = blobs.Delete("abc123")
+	assert.NoError(t, err)
+
+	val, err = blobs.Get("abc123")
+	assert.NoError(t, err)
+	assert.Nil(t, val)
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/contribsys/faktory/util"
+)
+
+var (
+	// ErrUnavailable is returned by WaitAvailable if Redis does
+	// not come back before the context is done.
+	ErrUnavailable = errors.New("Storage is unavailable")
+
+	// How often Redis is pinged while it's available.  While it's
+	// down we ping more often, backing off to MaxRecoveryInterval.
+	HealthCheckInterval = 1 * time.Second
+	MaxRecoveryInterval = 5 * time.Second
+)
+
+/*
+ * health tracks whether Redis is reachable so the server can
+ * pause FETCH and PUSH during a Redis restart rather than
+ * failing every command.  The Redis client reconnects on its own,
+ * once a ping succeeds again the recovery hooks are run to restore
+ * any state Redis may have lost.
+ */
+type health struct {
+	mu        sync.Mutex
+	available bool
+	ready     chan struct{}
+	hooks     []func() error
+}
+
+func newHealth() *health {
+	h := &health{available: true, ready: make(chan struct{})}
+	close(h.ready)
+	return h
+}
+
+func (h *health) Available() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.available
+}
+
+// WaitAvailable blocks until Redis is available or the
+// context is done.
+func (h *health) WaitAvailable(ctx context.Context) error {
+	h.mu.Lock()
+	ready := h.ready
+	h.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		return ErrUnavailable
+	}
+}
+
+// OnRecovery registers a function to be called each time
+// Redis becomes available again.
+func (h *health) OnRecovery(fn func() error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.hooks = append(h.hooks, fn)
+}
+
+// setAvailable returns true if the availability changed.
+func (h *health) setAvailable(available bool) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.available == available {
+		return false
+	}
+
+	h.available = available
+	if available {
+		close(h.ready)
+	} else {
+		h.ready = make(chan struct{})
+	}
+	return true
+}
+
+func (h *health) recover() {
+	h.mu.Lock()
+	hooks := h.hooks
+	h.mu.Unlock()
+
+	for _, fn := range hooks {
+		err := fn()
+		if err != nil {
+			util.Warnf("Unable to recover after Redis restart: %v", err)
+		}
+	}
+}
+
+func (store *redisStore) monitor(stopper chan struct{}) {
+	delay := HealthCheckInterval
+	for {
+		select {
+		case <-stopper:
+			return
+		case <-time.After(delay):
+		}
+
+		err := store.rclient.Ping().Err()
+		if err == nil {
+			delay = HealthCheckInterval
+			if store.setAvailable(true) {
+				util.Info("Redis is available, resuming")
+				store.reloadQueues()
+				store.recover()
+			}
+			continue
+		}
+
+		if store.setAvailable(false) {
+			util.Warnf("Redis is unavailable, pausing: %v", err)
+			delay = 100 * time.Millisecond
+		} else if delay < MaxRecoveryInterval {
+			delay *= 2
+			if delay > MaxRecoveryInterval {
+				delay = MaxRecoveryInterval
+			}
+		}
+	}
+}
+
+// reloadQueues refreshes the cached queue state which
+// may have changed if Redis lost data.
+func (store *redisStore) reloadQueues() {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	for _, q := range store.queueSet {
+		err := q.init()
+		if err != nil {
+			util.Warnf("Unable to reload queue %s: %v", q.name, err)
+		}
+	}
+}
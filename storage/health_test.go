@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealth(t *testing.T) {
+	h := newHealth()
+	assert.True(t, h.Available())
+	assert.NoError(t, h.WaitAvailable(context.Background()))
+
+	recovered := 0
+	h.OnRecovery(func() error {
+		recovered++
+		return nil
+	})
+	h.OnRecovery(func() error {
+		return errors.New("boom")
+	})
+
+	assert.False(t, h.setAvailable(true))
+	assert.True(t, h.setAvailable(false))
+	assert.False(t, h.Available())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, ErrUnavailable, h.WaitAvailable(ctx))
+
+	done := make(chan error)
+	go func() {
+		done <- h.WaitAvailable(context.Background())
+	}()
+	assert.True(t, h.setAvailable(true))
+	assert.NoError(t, <-done)
+
+	h.recover()
+	assert.Equal(t, 1, recovered)
+}
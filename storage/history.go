@@ -7,11 +7,25 @@ import (
 	"github.com/go-redis/redis"
 )
 
+// HourlyRetention is how long the hourly counters behind
+// the Web UI's throughput heatmap are kept.
+const HourlyRetention = 8 * 7 * 24 * time.Hour
+
+func hourKey(name string, tm time.Time) string {
+	return fmt.Sprintf("%s:%s", name, tm.UTC().Format("2006-01-02T15"))
+}
+
 func (store *redisStore) Success() error {
-	daystr := time.Now().Format("2006-01-02")
-	store.rclient.Incr(fmt.Sprintf("processed:%s", daystr))
-	store.rclient.Incr("processed")
-	return nil
+	now := time.Now()
+	daystr := now.Format("2006-01-02")
+	_, err := store.rclient.Pipelined(func(pipe redis.Pipeliner) error {
+		pipe.Incr(fmt.Sprintf("processed:%s", daystr))
+		pipe.Incr("processed")
+		pipe.Incr(hourKey("processed", now))
+		pipe.Expire(hourKey("processed", now), HourlyRetention)
+		return nil
+	})
+	return err
 }
 
 func (store *redisStore) TotalProcessed() uint64 {
@@ -22,13 +36,20 @@ func (store *redisStore) TotalFailures() uint64 {
 }
 
 func (store *redisStore) Failure() error {
-	store.rclient.Incr("processed")
-	store.rclient.Incr("failures")
-
-	daystr := time.Now().Format("2006-01-02")
-	store.rclient.Incr(fmt.Sprintf("processed:%s", daystr))
-	store.rclient.Incr(fmt.Sprintf("failures:%s", daystr))
-	return nil
+	now := time.Now()
+	daystr := now.Format("2006-01-02")
+	_, err := store.rclient.Pipelined(func(pipe redis.Pipeliner) error {
+		pipe.Incr("processed")
+		pipe.Incr("failures")
+		pipe.Incr(fmt.Sprintf("processed:%s", daystr))
+		pipe.Incr(fmt.Sprintf("failures:%s", daystr))
+		for _, name := range []string{"processed", "failures"} {
+			pipe.Incr(hourKey(name, now))
+			pipe.Expire(hourKey(name, now), HourlyRetention)
+		}
+		return nil
+	})
+	return err
 }
 
 func (store *redisStore) History(days int, fn func(day string, procCnt uint64, failCnt uint64)) error {
@@ -56,3 +77,28 @@ func (store *redisStore) History(days int, fn func(day string, procCnt uint64, f
 	}
 	return nil
 }
+
+// HourlyHistory calls fn with the counts of each of the last hours,
+// most recent first.  Hours are in UTC.
+func (store *redisStore) HourlyHistory(hours int, fn func(hour time.Time, procCnt uint64, failCnt uint64)) error {
+	ts := time.Now().UTC().Truncate(time.Hour)
+	procds := make([]*redis.IntCmd, hours)
+	fails := make([]*redis.IntCmd, hours)
+
+	_, err := store.rclient.Pipelined(func(pipe redis.Pipeliner) error {
+		for idx := 0; idx < hours; idx++ {
+			hour := ts.Add(-time.Duration(idx) * time.Hour)
+			procds[idx] = pipe.IncrBy(hourKey("processed", hour), 0)
+			fails[idx] = pipe.IncrBy(hourKey("failures", hour), 0)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for idx := 0; idx < hours; idx++ {
+		fn(ts.Add(-time.Duration(idx)*time.Hour), uint64(procds[idx].Val()), uint64(fails[idx].Val()))
+	}
+	return nil
+}
@@ -38,5 +38,18 @@ func TestStats(t *testing.T) {
 		assert.NotNil(t, counts)
 		assert.EqualValues(t, 10002, counts[0])
 		assert.EqualValues(t, 101, counts[1])
+
+		hours := []time.Time{}
+		var procd, failed uint64
+		store.HourlyHistory(3, func(hour time.Time, p, f uint64) {
+			hours = append(hours, hour)
+			procd += p
+			failed += f
+		})
+		assert.Equal(t, 3, len(hours))
+		assert.Equal(t, time.Now().UTC().Truncate(time.Hour), hours[0])
+		assert.Equal(t, time.Hour, hours[0].Sub(hours[1]))
+		assert.EqualValues(t, 10002, procd)
+		assert.EqualValues(t, 101, failed)
 	})
 }
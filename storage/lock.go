@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/contribsys/faktory/util"
+)
+
+// the lock file in the storage directory
+const lockFilename = "faktory.lock"
+
+/*
+ * LockDirectory takes an exclusive lock on the storage directory so a
+ * second Faktory can't boot another Redis over the same snapshot,
+ * which corrupts it.  The lock is held until the returned func is
+ * called or the process exits, even if it crashes, so it never goes
+ * stale.  The lock file records the PID of the holder.
+ */
+func LockDirectory(dir string) (func(), error) {
+	err := os.MkdirAll(dir, os.ModeDir|0755)
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, lockFilename)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	err = util.TryLock(f)
+	if err != nil {
+		pid := "unknown"
+		if data, rerr := ioutil.ReadAll(f); rerr == nil && len(data) > 0 {
+			pid = strings.TrimSpace(string(data))
+		}
+		f.Close()
+		return nil, fmt.Errorf("%s is in use by another Faktory, PID %s", dir, pid)
+	}
+
+	// the file is left behind, removing it would let another
+	// process lock a new file while one waits on this one
+	err = f.Truncate(0)
+	if err == nil {
+		_, err = f.WriteAt([]byte(fmt.Sprintf("%d\n", os.Getpid())), 0)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() { f.Close() }, nil
+}
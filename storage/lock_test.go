@@ -0,0 +1,31 @@
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "faktory-lock")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	unlock, err := LockDirectory(dir)
+	assert.NoError(t, err)
+	data, err := ioutil.ReadFile(filepath.Join(dir, lockFilename))
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%d\n", os.Getpid()), string(data))
+
+	_, err = LockDirectory(dir)
+	assert.EqualError(t, err, fmt.Sprintf("%s is in use by another Faktory, PID %d", dir, os.Getpid()))
+
+	unlock()
+	unlock, err = LockDirectory(dir)
+	assert.NoError(t, err)
+	unlock()
+}
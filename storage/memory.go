@@ -0,0 +1,1253 @@
+package storage
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/contribsys/faktory/util"
+)
+
+/*
+ * The memory backend runs a minimal Redis inside the Faktory
+ * process, speaking just enough of the Redis protocol for the
+ * storage layer.  It's meant for CI and local development where
+ * booting redis-server is the slowest part of the test suite:
+ *
+ *   faktory -storage memory
+ *
+ * Nothing is persisted, all jobs are lost when Faktory stops.
+ */
+var (
+	memoryInstances = map[string]*memoryServer{}
+)
+
+func BootMemory(sock string) (func(), error) {
+	redisMutex.Lock()
+	defer redisMutex.Unlock()
+	if _, ok := memoryInstances[sock]; ok {
+		return func() { StopMemory(sock) }, nil
+	}
+	util.Infof("Initializing in-memory storage, socket %s", sock)
+
+	// remove the socket left behind by a previous process
+	os.Remove(sock)
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		return nil, err
+	}
+
+	ms := newMemoryServer(listener)
+	memoryInstances[sock] = ms
+	go ms.serve()
+	return func() { StopMemory(sock) }, nil
+}
+
+func StopMemory(sock string) error {
+	redisMutex.Lock()
+	defer redisMutex.Unlock()
+
+	ms, ok := memoryInstances[sock]
+	if !ok {
+		return errors.New("No such memory instance " + sock)
+	}
+	delete(memoryInstances, sock)
+	ms.close()
+	os.Remove(sock)
+	return nil
+}
+
+type memoryServer struct {
+	mu       sync.Mutex
+	data     map[string]interface{}
+	expires  map[string]time.Time
+	pushed   chan struct{}
+	listener net.Listener
+	closed   chan struct{}
+	conns    map[net.Conn]bool
+	// SHA1s of the scripts loaded with SCRIPT LOAD
+	scripts map[string]bool
+}
+
+// memoryZset maps members to their scores
+type memoryZset map[string]float64
+
+type memoryHash map[string]string
+
+type memoryStatus string
+
+// an error reply
+type memoryError string
+
+func (e memoryError) Error() string {
+	return string(e)
+}
+
+const (
+	errWrongType = memoryError("WRONGTYPE Operation against a key holding the wrong kind of value")
+	errSyntax    = memoryError("ERR syntax error")
+	errNotInt    = memoryError("ERR value is not an integer or out of range")
+	errNotFloat  = memoryError("ERR min or max is not a float")
+	errNoSuchKey = memoryError("ERR no such key")
+)
+
+func newMemoryServer(listener net.Listener) *memoryServer {
+	return &memoryServer{
+		data:     map[string]interface{}{},
+		expires:  map[string]time.Time{},
+		pushed:   make(chan struct{}),
+		listener: listener,
+		closed:   make(chan struct{}),
+		conns:    map[net.Conn]bool{},
+		scripts:  map[string]bool{},
+	}
+}
+
+func (ms *memoryServer) serve() {
+	for {
+		conn, err := ms.listener.Accept()
+		if err != nil {
+			return
+		}
+		ms.mu.Lock()
+		ms.conns[conn] = true
+		ms.mu.Unlock()
+		go ms.handle(conn)
+	}
+}
+
+func (ms *memoryServer) close() {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	close(ms.closed)
+	ms.listener.Close()
+	for conn := range ms.conns {
+		conn.Close()
+	}
+}
+
+func (ms *memoryServer) handle(conn net.Conn) {
+	defer func() {
+		ms.mu.Lock()
+		delete(ms.conns, conn)
+		ms.mu.Unlock()
+		conn.Close()
+	}()
+
+	rdr := bufio.NewReader(conn)
+	wtr := bufio.NewWriter(conn)
+	var multi [][][]byte
+	inMulti := false
+
+	for {
+		args, err := readCommand(rdr)
+		if err != nil {
+			if err != io.EOF {
+				writeReply(wtr, memoryError("ERR "+err.Error()))
+				wtr.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		var reply interface{}
+		name := strings.ToLower(string(args[0]))
+		switch {
+		case name == "multi":
+			inMulti = true
+			multi = nil
+			reply = memoryStatus("OK")
+		case name == "exec":
+			if !inMulti {
+				reply = memoryError("ERR EXEC without MULTI")
+				break
+			}
+			replies := make([]interface{}, len(multi))
+			ms.mu.Lock()
+			for i, cmd := range multi {
+				replies[i] = ms.exec(cmd)
+			}
+			ms.mu.Unlock()
+			inMulti = false
+			multi = nil
+			reply = replies
+		case name == "discard":
+			inMulti = false
+			multi = nil
+			reply = memoryStatus("OK")
+		case inMulti:
+			multi = append(multi, args)
+			reply = memoryStatus("QUEUED")
+		case name == "brpop":
+			reply = ms.brpop(args[1:])
+		default:
+			ms.mu.Lock()
+			reply = ms.exec(args)
+			ms.mu.Unlock()
+		}
+
+		writeReply(wtr, reply)
+		// go-redis pipelines, only flush once all
+		// buffered commands have been processed
+		if rdr.Buffered() == 0 {
+			err = wtr.Flush()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func readCommand(rdr *bufio.Reader) ([][]byte, error) {
+	line, err := rdr.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) == 0 {
+		return nil, nil
+	}
+	if line[0] != '*' {
+		// inline command, e.g. from a telnet session
+		fields := strings.Fields(line)
+		args := make([][]byte, len(fields))
+		for i, f := range fields {
+			args[i] = []byte(f)
+		}
+		return args, nil
+	}
+
+	count, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid multibulk length")
+	}
+	args := make([][]byte, count)
+	for i := 0; i < count; i++ {
+		line, err := rdr.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		if len(line) < 3 || line[0] != '$' {
+			return nil, fmt.Errorf("expected '$', got '%s'", strings.TrimSpace(line))
+		}
+		size, err := strconv.Atoi(strings.TrimRight(line[1:], "\r\n"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid bulk length")
+		}
+		buf := make([]byte, size+2)
+		_, err = io.ReadFull(rdr, buf)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = buf[:size]
+	}
+	return args, nil
+}
+
+func writeReply(wtr *bufio.Writer, reply interface{}) {
+	switch v := reply.(type) {
+	case nil:
+		wtr.WriteString("$-1\r\n")
+	case memoryStatus:
+		fmt.Fprintf(wtr, "+%s\r\n", v)
+	case memoryError:
+		fmt.Fprintf(wtr, "-%s\r\n", v)
+	case int64:
+		fmt.Fprintf(wtr, ":%d\r\n", v)
+	case int:
+		fmt.Fprintf(wtr, ":%d\r\n", v)
+	case string:
+		fmt.Fprintf(wtr, "$%d\r\n%s\r\n", len(v), v)
+	case []byte:
+		fmt.Fprintf(wtr, "$%d\r\n", len(v))
+		wtr.Write(v)
+		wtr.WriteString("\r\n")
+	case []string:
+		if v == nil {
+			wtr.WriteString("*-1\r\n")
+			return
+		}
+		fmt.Fprintf(wtr, "*%d\r\n", len(v))
+		for _, s := range v {
+			writeReply(wtr, s)
+		}
+	case []interface{}:
+		fmt.Fprintf(wtr, "*%d\r\n", len(v))
+		for _, x := range v {
+			writeReply(wtr, x)
+		}
+	default:
+		fmt.Fprintf(wtr, "-ERR unexpected reply %T\r\n", v)
+	}
+}
+
+// brpop blocks without holding the lock, waking up whenever
+// something is pushed to any list.
+func (ms *memoryServer) brpop(args [][]byte) interface{} {
+	if len(args) < 2 {
+		return wrongArgs("brpop")
+	}
+	secs, err := strconv.ParseFloat(string(args[len(args)-1]), 64)
+	if err != nil || secs < 0 {
+		return memoryError("ERR timeout is not a float or out of range")
+	}
+	keys := args[:len(args)-1]
+
+	var timeout <-chan time.Time
+	if secs > 0 {
+		timeout = time.After(time.Duration(secs * float64(time.Second)))
+	}
+
+	for {
+		ms.mu.Lock()
+		for _, key := range keys {
+			list, err := ms.list(string(key))
+			if err != nil {
+				ms.mu.Unlock()
+				return err
+			}
+			if len(list) > 0 {
+				val := list[len(list)-1]
+				ms.setList(string(key), list[:len(list)-1])
+				ms.mu.Unlock()
+				return []string{string(key), val}
+			}
+		}
+		pushed := ms.pushed
+		ms.mu.Unlock()
+
+		select {
+		case <-pushed:
+		case <-timeout:
+			return []string(nil)
+		case <-ms.closed:
+			return []string(nil)
+		}
+	}
+}
+
+func wrongArgs(name string) memoryError {
+	return memoryError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+}
+
+// exec runs a single command, the caller must hold the lock.
+func (ms *memoryServer) exec(args [][]byte) interface{} {
+	name := strings.ToLower(string(args[0]))
+	cmd, ok := memoryCommands[name]
+	if !ok {
+		return memoryError(fmt.Sprintf("ERR unknown command '%s'", name))
+	}
+	if len(args)-1 < cmd.arity || (cmd.exact && len(args)-1 != cmd.arity) {
+		return wrongArgs(name)
+	}
+
+	strs := make([]string, len(args)-1)
+	for i, arg := range args[1:] {
+		strs[i] = string(arg)
+	}
+	return cmd.fn(ms, strs)
+}
+
+type memoryCommand struct {
+	arity int
+	exact bool
+	fn    func(ms *memoryServer, args []string) interface{}
+}
+
+var memoryCommands = map[string]memoryCommand{
+	"ping":             {0, false, memPing},
+	"select":           {1, true, memOk},
+	"info":             {0, false, memInfo},
+	"flushdb":          {0, false, memFlush},
+	"flushall":         {0, false, memFlush},
+	"get":              {1, true, memGet},
+	"set":              {2, false, memSet},
+	"del":              {1, false, memDel},
+	"exists":           {1, false, memExists},
+	"incr":             {1, true, memIncr},
+	"incrby":           {2, true, memIncrBy},
+	"rename":           {2, true, memRename},
+	"expire":           {2, true, memExpire},
+	"lpush":            {2, false, memLPush},
+	"rpush":            {2, false, memRPush},
+	"rpop":             {1, true, memRPop},
+	"llen":             {1, true, memLLen},
+	"lindex":           {2, true, memLIndex},
+	"lrange":           {3, true, memLRange},
+	"ltrim":            {3, true, memLTrim},
+	"lrem":             {3, true, memLRem},
+	"sadd":             {2, false, memSAdd},
+	"srem":             {2, false, memSRem},
+	"sismember":        {2, true, memSIsMember},
+	"smembers":         {1, true, memSMembers},
+	"scard":            {1, true, memSCard},
+	"hset":             {3, false, memHSet},
+	"hsetnx":           {3, true, memHSetNX},
+	"hget":             {2, true, memHGet},
+	"hdel":             {2, false, memHDel},
+	"hincrby":          {3, true, memHIncrBy},
+	"hgetall":          {1, true, memHGetAll},
+	"zadd":             {3, false, memZAdd},
+	"zcard":            {1, true, memZCard},
+	"zrem":             {2, false, memZRem},
+	"zrange":           {3, false, memZRange},
+	"zrangebyscore":    {3, false, memZRangeByScore},
+	"zremrangebyscore": {3, true, memZRemRangeByScore},
+	"script":           {1, false, memScript},
+	"evalsha":          {2, false, memEvalSha},
+}
+
+// There's no Lua, the storage layer's scripts (see scripts.go) are
+// implemented natively and looked up by their SHA1.
+var memoryScripts = map[string]func(ms *memoryServer, keys []string, argv []string) interface{}{
+	popScript.sha: memPopFirst,
+}
+
+// memScript supports SCRIPT LOAD for the known scripts and SCRIPT FLUSH
+func memScript(ms *memoryServer, args []string) interface{} {
+	switch strings.ToLower(args[0]) {
+	case "load":
+		if len(args) != 2 {
+			return wrongArgs("script")
+		}
+		sum := sha1.Sum([]byte(args[1]))
+		sha := hex.EncodeToString(sum[:])
+		if _, ok := memoryScripts[sha]; !ok {
+			return memoryError("ERR scripting is not supported by the memory storage")
+		}
+		ms.scripts[sha] = true
+		return sha
+	case "flush":
+		ms.scripts = map[string]bool{}
+		return memoryStatus("OK")
+	default:
+		return memoryError("ERR unknown SCRIPT subcommand")
+	}
+}
+
+func memEvalSha(ms *memoryServer, args []string) interface{} {
+	sha := strings.ToLower(args[0])
+	script, ok := memoryScripts[sha]
+	if !ok || !ms.scripts[sha] {
+		return memoryError("NOSCRIPT No matching script. Please use EVAL.")
+	}
+	numkeys, err := strconv.Atoi(args[1])
+	if err != nil || numkeys < 0 || numkeys > len(args)-2 {
+		return memoryError("ERR Number of keys can't be greater than number of args")
+	}
+	return script(ms, args[2:2+numkeys], args[2+numkeys:])
+}
+
+func memPopFirst(ms *memoryServer, keys []string, argv []string) interface{} {
+	for _, key := range keys {
+		val := memRPop(ms, []string{key})
+		if val != nil {
+			if s, ok := val.(string); ok {
+				return []string{key, s}
+			}
+			return val
+		}
+	}
+	return nil
+}
+
+// lookup returns the value of the key, expiring it first if necessary
+func (ms *memoryServer) lookup(key string) interface{} {
+	if exp, ok := ms.expires[key]; ok && !time.Now().Before(exp) {
+		delete(ms.data, key)
+		delete(ms.expires, key)
+	}
+	return ms.data[key]
+}
+
+func (ms *memoryServer) del(key string) bool {
+	_, ok := ms.data[key]
+	delete(ms.data, key)
+	delete(ms.expires, key)
+	return ok
+}
+
+func (ms *memoryServer) list(key string) ([]string, error) {
+	switch v := ms.lookup(key).(type) {
+	case nil:
+		return nil, nil
+	case []string:
+		return v, nil
+	default:
+		return nil, errWrongType
+	}
+}
+
+// setList stores the list, empty lists are removed like in Redis
+func (ms *memoryServer) setList(key string, list []string) {
+	if len(list) == 0 {
+		ms.del(key)
+		return
+	}
+	ms.data[key] = list
+}
+
+func (ms *memoryServer) set(key string) (map[string]bool, error) {
+	switch v := ms.lookup(key).(type) {
+	case nil:
+		return nil, nil
+	case map[string]bool:
+		return v, nil
+	default:
+		return nil, errWrongType
+	}
+}
+
+func (ms *memoryServer) hash(key string) (memoryHash, error) {
+	switch v := ms.lookup(key).(type) {
+	case nil:
+		return nil, nil
+	case memoryHash:
+		return v, nil
+	default:
+		return nil, errWrongType
+	}
+}
+
+func (ms *memoryServer) zset(key string) (memoryZset, error) {
+	switch v := ms.lookup(key).(type) {
+	case nil:
+		return nil, nil
+	case memoryZset:
+		return v, nil
+	default:
+		return nil, errWrongType
+	}
+}
+
+func memPing(ms *memoryServer, args []string) interface{} {
+	if len(args) > 0 {
+		return args[0]
+	}
+	return memoryStatus("PONG")
+}
+
+func memOk(ms *memoryServer, args []string) interface{} {
+	return memoryStatus("OK")
+}
+
+func memInfo(ms *memoryServer, args []string) interface{} {
+	return fmt.Sprintf("# Server\r\nredis_version:memory\r\nredis_mode:standalone\r\n\r\n# Keyspace\r\ndb0:keys=%d\r\n", len(ms.data))
+}
+
+func memFlush(ms *memoryServer, args []string) interface{} {
+	ms.data = map[string]interface{}{}
+	ms.expires = map[string]time.Time{}
+	return memoryStatus("OK")
+}
+
+func memGet(ms *memoryServer, args []string) interface{} {
+	switch v := ms.lookup(args[0]).(type) {
+	case nil:
+		return nil
+	case string:
+		return v
+	default:
+		return errWrongType
+	}
+}
+
+func memSet(ms *memoryServer, args []string) interface{} {
+	var expiry time.Time
+	nx := false
+	opts := args[2:]
+	for len(opts) > 0 {
+		if strings.ToLower(opts[0]) == "nx" {
+			nx = true
+			opts = opts[1:]
+			continue
+		}
+		if len(opts) < 2 {
+			return errSyntax
+		}
+		n, err := strconv.ParseInt(opts[1], 10, 64)
+		if err != nil || n <= 0 {
+			return memoryError("ERR invalid expire time in set")
+		}
+		switch strings.ToLower(opts[0]) {
+		case "ex":
+			expiry = time.Now().Add(time.Duration(n) * time.Second)
+		case "px":
+			expiry = time.Now().Add(time.Duration(n) * time.Millisecond)
+		default:
+			return errSyntax
+		}
+		opts = opts[2:]
+	}
+
+	if nx && ms.lookup(args[0]) != nil {
+		return nil
+	}
+	ms.del(args[0])
+	ms.data[args[0]] = args[1]
+	if !expiry.IsZero() {
+		ms.expires[args[0]] = expiry
+	}
+	return memoryStatus("OK")
+}
+
+func memDel(ms *memoryServer, args []string) interface{} {
+	count := 0
+	for _, key := range args {
+		if ms.lookup(key) != nil && ms.del(key) {
+			count++
+		}
+	}
+	return count
+}
+
+func memExists(ms *memoryServer, args []string) interface{} {
+	count := 0
+	for _, key := range args {
+		if ms.lookup(key) != nil {
+			count++
+		}
+	}
+	return count
+}
+
+func memIncr(ms *memoryServer, args []string) interface{} {
+	return memIncrBy(ms, []string{args[0], "1"})
+}
+
+func memIncrBy(ms *memoryServer, args []string) interface{} {
+	by, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return errNotInt
+	}
+	var val int64
+	switch v := ms.lookup(args[0]).(type) {
+	case nil:
+	case string:
+		val, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return errNotInt
+		}
+	default:
+		return errWrongType
+	}
+	val += by
+	ms.data[args[0]] = strconv.FormatInt(val, 10)
+	return val
+}
+
+func memRename(ms *memoryServer, args []string) interface{} {
+	val := ms.lookup(args[0])
+	if val == nil {
+		return errNoSuchKey
+	}
+	exp, hasExp := ms.expires[args[0]]
+	ms.del(args[0])
+	ms.del(args[1])
+	ms.data[args[1]] = val
+	if hasExp {
+		ms.expires[args[1]] = exp
+	}
+	return memoryStatus("OK")
+}
+
+func memExpire(ms *memoryServer, args []string) interface{} {
+	secs, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return errNotInt
+	}
+	if ms.lookup(args[0]) == nil {
+		return 0
+	}
+	ms.expires[args[0]] = time.Now().Add(time.Duration(secs) * time.Second)
+	return 1
+}
+
+func memLPush(ms *memoryServer, args []string) interface{} {
+	list, err := ms.list(args[0])
+	if err != nil {
+		return err
+	}
+	vals := args[1:]
+	updated := make([]string, 0, len(list)+len(vals))
+	for i := len(vals) - 1; i >= 0; i-- {
+		updated = append(updated, vals[i])
+	}
+	updated = append(updated, list...)
+	ms.setList(args[0], updated)
+
+	// wake up any blocked BRPOPs
+	close(ms.pushed)
+	ms.pushed = make(chan struct{})
+	return len(updated)
+}
+
+func memRPush(ms *memoryServer, args []string) interface{} {
+	list, err := ms.list(args[0])
+	if err != nil {
+		return err
+	}
+	updated := make([]string, 0, len(list)+len(args)-1)
+	updated = append(updated, list...)
+	updated = append(updated, args[1:]...)
+	ms.setList(args[0], updated)
+
+	close(ms.pushed)
+	ms.pushed = make(chan struct{})
+	return len(updated)
+}
+
+func memRPop(ms *memoryServer, args []string) interface{} {
+	list, err := ms.list(args[0])
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		return nil
+	}
+	val := list[len(list)-1]
+	ms.setList(args[0], list[:len(list)-1])
+	return val
+}
+
+func memLLen(ms *memoryServer, args []string) interface{} {
+	list, err := ms.list(args[0])
+	if err != nil {
+		return err
+	}
+	return len(list)
+}
+
+func memLIndex(ms *memoryServer, args []string) interface{} {
+	list, err := ms.list(args[0])
+	if err != nil {
+		return err
+	}
+	idx, err := strconv.Atoi(args[1])
+	if err != nil {
+		return errNotInt
+	}
+	if idx < 0 {
+		idx += len(list)
+	}
+	if idx < 0 || idx >= len(list) {
+		return nil
+	}
+	return list[idx]
+}
+
+// span converts Redis' inclusive start/stop indexes, which may
+// be negative, to a slice range.  ok is false if the range is empty.
+func span(start string, stop string, size int) (int, int, bool, error) {
+	from, err := strconv.Atoi(start)
+	if err != nil {
+		return 0, 0, false, errNotInt
+	}
+	to, err := strconv.Atoi(stop)
+	if err != nil {
+		return 0, 0, false, errNotInt
+	}
+	if from < 0 {
+		from += size
+	}
+	if to < 0 {
+		to += size
+	}
+	if from < 0 {
+		from = 0
+	}
+	if to >= size {
+		to = size - 1
+	}
+	if from > to || from >= size {
+		return 0, 0, false, nil
+	}
+	return from, to + 1, true, nil
+}
+
+func memLRange(ms *memoryServer, args []string) interface{} {
+	list, err := ms.list(args[0])
+	if err != nil {
+		return err
+	}
+	from, to, ok, err := span(args[1], args[2], len(list))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return []string{}
+	}
+	return append([]string{}, list[from:to]...)
+}
+
+func memLTrim(ms *memoryServer, args []string) interface{} {
+	list, err := ms.list(args[0])
+	if err != nil {
+		return err
+	}
+	from, to, ok, err := span(args[1], args[2], len(list))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		ms.setList(args[0], nil)
+	} else {
+		ms.setList(args[0], append([]string{}, list[from:to]...))
+	}
+	return memoryStatus("OK")
+}
+
+func memLRem(ms *memoryServer, args []string) interface{} {
+	list, err := ms.list(args[0])
+	if err != nil {
+		return err
+	}
+	count, err := strconv.Atoi(args[1])
+	if err != nil {
+		return errNotInt
+	}
+	limit := count
+	if limit < 0 {
+		limit = -limit
+	}
+
+	keep := make([]bool, len(list))
+	removed := 0
+	for i := range list {
+		// a negative count removes from the tail
+		idx := i
+		if count < 0 {
+			idx = len(list) - 1 - i
+		}
+		if list[idx] == args[2] && (limit == 0 || removed < limit) {
+			removed++
+			continue
+		}
+		keep[idx] = true
+	}
+
+	updated := make([]string, 0, len(list)-removed)
+	for i, val := range list {
+		if keep[i] {
+			updated = append(updated, val)
+		}
+	}
+	ms.setList(args[0], updated)
+	return removed
+}
+
+func memSAdd(ms *memoryServer, args []string) interface{} {
+	set, err := ms.set(args[0])
+	if err != nil {
+		return err
+	}
+	if set == nil {
+		set = map[string]bool{}
+		ms.data[args[0]] = set
+	}
+	count := 0
+	for _, member := range args[1:] {
+		if !set[member] {
+			set[member] = true
+			count++
+		}
+	}
+	return count
+}
+
+func memSRem(ms *memoryServer, args []string) interface{} {
+	set, err := ms.set(args[0])
+	if err != nil {
+		return err
+	}
+	count := 0
+	for _, member := range args[1:] {
+		if set[member] {
+			delete(set, member)
+			count++
+		}
+	}
+	if set != nil && len(set) == 0 {
+		ms.del(args[0])
+	}
+	return count
+}
+
+func memSIsMember(ms *memoryServer, args []string) interface{} {
+	set, err := ms.set(args[0])
+	if err != nil {
+		return err
+	}
+	if set[args[1]] {
+		return 1
+	}
+	return 0
+}
+
+func memSMembers(ms *memoryServer, args []string) interface{} {
+	set, err := ms.set(args[0])
+	if err != nil {
+		return err
+	}
+	members := make([]string, 0, len(set))
+	for member := range set {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members
+}
+
+func memSCard(ms *memoryServer, args []string) interface{} {
+	set, err := ms.set(args[0])
+	if err != nil {
+		return err
+	}
+	return len(set)
+}
+
+func memHSet(ms *memoryServer, args []string) interface{} {
+	pairs := args[1:]
+	if len(pairs)%2 != 0 {
+		return wrongArgs("hset")
+	}
+	hash, err := ms.hash(args[0])
+	if err != nil {
+		return err
+	}
+	if hash == nil {
+		hash = memoryHash{}
+		ms.data[args[0]] = hash
+	}
+	count := 0
+	for i := 0; i < len(pairs); i += 2 {
+		if _, ok := hash[pairs[i]]; !ok {
+			count++
+		}
+		hash[pairs[i]] = pairs[i+1]
+	}
+	return count
+}
+
+func memHSetNX(ms *memoryServer, args []string) interface{} {
+	hash, err := ms.hash(args[0])
+	if err != nil {
+		return err
+	}
+	if _, ok := hash[args[1]]; ok {
+		return 0
+	}
+	if hash == nil {
+		hash = memoryHash{}
+		ms.data[args[0]] = hash
+	}
+	hash[args[1]] = args[2]
+	return 1
+}
+
+func memHGet(ms *memoryServer, args []string) interface{} {
+	hash, err := ms.hash(args[0])
+	if err != nil {
+		return err
+	}
+	val, ok := hash[args[1]]
+	if !ok {
+		return nil
+	}
+	return val
+}
+
+func memHDel(ms *memoryServer, args []string) interface{} {
+	hash, err := ms.hash(args[0])
+	if err != nil {
+		return err
+	}
+	count := 0
+	for _, field := range args[1:] {
+		if _, ok := hash[field]; ok {
+			delete(hash, field)
+			count++
+		}
+	}
+	if hash != nil && len(hash) == 0 {
+		ms.del(args[0])
+	}
+	return count
+}
+
+func memHIncrBy(ms *memoryServer, args []string) interface{} {
+	by, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return errNotInt
+	}
+	hash, herr := ms.hash(args[0])
+	if herr != nil {
+		return herr
+	}
+	if hash == nil {
+		hash = memoryHash{}
+		ms.data[args[0]] = hash
+	}
+	var val int64
+	if cur, ok := hash[args[1]]; ok {
+		val, err = strconv.ParseInt(cur, 10, 64)
+		if err != nil {
+			return memoryError("ERR hash value is not an integer")
+		}
+	}
+	val += by
+	hash[args[1]] = strconv.FormatInt(val, 10)
+	return val
+}
+
+func memHGetAll(ms *memoryServer, args []string) interface{} {
+	hash, err := ms.hash(args[0])
+	if err != nil {
+		return err
+	}
+	fields := make([]string, 0, len(hash))
+	for field := range hash {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	reply := make([]string, 0, 2*len(hash))
+	for _, field := range fields {
+		reply = append(reply, field, hash[field])
+	}
+	return reply
+}
+
+func memZAdd(ms *memoryServer, args []string) interface{} {
+	pairs := args[1:]
+	if len(pairs)%2 != 0 {
+		return errSyntax
+	}
+	zset, err := ms.zset(args[0])
+	if err != nil {
+		return err
+	}
+
+	// validate everything before changing anything
+	scores := make([]float64, len(pairs)/2)
+	for i := range scores {
+		score, err := strconv.ParseFloat(pairs[i*2], 64)
+		if err != nil {
+			return memoryError("ERR value is not a valid float")
+		}
+		scores[i] = score
+	}
+
+	if zset == nil {
+		zset = memoryZset{}
+		ms.data[args[0]] = zset
+	}
+	count := 0
+	for i, score := range scores {
+		member := pairs[i*2+1]
+		if _, ok := zset[member]; !ok {
+			count++
+		}
+		zset[member] = score
+	}
+	return count
+}
+
+func memZCard(ms *memoryServer, args []string) interface{} {
+	zset, err := ms.zset(args[0])
+	if err != nil {
+		return err
+	}
+	return len(zset)
+}
+
+func memZRem(ms *memoryServer, args []string) interface{} {
+	zset, err := ms.zset(args[0])
+	if err != nil {
+		return err
+	}
+	count := 0
+	for _, member := range args[1:] {
+		if _, ok := zset[member]; ok {
+			delete(zset, member)
+			count++
+		}
+	}
+	if zset != nil && len(zset) == 0 {
+		ms.del(args[0])
+	}
+	return count
+}
+
+type memoryZentry struct {
+	member string
+	score  float64
+}
+
+// sorted returns the members ordered by score, then member
+func (zset memoryZset) sorted() []memoryZentry {
+	entries := make([]memoryZentry, 0, len(zset))
+	for member, score := range zset {
+		entries = append(entries, memoryZentry{member, score})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].score != entries[j].score {
+			return entries[i].score < entries[j].score
+		}
+		return entries[i].member < entries[j].member
+	})
+	return entries
+}
+
+func zreply(entries []memoryZentry, withScores bool) []string {
+	result := make([]string, 0, len(entries)*2)
+	for _, e := range entries {
+		result = append(result, e.member)
+		if withScores {
+			result = append(result, strconv.FormatFloat(e.score, 'f', -1, 64))
+		}
+	}
+	return result
+}
+
+func memZRange(ms *memoryServer, args []string) interface{} {
+	withScores := false
+	for _, opt := range args[3:] {
+		if strings.ToLower(opt) != "withscores" {
+			return errSyntax
+		}
+		withScores = true
+	}
+
+	zset, err := ms.zset(args[0])
+	if err != nil {
+		return err
+	}
+	entries := zset.sorted()
+	from, to, ok, err := span(args[1], args[2], len(entries))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return []string{}
+	}
+	return zreply(entries[from:to], withScores)
+}
+
+// scoreBound parses a ZRANGEBYSCORE min or max like "1.5",
+// "(1.5" (exclusive), "-inf" or "+inf"
+func scoreBound(val string) (float64, bool, error) {
+	exclusive := strings.HasPrefix(val, "(")
+	if exclusive {
+		val = val[1:]
+	}
+	switch strings.ToLower(val) {
+	case "-inf":
+		return math.Inf(-1), exclusive, nil
+	case "+inf", "inf":
+		return math.Inf(1), exclusive, nil
+	}
+	f, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return 0, false, errNotFloat
+	}
+	return f, exclusive, nil
+}
+
+func scoreRange(zset memoryZset, min string, max string) ([]memoryZentry, error) {
+	lo, loEx, err := scoreBound(min)
+	if err != nil {
+		return nil, err
+	}
+	hi, hiEx, err := scoreBound(max)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []memoryZentry{}
+	for _, e := range zset.sorted() {
+		if e.score < lo || (loEx && e.score == lo) {
+			continue
+		}
+		if e.score > hi || (hiEx && e.score == hi) {
+			break
+		}
+		result = append(result, e)
+	}
+	return result, nil
+}
+
+func memZRangeByScore(ms *memoryServer, args []string) interface{} {
+	withScores := false
+	offset, count := 0, -1
+	opts := args[3:]
+	for len(opts) > 0 {
+		switch strings.ToLower(opts[0]) {
+		case "withscores":
+			withScores = true
+			opts = opts[1:]
+		case "limit":
+			if len(opts) < 3 {
+				return errSyntax
+			}
+			var err error
+			offset, err = strconv.Atoi(opts[1])
+			if err != nil {
+				return errNotInt
+			}
+			count, err = strconv.Atoi(opts[2])
+			if err != nil {
+				return errNotInt
+			}
+			opts = opts[3:]
+		default:
+			return errSyntax
+		}
+	}
+
+	zset, err := ms.zset(args[0])
+	if err != nil {
+		return err
+	}
+	entries, err := scoreRange(zset, args[1], args[2])
+	if err != nil {
+		return err
+	}
+	if offset < 0 || offset >= len(entries) {
+		return []string{}
+	}
+	entries = entries[offset:]
+	if count >= 0 && count < len(entries) {
+		entries = entries[:count]
+	}
+	return zreply(entries, withScores)
+}
+
+func memZRemRangeByScore(ms *memoryServer, args []string) interface{} {
+	zset, err := ms.zset(args[0])
+	if err != nil {
+		return err
+	}
+	entries, err := scoreRange(zset, args[1], args[2])
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		delete(zset, e.member)
+	}
+	if zset != nil && len(zset) == 0 {
+		ms.del(args[0])
+	}
+	return len(entries)
+}
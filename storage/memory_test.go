@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemory(t *testing.T) {
+	sock := fmt.Sprintf("%s/faktory-memory-test.sock", os.TempDir())
+	stopper, err := BootMemory(sock)
+	assert.NoError(t, err)
+	defer stopper()
+
+	rc := redis.NewClient(&redis.Options{Network: "unix", Addr: sock})
+	defer rc.Close()
+
+	t.Run("Strings", func(t *testing.T) {
+		rc.FlushDB()
+		assert.Equal(t, "PONG", rc.Ping().Val())
+
+		_, err := rc.Get("missing").Result()
+		assert.Equal(t, redis.Nil, err)
+		assert.NoError(t, rc.Set("foo", "bar", 0).Err())
+		assert.Equal(t, "bar", rc.Get("foo").Val())
+		assert.EqualValues(t, 1, rc.Exists("foo").Val())
+
+		assert.EqualValues(t, 1, rc.Incr("count").Val())
+		assert.EqualValues(t, 11, rc.IncrBy("count", 10).Val())
+		assert.Error(t, rc.Incr("foo").Err())
+
+		assert.NoError(t, rc.Set("temp", "x", 10*time.Millisecond).Err())
+		time.Sleep(20 * time.Millisecond)
+		assert.EqualValues(t, 0, rc.Exists("temp").Val())
+
+		assert.True(t, rc.SetNX("once", "a", time.Minute).Val())
+		assert.False(t, rc.SetNX("once", "b", time.Minute).Val())
+		assert.Equal(t, "a", rc.Get("once").Val())
+
+		assert.EqualValues(t, 2, rc.Del("foo", "count", "missing").Val())
+	})
+
+	t.Run("Lists", func(t *testing.T) {
+		rc.FlushDB()
+		assert.EqualValues(t, 3, rc.LPush("q", "a", "b", "c").Val())
+		assert.Equal(t, []string{"c", "b", "a"}, rc.LRange("q", 0, -1).Val())
+		assert.Equal(t, "a", rc.LIndex("q", -1).Val())
+		assert.Equal(t, "a", rc.RPop("q").Val())
+		assert.EqualValues(t, 2, rc.LLen("q").Val())
+		assert.EqualValues(t, 3, rc.RPush("q", "first").Val())
+		assert.Equal(t, "first", rc.RPop("q").Val())
+
+		rc.LPush("q", "b", "b")
+		assert.EqualValues(t, 2, rc.LRem("q", 2, "b").Val())
+		assert.Equal(t, []string{"c", "b"}, rc.LRange("q", 0, -1).Val())
+
+		assert.NoError(t, rc.LTrim("q", 0, -2).Err())
+		assert.Equal(t, []string{"c"}, rc.LRange("q", 0, -1).Val())
+		assert.NoError(t, rc.Rename("q", "other").Err())
+		assert.EqualValues(t, 0, rc.LLen("q").Val())
+		assert.Equal(t, "c", rc.RPop("other").Val())
+		assert.EqualValues(t, 0, rc.Exists("other").Val())
+		assert.Error(t, rc.Rename("q", "other").Err())
+
+		_, err := rc.BRPop(1*time.Second, "q").Result()
+		assert.Equal(t, redis.Nil, err)
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			redis.NewClient(&redis.Options{Network: "unix", Addr: sock}).LPush("q", "later")
+		}()
+		vals, err := rc.BRPop(2*time.Second, "q").Result()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"q", "later"}, vals)
+	})
+
+	t.Run("Sets", func(t *testing.T) {
+		rc.FlushDB()
+		assert.EqualValues(t, 2, rc.SAdd("s", "a", "b", "a").Val())
+		assert.True(t, rc.SIsMember("s", "a").Val())
+		assert.Equal(t, []string{"a", "b"}, rc.SMembers("s").Val())
+		assert.EqualValues(t, 2, rc.SCard("s").Val())
+		assert.EqualValues(t, 0, rc.SCard("missing").Val())
+		assert.EqualValues(t, 1, rc.SRem("s", "a").Val())
+		assert.False(t, rc.SIsMember("s", "a").Val())
+	})
+
+	t.Run("Hashes", func(t *testing.T) {
+		rc.FlushDB()
+		assert.True(t, rc.HSet("h", "depth", 12).Val())
+		assert.False(t, rc.HSet("h", "depth", 10).Val())
+		assert.EqualValues(t, 3, rc.HIncrBy("h", "pushed", 3).Val())
+		assert.EqualValues(t, 5, rc.HIncrBy("h", "pushed", 2).Val())
+		assert.Equal(t, map[string]string{"depth": "10", "pushed": "5"}, rc.HGetAll("h").Val())
+		assert.Equal(t, map[string]string{}, rc.HGetAll("missing").Val())
+
+		assert.True(t, rc.Expire("h", 1*time.Second).Val())
+		assert.False(t, rc.Expire("missing", 1*time.Second).Val())
+		assert.Equal(t, "10", rc.HGet("h", "depth").Val())
+		assert.Equal(t, redis.Nil, rc.HGet("h", "missing").Err())
+		assert.EqualValues(t, 1, rc.HDel("h", "depth", "missing").Val())
+		assert.EqualValues(t, 1, rc.HDel("h", "pushed").Val())
+		assert.EqualValues(t, 0, rc.Exists("h").Val())
+		assert.True(t, rc.HSetNX("h", "fired", "now").Val())
+		assert.False(t, rc.HSetNX("h", "fired", "later").Val())
+		assert.Equal(t, "now", rc.HGet("h", "fired").Val())
+		rc.Set("foo", "bar", 0)
+		assert.Error(t, rc.HIncrBy("foo", "x", 1).Err())
+	})
+
+	t.Run("SortedSets", func(t *testing.T) {
+		rc.FlushDB()
+		rc.ZAdd("z", redis.Z{Score: 2, Member: "b"}, redis.Z{Score: 1, Member: "a"}, redis.Z{Score: 3.5, Member: "c"})
+		assert.EqualValues(t, 3, rc.ZCard("z").Val())
+		assert.Equal(t, []string{"a", "b"}, rc.ZRangeByScore("z", redis.ZRangeBy{Min: "-inf", Max: "2"}).Val())
+		assert.Equal(t, []string{"b"}, rc.ZRangeByScore("z", redis.ZRangeBy{Min: "(1", Max: "+inf", Count: 1}).Val())
+
+		zs := rc.ZRangeWithScores("z", -1, -1).Val()
+		assert.Equal(t, []redis.Z{{Score: 3.5, Member: "c"}}, zs)
+		zs = rc.ZRangeByScoreWithScores("z", redis.ZRangeBy{Min: "1", Max: "3.5", Offset: 1, Count: 5}).Val()
+		assert.Equal(t, 2, len(zs))
+
+		assert.EqualValues(t, 1, rc.ZRem("z", "a", "missing").Val())
+		assert.EqualValues(t, 1, rc.ZRemRangeByScore("z", "-inf", "2").Val())
+		assert.EqualValues(t, 1, rc.ZCard("z").Val())
+	})
+
+	t.Run("Transactions", func(t *testing.T) {
+		rc.FlushDB()
+		var incr *redis.IntCmd
+		_, err := rc.TxPipelined(func(pipe redis.Pipeliner) error {
+			pipe.SAdd("s", "a")
+			incr = pipe.IncrBy("n", 5)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.EqualValues(t, 5, incr.Val())
+		assert.True(t, rc.SIsMember("s", "a").Val())
+
+		assert.Error(t, rc.LPush("s", "x").Err())
+	})
+
+	t.Run("Scripts", func(t *testing.T) {
+		rc.FlushDB()
+		rc.LPush("b", "x")
+		assert.Equal(t, popScript.sha, rc.ScriptLoad(popScript.src).Val())
+		vals, err := rc.EvalSha(popScript.sha, []string{"a", "b"}).Result()
+		assert.NoError(t, err)
+		assert.Equal(t, []interface{}{"b", "x"}, vals)
+		_, err = rc.EvalSha(popScript.sha, []string{"a", "b"}).Result()
+		assert.Equal(t, redis.Nil, err)
+
+		assert.NoError(t, rc.ScriptFlush().Err())
+		assert.Error(t, rc.EvalSha(popScript.sha, []string{"a", "b"}).Err())
+		assert.Error(t, rc.ScriptLoad("return 1").Err())
+		assert.Error(t, rc.EvalSha("abc", nil).Err())
+	})
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"github.com/contribsys/faktory/util"
+	"github.com/go-redis/redis"
+)
+
+type redisPushBatch struct {
+	store *redisStore
+	ops   []func(redis.Pipeliner)
+}
+
+func (store *redisStore) NewPushBatch() PushBatch {
+	return &redisPushBatch{store: store}
+}
+
+func (b *redisPushBatch) Push(q Queue, payload []byte) {
+	name, data := q.Name(), append([]byte(nil), payload...)
+	b.ops = append(b.ops, func(pipe redis.Pipeliner) {
+		pipe.LPush(name, data)
+	})
+}
+
+func (b *redisPushBatch) Schedule(timestamp string, payload []byte) error {
+	tim, err := util.ParseTime(timestamp)
+	if err != nil {
+		return err
+	}
+	time_f := float64(tim.Unix()) + (float64(tim.Nanosecond()) / 1000000000)
+	name, data := b.store.scheduled.name, append([]byte(nil), payload...)
+	b.ops = append(b.ops, func(pipe redis.Pipeliner) {
+		pipe.ZAdd(name, redis.Z{Score: time_f, Member: data})
+	})
+	return nil
+}
+
+// Exec writes the batch in one transaction, so either every job
+// is stored or none are.  It builds a new pipeline each time and
+// may be called again if it fails.
+func (b *redisPushBatch) Exec() error {
+	if len(b.ops) == 0 {
+		return nil
+	}
+	_, err := b.store.rclient.TxPipelined(func(pipe redis.Pipeliner) error {
+		for _, op := range b.ops {
+			op(pipe)
+		}
+		return nil
+	})
+	return err
+}
@@ -3,6 +3,8 @@ package storage
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/contribsys/faktory/client"
@@ -11,11 +13,24 @@ import (
 )
 
 type redisQueue struct {
-	name  string
-	store *redisStore
-	done  bool
+	name   string
+	store  *redisStore
+	done   bool
+	paused bool
 }
 
+const (
+	pausedKey   = "paused"
+	clearingKey = "clearing"
+	// the names of all queues, so they can be reloaded at boot.
+	// ':' isn't valid in a queue name so this can't collide.
+	queuesKey = "queues:known"
+
+	// the number of elements removed per Redis command when
+	// reaping a cleared queue, keeps each command ~1ms
+	clearBatchSize = 1000
+)
+
 func (store *redisStore) NewQueue(name string) *redisQueue {
 	return &redisQueue{
 		name:  name,
@@ -50,16 +65,112 @@ func (q *redisQueue) Each(fn func(index int, data []byte) error) error {
 	return q.Page(0, -1, fn)
 }
 
+// Clear renames the queue out of the way in O(1) time,
+// the elements are deleted later by ReapClearedQueues.
 func (q *redisQueue) Clear() (uint64, error) {
-	q.store.rclient.Del(q.name)
-	return 0, nil
+	size := q.Size()
+	if size == 0 {
+		return 0, nil
+	}
+
+	tmp := fmt.Sprintf("clearing:%s:%d", q.name, time.Now().UnixNano())
+	_, err := q.store.rclient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Rename(q.name, tmp)
+		pipe.SAdd(clearingKey, tmp)
+		return nil
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "no such key") {
+			// emptied concurrently
+			return 0, nil
+		}
+		return 0, err
+	}
+	return size, nil
+}
+
+func (store *redisStore) ReapClearedQueues(count int64) (int64, error) {
+	keys, err := store.rclient.SMembers(clearingKey).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	reaped := int64(0)
+	for _, key := range keys {
+		for reaped < count {
+			size, err := store.rclient.LLen(key).Result()
+			if err != nil {
+				return reaped, err
+			}
+			if size <= clearBatchSize {
+				_, err = store.rclient.TxPipelined(func(pipe redis.Pipeliner) error {
+					pipe.Del(key)
+					pipe.SRem(clearingKey, key)
+					return nil
+				})
+				if err != nil {
+					return reaped, err
+				}
+				reaped += size
+				break
+			}
+
+			// trim from the tail, cost is proportional
+			// to the number of elements removed
+			err = store.rclient.LTrim(key, 0, -clearBatchSize-1).Err()
+			if err != nil {
+				return reaped, err
+			}
+			reaped += clearBatchSize
+		}
+	}
+	return reaped, nil
+}
+
+func (store *redisStore) ClearedSize() uint64 {
+	keys, err := store.rclient.SMembers(clearingKey).Result()
+	if err != nil {
+		return 0
+	}
+	size := uint64(0)
+	for _, key := range keys {
+		size += uint64(store.rclient.LLen(key).Val())
+	}
+	return size
 }
 
 func (q *redisQueue) init() error {
+	paused, err := q.store.rclient.SIsMember(pausedKey, q.name).Result()
+	if err != nil {
+		return err
+	}
+	q.paused = paused
 	util.Debugf("Queue init: %s %d elements", q.name, q.Size())
 	return nil
 }
 
+func (q *redisQueue) Pause() error {
+	err := q.store.rclient.SAdd(pausedKey, q.name).Err()
+	if err != nil {
+		return err
+	}
+	q.paused = true
+	return nil
+}
+
+func (q *redisQueue) Resume() error {
+	err := q.store.rclient.SRem(pausedKey, q.name).Err()
+	if err != nil {
+		return err
+	}
+	q.paused = false
+	return nil
+}
+
+func (q *redisQueue) IsPaused() bool {
+	return q.paused
+}
+
 func (q *redisQueue) Size() uint64 {
 	return uint64(q.store.rclient.LLen(q.name).Val())
 }
@@ -79,6 +190,10 @@ func (q *redisQueue) Push(payload []byte) error {
 	return nil
 }
 
+func (q *redisQueue) PushFront(payload []byte) error {
+	return q.store.rclient.RPush(q.name, payload).Err()
+}
+
 // non-blocking, returns immediately if there's nothing enqueued
 func (q *redisQueue) Pop() ([]byte, error) {
 	if q.done {
@@ -97,15 +212,36 @@ func (q *redisQueue) _pop() ([]byte, error) {
 }
 
 func (q *redisQueue) BPop(ctx context.Context) ([]byte, error) {
-	val, err := q.store.rclient.BRPop(2*time.Second, q.name).Result()
+	_, data, err := q.store.BPopFirst(ctx, []string{q.name})
+	return data, err
+}
+
+// BPopFirst pops from the first of the queues which isn't empty,
+// blocking until the context's deadline, 2 seconds if it has none,
+// for a job to be pushed if they all are.
+func (store *redisStore) BPopFirst(ctx context.Context, queues []string) (string, []byte, error) {
+	if len(queues) == 0 {
+		return "", nil, nil
+	}
+	timeout := 2 * time.Second
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+		if timeout <= 0 {
+			return "", nil, nil
+		}
+		// BRPOP counts whole seconds and 0 blocks forever, round
+		// up so a FETCH blocks for its full timeout
+		timeout = (timeout + time.Second - 1).Truncate(time.Second)
+	}
+	val, err := store.rclient.BRPop(timeout, queues...).Result()
 	if err != nil {
 		if err == redis.Nil {
-			return nil, nil
+			return "", nil, nil
 		}
-		return nil, err
+		return "", nil, err
 	}
 
-	return []byte(val[1]), nil
+	return val[0], []byte(val[1]), nil
 }
 
 func (q *redisQueue) Delete(vals [][]byte) error {
@@ -118,3 +254,35 @@ func (q *redisQueue) Delete(vals [][]byte) error {
 
 	return nil
 }
+
+// popScript pops from the first non-empty list, one round trip
+// however many queues the worker fetches from
+var popScript = newScript("pop_first", `
+for _, key in ipairs(KEYS) do
+  local val = redis.call("rpop", key)
+  if val then
+    return {key, val}
+  end
+end
+return false
+`)
+
+func (store *redisStore) PopFirst(queues []string) (string, []byte, error) {
+	if len(queues) == 0 {
+		return "", nil, nil
+	}
+	val, err := store.runScript(popScript, queues).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return "", nil, nil
+		}
+		return "", nil, err
+	}
+	pair, ok := val.([]interface{})
+	if !ok || len(pair) != 2 {
+		return "", nil, fmt.Errorf("Unexpected pop result %v", val)
+	}
+	name, _ := pair[0].(string)
+	data, _ := pair[1].(string)
+	return name, []byte(data), nil
+}
@@ -1,10 +1,12 @@
 package storage
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/contribsys/faktory/util"
 	"github.com/stretchr/testify/assert"
@@ -48,7 +50,7 @@ func TestBasicQueueOps(t *testing.T) {
 
 			cnt, err := q.Clear()
 			assert.NoError(t, err)
-			assert.EqualValues(t, 0, cnt)
+			assert.EqualValues(t, 1, cnt)
 			assert.EqualValues(t, 0, q.Size())
 
 			// valid names:
@@ -74,6 +76,153 @@ func TestBasicQueueOps(t *testing.T) {
 			assert.Error(t, err)
 		})
 
+		t.Run("PopFirst", func(t *testing.T) {
+			store.Flush()
+			high, err := store.GetQueue("high")
+			assert.NoError(t, err)
+			low, err := store.GetQueue("low")
+			assert.NoError(t, err)
+
+			name, data, err := store.PopFirst([]string{"high", "low"})
+			assert.NoError(t, err)
+			assert.Equal(t, "", name)
+			assert.Nil(t, data)
+
+			assert.NoError(t, low.Push([]byte("one")))
+			assert.NoError(t, high.Push([]byte("two")))
+			assert.NoError(t, high.Push([]byte("three")))
+
+			for _, expected := range []string{"high:two", "high:three", "low:one"} {
+				name, data, err = store.PopFirst([]string{"high", "low"})
+				assert.NoError(t, err)
+				assert.Equal(t, expected, name+":"+string(data))
+			}
+			assert.EqualValues(t, 0, high.Size()+low.Size())
+
+			// scripts lost by Redis are reloaded
+			assert.NoError(t, store.Redis().ScriptFlush().Err())
+			assert.NoError(t, high.Push([]byte("seven")))
+			name, data, err = store.PopFirst([]string{"high", "low"})
+			assert.NoError(t, err)
+			assert.Equal(t, "high:seven", name+":"+string(data))
+
+			assert.NoError(t, low.Push([]byte("four")))
+			assert.NoError(t, high.Push([]byte("five")))
+			name, data, err = store.BPopFirst(context.Background(), []string{"high", "low"})
+			assert.NoError(t, err)
+			assert.Equal(t, "high:five", name+":"+string(data))
+
+			go func() {
+				time.Sleep(50 * time.Millisecond)
+				high.Push([]byte("six"))
+			}()
+			name, data, err = store.BPopFirst(context.Background(), []string{"empty", "high"})
+			assert.NoError(t, err)
+			assert.Equal(t, "high:six", name+":"+string(data))
+
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			defer cancel()
+			name, data, err = store.BPopFirst(ctx, []string{"empty", "high"})
+			assert.NoError(t, err)
+			assert.Nil(t, data)
+			assert.EqualValues(t, 1, low.Size())
+
+			// the remaining time on a default FETCH context isn't
+			// cut down to the previous whole second
+			ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			start := time.Now()
+			_, data, err = store.BPopFirst(ctx, []string{"empty"})
+			assert.NoError(t, err)
+			assert.Nil(t, data)
+			assert.True(t, time.Since(start) >= 1900*time.Millisecond, "%v", time.Since(start))
+		})
+
+		t.Run("clear", func(t *testing.T) {
+			store.Flush()
+			q, err := store.GetQueue("default")
+			assert.NoError(t, err)
+
+			for i := 0; i < 2500; i++ {
+				err = q.Push([]byte("hello"))
+				assert.NoError(t, err)
+			}
+
+			cnt, err := q.Clear()
+			assert.NoError(t, err)
+			assert.EqualValues(t, 2500, cnt)
+			assert.EqualValues(t, 0, q.Size())
+			assert.EqualValues(t, 2500, store.ClearedSize())
+
+			// the queue is immediately usable again
+			err = q.Push([]byte("world"))
+			assert.NoError(t, err)
+			assert.EqualValues(t, 1, q.Size())
+
+			reaped, err := store.ReapClearedQueues(1000)
+			assert.NoError(t, err)
+			assert.EqualValues(t, 1000, reaped)
+			assert.EqualValues(t, 1500, store.ClearedSize())
+
+			reaped, err = store.ReapClearedQueues(5000)
+			assert.NoError(t, err)
+			assert.EqualValues(t, 1500, reaped)
+			assert.EqualValues(t, 0, store.ClearedSize())
+			assert.EqualValues(t, 1, q.Size())
+
+			reaped, err = store.ReapClearedQueues(5000)
+			assert.NoError(t, err)
+			assert.EqualValues(t, 0, reaped)
+		})
+
+		t.Run("reload", func(t *testing.T) {
+			store.Flush()
+			q, err := store.GetQueue("remembered")
+			assert.NoError(t, err)
+			assert.NoError(t, q.Push([]byte("hello")))
+
+			// a restarted server knows about the queue
+			// before anyone pushes to it
+			reopened, err := OpenRedis(store.(*redisStore).Name)
+			assert.NoError(t, err)
+			defer reopened.Close()
+			names := []string{}
+			reopened.EachQueue(func(q Queue) {
+				names = append(names, q.Name())
+			})
+			assert.Contains(t, names, "remembered")
+		})
+
+		t.Run("remove", func(t *testing.T) {
+			store.Flush()
+			q, err := store.GetQueue("tenant-1")
+			assert.NoError(t, err)
+			assert.NoError(t, q.Push([]byte("hello")))
+
+			removed, err := store.RemoveQueue("tenant-1")
+			assert.NoError(t, err)
+			assert.False(t, removed)
+			removed, err = store.RemoveQueue("tenant-2")
+			assert.NoError(t, err)
+			assert.False(t, removed)
+
+			_, err = q.Pop()
+			assert.NoError(t, err)
+			removed, err = store.RemoveQueue("tenant-1")
+			assert.NoError(t, err)
+			assert.True(t, removed)
+			store.EachQueue(func(q Queue) {
+				assert.NotEqual(t, "tenant-1", q.Name())
+			})
+
+			reopened, err := OpenRedis(store.(*redisStore).Name)
+			assert.NoError(t, err)
+			defer reopened.Close()
+			reopened.EachQueue(func(q Queue) {
+				assert.NotEqual(t, "tenant-1", q.Name())
+			})
+		})
+
 		t.Run("heavy", func(t *testing.T) {
 			store.Flush()
 			q, err := store.GetQueue("default")
@@ -11,7 +11,6 @@ import (
 	"os/exec"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
 
 	"regexp"
@@ -32,6 +31,9 @@ type redisStore struct {
 
 	rclient *redis.Client
 	DB      int
+
+	*health
+	stopper chan struct{}
 }
 
 var (
@@ -98,10 +100,7 @@ func BootRedis(path string, sock string) (func(), error) {
 
 		util.Debugf("Booting Redis: %s", strings.Join(arguments, " "))
 
-		cmd := exec.Command(arguments[0], arguments[1:]...)
-		util.EnsureChildShutdown(cmd, util.SIGTERM) // platform-specific tuning
-		//cmd.Stdout = os.Stdout
-		//cmd.Stderr = os.Stderr
+		cmd := redisCommand(arguments)
 		instances[sock] = cmd
 		err = cmd.Start()
 		if err != nil {
@@ -122,14 +121,7 @@ func BootRedis(path string, sock string) (func(), error) {
 		done := time.Now()
 		util.Debugf("Redis booted in %s", done.Sub(start))
 
-		// This will panic and crash Faktory if Redis dies for some reason.
-		go func() {
-			err := cmd.Wait()
-			if err != nil {
-				util.Warnf("Redis at %s crashed: %s", path, err)
-				panic(err)
-			}
-		}()
+		go superviseRedis(path, sock, arguments, cmd)
 	}
 
 	_, err = rclient.Ping().Result()
@@ -162,10 +154,71 @@ func BootRedis(path string, sock string) (func(), error) {
 	return func() { StopRedis(sock) }, nil
 }
 
+func redisCommand(arguments []string) *exec.Cmd {
+	cmd := exec.Command(arguments[0], arguments[1:]...)
+	util.EnsureChildShutdown(cmd, util.SIGTERM) // platform-specific tuning
+	//cmd.Stdout = os.Stdout
+	//cmd.Stderr = os.Stderr
+	return cmd
+}
+
+// superviseRedis restarts Redis if it exits without StopRedis
+// being called.  While Redis is down the store pauses FETCH and
+// PUSH, see health.go.
+func superviseRedis(path string, sock string, arguments []string, cmd *exec.Cmd) {
+	for {
+		err := cmd.Wait()
+
+		redisMutex.Lock()
+		stopped := instances[sock] != cmd
+		redisMutex.Unlock()
+		if stopped {
+			return
+		}
+
+		util.Warnf("Redis at %s exited unexpectedly: %v", path, err)
+		cmd = restartRedis(sock, arguments, cmd)
+		if cmd == nil {
+			return
+		}
+	}
+}
+
+// restartRedis starts a new Redis process, backing off if it
+// fails.  Returns nil if Redis was stopped in the meantime.
+func restartRedis(sock string, arguments []string, old *exec.Cmd) *exec.Cmd {
+	backoff := 1 * time.Second
+	for {
+		time.Sleep(backoff)
+
+		redisMutex.Lock()
+		if instances[sock] != old {
+			redisMutex.Unlock()
+			return nil
+		}
+		cmd := redisCommand(arguments)
+		err := cmd.Start()
+		if err == nil {
+			instances[sock] = cmd
+			redisMutex.Unlock()
+			util.Infof("Restarted Redis, PID %d", cmd.Process.Pid)
+			return cmd
+		}
+		redisMutex.Unlock()
+
+		util.Warnf("Unable to restart Redis: %v", err)
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
 func OpenRedis(sock string) (Store, error) {
 	redisMutex.Lock()
 	defer redisMutex.Unlock()
-	if _, ok := instances[sock]; !ok {
+	_, booted := instances[sock]
+	_, memory := memoryInstances[sock]
+	if !booted && !memory {
 		return nil, errors.New("redis not booted, cannot start")
 	}
 
@@ -175,6 +228,8 @@ func OpenRedis(sock string) (Store, error) {
 		DB:       db,
 		mu:       sync.Mutex{},
 		queueSet: map[string]*redisQueue{},
+		health:   newHealth(),
+		stopper:  make(chan struct{}),
 	}
 	rs.initSorted()
 
@@ -188,6 +243,17 @@ func OpenRedis(sock string) (Store, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = rs.loadQueues()
+	if err != nil {
+		return nil, err
+	}
+	err = rs.loadScripts()
+	if err != nil {
+		return nil, err
+	}
+	// a restarted Redis has lost its scripts
+	rs.OnRecovery(rs.loadScripts)
+	go rs.monitor(rs.stopper)
 	return rs, nil
 }
 
@@ -206,7 +272,18 @@ func (store *redisStore) EachQueue(x func(Queue)) {
 }
 
 func (store *redisStore) Flush() error {
-	return store.rclient.FlushDB().Err()
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	_, err := store.rclient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.FlushDB()
+		// the queues are still registered in memory
+		for name := range store.queueSet {
+			pipe.SAdd(queuesKey, name)
+		}
+		return nil
+	})
+	return err
 }
 
 var (
@@ -235,15 +312,59 @@ func (store *redisStore) GetQueue(name string) (Queue, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = store.rclient.SAdd(queuesKey, name).Err()
+	if err != nil {
+		return nil, err
+	}
 	store.queueSet[name] = q
 	return q, nil
 }
 
+// loadQueues registers the queues which existed before a restart
+func (store *redisStore) loadQueues() error {
+	names, err := store.rclient.SMembers(queuesKey).Result()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		_, err := store.GetQueue(name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (store *redisStore) RemoveQueue(name string) (bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	q, ok := store.queueSet[name]
+	if !ok || q.Size() > 0 {
+		return false, nil
+	}
+	err := store.rclient.SRem(queuesKey, name).Err()
+	if err != nil {
+		return false, err
+	}
+	delete(store.queueSet, name)
+
+	// a PUSH which fetched the queue before it was removed
+	// may have added a job, keep the queue for it
+	if q.Size() > 0 {
+		store.queueSet[name] = q
+		return false, store.rclient.SAdd(queuesKey, name).Err()
+	}
+	q.Close()
+	return true, nil
+}
+
 func (store *redisStore) Close() error {
 	util.Debug("Stopping storage")
 	store.mu.Lock()
 	defer store.mu.Unlock()
 
+	close(store.stopper)
 	return store.rclient.Close()
 }
 
@@ -260,31 +381,11 @@ func StopRedis(sock string) error {
 		return errors.New("No such redis instance " + sock)
 	}
 
-	util.Debugf("Shutting down Redis PID %d", cmd.Process.Pid)
-	before := time.Now()
-	p := cmd.Process
-	pid := p.Pid
-	err := p.Signal(syscall.SIGTERM)
-	if err != nil {
-		return err
-	}
+	// delete first so the supervisor doesn't restart it
 	delete(instances, sock)
 
-	// Test suite hack: Redis will not exit if we
-	// don't give it enough time to reopen the RDB
-	// file before deleting the entire storage directory.
-	//time.Sleep(100 * time.Millisecond)
-	i := 500
-	for ; i > 0; i-- {
-		time.Sleep(2 * time.Millisecond)
-		err := syscall.Kill(pid, syscall.Signal(0))
-		if err == syscall.ESRCH {
-			util.Debugf("Redis dead in %v", time.Since(before))
-			return nil
-		}
-	}
-
-	return nil
+	util.Debugf("Shutting down Redis PID %d", cmd.Process.Pid)
+	return terminate(cmd.Process)
 }
 
 func (store *redisStore) Retries() SortedSet {
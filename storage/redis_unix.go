@@ -0,0 +1,37 @@
+// +build !windows
+
+package storage
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/contribsys/faktory/util"
+)
+
+// terminate asks Redis to shut down and waits a second for it to exit
+func terminate(p *os.Process) error {
+	before := time.Now()
+	pid := p.Pid
+	err := p.Signal(syscall.SIGTERM)
+	if err != nil {
+		return err
+	}
+
+	// Test suite hack: Redis will not exit if we
+	// don't give it enough time to reopen the RDB
+	// file before deleting the entire storage directory.
+	//time.Sleep(100 * time.Millisecond)
+	i := 500
+	for ; i > 0; i-- {
+		time.Sleep(2 * time.Millisecond)
+		err := syscall.Kill(pid, syscall.Signal(0))
+		if err == syscall.ESRCH {
+			util.Debugf("Redis dead in %v", time.Since(before))
+			return nil
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,11 @@
+package storage
+
+import (
+	"os"
+)
+
+// terminate stops Redis, Windows can't deliver SIGTERM so
+// the process is killed
+func terminate(p *os.Process) error {
+	return p.Kill()
+}
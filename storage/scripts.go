@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/contribsys/faktory/util"
+	"github.com/go-redis/redis"
+)
+
+/*
+ * Every Lua script used by the storage layer is registered here.  They
+ * are loaded into Redis when the store is opened and again each time
+ * Redis recovers, and always run with EVALSHA.  If Redis has lost a
+ * script anyway, e.g. after SCRIPT FLUSH, it's reloaded and the call
+ * retried once rather than silently falling back to sending the whole
+ * script with EVAL.
+ */
+type script struct {
+	name string
+	src  string
+	sha  string
+}
+
+var scripts = map[string]*script{}
+
+func newScript(name, src string) *script {
+	sum := sha1.Sum([]byte(src))
+	s := &script{name: name, src: src, sha: hex.EncodeToString(sum[:])}
+	if _, ok := scripts[name]; ok {
+		panic("duplicate script " + name)
+	}
+	scripts[name] = s
+	return s
+}
+
+// loadScripts preloads every script into Redis
+func (store *redisStore) loadScripts() error {
+	for _, s := range scripts {
+		err := store.loadScript(s)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (store *redisStore) loadScript(s *script) error {
+	sha, err := store.rclient.ScriptLoad(s.src).Result()
+	if err != nil {
+		return fmt.Errorf("Unable to load script %s: %v", s.name, err)
+	}
+	if sha != s.sha {
+		return fmt.Errorf("Script %s loaded as %s, expected %s", s.name, sha, s.sha)
+	}
+	return nil
+}
+
+// runScript calls the script with EVALSHA, reloading it if
+// Redis doesn't have it
+func (store *redisStore) runScript(s *script, keys []string, args ...interface{}) *redis.Cmd {
+	cmd := store.rclient.EvalSha(s.sha, keys, args...)
+	if err := cmd.Err(); err != nil && strings.HasPrefix(err.Error(), "NOSCRIPT") {
+		util.Warnf("Script %s is missing from Redis, reloading", s.name)
+		err = store.loadScript(s)
+		if err != nil {
+			return cmd
+		}
+		cmd = store.rclient.EvalSha(s.sha, keys, args...)
+	}
+	return cmd
+}
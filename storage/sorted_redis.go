@@ -173,6 +173,72 @@ func (rs *redisSorted) Page(start int, count int, fn func(index int, e SortedEnt
 	return len(zs), nil
 }
 
+// Cursors are "score|offset" where offset is the number of elements
+// with that exact score which have already been iterated.
+func parseCursor(cursor string) (string, int64, error) {
+	if cursor == "" {
+		return "-inf", 0, nil
+	}
+	slice := strings.Split(cursor, "|")
+	if len(slice) != 2 {
+		return "", 0, fmt.Errorf("Invalid cursor: %s", cursor)
+	}
+	_, err := strconv.ParseFloat(slice[0], 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("Invalid cursor: %s", cursor)
+	}
+	offset, err := strconv.ParseInt(slice[1], 10, 64)
+	if err != nil || offset < 0 {
+		return "", 0, fmt.Errorf("Invalid cursor: %s", cursor)
+	}
+	return slice[0], offset, nil
+}
+
+func (rs *redisSorted) Cursor(cursor string, count int, fn func(index int, e SortedEntry) error) (string, error) {
+	min, offset, err := parseCursor(cursor)
+	if err != nil {
+		return "", err
+	}
+
+	zs, err := rs.store.rclient.ZRangeByScoreWithScores(rs.name, redis.ZRangeBy{
+		Min:    min,
+		Max:    "+inf",
+		Offset: offset,
+		Count:  int64(count),
+	}).Result()
+	if err != nil {
+		return "", err
+	}
+
+	for idx, z := range zs {
+		err = fn(idx, NewEntry(z.Score, []byte(z.Member.(string))))
+		if err == ErrStopIteration {
+			return nextCursor(min, offset, zs[:idx+1]), nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	if len(zs) < count {
+		return "", nil
+	}
+	return nextCursor(min, offset, zs), nil
+}
+
+func nextCursor(min string, offset int64, zs []redis.Z) string {
+	last := zs[len(zs)-1].Score
+	ties := int64(0)
+	for i := len(zs) - 1; i >= 0 && zs[i].Score == last; i-- {
+		ties++
+	}
+	lastStr := strconv.FormatFloat(last, 'f', -1, 64)
+	if ties == int64(len(zs)) && lastStr == min {
+		// the entire page shared the cursor's score
+		ties += offset
+	}
+	return fmt.Sprintf("%s|%d", lastStr, ties)
+}
+
 func (rs *redisSorted) Each(fn func(idx int, e SortedEntry) error) error {
 	count := 50
 	current := 0
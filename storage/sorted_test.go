@@ -37,6 +37,46 @@ func TestBasicSortedOps(t *testing.T) {
 			sset.Clear()
 		})
 
+		t.Run("cursor", func(t *testing.T) {
+			sset := store.Retries()
+			sset.Clear()
+			// several jobs share each timestamp so batches
+			// will split runs of identical scores
+			for i := 0; i < 25; i++ {
+				jid, data := fakeJob()
+				ts := util.Thens(time.Now().Add(time.Duration(i/4) * time.Minute))
+				err := sset.AddElement(ts, jid, data)
+				assert.NoError(t, err)
+			}
+
+			seen := map[string]bool{}
+			cursor := ""
+			calls := 0
+			for {
+				next, err := sset.Cursor(cursor, 3, func(idx int, entry SortedEntry) error {
+					j, err := entry.Job()
+					assert.NoError(t, err)
+					assert.False(t, seen[j.Jid])
+					seen[j.Jid] = true
+					return nil
+				})
+				assert.NoError(t, err)
+				calls++
+				if next == "" {
+					break
+				}
+				cursor = next
+			}
+			assert.Equal(t, 25, len(seen))
+			assert.Equal(t, 9, calls)
+
+			_, err := sset.Cursor("bogus", 3, func(idx int, entry SortedEntry) error {
+				return nil
+			})
+			assert.Error(t, err)
+			sset.Clear()
+		})
+
 		t.Run("junk data", func(t *testing.T) {
 			sset := store.Retries()
 			assert.EqualValues(t, 0, sset.Size())
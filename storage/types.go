@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -24,11 +25,33 @@ type Store interface {
 	Dead() SortedSet
 	GetQueue(string) (Queue, error)
 	EachQueue(func(Queue))
+	// RemoveQueue forgets an empty queue, returning false if
+	// it isn't known or has jobs.
+	RemoveQueue(string) (bool, error)
 	Stats() map[string]string
 	EnqueueAll(SortedSet) error
 	EnqueueFrom(SortedSet, []byte) error
+	// NewPushBatch buffers pushes so they're written
+	// with a single round trip, see push_batch.go.
+	NewPushBatch() PushBatch
+
+	// Cleared queues are deleted incrementally in the background
+	// so a huge queue doesn't block Redis, see server/tasks.go.
+	// ReapClearedQueues deletes up to count jobs and returns the
+	// number deleted, ClearedSize returns the number remaining.
+	ReapClearedQueues(count int64) (int64, error)
+	ClearedSize() uint64
+
+	// PopFirst pops from the first of the queues which isn't empty
+	// with a single script call, returning the queue's name and
+	// the payload or nil if they are all empty.
+	PopFirst(queues []string) (string, []byte, error)
+	// BPopFirst is like PopFirst but blocks with a single BRPOP
+	// until the context is done if the queues are empty.
+	BPopFirst(ctx context.Context, queues []string) (string, []byte, error)
 
 	History(days int, fn func(day string, procCnt uint64, failCnt uint64)) error
+	HourlyHistory(hours int, fn func(hour time.Time, procCnt uint64, failCnt uint64)) error
 	Success() error
 	Failure() error
 	TotalProcessed() uint64
@@ -40,6 +63,15 @@ type Store interface {
 
 	Raw() KV
 	Redis
+
+	// Redis may restart while Faktory is running, see health.go.
+	// Available reports whether Redis is currently reachable,
+	// WaitAvailable blocks until it is or the context is done.
+	// Functions registered with OnRecovery are called each time
+	// Redis comes back.
+	Available() bool
+	WaitAvailable(ctx context.Context) error
+	OnRecovery(fn func() error)
 }
 
 type Redis interface {
@@ -51,7 +83,12 @@ type Queue interface {
 	Size() uint64
 
 	Add(job *client.Job) error
+	// Push must not keep a reference to data, the
+	// manager reuses the buffer.
 	Push(data []byte) error
+	// PushFront enqueues ahead of every job in the queue
+	// so it is the next to be popped.
+	PushFront(data []byte) error
 
 	Pop() ([]byte, error)
 	BPop(context.Context) ([]byte, error)
@@ -61,6 +98,29 @@ type Queue interface {
 	Page(start int64, count int64, fn func(index int, data []byte) error) error
 
 	Delete(keys [][]byte) error
+
+	// A paused queue still accepts jobs but FETCH will
+	// not dispatch them until the queue is resumed.
+	Pause() error
+	Resume() error
+	IsPaused() bool
+}
+
+var (
+	// Return ErrStopIteration from a Cursor callback to end
+	// the iteration early.  The returned cursor will resume
+	// immediately after the current element.
+	ErrStopIteration = errors.New("Stop iteration")
+)
+
+// PushBatch collects jobs being enqueued or scheduled and writes
+// them to Redis in one transaction when Exec is called, which can
+// be retried.  The payloads are copied so callers may reuse their
+// buffers.
+type PushBatch interface {
+	Push(q Queue, payload []byte)
+	Schedule(timestamp string, payload []byte) error
+	Exec() error
 }
 
 type SortedEntry interface {
@@ -75,12 +135,20 @@ type SortedSet interface {
 	Clear() error
 
 	Add(job *client.Job) error
+	// AddElement must not keep a reference to payload
 	AddElement(timestamp string, jid string, payload []byte) error
 
 	Get(key []byte) (SortedEntry, error)
 	Page(start int, count int, fn func(index int, e SortedEntry) error) (int, error)
 	Each(fn func(idx int, e SortedEntry) error) error
 
+	// Cursor iterates up to count elements after the given cursor.
+	// Pass an empty cursor to start at the beginning of the set.
+	// Returns the cursor for the next call or "" when the set
+	// has been exhausted.  Unlike Page, the cost of each call does
+	// not grow with the position in the set.
+	Cursor(cursor string, count int, fn func(index int, e SortedEntry) error) (string, error)
+
 	// bool is whether or not the element was actually removed from the sset.
 	// the scheduler and other things can be operating on the sset concurrently
 	// so we need to be careful about the data changing under us.
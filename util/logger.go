@@ -1,9 +1,12 @@
 package util
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"runtime"
+	"strings"
 	"sync"
 	"time"
 
@@ -69,8 +72,141 @@ func (h *LogHandler) HandleLog(e *alog.Entry) error {
 	return nil
 }
 
+// JSONLogHandler writes each entry as a JSON object on its own line
+// for log pipelines which can't parse the text format.  The fields
+// are level, ts, component, the package which logged the entry, msg
+// and any fields set on the entry, e.g. jid and queue.
+type JSONLogHandler struct {
+	mu     sync.Mutex
+	writer io.Writer
+}
+
+func (h *JSONLogHandler) HandleLog(e *alog.Entry) error {
+	line := make(map[string]interface{}, len(e.Fields)+4)
+	for name, val := range e.Fields {
+		if err, ok := val.(error); ok {
+			val = err.Error()
+		}
+		line[name] = val
+	}
+	line["level"] = e.Level.String()
+	line["ts"] = time.Now().UTC().Format(TimeFormat)
+	line["component"] = component()
+	line["msg"] = e.Message
+
+	data, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err = h.writer.Write(append(data, '\n'))
+	return err
+}
+
+// component returns the name of the package which logged,
+// the first caller outside of the logging packages
+func component() string {
+	pcs := make([]uintptr, 16)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for {
+		frame, more := frames.Next()
+		fn := frame.Function
+		if !strings.HasPrefix(fn, "github.com/apex/log") && !strings.HasPrefix(fn, "github.com/contribsys/faktory/util.") {
+			pkg := fn[strings.LastIndex(fn, "/")+1:]
+			if idx := strings.IndexByte(pkg, '.'); idx != -1 {
+				pkg = pkg[:idx]
+			}
+			return pkg
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+var (
+	logFormat = "text"
+	logOutput = &logWriter{file: os.Stdout}
+)
+
+// logWriter is the log's destination, stdout unless SetLogFile
+// was called.  The file can be reopened while logging.
+type logWriter struct {
+	mu   sync.Mutex
+	file *os.File
+	path string
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Write(p)
+}
+
+func (w *logWriter) open(path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, os.FileMode(0644))
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	old := w.file
+	w.file = file
+	w.path = path
+	w.mu.Unlock()
+
+	if old != os.Stdout {
+		return old.Close()
+	}
+	return nil
+}
+
+func newLogHandler() alog.Handler {
+	if logFormat == "json" {
+		return &JSONLogHandler{writer: logOutput}
+	}
+	return &LogHandler{writer: logOutput, tty: logOutput.path == "" && isTTY(int(os.Stdout.Fd()))}
+}
+
+// SetLogFile appends the log to the file rather than writing it
+// to stdout.
+func SetLogFile(path string) error {
+	err := logOutput.open(path)
+	if err != nil {
+		return err
+	}
+	alog.SetHandler(newLogHandler())
+	return nil
+}
+
+// ReopenLogFile closes and reopens the log file so it can be
+// rotated by moving it, e.g. with logrotate.  It does nothing if
+// the log is written to stdout.
+func ReopenLogFile() error {
+	logOutput.mu.Lock()
+	path := logOutput.path
+	logOutput.mu.Unlock()
+	if path == "" {
+		return nil
+	}
+	return logOutput.open(path)
+}
+
+// SetLogFormat switches the log output between "text", the
+// default, and "json".
+func SetLogFormat(format string) error {
+	if format != "text" && format != "json" {
+		return fmt.Errorf("Unknown log format %s, expected text or json", format)
+	}
+	logFormat = format
+	alog.SetHandler(newLogHandler())
+	return nil
+}
+
 func NewLogger(level string, production bool) Logger {
-	alog.SetHandler(&LogHandler{writer: os.Stdout, tty: isTTY(int(os.Stdout.Fd()))})
+	alog.SetHandler(newLogHandler())
 	alog.SetLevelFromString(level)
 	return alog.Log
 }
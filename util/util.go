@@ -10,6 +10,8 @@ import (
 	"os"
 	"runtime"
 	"time"
+
+	alog "github.com/apex/log"
 )
 
 const (
@@ -23,6 +25,7 @@ var (
 	LogInfo  = false
 	LogDebug = false
 	logg     = NewLogger("info", false)
+	logLevel = "info"
 )
 
 func Darwin() bool {
@@ -57,21 +60,42 @@ func ReadLines(data []byte) ([]string, error) {
 //
 
 func InitLogger(level string) {
+	logLevel = level
 	logg = NewLogger(level, true)
-	if level == "info" {
-		LogInfo = true
-	}
+	setLevel(level)
+}
+
+func setLevel(level string) {
+	LogInfo = level == "info" || level == "debug"
+	LogDebug = level == "debug"
+}
 
-	if level == "debug" {
-		LogInfo = true
-		LogDebug = true
+// ToggleDebug switches between debug logging and the level given to
+// InitLogger so a running process can be debugged without a restart.
+// It returns the new level.
+func ToggleDebug() string {
+	level := "debug"
+	if LogDebug {
+		level = logLevel
+		if level == "debug" {
+			level = "info"
+		}
 	}
+	alog.SetLevelFromString(level)
+	setLevel(level)
+	return level
 }
 
 func Log() Logger {
 	return logg
 }
 
+// ForJob returns a logger which includes the job's jid and
+// queue as fields
+func ForJob(jid string, queue string) Logger {
+	return logg.WithFields(alog.Fields{"jid": jid, "queue": queue})
+}
+
 func Error(msg string, err error) {
 	logg.WithError(err).Error(msg)
 }
@@ -3,6 +3,7 @@
 package util
 
 import (
+	"os"
 	"os/exec"
 	"syscall"
 	"unsafe"
@@ -18,3 +19,9 @@ func EnsureChildShutdown(cmd *exec.Cmd, sig int) {
 	// This ensures that, on Linux, if Faktory panics, our Redis child process will immediately
 	// get a SIGTERM signal to shutdown.  No such feature on Darwin/BSD, Redis will orphan.
 }
+
+// TryLock takes an exclusive lock on the file without
+// waiting, it's released when the file is closed
+func TryLock(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
@@ -3,6 +3,7 @@
 package util
 
 import (
+	"os"
 	"os/exec"
 	"syscall"
 	"unsafe"
@@ -23,3 +24,9 @@ func EnsureChildShutdown(cmd *exec.Cmd, sig int) {
 		Pdeathsig: syscall.Signal(sig),
 	}
 }
+
+// TryLock takes an exclusive lock on the file without
+// waiting, it's released when the file is closed
+func TryLock(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
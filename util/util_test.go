@@ -1,9 +1,16 @@
 package util
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	alog "github.com/apex/log"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -36,3 +43,70 @@ func TestBacktrace(t *testing.T) {
 	//fmt.Println(str)
 	//}
 }
+
+func TestToggleDebug(t *testing.T) {
+	InitLogger("warn")
+	defer InitLogger("info")
+	assert.False(t, LogInfo)
+
+	assert.Equal(t, "debug", ToggleDebug())
+	assert.True(t, LogInfo)
+	assert.True(t, LogDebug)
+	assert.Equal(t, "warn", ToggleDebug())
+	assert.False(t, LogInfo)
+	assert.False(t, LogDebug)
+
+	InitLogger("debug")
+	assert.Equal(t, "info", ToggleDebug())
+	assert.True(t, LogInfo)
+	assert.False(t, LogDebug)
+	assert.Equal(t, "debug", ToggleDebug())
+}
+
+func TestLogFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "faktory-log")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "faktory.log")
+
+	assert.NoError(t, ReopenLogFile())
+	assert.NoError(t, SetLogFile(path))
+	defer func() {
+		logOutput = &logWriter{file: os.Stdout}
+		SetLogFormat("text")
+	}()
+	Warn("before rotation")
+
+	assert.NoError(t, os.Rename(path, path+".1"))
+	assert.NoError(t, ReopenLogFile())
+	Warn("after rotation")
+
+	data, err := ioutil.ReadFile(path + ".1")
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "before rotation")
+	assert.NotContains(t, string(data), "after rotation")
+	data, err = ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "after rotation")
+}
+
+func TestJSONLogging(t *testing.T) {
+	var buf bytes.Buffer
+	logger := &alog.Logger{Handler: &JSONLogHandler{writer: &buf}, Level: alog.InfoLevel}
+	logger.WithFields(alog.Fields{"jid": "123456", "queue": "default"}).WithError(errors.New("boom")).Warn("Unable to track 123456")
+	logger.Debug("hidden")
+
+	var line map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "warn", line["level"])
+	assert.Equal(t, "Unable to track 123456", line["msg"])
+	assert.Equal(t, "123456", line["jid"])
+	assert.Equal(t, "default", line["queue"])
+	assert.Equal(t, "boom", line["error"])
+	assert.NotEmpty(t, line["ts"])
+	assert.NotEmpty(t, line["component"])
+
+	assert.Error(t, SetLogFormat("xml"))
+	assert.NoError(t, SetLogFormat("json"))
+	assert.NoError(t, SetLogFormat("text"))
+}
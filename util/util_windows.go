@@ -1,7 +1,10 @@
 package util
 
 import (
+	"os"
 	"os/exec"
+
+	"golang.org/x/sys/windows"
 )
 
 func isTTY(fd int) bool {
@@ -14,3 +17,9 @@ func EnsureChildShutdown(cmd *exec.Cmd, sig int) {
 	// This ensures that, on Linux, if Faktory panics, the child process will immediately
 	// get a signal.  Dunno if this is possible on Windows or how it will behave.
 }
+
+// TryLock takes an exclusive lock on the file without
+// waiting, it's released when the file is closed
+func TryLock(f *os.File) error {
+	return windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, &windows.Overlapped{})
+}
@@ -0,0 +1,337 @@
+package webui
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/contribsys/faktory/util"
+)
+
+/*
+ * Destructive actions in the Web UI may need a second admin to
+ * approve them before they're carried out:
+ *
+ * [web.approvals]
+ * actions = ["clear_queue", "retry_all", "delete_all", "kill_all", "push_template", "push_job"]
+ * queues = ["production_*"]  # clear_queue and pushes only need approval in these queues, default all
+ * ttl = 3600                 # seconds a request waits for approval
+ *
+ * retry_all, delete_all and kill_all act on a whole set, or every job
+ * in it matching the filter.  The action is listed on the Approvals
+ * page instead, where an admin other than the one who requested it
+ * carries it out by approving it, or it can be rejected.  Admins are
+ * told apart by the name they sign in with, so list them in
+ * [web.users]: with the [web] password any name may be used.
+ */
+const approvalsKey = "webui:approvals"
+
+var approvalActions = []string{"clear_queue", "retry_all", "delete_all", "kill_all", "push_template", "push_job"}
+
+type approvalPolicy struct {
+	actions map[string]bool
+	queues  []string
+	ttl     time.Duration
+}
+
+// parseApprovals returns nil if no actions need approval
+func parseApprovals(config interface{}) (*approvalPolicy, error) {
+	web, _ := config.(map[string]interface{})
+	if web["approvals"] == nil {
+		return nil, nil
+	}
+	cfg, ok := web["approvals"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Invalid web approvals configuration")
+	}
+
+	policy := &approvalPolicy{actions: map[string]bool{}, ttl: time.Hour}
+	actions, ok := stringList(cfg["actions"])
+	if !ok || len(actions) == 0 {
+		return nil, fmt.Errorf("[web.approvals] must list its actions")
+	}
+	for _, action := range actions {
+		known := false
+		for _, name := range approvalActions {
+			known = known || name == action
+		}
+		if !known {
+			return nil, fmt.Errorf("Unknown approval action %q, expected one of %s", action, strings.Join(approvalActions, ", "))
+		}
+		policy.actions[action] = true
+	}
+
+	policy.queues, ok = stringList(cfg["queues"])
+	if !ok {
+		return nil, fmt.Errorf("Invalid queues for [web.approvals]")
+	}
+	if len(policy.queues) == 0 {
+		policy.queues = []string{"*"}
+	}
+	for _, queue := range policy.queues {
+		if _, err := path.Match(queue, ""); err != nil {
+			return nil, fmt.Errorf("Invalid queue pattern %q for [web.approvals]", queue)
+		}
+	}
+
+	switch ttl := cfg["ttl"].(type) {
+	case nil:
+	case int64:
+		if ttl <= 0 {
+			return nil, fmt.Errorf("[web.approvals] ttl must be positive")
+		}
+		policy.ttl = time.Duration(ttl) * time.Second
+	default:
+		return nil, fmt.Errorf("Invalid ttl for [web.approvals]")
+	}
+	return policy, nil
+}
+
+// requires reports whether the action needs approval, queue
+// is empty for the actions on whole sets
+func (p *approvalPolicy) requires(action string, queue string) bool {
+	if p == nil || !p.actions[action] {
+		return false
+	}
+	if queue == "" {
+		return true
+	}
+	for _, pattern := range p.queues {
+		if ok, _ := path.Match(pattern, queue); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (ui *WebUI) setApprovals(policy *approvalPolicy) {
+	ui.mu.Lock()
+	ui.approvals = policy
+	ui.mu.Unlock()
+}
+
+func (ui *WebUI) approvalPolicy() *approvalPolicy {
+	ui.mu.RLock()
+	defer ui.mu.RUnlock()
+	return ui.approvals
+}
+
+// approval is an action waiting for a second admin, the form
+// is posted to the path again once it's approved
+type approval struct {
+	ID          string     `json:"id"`
+	Action      string     `json:"action"`
+	Description string     `json:"description"`
+	Path        string     `json:"path"`
+	Form        url.Values `json:"form"`
+	RequestedBy string     `json:"requested_by"`
+	RequestedAt time.Time  `json:"requested_at"`
+}
+
+// approvable maps the paths which may defer an action for
+// approval to the handler which carries it out
+var approvable = map[string]http.HandlerFunc{
+	"/queues/":    queueHandler,
+	"/retries":    retriesHandler,
+	"/scheduled":  scheduledHandler,
+	"/morgue":     morgueHandler,
+	"/templates/": templateHandler,
+	"/jobs/new":   newJobHandler,
+}
+
+func approvableHandler(uri string) http.HandlerFunc {
+	for prefix, h := range approvable {
+		if uri == prefix || (strings.HasSuffix(prefix, "/") && strings.HasPrefix(uri, prefix)) {
+			return h
+		}
+	}
+	return nil
+}
+
+// userName is the name the user signed in with
+func userName(r *http.Request) string {
+	name, _, _ := r.BasicAuth()
+	return name
+}
+
+/*
+ * deferForApproval records the action for another admin to approve
+ * if the policy requires it, responding with a redirect to the
+ * Approvals page, and returns true.  It returns false when the
+ * handler should carry out the action, e.g. once it's approved.
+ */
+func deferForApproval(w http.ResponseWriter, r *http.Request, action string, queue string, description string) bool {
+	dc := ctx(r)
+	if dc.approved || !dc.webui.approvalPolicy().requires(action, queue) {
+		return false
+	}
+	name := userName(r)
+	if name == "" {
+		http.Error(w, "Another admin must approve this, sign in with a user name to request it", http.StatusForbidden)
+		return true
+	}
+
+	r.ParseForm()
+	form := url.Values{}
+	for key, vals := range r.PostForm {
+		if key != "csrf_token" {
+			form[key] = vals
+		}
+	}
+	a := &approval{
+		ID:          util.RandomJid(),
+		Action:      action,
+		Description: description,
+		Path:        r.URL.RequestURI(),
+		Form:        form,
+		RequestedBy: name,
+		RequestedAt: time.Now(),
+	}
+	data, err := json.Marshal(a)
+	if err == nil {
+		err = dc.Store().Redis().HSet(approvalsKey, a.ID, data).Err()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+	util.Infof("Web UI user %s requested approval to %s", name, description)
+	http.Redirect(w, r, "/approvals", http.StatusFound)
+	return true
+}
+
+// deferBulk defers the action on a whole set or all of
+// its jobs matching the filter
+func deferBulk(w http.ResponseWriter, r *http.Request, action string, keys []string, set string) bool {
+	if len(keys) != 1 || (keys[0] != "all" && keys[0] != "matching") {
+		return false
+	}
+	description := fmt.Sprintf("%s all %s", action, set)
+	if !unfiltered(r) {
+		description += " matching " + filterQuery(r.URL.Query())
+	}
+	return deferForApproval(w, r, action+"_all", "", description)
+}
+
+// pendingApprovals lists the approvals which haven't expired,
+// oldest first, removing those which have
+func pendingApprovals(req *http.Request) ([]*approval, error) {
+	policy := ctx(req).webui.approvalPolicy()
+	rclient := ctx(req).Store().Redis()
+	vals, err := rclient.HGetAll(approvalsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*approval, 0, len(vals))
+	for id, val := range vals {
+		var a approval
+		err := json.Unmarshal([]byte(val), &a)
+		if err != nil || policy == nil || time.Since(a.RequestedAt) > policy.ttl {
+			rclient.HDel(approvalsKey, id)
+			continue
+		}
+		list = append(list, &a)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].RequestedAt.Before(list[j].RequestedAt)
+	})
+	return list, nil
+}
+
+// visibleApprovals are the pending approvals the user may act on,
+// admins see them all and others only their own requests
+func visibleApprovals(req *http.Request) []*approval {
+	if ctx(req).webui.approvalPolicy() == nil {
+		return nil
+	}
+	list, err := pendingApprovals(req)
+	if err != nil {
+		util.Warnf("Unable to read approvals: %v", err)
+		return nil
+	}
+	if admin(req) {
+		return list
+	}
+	name := userName(req)
+	mine := []*approval{}
+	for _, a := range list {
+		if a.RequestedBy == name {
+			mine = append(mine, a)
+		}
+	}
+	return mine
+}
+
+func approvalsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		ego_approvals(w, r, visibleApprovals(r))
+		return
+	}
+
+	var a *approval
+	for _, pending := range visibleApprovals(r) {
+		if pending.ID == r.FormValue("id") {
+			a = pending
+		}
+	}
+	if a == nil {
+		// already approved, rejected or expired
+		http.Redirect(w, r, "/approvals", http.StatusFound)
+		return
+	}
+
+	name := userName(r)
+	approving := r.FormValue("action") == "approve"
+	if approving && (!admin(r) || name == "" || name == a.RequestedBy) {
+		http.Error(w, "Another admin must approve this", http.StatusForbidden)
+		return
+	}
+	// removing it first carries it out at most once
+	removed, err := ctx(r).Store().Redis().HDel(approvalsKey, a.ID).Result()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if removed == 0 || !approving {
+		if removed > 0 {
+			util.Infof("Web UI user %s rejected %s's request to %s", name, a.RequestedBy, a.Description)
+		}
+		http.Redirect(w, r, "/approvals", http.StatusFound)
+		return
+	}
+
+	util.Infof("Web UI user %s approved %s's request to %s", name, a.RequestedBy, a.Description)
+	replay(w, r, a)
+}
+
+// replay posts the approved form to its handler again on
+// behalf of the approving admin
+func replay(w http.ResponseWriter, r *http.Request, a *approval) {
+	var h http.HandlerFunc
+	uri, err := url.ParseRequestURI(a.Path)
+	if err == nil {
+		h = approvableHandler(uri.Path)
+	}
+	if h == nil {
+		http.Error(w, fmt.Sprintf("Unable to carry out the request to %s", a.Description), http.StatusBadRequest)
+		return
+	}
+
+	dc := *ctx(r)
+	dc.approved = true
+	req := r.WithContext(&dc)
+	dc.request = req
+	req.Method = "POST"
+	req.URL = uri
+	req.RequestURI = a.Path
+	req.Form = a.Form
+	req.PostForm = a.Form
+	req.Body = http.NoBody
+	h(w, req)
+}
@@ -0,0 +1,125 @@
+package webui
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/contribsys/faktory/server"
+	"github.com/contribsys/faktory/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApprovals(t *testing.T) {
+	_, err := parseApprovals(map[string]interface{}{"approvals": map[string]interface{}{}})
+	assert.EqualError(t, err, "[web.approvals] must list its actions")
+	_, err = parseApprovals(map[string]interface{}{"approvals": map[string]interface{}{"actions": []interface{}{"flush"}}})
+	assert.Error(t, err)
+	policy, err := parseApprovals(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, policy)
+	assert.False(t, policy.requires("clear_queue", "default"))
+
+	policy, err = parseApprovals(map[string]interface{}{"approvals": map[string]interface{}{
+		"actions": []interface{}{"clear_queue", "retry_all"},
+		"queues":  []interface{}{"prod_*"},
+		"ttl":     int64(600),
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, 10*time.Minute, policy.ttl)
+	assert.True(t, policy.requires("clear_queue", "prod_orders"))
+	assert.False(t, policy.requires("clear_queue", "default"))
+	assert.True(t, policy.requires("retry_all", ""))
+	assert.False(t, policy.requires("delete_all", ""))
+
+	bootRuntime(t, "approvals", func(ui *WebUI, s *server.Server, t *testing.T) {
+		ui.setApprovals(policy)
+		defer ui.setApprovals(nil)
+
+		as := func(user, method, path string, payload url.Values) *httptest.ResponseRecorder {
+			req, err := ui.NewRequest(method, "http://localhost:7420"+path, strings.NewReader(payload.Encode()))
+			assert.NoError(t, err)
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if user != "" {
+				req.SetBasicAuth(user, "secret")
+			}
+			w := httptest.NewRecorder()
+			h := approvableHandler(req.URL.Path)
+			if h == nil {
+				h = approvalsHandler
+			}
+			h(w, req)
+			return w
+		}
+
+		q, err := s.Store().GetQueue("prod_orders")
+		assert.NoError(t, err)
+		assert.NoError(t, q.Push([]byte(`{"jid":"1","jobtype":"Order","args":[]}`)))
+
+		w := as("", "POST", "/queues/prod_orders", url.Values{})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		w = as("alice", "POST", "/queues/prod_orders", url.Values{})
+		assert.Equal(t, http.StatusFound, w.Code)
+		assert.Equal(t, "/approvals", w.Header().Get("Location"))
+		assert.EqualValues(t, 1, q.Size())
+
+		w = as("bob", "GET", "/approvals", nil)
+		assert.Equal(t, 200, w.Code)
+		assert.Contains(t, w.Body.String(), "clear the prod_orders queue")
+		assert.Contains(t, w.Body.String(), `value="approve"`)
+		w = as("alice", "GET", "/approvals", nil)
+		assert.NotContains(t, w.Body.String(), `value="approve"`)
+
+		list := visibleApprovals(mustRequest(t, ui))
+		assert.Equal(t, 1, len(list))
+		assert.Equal(t, "alice", list[0].RequestedBy)
+		id := list[0].ID
+
+		w = as("alice", "POST", "/approvals", url.Values{"id": {id}, "action": {"approve"}})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		w = as("bob", "POST", "/approvals", url.Values{"id": {id}, "action": {"approve"}})
+		assert.Equal(t, http.StatusFound, w.Code)
+		assert.Equal(t, "/queues", w.Header().Get("Location"))
+		assert.EqualValues(t, 0, q.Size())
+		// only carried out once
+		w = as("carol", "POST", "/approvals", url.Values{"id": {id}, "action": {"approve"}})
+		assert.Equal(t, "/approvals", w.Header().Get("Location"))
+
+		// queues which don't match aren't held
+		other, err := s.Store().GetQueue("default")
+		assert.NoError(t, err)
+		assert.NoError(t, other.Push([]byte(`{"jid":"2","jobtype":"Order","args":[]}`)))
+		w = as("alice", "POST", "/queues/default", url.Values{})
+		assert.Equal(t, "/queues", w.Header().Get("Location"))
+		assert.EqualValues(t, 0, other.Size())
+
+		retries := s.Store().Retries()
+		jid, data := fakeJob()
+		assert.NoError(t, retries.AddElement(util.Nows(), jid, data))
+		w = as("alice", "POST", "/retries", url.Values{"key": {"all"}, "action": {"retry"}})
+		assert.Equal(t, "/approvals", w.Header().Get("Location"))
+		w = as("bob", "GET", "/approvals", nil)
+		assert.Contains(t, w.Body.String(), "retry all retries")
+		list = visibleApprovals(mustRequest(t, ui))
+		assert.Equal(t, 1, len(list))
+		w = as("alice", "POST", "/approvals", url.Values{"id": {list[0].ID}, "action": {"reject"}})
+		assert.Equal(t, "/approvals", w.Header().Get("Location"))
+		assert.EqualValues(t, 1, retries.Size())
+		assert.Equal(t, 0, len(visibleApprovals(mustRequest(t, ui))))
+
+		// requests expire
+		as("alice", "POST", "/retries", url.Values{"key": {"all"}, "action": {"retry"}})
+		ui.setApprovals(&approvalPolicy{actions: policy.actions, queues: policy.queues, ttl: time.Nanosecond})
+		assert.Equal(t, 0, len(visibleApprovals(mustRequest(t, ui))))
+		assert.EqualValues(t, 0, s.Store().Redis().HLen(approvalsKey).Val())
+	})
+}
+
+func mustRequest(t *testing.T, ui *WebUI) *http.Request {
+	req, err := ui.NewRequest("GET", "http://localhost:7420/approvals", nil)
+	assert.NoError(t, err)
+	return req
+}
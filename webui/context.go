@@ -24,6 +24,8 @@ type DefaultContext struct {
 	locale   string
 	strings  map[string]string
 	csrf     bool
+	// the request replays an approved action, see approvals.go
+	approved bool
 }
 
 func (d *DefaultContext) Response() http.ResponseWriter {
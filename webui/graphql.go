@@ -0,0 +1,362 @@
+package webui
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/graphql"
+	"github.com/contribsys/faktory/manager"
+	"github.com/contribsys/faktory/server"
+	"github.com/contribsys/faktory/storage"
+)
+
+/*
+ * The GraphQL endpoint lets dashboards and internal tools fetch exactly
+ * the data they need in one request:
+ *
+ * curl -u :password localhost:7420/graphql -d '{"query": "{
+ *   stats { processed failures }
+ *   queues { name size drainSeconds jobs(first: 5) { jid jobtype } }
+ *   dead(first: 10) { size next jobs { jid failure { message } } }
+ * }"}'
+ *
+ * Sync tools follow the changes feed, passing the next cursor of each
+ * page as after and resyncing if the feed reports it was truncated:
+ *
+ *   changes(after: "1234", first: 100) { next truncated changes { seq kind subject detail } }
+ *
+ * It's read-only and uses the Web UI password.
+ */
+
+const (
+	maxGraphqlRequest = 64 * 1024
+	maxGraphqlPage    = 100
+)
+
+var apiSchema = graphqlSchema()
+
+type jobPage struct {
+	Size uint64
+	Next string
+	Jobs []*client.Job
+}
+
+func dctx(c context.Context) *DefaultContext {
+	return c.(*DefaultContext)
+}
+
+// scalar returns a field whose value is computed from the source alone.
+func scalar(fn func(src interface{}) interface{}) *graphql.Field {
+	return &graphql.Field{Resolve: func(_ context.Context, src interface{}, _ graphql.Args) (interface{}, error) {
+		return fn(src), nil
+	}}
+}
+
+func pageSize(args graphql.Args) (int, error) {
+	count := args.Int("first", 25)
+	if count < 1 || count > maxGraphqlPage {
+		return 0, fmt.Errorf("first must be between 1 and %d", maxGraphqlPage)
+	}
+	return count, nil
+}
+
+func setField(jobs *graphql.Object, set func(*DefaultContext) storage.SortedSet,
+	enumerate func(manager.Manager, string, int) ([]*client.Job, string, error)) *graphql.Field {
+	return &graphql.Field{Type: jobs, Resolve: func(c context.Context, _ interface{}, args graphql.Args) (interface{}, error) {
+		count, err := pageSize(args)
+		if err != nil {
+			return nil, err
+		}
+		list, next, err := enumerate(dctx(c).Server().Manager(), args.String("after", ""), count)
+		if err != nil {
+			return nil, err
+		}
+		return &jobPage{Size: set(dctx(c)).Size(), Next: next, Jobs: list}, nil
+	}}
+}
+
+func drainField(fn func(*server.DrainEstimate) interface{}) *graphql.Field {
+	return &graphql.Field{Resolve: func(c context.Context, src interface{}, _ graphql.Args) (interface{}, error) {
+		name := src.(manager.QueueInfo).Name
+		estimates, err := dctx(c).Server().DrainEstimates(name)
+		if err != nil || estimates == nil {
+			return nil, err
+		}
+		return fn(estimates[name]), nil
+	}}
+}
+
+func graphqlSchema() *graphql.Schema {
+	failureType := &graphql.Object{Name: "Failure", Fields: map[string]*graphql.Field{
+		"retryCount": scalar(func(src interface{}) interface{} { return src.(*client.Failure).RetryCount }),
+		"failedAt":   scalar(func(src interface{}) interface{} { return src.(*client.Failure).FailedAt }),
+		"nextAt":     scalar(func(src interface{}) interface{} { return src.(*client.Failure).NextAt }),
+		"message":    scalar(func(src interface{}) interface{} { return src.(*client.Failure).ErrorMessage }),
+		"errtype":    scalar(func(src interface{}) interface{} { return src.(*client.Failure).ErrorType }),
+		"backtrace":  scalar(func(src interface{}) interface{} { return src.(*client.Failure).Backtrace }),
+	}}
+
+	jobType := &graphql.Object{Name: "Job", Fields: map[string]*graphql.Field{
+		"jid":         scalar(func(src interface{}) interface{} { return src.(*client.Job).Jid }),
+		"jobtype":     scalar(func(src interface{}) interface{} { return src.(*client.Job).Type }),
+		"queue":       scalar(func(src interface{}) interface{} { return src.(*client.Job).Queue }),
+		"args":        scalar(func(src interface{}) interface{} { return src.(*client.Job).Args }),
+		"createdAt":   scalar(func(src interface{}) interface{} { return src.(*client.Job).CreatedAt }),
+		"enqueuedAt":  scalar(func(src interface{}) interface{} { return src.(*client.Job).EnqueuedAt }),
+		"at":          scalar(func(src interface{}) interface{} { return src.(*client.Job).At }),
+		"retry":       scalar(func(src interface{}) interface{} { return src.(*client.Job).Retry }),
+		"reserveFor":  scalar(func(src interface{}) interface{} { return src.(*client.Job).ReserveFor }),
+		"custom":      scalar(func(src interface{}) interface{} { return src.(*client.Job).Custom }),
+		"annotations": scalar(func(src interface{}) interface{} { return src.(*client.Job).Annotations }),
+		"failure": {Type: failureType, Resolve: func(_ context.Context, src interface{}, _ graphql.Args) (interface{}, error) {
+			return src.(*client.Job).Failure, nil
+		}},
+	}}
+
+	pageType := &graphql.Object{Name: "JobPage", Fields: map[string]*graphql.Field{
+		"size": scalar(func(src interface{}) interface{} { return src.(*jobPage).Size }),
+		"next": scalar(func(src interface{}) interface{} { return src.(*jobPage).Next }),
+		"jobs": {Type: jobType, Resolve: func(_ context.Context, src interface{}, _ graphql.Args) (interface{}, error) {
+			return src.(*jobPage).Jobs, nil
+		}},
+	}}
+
+	queueType := &graphql.Object{Name: "Queue", Fields: map[string]*graphql.Field{
+		"name":   scalar(func(src interface{}) interface{} { return src.(manager.QueueInfo).Name }),
+		"size":   scalar(func(src interface{}) interface{} { return src.(manager.QueueInfo).Size }),
+		"paused": scalar(func(src interface{}) interface{} { return src.(manager.QueueInfo).Paused }),
+		// seconds until the queue is empty, null if it isn't draining
+		"drainSeconds": drainField(func(est *server.DrainEstimate) interface{} {
+			if eta, ok := est.ETA(); ok {
+				return int(eta.Seconds())
+			}
+			return nil
+		}),
+		// net jobs per minute leaving the queue
+		"drainRate": drainField(func(est *server.DrainEstimate) interface{} { return est.Rate() }),
+		"jobs": {Type: jobType, Resolve: func(c context.Context, src interface{}, args graphql.Args) (interface{}, error) {
+			count, err := pageSize(args)
+			if err != nil {
+				return nil, err
+			}
+			q, err := dctx(c).Store().GetQueue(src.(manager.QueueInfo).Name)
+			if err != nil {
+				return nil, err
+			}
+			jobs := []*client.Job{}
+			err = q.Page(int64(args.Int("offset", 0)), int64(count), func(_ int, data []byte) error {
+				var job client.Job
+				err := json.Unmarshal(data, &job)
+				if err != nil {
+					return err
+				}
+				jobs = append(jobs, &job)
+				return nil
+			})
+			return jobs, err
+		}},
+	}}
+
+	workerType := &graphql.Object{Name: "Worker", Fields: map[string]*graphql.Field{
+		"wid":       scalar(func(src interface{}) interface{} { return src.(*busyProcess).Wid }),
+		"hostname":  scalar(func(src interface{}) interface{} { return src.(*busyProcess).Hostname }),
+		"pid":       scalar(func(src interface{}) interface{} { return src.(*busyProcess).Pid }),
+		"labels":    scalar(func(src interface{}) interface{} { return src.(*busyProcess).Labels }),
+		"quiet":     scalar(func(src interface{}) interface{} { return src.(*busyProcess).IsQuiet() }),
+		"connected": scalar(func(src interface{}) interface{} { return src.(*busyProcess).Connected }),
+		"jobs": {Type: jobType, Resolve: func(_ context.Context, src interface{}, _ graphql.Args) (interface{}, error) {
+			jobs := []*client.Job{}
+			for _, res := range src.(*busyProcess).Jobs {
+				jobs = append(jobs, res.Job)
+			}
+			return jobs, nil
+		}},
+	}}
+
+	changeType := &graphql.Object{Name: "Change", Fields: map[string]*graphql.Field{
+		"seq":     scalar(func(src interface{}) interface{} { return strconv.FormatInt(src.(*server.Change).Seq, 10) }),
+		"at":      scalar(func(src interface{}) interface{} { return src.(*server.Change).At }),
+		"kind":    scalar(func(src interface{}) interface{} { return src.(*server.Change).Kind }),
+		"subject": scalar(func(src interface{}) interface{} { return src.(*server.Change).Subject }),
+		"detail":  scalar(func(src interface{}) interface{} { return src.(*server.Change).Detail }),
+	}}
+
+	feedType := &graphql.Object{Name: "ChangeFeed", Fields: map[string]*graphql.Field{
+		"next":      scalar(func(src interface{}) interface{} { return strconv.FormatInt(src.(*server.ChangeFeed).Next, 10) }),
+		"truncated": scalar(func(src interface{}) interface{} { return src.(*server.ChangeFeed).Truncated }),
+		"changes": {Type: changeType, Resolve: func(_ context.Context, src interface{}, _ graphql.Args) (interface{}, error) {
+			return src.(*server.ChangeFeed).Changes, nil
+		}},
+	}}
+
+	duplicateType := &graphql.Object{Name: "DuplicateStat", Fields: map[string]*graphql.Field{
+		"jobtype":    scalar(func(src interface{}) interface{} { return src.(*server.DuplicateStat).Type }),
+		"pushed":     scalar(func(src interface{}) interface{} { return src.(*server.DuplicateStat).Pushed }),
+		"duplicates": scalar(func(src interface{}) interface{} { return src.(*server.DuplicateStat).Duplicates }),
+		"rate":       scalar(func(src interface{}) interface{} { return src.(*server.DuplicateStat).Rate() }),
+	}}
+
+	reportType := &graphql.Object{Name: "DuplicatesReport", Fields: map[string]*graphql.Field{
+		"at":            scalar(func(src interface{}) interface{} { return src.(*server.DuplicatesReport).At }),
+		"windowSeconds": scalar(func(src interface{}) interface{} { return int(src.(*server.DuplicatesReport).Window.Seconds()) }),
+		"jobtypes": {Type: duplicateType, Resolve: func(_ context.Context, src interface{}, _ graphql.Args) (interface{}, error) {
+			return src.(*server.DuplicatesReport).JobTypes, nil
+		}},
+	}}
+
+	usageType := &graphql.Object{Name: "Usage", Fields: map[string]*graphql.Field{
+		"label":     scalar(func(src interface{}) interface{} { return src.(*server.UsageRow).Label }),
+		"value":     scalar(func(src interface{}) interface{} { return src.(*server.UsageRow).Value }),
+		"queue":     scalar(func(src interface{}) interface{} { return src.(*server.UsageRow).Queue }),
+		"pushed":    scalar(func(src interface{}) interface{} { return src.(*server.UsageRow).Pushed }),
+		"processed": scalar(func(src interface{}) interface{} { return src.(*server.UsageRow).Processed }),
+		"failed":    scalar(func(src interface{}) interface{} { return src.(*server.UsageRow).Failed }),
+	}}
+
+	statsType := &graphql.Object{Name: "Stats", Fields: map[string]*graphql.Field{}}
+	for name, fn := range map[string]func(*DefaultContext) interface{}{
+		"processed":   func(d *DefaultContext) interface{} { return d.Store().TotalProcessed() },
+		"failures":    func(d *DefaultContext) interface{} { return d.Store().TotalFailures() },
+		"connections": func(d *DefaultContext) interface{} { return atomic.LoadUint64(&d.Server().Stats.Connections) },
+		"commands":    func(d *DefaultContext) interface{} { return atomic.LoadUint64(&d.Server().Stats.Commands) },
+		"uptime":      func(d *DefaultContext) interface{} { return int(time.Since(d.Server().Stats.StartedAt).Seconds()) },
+		"version":     func(d *DefaultContext) interface{} { return client.Version },
+		"enqueued": func(d *DefaultContext) interface{} {
+			total := uint64(0)
+			d.Store().EachQueue(func(q storage.Queue) {
+				total += q.Size()
+			})
+			return total
+		},
+	} {
+		fn := fn
+		statsType.Fields[name] = &graphql.Field{Resolve: func(c context.Context, _ interface{}, _ graphql.Args) (interface{}, error) {
+			return fn(dctx(c)), nil
+		}}
+	}
+
+	queryType := &graphql.Object{Name: "Query", Fields: map[string]*graphql.Field{
+		"stats": {Type: statsType, Resolve: func(_ context.Context, _ interface{}, _ graphql.Args) (interface{}, error) {
+			return struct{}{}, nil
+		}},
+		"queues": {Type: queueType, Resolve: func(c context.Context, _ interface{}, _ graphql.Args) (interface{}, error) {
+			return dctx(c).Server().Manager().ListQueues()
+		}},
+		"queue": {Type: queueType, Resolve: func(c context.Context, _ interface{}, args graphql.Args) (interface{}, error) {
+			queues, err := dctx(c).Server().Manager().ListQueues()
+			if err != nil {
+				return nil, err
+			}
+			for _, q := range queues {
+				if q.Name == args.String("name", "") {
+					return q, nil
+				}
+			}
+			return nil, nil
+		}},
+		"workers": {Type: workerType, Resolve: func(c context.Context, _ interface{}, _ graphql.Args) (interface{}, error) {
+			procs := []*busyProcess{}
+			for _, host := range busyHosts(dctx(c).Request().WithContext(c)) {
+				procs = append(procs, host.Processes...)
+			}
+			return procs, nil
+		}},
+		"scheduled": setField(pageType,
+			func(d *DefaultContext) storage.SortedSet { return d.Store().Scheduled() },
+			manager.Manager.EnumerateScheduled),
+		"retries": setField(pageType,
+			func(d *DefaultContext) storage.SortedSet { return d.Store().Retries() },
+			manager.Manager.EnumerateRetries),
+		"dead": setField(pageType,
+			func(d *DefaultContext) storage.SortedSet { return d.Store().Dead() },
+			manager.Manager.EnumerateDead),
+		// null until the first report, see [duplicates]
+		"duplicates": {Type: reportType, Resolve: func(c context.Context, _ interface{}, _ graphql.Args) (interface{}, error) {
+			if report := dctx(c).Server().DuplicatesReport(); report != nil {
+				return report, nil
+			}
+			return nil, nil
+		}},
+		// usage per label value over the last hours, see [metrics] labels
+		"usage": {Type: usageType, Resolve: func(c context.Context, _ interface{}, args graphql.Args) (interface{}, error) {
+			hours := args.Int("hours", 24)
+			if hours < 1 {
+				return nil, fmt.Errorf("hours must be at least 1")
+			}
+			rows, err := dctx(c).Server().Usage(time.Now().Add(-time.Duration(hours) * time.Hour))
+			if rows == nil || err != nil {
+				return nil, err
+			}
+			return rows, nil
+		}},
+		// the changes feed, after is the next cursor of the last page
+		"changes": {Type: feedType, Resolve: func(c context.Context, _ interface{}, args graphql.Args) (interface{}, error) {
+			count, err := pageSize(args)
+			if err != nil {
+				return nil, err
+			}
+			after, err := strconv.ParseInt(args.String("after", "0"), 10, 64)
+			if err != nil || after < 0 {
+				return nil, fmt.Errorf("Invalid changes cursor %q", args.String("after", ""))
+			}
+			return dctx(c).Server().Changes(after, count)
+		}},
+	}}
+
+	return &graphql.Schema{Query: queryType}
+}
+
+// graphqlHandler answers a GraphQL query given as GET parameters
+// or as a POSTed JSON body.
+func graphqlHandler(w http.ResponseWriter, r *http.Request) {
+	var req graphql.Request
+	switch r.Method {
+	case "GET":
+		params := r.URL.Query()
+		req.Query = params.Get("query")
+		req.OperationName = params.Get("operationName")
+		if vars := params.Get("variables"); vars != "" {
+			err := json.Unmarshal([]byte(vars), &req.Variables)
+			if err != nil {
+				http.Error(w, "Invalid variables: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	case "POST":
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxGraphqlRequest))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "get or post only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp := apiSchema.Execute(r.Context(), &req)
+	data, err := json.Marshal(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Add("Cache-Control", "no-cache")
+	if resp.Data == nil {
+		// the query itself is invalid
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	w.Write(data)
+}
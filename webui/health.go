@@ -0,0 +1,50 @@
+package webui
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+/*
+ * Orchestration probes the Web UI binding, without a password, for
+ * whether to route traffic to the server and whether to restart it:
+ *
+ *   readinessProbe: GET /-/ready     503 until every startup stage is
+ *                                    done, and while Redis recovers
+ *   livenessProbe:  GET /-/healthy   503 once the server is shutting
+ *                                    down or a startup stage failed
+ *
+ * The responses list the state of each stage, see server/startup.go.
+ */
+func readyHandler(ui *WebUI) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ready, report := ui.Server.Readiness()
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		if ready {
+			w.WriteHeader(http.StatusOK)
+			fmt.Fprintln(w, "Faktory is Ready.")
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, "Faktory is not Ready.")
+		}
+		fmt.Fprintln(w, strings.Join(report, "\n"))
+	}
+}
+
+func healthyHandler(ui *WebUI) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		if ui.Server.Healthy() {
+			w.WriteHeader(http.StatusOK)
+			fmt.Fprintln(w, "Faktory is Healthy.")
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, "Faktory is not Healthy.")
+		_, report := ui.Server.Readiness()
+		fmt.Fprintln(w, strings.Join(report, "\n"))
+	}
+}
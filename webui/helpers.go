@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"html"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"sort"
 	"strconv"
 	"strings"
@@ -28,7 +30,7 @@ func serverUtcTime() string {
 }
 
 func serverLocation(req *http.Request) string {
-	return ctx(req).Server().Options.Binding
+	return strings.Join(ctx(req).Server().Bindings(), ",")
 }
 
 func rtl(req *http.Request) bool {
@@ -48,8 +50,21 @@ func t(req *http.Request, word string) string {
 	return dc.Translation(word)
 }
 
+// pageparam preserves any other query parameters, e.g. filters
 func pageparam(req *http.Request, pageValue uint64) string {
-	return fmt.Sprintf("page=%d", pageValue)
+	params := req.URL.Query()
+	params.Set("page", strconv.FormatUint(pageValue, 10))
+	return params.Encode()
+}
+
+func cursorparam(req *http.Request, cursor string) string {
+	params := req.URL.Query()
+	if cursor == "" {
+		params.Del("cursor")
+	} else {
+		params.Set("cursor", cursor)
+	}
+	return params.Encode()
 }
 
 func currentStatus(req *http.Request) string {
@@ -67,7 +82,9 @@ type Queue struct {
 func queues(req *http.Request) []Queue {
 	queues := make([]Queue, 0)
 	ctx(req).Store().EachQueue(func(q storage.Queue) {
-		queues = append(queues, Queue{q.Name(), q.Size()})
+		if can(req, q.Name(), canView) {
+			queues = append(queues, Queue{q.Name(), q.Size()})
+		}
 	})
 
 	sort.Slice(queues, func(i, j int) bool {
@@ -139,30 +156,268 @@ func relativeTime(moment string) string {
 	return Timeago(tm)
 }
 
-func unfiltered() bool {
-	return true
+// filterParams are the query parameters which filter the
+// Retries, Scheduled and Dead listings.  Filters live in the URL
+// so a filtered view can be bookmarked or linked from a runbook.
+var filterParams = []string{"queue", "jobtype", "annotation", "since"}
+
+func filterParam(req *http.Request, name string) string {
+	return strings.TrimSpace(req.URL.Query().Get(name))
 }
 
-func filtering(set string) string {
-	return ""
+func filterValue(req *http.Request) string {
+	return filterParam(req, "annotation")
 }
 
-func setJobs(set storage.SortedSet, count, currentPage uint64, fn func(idx int, key []byte, job *client.Job)) {
-	_, err := set.Page(int((currentPage-1)*count), int(count), func(idx int, entry storage.SortedEntry) error {
-		job, err := entry.Job()
+func unfiltered(req *http.Request) bool {
+	return filterQuery(req.URL.Query()) == ""
+}
+
+// filterQuery encodes the filter parameters in values, dropping
+// everything else, e.g. the cursor.
+func filterQuery(values url.Values) string {
+	params := url.Values{}
+	for _, name := range filterParams {
+		val := strings.TrimSpace(values.Get(name))
+		if val != "" {
+			params.Set(name, val)
+		}
+	}
+	return params.Encode()
+}
+
+// filteredPath returns the path with the request's filters so
+// actions on a filtered listing return to the same view.
+func filteredPath(req *http.Request, path string) string {
+	query := filterQuery(req.URL.Query())
+	if query == "" {
+		return path
+	}
+	return path + "?" + query
+}
+
+const (
+	savedFiltersCookie = "faktory_filters"
+	// browsers drop cookies larger than 4KB
+	maxSavedFilters = 3800
+)
+
+// filterPages are the listings which can be filtered
+var filterPages = map[string]bool{
+	"/retries":   true,
+	"/scheduled": true,
+	"/morgue":    true,
+}
+
+type savedFilter struct {
+	Name string
+	URL  string
+}
+
+// savedFilters returns the user's named filters, sorted by name.
+// They're kept in a cookie so each browser has its own set.
+func savedFilters(req *http.Request) url.Values {
+	saved := url.Values{}
+	cookie, err := req.Cookie(savedFiltersCookie)
+	if err != nil {
+		return saved
+	}
+	values, err := url.ParseQuery(cookie.Value)
+	if err != nil {
+		return saved
+	}
+	for name := range values {
+		link, err := url.Parse(values.Get(name))
+		if err == nil && link.Host == "" && filterPages[link.Path] {
+			saved.Set(name, values.Get(name))
+		}
+	}
+	return saved
+}
+
+func savedFilterList(req *http.Request) []savedFilter {
+	saved := savedFilters(req)
+	list := make([]savedFilter, 0, len(saved))
+	for name := range saved {
+		list = append(list, savedFilter{name, saved.Get(name)})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+type jobFilter struct {
+	Queue      string
+	JobType    string
+	Annotation string
+	Since      time.Time
+}
+
+func currentFilter(req *http.Request) *jobFilter {
+	return &jobFilter{
+		Queue:      filterParam(req, "queue"),
+		JobType:    filterParam(req, "jobtype"),
+		Annotation: filterValue(req),
+		Since:      sinceTime(req, filterParam(req, "since")),
+	}
+}
+
+// sinceTime parses the "since" filter: a duration like "90m", "24h"
+// or "7d", a timestamp, or "deploy" or "incident" for the latest
+// marker of that kind.  Anything else doesn't filter.
+func sinceTime(req *http.Request, value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	if value == "deploy" || value == "incident" {
+		now := time.Now()
+		markers, err := ctx(req).Server().Markers(now.Add(-server.MarkerRetention), now)
 		if err != nil {
-			util.Warnf("Error parsing JSON: %s", string(entry.Value()))
-			return err
+			util.Warnf("Unable to read markers: %v", err)
+			return time.Time{}
+		}
+		for idx := len(markers) - 1; idx >= 0; idx-- {
+			if markers[idx].Kind == value {
+				return markers[idx].At
+			}
+		}
+		return time.Time{}
+	}
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(value[:len(value)-1])
+		if err == nil && days > 0 {
+			return time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+		}
+		return time.Time{}
+	}
+	if dur, err := time.ParseDuration(value); err == nil && dur > 0 {
+		return time.Now().Add(-dur)
+	}
+	if at, err := util.ParseTime(value); err == nil {
+		return at
+	}
+	if at, err := time.Parse(time.RFC3339, value); err == nil {
+		return at
+	}
+	return time.Time{}
+}
+
+type sinceOption struct {
+	Value string
+	Label string
+}
+
+// sinceOptions are the choices for the since filter, including
+// the current value when it was typed into the URL.
+func sinceOptions(req *http.Request, current string) []sinceOption {
+	opts := []sinceOption{
+		{"1h", "1h"},
+		{"24h", "24h"},
+		{"7d", "7d"},
+		{"deploy", t(req, "SinceDeploy")},
+		{"incident", t(req, "SinceIncident")},
+	}
+	if current == "" {
+		return opts
+	}
+	for _, opt := range opts {
+		if opt.Value == current {
+			return opts
+		}
+	}
+	return append(opts, sinceOption{current, current})
+}
+
+// Matches compares the since filter to the time the job last
+// failed or, for jobs which haven't failed, was created.
+func (f *jobFilter) Matches(job *client.Job) bool {
+	if f.Queue != "" && job.Queue != f.Queue {
+		return false
+	}
+	if f.JobType != "" && job.Type != f.JobType {
+		return false
+	}
+	if !annotationMatches(job, f.Annotation) {
+		return false
+	}
+	if !f.Since.IsZero() {
+		ts := job.CreatedAt
+		if job.Failure != nil {
+			ts = job.Failure.FailedAt
 		}
-		key, err := entry.Key()
+		at, err := util.ParseTime(ts)
+		if err != nil || at.Before(f.Since) {
+			return false
+		}
+	}
+	return true
+}
+
+// A filter of "key=value" must match an annotation exactly,
+// otherwise we look for the value in any annotation key or value.
+func annotationMatches(job *client.Job, filter string) bool {
+	if filter == "" {
+		return true
+	}
+
+	pair := strings.SplitN(filter, "=", 2)
+	if len(pair) == 2 {
+		val, ok := job.Annotations[pair[0]]
+		return ok && val == pair[1]
+	}
+
+	for k, v := range job.Annotations {
+		if k == filter || strings.Contains(v, filter) {
+			return true
+		}
+	}
+	return false
+}
+
+type setEntry struct {
+	Key []byte
+	Job *client.Job
+}
+
+// setJobs returns up to count jobs after the given cursor
+// along with the cursor for the next page, "" if there are
+// no more jobs.
+func setJobs(req *http.Request, set storage.SortedSet, count int, cursor string) ([]setEntry, string) {
+	filter := currentFilter(req)
+	access := ctx(req).access()
+	entries := make([]setEntry, 0, count)
+
+	for {
+		next, err := set.Cursor(cursor, count, func(_ int, entry storage.SortedEntry) error {
+			job, err := entry.Job()
+			if err != nil {
+				util.Warnf("Error parsing JSON: %s", string(entry.Value()))
+				return err
+			}
+			if !filter.Matches(job) || !access.can(job.Queue, canView) {
+				return nil
+			}
+			key, err := entry.Key()
+			if err != nil {
+				return err
+			}
+			entries = append(entries, setEntry{key, job})
+			if len(entries) == count {
+				return storage.ErrStopIteration
+			}
+			return nil
+		})
 		if err != nil {
-			return err
+			util.Error("Error iterating sorted set", err)
+			return entries, ""
 		}
-		fn(idx, key, job)
-		return nil
-	})
-	if err != nil {
-		util.Error("Error iterating sorted set", err)
+		// Filtering may need to scan many batches to fill a page
+		// so it's slow with very large sets.
+		if next == "" || len(entries) == count {
+			return entries, next
+		}
+		cursor = next
 	}
 }
 
@@ -172,7 +427,7 @@ func busyReservations(req *http.Request, fn func(worker *manager.Reservation)) {
 		err := json.Unmarshal(entry.Value(), &res)
 		if err != nil {
 			util.Error("Cannot unmarshal reservation", err)
-		} else {
+		} else if can(req, res.Job.Queue, canView) {
 			fn(&res)
 		}
 		return err
@@ -188,7 +443,203 @@ func busyWorkers(req *http.Request, fn func(proc *server.ClientData)) {
 	}
 }
 
+// Hosts are collapsed on the Busy page once there
+// are more processes than this.
+const collapseProcesses = 50
+
+type busyProcess struct {
+	*server.ClientData
+	Jobs []*manager.Reservation
+	// false if the process holding the reservations
+	// is no longer sending heartbeats
+	Connected bool
+}
+
+type busyHost struct {
+	Hostname  string
+	Processes []*busyProcess
+	Busy      int
+}
+
+// busyHosts groups the processes and their jobs by host,
+// sorted by hostname and pid.  Jobs reserved by processes which
+// have gone away are grouped under a host with an empty name.
+func busyHosts(req *http.Request) []*busyHost {
+	procs := map[string]*busyProcess{}
+	busyWorkers(req, func(worker *server.ClientData) {
+		procs[worker.Wid] = &busyProcess{ClientData: worker, Connected: true}
+	})
+	busyReservations(req, func(res *manager.Reservation) {
+		proc, ok := procs[res.Wid]
+		if !ok {
+			proc = &busyProcess{ClientData: &server.ClientData{Wid: res.Wid}}
+			procs[res.Wid] = proc
+		}
+		proc.Jobs = append(proc.Jobs, res)
+	})
+
+	byName := map[string]*busyHost{}
+	hosts := []*busyHost{}
+	for _, proc := range procs {
+		host, ok := byName[proc.Hostname]
+		if !ok {
+			host = &busyHost{Hostname: proc.Hostname}
+			byName[proc.Hostname] = host
+			hosts = append(hosts, host)
+		}
+		host.Processes = append(host.Processes, proc)
+		host.Busy += len(proc.Jobs)
+	}
+
+	sort.Slice(hosts, func(i, j int) bool {
+		a, b := hosts[i].Hostname, hosts[j].Hostname
+		if a == "" || b == "" {
+			return b == ""
+		}
+		return a < b
+	})
+	for _, host := range hosts {
+		sort.Slice(host.Processes, func(i, j int) bool {
+			a, b := host.Processes[i], host.Processes[j]
+			if a.Pid != b.Pid {
+				return a.Pid < b.Pid
+			}
+			return a.Wid < b.Wid
+		})
+	}
+	return hosts
+}
+
+func processCount(hosts []*busyHost) int {
+	count := 0
+	for _, host := range hosts {
+		count += len(host.Processes)
+	}
+	return count
+}
+
+// matchingKeys returns the keys of every job in the set which
+// matches the request's filters, across all pages.
+func matchingKeys(req *http.Request, set storage.SortedSet) ([]string, error) {
+	filter := currentFilter(req)
+	access := ctx(req).access()
+	keys := []string{}
+	cursor := ""
+	for {
+		next, err := set.Cursor(cursor, 100, func(_ int, entry storage.SortedEntry) error {
+			job, err := entry.Job()
+			if err != nil {
+				util.Warnf("Error parsing JSON: %s", string(entry.Value()))
+				return nil
+			}
+			if !filter.Matches(job) || !access.can(job.Queue, canView) {
+				return nil
+			}
+			key, err := entry.Key()
+			if err != nil {
+				return err
+			}
+			keys = append(keys, string(key))
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if next == "" {
+			return keys, nil
+		}
+		cursor = next
+	}
+}
+
+var errForbidden = fmt.Errorf("Forbidden")
+
+// the right needed on a job's queue for each action
+var actionRights = map[string]right{
+	"retry":  canRetry,
+	"delete": canDelete,
+	"kill":   canDelete,
+}
+
+// checkRights returns errForbidden unless the user has the
+// action's right on the queue of every job.
+func checkRights(req *http.Request, set storage.SortedSet, action string, keys []string) error {
+	access := ctx(req).access()
+	needed, ok := actionRights[action]
+	if access == nil || !ok {
+		return nil
+	}
+	for _, key := range keys {
+		entry, err := set.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			continue
+		}
+		job, err := entry.Job()
+		if err != nil {
+			return err
+		}
+		if !access.can(job.Queue, needed) {
+			return errForbidden
+		}
+	}
+	return nil
+}
+
+// actOn applies the action to the given keys, "all" for the whole
+// set or "matching" for every job matching the request's filters.
+// Users limited to some queues can't act on the whole set.
 func actOn(req *http.Request, set storage.SortedSet, action string, keys []string) error {
+	if len(keys) == 1 && keys[0] == "all" && !admin(req) {
+		keys = []string{"matching"}
+	}
+	if len(keys) == 1 && keys[0] == "matching" {
+		// the store can't kill a whole set at once
+		if unfiltered(req) && admin(req) && action != "kill" {
+			keys = []string{"all"}
+		} else {
+			var err error
+			keys, err = matchingKeys(req, set)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err := checkRights(req, set, action, keys)
+	if err != nil {
+		return err
+	}
+	err = applyAction(req, set, action, keys)
+	if err != nil {
+		return err
+	}
+
+	detail := map[string]interface{}{"count": len(keys)}
+	if len(keys) == 1 && keys[0] == "all" {
+		detail = map[string]interface{}{"all": true}
+	}
+	recordChange(req, setChanges[action], set.Name(), detail)
+	return nil
+}
+
+var setChanges = map[string]string{
+	"delete": server.ChangeJobsDeleted,
+	"retry":  server.ChangeJobsRetried,
+	"kill":   server.ChangeJobsKilled,
+}
+
+// recordChange adds the change to the server's changes feed,
+// noting who made it
+func recordChange(req *http.Request, kind string, subject string, detail map[string]interface{}) {
+	if name := userName(req); name != "" {
+		detail["user"] = name
+	}
+	ctx(req).Server().RecordChange(kind, subject, detail)
+}
+
+func applyAction(req *http.Request, set storage.SortedSet, action string, keys []string) error {
 	switch action {
 	case "delete":
 		if len(keys) == 1 && keys[0] == "all" {
@@ -251,6 +702,16 @@ func redis_info(req *http.Request) string {
 	}
 	return val
 }
+
+// backups lists the server's backups, newest first
+func backups(req *http.Request) []string {
+	paths, err := ctx(req).Server().Backups()
+	if err != nil {
+		return nil
+	}
+	return paths
+}
+
 func rss() string {
 	ex, err := util.FileExists("/proc/self/status")
 	if err != nil || !ex {
@@ -303,6 +764,108 @@ func daysMatches(req *http.Request, value string, defalt bool) string {
 	return ""
 }
 
+func periodMatches(req *http.Request, value string, defalt bool) string {
+	period := req.URL.Query().Get("period")
+	if period == value || (period == "" && defalt) {
+		return "active"
+	}
+	return ""
+}
+
+// dashboardSeries returns the values of one of the queue's
+// metrics: depth, processed, errors or latency.
+func dashboardSeries(dash *server.QueueDashboard, metric string) []float64 {
+	values := make([]float64, len(dash.Points))
+	for idx, point := range dash.Points {
+		switch metric {
+		case "depth":
+			values[idx] = float64(point.Depth)
+		case "processed":
+			values[idx] = float64(point.Processed + point.Failed)
+		case "errors":
+			values[idx] = point.ErrorRate()
+		case "latency":
+			values[idx] = point.Latency.Seconds()
+		}
+	}
+	return values
+}
+
+// dashboardChart renders one of the queue's metrics
+// with the period's markers.
+func dashboardChart(dash *server.QueueDashboard, metric string) string {
+	values := dashboardSeries(dash, metric)
+	if len(dash.Points) < 2 {
+		return sparkline(values, nil)
+	}
+
+	first := dash.Points[0].At
+	span := dash.Points[len(dash.Points)-1].At.Sub(first)
+	marks := make([]*chartMark, len(dash.Markers))
+	for idx, m := range dash.Markers {
+		offset := float64(m.At.Sub(first)) / float64(span)
+		if offset > 1 {
+			offset = 1
+		}
+		marks[idx] = &chartMark{offset, m.Kind, m.Label}
+	}
+	return sparkline(values, marks)
+}
+
+type chartMark struct {
+	// 0 is the start of the chart, 1 the end
+	Offset float64
+	Kind   string
+	Label  string
+}
+
+// sparkline renders the values as a small inline SVG chart
+// scaled to the largest value, with a vertical line for each mark.
+func sparkline(values []float64, marks []*chartMark) string {
+	const width, height = 600.0, 80.0
+	if len(values) == 0 {
+		return ""
+	}
+
+	max := 0.0
+	for _, val := range values {
+		if val > max {
+			max = val
+		}
+	}
+	if max == 0 {
+		max = 1
+	}
+
+	step := width
+	if len(values) > 1 {
+		step = width / float64(len(values)-1)
+	}
+	var points bytes.Buffer
+	for idx, val := range values {
+		if idx > 0 {
+			points.WriteByte(' ')
+		}
+		fmt.Fprintf(&points, "%.1f,%.1f", float64(idx)*step, height-(val/max)*(height-2)-1)
+	}
+
+	var lines bytes.Buffer
+	for _, mark := range marks {
+		x := mark.Offset * width
+		fmt.Fprintf(&lines, `<line class="marker %s" x1="%.1f" y1="0" x2="%.1f" y2="%.0f"><title>%s</title></line>`,
+			mark.Kind, x, x, height, html.EscapeString(mark.Label))
+	}
+	return fmt.Sprintf(`<svg class="sparkline" viewBox="0 0 %.0f %.0f" preserveAspectRatio="none">%s<polyline points="%s"/></svg>`,
+		width, height, lines.String(), points.String())
+}
+
+func formatLatency(dur time.Duration) string {
+	if dur < time.Second {
+		return fmt.Sprintf("%d ms", dur/time.Millisecond)
+	}
+	return fmt.Sprintf("%.1f sec", dur.Seconds())
+}
+
 func processedHistory(req *http.Request) string {
 	cnt := days(req)
 	procd := map[string]uint64{}
@@ -335,6 +898,30 @@ func failedHistory(req *http.Request) string {
 	return string(str)
 }
 
+// historyMarkers lists the markers within the history
+// graph's range as [{"x": unixtime, "kind": "deploy", "label": "v1.2"}]
+func historyMarkers(req *http.Request) string {
+	now := time.Now()
+	markers, err := ctx(req).Server().Markers(now.AddDate(0, 0, -days(req)), now)
+	if err != nil {
+		util.Warnf("Unable to read markers: %v", err)
+	}
+
+	points := make([]map[string]interface{}, len(markers))
+	for idx, m := range markers {
+		points[idx] = map[string]interface{}{
+			"x":     m.At.Unix(),
+			"kind":  m.Kind,
+			"label": m.Label,
+		}
+	}
+	str, err := json.Marshal(points)
+	if err != nil {
+		return err.Error()
+	}
+	return string(str)
+}
+
 func sortedLocaleNames(req *http.Request, fn func(string, bool)) {
 	c := ctx(req)
 	names := make(sort.StringSlice, len(locales))
@@ -358,6 +945,55 @@ func displayFullArgs(args []interface{}) string {
 	return displayLimitedArgs(args, 1024*1024)
 }
 
+// redacted is true if the job's args must not be shown, they're
+// encrypted or the job is in a sensitive queue
+func redacted(req *http.Request, job *client.Job) bool {
+	if _, ok := job.GetCustom(server.EncryptedAttribute); ok {
+		return true
+	}
+	return ctx(req).Server().Sensitive(job.Queue)
+}
+
+func displayJobArgs(req *http.Request, job *client.Job) string {
+	if redacted(req, job) {
+		return t(req, "Redacted")
+	}
+	return displayArgs(job.Args)
+}
+
+func displayFullJobArgs(req *http.Request, job *client.Job) string {
+	if redacted(req, job) {
+		return t(req, "Redacted")
+	}
+	return displayFullArgs(job.Args)
+}
+
+// jobLineage returns the root of the job's lineage tree, its
+// ancestors are chained above it.  nil if it has no relatives.
+func jobLineage(req *http.Request, job *client.Job) *server.LineageNode {
+	ancestors, node, err := ctx(req).Server().Lineage(job)
+	if err != nil {
+		util.Warnf("Unable to load lineage of %s: %v", job.Jid, err)
+		return nil
+	}
+	if len(ancestors) == 0 && len(node.Children) == 0 {
+		return nil
+	}
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		ancestors[i].Children = []*server.LineageNode{node}
+		node = ancestors[i]
+	}
+	return node
+}
+
+// displayCustom hides the ciphertext of encrypted args
+func displayCustom(key string, val interface{}) string {
+	if key == server.EncryptedAttribute {
+		return "…"
+	}
+	return fmt.Sprintf("%#v", val)
+}
+
 func displayLimitedArgs(args []interface{}, limit int) string {
 	var b strings.Builder
 	for idx, arg := range args {
@@ -375,3 +1011,230 @@ func displayLimitedArgs(args []interface{}, limit int) string {
 	}
 	return b.String()
 }
+
+type diffLine struct {
+	// "+" for an added line, "-" for a removed one
+	// and " " for an unchanged one
+	Op   string
+	Text string
+}
+
+// diffLines returns the changes from a to b.  Backtraces are at
+// most 50 lines so a simple longest common subsequence is fine.
+func diffLines(a, b []string) []diffLine {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	lines := make([]diffLine, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, diffLine{" ", a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, diffLine{"-", a[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{"+", b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, diffLine{"-", a[i]})
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, diffLine{"+", b[j]})
+	}
+	return lines
+}
+
+type attemptDiff struct {
+	*client.Attempt
+	Number   int
+	Previous *client.Attempt
+	// what changed since the previous attempt, nothing
+	// is marked changed for the first one
+	TypeChanged      bool
+	MessageChanged   bool
+	BacktraceChanged bool
+	Backtrace        []diffLine
+}
+
+func (d *attemptDiff) Identical() bool {
+	return d.Previous != nil && !d.TypeChanged && !d.MessageChanged && !d.BacktraceChanged
+}
+
+// attemptDiffs compares each of the job's recorded failures
+// with the one before it.
+func attemptDiffs(job *client.Job) []*attemptDiff {
+	if job.Failure == nil {
+		return nil
+	}
+	attempts := job.Failure.Attempts
+	// the attempts before these have been dropped
+	first := job.Failure.RetryCount + 2 - len(attempts)
+	if first < 1 {
+		first = 1
+	}
+
+	diffs := make([]*attemptDiff, len(attempts))
+	for idx, attempt := range attempts {
+		diff := &attemptDiff{Attempt: attempt, Number: first + idx}
+		if idx > 0 {
+			prev := attempts[idx-1]
+			diff.Previous = prev
+			diff.TypeChanged = prev.ErrorType != attempt.ErrorType
+			diff.MessageChanged = prev.ErrorMessage != attempt.ErrorMessage
+			diff.Backtrace = diffLines(prev.Backtrace, attempt.Backtrace)
+			for _, line := range diff.Backtrace {
+				if line.Op != " " {
+					diff.BacktraceChanged = true
+					break
+				}
+			}
+		}
+		diffs[idx] = diff
+	}
+	return diffs
+}
+
+func formatRuntime(secs float64) string {
+	return formatLatency(time.Duration(secs * float64(time.Second)))
+}
+
+type sampleEvent struct {
+	Name string
+	*server.SampleEvent
+}
+
+func sampleEvents(smp *server.Sample) []sampleEvent {
+	events := []sampleEvent{{"Fetched", smp.Fetched}}
+	if smp.Acked != nil {
+		events = append(events, sampleEvent{"Acknowledged", smp.Acked})
+	}
+	if smp.Failed != nil {
+		events = append(events, sampleEvent{"Failed", smp.Failed})
+	}
+	return events
+}
+
+func sampleState(smp *server.Sample) string {
+	switch {
+	case smp.Acked != nil:
+		return "Acknowledged"
+	case smp.Failed != nil:
+		return "Failed"
+	default:
+		return "Working"
+	}
+}
+
+func prettyPayload(payload []byte) string {
+	var buf bytes.Buffer
+	err := json.Indent(&buf, payload, "", "  ")
+	if err != nil {
+		return string(payload)
+	}
+	return buf.String()
+}
+
+// drainEstimates predicts when the queues will be empty, nil
+// if queue metrics aren't available.
+func drainEstimates(req *http.Request, queues ...string) map[string]*server.DrainEstimate {
+	estimates, err := ctx(req).Server().DrainEstimates(queues...)
+	if err != nil {
+		util.Warnf("Unable to estimate drain times: %v", err)
+		return nil
+	}
+	return estimates
+}
+
+func drainTime(req *http.Request, est *server.DrainEstimate) string {
+	if est == nil {
+		return "-"
+	}
+	eta, ok := est.ETA()
+	if !ok {
+		return t(req, "NotDraining")
+	}
+	return formatETA(eta)
+}
+
+func formatETA(eta time.Duration) string {
+	switch {
+	case eta == 0:
+		return "-"
+	case eta < time.Minute:
+		return "< 1 min"
+	case eta < time.Hour:
+		return fmt.Sprintf("%d min", eta/time.Minute)
+	case eta < 48*time.Hour:
+		return fmt.Sprintf("%d h %d min", eta/time.Hour, (eta%time.Hour)/time.Minute)
+	default:
+		return fmt.Sprintf("%d days", eta/(24*time.Hour))
+	}
+}
+
+// heatmap averages the jobs processed in each hour of the week,
+// Monday first, in UTC.
+type heatmap struct {
+	Days  int
+	Cells [7][24]uint64
+	Max   uint64
+}
+
+func (h *heatmap) Weekday(row int) string {
+	return time.Weekday((row + 1) % 7).String()[:3]
+}
+
+// Shade is the cell's opacity relative to the busiest hour.
+func (h *heatmap) Shade(row, hour int) string {
+	if h.Max == 0 {
+		return "0"
+	}
+	return fmt.Sprintf("%.2f", float64(h.Cells[row][hour])/float64(h.Max))
+}
+
+func throughputHeatmap(req *http.Request) *heatmap {
+	h := &heatmap{Days: days(req)}
+	if max := int(storage.HourlyRetention / (24 * time.Hour)); h.Days > max {
+		h.Days = max
+	}
+
+	var sums, counts [7][24]uint64
+	err := ctx(req).Store().HourlyHistory(h.Days*24, func(hour time.Time, procd uint64, _ uint64) {
+		row := (int(hour.Weekday()) + 6) % 7
+		sums[row][hour.Hour()] += procd
+		counts[row][hour.Hour()]++
+	})
+	if err != nil {
+		util.Warnf("Unable to read hourly history: %v", err)
+		return h
+	}
+	for row := range sums {
+		for hour := range sums[row] {
+			if counts[row][hour] > 0 {
+				h.Cells[row][hour] = sums[row][hour] / counts[row][hour]
+			}
+			if h.Cells[row][hour] > h.Max {
+				h.Max = h.Cells[row][hour]
+			}
+		}
+	}
+	return h
+}
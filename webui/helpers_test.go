@@ -0,0 +1,108 @@
+package webui
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnnotationFilter(t *testing.T) {
+	job := client.NewJob("Invoice", 1)
+	assert.True(t, annotationMatches(job, ""))
+	assert.False(t, annotationMatches(job, "INV-1234"))
+
+	job.Annotate(map[string]string{"external_ref": "INV-1234"})
+	assert.True(t, annotationMatches(job, "external_ref=INV-1234"))
+	assert.False(t, annotationMatches(job, "external_ref=INV-9999"))
+	assert.False(t, annotationMatches(job, "other=INV-1234"))
+	assert.True(t, annotationMatches(job, "INV-1234"))
+	assert.True(t, annotationMatches(job, "INV"))
+	assert.True(t, annotationMatches(job, "external_ref"))
+
+	req := httptest.NewRequest("GET", "/retries?annotation=external_ref%3DINV-1234&cursor=1530000000.5%7C2", nil)
+	assert.False(t, unfiltered(req))
+	assert.Equal(t, "external_ref=INV-1234", filterValue(req))
+	assert.Equal(t, "annotation=external_ref%3DINV-1234&cursor=1530000001%7C1", cursorparam(req, "1530000001|1"))
+	assert.Equal(t, "annotation=external_ref%3DINV-1234", cursorparam(req, ""))
+
+	req = httptest.NewRequest("GET", "/queues/default", nil)
+	assert.True(t, unfiltered(req))
+	assert.Equal(t, "page=2", pageparam(req, 2))
+}
+
+func TestJobFilter(t *testing.T) {
+	req := httptest.NewRequest("GET", "/morgue?queue=billing&jobtype=Invoice&since=7d&cursor=1530000000.5%7C2", nil)
+	assert.False(t, unfiltered(req))
+	assert.Equal(t, "jobtype=Invoice&queue=billing&since=7d", filterQuery(req.URL.Query()))
+	assert.Equal(t, "/morgue?jobtype=Invoice&queue=billing&since=7d", filteredPath(req, "/morgue"))
+	assert.Equal(t, "/morgue", filteredPath(httptest.NewRequest("GET", "/morgue?cursor=1", nil), "/morgue"))
+
+	filter := currentFilter(req)
+	assert.Equal(t, "billing", filter.Queue)
+	assert.WithinDuration(t, time.Now().Add(-7*24*time.Hour), filter.Since, time.Minute)
+
+	job := client.NewJob("Invoice", 1)
+	job.Queue = "billing"
+	job.CreatedAt = util.Thens(time.Now().Add(-8 * 24 * time.Hour))
+	assert.False(t, filter.Matches(job))
+	job.Failure = &client.Failure{FailedAt: util.Nows()}
+	assert.True(t, filter.Matches(job))
+	job.Type = "Receipt"
+	assert.False(t, filter.Matches(job))
+	job.Type = "Invoice"
+	job.Queue = "default"
+	assert.False(t, filter.Matches(job))
+
+	assert.WithinDuration(t, time.Now().Add(-90*time.Minute), sinceTime(req, "90m"), time.Minute)
+	assert.True(t, sinceTime(req, "-1h").IsZero())
+	assert.True(t, sinceTime(req, "yesterday").IsZero())
+	assert.Equal(t, int64(1530000000), sinceTime(req, "2018-06-26T08:00:00Z").Unix())
+}
+
+func TestAttemptDiffs(t *testing.T) {
+	lines := diffLines([]string{"a.go:1", "b.go:2", "c.go:3"}, []string{"a.go:1", "x.go:9", "c.go:3", "d.go:4"})
+	assert.Equal(t, []diffLine{
+		{" ", "a.go:1"},
+		{"-", "b.go:2"},
+		{"+", "x.go:9"},
+		{" ", "c.go:3"},
+		{"+", "d.go:4"},
+	}, lines)
+
+	job := client.NewJob("Invoice", 1)
+	assert.Nil(t, attemptDiffs(job))
+
+	job.Failure = &client.Failure{
+		RetryCount: 7,
+		Attempts: []*client.Attempt{
+			{ErrorType: "Timeout", ErrorMessage: "read timeout", Backtrace: []string{"a.go:1"}},
+			{ErrorType: "Timeout", ErrorMessage: "read timeout", Backtrace: []string{"a.go:1"}},
+			{ErrorType: "Timeout", ErrorMessage: "connection refused", Backtrace: []string{"a.go:1"}},
+			{ErrorType: "RuntimeError", ErrorMessage: "connection refused", Backtrace: []string{"b.go:2"}},
+		},
+	}
+	diffs := attemptDiffs(job)
+	assert.Equal(t, 4, len(diffs))
+	assert.Equal(t, 5, diffs[0].Number)
+	assert.Equal(t, 8, diffs[3].Number)
+	assert.False(t, diffs[0].Identical())
+	assert.True(t, diffs[1].Identical())
+	assert.True(t, diffs[2].MessageChanged)
+	assert.False(t, diffs[2].TypeChanged)
+	assert.False(t, diffs[2].BacktraceChanged)
+	assert.True(t, diffs[3].TypeChanged)
+	assert.False(t, diffs[3].MessageChanged)
+	assert.True(t, diffs[3].BacktraceChanged)
+}
+
+func TestFormatETA(t *testing.T) {
+	assert.Equal(t, "-", formatETA(0))
+	assert.Equal(t, "< 1 min", formatETA(20*time.Second))
+	assert.Equal(t, "12 min", formatETA(12*time.Minute+30*time.Second))
+	assert.Equal(t, "3 h 20 min", formatETA(200*time.Minute))
+	assert.Equal(t, "4 days", formatETA(100*time.Hour))
+}
@@ -8,8 +8,12 @@ import (
 	"net/url"
 	"regexp"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/contribsys/faktory/client"
 	"github.com/contribsys/faktory/server"
+	"github.com/contribsys/faktory/util"
 )
 
 func statsHandler(w http.ResponseWriter, r *http.Request) {
@@ -18,6 +22,17 @@ func statsHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if faktory, ok := hash["faktory"].(map[string]interface{}); ok && !admin(r) {
+		// only show the queues the user may see
+		all, _ := faktory["queues"].(map[string]int64)
+		queues := map[string]int64{}
+		for name, size := range all {
+			if can(r, name, canView) {
+				queues[name] = size
+			}
+		}
+		faktory["queues"] = queues
+	}
 	data, err := json.Marshal(hash)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -45,6 +60,84 @@ var (
 	LAST_ELEMENT = regexp.MustCompile(`\/([^\/]+)\z`)
 )
 
+var dashboardPeriods = map[string]time.Duration{
+	"1h":  1 * time.Hour,
+	"6h":  6 * time.Hour,
+	"24h": 24 * time.Hour,
+}
+
+func queueDashboardHandler(w http.ResponseWriter, r *http.Request) {
+	name := LAST_ELEMENT.FindStringSubmatch(r.URL.Path)
+	if name == nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	if !can(r, name[1], canView) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	period, ok := dashboardPeriods[r.URL.Query().Get("period")]
+	if !ok {
+		period = 1 * time.Hour
+	}
+	dash, err := ctx(r).Server().QueueDashboard(name[1], period)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if dash == nil {
+		http.Error(w, "Queue metrics are not enabled", http.StatusNotFound)
+		return
+	}
+	ego_queueDashboard(w, r, dash)
+}
+
+// filtersHandler saves or deletes a named filter for the
+// listing in the "path" field.
+func filtersHandler(w http.ResponseWriter, r *http.Request) {
+	path := r.FormValue("path")
+	if !filterPages[path] {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		http.Error(w, "Filter name is required", http.StatusBadRequest)
+		return
+	}
+
+	link := path
+	if query := filterQuery(r.PostForm); query != "" {
+		link = path + "?" + query
+	}
+	saved := savedFilters(r)
+	switch r.FormValue("action") {
+	case "save":
+		saved.Set(name, link)
+	case "delete":
+		saved.Del(name)
+	default:
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	value := saved.Encode()
+	if len(value) > maxSavedFilters {
+		http.Error(w, "Too many saved filters, delete some first", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     savedFiltersCookie,
+		Value:    value,
+		Path:     "/",
+		Expires:  time.Now().Add(365 * 24 * time.Hour),
+		HttpOnly: true,
+	})
+	http.Redirect(w, r, link, http.StatusFound)
+}
+
 func queueHandler(w http.ResponseWriter, r *http.Request) {
 	name := LAST_ELEMENT.FindStringSubmatch(r.URL.Path)
 	if name == nil {
@@ -52,6 +145,10 @@ func queueHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	queueName := name[1]
+	if !can(r, queueName, canView) || (r.Method == "POST" && !can(r, queueName, canDelete)) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 	q, err := ctx(r).Store().GetQueue(queueName)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -78,13 +175,18 @@ func queueHandler(w http.ResponseWriter, r *http.Request) {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
+			recordChange(r, server.ChangeJobsDeleted, queueName, map[string]interface{}{"count": len(bkeys)})
 		} else {
 			// clear entire queue
-			_, err := q.Clear()
+			if deferForApproval(w, r, "clear_queue", queueName, fmt.Sprintf("clear the %s queue", queueName)) {
+				return
+			}
+			count, err := q.Clear()
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
+			recordChange(r, server.ChangeQueueCleared, queueName, map[string]interface{}{"count": count})
 			http.Redirect(w, r, "/queues", http.StatusFound)
 			return
 		}
@@ -113,28 +215,24 @@ func retriesHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "POST" {
 		action := r.FormValue("action")
 		keys := r.Form["key"]
+		if deferBulk(w, r, action, keys, "retries") {
+			return
+		}
 		err := actOn(r, set, action, keys)
-		if err != nil {
+		if err == errForbidden {
+			http.Error(w, err.Error(), http.StatusForbidden)
+		} else if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		} else {
-			http.Redirect(w, r, "/retries", http.StatusFound)
+			http.Redirect(w, r, filteredPath(r, "/retries"), http.StatusFound)
 		}
 		return
 	}
 
-	currentPage := uint64(1)
-	p := r.URL.Query()["page"]
-	if p != nil {
-		val, err := strconv.Atoi(p[0])
-		if err != nil {
-			http.Error(w, "Invalid parameter", http.StatusBadRequest)
-			return
-		}
-		currentPage = uint64(val)
-	}
-	count := uint64(25)
+	cursor := r.URL.Query().Get("cursor")
+	count := 25
 
-	ego_listRetries(w, r, set, count, currentPage)
+	ego_listRetries(w, r, set, count, cursor)
 }
 
 func retryHandler(w http.ResponseWriter, r *http.Request) {
@@ -165,6 +263,10 @@ func retryHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if !can(r, job.Queue, canView) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 
 	if job.Failure == nil {
 		http.Error(w, fmt.Sprintf("Job %s is not a retry", job.Jid), http.StatusInternalServerError)
@@ -179,28 +281,24 @@ func scheduledHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "POST" {
 		action := r.FormValue("action")
 		keys := r.Form["key"]
+		if deferBulk(w, r, action, keys, "scheduled jobs") {
+			return
+		}
 		err := actOn(r, set, action, keys)
-		if err != nil {
+		if err == errForbidden {
+			http.Error(w, err.Error(), http.StatusForbidden)
+		} else if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		} else {
-			http.Redirect(w, r, "/scheduled", http.StatusFound)
+			http.Redirect(w, r, filteredPath(r, "/scheduled"), http.StatusFound)
 		}
 		return
 	}
 
-	currentPage := uint64(1)
-	p := r.URL.Query()["page"]
-	if p != nil {
-		val, err := strconv.Atoi(p[0])
-		if err != nil {
-			http.Error(w, "Invalid parameter", http.StatusBadRequest)
-			return
-		}
-		currentPage = uint64(val)
-	}
-	count := uint64(25)
+	cursor := r.URL.Query().Get("cursor")
+	count := 25
 
-	ego_listScheduled(w, r, set, count, currentPage)
+	ego_listScheduled(w, r, set, count, cursor)
 }
 
 func scheduledJobHandler(w http.ResponseWriter, r *http.Request) {
@@ -232,6 +330,10 @@ func scheduledJobHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if !can(r, job.Queue, canView) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 
 	ego_scheduled_job(w, r, key, job)
 }
@@ -242,28 +344,24 @@ func morgueHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "POST" {
 		action := r.FormValue("action")
 		keys := r.Form["key"]
+		if deferBulk(w, r, action, keys, "dead jobs") {
+			return
+		}
 		err := actOn(r, set, action, keys)
-		if err != nil {
+		if err == errForbidden {
+			http.Error(w, err.Error(), http.StatusForbidden)
+		} else if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		} else {
-			http.Redirect(w, r, "/morgue", http.StatusFound)
+			http.Redirect(w, r, filteredPath(r, "/morgue"), http.StatusFound)
 		}
 		return
 	}
 
-	currentPage := uint64(1)
-	p := r.URL.Query()["page"]
-	if p != nil {
-		val, err := strconv.Atoi(p[0])
-		if err != nil {
-			http.Error(w, "Invalid parameter", http.StatusBadRequest)
-			return
-		}
-		currentPage = uint64(val)
-	}
-	count := uint64(25)
+	cursor := r.URL.Query().Get("cursor")
+	count := 25
 
-	ego_listDead(w, r, set, count, currentPage)
+	ego_listDead(w, r, set, count, cursor)
 }
 
 func deadHandler(w http.ResponseWriter, r *http.Request) {
@@ -294,12 +392,20 @@ func deadHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if !can(r, job.Queue, canView) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 
 	ego_dead(w, r, key, job)
 }
 
 func busyHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "POST" {
+		if !admin(r) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
 		wid := r.FormValue("wid")
 		action := r.FormValue("signal")
 		if wid != "" {
@@ -325,6 +431,301 @@ func busyHandler(w http.ResponseWriter, r *http.Request) {
 	ego_busy(w, r)
 }
 
+const maxSamples = 100
+
+func samplesHandler(w http.ResponseWriter, r *http.Request) {
+	samples, err := ctx(r).Server().Samples(filterParam(r, "q"), maxSamples, func(smp *server.Sample) bool {
+		return can(r, smp.Job.Queue, canView)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ego_samples(w, r, samples)
+}
+
+func sampleHandler(w http.ResponseWriter, r *http.Request) {
+	name := LAST_ELEMENT.FindStringSubmatch(r.URL.Path)
+	if name == nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	smp, err := ctx(r).Server().Sample(name[1])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if smp == nil {
+		// the sample has expired
+		http.Redirect(w, r, "/samples", http.StatusTemporaryRedirect)
+		return
+	}
+	if !can(r, smp.Job.Queue, canView) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	ego_sample(w, r, smp)
+}
+
+// protocolHandler serves the protocol reference as Markdown,
+// or JSON with ?format=json
+func duplicatesHandler(w http.ResponseWriter, r *http.Request) {
+	if !ctx(r).Server().DuplicatesEnabled() {
+		http.Error(w, "Duplicates aren't tracked, see [duplicates] window", http.StatusNotFound)
+		return
+	}
+	ego_duplicates(w, r, ctx(r).Server().DuplicatesReport())
+}
+
+func protocolHandler(w http.ResponseWriter, r *http.Request) {
+	ref := server.Protocol()
+	if r.FormValue("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ref)
+		return
+	}
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	ref.WriteMarkdown(w)
+}
+
 func debugHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		if r.FormValue("action") == "backup" {
+			_, err := ctx(r).Server().Backup()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		http.Redirect(w, r, "/debug", http.StatusFound)
+		return
+	}
 	ego_debug(w, r)
 }
+
+// jobForm holds the fields of the new job form so
+// they can be shown again if they're invalid
+type jobForm struct {
+	Type  string
+	Queue string
+	Args  string
+	At    string
+	Error string
+}
+
+// the value of the datetime-local input, in UTC
+const atLayout = "2006-01-02T15:04"
+
+func (form *jobForm) job(now time.Time) (*client.Job, error) {
+	if form.Type == "" {
+		return nil, fmt.Errorf("A jobtype is required")
+	}
+
+	args, err := parseArgs(form.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	job := client.NewJob(form.Type, args...)
+	if form.Queue != "" {
+		job.Queue = form.Queue
+	}
+	if form.At != "" {
+		at, err := time.ParseInLocation(atLayout, form.At, time.UTC)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid time %q", form.At)
+		}
+		// a time in the past enqueues the job now
+		if at.After(now) {
+			job.At = util.Thens(at)
+		}
+	}
+	return job, nil
+}
+
+func parseArgs(data string) ([]interface{}, error) {
+	var args []interface{}
+	dec := json.NewDecoder(strings.NewReader(data))
+	// large integers, e.g. IDs, would lose precision as floats
+	dec.UseNumber()
+	err := dec.Decode(&args)
+	if err == nil && (args == nil || dec.More()) {
+		err = fmt.Errorf("expected a single array")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Arguments must be a JSON array: %v", err)
+	}
+	return args, nil
+}
+
+// pushedLocation is where to see a job pushed from the Web UI
+func pushedLocation(job *client.Job) string {
+	if job.At != "" {
+		return "/scheduled"
+	}
+	return "/queues/" + url.PathEscape(job.Queue)
+}
+
+// newJobHandler lets admins push a job by hand,
+// e.g. to rerun a report during an incident
+func newJobHandler(w http.ResponseWriter, r *http.Request) {
+	form := &jobForm{Queue: "default", Args: "[]"}
+	if r.Method == "POST" {
+		form = &jobForm{
+			Type:  strings.TrimSpace(r.FormValue("jobtype")),
+			Queue: strings.TrimSpace(r.FormValue("queue")),
+			Args:  r.FormValue("args"),
+			At:    r.FormValue("at"),
+		}
+		status := http.StatusBadRequest
+		job, err := form.job(time.Now())
+		if err == nil && deferForApproval(w, r, "push_job", job.Queue, fmt.Sprintf("push a %s job to %s", job.Type, job.Queue)) {
+			return
+		}
+		if err == nil {
+			status = http.StatusInternalServerError
+			err = ctx(r).Server().Manager().Push(job)
+		}
+		if err == nil {
+			util.Infof("Web UI pushed %s job %s to %s", job.Type, job.Jid, job.Queue)
+			http.Redirect(w, r, pushedLocation(job), http.StatusFound)
+			return
+		}
+		form.Error = err.Error()
+		w.WriteHeader(status)
+	}
+	ego_newJob(w, r, form)
+}
+
+// templateForm holds the fields of the template form so
+// they can be shown again if they're invalid
+type templateForm struct {
+	Name        string
+	Type        string
+	Queue       string
+	Args        string
+	Description string
+	Error       string
+}
+
+// templatesHandler lists the job templates and saves or deletes
+// them, ?name= fills in the form to edit a template
+func templatesHandler(w http.ResponseWriter, r *http.Request) {
+	srv := ctx(r).Server()
+	form := &templateForm{Args: "[]"}
+	status := http.StatusOK
+	if r.Method == "POST" {
+		name := strings.TrimSpace(r.FormValue("name"))
+		if r.FormValue("action") == "delete" {
+			err := srv.DeleteTemplate(name)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			util.Infof("Web UI deleted template %s", name)
+			http.Redirect(w, r, "/templates", http.StatusFound)
+			return
+		}
+
+		form = &templateForm{
+			Name:        name,
+			Type:        strings.TrimSpace(r.FormValue("jobtype")),
+			Queue:       strings.TrimSpace(r.FormValue("queue")),
+			Args:        r.FormValue("args"),
+			Description: strings.TrimSpace(r.FormValue("description")),
+		}
+		args, err := parseArgs(form.Args)
+		if err == nil {
+			err = srv.SaveTemplate(&server.JobTemplate{
+				Name:        form.Name,
+				Type:        form.Type,
+				Queue:       form.Queue,
+				Args:        args,
+				Description: form.Description,
+			})
+		}
+		if err == nil {
+			util.Infof("Web UI saved template %s", form.Name)
+			http.Redirect(w, r, "/templates", http.StatusFound)
+			return
+		}
+		form.Error = err.Error()
+		status = http.StatusBadRequest
+	} else if name := r.FormValue("name"); name != "" {
+		t, err := srv.Template(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if t != nil {
+			args, _ := json.Marshal(t.Args)
+			form = &templateForm{Name: t.Name, Type: t.Type, Queue: t.Queue, Args: string(args), Description: t.Description}
+		}
+	}
+
+	list, err := srv.Templates()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+	}
+	ego_templates(w, r, list, form)
+}
+
+// templatePush holds the parameters entered for a
+// template so they can be shown again on error
+type templatePush struct {
+	Template *server.JobTemplate
+	Values   map[string]string
+	Error    string
+}
+
+// templateHandler pushes a job from the template with the
+// parameters entered, the defaults are filled in to start
+func templateHandler(w http.ResponseWriter, r *http.Request) {
+	name := LAST_ELEMENT.FindStringSubmatch(r.URL.Path)
+	if name == nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	t, err := ctx(r).Server().Template(name[1])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if t == nil {
+		http.Error(w, "No such template", http.StatusNotFound)
+		return
+	}
+
+	form := &templatePush{Template: t, Values: map[string]string{}}
+	for _, param := range t.Params() {
+		form.Values[param.Name] = param.Default
+	}
+	if r.Method == "POST" {
+		for pname := range form.Values {
+			form.Values[pname] = r.FormValue("param-" + pname)
+		}
+		status := http.StatusBadRequest
+		job, err := t.Job(form.Values)
+		if err == nil && deferForApproval(w, r, "push_template", job.Queue, fmt.Sprintf("push %s from template %s to %s", job.Type, t.Name, job.Queue)) {
+			return
+		}
+		if err == nil {
+			status = http.StatusInternalServerError
+			err = ctx(r).Server().Manager().Push(job)
+		}
+		if err == nil {
+			util.Infof("Web UI pushed %s job %s from template %s", job.Type, job.Jid, t.Name)
+			http.Redirect(w, r, pushedLocation(job), http.StatusFound)
+			return
+		}
+		form.Error = err.Error()
+		w.WriteHeader(status)
+	}
+	ego_template(w, r, form)
+}
@@ -1,6 +1,7 @@
 package webui
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -12,6 +13,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/contribsys/faktory/client"
 	"github.com/contribsys/faktory/server"
 	"github.com/contribsys/faktory/storage"
 	"github.com/contribsys/faktory/util"
@@ -30,6 +32,138 @@ func TestPages(t *testing.T) {
 			assert.Equal(t, 200, w.Code)
 			assert.True(t, strings.Contains(w.Body.String(), "uptime_in_days"), w.Body.String())
 			assert.True(t, strings.Contains(w.Body.String(), "idle"), w.Body.String())
+			assert.True(t, strings.Contains(w.Body.String(), "Processed by Hour"), w.Body.String())
+		})
+
+		t.Run("Heatmap", func(t *testing.T) {
+			s.Store().Flush()
+			for i := 0; i < 5; i++ {
+				assert.NoError(t, s.Store().Success())
+			}
+			req, err := ui.NewRequest("GET", "http://localhost:7420/?days=7", nil)
+			assert.NoError(t, err)
+
+			heat := throughputHeatmap(req)
+			now := time.Now().UTC()
+			row := (int(now.Weekday()) + 6) % 7
+			assert.Equal(t, 7, heat.Days)
+			assert.EqualValues(t, 5, heat.Cells[row][now.Hour()])
+			assert.EqualValues(t, 5, heat.Max)
+			assert.Equal(t, "1.00", heat.Shade(row, now.Hour()))
+			assert.Equal(t, "Mon", heat.Weekday(0))
+			assert.Equal(t, "Sun", heat.Weekday(6))
+		})
+
+		t.Run("Protocol", func(t *testing.T) {
+			req, err := ui.NewRequest("GET", "http://localhost:7420/protocol", nil)
+			assert.NoError(t, err)
+			w := httptest.NewRecorder()
+			protocolHandler(w, req)
+			assert.Equal(t, 200, w.Code)
+			assert.Contains(t, w.Body.String(), "### `PUSH`")
+
+			req, err = ui.NewRequest("GET", "http://localhost:7420/protocol?format=json", nil)
+			assert.NoError(t, err)
+			w = httptest.NewRecorder()
+			protocolHandler(w, req)
+			var ref server.ProtocolReference
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &ref))
+			assert.Equal(t, 2, ref.Version)
+			assert.NotEmpty(t, ref.Commands)
+		})
+
+		t.Run("NewJob", func(t *testing.T) {
+			req, err := ui.NewRequest("GET", "http://localhost:7420/jobs/new", nil)
+			assert.NoError(t, err)
+			w := httptest.NewRecorder()
+			newJobHandler(w, req)
+			assert.Equal(t, 200, w.Code)
+			assert.Contains(t, w.Body.String(), `name="args"`)
+
+			post := func(payload url.Values) *httptest.ResponseRecorder {
+				req, err := ui.NewRequest("POST", "http://localhost:7420/jobs/new", strings.NewReader(payload.Encode()))
+				assert.NoError(t, err)
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				w := httptest.NewRecorder()
+				newJobHandler(w, req)
+				return w
+			}
+
+			q, err := s.Store().GetQueue("manual")
+			assert.NoError(t, err)
+			q.Clear()
+			w = post(url.Values{"jobtype": {"Report"}, "queue": {"manual"}, "args": {`[12345678901234567890, "x"]`}})
+			assert.Equal(t, 302, w.Code)
+			assert.Equal(t, "/queues/manual", w.Header().Get("Location"))
+			assert.EqualValues(t, 1, q.Size())
+			data, err := q.Pop()
+			assert.NoError(t, err)
+			assert.Contains(t, string(data), `"args":[12345678901234567890,"x"]`)
+
+			scheduled := s.Store().Scheduled().Size()
+			w = post(url.Values{"jobtype": {"Report"}, "args": {"[]"}, "at": {time.Now().UTC().Add(time.Hour).Format(atLayout)}})
+			assert.Equal(t, 302, w.Code)
+			assert.Equal(t, "/scheduled", w.Header().Get("Location"))
+			assert.EqualValues(t, scheduled+1, s.Store().Scheduled().Size())
+
+			// invalid fields are shown again with the error
+			for _, args := range []string{"{}", "[1] [2]", "[1,", "null"} {
+				w = post(url.Values{"jobtype": {"Report"}, "args": {args}})
+				assert.Equal(t, 400, w.Code)
+				assert.Contains(t, w.Body.String(), "Arguments must be a JSON array")
+			}
+			w = post(url.Values{"queue": {"manual"}, "args": {"[]"}})
+			assert.Equal(t, 400, w.Code)
+			assert.Contains(t, w.Body.String(), "A jobtype is required")
+			assert.Contains(t, w.Body.String(), `value="manual"`)
+			assert.EqualValues(t, 0, q.Size())
+		})
+
+		t.Run("Templates", func(t *testing.T) {
+			handle := func(h http.HandlerFunc, method, path string, payload url.Values) *httptest.ResponseRecorder {
+				req, err := ui.NewRequest(method, "http://localhost:7420"+path, strings.NewReader(payload.Encode()))
+				assert.NoError(t, err)
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				w := httptest.NewRecorder()
+				h(w, req)
+				return w
+			}
+
+			w := handle(templatesHandler, "POST", "/templates", url.Values{"name": {"reindex"}, "jobtype": {"Reindex"},
+				"queue": {"manual"}, "args": {`["{{index}}", "{{batch|500}}"]`}, "description": {"Rebuild an index"}})
+			assert.Equal(t, 302, w.Code)
+			w = handle(templatesHandler, "POST", "/templates", url.Values{"name": {"bad name"}, "jobtype": {"Reindex"}, "args": {"[]"}})
+			assert.Equal(t, 400, w.Code)
+			assert.Contains(t, w.Body.String(), "Template name must be")
+
+			w = handle(templatesHandler, "GET", "/templates?name=reindex", nil)
+			assert.Equal(t, 200, w.Code)
+			assert.Contains(t, w.Body.String(), "Rebuild an index")
+			assert.Contains(t, w.Body.String(), `value="Reindex"`)
+
+			w = handle(templateHandler, "GET", "/templates/reindex", nil)
+			assert.Equal(t, 200, w.Code)
+			assert.Contains(t, w.Body.String(), `name="param-index" value="" required`)
+			assert.Contains(t, w.Body.String(), `name="param-batch" value="500"`)
+
+			q, err := s.Store().GetQueue("manual")
+			assert.NoError(t, err)
+			q.Clear()
+			w = handle(templateHandler, "POST", "/templates/reindex", url.Values{"param-index": {"users"}, "param-batch": {"100"}})
+			assert.Equal(t, 302, w.Code)
+			assert.Equal(t, "/queues/manual", w.Header().Get("Location"))
+			data, err := q.Pop()
+			assert.NoError(t, err)
+			assert.Contains(t, string(data), `"args":["users",100]`)
+
+			w = handle(templateHandler, "GET", "/templates/nope", nil)
+			assert.Equal(t, 404, w.Code)
+
+			w = handle(templatesHandler, "POST", "/templates", url.Values{"name": {"reindex"}, "action": {"delete"}})
+			assert.Equal(t, 302, w.Code)
+			w = handle(templatesHandler, "GET", "/templates", nil)
+			assert.Equal(t, 200, w.Code)
+			assert.Contains(t, w.Body.String(), "No job templates have been saved")
 		})
 
 		t.Run("Stats", func(t *testing.T) {
@@ -113,6 +247,78 @@ func TestPages(t *testing.T) {
 			assert.Equal(t, 302, w.Code)
 		})
 
+		t.Run("QueueDashboard", func(t *testing.T) {
+			req, err := ui.NewRequest("GET", "http://localhost:7420/dashboards/foobar", nil)
+			assert.NoError(t, err)
+			w := httptest.NewRecorder()
+			queueDashboardHandler(w, req)
+			assert.Equal(t, 404, w.Code)
+
+			metrics := server.MetricsSubsystem()
+			s.Register(metrics)
+			assert.NoError(t, metrics.Start(s))
+			assert.NoError(t, s.Manager().Push(client.NewJob("SomeWorker", 1)))
+			assert.NoError(t, s.AddMarker(&server.Marker{Kind: "deploy", Label: "v1.2<3"}))
+
+			req, err = ui.NewRequest("GET", "http://localhost:7420/dashboards/default?period=6h", nil)
+			assert.NoError(t, err)
+			w = httptest.NewRecorder()
+			queueDashboardHandler(w, req)
+			assert.Equal(t, 200, w.Code)
+			assert.True(t, strings.Contains(w.Body.String(), "sparkline"), w.Body.String())
+			assert.True(t, strings.Contains(w.Body.String(), "SomeWorker"), w.Body.String())
+			assert.True(t, strings.Contains(w.Body.String(), `class="marker deploy"`), w.Body.String())
+			assert.True(t, strings.Contains(w.Body.String(), "v1.2&lt;3"), w.Body.String())
+			assert.True(t, strings.Contains(w.Body.String(), "Not draining"), w.Body.String())
+
+			req, err = ui.NewRequest("GET", "http://localhost:7420/queues", nil)
+			assert.NoError(t, err)
+			w = httptest.NewRecorder()
+			queuesHandler(w, req)
+			assert.Equal(t, 200, w.Code)
+			assert.True(t, strings.Contains(w.Body.String(), "Drains In"), w.Body.String())
+		})
+
+		t.Run("Samples", func(t *testing.T) {
+			s.Options.GlobalConfig = map[string]interface{}{
+				"sampling": map[string]interface{}{"rate": 1.0},
+			}
+			defer func() { s.Options.GlobalConfig = nil }()
+			sampling := server.SamplingSubsystem()
+			s.Register(sampling)
+			assert.NoError(t, sampling.Start(s))
+
+			job := client.NewJob("SampledWorker", "acct_123")
+			job.Queue = "sampled"
+			assert.NoError(t, s.Manager().Push(job))
+			fetched, err := s.Manager().Fetch(context.Background(), "", "sampled")
+			assert.NoError(t, err)
+			_, err = s.Manager().Acknowledge(fetched.Jid)
+			assert.NoError(t, err)
+
+			req, err := ui.NewRequest("GET", "http://localhost:7420/samples?q=acct_123", nil)
+			assert.NoError(t, err)
+			w := httptest.NewRecorder()
+			samplesHandler(w, req)
+			assert.Equal(t, 200, w.Code)
+			assert.True(t, strings.Contains(w.Body.String(), "SampledWorker"), w.Body.String())
+			assert.True(t, strings.Contains(w.Body.String(), "Acknowledged"), w.Body.String())
+			assert.True(t, strings.Contains(w.Body.String(), `href="/samples"`), w.Body.String())
+
+			req, err = ui.NewRequest("GET", "http://localhost:7420/samples/"+job.Jid, nil)
+			assert.NoError(t, err)
+			w = httptest.NewRecorder()
+			sampleHandler(w, req)
+			assert.Equal(t, 200, w.Code)
+			assert.True(t, strings.Contains(w.Body.String(), "&#34;acct_123&#34;"), w.Body.String())
+
+			req, err = ui.NewRequest("GET", "http://localhost:7420/samples/nope", nil)
+			assert.NoError(t, err)
+			w = httptest.NewRecorder()
+			sampleHandler(w, req)
+			assert.Equal(t, 307, w.Code)
+		})
+
 		t.Run("Retries", func(t *testing.T) {
 			s.Store().Flush()
 			req, err := ui.NewRequest("GET", "http://localhost:7420/retries", nil)
@@ -235,6 +441,27 @@ func TestPages(t *testing.T) {
 			retryHandler(w, req)
 			assert.Equal(t, 200, w.Code)
 			assert.True(t, strings.Contains(w.Body.String(), jid), w.Body.String())
+			assert.False(t, strings.Contains(w.Body.String(), "Attempts"), w.Body.String())
+			assert.False(t, strings.Contains(w.Body.String(), "Lineage"), w.Body.String())
+
+			var job client.Job
+			err = json.Unmarshal(data, &job)
+			assert.NoError(t, err)
+			job.Failure.RetryCount = 1
+			job.Failure.Attempts = []*client.Attempt{
+				{FailedAt: ts, ErrorType: "RuntimeError", ErrorMessage: "Invalid argument", Backtrace: []string{"worker.go:12"}},
+				{FailedAt: ts, ErrorType: "RuntimeError", ErrorMessage: "Invalid argument", Backtrace: []string{"worker.go:14"}},
+			}
+			data, err = json.Marshal(&job)
+			assert.NoError(t, err)
+			q.Clear()
+			err = q.AddElement(ts, jid, data)
+			assert.NoError(t, err)
+
+			w = httptest.NewRecorder()
+			retryHandler(w, req)
+			assert.Equal(t, 200, w.Code)
+			assert.True(t, strings.Contains(w.Body.String(), `<span class="added">+ worker.go:14</span>`), w.Body.String())
 		})
 
 		t.Run("Scheduled", func(t *testing.T) {
@@ -312,6 +539,139 @@ func TestPages(t *testing.T) {
 			morgueHandler(w, req)
 			assert.Equal(t, 200, w.Code)
 			assert.True(t, strings.Contains(w.Body.String(), jid), w.Body.String())
+
+			req, err = ui.NewRequest("GET", "http://localhost:7420/morgue?queue=billing", nil)
+			assert.NoError(t, err)
+			w = httptest.NewRecorder()
+			morgueHandler(w, req)
+			assert.Equal(t, 200, w.Code)
+			assert.False(t, strings.Contains(w.Body.String(), jid), w.Body.String())
+
+			// the job failed before the deploy
+			err = s.AddMarker(&server.Marker{At: time.Now(), Kind: "deploy", Label: "v2"})
+			assert.NoError(t, err)
+			req, err = ui.NewRequest("GET", "http://localhost:7420/morgue?since=deploy", nil)
+			assert.NoError(t, err)
+			w = httptest.NewRecorder()
+			morgueHandler(w, req)
+			assert.Equal(t, 200, w.Code)
+			assert.False(t, strings.Contains(w.Body.String(), jid), w.Body.String())
+			assert.True(t, strings.Contains(w.Body.String(), `value="deploy" selected`), w.Body.String())
+		})
+
+		t.Run("GraphQL", func(t *testing.T) {
+			str := s.Store()
+			str.Dead().Clear()
+			jid, data := fakeJob()
+			err := str.Dead().AddElement(util.Nows(), jid, data)
+			assert.NoError(t, err)
+			q, err := str.GetQueue("graphql")
+			assert.NoError(t, err)
+			_, err = q.Clear()
+			assert.NoError(t, err)
+			err = q.Push(data)
+			assert.NoError(t, err)
+
+			body := `{"query": "query Q($n: Int) { stats { version } queue(name: \"graphql\") { name size jobs(first: $n) { jid } } dead { size jobs { jid failure { errtype } } } }", "variables": {"n": 1}}`
+			req, err := ui.NewRequest("POST", "http://localhost:7420/graphql", strings.NewReader(body))
+			assert.NoError(t, err)
+			w := httptest.NewRecorder()
+			graphqlHandler(w, req)
+			assert.Equal(t, 200, w.Code)
+			expected := fmt.Sprintf(`{"data":{"stats":{"version":%q},"queue":{"name":"graphql","size":1,"jobs":[{"jid":%q}]},"dead":{"size":1,"jobs":[{"jid":%q,"failure":{"errtype":"RuntimeError"}}]}}}`, client.Version, jid, jid)
+			assert.Equal(t, expected, w.Body.String())
+
+			req, err = ui.NewRequest("GET", "http://localhost:7420/graphql?query="+url.QueryEscape("{ workers { wid } dead(first: 1000) { size } }"), nil)
+			assert.NoError(t, err)
+			w = httptest.NewRecorder()
+			graphqlHandler(w, req)
+			assert.Equal(t, 200, w.Code)
+			assert.Contains(t, w.Body.String(), `"dead":null`)
+			assert.Contains(t, w.Body.String(), `first must be between 1 and 100`)
+
+			req, err = ui.NewRequest("GET", "http://localhost:7420/graphql?query="+url.QueryEscape("{ jobs }"), nil)
+			assert.NoError(t, err)
+			w = httptest.NewRecorder()
+			graphqlHandler(w, req)
+			assert.Equal(t, 400, w.Code)
+
+			req, err = ui.NewRequest("POST", "http://localhost:7420/queues/graphql", strings.NewReader(""))
+			assert.NoError(t, err)
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w = httptest.NewRecorder()
+			queueHandler(w, req)
+			assert.Equal(t, 302, w.Code)
+
+			req, err = ui.NewRequest("GET", "http://localhost:7420/graphql?query="+url.QueryEscape("{ duplicates { windowSeconds } }"), nil)
+			assert.NoError(t, err)
+			w = httptest.NewRecorder()
+			graphqlHandler(w, req)
+			assert.Equal(t, `{"data":{"duplicates":null}}`, w.Body.String())
+
+			req, err = ui.NewRequest("GET", "http://localhost:7420/graphql?query="+url.QueryEscape("{ usage(hours: 0) { value } }"), nil)
+			assert.NoError(t, err)
+			w = httptest.NewRecorder()
+			graphqlHandler(w, req)
+			assert.Contains(t, w.Body.String(), `hours must be at least 1`)
+
+			req, err = ui.NewRequest("GET", "http://localhost:7420/graphql?query="+url.QueryEscape(`{ changes(after: "bogus") { next } }`), nil)
+			assert.NoError(t, err)
+			w = httptest.NewRecorder()
+			graphqlHandler(w, req)
+			assert.Contains(t, w.Body.String(), `Invalid changes cursor`)
+
+			req, err = ui.NewRequest("GET", "http://localhost:7420/graphql?query="+url.QueryEscape("{ changes(first: 100) { truncated changes { kind subject detail } } }"), nil)
+			assert.NoError(t, err)
+			w = httptest.NewRecorder()
+			graphqlHandler(w, req)
+			assert.Equal(t, 200, w.Code)
+			assert.Contains(t, w.Body.String(), `"truncated":false`)
+			assert.Contains(t, w.Body.String(), `{"kind":"queue.cleared","subject":"graphql","detail":{"count":1}}`)
+		})
+
+		t.Run("SavedFilters", func(t *testing.T) {
+			payload := url.Values{
+				"action": {"save"},
+				"name":   {"billing deploy"},
+				"path":   {"/morgue"},
+				"queue":  {"billing"},
+				"since":  {"deploy"},
+			}
+			req, err := ui.NewRequest("POST", "http://localhost:7420/filters", strings.NewReader(payload.Encode()))
+			assert.NoError(t, err)
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			filtersHandler(w, req)
+			assert.Equal(t, 302, w.Code)
+			assert.Equal(t, "/morgue?queue=billing&since=deploy", w.Header().Get("Location"))
+			cookies := w.Result().Cookies()
+			assert.Equal(t, 1, len(cookies))
+
+			req, err = ui.NewRequest("GET", "http://localhost:7420/retries", nil)
+			assert.NoError(t, err)
+			req.AddCookie(cookies[0])
+			w = httptest.NewRecorder()
+			retriesHandler(w, req)
+			assert.Equal(t, 200, w.Code)
+			assert.True(t, strings.Contains(w.Body.String(), `href="/morgue?queue=billing&amp;since=deploy">billing deploy</a>`), w.Body.String())
+
+			payload.Set("action", "delete")
+			req, err = ui.NewRequest("POST", "http://localhost:7420/filters", strings.NewReader(payload.Encode()))
+			assert.NoError(t, err)
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.AddCookie(cookies[0])
+			w = httptest.NewRecorder()
+			filtersHandler(w, req)
+			assert.Equal(t, 302, w.Code)
+			assert.Equal(t, "", w.Result().Cookies()[0].Value)
+
+			payload.Set("path", "http://example.com/")
+			req, err = ui.NewRequest("POST", "http://localhost:7420/filters", strings.NewReader(payload.Encode()))
+			assert.NoError(t, err)
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w = httptest.NewRecorder()
+			filtersHandler(w, req)
+			assert.Equal(t, 400, w.Code)
 		})
 
 		t.Run("Dead", func(t *testing.T) {
@@ -345,6 +705,28 @@ func TestPages(t *testing.T) {
 			assert.Equal(t, 302, w.Code)
 			assert.Equal(t, "", w.Body.String())
 			assert.EqualValues(t, 0, q.Size())
+
+			for i := 0; i < 3; i++ {
+				jid, data = fakeJob()
+				err = q.AddElement(util.Nows(), jid, data)
+				assert.NoError(t, err)
+			}
+			payload = url.Values{
+				"key":    {"matching"},
+				"action": {"delete"},
+			}
+			// only the jobs matching the filter are deleted
+			remaining := map[string]int{"OtherWorker": 3, "SomeWorker": 0}
+			for _, jobtype := range []string{"OtherWorker", "SomeWorker"} {
+				req, err = ui.NewRequest("POST", "http://localhost:7420/morgue?jobtype="+jobtype, strings.NewReader(payload.Encode()))
+				assert.NoError(t, err)
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				w = httptest.NewRecorder()
+				morgueHandler(w, req)
+				assert.Equal(t, 302, w.Code)
+				assert.Equal(t, "/morgue?jobtype="+jobtype, w.Header().Get("Location"))
+				assert.EqualValues(t, remaining[jobtype], q.Size())
+			}
 		})
 
 		t.Run("Busy", func(t *testing.T) {
@@ -370,6 +752,22 @@ func TestPages(t *testing.T) {
 			assert.True(t, strings.Contains(w.Body.String(), "bubba"), w.Body.String())
 			assert.False(t, wrk.IsQuiet())
 
+			other := &server.ClientData{
+				Hostname:  "foobar.local",
+				Pid:       12346,
+				Wid:       "other",
+				StartedAt: time.Now(),
+				Version:   2,
+			}
+			s.Heartbeats()[other.Wid] = other
+			hosts := busyHosts(req)
+			assert.Equal(t, 1, len(hosts))
+			assert.Equal(t, "foobar.local", hosts[0].Hostname)
+			assert.Equal(t, 2, len(hosts[0].Processes))
+			assert.Equal(t, wid, hosts[0].Processes[0].Wid)
+			assert.True(t, hosts[0].Processes[0].Connected)
+			delete(s.Heartbeats(), other.Wid)
+
 			data := url.Values{
 				"signal": {"quiet"},
 				"wid":    {wid},
@@ -383,6 +781,116 @@ func TestPages(t *testing.T) {
 			assert.True(t, wrk.IsQuiet())
 		})
 
+		t.Run("Permissions", func(t *testing.T) {
+			users, err := parseUsers(map[string]interface{}{
+				"groups": map[string]interface{}{
+					"payments": map[string]interface{}{
+						"queues": []interface{}{"payments", "billing_*"},
+						"rights": []interface{}{"retry"},
+					},
+					"admins": map[string]interface{}{
+						"queues": []interface{}{"*"},
+						"rights": []interface{}{"view", "retry", "delete"},
+					},
+				},
+				"users": map[string]interface{}{
+					"alice": map[string]interface{}{"password": "secret", "groups": []interface{}{"payments"}},
+					"bob":   map[string]interface{}{"password": "hunter2", "groups": []interface{}{"admins"}},
+				},
+			})
+			assert.NoError(t, err)
+			assert.Nil(t, users["bob"].access)
+			alice := users["alice"].access
+			assert.True(t, alice.can("payments", canView|canRetry))
+			assert.True(t, alice.can("billing_eu", canRetry))
+			assert.False(t, alice.can("payments", canDelete))
+			assert.False(t, alice.can("default", canView))
+
+			_, err = parseUsers(map[string]interface{}{
+				"users": map[string]interface{}{"carol": map[string]interface{}{"password": "x", "groups": []interface{}{"nope"}}},
+			})
+			assert.EqualError(t, err, "Unknown group nope for web user carol")
+
+			ui.setUsers(users)
+			defer ui.setUsers(nil)
+			for user, code := range map[string]int{"alice:secret": 200, "bob:hunter2": 200, "alice:hunter2": 401, ":": 401} {
+				req := httptest.NewRequest("GET", "http://localhost:7420/stats", nil)
+				creds := strings.SplitN(user, ":", 2)
+				req.SetBasicAuth(creds[0], creds[1])
+				w := httptest.NewRecorder()
+				setup(ui, statsHandler, false)(w, req)
+				assert.Equal(t, code, w.Code, user)
+			}
+
+			limited := func(method, path string, body io.Reader) *http.Request {
+				req, err := ui.NewRequest(method, "http://localhost:7420"+path, body)
+				assert.NoError(t, err)
+				ctx(req).Context = context.WithValue(ctx(req).Context, accessKey{}, alice)
+				if body != nil {
+					req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				}
+				return req
+			}
+
+			s.Store().Flush()
+			retries := s.Store().Retries()
+			jid1, data := fakeJob()
+			assert.NoError(t, retries.AddElement(util.Nows(), jid1, data))
+			jid2, data := fakeJob()
+			data = []byte(strings.Replace(string(data), `"queue":"default"`, `"queue":"payments"`, 1))
+			assert.NoError(t, retries.AddElement(util.Nows(), jid2, data))
+
+			w := httptest.NewRecorder()
+			retriesHandler(w, limited("GET", "/retries", nil))
+			assert.Equal(t, 200, w.Code)
+			assert.False(t, strings.Contains(w.Body.String(), jid1), w.Body.String())
+			assert.True(t, strings.Contains(w.Body.String(), jid2), w.Body.String())
+
+			keys := map[string]string{}
+			retries.Each(func(_ int, entry storage.SortedEntry) error {
+				key, err := entry.Key()
+				job, _ := entry.Job()
+				keys[job.Jid] = string(key)
+				return err
+			})
+
+			payload := url.Values{"key": {keys[jid1]}, "action": {"delete"}}
+			w = httptest.NewRecorder()
+			retriesHandler(w, limited("POST", "/retries", strings.NewReader(payload.Encode())))
+			assert.Equal(t, 403, w.Code)
+			payload = url.Values{"key": {keys[jid2]}, "action": {"delete"}}
+			w = httptest.NewRecorder()
+			retriesHandler(w, limited("POST", "/retries", strings.NewReader(payload.Encode())))
+			assert.Equal(t, 403, w.Code)
+			assert.EqualValues(t, 2, retries.Size())
+
+			payload = url.Values{"key": {"all"}, "action": {"retry"}}
+			w = httptest.NewRecorder()
+			retriesHandler(w, limited("POST", "/retries", strings.NewReader(payload.Encode())))
+			assert.Equal(t, 302, w.Code)
+			assert.EqualValues(t, 1, retries.Size())
+			q, _ := s.Store().GetQueue("payments")
+			assert.EqualValues(t, 1, q.Size())
+
+			w = httptest.NewRecorder()
+			retryHandler(w, limited("GET", "/retries/"+keys[jid1], nil))
+			assert.Equal(t, 403, w.Code)
+			w = httptest.NewRecorder()
+			queueHandler(w, limited("GET", "/queues/default", nil))
+			assert.Equal(t, 403, w.Code)
+			w = httptest.NewRecorder()
+			queueHandler(w, limited("POST", "/queues/payments", strings.NewReader("action=delete")))
+			assert.Equal(t, 403, w.Code)
+			w = httptest.NewRecorder()
+			AdminOnly(debugHandler)(w, limited("GET", "/debug", nil))
+			assert.Equal(t, 403, w.Code)
+
+			w = httptest.NewRecorder()
+			statsHandler(w, limited("GET", "/stats", nil))
+			assert.Equal(t, 200, w.Code)
+			assert.True(t, strings.Contains(w.Body.String(), `"queues":{"payments":1}`), w.Body.String())
+		})
+
 		t.Run("RequireCSRF", func(t *testing.T) {
 			req, err := ui.NewRequest("GET", "http://localhost:7420/busy", nil)
 			assert.NoError(t, err)
@@ -0,0 +1,221 @@
+package webui
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"path"
+)
+
+/*
+ * Users may be limited to some of the queues in the Web UI:
+ *
+ * [web.groups.payments]
+ * queues = ["payments", "billing_*"]  # glob patterns
+ * rights = ["view", "retry", "delete"] # default is view only
+ *
+ * [web.users.alice]
+ * password = "..."
+ * groups = ["payments"]
+ *
+ * Users sign in with HTTP Basic auth as their user name.  The [web]
+ * password signs in with access to everything, as does any group with
+ * every right on queues = ["*"].  Limited users only see the jobs and
+ * workers' jobs in their queues and can't use the GraphQL API, the
+ * Debug page or act on whole sets or workers.
+ */
+
+type right uint8
+
+const (
+	canView right = 1 << iota
+	canRetry
+	canDelete
+	allRights = canView | canRetry | canDelete
+)
+
+var rightNames = map[string]right{
+	"view":   canView,
+	"retry":  canRetry,
+	"delete": canDelete,
+}
+
+type grant struct {
+	pattern string
+	rights  right
+}
+
+// queueAccess limits a user to some queues, nil allows everything.
+type queueAccess struct {
+	user   string
+	grants []grant
+}
+
+func (a *queueAccess) can(queue string, r right) bool {
+	if a == nil {
+		return true
+	}
+	for _, g := range a.grants {
+		if g.rights&r != r {
+			continue
+		}
+		if ok, _ := path.Match(g.pattern, queue); ok {
+			return true
+		}
+	}
+	return false
+}
+
+type webUser struct {
+	password string
+	access   *queueAccess
+}
+
+func stringList(val interface{}) ([]string, bool) {
+	list, ok := val.([]interface{})
+	if !ok {
+		return nil, val == nil
+	}
+	strs := make([]string, len(list))
+	for idx, item := range list {
+		strs[idx], ok = item.(string)
+		if !ok {
+			return nil, false
+		}
+	}
+	return strs, true
+}
+
+func parseUsers(config interface{}) (map[string]*webUser, error) {
+	users := map[string]*webUser{}
+	web, _ := config.(map[string]interface{})
+	if web["users"] == nil {
+		return users, nil
+	}
+
+	groups := map[string][]grant{}
+	groupConfig, ok := web["groups"].(map[string]interface{})
+	if !ok && web["groups"] != nil {
+		return nil, fmt.Errorf("Invalid web groups configuration")
+	}
+	for name, val := range groupConfig {
+		group, _ := val.(map[string]interface{})
+		queues, ok := stringList(group["queues"])
+		if !ok || len(queues) == 0 {
+			return nil, fmt.Errorf("Web group %s must list its queues", name)
+		}
+		rights := canView
+		names, ok := stringList(group["rights"])
+		if !ok {
+			return nil, fmt.Errorf("Invalid rights for web group %s", name)
+		}
+		for _, rname := range names {
+			r, ok := rightNames[rname]
+			if !ok {
+				return nil, fmt.Errorf("Unknown right %q for web group %s, expected view, retry or delete", rname, name)
+			}
+			rights |= r
+		}
+		for _, queue := range queues {
+			if _, err := path.Match(queue, ""); err != nil {
+				return nil, fmt.Errorf("Invalid queue pattern %q for web group %s", queue, name)
+			}
+			groups[name] = append(groups[name], grant{queue, rights})
+		}
+	}
+
+	userConfig, ok := web["users"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Invalid web users configuration")
+	}
+	for name, val := range userConfig {
+		cfg, _ := val.(map[string]interface{})
+		password, _ := cfg["password"].(string)
+		if password == "" {
+			return nil, fmt.Errorf("Web user %s must have a password", name)
+		}
+		names, ok := stringList(cfg["groups"])
+		if !ok {
+			return nil, fmt.Errorf("Invalid groups for web user %s", name)
+		}
+
+		access := &queueAccess{user: name}
+		for _, gname := range names {
+			grants, ok := groups[gname]
+			if !ok {
+				return nil, fmt.Errorf("Unknown group %s for web user %s", gname, name)
+			}
+			for _, g := range grants {
+				if g.pattern == "*" && g.rights == allRights {
+					access = nil
+					break
+				}
+				access.grants = append(access.grants, g)
+			}
+			if access == nil {
+				break
+			}
+		}
+		users[name] = &webUser{password: password, access: access}
+	}
+	return users, nil
+}
+
+func (ui *WebUI) setUsers(users map[string]*webUser) {
+	ui.mu.Lock()
+	ui.users = users
+	ui.mu.Unlock()
+}
+
+func (ui *WebUI) webUsers() map[string]*webUser {
+	ui.mu.RLock()
+	defer ui.mu.RUnlock()
+	return ui.users
+}
+
+type accessKey struct{}
+
+// login checks the request's credentials, returning the user's access.
+func (ui *WebUI) login(r *http.Request) (*queueAccess, error) {
+	users := ui.webUsers()
+	if ui.Options.Password == "" && len(users) == 0 {
+		return nil, nil
+	}
+
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, fmt.Errorf("Authorization required")
+	}
+	if ui.Options.Password != "" && subtle.ConstantTimeCompare([]byte(password), []byte(ui.Options.Password)) == 1 {
+		return nil, nil
+	}
+	if user, ok := users[name]; ok && subtle.ConstantTimeCompare([]byte(password), []byte(user.password)) == 1 {
+		return user.access, nil
+	}
+	return nil, fmt.Errorf("Authorization failed")
+}
+
+func (d *DefaultContext) access() *queueAccess {
+	access, _ := d.Value(accessKey{}).(*queueAccess)
+	return access
+}
+
+func can(req *http.Request, queue string, r right) bool {
+	return ctx(req).access().can(queue, r)
+}
+
+// admin reports whether the user may see and act on every queue.
+func admin(req *http.Request) bool {
+	return ctx(req).access() == nil
+}
+
+// AdminOnly rejects users limited to some queues.
+func AdminOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !admin(r) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
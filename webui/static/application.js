@@ -26,7 +26,73 @@ $(function() {
   }
 
   $(document).on('click', '.check_all', function() {
-    $('input[type=checkbox]', $(this).closest('table')).prop('checked', $(this).is(':checked'));
+    var $table = $(this).closest('table');
+    var checked = $(this).is(':checked');
+    $('input[type=checkbox]', $table).prop('checked', checked);
+    selectMatching($table.closest('form'), false);
+    $('.select-matching', $table.closest('form')).toggle(checked);
+  });
+
+  // shift-click checks or unchecks every row since the last click
+  $(document).on('click', 'table.bulk input[name=key]', function(e) {
+    checkRange(this, e.shiftKey);
+  });
+
+  $(document).on('click', '[data-select]', function(e) {
+    e.preventDefault();
+    var $form = $(this).closest('form');
+    if ($(this).data('select') == 'matching') {
+      selectMatching($form, true);
+    } else {
+      selectMatching($form, false);
+      $('input[type=checkbox]', $form).prop('checked', false);
+      $('.select-matching', $form).hide();
+    }
+  });
+
+  // j/k move between rows, x checks the row (shift+x a range),
+  // o opens the job and / jumps to the filter
+  $(document).on('keydown', function(e) {
+    if (e.ctrlKey || e.metaKey || e.altKey || $(e.target).is('input, select, textarea, button')) {
+      return;
+    }
+    var $rows = $('table.bulk tr').has('input[name=key]');
+    if ($rows.length == 0) {
+      return;
+    }
+    var $current = $rows.filter('.current');
+    var idx = $rows.index($current);
+
+    switch (e.key) {
+    case 'j':
+    case 'k':
+      idx = e.key == 'j' ? Math.min(idx + 1, $rows.length - 1) : Math.max(idx - 1, 0);
+      $current.removeClass('current');
+      $current = $rows.eq(idx).addClass('current');
+      $current[0].scrollIntoView({block: 'nearest'});
+      break;
+    case 'x':
+    case 'X':
+      var box = $current.find('input[name=key]:enabled')[0];
+      if (box) {
+        box.checked = !box.checked;
+        checkRange(box, e.shiftKey);
+      }
+      break;
+    case 'o':
+    case 'Enter':
+      var href = $current.find('a').first().attr('href');
+      if (href) {
+        window.location = href;
+      }
+      break;
+    case '/':
+      $('.filters input[name=annotation]').focus();
+      break;
+    default:
+      return;
+    }
+    e.preventDefault();
   });
 
   $(document).on("click", "[data-confirm]", function() {
@@ -37,6 +103,17 @@ $(function() {
     $($(this).attr('data-target')).toggle();
   });
 
+  // check the new job's arguments are a JSON array as they're typed
+  $(document).on("input", ".new-job textarea[name=args]", function() {
+    var valid = false;
+    try {
+      valid = Array.isArray(JSON.parse(this.value));
+    } catch (e) {
+    }
+    $(this).closest('.form-group').toggleClass('has-error', !valid);
+    $(this).closest('form').find('button[type=submit]').prop('disabled', !valid);
+  });
+
   $(document).on("change","#faktory_locale", function() {
     document.cookie = "faktory_locale="+$("#faktory_locale").find(":selected").text();
     location.reload();
@@ -44,6 +121,29 @@ $(function() {
   updateFuzzyTimes($('body').data('locale'));
 });
 
+var lastChecked = null;
+
+function checkRange(box, range) {
+  var $boxes = $(box).closest('table').find('input[name=key]');
+  if (range && lastChecked && $boxes.index(lastChecked) >= 0) {
+    var from = $boxes.index(lastChecked);
+    var to = $boxes.index(box);
+    $boxes.slice(Math.min(from, to), Math.max(from, to) + 1).prop('checked', box.checked);
+  }
+  lastChecked = box;
+}
+
+// selectMatching replaces the checked rows with every job matching
+// the filters, on all pages
+function selectMatching($form, on) {
+  $('input[name=key][type=hidden]', $form).remove();
+  $('input[name=key]', $form).prop('disabled', on);
+  $('.select-matching', $form).toggleClass('matching', on);
+  if (on) {
+    $form.append('<input type="hidden" name="key" value="matching"/>');
+  }
+}
+
 function updateFuzzyTimes(locale) {
   var parts = locale.split('-');
   if (typeof parts[1] !== 'undefined') {
@@ -157,6 +157,18 @@ var historyGraph = function() {
 
   graph.render();
 
+  var markers = $("#history").data("markers") || [];
+  if (markers.length > 0) {
+    var annotator = new Rickshaw.Graph.Annotate({
+      graph: graph,
+      element: document.getElementById("history-timeline")
+    });
+    markers.forEach(function(marker) {
+      annotator.add(marker.x, marker.kind + ": " + marker.label);
+    });
+    annotator.update();
+  }
+
   var legend = document.querySelector('#history-legend');
   var Hover = Rickshaw.Class.create(Rickshaw.Graph.HoverDetail, {
     render: function(args) {
@@ -296,6 +308,7 @@ $(function(){
 var resetGraphs = function() {
   document.getElementById('realtime').innerHTML = '';
   document.getElementById('history').innerHTML = '';
+  document.getElementById('history-timeline').innerHTML = '';
 };
 
 // Resize graphs after resizing window
@@ -4,13 +4,15 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"crypto/subtle"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/contribsys/faktory/client"
 	"github.com/contribsys/faktory/server"
 	"github.com/contribsys/faktory/util"
 	"github.com/justinas/nosurf"
@@ -74,6 +76,11 @@ type WebUI struct {
 	Options Options
 	Server  *server.Server
 	Mux     *http.ServeMux
+
+	mu        sync.RWMutex
+	webhooks  map[string]*webhook
+	users     map[string]*webUser
+	approvals *approvalPolicy
 }
 
 type Options struct {
@@ -104,6 +111,7 @@ func newWeb(s *server.Server, opts Options) *WebUI {
 	ui.Mux.HandleFunc("/", Log(ui, GetOnly(indexHandler)))
 	ui.Mux.HandleFunc("/queues", Log(ui, queuesHandler))
 	ui.Mux.HandleFunc("/queues/", Log(ui, queueHandler))
+	ui.Mux.HandleFunc("/dashboards/", Log(ui, GetOnly(queueDashboardHandler)))
 	ui.Mux.HandleFunc("/retries", Log(ui, retriesHandler))
 	ui.Mux.HandleFunc("/retries/", Log(ui, retryHandler))
 	ui.Mux.HandleFunc("/scheduled", Log(ui, scheduledHandler))
@@ -111,7 +119,28 @@ func newWeb(s *server.Server, opts Options) *WebUI {
 	ui.Mux.HandleFunc("/morgue", Log(ui, morgueHandler))
 	ui.Mux.HandleFunc("/morgue/", Log(ui, deadHandler))
 	ui.Mux.HandleFunc("/busy", Log(ui, busyHandler))
-	ui.Mux.HandleFunc("/debug", Log(ui, debugHandler))
+	ui.Mux.HandleFunc("/filters", Log(ui, PostOnly(filtersHandler)))
+	ui.Mux.HandleFunc("/samples", Log(ui, GetOnly(samplesHandler)))
+	ui.Mux.HandleFunc("/samples/", Log(ui, GetOnly(sampleHandler)))
+	ui.Mux.HandleFunc("/debug", Log(ui, AdminOnly(debugHandler)))
+	ui.Mux.HandleFunc("/jobs/new", Log(ui, AdminOnly(newJobHandler)))
+	ui.Mux.HandleFunc("/templates", Log(ui, AdminOnly(templatesHandler)))
+	ui.Mux.HandleFunc("/templates/", Log(ui, AdminOnly(templateHandler)))
+	ui.Mux.HandleFunc("/approvals", Log(ui, approvalsHandler))
+	ui.Mux.HandleFunc("/duplicates", Log(ui, AdminOnly(GetOnly(duplicatesHandler))))
+	ui.Mux.HandleFunc("/protocol", Log(ui, GetOnly(protocolHandler)))
+
+	// the API is read-only so it skips CSRF protection, which
+	// would reject tools POSTing JSON
+	ui.Mux.HandleFunc("/graphql", setup(ui, AdminOnly(graphqlHandler), false))
+
+	// webhooks are authenticated by signature, not password
+	ui.Mux.HandleFunc("/webhooks/", webhookHandler(ui))
+	// workers authenticate with HELLO, not the password
+	ui.Mux.HandleFunc(client.WebSocketPath, websocketHandler(ui))
+	// orchestration probes skip the password, see health.go
+	ui.Mux.HandleFunc("/-/ready", readyHandler(ui))
+	ui.Mux.HandleFunc("/-/healthy", healthyHandler(ui))
 
 	return ui
 }
@@ -135,7 +164,23 @@ func (l *Lifecycle) opts(s *server.Server) Options {
 func (l *Lifecycle) Start(s *server.Server) error {
 	uiopts := l.opts(s)
 
+	hooks, err := parseWebhooks(s.Options.GlobalConfig["webhooks"])
+	if err != nil {
+		return err
+	}
+	users, err := parseUsers(s.Options.GlobalConfig["web"])
+	if err != nil {
+		return err
+	}
+	approvals, err := parseApprovals(s.Options.GlobalConfig["web"])
+	if err != nil {
+		return err
+	}
+
 	l.WebUI = newWeb(s, uiopts)
+	l.WebUI.setWebhooks(hooks)
+	l.WebUI.setUsers(users)
+	l.WebUI.setApprovals(approvals)
 	closer, err := l.WebUI.Run()
 	if err != nil {
 		return err
@@ -147,15 +192,42 @@ func (l *Lifecycle) Start(s *server.Server) error {
 func (l *Lifecycle) Reload(s *server.Server) error {
 	uiopts := l.opts(s)
 
+	hooks, err := parseWebhooks(s.Options.GlobalConfig["webhooks"])
+	if err != nil {
+		return err
+	}
+	users, err := parseUsers(s.Options.GlobalConfig["web"])
+	if err != nil {
+		return err
+	}
+	approvals, err := parseApprovals(s.Options.GlobalConfig["web"])
+	if err != nil {
+		return err
+	}
+	l.WebUI.setWebhooks(hooks)
+	l.WebUI.setUsers(users)
+	l.WebUI.setApprovals(approvals)
+
 	if uiopts != l.WebUI.Options {
 		util.Infof("Reloading web interface")
-		l.closer()
+		previous := l.WebUI.Options
+		rebinding := uiopts.Binding != previous.Binding
+		if !rebinding && l.closer != nil {
+			l.closer()
+			l.closer = nil
+		}
 
+		// a new binding is opened before the previous one is closed
+		// so the previous one is kept if it can't be opened
 		l.WebUI.Options = uiopts
 		closer, err := l.WebUI.Run()
 		if err != nil {
+			l.WebUI.Options = previous
 			return err
 		}
+		if rebinding && l.closer != nil {
+			l.closer()
+		}
 		l.closer = closer
 		return nil
 	}
@@ -186,13 +258,18 @@ func (ui *WebUI) Run() (func(), error) {
 		Handler:        ui.Mux,
 	}
 
+	listener, err := net.Listen("tcp", ui.Options.Binding)
+	if err != nil {
+		return nil, err
+	}
 	go func() {
-		err := s.ListenAndServe()
+		err := s.Serve(listener)
 		if err != http.ErrServerClosed {
 			util.Error(fmt.Sprintf("%s server crashed", ui.Options.Binding), err)
 		}
 	}()
 	util.Infof("Web server now listening at %s", ui.Options.Binding)
+	// requests in progress are allowed to finish
 	return func() { s.Shutdown(context.Background()) }, nil
 }
 
@@ -328,24 +405,19 @@ func setup(ui *WebUI, pass http.HandlerFunc, debug bool) http.HandlerFunc {
 			util.Infof("%s %s %v", r.Method, r.RequestURI, time.Since(start))
 		}
 	}
-	if ui.Options.Password != "" {
-		return basicAuth(ui.Options.Password, genericSetup)
-	}
-	return genericSetup
+	return basicAuth(ui, genericSetup)
 }
 
-func basicAuth(pwd string, pass http.HandlerFunc) http.HandlerFunc {
+func basicAuth(ui *WebUI, pass http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		_, password, ok := r.BasicAuth()
-		if !ok {
+		access, err := ui.login(r)
+		if err != nil {
 			w.Header().Set("WWW-Authenticate", `Basic realm="Faktory"`)
-			http.Error(w, "Authorization required", http.StatusUnauthorized)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
 			return
 		}
-		if subtle.ConstantTimeCompare([]byte(password), []byte(pwd)) != 1 {
-			w.Header().Set("WWW-Authenticate", `Basic realm="Faktory"`)
-			http.Error(w, "Authorization failed", http.StatusUnauthorized)
-			return
+		if access != nil {
+			r = r.WithContext(context.WithValue(r.Context(), accessKey{}, access))
 		}
 		pass(w, r)
 	}
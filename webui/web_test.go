@@ -7,6 +7,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/contribsys/faktory/client"
 	"github.com/contribsys/faktory/server"
 	"github.com/contribsys/faktory/storage"
 	"github.com/contribsys/faktory/util"
@@ -35,6 +36,22 @@ func TestLiveServer(t *testing.T) {
 			assert.True(t, strings.Contains(w.Body.String(), "Disk Usage"), w.Body.String())
 		})
 
+		t.Run("Probes", func(t *testing.T) {
+			// no password, orchestration doesn't have it
+			req := httptest.NewRequest("GET", "http://localhost:7420/-/ready", nil)
+			w := httptest.NewRecorder()
+			ui.Mux.ServeHTTP(w, req)
+			assert.Equal(t, 200, w.Code)
+			assert.Contains(t, w.Body.String(), "listeners: ok")
+			assert.Contains(t, w.Body.String(), "redis: ok")
+
+			req = httptest.NewRequest("GET", "http://localhost:7420/-/healthy", nil)
+			w = httptest.NewRecorder()
+			ui.Mux.ServeHTTP(w, req)
+			assert.Equal(t, 200, w.Code)
+			assert.Equal(t, "Faktory is Healthy.\n", w.Body.String())
+		})
+
 		t.Run("ComputeLocale", func(t *testing.T) {
 			lang := localeFromHeader("")
 			assert.Equal(t, "en", lang)
@@ -71,6 +88,25 @@ func TestLiveServer(t *testing.T) {
 			lang = localeFromHeader("*")
 			assert.Equal(t, "en", lang)
 		})
+
+		t.Run("WebSocket", func(t *testing.T) {
+			ts := httptest.NewServer(ui.Mux)
+			defer ts.Close()
+
+			srv := client.DefaultServer()
+			srv.Network = "ws"
+			srv.Address = strings.TrimPrefix(ts.URL, "http://")
+			cl, err := srv.Open()
+			assert.NoError(t, err)
+			defer cl.Close()
+
+			job := client.NewJob("WebSocketJob", 1)
+			assert.NoError(t, cl.Push(job))
+			fetched, err := cl.Fetch(job.Queue)
+			assert.NoError(t, err)
+			assert.Equal(t, job.Jid, fetched.Jid)
+			assert.NoError(t, cl.Ack(job.Jid))
+		})
 	})
 }
 
@@ -0,0 +1,337 @@
+package webui
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/server"
+	"github.com/contribsys/faktory/util"
+)
+
+/*
+ * Webhooks allow third party services to create jobs directly,
+ * without a web service in between to convert the payload.
+ *
+ * [webhooks.stripe]
+ * provider = "stripe"               # github, stripe or generic
+ * secret = "whsec_..."              # HMAC secret shared with the provider
+ * jobtype = "StripeEvent"
+ * queue = "billing"                 # optional, default queue otherwise
+ * args = ["{{.Event}}", "{{.Payload.data.object.id}}"]
+ *
+ * The provider POSTs to /webhooks/stripe.  Each arg is a Go template
+ * rendered with the provider's event type and the decoded JSON payload.
+ * Webhook requests do not use the Web UI password, every request must
+ * be signed by the provider instead.  Generic webhooks send the event
+ * type in X-Faktory-Event, the Unix time in X-Faktory-Timestamp and in
+ * X-Faktory-Signature "sha256=" and the hex HMAC-SHA256 of the
+ * timestamp, a "." and the body.
+ *
+ * Stripe and generic requests signed more than 5 minutes from now are
+ * refused, as are repeats of a signature, so captured requests can't
+ * be replayed.  GitHub doesn't sign a timestamp.  The jobs are pushed
+ * as PUSH would, subject to [faktory] max_job_size, load shedding and
+ * debounce.
+ */
+
+const (
+	// The Custom attribute which records the webhook that created a job
+	WebhookAttribute = "webhook"
+
+	maxWebhookSize = 1024 * 1024
+
+	// how far the signed timestamp may be from now, as Stripe's libraries allow
+	webhookTolerance = 5 * time.Minute
+)
+
+type webhook struct {
+	name     string
+	provider string
+	secret   []byte
+	jobtype  string
+	queue    string
+	args     []*template.Template
+}
+
+type webhookData struct {
+	Event   string
+	Payload interface{}
+}
+
+func parseWebhooks(config interface{}) (map[string]*webhook, error) {
+	hooks := map[string]*webhook{}
+	if config == nil {
+		return hooks, nil
+	}
+
+	mapp, ok := config.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Invalid webhooks configuration")
+	}
+
+	for name, val := range mapp {
+		rule, ok := val.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Invalid configuration for webhook %s", name)
+		}
+		hook, err := parseWebhook(name, rule)
+		if err != nil {
+			return nil, err
+		}
+		hooks[name] = hook
+	}
+	return hooks, nil
+}
+
+func parseWebhook(name string, rule map[string]interface{}) (*webhook, error) {
+	str := func(key string) string {
+		s, _ := rule[key].(string)
+		return s
+	}
+
+	hook := &webhook{
+		name:     name,
+		provider: str("provider"),
+		secret:   []byte(str("secret")),
+		jobtype:  str("jobtype"),
+		queue:    str("queue"),
+	}
+	if hook.provider == "" {
+		hook.provider = "generic"
+	}
+	switch hook.provider {
+	case "github", "stripe", "generic":
+	default:
+		return nil, fmt.Errorf("Webhook %s: unknown provider %s", name, hook.provider)
+	}
+	if len(hook.secret) == 0 {
+		return nil, fmt.Errorf("Webhook %s: secret is required", name)
+	}
+	if hook.jobtype == "" {
+		return nil, fmt.Errorf("Webhook %s: jobtype is required", name)
+	}
+
+	args, _ := rule["args"].([]interface{})
+	for idx, arg := range args {
+		s, ok := arg.(string)
+		if !ok {
+			return nil, fmt.Errorf("Webhook %s: args must be strings", name)
+		}
+		tmpl, err := template.New(fmt.Sprintf("%s/%d", name, idx)).Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("Webhook %s: %v", name, err)
+		}
+		hook.args = append(hook.args, tmpl)
+	}
+	return hook, nil
+}
+
+func hmacHex(secret []byte, parts ...[]byte) string {
+	mac := hmac.New(sha256.New, secret)
+	for _, p := range parts {
+		mac.Write(p)
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func signatureMatches(expected, given string) bool {
+	return hmac.Equal([]byte(expected), []byte(given))
+}
+
+// checkTimestamp refuses signatures made too long ago or in the future
+func checkTimestamp(ts string, now time.Time) error {
+	secs, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("Invalid signature timestamp")
+	}
+	age := now.Sub(time.Unix(secs, 0))
+	if age < 0 {
+		age = -age
+	}
+	if age > webhookTolerance {
+		return fmt.Errorf("Signature has expired")
+	}
+	return nil
+}
+
+// verify checks the request signature and returns the event type
+// and the signature which must not be seen again, if the provider
+// signs a timestamp
+func (hook *webhook) verify(header http.Header, body []byte, now time.Time) (string, string, error) {
+	switch hook.provider {
+	case "github":
+		sig := strings.TrimPrefix(header.Get("X-Hub-Signature-256"), "sha256=")
+		if !signatureMatches(hmacHex(hook.secret, body), sig) {
+			return "", "", fmt.Errorf("Invalid signature")
+		}
+		return header.Get("X-GitHub-Event"), "", nil
+	case "stripe":
+		var ts string
+		sigs := []string{}
+		for _, pair := range strings.Split(header.Get("Stripe-Signature"), ",") {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			switch kv[0] {
+			case "t":
+				ts = kv[1]
+			case "v1":
+				sigs = append(sigs, kv[1])
+			}
+		}
+		err := checkTimestamp(ts, now)
+		if err != nil {
+			return "", "", err
+		}
+		expected := hmacHex(hook.secret, []byte(ts), []byte("."), body)
+		for _, sig := range sigs {
+			if signatureMatches(expected, sig) {
+				var event struct {
+					Type string `json:"type"`
+				}
+				json.Unmarshal(body, &event)
+				return event.Type, expected, nil
+			}
+		}
+		return "", "", fmt.Errorf("Invalid signature")
+	default:
+		ts := header.Get("X-Faktory-Timestamp")
+		err := checkTimestamp(ts, now)
+		if err != nil {
+			return "", "", err
+		}
+		expected := hmacHex(hook.secret, []byte(ts), []byte("."), body)
+		sig := strings.TrimPrefix(header.Get("X-Faktory-Signature"), "sha256=")
+		if !signatureMatches(expected, sig) {
+			return "", "", fmt.Errorf("Invalid signature")
+		}
+		return header.Get("X-Faktory-Event"), expected, nil
+	}
+}
+
+// replayed records the signature, returning true if it was
+// seen within the tolerance either side of now
+func (ui *WebUI) replayed(name string, sig string) (bool, error) {
+	fresh, err := ui.Server.Manager().Redis().SetNX("webhook-signature:"+name+":"+sig, 1, 2*webhookTolerance).Result()
+	return !fresh, err
+}
+
+func (hook *webhook) job(event string, body []byte) (*client.Job, error) {
+	var payload interface{}
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		return nil, err
+	}
+
+	data := webhookData{Event: event, Payload: payload}
+	args := make([]interface{}, len(hook.args))
+	for idx, tmpl := range hook.args {
+		var buf bytes.Buffer
+		err := tmpl.Execute(&buf, data)
+		if err != nil {
+			return nil, err
+		}
+		args[idx] = buf.String()
+	}
+
+	job := client.NewJob(hook.jobtype, args...)
+	if hook.queue != "" {
+		job.Queue = hook.queue
+	}
+	job.SetCustom(WebhookAttribute, hook.name)
+	return job, nil
+}
+
+func (ui *WebUI) setWebhooks(hooks map[string]*webhook) {
+	ui.mu.Lock()
+	ui.webhooks = hooks
+	ui.mu.Unlock()
+}
+
+func (ui *WebUI) webhook(name string) *webhook {
+	ui.mu.RLock()
+	defer ui.mu.RUnlock()
+	return ui.webhooks[name]
+}
+
+func webhookHandler(ui *WebUI) http.HandlerFunc {
+	return PostOnly(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		name := strings.TrimPrefix(r.URL.Path, "/webhooks/")
+		hook := ui.webhook(name)
+		if hook == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		event, sig, err := hook.verify(r.Header, body, time.Now())
+		if err != nil {
+			util.Warnf("Webhook %s: %v", name, err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if sig != "" {
+			replayed, err := ui.replayed(name, sig)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if replayed {
+				util.Warnf("Webhook %s: replayed request", name)
+				http.Error(w, "Replayed request", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		job, err := hook.job(event, body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, err := json.Marshal(job)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = ui.Server.Push(job, len(data))
+		if err != nil {
+			http.Error(w, err.Error(), pushStatus(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"jid":"%s"}`, job.Jid)
+		util.Infof("%s %s %v", r.Method, r.RequestURI, time.Since(start))
+	})
+}
+
+// pushStatus is the HTTP status for the reason a push was refused
+func pushStatus(err error) int {
+	switch server.ErrorCode(err) {
+	case "TOOBIG":
+		return http.StatusRequestEntityTooLarge
+	case "BUSY", "UNAVAILABLE":
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
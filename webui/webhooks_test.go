@@ -0,0 +1,187 @@
+package webui
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/contribsys/faktory/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhooks(t *testing.T) {
+	config := map[string]interface{}{
+		"github": map[string]interface{}{
+			"provider": "github",
+			"secret":   "s3cr3t",
+			"jobtype":  "GithubPush",
+			"queue":    "hooks",
+			"args":     []interface{}{"{{.Event}}", "{{.Payload.repository.full_name}}"},
+		},
+		"stripe": map[string]interface{}{
+			"provider": "stripe",
+			"secret":   "whsec_123",
+			"jobtype":  "StripeEvent",
+			"args":     []interface{}{"{{.Event}}", "{{.Payload.data.object.id}}"},
+		},
+		"generic": map[string]interface{}{
+			"secret":  "generic",
+			"jobtype": "GenericEvent",
+		},
+	}
+
+	hooks, err := parseWebhooks(config)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(hooks))
+	assert.Equal(t, "generic", hooks["generic"].provider)
+
+	hooks, err = parseWebhooks(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(hooks))
+
+	_, err = parseWebhooks(map[string]interface{}{
+		"bad": map[string]interface{}{"provider": "paypal", "secret": "x", "jobtype": "X"},
+	})
+	assert.Error(t, err)
+	_, err = parseWebhooks(map[string]interface{}{
+		"bad": map[string]interface{}{"jobtype": "X"},
+	})
+	assert.Error(t, err)
+	_, err = parseWebhooks(map[string]interface{}{
+		"bad": map[string]interface{}{"secret": "x", "jobtype": "X", "args": []interface{}{"{{.Event"}},
+	})
+	assert.Error(t, err)
+
+	t.Run("GitHub", func(t *testing.T) {
+		hook, err := parseWebhook("github", config["github"].(map[string]interface{}))
+		assert.NoError(t, err)
+
+		body := []byte(`{"repository":{"full_name":"contribsys/faktory"}}`)
+		header := http.Header{}
+		header.Set("X-GitHub-Event", "push")
+		_, _, err = hook.verify(header, body, time.Now())
+		assert.Error(t, err)
+
+		header.Set("X-Hub-Signature-256", "sha256="+hmacHex([]byte("s3cr3t"), body))
+		event, nonce, err := hook.verify(header, body, time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, "push", event)
+		assert.Equal(t, "", nonce)
+
+		job, err := hook.job(event, body)
+		assert.NoError(t, err)
+		assert.Equal(t, "GithubPush", job.Type)
+		assert.Equal(t, "hooks", job.Queue)
+		assert.Equal(t, []interface{}{"push", "contribsys/faktory"}, job.Args)
+		name, ok := job.GetCustom(WebhookAttribute)
+		assert.True(t, ok)
+		assert.Equal(t, "github", name)
+	})
+
+	t.Run("Stripe", func(t *testing.T) {
+		hook, err := parseWebhook("stripe", config["stripe"].(map[string]interface{}))
+		assert.NoError(t, err)
+
+		body := []byte(`{"type":"invoice.paid","data":{"object":{"id":"in_123"}}}`)
+		now := time.Now()
+		ts := fmt.Sprintf("%d", now.Unix())
+		sig := hmacHex([]byte("whsec_123"), []byte(ts), []byte("."), body)
+
+		header := http.Header{}
+		header.Set("Stripe-Signature", fmt.Sprintf("t=%s,v1=bogus,v1=%s", ts, sig))
+		event, nonce, err := hook.verify(header, body, now)
+		assert.NoError(t, err)
+		assert.Equal(t, "invoice.paid", event)
+		assert.Equal(t, sig, nonce)
+
+		_, _, err = hook.verify(header, body, now.Add(10*time.Minute))
+		assert.EqualError(t, err, "Signature has expired")
+		// signed in the future
+		_, _, err = hook.verify(header, body, now.Add(-10*time.Minute))
+		assert.EqualError(t, err, "Signature has expired")
+
+		header.Set("Stripe-Signature", fmt.Sprintf("t=%s,v1=bogus", ts))
+		_, _, err = hook.verify(header, body, now)
+		assert.Error(t, err)
+
+		job, err := hook.job(event, body)
+		assert.NoError(t, err)
+		assert.Equal(t, "default", job.Queue)
+		assert.Equal(t, []interface{}{"invoice.paid", "in_123"}, job.Args)
+	})
+
+	t.Run("Generic", func(t *testing.T) {
+		hook, err := parseWebhook("generic", config["generic"].(map[string]interface{}))
+		assert.NoError(t, err)
+
+		body := []byte(`{}`)
+		now := time.Now()
+		ts := fmt.Sprintf("%d", now.Unix())
+		header := http.Header{}
+		header.Set("X-Faktory-Event", "ping")
+		header.Set("X-Faktory-Signature", "sha256="+hmacHex([]byte("generic"), body))
+		_, _, err = hook.verify(header, body, now)
+		assert.EqualError(t, err, "Invalid signature timestamp")
+
+		// the timestamp is signed
+		header.Set("X-Faktory-Timestamp", ts)
+		_, _, err = hook.verify(header, body, now)
+		assert.EqualError(t, err, "Invalid signature")
+		sig := hmacHex([]byte("generic"), []byte(ts), []byte("."), body)
+		header.Set("X-Faktory-Signature", "sha256="+sig)
+		event, nonce, err := hook.verify(header, body, now)
+		assert.NoError(t, err)
+		assert.Equal(t, "ping", event)
+		assert.Equal(t, sig, nonce)
+		_, _, err = hook.verify(header, body, now.Add(10*time.Minute))
+		assert.EqualError(t, err, "Signature has expired")
+
+		_, err = hook.job("", []byte("not json"))
+		assert.Error(t, err)
+	})
+}
+
+func TestWebhookPush(t *testing.T) {
+	bootRuntime(t, "webhooks", func(ui *WebUI, s *server.Server, t *testing.T) {
+		s.Options.GlobalConfig = map[string]interface{}{"faktory": map[string]interface{}{"max_job_size": 200}}
+		hooks, err := parseWebhooks(map[string]interface{}{
+			"generic": map[string]interface{}{
+				"secret":  "generic",
+				"jobtype": "GenericEvent",
+				"args":    []interface{}{"{{.Payload.name}}"},
+			},
+		})
+		assert.NoError(t, err)
+		ui.setWebhooks(hooks)
+
+		post := func(body string) *httptest.ResponseRecorder {
+			ts := fmt.Sprintf("%d", time.Now().Unix())
+			req := httptest.NewRequest("POST", "/webhooks/generic", strings.NewReader(body))
+			req.Header.Set("X-Faktory-Timestamp", ts)
+			req.Header.Set("X-Faktory-Signature", "sha256="+hmacHex([]byte("generic"), []byte(ts), []byte("."), []byte(body)))
+			w := httptest.NewRecorder()
+			webhookHandler(ui)(w, req)
+			return w
+		}
+
+		body := `{"name":"mike"}`
+		w := post(body)
+		assert.Equal(t, http.StatusOK, w.Code)
+		q, err := s.Store().GetQueue("default")
+		assert.NoError(t, err)
+		assert.EqualValues(t, 1, q.Size())
+
+		// the same request again
+		w = post(body)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.EqualValues(t, 1, q.Size())
+
+		// pushed as PUSH would be
+		w = post(`{"name":"` + strings.Repeat("x", 200) + `"}`)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+		assert.EqualValues(t, 1, q.Size())
+	})
+}
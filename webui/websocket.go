@@ -0,0 +1,26 @@
+package webui
+
+import (
+	"net/http"
+
+	"github.com/contribsys/faktory/client"
+	"github.com/contribsys/faktory/util"
+)
+
+/*
+ * Workers which can only make HTTP requests connect to /ws with the
+ * ws or wss scheme and speak the protocol over the WebSocket, see
+ * client/websocket.go.  The Web UI password doesn't apply, workers
+ * authenticate with HELLO as they would on the command port.  Put
+ * the Web UI behind a proxy terminating TLS to offer wss.
+ */
+func websocketHandler(ui *WebUI) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, err := client.AcceptWebSocket(w, r)
+		if err != nil {
+			util.Debugf("Refused WebSocket from %s: %v", r.RemoteAddr, err)
+			return
+		}
+		ui.Server.ServeConn(conn)
+	}
+}